@@ -0,0 +1,127 @@
+// The go_spider runs a crawl declared by a config file of spider.Config and an extraction spec of
+// page_processer.ExtractRules, and writes the items to json lines or csv, so simple crawls need no Go code.
+//
+//	go_spider -config crawl.yaml -spec spec.yaml -output items.csv
+//
+// The spec is a YAML or JSON file like:
+//
+//	fields:
+//	    - name: title
+//	      selector: h1
+//	    - name: image
+//	      selector: img.main
+//	      attr: src
+//	    - name: price
+//	      selector: .price
+//	      transform: [currency]
+//	      required: true
+//	    - name: tags
+//	      selector: .tag
+//	      list: true
+//	follow:
+//	    - selector: a.next
+//	    - selector: .items a
+//	      allow: ["/item/"]
+//	items: ["/item/"]
+//
+// Fields are also taken by "xpath" or by "jsonpath" of json pages, and "regex" and "transform" clean values.
+//
+// With -watch like "-watch 10s", crawl rules of the config and the spec are reloaded when their files are
+// changed while crawling; columns of csv output are the fields of the spec at start.
+package main
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "github.com/hu17889/go_spider/core/page_processer"
+    "github.com/hu17889/go_spider/core/spider"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+// The run runs the crawl of command line args, and writes items to stdout if output is not set.
+func run(args []string, stdout io.Writer) error {
+    flags := flag.NewFlagSet("go_spider", flag.ContinueOnError)
+    configPath := flags.String("config", "", "config file of the crawl: .yaml, .yml, .toml or .json")
+    specPath := flags.String("spec", "", "extraction spec file of fields and links to follow: .yaml or .json")
+    output := flags.String("output", "", "output file of items, stdout by default")
+    format := flags.String("format", "", "output format: json (json lines) or csv, by extension of output by default")
+    watch := flags.Duration("watch", 0, "interval of checking config and spec files for reloading, like 10s")
+    dashboard := flags.String("dashboard", "", "address of dashboard and control api, like 127.0.0.1:8080")
+    dashboardToken := flags.String("dashboard-token", os.Getenv("GO_SPIDER_DASHBOARD_TOKEN"),
+        "token of dashboard and control api, like ?token=<token> of the page; default is $GO_SPIDER_DASHBOARD_TOKEN")
+    if err := flags.Parse(args); err != nil {
+        return err
+    }
+    if *configPath == "" || *specPath == "" {
+        flags.Usage()
+        return errors.New("config and spec are required")
+    }
+
+    c, err := spider.LoadConfig(*configPath)
+    if err != nil {
+        return err
+    }
+    p, err := page_processer.LoadRules(*specPath)
+    if err != nil {
+        return err
+    }
+    if *format == "" {
+        *format = "json"
+        if strings.ToLower(filepath.Ext(*output)) == ".csv" {
+            *format = "csv"
+        }
+    }
+
+    w := stdout
+    if *output != "" {
+        f, err := os.Create(*output)
+        if err != nil {
+            return err
+        }
+        defer f.Close()
+        w = f
+    }
+    var out outputPipeline
+    switch *format {
+    case "json":
+        out = newJsonPipeline(w)
+    case "csv":
+        out = newCsvPipeline(w, p.GetFields())
+    default:
+        return errors.New("output format is not supported : " + *format)
+    }
+
+    sp, err := c.NewSpider(p)
+    if err != nil {
+        return err
+    }
+    defer sp.StopOnSignal()()
+    if *dashboard != "" {
+        if err = sp.SetDashboardToken(*dashboardToken).ServeDashboard(*dashboard); err != nil {
+            return err
+        }
+    }
+    if *watch > 0 {
+        defer sp.WatchConfig(*configPath, *watch)()
+        defer spider.WatchFile(*specPath, *watch, func() error {
+            p, err := page_processer.LoadRules(*specPath)
+            if err == nil {
+                sp.SetPageProcesser(p)
+            }
+            return err
+        })()
+    }
+    sp.AddPipeline(out).Run()
+    return out.Err()
+}
+
+func main() {
+    if err := run(os.Args[1:], os.Stdout); err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+)
+
+func TestRun(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/":
+            w.Write([]byte(`<div class="items"><a href="/item/1">1</a><a href="/about">about</a></div>` +
+                `<a class="next" href="/page/2">next</a>`))
+        case "/page/2":
+            w.Write([]byte(`<div class="items"><a href="/item/2">2</a></div>`))
+        case "/item/1", "/item/2":
+            w.Write([]byte(`<h1> Item ` + r.URL.Path[6:] + ` </h1><span class="tag">a</span><span class="tag">b</span>` +
+                `<img class="main" src="/img.png">`))
+        default:
+            w.Write([]byte(`<h1>other</h1>`))
+        }
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    files := map[string]string{
+        "crawl.yaml": "taskname: cmd\nseeds: [\"" + ts.URL + "/\"]\nobey_robots: false\n",
+        "spec.yaml": `
+fields:
+    - name: title
+      selector: h1
+    - name: image
+      selector: img.main
+      attr: src
+    - name: tags
+      selector: .tag
+      list: true
+follow:
+    - selector: a.next
+    - selector: .items a
+      allow: ["/item/"]
+items: ["/item/"]
+`,
+    }
+    for name, content := range files {
+        if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    config, specPath := filepath.Join(dir, "crawl.yaml"), filepath.Join(dir, "spec.yaml")
+
+    var stdout bytes.Buffer
+    if err := run([]string{"-config", config, "-spec", specPath}, &stdout); err != nil {
+        t.Fatal(err)
+    }
+    var titles []string
+    for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+        var item map[string]interface{}
+        if err := json.Unmarshal([]byte(line), &item); err != nil {
+            t.Fatal(err)
+        }
+        if item["image"] != "/img.png" || len(item["tags"].([]interface{})) != 2 || item["url"] == "" {
+            t.Errorf("wrong item: %v", item)
+        }
+        titles = append(titles, item["title"].(string))
+    }
+    sort.Strings(titles)
+    if strings.Join(titles, ",") != "Item 1,Item 2" {
+        t.Errorf("only item pages should be written: %v", titles)
+    }
+
+    output := filepath.Join(dir, "items.csv")
+    if err := run([]string{"-config", config, "-spec", specPath, "-output", output}, &stdout); err != nil {
+        t.Fatal(err)
+    }
+    f, err := os.Open(output)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    records, err := csv.NewReader(f).ReadAll()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(records) != 3 || strings.Join(records[0], ",") != "url,title,image,tags" || records[1][3] != `["a","b"]` {
+        t.Errorf("wrong csv: %v", records)
+    }
+
+    if err := run([]string{"-config", config}, &stdout); err == nil {
+        t.Error("spec should be required")
+    }
+}
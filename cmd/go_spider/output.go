@@ -0,0 +1,98 @@
+package main
+
+import (
+    "encoding/csv"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "io"
+    "sync"
+)
+
+// The outputPipeline writes items, and returns the first write error by Err.
+type outputPipeline interface {
+    pipeline.FlushPipeline
+    Err() error
+}
+
+// The jsonPipeline writes each page as a json object in a line, with its url in key "url".
+type jsonPipeline struct {
+    locker sync.Mutex
+    enc    *json.Encoder
+    err    error
+}
+
+func newJsonPipeline(w io.Writer) *jsonPipeline {
+    return &jsonPipeline{enc: json.NewEncoder(w)}
+}
+
+func (this *jsonPipeline) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    values := items.GetValues()
+    values["url"] = items.GetRequest().GetUrl()
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if err := this.enc.Encode(values); err != nil && this.err == nil {
+        this.err = err
+    }
+}
+
+func (this *jsonPipeline) Flush() {}
+
+func (this *jsonPipeline) Err() error {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.err
+}
+
+// The csvPipeline writes each page as a csv record of its url and the fields. List fields are written as json.
+type csvPipeline struct {
+    locker sync.Mutex
+    w      *csv.Writer
+    fields []string
+    header bool
+    err    error
+}
+
+func newCsvPipeline(w io.Writer, fields []string) *csvPipeline {
+    return &csvPipeline{w: csv.NewWriter(w), fields: fields}
+}
+
+func (this *csvPipeline) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    record := make([]string, 0, len(this.fields)+1)
+    record = append(record, items.GetRequest().GetUrl())
+    for _, name := range this.fields {
+        value, _ := items.GetItem(name)
+        record = append(record, value)
+    }
+
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if !this.header {
+        this.header = true
+        this.write(append([]string{"url"}, this.fields...))
+    }
+    this.write(record)
+}
+
+// The write writes the record and keeps the first error. It is called with locker held.
+func (this *csvPipeline) write(record []string) {
+    if err := this.w.Write(record); err != nil && this.err == nil {
+        this.err = err
+    }
+}
+
+func (this *csvPipeline) Flush() {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.w.Flush()
+    if err := this.w.Error(); err != nil && this.err == nil {
+        this.err = err
+    }
+}
+
+func (this *csvPipeline) Err() error {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.err
+}
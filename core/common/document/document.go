@@ -0,0 +1,153 @@
+// Package document extracts text and metadata of documents like PDF, DOCX, XLSX and PPTX, so their pages are
+// processed by text like html pages.
+package document
+
+import (
+    "archive/zip"
+    "bytes"
+    "errors"
+    "mime"
+    "path"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Document is text and metadata extracted from a document.
+type Document struct {
+    // The Format is format of the document, like "pdf", "docx", "xlsx" or "pptx".
+    Format string
+
+    Title    string
+    Author   string
+    Subject  string
+    Keywords string
+
+    // The Created and Modified are zero if the document does not have them.
+    Created  time.Time
+    Modified time.Time
+
+    // The Pages is number of pages of pdf, sheets of xlsx or slides of pptx, or pages saved by the editor of
+    // docx.
+    Pages int
+
+    // The Text is text of the document, whose paragraphs, rows and pages are separated by line breaks.
+    Text string
+}
+
+// Extractor extracts Document of a body.
+type Extractor func(body []byte) (*Document, error)
+
+// The media types of documents extracted by default.
+const (
+    MediaTypePDF  = "application/pdf"
+    MediaTypeDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+    MediaTypeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+    MediaTypePPTX = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+)
+
+var (
+    locker     sync.RWMutex
+    extractors = map[string]Extractor{
+        MediaTypePDF:  ExtractPDF,
+        MediaTypeDOCX: ExtractDOCX,
+        MediaTypeXLSX: ExtractXLSX,
+        MediaTypePPTX: ExtractPPTX,
+    }
+    extensions = map[string]string{
+        ".pdf": MediaTypePDF, ".docx": MediaTypeDOCX, ".xlsx": MediaTypeXLSX, ".pptx": MediaTypePPTX,
+    }
+)
+
+// Register sets Extractor of documents of the media type, like a converter of "application/msword" by an
+// external tool. Nil extractor removes the media type.
+func Register(mediaType string, e Extractor) {
+    locker.Lock()
+    defer locker.Unlock()
+    if e == nil {
+        delete(extractors, mediaType)
+    } else {
+        extractors[mediaType] = e
+    }
+}
+
+// MediaType returns media type of the document by Content-Type, by its head if Content-Type is missing or
+// generic like "application/octet-stream", or by extension of its name like path of the url. It returns ""
+// if the body is not a document that has Extractor.
+func MediaType(contentType string, body []byte, name string) string {
+    locker.RLock()
+    defer locker.RUnlock()
+    mediatype, _, _ := mime.ParseMediaType(contentType)
+    if _, ok := extractors[mediatype]; ok {
+        return mediatype
+    }
+    if mediatype != "" && mediatype != "application/octet-stream" && mediatype != "binary/octet-stream" &&
+        mediatype != "application/zip" && mediatype != "application/x-zip-compressed" {
+        return ""
+    }
+    if sniffed := sniff(body); sniffed != "" {
+        if _, ok := extractors[sniffed]; ok {
+            return sniffed
+        }
+    }
+    if byExt, ok := extensions[strings.ToLower(path.Ext(name))]; ok && mediatype == "" {
+        if _, ok := extractors[byExt]; ok {
+            return byExt
+        }
+    }
+    return ""
+}
+
+// The sniff returns media type of pdf by its header, or of office documents by their main part in zip.
+func sniff(body []byte) string {
+    if bytes.HasPrefix(body, []byte("%PDF-")) {
+        return MediaTypePDF
+    }
+    if !bytes.HasPrefix(body, []byte("PK\x03\x04")) {
+        return ""
+    }
+    r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+    if err != nil {
+        return ""
+    }
+    for _, f := range r.File {
+        switch f.Name {
+        case "word/document.xml":
+            return MediaTypeDOCX
+        case "xl/workbook.xml":
+            return MediaTypeXLSX
+        case "ppt/presentation.xml":
+            return MediaTypePPTX
+        }
+    }
+    return ""
+}
+
+// Extract returns Document of the body by Extractor of its media type, see MediaType. It returns nil and
+// no error if the body is not a document.
+func Extract(contentType string, body []byte, name string) (*Document, error) {
+    mediatype := MediaType(contentType, body, name)
+    if mediatype == "" {
+        return nil, nil
+    }
+    locker.RLock()
+    e := extractors[mediatype]
+    locker.RUnlock()
+    doc, err := e(body)
+    if err != nil {
+        return nil, errors.New("document " + mediatype + " can not be extracted : " + err.Error())
+    }
+    return doc, nil
+}
+
+// The cleanText trims spaces of lines of the text and drops empty lines.
+func cleanText(text string) string {
+    lines := strings.Split(text, "\n")
+    kept := lines[:0]
+    for _, line := range lines {
+        if line = strings.TrimSpace(line); line != "" {
+            kept = append(kept, line)
+        }
+    }
+    return strings.Join(kept, "\n")
+}
@@ -0,0 +1,153 @@
+package document_test
+
+import (
+    "archive/zip"
+    "bytes"
+    "compress/zlib"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/document"
+    "testing"
+    "time"
+)
+
+// The buildPDF returns a pdf of one page whose content stream is compressed, with a latin font and a
+// composite font of ToUnicode cmap.
+func buildPDF() []byte {
+    var content bytes.Buffer
+    zw := zlib.NewWriter(&content)
+    zw.Write([]byte("BT /F1 12 Tf 72 720 Td (Hello) Tj ( world) Tj 0 -14 Td [(Sec) -250 (ond)] TJ ET\n" +
+        "BT /F2 12 Tf 72 600 Td <00010002> Tj ET"))
+    zw.Close()
+    cmap := "/CIDInit /ProcSet findresource begin 12 dict begin begincmap\n" +
+        "1 begincodespacerange <0000> <FFFF> endcodespacerange\n" +
+        "2 beginbfchar <0001> <4E2D> <0002> <6587> endbfchar\nendcmap end end"
+    objects := []string{
+        "<< /Type /Catalog /Pages 2 0 R >>",
+        "<< /Type /Pages /Kids [3 0 R] /Count 1 /Resources << /Font << /F1 5 0 R /F2 6 0 R >> >> >>",
+        "<< /Type /Page /Parent 2 0 R /Contents 4 0 R >>",
+        fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", content.Len(), content.String()),
+        "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+        "<< /Type /Font /Subtype /Type0 /BaseFont /Song /Encoding /Identity-H /ToUnicode 7 0 R >>",
+        fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(cmap), cmap),
+        "<< /Title <FEFF005200650070006f00720074> /Author (Hu Cong) /CreationDate (D:20240102150405+08'00') >>",
+    }
+    var b bytes.Buffer
+    b.WriteString("%PDF-1.4\n")
+    for i, o := range objects {
+        fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+    }
+    b.WriteString("trailer\n<< /Size 9 /Root 1 0 R /Info 8 0 R >>\n%%EOF\n")
+    return b.Bytes()
+}
+
+func buildZip(files map[string]string) []byte {
+    var b bytes.Buffer
+    zw := zip.NewWriter(&b)
+    for name, content := range files {
+        w, _ := zw.Create(name)
+        w.Write([]byte(content))
+    }
+    zw.Close()
+    return b.Bytes()
+}
+
+const coreXml = `<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties"
+ xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/">
+<dc:title>Plan</dc:title><dc:creator>Hu Cong</dc:creator>
+<dcterms:created>2024-01-02T15:04:05Z</dcterms:created></cp:coreProperties>`
+
+func TestExtractPDF(t *testing.T) {
+    doc, err := document.Extract("application/pdf", buildPDF(), "/a/report")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if doc == nil || doc.Format != "pdf" {
+        t.Fatalf("document is %#v", doc)
+    }
+    if doc.Text != "Hello world\nSec ond\n中文" {
+        t.Errorf("text is %q", doc.Text)
+    }
+    if doc.Title != "Report" || doc.Author != "Hu Cong" || doc.Pages != 1 {
+        t.Errorf("metadata is %#v", doc)
+    }
+    if want := time.Date(2024, 1, 2, 7, 4, 5, 0, time.UTC); !doc.Created.Equal(want) {
+        t.Errorf("created is %v, want %v", doc.Created, want)
+    }
+
+    // sniffed by content of generic Content-Type
+    if doc, err := document.Extract("application/octet-stream", buildPDF(), ""); err != nil || doc == nil {
+        t.Errorf("sniffed document is %v, %v", doc, err)
+    }
+    if doc, err := document.Extract("text/html", []byte("<html></html>"), "/a.pdf"); err != nil || doc != nil {
+        t.Errorf("html is document %v, %v", doc, err)
+    }
+    if _, err := document.Extract("application/pdf", []byte("%PDF-1.4 broken"), ""); err == nil {
+        t.Error("broken pdf is extracted")
+    }
+}
+
+func TestExtractOffice(t *testing.T) {
+    docx := buildZip(map[string]string{
+        "word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>First </w:t></w:r><w:r><w:t>paragraph</w:t></w:r></w:p>
+<w:tbl><w:tr><w:tc><w:p><w:r><w:t>a</w:t></w:r></w:p></w:tc></w:tr></w:tbl>
+<w:p><w:r><w:t>Last</w:t></w:r></w:p></w:body></w:document>`,
+        "docProps/core.xml": coreXml,
+        "docProps/app.xml":  `<Properties><Pages>2</Pages></Properties>`,
+    })
+    doc, err := document.Extract("", docx, "/plan.docx")
+    if err != nil || doc == nil {
+        t.Fatalf("docx is %v, %v", doc, err)
+    }
+    if doc.Format != "docx" || doc.Text != "First paragraph\na\nLast" {
+        t.Errorf("docx text is %q", doc.Text)
+    }
+    if doc.Title != "Plan" || doc.Author != "Hu Cong" || doc.Pages != 2 || doc.Created.Year() != 2024 {
+        t.Errorf("docx metadata is %#v", doc)
+    }
+
+    xlsx := buildZip(map[string]string{
+        "xl/workbook.xml": `<workbook xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="Prices" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+        "xl/_rels/workbook.xml.rels": `<Relationships><Relationship Id="rId1" Target="worksheets/sheet1.xml"/></Relationships>`,
+        "xl/sharedStrings.xml":       `<sst><si><t>apple</t></si><si><r><t>pe</t></r><r><t>ar</t></r></si></sst>`,
+        "xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+<row><c t="s"><v>0</v></c><c><v>1.5</v></c></row><row><c t="s"><v>1</v></c><c t="inlineStr"><is><t>2</t></is></c></row>
+</sheetData></worksheet>`,
+    })
+    doc, err = document.Extract(document.MediaTypeXLSX, xlsx, "")
+    if err != nil || doc == nil {
+        t.Fatalf("xlsx is %v, %v", doc, err)
+    }
+    if doc.Text != "Prices\napple\t1.5\npear\t2" || doc.Pages != 1 {
+        t.Errorf("xlsx is %#v", doc)
+    }
+
+    pptx := buildZip(map[string]string{
+        "ppt/presentation.xml":    `<p:presentation/>`,
+        "ppt/slides/slide10.xml":  `<p:sld><a:p><a:r><a:t>Ten</a:t></a:r></a:p></p:sld>`,
+        "ppt/slides/slide2.xml":   `<p:sld><a:p><a:r><a:t>Two</a:t></a:r></a:p></p:sld>`,
+        "ppt/slides/_rels/x.rels": `<Relationships/>`,
+    })
+    doc, err = document.Extract("application/zip", pptx, "")
+    if err != nil || doc == nil {
+        t.Fatalf("pptx is %v, %v", doc, err)
+    }
+    if doc.Text != "Two\nTen" || doc.Pages != 2 {
+        t.Errorf("pptx is %#v", doc)
+    }
+}
+
+func TestRegister(t *testing.T) {
+    document.Register("application/msword", func(body []byte) (*document.Document, error) {
+        return &document.Document{Format: "doc", Text: string(body)}, nil
+    })
+    defer document.Register("application/msword", nil)
+    if doc, err := document.Extract("application/msword", []byte("text"), ""); err != nil || doc.Text != "text" {
+        t.Errorf("registered document is %v, %v", doc, err)
+    }
+    document.Register("application/msword", nil)
+    if mt := document.MediaType("application/msword", nil, ""); mt != "" {
+        t.Errorf("removed media type is %s", mt)
+    }
+}
@@ -0,0 +1,440 @@
+package document
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/xml"
+    "errors"
+    "io"
+    "path"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The maxPartSize limits bytes of an xml part read from an office document, so a zip bomb is not inflated.
+const maxPartSize = 64 << 20
+
+// The officeDocument is zip package of an office document.
+type officeDocument struct {
+    files map[string]*zip.File
+}
+
+func openOffice(body []byte) (*officeDocument, error) {
+    r, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+    if err != nil {
+        return nil, err
+    }
+    files := make(map[string]*zip.File, len(r.File))
+    for _, f := range r.File {
+        files[f.Name] = f
+    }
+    return &officeDocument{files: files}, nil
+}
+
+// The part returns decoder of the xml part, or nil if the document does not have it.
+func (this *officeDocument) part(name string) (*xml.Decoder, func(), error) {
+    f, ok := this.files[name]
+    if !ok {
+        return nil, func() {}, nil
+    }
+    rc, err := f.Open()
+    if err != nil {
+        return nil, func() {}, err
+    }
+    return xml.NewDecoder(io.LimitReader(rc, maxPartSize)), func() { rc.Close() }, nil
+}
+
+// The metadata reads title, author and dates of docProps/core.xml, and pages of docProps/app.xml.
+func (this *officeDocument) metadata(doc *Document) error {
+    d, done, err := this.part("docProps/core.xml")
+    defer done()
+    if err != nil {
+        return err
+    }
+    if d != nil {
+        fields := map[string]*string{"title": &doc.Title, "creator": &doc.Author, "subject": &doc.Subject,
+            "keywords": &doc.Keywords}
+        var created, modified string
+        dates := map[string]*string{"created": &created, "modified": &modified}
+        if err := eachElementText(d, func(name string, text string) {
+            if field, ok := fields[name]; ok {
+                *field = strings.TrimSpace(text)
+            } else if date, ok := dates[name]; ok {
+                *date = strings.TrimSpace(text)
+            }
+        }); err != nil {
+            return err
+        }
+        doc.Created, _ = time.Parse(time.RFC3339, created)
+        doc.Modified, _ = time.Parse(time.RFC3339, modified)
+    }
+
+    d, done2, err := this.part("docProps/app.xml")
+    defer done2()
+    if err != nil || d == nil {
+        return err
+    }
+    return eachElementText(d, func(name string, text string) {
+        if name == "Pages" && doc.Pages == 0 {
+            doc.Pages, _ = strconv.Atoi(strings.TrimSpace(text))
+        }
+    })
+}
+
+// The eachElementText calls f with local name and text of each element without child elements.
+func eachElementText(d *xml.Decoder, f func(name string, text string)) error {
+    var name string
+    var text strings.Builder
+    for {
+        token, err := d.Token()
+        if err == io.EOF {
+            return nil
+        } else if err != nil {
+            return err
+        }
+        switch t := token.(type) {
+        case xml.StartElement:
+            name = t.Name.Local
+            text.Reset()
+        case xml.CharData:
+            text.Write(t)
+        case xml.EndElement:
+            if t.Name.Local == name {
+                f(name, text.String())
+            }
+            name = ""
+        }
+    }
+}
+
+// ExtractDOCX extracts text of paragraphs of the body of word document, and its metadata.
+func ExtractDOCX(body []byte) (*Document, error) {
+    office, err := openOffice(body)
+    if err != nil {
+        return nil, err
+    }
+    d, done, err := office.part("word/document.xml")
+    defer done()
+    if err != nil {
+        return nil, err
+    }
+    if d == nil {
+        return nil, errors.New("word/document.xml is not found")
+    }
+    var text strings.Builder
+    inText := false
+    for {
+        token, err := d.Token()
+        if err == io.EOF {
+            break
+        } else if err != nil {
+            return nil, err
+        }
+        switch t := token.(type) {
+        case xml.StartElement:
+            switch t.Name.Local {
+            case "t":
+                inText = true
+            case "tab":
+                text.WriteByte('\t')
+            case "br", "cr":
+                text.WriteByte('\n')
+            }
+        case xml.EndElement:
+            switch t.Name.Local {
+            case "t":
+                inText = false
+            case "p", "tr":
+                text.WriteByte('\n')
+            case "tc":
+                text.WriteByte('\t')
+            }
+        case xml.CharData:
+            if inText {
+                text.Write(t)
+            }
+        }
+    }
+    doc := &Document{Format: "docx", Text: cleanText(text.String())}
+    return doc, office.metadata(doc)
+}
+
+// ExtractXLSX extracts text of the sheets of excel workbook, each sheet as its name and its rows of cells
+// separated by tabs, and its metadata.
+func ExtractXLSX(body []byte) (*Document, error) {
+    office, err := openOffice(body)
+    if err != nil {
+        return nil, err
+    }
+    strs, err := office.sharedStrings()
+    if err != nil {
+        return nil, err
+    }
+    sheets, err := office.sheets()
+    if err != nil {
+        return nil, err
+    }
+    var text strings.Builder
+    for _, sheet := range sheets {
+        text.WriteString(sheet.name + "\n")
+        if err := office.sheetText(sheet.path, strs, &text); err != nil {
+            return nil, err
+        }
+    }
+    doc := &Document{Format: "xlsx", Text: cleanText(text.String())}
+    if err := office.metadata(doc); err != nil {
+        return nil, err
+    }
+    doc.Pages = len(sheets)
+    return doc, nil
+}
+
+// The sharedStrings returns strings of xl/sharedStrings.xml, which cells of type "s" refer to by index.
+func (this *officeDocument) sharedStrings() ([]string, error) {
+    d, done, err := this.part("xl/sharedStrings.xml")
+    defer done()
+    if err != nil || d == nil {
+        return nil, err
+    }
+    var strs []string
+    var s strings.Builder
+    inText, inPhonetic := false, false
+    for {
+        token, err := d.Token()
+        if err == io.EOF {
+            return strs, nil
+        } else if err != nil {
+            return nil, err
+        }
+        switch t := token.(type) {
+        case xml.StartElement:
+            switch t.Name.Local {
+            case "si":
+                s.Reset()
+            case "t":
+                inText = true
+            case "rPh":
+                // phonetic guide of east asian text is not the text
+                inPhonetic = true
+            }
+        case xml.EndElement:
+            switch t.Name.Local {
+            case "si":
+                strs = append(strs, s.String())
+            case "t":
+                inText = false
+            case "rPh":
+                inPhonetic = false
+            }
+        case xml.CharData:
+            if inText && !inPhonetic {
+                s.Write(t)
+            }
+        }
+    }
+}
+
+type officeSheet struct {
+    name string
+    path string
+}
+
+// The sheets returns sheets of xl/workbook.xml in order, with paths of their parts by relationships of
+// the workbook.
+func (this *officeDocument) sheets() ([]officeSheet, error) {
+    targets, err := this.relationships("xl/_rels/workbook.xml.rels", "xl")
+    if err != nil {
+        return nil, err
+    }
+    d, done, err := this.part("xl/workbook.xml")
+    defer done()
+    if err != nil {
+        return nil, err
+    }
+    if d == nil {
+        return nil, errors.New("xl/workbook.xml is not found")
+    }
+    var sheets []officeSheet
+    for {
+        token, err := d.Token()
+        if err == io.EOF {
+            return sheets, nil
+        } else if err != nil {
+            return nil, err
+        }
+        if start, ok := token.(xml.StartElement); ok && start.Name.Local == "sheet" {
+            var sheet officeSheet
+            for _, attr := range start.Attr {
+                switch {
+                case attr.Name.Local == "name":
+                    sheet.name = attr.Value
+                case attr.Name.Local == "id" && attr.Name.Space != "":
+                    sheet.path = targets[attr.Value]
+                }
+            }
+            if sheet.path != "" {
+                sheets = append(sheets, sheet)
+            }
+        }
+    }
+}
+
+// The relationships returns paths of targets of the relationships part by id, resolved against the dir.
+func (this *officeDocument) relationships(name string, dir string) (map[string]string, error) {
+    d, done, err := this.part(name)
+    defer done()
+    if err != nil || d == nil {
+        return nil, err
+    }
+    targets := make(map[string]string)
+    for {
+        token, err := d.Token()
+        if err == io.EOF {
+            return targets, nil
+        } else if err != nil {
+            return nil, err
+        }
+        if start, ok := token.(xml.StartElement); ok && start.Name.Local == "Relationship" {
+            var id, target string
+            for _, attr := range start.Attr {
+                switch attr.Name.Local {
+                case "Id":
+                    id = attr.Value
+                case "Target":
+                    target = attr.Value
+                }
+            }
+            if strings.HasPrefix(target, "/") {
+                targets[id] = strings.TrimPrefix(target, "/")
+            } else {
+                targets[id] = path.Join(dir, target)
+            }
+        }
+    }
+}
+
+// The sheetText writes rows of the sheet, whose cells are separated by tabs.
+func (this *officeDocument) sheetText(name string, strs []string, text *strings.Builder) error {
+    d, done, err := this.part(name)
+    defer done()
+    if err != nil || d == nil {
+        return err
+    }
+    var cellType string
+    var value strings.Builder
+    inValue := false
+    cells := 0
+    for {
+        token, err := d.Token()
+        if err == io.EOF {
+            return nil
+        } else if err != nil {
+            return err
+        }
+        switch t := token.(type) {
+        case xml.StartElement:
+            switch t.Name.Local {
+            case "row":
+                cells = 0
+            case "c":
+                cellType = ""
+                for _, attr := range t.Attr {
+                    if attr.Name.Local == "t" {
+                        cellType = attr.Value
+                    }
+                }
+                value.Reset()
+            case "v", "t":
+                inValue = true
+            }
+        case xml.EndElement:
+            switch t.Name.Local {
+            case "v", "t":
+                inValue = false
+            case "c":
+                v := value.String()
+                if cellType == "s" {
+                    if i, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && i >= 0 && i < len(strs) {
+                        v = strs[i]
+                    }
+                }
+                if v == "" {
+                    continue
+                }
+                if cells > 0 {
+                    text.WriteByte('\t')
+                }
+                text.WriteString(v)
+                cells++
+            case "row":
+                text.WriteByte('\n')
+            }
+        case xml.CharData:
+            if inValue {
+                value.Write(t)
+            }
+        }
+    }
+}
+
+// ExtractPPTX extracts text of the slides of powerpoint presentation in order, and its metadata.
+func ExtractPPTX(body []byte) (*Document, error) {
+    office, err := openOffice(body)
+    if err != nil {
+        return nil, err
+    }
+    var slides []string
+    for name := range office.files {
+        if strings.HasPrefix(name, "ppt/slides/slide") && strings.HasSuffix(name, ".xml") {
+            slides = append(slides, name)
+        }
+    }
+    number := func(name string) int {
+        n, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "ppt/slides/slide"), ".xml"))
+        return n
+    }
+    sort.Slice(slides, func(i, j int) bool {
+        return number(slides[i]) < number(slides[j])
+    })
+    var text strings.Builder
+    for _, name := range slides {
+        d, done, err := office.part(name)
+        if err != nil {
+            done()
+            return nil, err
+        }
+        inText := false
+        for {
+            token, err := d.Token()
+            if err == io.EOF {
+                break
+            } else if err != nil {
+                done()
+                return nil, err
+            }
+            switch t := token.(type) {
+            case xml.StartElement:
+                inText = t.Name.Local == "t"
+            case xml.EndElement:
+                inText = false
+                if t.Name.Local == "p" {
+                    text.WriteByte('\n')
+                }
+            case xml.CharData:
+                if inText {
+                    text.Write(t)
+                }
+            }
+        }
+        done()
+        text.WriteByte('\n')
+    }
+    doc := &Document{Format: "pptx", Text: cleanText(text.String())}
+    if err := office.metadata(doc); err != nil {
+        return nil, err
+    }
+    doc.Pages = len(slides)
+    return doc, nil
+}
@@ -0,0 +1,873 @@
+package document
+
+import (
+    "bytes"
+    "compress/zlib"
+    "errors"
+    "io"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf16"
+)
+
+// The pdf values are parsed as float64 of numbers, string of strings, pdfName, []interface{} of arrays,
+// pdfDict, pdfRef, pdfStream, bool, nil and pdfKeyword of operators of content streams.
+type (
+    pdfName    string
+    pdfKeyword string
+    pdfDict    map[string]interface{}
+    pdfRef     struct{ num, gen int }
+    pdfStream  struct {
+        dict pdfDict
+        data []byte
+    }
+)
+
+// The maxStreamSize limits bytes of a stream inflated, so a zip bomb is not inflated.
+const maxStreamSize = 64 << 20
+
+// ExtractPDF extracts text of the pages of pdf, and its metadata of the document information dictionary.
+// Text is read from text operators of content streams, decoded by ToUnicode cmaps of fonts, or as
+// latin-1 for simple fonts without them. Encrypted pdf and text of images are not supported.
+func ExtractPDF(body []byte) (*Document, error) {
+    if !bytes.HasPrefix(body, []byte("%PDF-")) {
+        return nil, errors.New("pdf header is not found")
+    }
+    file := parsePDF(body)
+    if file.trailer("Encrypt") != nil {
+        return nil, errors.New("encrypted pdf is not supported")
+    }
+    doc := &Document{Format: "pdf"}
+    if info, ok := file.resolve(file.trailer("Info")).(pdfDict); ok {
+        doc.Title = pdfText(file.resolve(info["Title"]))
+        doc.Author = pdfText(file.resolve(info["Author"]))
+        doc.Subject = pdfText(file.resolve(info["Subject"]))
+        doc.Keywords = pdfText(file.resolve(info["Keywords"]))
+        doc.Created = pdfDate(pdfText(file.resolve(info["CreationDate"])))
+        doc.Modified = pdfDate(pdfText(file.resolve(info["ModDate"])))
+    }
+    pages := file.pages()
+    if len(pages) == 0 {
+        return nil, errors.New("pdf pages are not found")
+    }
+    doc.Pages = len(pages)
+    var text strings.Builder
+    for _, page := range pages {
+        file.pageText(page, &text)
+        text.WriteByte('\n')
+    }
+    doc.Text = cleanText(text.String())
+    return doc, nil
+}
+
+// The pdfFile is objects of a pdf file by number.
+type pdfFile struct {
+    objects  map[int]interface{}
+    trailers []pdfDict
+}
+
+var pdfObjectRegexp = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+var pdfTrailerRegexp = regexp.MustCompile(`trailer\s*<<`)
+
+// The parsePDF parses objects of the body in order, so objects of incremental updates replace the objects
+// before, and objects in object streams that are not replaced.
+func parsePDF(body []byte) *pdfFile {
+    file := &pdfFile{objects: make(map[int]interface{})}
+    streamEnd := 0
+    for _, m := range pdfObjectRegexp.FindAllSubmatchIndex(body, -1) {
+        if m[0] < streamEnd {
+            // like "1 0 obj" in data of a stream
+            continue
+        }
+        num, _ := strconv.Atoi(string(body[m[2]:m[3]]))
+        l := &pdfLexer{b: body, i: m[1]}
+        v, ok := l.value()
+        if !ok {
+            continue
+        }
+        if dict, ok := v.(pdfDict); ok {
+            if data, ok := l.streamData(dict); ok {
+                v = &pdfStream{dict: dict, data: data}
+                streamEnd = l.i
+            }
+        }
+        file.objects[num] = v
+    }
+    for _, m := range pdfTrailerRegexp.FindAllIndex(body, -1) {
+        l := &pdfLexer{b: body, i: m[1] - 2}
+        if dict, ok := l.value(); ok {
+            if d, ok := dict.(pdfDict); ok {
+                file.trailers = append(file.trailers, d)
+            }
+        }
+    }
+    var streams []*pdfStream
+    for _, v := range file.objects {
+        if s, ok := v.(*pdfStream); ok {
+            switch s.dict["Type"] {
+            case pdfName("ObjStm"):
+                streams = append(streams, s)
+            case pdfName("XRef"):
+                // cross-reference streams are trailers of pdf 1.5
+                file.trailers = append(file.trailers, s.dict)
+            }
+        }
+    }
+    for _, s := range streams {
+        file.parseObjectStream(s)
+    }
+    return file
+}
+
+// The parseObjectStream parses objects compressed in the object stream.
+func (this *pdfFile) parseObjectStream(s *pdfStream) {
+    data, err := this.decode(s)
+    if err != nil {
+        return
+    }
+    n, _ := this.resolve(s.dict["N"]).(float64)
+    first, _ := this.resolve(s.dict["First"]).(float64)
+    header := &pdfLexer{b: data}
+    for i := 0; i < int(n); i++ {
+        num, ok1 := header.value()
+        offset, ok2 := header.value()
+        numf, ok3 := num.(float64)
+        offsetf, ok4 := offset.(float64)
+        if !ok1 || !ok2 || !ok3 || !ok4 {
+            return
+        }
+        if _, ok := this.objects[int(numf)]; ok {
+            continue
+        }
+        pos := int(first) + int(offsetf)
+        if pos < 0 || pos >= len(data) {
+            continue
+        }
+        l := &pdfLexer{b: data, i: pos}
+        if v, ok := l.value(); ok {
+            this.objects[int(numf)] = v
+        }
+    }
+}
+
+// The trailer returns the value of the key of the last trailer that has it.
+func (this *pdfFile) trailer(key string) interface{} {
+    for i := len(this.trailers) - 1; i >= 0; i-- {
+        if v, ok := this.trailers[i][key]; ok {
+            return v
+        }
+    }
+    return nil
+}
+
+// The resolve returns the object of the reference, or the value if it is not a reference.
+func (this *pdfFile) resolve(v interface{}) interface{} {
+    for i := 0; i < 8; i++ {
+        ref, ok := v.(pdfRef)
+        if !ok {
+            return v
+        }
+        v = this.objects[ref.num]
+    }
+    return nil
+}
+
+// The dict returns dictionary of the value, or of the stream.
+func (this *pdfFile) dict(v interface{}) pdfDict {
+    switch d := this.resolve(v).(type) {
+    case pdfDict:
+        return d
+    case *pdfStream:
+        return d.dict
+    }
+    return nil
+}
+
+// The decode returns data of the stream decoded by its filters. Filters other than FlateDecode are not
+// supported.
+func (this *pdfFile) decode(s *pdfStream) ([]byte, error) {
+    var filters []interface{}
+    switch f := this.resolve(s.dict["Filter"]).(type) {
+    case pdfName:
+        filters = []interface{}{f}
+    case []interface{}:
+        filters = f
+    }
+    data := s.data
+    for _, f := range filters {
+        switch this.resolve(f) {
+        case pdfName("FlateDecode"), pdfName("Fl"):
+            r, err := zlib.NewReader(bytes.NewReader(data))
+            if err != nil {
+                return nil, err
+            }
+            // data before an error of a damaged stream is kept
+            inflated, err := io.ReadAll(io.LimitReader(r, maxStreamSize))
+            if len(inflated) == 0 && err != nil {
+                return nil, err
+            }
+            data = inflated
+        default:
+            return nil, errors.New("pdf filter is not supported : " + pdfTextOf(f))
+        }
+    }
+    return data, nil
+}
+
+func pdfTextOf(v interface{}) string {
+    if name, ok := v.(pdfName); ok {
+        return string(name)
+    }
+    return ""
+}
+
+// The pages returns page dictionaries of the page tree of the catalog in order, or all the page objects in
+// order of their numbers if the page tree is not found.
+func (this *pdfFile) pages() []pdfDict {
+    var pages []pdfDict
+    seen := make(map[pdfRef]bool)
+    var walk func(node interface{}, depth int)
+    walk = func(node interface{}, depth int) {
+        if ref, ok := node.(pdfRef); ok {
+            if seen[ref] {
+                return
+            }
+            seen[ref] = true
+        }
+        if depth > 64 {
+            return
+        }
+        d := this.dict(node)
+        switch d["Type"] {
+        case pdfName("Page"):
+            pages = append(pages, d)
+        default:
+            if kids, ok := this.resolve(d["Kids"]).([]interface{}); ok {
+                for _, kid := range kids {
+                    walk(kid, depth+1)
+                }
+            }
+        }
+    }
+    if root := this.dict(this.trailer("Root")); root != nil {
+        walk(root["Pages"], 0)
+    }
+    if len(pages) > 0 {
+        return pages
+    }
+    nums := make([]int, 0, len(this.objects))
+    for num := range this.objects {
+        nums = append(nums, num)
+    }
+    sort.Ints(nums)
+    for _, num := range nums {
+        if d := this.dict(this.objects[num]); d != nil && d["Type"] == pdfName("Page") {
+            pages = append(pages, d)
+        }
+    }
+    return pages
+}
+
+// The inherited returns value of the key of the page, or of its ancestors in the page tree.
+func (this *pdfFile) inherited(page pdfDict, key string) interface{} {
+    for i := 0; page != nil && i < 64; i++ {
+        if v, ok := page[key]; ok {
+            return this.resolve(v)
+        }
+        page = this.dict(page["Parent"])
+    }
+    return nil
+}
+
+// The pageText writes text of content streams of the page.
+func (this *pdfFile) pageText(page pdfDict, text *strings.Builder) {
+    fonts := make(map[string]*pdfFont)
+    if resources := this.dict(this.inherited(page, "Resources")); resources != nil {
+        for name, f := range this.dict(resources["Font"]) {
+            fonts[name] = this.font(this.dict(f))
+        }
+    }
+    var contents []interface{}
+    switch c := this.resolve(page["Contents"]).(type) {
+    case []interface{}:
+        contents = c
+    case *pdfStream:
+        contents = []interface{}{c}
+    }
+    var data []byte
+    for _, c := range contents {
+        if s, ok := this.resolve(c).(*pdfStream); ok {
+            if decoded, err := this.decode(s); err == nil {
+                data = append(data, decoded...)
+                data = append(data, '\n')
+            }
+        }
+    }
+    contentText(data, fonts, text)
+}
+
+// The pdfFont decodes strings shown by a font to text, by its ToUnicode cmap or as latin-1.
+type pdfFont struct {
+    cmap map[string]string
+
+    // The minLen and maxLen are lengths of codes of the cmap.
+    minLen, maxLen int
+
+    // The composite is whether the font is type0, whose codes are not text without cmap.
+    composite bool
+}
+
+func (this *pdfFile) font(d pdfDict) *pdfFont {
+    f := &pdfFont{composite: d["Subtype"] == pdfName("Type0")}
+    if s, ok := this.resolve(d["ToUnicode"]).(*pdfStream); ok {
+        if data, err := this.decode(s); err == nil {
+            f.parseCMap(data)
+        }
+    }
+    return f
+}
+
+// The parseCMap reads bfchar and bfrange mappings of the ToUnicode cmap.
+func (this *pdfFont) parseCMap(data []byte) {
+    this.cmap = make(map[string]string)
+    l := &pdfLexer{b: data}
+    var operands []interface{}
+    setLen := func(code string) {
+        if this.minLen == 0 || len(code) < this.minLen {
+            this.minLen = len(code)
+        }
+        if len(code) > this.maxLen {
+            this.maxLen = len(code)
+        }
+    }
+    for {
+        v, ok := l.value()
+        if !ok {
+            break
+        }
+        keyword, isKeyword := v.(pdfKeyword)
+        if !isKeyword {
+            operands = append(operands, v)
+            continue
+        }
+        switch keyword {
+        case "endcodespacerange":
+            for i := 0; i+1 < len(operands); i += 2 {
+                if lo, ok := operands[i].(string); ok {
+                    setLen(lo)
+                }
+            }
+        case "endbfchar":
+            for i := 0; i+1 < len(operands); i += 2 {
+                src, ok1 := operands[i].(string)
+                dst, ok2 := operands[i+1].(string)
+                if ok1 && ok2 {
+                    this.cmap[src] = utf16Text(dst)
+                    setLen(src)
+                }
+            }
+        case "endbfrange":
+            for i := 0; i+2 < len(operands); i += 3 {
+                lo, ok1 := operands[i].(string)
+                hi, ok2 := operands[i+1].(string)
+                if !ok1 || !ok2 || len(lo) != len(hi) || len(lo) == 0 || len(lo) > 4 {
+                    continue
+                }
+                setLen(lo)
+                start, end := codeInt(lo), codeInt(hi)
+                if end < start || end-start > 0xffff {
+                    continue
+                }
+                for c := start; c <= end; c++ {
+                    code := intCode(c, len(lo))
+                    switch dst := operands[i+2].(type) {
+                    case string:
+                        // the last byte of the destination is incremented
+                        b := []byte(dst)
+                        if len(b) > 0 {
+                            b[len(b)-1] += byte(c - start)
+                        }
+                        this.cmap[code] = utf16Text(string(b))
+                    case []interface{}:
+                        if c-start < len(dst) {
+                            if s, ok := dst[c-start].(string); ok {
+                                this.cmap[code] = utf16Text(s)
+                            }
+                        }
+                    }
+                }
+            }
+        }
+        operands = operands[:0]
+    }
+}
+
+func codeInt(code string) int {
+    n := 0
+    for i := 0; i < len(code); i++ {
+        n = n<<8 | int(code[i])
+    }
+    return n
+}
+
+func intCode(n int, size int) string {
+    b := make([]byte, size)
+    for i := size - 1; i >= 0; i-- {
+        b[i] = byte(n)
+        n >>= 8
+    }
+    return string(b)
+}
+
+// The utf16Text decodes utf-16be text of cmaps and text strings.
+func utf16Text(s string) string {
+    units := make([]uint16, 0, len(s)/2)
+    for i := 0; i+1 < len(s); i += 2 {
+        units = append(units, uint16(s[i])<<8|uint16(s[i+1]))
+    }
+    return string(utf16.Decode(units))
+}
+
+// The decode returns text of the string shown by the font.
+func (this *pdfFont) decode(s string) string {
+    if this == nil || len(this.cmap) == 0 {
+        if this != nil && this.composite {
+            return ""
+        }
+        return latin1(s)
+    }
+    var b strings.Builder
+    for i := 0; i < len(s); {
+        n := 0
+        for size := this.minLen; size <= this.maxLen && i+size <= len(s); size++ {
+            if text, ok := this.cmap[s[i:i+size]]; ok {
+                b.WriteString(text)
+                n = size
+                break
+            }
+        }
+        if n == 0 {
+            // codes not mapped are skipped, or latin-1 of simple fonts
+            if n = this.minLen; n <= 1 {
+                n = 1
+                if !this.composite {
+                    b.WriteString(latin1(s[i : i+1]))
+                }
+            }
+        }
+        i += n
+    }
+    return b.String()
+}
+
+func latin1(s string) string {
+    runes := make([]rune, len(s))
+    for i := 0; i < len(s); i++ {
+        runes[i] = rune(s[i])
+    }
+    return string(runes)
+}
+
+// The contentText writes text shown by text operators of the content stream. Lines are broken when text
+// moves to another line.
+func contentText(data []byte, fonts map[string]*pdfFont, text *strings.Builder) {
+    l := &pdfLexer{b: data}
+    var operands []interface{}
+    var font *pdfFont
+    var lineY float64
+    newline := func() {
+        text.WriteByte('\n')
+    }
+    for {
+        v, ok := l.value()
+        if !ok {
+            return
+        }
+        op, isOp := v.(pdfKeyword)
+        if !isOp {
+            operands = append(operands, v)
+            continue
+        }
+        switch op {
+        case "Tf":
+            if len(operands) >= 2 {
+                if name, ok := operands[len(operands)-2].(pdfName); ok {
+                    font = fonts[string(name)]
+                }
+            }
+        case "Tj":
+            if len(operands) >= 1 {
+                if s, ok := operands[len(operands)-1].(string); ok {
+                    text.WriteString(font.decode(s))
+                }
+            }
+        case "'", "\"":
+            newline()
+            if len(operands) >= 1 {
+                if s, ok := operands[len(operands)-1].(string); ok {
+                    text.WriteString(font.decode(s))
+                }
+            }
+        case "TJ":
+            if len(operands) >= 1 {
+                if arr, ok := operands[len(operands)-1].([]interface{}); ok {
+                    for _, e := range arr {
+                        switch e := e.(type) {
+                        case string:
+                            text.WriteString(font.decode(e))
+                        case float64:
+                            // a large negative adjustment is a space between words
+                            if e < -200 {
+                                text.WriteByte(' ')
+                            }
+                        }
+                    }
+                }
+            }
+        case "Td", "TD":
+            if len(operands) >= 2 {
+                if ty, ok := operands[len(operands)-1].(float64); ok && ty != 0 {
+                    newline()
+                } else {
+                    text.WriteByte(' ')
+                }
+            }
+        case "T*":
+            newline()
+        case "Tm":
+            // text of the same line may be positioned word by word
+            if len(operands) >= 6 {
+                if y, ok := operands[len(operands)-1].(float64); ok && y == lineY {
+                    text.WriteByte(' ')
+                } else {
+                    lineY = y
+                    newline()
+                }
+            }
+        case "ID":
+            l.skipInlineImage()
+        }
+        operands = operands[:0]
+    }
+}
+
+// The pdfText decodes text string of utf-16be with byte order mark, or of latin-1.
+func pdfText(v interface{}) string {
+    s, ok := v.(string)
+    if !ok {
+        return ""
+    }
+    if strings.HasPrefix(s, "\xfe\xff") {
+        return strings.TrimSpace(utf16Text(s[2:]))
+    }
+    if strings.HasPrefix(s, "\xef\xbb\xbf") {
+        return strings.TrimSpace(s[3:])
+    }
+    return strings.TrimSpace(latin1(s))
+}
+
+// The pdfDate parses date like "D:20240102150405+08'00'", or returns zero time.
+func pdfDate(s string) time.Time {
+    s = strings.TrimPrefix(s, "D:")
+    s = strings.ReplaceAll(s, "'", "")
+    for _, layout := range []string{"20060102150405-0700", "20060102150405Z", "20060102150405", "200601021504",
+        "20060102", "2006"} {
+        if t, err := time.Parse(layout, s); err == nil {
+            return t
+        }
+    }
+    if len(s) > 14 {
+        if t, err := time.Parse("20060102150405", s[:14]); err == nil {
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+// The pdfLexer reads pdf values of objects and content streams.
+type pdfLexer struct {
+    b []byte
+    i int
+}
+
+func isPDFSpace(c byte) bool {
+    return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+    return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
+
+func (this *pdfLexer) skipSpace() {
+    for this.i < len(this.b) {
+        c := this.b[this.i]
+        if isPDFSpace(c) {
+            this.i++
+        } else if c == '%' {
+            for this.i < len(this.b) && this.b[this.i] != '\n' && this.b[this.i] != '\r' {
+                this.i++
+            }
+        } else {
+            return
+        }
+    }
+}
+
+// The value returns the next value, or keyword of operators and delimiters "]" and ">>" ending arrays and
+// dictionaries. It returns false at the end.
+func (this *pdfLexer) value() (interface{}, bool) {
+    this.skipSpace()
+    if this.i >= len(this.b) {
+        return nil, false
+    }
+    c := this.b[this.i]
+    switch {
+    case c == '/':
+        return this.name(), true
+    case c == '(':
+        return this.literal(), true
+    case c == '<' && this.i+1 < len(this.b) && this.b[this.i+1] == '<':
+        this.i += 2
+        return this.dictionary(), true
+    case c == '<':
+        return this.hex(), true
+    case c == '>' && this.i+1 < len(this.b) && this.b[this.i+1] == '>':
+        this.i += 2
+        return pdfKeyword(">>"), true
+    case c == '[':
+        this.i++
+        return this.array(), true
+    case c == ']':
+        this.i++
+        return pdfKeyword("]"), true
+    case c == '+' || c == '-' || c == '.' || c >= '0' && c <= '9':
+        return this.number(), true
+    case isPDFDelimiter(c):
+        this.i++
+        return pdfKeyword(string(c)), true
+    }
+    start := this.i
+    for this.i < len(this.b) && !isPDFSpace(this.b[this.i]) && !isPDFDelimiter(this.b[this.i]) {
+        this.i++
+    }
+    switch word := string(this.b[start:this.i]); word {
+    case "true":
+        return true, true
+    case "false":
+        return false, true
+    case "null":
+        return nil, true
+    default:
+        return pdfKeyword(word), true
+    }
+}
+
+func (this *pdfLexer) name() pdfName {
+    this.i++
+    var b []byte
+    for this.i < len(this.b) && !isPDFSpace(this.b[this.i]) && !isPDFDelimiter(this.b[this.i]) {
+        c := this.b[this.i]
+        if c == '#' && this.i+2 < len(this.b) {
+            if n, err := strconv.ParseUint(string(this.b[this.i+1:this.i+3]), 16, 8); err == nil {
+                b = append(b, byte(n))
+                this.i += 3
+                continue
+            }
+        }
+        b = append(b, c)
+        this.i++
+    }
+    return pdfName(b)
+}
+
+func (this *pdfLexer) literal() string {
+    this.i++
+    var b []byte
+    depth := 1
+    for this.i < len(this.b) {
+        c := this.b[this.i]
+        this.i++
+        switch c {
+        case '(':
+            depth++
+        case ')':
+            if depth--; depth == 0 {
+                return string(b)
+            }
+        case '\\':
+            if this.i >= len(this.b) {
+                return string(b)
+            }
+            c = this.b[this.i]
+            this.i++
+            switch c {
+            case 'n':
+                c = '\n'
+            case 'r':
+                c = '\r'
+            case 't':
+                c = '\t'
+            case 'b':
+                c = '\b'
+            case 'f':
+                c = '\f'
+            case '\r':
+                if this.i < len(this.b) && this.b[this.i] == '\n' {
+                    this.i++
+                }
+                continue
+            case '\n':
+                continue
+            default:
+                if c >= '0' && c <= '7' {
+                    n := int(c - '0')
+                    for k := 0; k < 2 && this.i < len(this.b) && this.b[this.i] >= '0' && this.b[this.i] <= '7'; k++ {
+                        n = n*8 + int(this.b[this.i]-'0')
+                        this.i++
+                    }
+                    c = byte(n)
+                }
+            }
+        }
+        b = append(b, c)
+    }
+    return string(b)
+}
+
+func (this *pdfLexer) hex() string {
+    this.i++
+    var digits []byte
+    for this.i < len(this.b) && this.b[this.i] != '>' {
+        if c := this.b[this.i]; !isPDFSpace(c) {
+            digits = append(digits, c)
+        }
+        this.i++
+    }
+    this.i++
+    if len(digits)%2 == 1 {
+        digits = append(digits, '0')
+    }
+    b := make([]byte, 0, len(digits)/2)
+    for k := 0; k+1 < len(digits); k += 2 {
+        n, err := strconv.ParseUint(string(digits[k:k+2]), 16, 8)
+        if err != nil {
+            break
+        }
+        b = append(b, byte(n))
+    }
+    return string(b)
+}
+
+func (this *pdfLexer) array() []interface{} {
+    var arr []interface{}
+    for {
+        v, ok := this.value()
+        if !ok || v == pdfKeyword("]") {
+            return arr
+        }
+        if v == pdfKeyword("R") && len(arr) >= 2 {
+            // "num gen R" is a reference
+            num, ok1 := arr[len(arr)-2].(float64)
+            gen, ok2 := arr[len(arr)-1].(float64)
+            if ok1 && ok2 {
+                arr = append(arr[:len(arr)-2], pdfRef{int(num), int(gen)})
+                continue
+            }
+        }
+        arr = append(arr, v)
+    }
+}
+
+func (this *pdfLexer) dictionary() pdfDict {
+    d := make(pdfDict)
+    for {
+        k, ok := this.value()
+        if !ok || k == pdfKeyword(">>") {
+            return d
+        }
+        key, ok := k.(pdfName)
+        if !ok {
+            continue
+        }
+        v, ok := this.value()
+        if !ok || v == pdfKeyword(">>") {
+            return d
+        }
+        if num, ok := v.(float64); ok {
+            // "num gen R" is a reference
+            save := this.i
+            if gen, ok := this.value(); ok {
+                if genf, ok := gen.(float64); ok {
+                    if r, ok := this.value(); ok && r == pdfKeyword("R") {
+                        v = pdfRef{int(num), int(genf)}
+                        save = this.i
+                    }
+                }
+            }
+            this.i = save
+        }
+        d[string(key)] = v
+    }
+}
+
+func (this *pdfLexer) number() interface{} {
+    start := this.i
+    this.i++
+    for this.i < len(this.b) && (this.b[this.i] == '.' || this.b[this.i] >= '0' && this.b[this.i] <= '9') {
+        this.i++
+    }
+    n, err := strconv.ParseFloat(string(this.b[start:this.i]), 64)
+    if err != nil {
+        return pdfKeyword(this.b[start:this.i])
+    }
+    return n
+}
+
+// The streamData returns data of the stream after the dictionary of an object, or false if the object is
+// not a stream.
+func (this *pdfLexer) streamData(dict pdfDict) ([]byte, bool) {
+    this.skipSpace()
+    if !bytes.HasPrefix(this.b[this.i:], []byte("stream")) {
+        return nil, false
+    }
+    this.i += len("stream")
+    if this.i < len(this.b) && this.b[this.i] == '\r' {
+        this.i++
+    }
+    if this.i < len(this.b) && this.b[this.i] == '\n' {
+        this.i++
+    }
+    start := this.i
+    if n, ok := dict["Length"].(float64); ok && n >= 0 && start+int(n) <= len(this.b) {
+        end := start + int(n)
+        rest := bytes.TrimLeft(this.b[end:min(end+16, len(this.b))], " \r\n\t")
+        if bytes.HasPrefix(rest, []byte("endstream")) {
+            this.i = end
+            return this.b[start:end], true
+        }
+    }
+    // the length is a reference or wrong
+    end := bytes.Index(this.b[start:], []byte("endstream"))
+    if end < 0 {
+        this.i = len(this.b)
+        return this.b[start:], true
+    }
+    this.i = start + end
+    return bytes.TrimRight(this.b[start:start+end], "\r\n"), true
+}
+
+// The skipInlineImage skips data of inline image after "ID" until "EI".
+func (this *pdfLexer) skipInlineImage() {
+    for this.i+2 < len(this.b) {
+        if isPDFSpace(this.b[this.i]) && this.b[this.i+1] == 'E' && this.b[this.i+2] == 'I' &&
+            (this.i+3 == len(this.b) || isPDFSpace(this.b[this.i+3])) {
+            this.i += 3
+            return
+        }
+        this.i++
+    }
+    this.i = len(this.b)
+}
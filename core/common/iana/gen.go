@@ -0,0 +1,183 @@
+//go:build ignore
+
+// This program generates tables.go of IANA registries, from www.iana.org or with -offline from xml files of
+// the registries cached in a directory, like testdata, so builds without network can regenerate the tables.
+//
+//	go run gen.go [-offline dir] [-output tables.go]
+package main
+
+import (
+    "bytes"
+    "encoding/xml"
+    "errors"
+    "flag"
+    "fmt"
+    "go/format"
+    "io"
+    "io/ioutil"
+    "log"
+    "net/http"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode"
+)
+
+// The assignmentsUrl is where xml files of the registries are, under their ids.
+const assignmentsUrl = "https://www.iana.org/assignments/"
+
+var (
+    offline = flag.String("offline", "", "read cached registry xml files from the directory instead of iana.org")
+    output  = flag.String("output", "tables.go", "file of the generated tables")
+)
+
+func main() {
+    flag.Parse()
+    var buf bytes.Buffer
+    fmt.Fprintln(&buf, "// go generate gen.go")
+    fmt.Fprintln(&buf, "// Code generated by the command above; DO NOT EDIT.")
+    fmt.Fprintln(&buf)
+    fmt.Fprintln(&buf, "package iana")
+    for _, gen := range []struct {
+        id  string
+        gen func(io.Writer, []byte) error
+    }{
+        {"service-names-port-numbers", genServicePorts},
+        {"icmpv6-parameters", genICMPv6Types},
+    } {
+        data, err := registry(gen.id)
+        if err != nil {
+            log.Fatal(gen.id + " : " + err.Error())
+        }
+        fmt.Fprintln(&buf)
+        if err = gen.gen(&buf, data); err != nil {
+            log.Fatal(gen.id + " : " + err.Error())
+        }
+    }
+    src, err := format.Source(buf.Bytes())
+    if err != nil {
+        log.Fatal(err)
+    }
+    // the repository indents by 4 spaces
+    lines := strings.Split(string(src), "\n")
+    for i, line := range lines {
+        tabs := len(line) - len(strings.TrimLeft(line, "\t"))
+        lines[i] = strings.Repeat("    ", tabs) + line[tabs:]
+    }
+    if err = ioutil.WriteFile(*output, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+        log.Fatal(err)
+    }
+}
+
+// The registry returns xml of the registry of the id, from the offline directory or from iana.org.
+func registry(id string) ([]byte, error) {
+    if *offline != "" {
+        return ioutil.ReadFile(filepath.Join(*offline, id+".xml"))
+    }
+    resp, err := http.Get(assignmentsUrl + id + "/" + id + ".xml")
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, errors.New("http status " + strconv.Itoa(resp.StatusCode))
+    }
+    return ioutil.ReadAll(resp.Body)
+}
+
+type serviceRegistry struct {
+    Title   string `xml:"title"`
+    Updated string `xml:"updated"`
+    Records []struct {
+        Name        string `xml:"name"`
+        Protocol    string `xml:"protocol"`
+        Number      string `xml:"number"`
+        Description string `xml:"description"`
+    } `xml:"record"`
+}
+
+// The genServicePorts writes tcp ports of service names.
+func genServicePorts(w io.Writer, data []byte) error {
+    var r serviceRegistry
+    if err := xml.Unmarshal(data, &r); err != nil {
+        return err
+    }
+    ports := make(map[string]int)
+    for _, rec := range r.Records {
+        name := strings.ToLower(strings.TrimSpace(rec.Name))
+        port, err := strconv.Atoi(rec.Number)
+        if name == "" || rec.Protocol != "tcp" || err != nil {
+            continue
+        }
+        if _, ok := ports[name]; !ok {
+            ports[name] = port
+        }
+    }
+    names := make([]string, 0, len(ports))
+    for name := range ports {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    fmt.Fprintf(w, "// %s, Updated: %s\n", r.Title, r.Updated)
+    fmt.Fprintln(w, "var servicePorts = map[string]int{")
+    for _, name := range names {
+        fmt.Fprintf(w, "%q: %d,\n", name, ports[name])
+    }
+    fmt.Fprintln(w, "}")
+    return nil
+}
+
+type icmpRegistry struct {
+    Title      string `xml:"title"`
+    Updated    string `xml:"updated"`
+    Registries []struct {
+        Id      string `xml:"id,attr"`
+        Records []struct {
+            Value       string `xml:"value"`
+            Description string `xml:"description"`
+        } `xml:"record"`
+    } `xml:"registry"`
+}
+
+// The genICMPv6Types writes constants of ICMPv6 message types.
+func genICMPv6Types(w io.Writer, data []byte) error {
+    var r icmpRegistry
+    if err := xml.Unmarshal(data, &r); err != nil {
+        return err
+    }
+    fmt.Fprintf(w, "// %s, Updated: %s\n", r.Title, r.Updated)
+    fmt.Fprintln(w, "const (")
+    seen := make(map[string]bool)
+    for _, sr := range r.Registries {
+        if sr.Id != "icmpv6-parameters-2" {
+            continue
+        }
+        for _, rec := range sr.Records {
+            value, err := strconv.Atoi(rec.Value)
+            name := constName(rec.Description)
+            if err != nil || name == "" || name == "Reserved" || name == "Unassigned" || seen[name] {
+                continue
+            }
+            seen[name] = true
+            fmt.Fprintf(w, "ICMPv6%s = %d // %s\n", name, value, strings.TrimSpace(rec.Description))
+        }
+    }
+    fmt.Fprintln(w, ")")
+    return nil
+}
+
+// The constName returns camel case name of the description without notes in parentheses, like
+// "EchoRequest" of "Echo Request".
+func constName(description string) string {
+    if i := strings.Index(description, "("); i >= 0 {
+        description = description[:i]
+    }
+    var b strings.Builder
+    for _, word := range strings.FieldsFunc(description, func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+    }) {
+        b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+    }
+    return b.String()
+}
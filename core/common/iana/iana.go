@@ -0,0 +1,19 @@
+// Package iana contains tables of IANA registries used by crawls, like tcp ports of service names for
+// default ports of urls, and ICMPv6 message types for liveness probes of hosts by ping.
+// The tables are generated by gen.go from www.iana.org, or from registry xml files cached in a directory
+// for builds without network:
+//
+//	go run gen.go -offline dir
+//
+// The files in testdata are excerpts of the registries for tests of gen.go.
+package iana
+
+//go:generate go run gen.go
+
+import "strings"
+
+// ServicePort returns the tcp port of the service name, like 443 of "https", and whether it is registered.
+func ServicePort(name string) (int, bool) {
+    port, ok := servicePorts[strings.ToLower(name)]
+    return port, ok
+}
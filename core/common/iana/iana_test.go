@@ -0,0 +1,75 @@
+package iana_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/iana"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "io/ioutil"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "testing"
+)
+
+func TestServicePort(t *testing.T) {
+    for name, want := range map[string]int{"http": 80, "HTTPS": 443, "socks": 1080} {
+        if port, ok := iana.ServicePort(name); !ok || port != want {
+            t.Errorf("port of %s should be %d, not %d", name, want, port)
+        }
+    }
+    if _, ok := iana.ServicePort("ws"); ok {
+        t.Error("ws is not a registered service name")
+    }
+    if iana.ICMPv6EchoRequest != 128 || iana.ICMPv6EchoReply != 129 {
+        t.Error("ICMPv6 echo types error")
+    }
+}
+
+func TestGenerateOffline(t *testing.T) {
+    gotool, err := exec.LookPath("go")
+    if err != nil {
+        t.Skip("go command is not found")
+    }
+    dir, err := ioutil.TempDir("", "iana")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    output := filepath.Join(dir, "tables.go")
+    if out, err := exec.Command(gotool, "run", "gen.go", "-offline", "testdata", "-output", output).CombinedOutput(); err != nil {
+        t.Fatalf("gen.go error : %v %s", err, out)
+    }
+    f, err := parser.ParseFile(token.NewFileSet(), output, nil, 0)
+    if err != nil {
+        t.Fatalf("generated tables should be go source: %v", err)
+    }
+    // literal values of entries of servicePorts and of constants, by their keys and names
+    values := make(map[string]string)
+    ast.Inspect(f, func(n ast.Node) bool {
+        switch n := n.(type) {
+        case *ast.KeyValueExpr:
+            if key, ok := n.Key.(*ast.BasicLit); ok {
+                if value, ok := n.Value.(*ast.BasicLit); ok {
+                    values[key.Value] = value.Value
+                }
+            }
+        case *ast.ValueSpec:
+            if len(n.Values) == 1 {
+                if value, ok := n.Values[0].(*ast.BasicLit); ok {
+                    values[n.Names[0].Name] = value.Value
+                }
+            }
+        }
+        return true
+    })
+    for key, want := range map[string]string{`"http"`: "80", `"https"`: "443", `"socks"`: "1080",
+        "ICMPv6EchoRequest": "128", "ICMPv6EchoReply": "129", "ICMPv6PacketTooBig": "2"} {
+        if values[key] != want {
+            t.Errorf("%s should be %s in generated tables, not %q", key, want, values[key])
+        }
+    }
+    if _, ok := values["ICMPv6Unassigned"]; ok {
+        t.Error("unassigned types should not be generated")
+    }
+}
@@ -0,0 +1,267 @@
+// go generate gen.go
+// Code generated by the command above; DO NOT EDIT.
+
+package iana
+
+// Service Name and Transport Protocol Port Number Registry, Updated: 2021-03-27 (netbase 6.4 /etc/services)
+var servicePorts = map[string]int{
+    "acr-nema":         104,
+    "afpovertcp":       548,
+    "amanda":           10080,
+    "amandaidx":        10082,
+    "amidxtape":        10083,
+    "amqp":             5672,
+    "amqps":            5671,
+    "asp":              27374,
+    "auth":             113,
+    "bacula-dir":       9101,
+    "bacula-fd":        9102,
+    "bacula-sd":        9103,
+    "bbs":              7000,
+    "bgp":              179,
+    "bgpd":             2605,
+    "binkp":            24554,
+    "canna":            5680,
+    "cfengine":         5308,
+    "chargen":          19,
+    "cisco-sccp":       2000,
+    "clc-build-daemon": 8990,
+    "cmip-agent":       164,
+    "cmip-man":         163,
+    "codaauth2":        370,
+    "codasrv":          2432,
+    "codasrv-se":       2433,
+    "csync2":           30865,
+    "cvspserver":       2401,
+    "daap":             3689,
+    "datametrics":      1645,
+    "daytime":          13,
+    "db-lsp":           17500,
+    "dcap":             22125,
+    "dicom":            11112,
+    "dict":             2628,
+    "dircproxy":        57000,
+    "discard":          9,
+    "distcc":           3632,
+    "domain":           53,
+    "domain-s":         853,
+    "echo":             7,
+    "epmap":            135,
+    "epmd":             4369,
+    "exec":             512,
+    "f5-globalsite":    2792,
+    "f5-iquery":        4353,
+    "fax":              4557,
+    "fido":             60179,
+    "finger":           79,
+    "font-service":     7100,
+    "freeciv":          5556,
+    "ftp":              21,
+    "ftp-data":         20,
+    "ftps":             990,
+    "ftps-data":        989,
+    "gdomap":           538,
+    "gds-db":           3050,
+    "git":              9418,
+    "gnunet":           2086,
+    "gnutella-rtr":     6347,
+    "gnutella-svc":     6346,
+    "gopher":           70,
+    "gpsd":             2947,
+    "gris":             2135,
+    "groupwise":        1677,
+    "gsidcap":          22128,
+    "gsiftp":           2811,
+    "gsigatekeeper":    2119,
+    "hkp":              11371,
+    "http":             80,
+    "http-alt":         8080,
+    "https":            443,
+    "hylafax":          4559,
+    "imap2":            143,
+    "imaps":            993,
+    "ingreslock":       1524,
+    "ipp":              631,
+    "iprop":            2121,
+    "ircd":             6667,
+    "ircs-u":           6697,
+    "iscsi-target":     3260,
+    "isisd":            2608,
+    "isns":             3205,
+    "iso-tsap":         102,
+    "kamanda":          10081,
+    "kerberos":         88,
+    "kerberos-adm":     749,
+    "kerberos-master":  751,
+    "kerberos4":        750,
+    "kermit":           1649,
+    "klogin":           543,
+    "kpasswd":          464,
+    "krb-prop":         754,
+    "kshell":           544,
+    "ldap":             389,
+    "ldaps":            636,
+    "ldp":              646,
+    "login":            513,
+    "lotusnote":        1352,
+    "mailq":            174,
+    "microsoft-ds":     445,
+    "moira-db":         775,
+    "moira-update":     777,
+    "mon":              2583,
+    "ms-sql-s":         1433,
+    "ms-wbt-server":    3389,
+    "mtn":              4691,
+    "munin":            4949,
+    "mysql":            3306,
+    "mysql-proxy":      6446,
+    "nbd":              10809,
+    "netbios-ssn":      139,
+    "netstat":          15,
+    "nfs":              2049,
+    "nntp":             119,
+    "nntps":            563,
+    "nqs":              607,
+    "nrpe":             5666,
+    "nsca":             5667,
+    "ntske":            4460,
+    "nut":              3493,
+    "omniorb":          8088,
+    "openvpn":          1194,
+    "ospf6d":           2606,
+    "ospfapi":          2607,
+    "ospfd":            2604,
+    "pawserv":          345,
+    "pop3":             110,
+    "pop3s":            995,
+    "poppassd":         106,
+    "postgresql":       5432,
+    "printer":          515,
+    "proofd":           1093,
+    "puppet":           8140,
+    "qmqp":             628,
+    "qmtp":             209,
+    "qotd":             17,
+    "radius":           1812,
+    "radius-acct":      1813,
+    "radmin-port":      4899,
+    "redis":            6379,
+    "remctl":           4373,
+    "ripd":             2602,
+    "ripngd":           2603,
+    "rmiregistry":      1099,
+    "rmtcfg":           1236,
+    "rootd":            1094,
+    "rpc2portmap":      369,
+    "rsync":            873,
+    "rtcm-sc104":       2101,
+    "rtsp":             554,
+    "sa-msg-port":      1646,
+    "saft":             487,
+    "sane-port":        6566,
+    "sge-execd":        6445,
+    "sge-qmaster":      6444,
+    "sgi-cad":          17004,
+    "shell":            514,
+    "sieve":            4190,
+    "silc":             706,
+    "sip":              5060,
+    "sip-tls":          5061,
+    "skkserv":          1178,
+    "smtp":             25,
+    "smux":             199,
+    "snmp":             161,
+    "snmp-trap":        162,
+    "snpp":             444,
+    "socks":            1080,
+    "spamd":            783,
+    "ssh":              22,
+    "submission":       587,
+    "submissions":      465,
+    "sunrpc":           111,
+    "supfiledbg":       1127,
+    "supfilesrv":       871,
+    "suucp":            4031,
+    "svn":              3690,
+    "svrloc":           427,
+    "syslog-tls":       6514,
+    "sysrqd":           4094,
+    "systat":           11,
+    "tacacs":           49,
+    "tcpmux":           1,
+    "telnet":           23,
+    "telnets":          992,
+    "tfido":            60177,
+    "time":             37,
+    "tinc":             655,
+    "tproxy":           8081,
+    "uucp":             540,
+    "venus":            2430,
+    "venus-se":         2431,
+    "webmin":           10000,
+    "whois":            43,
+    "wnn6":             22273,
+    "x11":              6000,
+    "x11-1":            6001,
+    "x11-2":            6002,
+    "x11-3":            6003,
+    "x11-4":            6004,
+    "x11-5":            6005,
+    "x11-6":            6006,
+    "x11-7":            6007,
+    "xinetd":           9098,
+    "xmms2":            9667,
+    "xmpp-client":      5222,
+    "xmpp-server":      5269,
+    "xtel":             1313,
+    "xtelw":            1314,
+    "z3950":            210,
+    "zabbix-agent":     10050,
+    "zabbix-trapper":   10051,
+    "zebra":            2601,
+    "zebrasrv":         2600,
+    "zope":             9673,
+    "zope-ftp":         8021,
+    "zserv":            346,
+}
+
+// Internet Control Message Protocol version 6 (ICMPv6) Parameters, Updated: 2018-03-09
+const (
+    ICMPv6DestinationUnreachable                       = 1   // Destination Unreachable
+    ICMPv6PacketTooBig                                 = 2   // Packet Too Big
+    ICMPv6TimeExceeded                                 = 3   // Time Exceeded
+    ICMPv6ParameterProblem                             = 4   // Parameter Problem
+    ICMPv6EchoRequest                                  = 128 // Echo Request
+    ICMPv6EchoReply                                    = 129 // Echo Reply
+    ICMPv6MulticastListenerQuery                       = 130 // Multicast Listener Query
+    ICMPv6MulticastListenerReport                      = 131 // Multicast Listener Report
+    ICMPv6MulticastListenerDone                        = 132 // Multicast Listener Done
+    ICMPv6RouterSolicitation                           = 133 // Router Solicitation
+    ICMPv6RouterAdvertisement                          = 134 // Router Advertisement
+    ICMPv6NeighborSolicitation                         = 135 // Neighbor Solicitation
+    ICMPv6NeighborAdvertisement                        = 136 // Neighbor Advertisement
+    ICMPv6RedirectMessage                              = 137 // Redirect Message
+    ICMPv6RouterRenumbering                            = 138 // Router Renumbering
+    ICMPv6ICMPNodeInformationQuery                     = 139 // ICMP Node Information Query
+    ICMPv6ICMPNodeInformationResponse                  = 140 // ICMP Node Information Response
+    ICMPv6InverseNeighborDiscoverySolicitationMessage  = 141 // Inverse Neighbor Discovery Solicitation Message
+    ICMPv6InverseNeighborDiscoveryAdvertisementMessage = 142 // Inverse Neighbor Discovery Advertisement Message
+    ICMPv6Version2MulticastListenerReport              = 143 // Version 2 Multicast Listener Report
+    ICMPv6HomeAgentAddressDiscoveryRequestMessage      = 144 // Home Agent Address Discovery Request Message
+    ICMPv6HomeAgentAddressDiscoveryReplyMessage        = 145 // Home Agent Address Discovery Reply Message
+    ICMPv6MobilePrefixSolicitation                     = 146 // Mobile Prefix Solicitation
+    ICMPv6MobilePrefixAdvertisement                    = 147 // Mobile Prefix Advertisement
+    ICMPv6CertificationPathSolicitationMessage         = 148 // Certification Path Solicitation Message
+    ICMPv6CertificationPathAdvertisementMessage        = 149 // Certification Path Advertisement Message
+    ICMPv6MulticastRouterAdvertisement                 = 151 // Multicast Router Advertisement
+    ICMPv6MulticastRouterSolicitation                  = 152 // Multicast Router Solicitation
+    ICMPv6MulticastRouterTermination                   = 153 // Multicast Router Termination
+    ICMPv6FMIPv6Messages                               = 154 // FMIPv6 Messages
+    ICMPv6RPLControlMessage                            = 155 // RPL Control Message
+    ICMPv6ILNPv6LocatorUpdateMessage                   = 156 // ILNPv6 Locator Update Message
+    ICMPv6DuplicateAddressRequest                      = 157 // Duplicate Address Request
+    ICMPv6DuplicateAddressConfirmation                 = 158 // Duplicate Address Confirmation
+    ICMPv6MPLControlMessage                            = 159 // MPL Control Message
+    ICMPv6ExtendedEchoRequest                          = 160 // Extended Echo Request
+    ICMPv6ExtendedEchoReply                            = 161 // Extended Echo Reply
+)
@@ -0,0 +1,172 @@
+// Package language detects natural language of text by its scripts and its common words, without models or
+// dependencies, so multilingual crawls can tell languages of pages.
+package language
+
+import (
+    "strings"
+    "unicode"
+)
+
+// The sampleRunes limits runes of text read by Detect, as the beginning of a page tells its language.
+const sampleRunes = 16 << 10
+
+// The minLetters is letters a text needs before its language is told.
+const minLetters = 20
+
+// The stopwords are common words of languages of latin script, which tell them apart.
+var stopwords = map[string][]string{
+    "en": {"the", "and", "of", "to", "is", "that", "it", "for", "was", "on", "are", "with", "as", "this", "be",
+        "by", "at", "from", "have", "not", "or", "which", "you", "they", "we", "has", "but"},
+    "de": {"der", "die", "und", "das", "ist", "nicht", "ein", "eine", "zu", "den", "von", "mit", "sich", "des",
+        "auf", "für", "im", "dem", "auch", "wird", "werden", "sie", "wir", "ich", "nach", "bei", "sind"},
+    "fr": {"le", "la", "les", "et", "est", "des", "une", "un", "du", "que", "pour", "dans", "qui", "pas", "sur",
+        "au", "avec", "ce", "il", "sont", "nous", "vous", "mais", "ou", "être", "aux", "cette"},
+    "es": {"el", "los", "las", "y", "es", "que", "un", "una", "por", "con", "para", "del", "se", "su", "al",
+        "lo", "como", "más", "pero", "sus", "está", "son", "muy", "también", "fue", "hay"},
+    "it": {"il", "di", "che", "è", "per", "non", "del", "della", "sono", "con", "si", "gli", "da", "nel", "anche",
+        "come", "più", "ma", "questo", "una", "alla", "dei", "delle", "essere", "ha"},
+    "pt": {"o", "os", "e", "é", "que", "do", "da", "em", "um", "uma", "para", "com", "não", "no", "na", "dos",
+        "das", "por", "mais", "ao", "são", "foi", "como", "pela", "pelo", "também"},
+    "nl": {"de", "het", "een", "en", "van", "is", "dat", "niet", "op", "te", "zijn", "voor", "met", "die", "er",
+        "ook", "aan", "als", "bij", "wordt", "maar", "om", "worden", "naar", "deze"},
+    "sv": {"och", "att", "det", "som", "en", "är", "på", "för", "med", "av", "inte", "den", "till", "har", "jag",
+        "om", "ett", "men", "var", "vi", "så", "kan", "från", "eller", "efter"},
+    "pl": {"i", "w", "na", "się", "nie", "z", "do", "to", "że", "jest", "jak", "po", "co", "tak", "od", "ale",
+        "są", "przez", "dla", "czy", "być", "jego", "oraz", "już", "tylko"},
+    "tr": {"ve", "bir", "bu", "da", "için", "ile", "olarak", "çok", "daha", "olan", "gibi", "ne", "sonra",
+        "kadar", "ama", "ya", "değil", "her", "mi", "var", "ise", "veya", "göre"},
+    "id": {"yang", "dan", "di", "ini", "itu", "dengan", "untuk", "dari", "dalam", "tidak", "akan", "pada",
+        "adalah", "ke", "juga", "oleh", "ada", "atau", "kami", "bisa", "sudah", "mereka"},
+    "vi": {"của", "và", "là", "các", "có", "được", "trong", "cho", "không", "những", "một", "với", "người",
+        "này", "đã", "để", "khi", "từ", "theo", "đến", "như", "về"},
+}
+
+// The languagesOf saves languages of each stop word.
+var languagesOf = func() map[string][]string {
+    m := make(map[string][]string)
+    for lang, words := range stopwords {
+        for _, word := range words {
+            m[word] = append(m[word], lang)
+        }
+    }
+    return m
+}()
+
+// The scripts tell languages written in their own script. Han, kana and hangul are told apart by Detect, and
+// cyrillic and arabic by letters of their languages.
+var scripts = []struct {
+    table *unicode.RangeTable
+    lang  string
+}{
+    {unicode.Cyrillic, "ru"}, {unicode.Arabic, "ar"}, {unicode.Greek, "el"}, {unicode.Hebrew, "he"},
+    {unicode.Thai, "th"}, {unicode.Devanagari, "hi"}, {unicode.Bengali, "bn"}, {unicode.Tamil, "ta"},
+    {unicode.Georgian, "ka"}, {unicode.Armenian, "hy"},
+}
+
+// Detect returns language of the text as ISO 639-1 code, like "en", "zh" or "ru", and confidence from 0 to 1.
+// Languages of their own scripts are told by scripts, and languages of latin script by common words. It
+// returns "" and 0 if the text is too short or its language is not known.
+func Detect(text string) (string, float64) {
+    var latin, han, kana, hangul, letters int
+    others := make(map[string]int)
+    ukrainian, persian := 0, 0
+    n := 0
+    for _, r := range text {
+        if n++; n > sampleRunes {
+            break
+        }
+        if !unicode.IsLetter(r) {
+            continue
+        }
+        // a syllable of cjk is worth a few latin letters
+        switch {
+        case unicode.Is(unicode.Latin, r):
+            latin++
+            letters++
+        case unicode.Is(unicode.Han, r):
+            han += 3
+            letters += 3
+        case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+            kana += 3
+            letters += 3
+        case unicode.Is(unicode.Hangul, r):
+            hangul += 3
+            letters += 3
+        default:
+            letters++
+            for _, s := range scripts {
+                if unicode.Is(s.table, r) {
+                    others[s.lang]++
+                    break
+                }
+            }
+            switch r {
+            case 'і', 'ї', 'є', 'ґ', 'І', 'Ї', 'Є', 'Ґ':
+                ukrainian++
+            case 'پ', 'چ', 'ژ', 'گ', 'ی':
+                persian++
+            }
+        }
+    }
+    if letters < minLetters {
+        return "", 0
+    }
+
+    lang, count := "", 0
+    if han+kana > count {
+        lang, count = "zh", han+kana
+        // japanese is written in kanji and kana
+        if kana*5 > han+kana {
+            lang = "ja"
+        }
+    }
+    if hangul > count {
+        lang, count = "ko", hangul
+    }
+    for l, c := range others {
+        if c > count {
+            lang, count = l, c
+        }
+    }
+    if latin > count {
+        return detectLatin(text, float64(latin)/float64(letters))
+    }
+    if lang == "ru" && ukrainian*50 > count {
+        lang = "uk"
+    } else if lang == "ar" && persian*30 > count {
+        lang = "fa"
+    }
+    return lang, float64(count) / float64(letters)
+}
+
+// The detectLatin tells language of latin script by its common words. The share is share of latin letters
+// of the text, which confidence is scaled by.
+func detectLatin(text string, share float64) (string, float64) {
+    if len(text) > sampleRunes*2 {
+        text = text[:sampleRunes*2]
+    }
+    scores := make(map[string]int)
+    words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && r != '\''
+    })
+    for _, word := range words {
+        for _, lang := range languagesOf[word] {
+            scores[lang]++
+        }
+    }
+    best, second := "", 0
+    for lang, score := range scores {
+        if best == "" || score > scores[best] || score == scores[best] && lang < best {
+            if best != "" {
+                second = scores[best]
+            }
+            best = lang
+        } else if score > second {
+            second = score
+        }
+    }
+    if best == "" || scores[best] < 2 {
+        return "", 0
+    }
+    return best, share * float64(scores[best]-second) / float64(scores[best])
+}
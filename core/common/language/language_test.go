@@ -0,0 +1,41 @@
+package language_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/language"
+    "testing"
+)
+
+func TestDetect(t *testing.T) {
+    texts := map[string]string{
+        "en": "The spider crawls pages of the web and saves the items that it finds in them to the pipelines, which are files or databases.",
+        "de": "Der Spider lädt die Seiten aus dem Netz und speichert die Daten, die er auf den Seiten findet, in einer Datenbank. Das ist nicht schwer.",
+        "fr": "Le robot parcourt les pages du web et enregistre les données qu'il trouve dans une base pour les utiliser plus tard avec des outils.",
+        "es": "El robot recorre las páginas de la red y guarda los datos que encuentra en una base para usarlos más tarde con otras herramientas.",
+        "it": "Il robot visita le pagine del web e salva i dati che trova in una base di dati per usarli più tardi con gli strumenti della analisi.",
+        "pt": "O robô percorre as páginas da rede e guarda os dados que encontra em uma base para usá-los mais tarde com as ferramentas do sistema.",
+        "nl": "De spin bezoekt de pagina's van het web en bewaart de gegevens die hij vindt in een database. Dat is niet moeilijk voor een programma.",
+        "zh": "网络爬虫按照一定的规则自动抓取万维网上的信息，并把抓取到的数据保存到数据库中，供以后分析使用。",
+        "ja": "ウェブクローラーはウェブ上のページを自動的に巡回して、見つけたデータをデータベースに保存するプログラムです。",
+        "ko": "웹 크롤러는 웹 페이지를 자동으로 방문하여 찾은 데이터를 데이터베이스에 저장하는 프로그램입니다.",
+        "ru": "Поисковый робот автоматически обходит страницы сети и сохраняет найденные данные в базе для последующего анализа.",
+        "uk": "Пошуковий робот автоматично обходить сторінки мережі і зберігає знайдені дані в базі для подальшого аналізу її змісту.",
+        "ar": "يقوم زاحف الويب بزيارة صفحات الشبكة تلقائيا وحفظ البيانات التي يجدها في قاعدة البيانات لتحليلها لاحقا.",
+        "el": "Ο ανιχνευτής ιστού επισκέπτεται αυτόματα τις σελίδες του διαδικτύου και αποθηκεύει τα δεδομένα που βρίσκει.",
+    }
+    for expected, text := range texts {
+        if lang, confidence := language.Detect(text); lang != expected || confidence < 0.5 {
+            t.Errorf("language of %q should be %s: %s %.2f", text, expected, lang, confidence)
+        }
+    }
+
+    // chinese content with english navigation is chinese
+    mixed := "Home About Login " + texts["zh"] + texts["zh"]
+    if lang, _ := language.Detect(mixed); lang != "zh" {
+        t.Errorf("mixed text should be chinese: %s", lang)
+    }
+    for _, text := range []string{"", "ok", "12345 67890 !!!", "Lorem ipsum dolor sit amet consectetur adipiscing"} {
+        if lang, confidence := language.Detect(text); lang != "" || confidence != 0 {
+            t.Errorf("language of %q should not be known: %s %.2f", text, lang, confidence)
+        }
+    }
+}
@@ -0,0 +1,155 @@
+package mlog
+
+import (
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+)
+
+// The Level is severity of logs. Logs below the level of their component are dropped.
+type Level int32
+
+const (
+    LevelDebug Level = iota
+    LevelInfo
+    LevelWarn
+    LevelError
+    // The LevelOff drops all the logs of the component.
+    LevelOff
+)
+
+var levelNames = []string{"debug", "info", "warn", "error", "off"}
+
+func (this Level) String() string {
+    if this < LevelDebug || this > LevelOff {
+        return "level(" + strconv.Itoa(int(this)) + ")"
+    }
+    return levelNames[this]
+}
+
+// ParseLevel returns the Level of name like "debug", "info", "warn", "error" or "off".
+func ParseLevel(name string) (Level, error) {
+    for i, n := range levelNames {
+        if strings.EqualFold(name, n) {
+            return Level(i), nil
+        }
+    }
+    return LevelDebug, errors.New("unknown log level : " + name)
+}
+
+// The Field is a structured field of a log, like url, host, status or duration.
+type Field struct {
+    Key   string
+    Value interface{}
+}
+
+// F returns Field of the key and value.
+func F(key string, value interface{}) Field {
+    return Field{Key: key, Value: value}
+}
+
+// The FieldLogger is Logger that receives structured fields, for loggers like zap, logrus or slog.
+// Component loggers call LogFields instead of the methods of Logger, with the component name in field "component".
+// Other Loggers receive the fields formatted after the message, like "download error url=http://a.com status=500".
+type FieldLogger interface {
+    Logger
+    LogFields(level Level, msg string, fields []Field)
+}
+
+var defaultLevel = LevelDebug
+var levels = make(map[string]Level)
+
+// SetLevel sets the lowest Level of logs written by the component, like "spider", "downloader", "scheduler",
+// "pipeline" or "page_processer". The component "" sets Level of components without their own Level.
+// Default is LevelDebug.
+func SetLevel(component string, l Level) {
+    loggerLocker.Lock()
+    defer loggerLocker.Unlock()
+    if component == "" {
+        defaultLevel = l
+    } else {
+        levels[component] = l
+    }
+}
+
+// GetLevel returns the Level of the component.
+func GetLevel(component string) Level {
+    loggerLocker.RLock()
+    defer loggerLocker.RUnlock()
+    if l, ok := levels[component]; ok {
+        return l
+    }
+    return defaultLevel
+}
+
+// The ComponentLogger writes logs of a component with structured fields to the Logger set by SetLogger,
+// and drops logs below the Level of the component.
+type ComponentLogger struct {
+    name string
+}
+
+// Component returns ComponentLogger of the component name.
+func Component(name string) *ComponentLogger {
+    return &ComponentLogger{name: name}
+}
+
+// The Enabled returns whether logs of the Level are written, for skipping costly fields.
+func (this *ComponentLogger) Enabled(l Level) bool {
+    return l >= GetLevel(this.name)
+}
+
+func (this *ComponentLogger) Debug(msg string, fields ...Field) {
+    this.log(LevelDebug, msg, fields)
+}
+
+func (this *ComponentLogger) Info(msg string, fields ...Field) {
+    this.log(LevelInfo, msg, fields)
+}
+
+func (this *ComponentLogger) Warn(msg string, fields ...Field) {
+    this.log(LevelWarn, msg, fields)
+}
+
+func (this *ComponentLogger) Error(msg string, fields ...Field) {
+    this.log(LevelError, msg, fields)
+}
+
+func (this *ComponentLogger) log(level Level, msg string, fields []Field) {
+    if !this.Enabled(level) {
+        return
+    }
+    l := Log()
+    if fl, ok := l.(FieldLogger); ok {
+        fl.LogFields(level, msg, append([]Field{F("component", this.name)}, fields...))
+        return
+    }
+    str := formatFields(msg, fields)
+    switch level {
+    case LevelDebug:
+        l.Debug(str)
+    case LevelInfo:
+        l.Info(str)
+    case LevelWarn:
+        l.Warn(str)
+    default:
+        l.Error(str)
+    }
+}
+
+// The formatFields appends fields to the message as key=value, and quotes values with spaces.
+func formatFields(msg string, fields []Field) string {
+    if len(fields) == 0 {
+        return msg
+    }
+    var b strings.Builder
+    b.WriteString(msg)
+    for _, f := range fields {
+        value := fmt.Sprint(f.Value)
+        if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+            value = strconv.Quote(value)
+        }
+        b.WriteString(" " + f.Key + "=" + value)
+    }
+    return b.String()
+}
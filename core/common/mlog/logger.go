@@ -0,0 +1,54 @@
+package mlog
+
+import (
+    "sync"
+)
+
+// The Logger interface receives logs of GO_SPIDER modules, like spider, downloader and scheduler.
+// Implement it to send logs to your own logger like zap or logrus, and set it by SetLogger.
+type Logger interface {
+    Debug(str string)
+    Info(str string)
+    Warn(str string)
+    Error(str string)
+}
+
+// The fileLogger is the default Logger that writes logs to the file log of LogInst.
+type fileLogger struct{}
+
+func (fileLogger) Debug(str string) {
+    LogInst().log("[DEBUG]", str)
+}
+
+func (fileLogger) Info(str string) {
+    LogInst().log("[INFO]", str)
+}
+
+func (fileLogger) Warn(str string) {
+    LogInst().log("[WARN]", str)
+}
+
+func (fileLogger) Error(str string) {
+    LogInst().log("[ERROR]", str)
+}
+
+var loggerLocker sync.RWMutex
+var logger Logger = fileLogger{}
+
+// SetLogger replaces the Logger used by all GO_SPIDER modules.
+// The nil sets the default Logger that writes to the file log of LogInst.
+func SetLogger(l Logger) {
+    if l == nil {
+        l = fileLogger{}
+    }
+    loggerLocker.Lock()
+    logger = l
+    loggerLocker.Unlock()
+}
+
+// Log returns the Logger set by SetLogger.
+func Log() Logger {
+    loggerLocker.RLock()
+    defer loggerLocker.RUnlock()
+    return logger
+}
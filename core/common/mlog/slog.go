@@ -0,0 +1,47 @@
+package mlog
+
+import (
+    "context"
+    "log/slog"
+)
+
+// The slogLogger is FieldLogger that writes logs to a slog.Logger.
+type slogLogger struct {
+    l *slog.Logger
+}
+
+// NewSlogLogger returns FieldLogger that writes logs to l, with fields as slog attributes.
+func NewSlogLogger(l *slog.Logger) FieldLogger {
+    return &slogLogger{l: l}
+}
+
+var slogLevels = map[Level]slog.Level{
+    LevelDebug: slog.LevelDebug,
+    LevelInfo:  slog.LevelInfo,
+    LevelWarn:  slog.LevelWarn,
+    LevelError: slog.LevelError,
+}
+
+func (this *slogLogger) LogFields(level Level, msg string, fields []Field) {
+    attrs := make([]slog.Attr, 0, len(fields))
+    for _, f := range fields {
+        attrs = append(attrs, slog.Any(f.Key, f.Value))
+    }
+    this.l.LogAttrs(context.Background(), slogLevels[level], msg, attrs...)
+}
+
+func (this *slogLogger) Debug(str string) {
+    this.l.Debug(str)
+}
+
+func (this *slogLogger) Info(str string) {
+    this.l.Info(str)
+}
+
+func (this *slogLogger) Warn(str string) {
+    this.l.Warn(str)
+}
+
+func (this *slogLogger) Error(str string) {
+    this.l.Error(str)
+}
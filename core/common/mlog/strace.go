@@ -6,7 +6,7 @@ import (
 )
 
 // Strace represents an active object that strace the processing of spider.
-// The strace info is output to os.Stderr.
+// The strace info is output to os.Stderr, or to the Logger of SetLogger as info logs of component "strace".
 // The loginst is an point of logger in Std-Packages.
 // The isopen is a label represents whether open strace or not.
 type strace struct {
@@ -17,6 +17,8 @@ type strace struct {
 
 var pstrace *strace
 
+var straceLogger = Component("strace")
+
 // StraceInst get the singleton strace object.
 func StraceInst() *strace {
     if pstrace == nil {
@@ -33,10 +35,14 @@ func newStrace() *strace {
     return pstrace
 }
 
-// Println output the str to os.Stderr.
+// Println output the str to os.Stderr, or to the Logger if other Logger is set by SetLogger.
 func (this *strace) Println(str string) {
     if !this.isopen {
         return
     }
+    if _, ok := Log().(fileLogger); !ok {
+        straceLogger.Info(str)
+        return
+    }
     this.loginst.Printf("%s\n", str)
 }
@@ -0,0 +1,170 @@
+package page
+
+import (
+    "github.com/PuerkitoBio/goquery"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/util"
+    "net/url"
+    "regexp"
+    "strings"
+)
+
+// The LinkExtractor extracts links of a html page. Links are resolved against <base href> or the page url,
+// canonicalized by util.CanonicalizeUrl, and filtered by allow and deny regexps.
+// Only http and https links, and ftp and file links of pages of the same scheme, like a mirror crawled by
+// HttpDownloader.SetFileRoot, are extracted, so "javascript:", "mailto:" and the like are skipped.
+type LinkExtractor struct {
+    selector string
+    attr     string
+    allows   []*regexp.Regexp
+    denies   []*regexp.Regexp
+    nofollow bool
+}
+
+// NewLinkExtractor returns LinkExtractor of "href" of all the anchors and areas.
+func NewLinkExtractor() *LinkExtractor {
+    return &LinkExtractor{selector: "a[href], area[href]", attr: "href"}
+}
+
+// The SetSelector sets css selector of link elements and their url attribute, like "img[src]" and "src",
+// or a selector restricting links to a part of the page like "div.list a[href]" and "href".
+func (this *LinkExtractor) SetSelector(selector string, attr string) *LinkExtractor {
+    this.selector = selector
+    this.attr = attr
+    return this
+}
+
+// The Allow adds regexps of extracted urls. If they are set, only urls matching one of them are extracted.
+// It panics if an expr can not be compiled.
+func (this *LinkExtractor) Allow(exprs ...string) *LinkExtractor {
+    for _, expr := range exprs {
+        this.allows = append(this.allows, regexp.MustCompile(expr))
+    }
+    return this
+}
+
+// The Deny adds regexps of urls that are not extracted, which win over allowed ones.
+// It panics if an expr can not be compiled.
+func (this *LinkExtractor) Deny(exprs ...string) *LinkExtractor {
+    for _, expr := range exprs {
+        this.denies = append(this.denies, regexp.MustCompile(expr))
+    }
+    return this
+}
+
+// The SetFollowNofollow sets whether links of rel="nofollow" are extracted. Default is true.
+func (this *LinkExtractor) SetFollowNofollow(follow bool) *LinkExtractor {
+    this.nofollow = !follow
+    return this
+}
+
+// The Extract returns urls of links in the page in document order, without duplicates.
+func (this *LinkExtractor) Extract(p *Page) []string {
+    doc := p.GetHtmlParser()
+    if doc == nil {
+        return nil
+    }
+    base := linkBase(p, doc)
+    if base == nil {
+        return nil
+    }
+
+    var links []string
+    seen := make(map[string]bool)
+    doc.Find(this.selector).Each(func(i int, s *goquery.Selection) {
+        if this.nofollow && hasRel(s, "nofollow") {
+            return
+        }
+        ref, ok := s.Attr(this.attr)
+        if !ok {
+            return
+        }
+        ref = strings.TrimSpace(ref)
+        if ref == "" || strings.HasPrefix(ref, "#") {
+            return
+        }
+        u, err := base.Parse(ref)
+        if err != nil || !followScheme(base, u) {
+            return
+        }
+        link := util.CanonicalizeUrl(u.String())
+        if seen[link] || !this.allowed(link) {
+            return
+        }
+        seen[link] = true
+        links = append(links, link)
+    })
+    return links
+}
+
+// The ExtractRequests returns requests of the links with the responce type, whose referer is the page url.
+func (this *LinkExtractor) ExtractRequests(p *Page, respType string) []*request.Request {
+    links := this.Extract(p)
+    reqs := make([]*request.Request, 0, len(links))
+    for _, link := range links {
+        reqs = append(reqs, request.NewRequest(link, respType).SetReferer(p.GetRequest().GetUrl()))
+    }
+    return reqs
+}
+
+// The followScheme tests whether the link of the page is extracted by its scheme.
+func followScheme(base, u *url.URL) bool {
+    switch u.Scheme {
+    case "http", "https":
+        return u.Host != ""
+    case "ftp":
+        return u.Host != "" && base.Scheme == "ftp"
+    case "file":
+        return base.Scheme == "file"
+    }
+    return false
+}
+
+// The allowed tests the url by allow and deny regexps.
+func (this *LinkExtractor) allowed(link string) bool {
+    for _, reg := range this.denies {
+        if reg.MatchString(link) {
+            return false
+        }
+    }
+    if len(this.allows) == 0 {
+        return true
+    }
+    for _, reg := range this.allows {
+        if reg.MatchString(link) {
+            return true
+        }
+    }
+    return false
+}
+
+// The GetLinks returns canonical urls of all the links of the html page, resolved against <base href>
+// or the page url. Use LinkExtractor for filtering them.
+func (this *Page) GetLinks() []string {
+    return NewLinkExtractor().Extract(this)
+}
+
+// The linkBase returns url that links of the page are resolved against, which is <base href> resolved
+// against the page url, or the page url after redirects.
+func linkBase(p *Page, doc *goquery.Document) *url.URL {
+    base, err := url.Parse(p.GetFinalUrl())
+    if err != nil {
+        return nil
+    }
+    if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+        if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+            return u
+        }
+    }
+    return base
+}
+
+// The hasRel tests whether rel attribute of the element has the value.
+func hasRel(s *goquery.Selection, value string) bool {
+    for _, rel := range strings.Fields(strings.ToLower(s.AttrOr("rel", ""))) {
+        if rel == value {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,43 @@
+package page_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "reflect"
+    "testing"
+)
+
+func TestLinkExtractor(t *testing.T) {
+    html := `<html><head><base href="/docs/"></head><body>
+        <a href="intro.html#top">intro</a>
+        <a href="HTTP://Example.com:80/docs/intro.html">intro again</a>
+        <a href="../blog/%7epost?id=1">post</a>
+        <a href="https://other.com/x" rel="nofollow">other</a>
+        <a href="mailto:a@example.com">mail</a>
+        <a href="javascript:void(0)">js</a>
+        <a href="#section">section</a>
+        <area href="/map/1.png">
+    </body></html>`
+    p := newHtmlPage("http://example.com/a/index.html", html)
+
+    links := p.GetLinks()
+    expected := []string{"http://example.com/docs/intro.html", "http://example.com/blog/~post?id=1",
+        "https://other.com/x", "http://example.com/map/1.png"}
+    if !reflect.DeepEqual(links, expected) {
+        t.Errorf("links error: %v", links)
+    }
+
+    links = page.NewLinkExtractor().Allow(`^http://example\.com/`).Deny(`\.png$`).SetFollowNofollow(false).Extract(p)
+    if !reflect.DeepEqual(links, expected[:2]) {
+        t.Errorf("filtered links error: %v", links)
+    }
+
+    reqs := page.NewLinkExtractor().SetSelector("a[rel=nofollow]", "href").ExtractRequests(p, "html")
+    if len(reqs) != 1 || reqs[0].GetUrl() != "https://other.com/x" || reqs[0].GetReferer() != "http://example.com/a/index.html" {
+        t.Errorf("requests error: %v", reqs)
+    }
+
+    p = newHtmlPage("file:///data/mirror/", `<a href="a.html">a</a><a href="ftp://example.com/pub/">ftp</a>`)
+    if links := p.GetLinks(); !reflect.DeepEqual(links, []string{"file:///data/mirror/a.html"}) {
+        t.Errorf("file links of file page should be extracted only: %v", links)
+    }
+}
@@ -3,10 +3,13 @@
 package page
 
 import (
+    "context"
     "github.com/PuerkitoBio/goquery"
     "github.com/bitly/go-simplejson"
+    "github.com/hu17889/go_spider/core/common/document"
     "github.com/hu17889/go_spider/core/common/page_items"
     "github.com/hu17889/go_spider/core/common/request"
+    "io"
     "net/http"
     //"fmt"
 )
@@ -23,21 +26,71 @@ type Page struct {
     // The body is plain text of crawl result.
     body string
 
+    // The rawBody is responce body before it is converted to utf-8, kept if downloader is set to keep it.
+    rawBody []byte
+
+    // The filePath and fileSize is the file saved for "file" responce type, which has no body.
+    filePath string
+    fileSize int64
+
+    // The bodyReader reads body of "stream" responce type while it is downloaded, and bodyCloser closes
+    // the responce after the page is processed.
+    bodyReader io.Reader
+    bodyCloser io.Closer
+
+    // The messages receives messages of "sse" and "websocket" responce types, and message is the message of
+    // a page returned by MessagePage.
+    messages <-chan Message
+    message  *Message
+
+    // The emitter outputs items and target requests of the page before it is processed completely.
+    emitter func(p *Page)
+
+    // The statusCode is status code of http responce, 0 if responce is not received.
+    statusCode int
+
+    // The notModified is true when responce is 304 Not Modified and the page saved before is used.
+    notModified bool
+
+    // The bodyOutcome is why responce body is not read completely, like BodyTruncated, or "" if it is.
+    bodyOutcome string
+
+    // The errorClass is class of the download error, like "dns" or "timeout", or "" if it is not known.
+    errorClass string
+
     header  map[string][]string
     cookies []*http.Cookie
 
+    // The proxyHost is the proxy used to download the page.
+    proxyHost string
+
+    // The remoteAddr is address of the connection the page is downloaded by.
+    remoteAddr string
+
+    // The resp is the raw http responce whose body has been read and closed.
+    resp *http.Response
+
     // The docParser is a pointer of goquery boject that contains html result.
     docParser *goquery.Document
 
     // The jsonMap is the json result.
     jsonMap *simplejson.Json
 
+    // The document is text and metadata of body of a document like pdf.
+    document *document.Document
+
+    // The language is ISO 639-1 code of language of the page.
+    language string
+
     // The pItems is object for save Key-Values in PageProcesser.
     // And pItems is output in Pipline.
     pItems *page_items.PageItems
 
     // The targetRequests is requests to put into Scheduler.
     targetRequests []*request.Request
+
+    // The ctx is context of the download, which is context of the request with cancellation of the crawl.
+    ctx context.Context
 }
 
 // NewPage returns initialized Page object.
@@ -45,6 +98,61 @@ func NewPage(req *request.Request) *Page {
     return &Page{pItems: page_items.NewPageItems(req), req: req}
 }
 
+// SetStatusCode save the status code of http responce
+func (this *Page) SetStatusCode(code int) {
+    this.statusCode = code
+}
+
+// GetStatusCode returns the status code of http responce, or 0 if no responce is received.
+func (this *Page) GetStatusCode() int {
+    return this.statusCode
+}
+
+// SetNotModified marks the page is not modified since it was downloaded last time.
+func (this *Page) SetNotModified(notModified bool) {
+    this.notModified = notModified
+}
+
+// IsNotModified test whether the page is the one saved before because responce is 304 Not Modified.
+func (this *Page) IsNotModified() bool {
+    return this.notModified
+}
+
+// The outcomes of reading responce body limited by size or Content-Type, returned by GetBodyOutcome.
+const (
+    // The BodyTruncated is body over the max body size that is truncated to the size.
+    BodyTruncated = "truncated"
+    // The BodyTooLarge is body over the max body size that is not read, and the page is failed.
+    BodyTooLarge = "too_large"
+    // The BodyTypeRejected is body of Content-Type not allowed that is not read, and the page is failed.
+    BodyTypeRejected = "type_rejected"
+)
+
+// SetBodyOutcome saves why responce body is not read completely.
+func (this *Page) SetBodyOutcome(outcome string) *Page {
+    this.bodyOutcome = outcome
+    return this
+}
+
+// GetBodyOutcome returns BodyTruncated, BodyTooLarge or BodyTypeRejected if responce body is limited by
+// HttpDownloader, or "" if it is read completely.
+func (this *Page) GetBodyOutcome() string {
+    return this.bodyOutcome
+}
+
+// SetErrorClass saves class of the download error, like "dns", "connect", "tls" or "timeout" of
+// downloader.ClassifyError.
+func (this *Page) SetErrorClass(class string) *Page {
+    this.errorClass = class
+    return this
+}
+
+// GetErrorClass returns class of the download error, or "" if the download is not failed by a network error
+// or its class is not known.
+func (this *Page) GetErrorClass() string {
+    return this.errorClass
+}
+
 // SetHeader save the header of http responce
 func (this *Page) SetHeader(header map[string][]string) {
     this.header = header
@@ -65,6 +173,87 @@ func (this *Page) GetCookies() []*http.Cookie {
     return this.cookies
 }
 
+// SetResponse saves the raw http responce. Its body has been read and closed already.
+func (this *Page) SetResponse(resp *http.Response) {
+    this.resp = resp
+}
+
+// GetResponse returns the raw http responce for details like trailers and TLS connection state.
+// Its body can not be read again. It is nil if the page is not downloaded by http, like page loaded from cache.
+func (this *Page) GetResponse() *http.Response {
+    return this.resp
+}
+
+// SetRawBody saves responce body as it is received, decompressed but not converted to utf-8.
+func (this *Page) SetRawBody(body []byte) *Page {
+    this.rawBody = body
+    return this
+}
+
+// GetRawBody returns responce body before it is converted to utf-8, like for archiving the responce.
+// It is nil unless HttpDownloader is set by SetKeepRawBody.
+func (this *Page) GetRawBody() []byte {
+    return this.rawBody
+}
+
+// The Redirect is a hop of redirects of a download: the url redirected, status code of its redirect responce
+// and the Location it is redirected to.
+type Redirect struct {
+    Url        string
+    StatusCode int
+    Location   string
+}
+
+// GetFinalUrl returns url of the page after redirects, which is the request url if it is not redirected.
+func (this *Page) GetFinalUrl() string {
+    if this.resp != nil && this.resp.Request != nil && this.resp.Request.URL != nil {
+        return this.resp.Request.URL.String()
+    }
+    return this.req.GetUrl()
+}
+
+// GetRedirects returns redirects followed by the download in order, from the request url to the final url.
+// It is empty if the page is not redirected, or not downloaded by http.
+func (this *Page) GetRedirects() []Redirect {
+    var redirects []Redirect
+    if this.resp == nil {
+        return nil
+    }
+    // every request of a redirect has the responce that caused it
+    for req := this.resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+        from := req.Response
+        hop := Redirect{StatusCode: from.StatusCode, Location: req.URL.String()}
+        if from.Request != nil {
+            hop.Url = from.Request.URL.String()
+        }
+        redirects = append([]Redirect{hop}, redirects...)
+    }
+    return redirects
+}
+
+// SetProxyHost saves the proxy used to download the page.
+func (this *Page) SetProxyHost(proxy string) *Page {
+    this.proxyHost = proxy
+    return this
+}
+
+// GetProxyHost returns the proxy used to download the page, or "" if no proxy is used.
+func (this *Page) GetProxyHost() string {
+    return this.proxyHost
+}
+
+// SetRemoteAddr saves address "ip:port" of the connection the page is downloaded by.
+func (this *Page) SetRemoteAddr(addr string) *Page {
+    this.remoteAddr = addr
+    return this
+}
+
+// GetRemoteAddr returns address "ip:port" of the connection the page is downloaded by, which is address of
+// the proxy if a proxy is used, or "" if the page is not downloaded by a connection, like a cached page.
+func (this *Page) GetRemoteAddr() string {
+    return this.remoteAddr
+}
+
 // IsSucc test whether download process success or not.
 func (this *Page) IsSucc() bool {
     return !this.isfail
@@ -86,6 +275,23 @@ func (this *Page) AddField(key string, value string) {
     this.pItems.AddItem(key, value)
 }
 
+// SetItems saves all the values of the map to PageItems preparing for Pipeline, keeping other keys
+func (this *Page) SetItems(items map[string]interface{}) {
+    for key, value := range items {
+        this.pItems.SetValue(key, value)
+    }
+}
+
+// AddValue saves structured value like nested map or slice to PageItems preparing for Pipeline
+func (this *Page) AddValue(key string, value interface{}) {
+    this.pItems.SetValue(key, value)
+}
+
+// AppendValue appends values to the slice of the key in PageItems
+func (this *Page) AppendValue(key string, values ...interface{}) {
+    this.pItems.AppendValue(key, values...)
+}
+
 // GetPageItems returns PageItems object that record KV pair parsed in PageProcesser.
 func (this *Page) GetPageItems() *page_items.PageItems {
     return this.pItems
@@ -113,12 +319,40 @@ func (this *Page) GetRequest() *request.Request {
     return this.req
 }
 
+// The SetContext sets context of the page, which is set by downloader to the context it is downloaded with.
+func (this *Page) SetContext(ctx context.Context) {
+    this.ctx = ctx
+}
+
+// The Context returns context the page is downloaded with, or context of its request if it is not set.
+// It has values of context of the request, and it is done when the crawl is canceled, so PageProcesser
+// can stop long work like following pagination.
+func (this *Page) Context() context.Context {
+    if this.ctx == nil {
+        return this.req.Context()
+    }
+    return this.ctx
+}
+
 // AddTargetRequest adds one new Request waitting for crawl.
 func (this *Page) AddTargetRequest(url string, respType string) *Page {
     this.targetRequests = append(this.targetRequests, request.NewRequest(url, respType))
     return this
 }
 
+// AddTargetRequestWithParams adds one new Request built by caller, which may have callback, meta and other params.
+func (this *Page) AddTargetRequestWithParams(req *request.Request) *Page {
+    this.targetRequests = append(this.targetRequests, req)
+    return this
+}
+
+// AddTargetRequestWithPriority adds one new Request with priority, which is crawled earlier than requests of
+// smaller priority if Spider uses PriorityScheduler.
+func (this *Page) AddTargetRequestWithPriority(url string, respType string, priority int) *Page {
+    this.targetRequests = append(this.targetRequests, request.NewRequest(url, respType).SetPriority(priority))
+    return this
+}
+
 // AddTargetRequests adds new Requests waitting for crawl.
 func (this *Page) AddTargetRequests(urls []string, respType string) *Page {
     for _, url := range urls {
@@ -127,6 +361,15 @@ func (this *Page) AddTargetRequests(urls []string, respType string) *Page {
     return this
 }
 
+// AddTargetRequestsWithTag adds new Requests with tag like "detail", which routes their pages to a
+// PageProcesser by page_processer.RouterPageProcesser.
+func (this *Page) AddTargetRequestsWithTag(urls []string, respType string, tag string) *Page {
+    for _, url := range urls {
+        this.targetRequests = append(this.targetRequests, request.NewRequest(url, respType).SetTag(tag))
+    }
+    return this
+}
+
 // GetTargetRequests returns the target requests that will put into Scheduler
 func (this *Page) GetTargetRequests() []*request.Request {
     return this.targetRequests
@@ -143,6 +386,23 @@ func (this *Page) GetBodyStr() string {
     return this.body
 }
 
+// SetFile saves path and size of the file downloaded for "file" responce type.
+func (this *Page) SetFile(path string, size int64) *Page {
+    this.filePath = path
+    this.fileSize = size
+    return this
+}
+
+// GetFilePath returns path of the file downloaded for "file" responce type.
+func (this *Page) GetFilePath() string {
+    return this.filePath
+}
+
+// GetFileSize returns size of the file downloaded for "file" responce type.
+func (this *Page) GetFileSize() int64 {
+    return this.fileSize
+}
+
 // SetHtmlParser saves goquery object binded to target crawl result.
 func (this *Page) SetHtmlParser(doc *goquery.Document) *Page {
     this.docParser = doc
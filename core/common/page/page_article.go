@@ -0,0 +1,253 @@
+package page
+
+import (
+    "github.com/PuerkitoBio/goquery"
+    "golang.org/x/net/html"
+    "math"
+    "regexp"
+    "strings"
+    "time"
+)
+
+// The Article is main content of a news or blog page extracted by GetArticle.
+type Article struct {
+    Title     string
+    Author    string
+    Published time.Time
+    // The Text is plain text of the main content with paragraphs separated by a blank line,
+    // and the Html is html of the element holding it.
+    Text string
+    Html string
+}
+
+var articleUnlikelyReg = regexp.MustCompile(`(?i)banner|breadcrumb|comment|community|cookie|disqus|footer|header|menu|modal|nav|popup|related|remark|share|shoutbox|sidebar|social|sponsor|subscribe|widget|^ad-|-ad$|\bads?\b`)
+var articlePositiveReg = regexp.MustCompile(`(?i)article|body|content|entry|main|page|post|story|text`)
+var articleNegativeReg = regexp.MustCompile(`(?i)byline|caption|comment|foot|hidden|meta|more|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|tags|widget`)
+var articleSpaceReg = regexp.MustCompile(`[ \t\r\f\v]+`)
+var articleBlankLineReg = regexp.MustCompile(`\s*\n\s*\n\s*`)
+
+// The articleBlocks are tags whose text is separated from text around them.
+var articleBlocks = map[string]bool{"address": true, "article": true, "blockquote": true, "dd": true, "div": true,
+    "dl": true, "dt": true, "figcaption": true, "figure": true, "h1": true, "h2": true, "h3": true, "h4": true,
+    "h5": true, "h6": true, "li": true, "ol": true, "p": true, "pre": true, "section": true, "table": true,
+    "tr": true, "ul": true}
+
+// GetArticle extracts title, author, publish date and main content of the html result, by a readability-style
+// algorithm: boilerplate like navigation, sidebars and comments is removed, paragraphs score their parent
+// elements by text length and commas, and the element of the best score with few links is the main content.
+// Title, author and date are read from meta tags of Open Graph, article and schema.org first.
+// It returns nil if the page has no html result or no main content is found.
+func (this *Page) GetArticle() *Article {
+    if this.docParser == nil {
+        return nil
+    }
+    doc := this.docParser
+    body := doc.Find("body").Clone()
+    if body.Length() == 0 {
+        return nil
+    }
+    body.Find("script, style, noscript, iframe, form, nav, aside, header, footer, svg, button, select").Remove()
+    body.Find("*").Each(func(i int, s *goquery.Selection) {
+        id, _ := s.Attr("id")
+        class, _ := s.Attr("class")
+        role, _ := s.Attr("role")
+        match := class + " " + id
+        if role == "navigation" || role == "complementary" || role == "banner" || role == "contentinfo" ||
+            (articleUnlikelyReg.MatchString(match) && !articlePositiveReg.MatchString(match) && !s.Is("body, article")) {
+            s.Remove()
+        }
+    })
+
+    content := articleContent(body)
+    if content == nil {
+        return nil
+    }
+    a := &Article{Text: articleText(content.Get(0))}
+    if a.Text == "" {
+        return nil
+    }
+    a.Html, _ = content.Html()
+    a.Html = strings.TrimSpace(a.Html)
+    a.Title = articleTitle(doc)
+    a.Author = articleAuthor(doc)
+    a.Published = articlePublished(doc)
+    return a
+}
+
+// The articleContent returns the element holding main content of the cleaned body, or nil.
+func articleContent(body *goquery.Selection) *goquery.Selection {
+    scores := make(map[*html.Node]float64)
+    var candidates []*goquery.Selection
+    addScore := func(s *goquery.Selection, score float64) {
+        if s.Length() == 0 {
+            return
+        }
+        n := s.Get(0)
+        if _, ok := scores[n]; !ok {
+            scores[n] = articleWeight(s)
+            candidates = append(candidates, s)
+        }
+        scores[n] += score
+    }
+    body.Find("p, pre, td, blockquote").Each(func(i int, p *goquery.Selection) {
+        text := strings.TrimSpace(p.Text())
+        if len([]rune(text)) < 25 {
+            return
+        }
+        score := 1 + float64(strings.Count(text, ",")+strings.Count(text, "，")) +
+            math.Min(float64(len([]rune(text)))/100, 3)
+        addScore(p.Parent(), score)
+        addScore(p.Parent().Parent(), score/2)
+    })
+
+    var best *goquery.Selection
+    bestScore := 0.0
+    for _, s := range candidates {
+        score := scores[s.Get(0)] * (1 - linkDensity(s))
+        if best == nil || score > bestScore {
+            best, bestScore = s, score
+        }
+    }
+    if best == nil {
+        if article := body.Find("article").First(); article.Length() > 0 {
+            return article
+        }
+        return nil
+    }
+    return best
+}
+
+// The articleWeight is the initial score of a candidate element by its tag, class and id.
+func articleWeight(s *goquery.Selection) float64 {
+    weight := 0.0
+    switch goquery.NodeName(s) {
+    case "article":
+        weight += 10
+    case "div", "section", "main":
+        weight += 5
+    case "pre", "td", "blockquote":
+        weight += 3
+    case "ol", "ul", "dl", "dd", "dt", "li", "form":
+        weight -= 3
+    case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+        weight -= 5
+    }
+    for _, attr := range []string{"class", "id"} {
+        if value, ok := s.Attr(attr); ok && value != "" {
+            if articleNegativeReg.MatchString(value) {
+                weight -= 25
+            }
+            if articlePositiveReg.MatchString(value) {
+                weight += 25
+            }
+        }
+    }
+    return weight
+}
+
+// The linkDensity is the ratio of link text to all the text of the element.
+func linkDensity(s *goquery.Selection) float64 {
+    length := len([]rune(strings.TrimSpace(s.Text())))
+    if length == 0 {
+        return 0
+    }
+    links := 0
+    s.Find("a").Each(func(i int, a *goquery.Selection) {
+        links += len([]rune(strings.TrimSpace(a.Text())))
+    })
+    return float64(links) / float64(length)
+}
+
+// The articleText returns plain text of the node with blocks separated by a blank line.
+func articleText(n *html.Node) string {
+    var b strings.Builder
+    var walk func(n *html.Node)
+    walk = func(n *html.Node) {
+        switch n.Type {
+        case html.TextNode:
+            b.WriteString(strings.Replace(n.Data, "\n", " ", -1))
+            return
+        case html.ElementNode:
+            if n.Data == "br" {
+                b.WriteString("\n")
+                return
+            }
+        }
+        block := n.Type == html.ElementNode && articleBlocks[n.Data]
+        if block {
+            b.WriteString("\n\n")
+        }
+        for c := n.FirstChild; c != nil; c = c.NextSibling {
+            walk(c)
+        }
+        if block {
+            b.WriteString("\n\n")
+        }
+    }
+    walk(n)
+
+    text := articleSpaceReg.ReplaceAllString(b.String(), " ")
+    lines := strings.Split(text, "\n")
+    for i, line := range lines {
+        lines[i] = strings.TrimSpace(line)
+    }
+    text = articleBlankLineReg.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+    return strings.TrimSpace(text)
+}
+
+// The articleMeta returns content of the first meta tag matched by the selector.
+func articleMeta(doc *goquery.Document, selector string) string {
+    value := ""
+    doc.Find(selector).EachWithBreak(func(i int, s *goquery.Selection) bool {
+        value = strings.TrimSpace(s.AttrOr("content", ""))
+        return value == ""
+    })
+    return value
+}
+
+func articleTitle(doc *goquery.Document) string {
+    if title := articleMeta(doc, `meta[property="og:title"], meta[name="twitter:title"]`); title != "" {
+        return title
+    }
+    if h1 := doc.Find("h1"); h1.Length() == 1 {
+        if title := strings.TrimSpace(h1.Text()); title != "" {
+            return title
+        }
+    }
+    title := strings.TrimSpace(doc.Find("title").First().Text())
+    // site name after separator like "Title - Site" or "Title | Site"
+    for _, sep := range []string{" | ", " - ", " – ", " — "} {
+        if i := strings.LastIndex(title, sep); i > 0 {
+            return strings.TrimSpace(title[:i])
+        }
+    }
+    return title
+}
+
+func articleAuthor(doc *goquery.Document) string {
+    if author := articleMeta(doc, `meta[name="author"], meta[property="article:author"], meta[name="byl"]`); author != "" &&
+        !strings.HasPrefix(author, "http") {
+        return author
+    }
+    author := ""
+    doc.Find(`[itemprop="author"], [rel="author"], .author, .byline`).EachWithBreak(func(i int, s *goquery.Selection) bool {
+        if name := s.Find(`[itemprop="name"]`); name.Length() > 0 {
+            s = name
+        }
+        author = strings.TrimSpace(articleSpaceReg.ReplaceAllString(strings.Replace(s.Text(), "\n", " ", -1), " "))
+        return author == ""
+    })
+    return strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(author, "By "), "by "))
+}
+
+func articlePublished(doc *goquery.Document) time.Time {
+    value := articleMeta(doc, `meta[property="article:published_time"], meta[itemprop="datePublished"], `+
+        `meta[name="pubdate"], meta[name="publishdate"], meta[name="date"], meta[name="dc.date"]`)
+    if value == "" {
+        value = strings.TrimSpace(doc.Find(`[itemprop="datePublished"]`).First().AttrOr("datetime", ""))
+    }
+    if value == "" {
+        value = strings.TrimSpace(doc.Find("time[datetime]").First().AttrOr("datetime", ""))
+    }
+    return parseFeedTime(value)
+}
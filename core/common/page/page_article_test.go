@@ -0,0 +1,61 @@
+package page_test
+
+import (
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestGetArticle(t *testing.T) {
+    html := `<html><head>
+        <title>Go 2 is coming - Daily Gopher</title>
+        <meta property="article:published_time" content="2021-03-04T08:30:00Z"/>
+        <script>var tracking = 1;</script>
+    </head><body>
+        <header><a href="/">Daily Gopher</a> <a href="/news">News</a> <a href="/blog">Blog</a></header>
+        <nav><ul><li><a href="/a">Politics, world, economy and more</a></li></ul></nav>
+        <div id="wrapper">
+            <div class="post-body">
+                <h1>Go 2 is coming</h1>
+                <p class="byline">By <span itemprop="author">Jane Doe</span></p>
+                <p>The Go team announced today, after years of discussion, that generics will land in the next release.</p>
+                <p>Developers have long asked for type parameters, and the new design keeps the language simple,
+                readable and fast to compile.</p>
+                <p>Read the <a href="/design">design document</a> for the details, including constraints, type sets and inference.</p>
+            </div>
+            <div class="sidebar"><p>Subscribe to our newsletter, it is free, weekly and full of news.</p></div>
+            <div id="comments"><p>Great news, finally, I have waited for this, thanks to the team!</p></div>
+        </div>
+        <footer>Copyright, all rights reserved, Daily Gopher Inc.</footer>
+    </body></html>`
+    p := newHtmlPage("http://example.com/go2", html)
+    a := p.GetArticle()
+    if a == nil {
+        t.Fatal("article should be found")
+    }
+    if a.Title != "Go 2 is coming" || a.Author != "Jane Doe" {
+        t.Errorf("title or author error: %q %q", a.Title, a.Author)
+    }
+    if !a.Published.Equal(time.Date(2021, 3, 4, 8, 30, 0, 0, time.UTC)) {
+        t.Errorf("published error: %v", a.Published)
+    }
+    if !strings.HasPrefix(a.Text, "Go 2 is coming\n\nBy Jane Doe\n\nThe Go team announced today") ||
+        !strings.Contains(a.Text, "readable and fast to compile.\n\nRead the design document for the details") {
+        t.Errorf("text error:\n%s", a.Text)
+    }
+    for _, boilerplate := range []string{"Subscribe", "Great news", "Copyright", "Politics", "tracking"} {
+        if strings.Contains(a.Text, boilerplate) {
+            t.Errorf("boilerplate %q should be removed:\n%s", boilerplate, a.Text)
+        }
+    }
+    if !strings.Contains(a.Html, `<a href="/design">design document</a>`) {
+        t.Errorf("html error: %s", a.Html)
+    }
+    if p.GetHtmlParser().Find("#comments").Length() != 1 {
+        t.Error("document of the page should not be changed")
+    }
+
+    if a := newHtmlPage("http://example.com/", "<html><body><a href='/'>home</a></body></html>").GetArticle(); a != nil {
+        t.Errorf("page without content should have no article: %+v", a)
+    }
+}
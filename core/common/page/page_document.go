@@ -0,0 +1,30 @@
+package page
+
+import (
+    "github.com/hu17889/go_spider/core/common/document"
+    "strings"
+)
+
+// SetDocument saves text and metadata extracted from body of a document like pdf, docx or xlsx.
+func (this *Page) SetDocument(doc *document.Document) *Page {
+    this.document = doc
+    return this
+}
+
+// GetDocument returns text and metadata of the document, like its title and author, if body of the page is a
+// document extracted by Downloader, or nil.
+func (this *Page) GetDocument() *document.Document {
+    return this.document
+}
+
+// GetText returns plain text of the page: text of the document if the page is a document, text of body of
+// html page, or the body.
+func (this *Page) GetText() string {
+    if this.document != nil {
+        return this.document.Text
+    }
+    if this.docParser != nil {
+        return strings.TrimSpace(this.docParser.Find("body").Text())
+    }
+    return this.body
+}
@@ -0,0 +1,227 @@
+package page
+
+import (
+    "encoding/xml"
+    "errors"
+    "golang.org/x/net/html/charset"
+    "io"
+    "net/url"
+    "strings"
+    "time"
+)
+
+// The Feed is a RSS or Atom feed.
+type Feed struct {
+    Title   string
+    Link    string
+    Entries []FeedEntry
+}
+
+// The FeedEntry is an item of RSS feed or an entry of Atom feed.
+// The Id is guid of RSS item or id of Atom entry, and the link if they are not set.
+// The Content is full content of the entry, like <content:encoded> of RSS, and the Summary is its description.
+// The Published is zero if the date is not set or can not be parsed.
+type FeedEntry struct {
+    Id        string
+    Title     string
+    Link      string
+    Author    string
+    Summary   string
+    Content   string
+    Published time.Time
+    Updated   time.Time
+}
+
+// The feedXml represents RSS 2.0, RSS 1.0 and Atom documents, whose elements are matched by local names.
+type feedXml struct {
+    XMLName xml.Name
+    Channel struct {
+        Title string     `xml:"title"`
+        Links []feedLink `xml:"link"`
+        Items []feedItem `xml:"item"`
+    } `xml:"channel"`
+    // items of RSS 1.0 are out of the channel
+    Items   []feedItem `xml:"item"`
+    Title   string     `xml:"title"`
+    Links   []feedLink `xml:"link"`
+    Entries []feedItem `xml:"entry"`
+}
+
+// The feedItem represents both RSS item and Atom entry.
+type feedItem struct {
+    Guid        string       `xml:"guid"`
+    Id          string       `xml:"id"`
+    Title       string       `xml:"title"`
+    Links       []feedLink   `xml:"link"`
+    Authors     []feedAuthor `xml:"author"`
+    Creator     string       `xml:"http://purl.org/dc/elements/1.1/ creator"`
+    Description string       `xml:"description"`
+    Summary     string       `xml:"summary"`
+    Encoded     string       `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+    Content     feedContent  `xml:"content"`
+    PubDate     string       `xml:"pubDate"`
+    Date        string       `xml:"http://purl.org/dc/elements/1.1/ date"`
+    Published   string       `xml:"published"`
+    Updated     string       `xml:"updated"`
+}
+
+// The feedAuthor is author of RSS, which is its text, or author of Atom, which has a name.
+type feedAuthor struct {
+    Name string `xml:"name"`
+    Text string `xml:",chardata"`
+}
+
+// The feedContent is content of Atom entry. Its xhtml content is kept as it is.
+type feedContent struct {
+    Type  string `xml:"type,attr"`
+    Text  string `xml:",chardata"`
+    Inner string `xml:",innerxml"`
+}
+
+// The feedLink is link of RSS, whose url is its text, or link of Atom, whose url is its href.
+type feedLink struct {
+    Href string `xml:"href,attr"`
+    Rel  string `xml:"rel,attr"`
+    Text string `xml:",chardata"`
+}
+
+// The feedTimeLayouts are layouts of RFC 822 dates of RSS seen in the wild, and RFC 3339 dates of Atom.
+var feedTimeLayouts = []string{
+    time.RFC1123Z,
+    time.RFC1123,
+    "Mon, 2 Jan 2006 15:04:05 -0700",
+    "Mon, 2 Jan 2006 15:04:05 MST",
+    "2 Jan 2006 15:04:05 -0700",
+    "Mon, 02 Jan 06 15:04:05 -0700",
+    "Mon, 2 Jan 2006 15:04 -0700",
+    time.RFC3339,
+    "2006-01-02T15:04:05",
+    "2006-01-02",
+}
+
+// GetFeed parses the page body as RSS or Atom feed, for pages of "text" responce type.
+// Relative links of entries are resolved against the page url.
+func (this *Page) GetFeed() (*Feed, error) {
+    return parseFeed(strings.NewReader(this.GetBodyStr()), this.GetRequest().GetUrl(),
+        func(label string, input io.Reader) (io.Reader, error) {
+            // the body has been changed to utf-8 by Downloader
+            return input, nil
+        })
+}
+
+// ParseFeed parses RSS or Atom feed of the url from r. The charset of xml declaration is changed to utf-8.
+func ParseFeed(r io.Reader, feedUrl string) (*Feed, error) {
+    return parseFeed(r, feedUrl, charset.NewReaderLabel)
+}
+
+func parseFeed(r io.Reader, feedUrl string, charsetReader func(string, io.Reader) (io.Reader, error)) (*Feed, error) {
+    decoder := xml.NewDecoder(r)
+    decoder.CharsetReader = charsetReader
+    // feeds are often not strictly well-formed, like html entities in them
+    decoder.Strict = false
+    decoder.Entity = xml.HTMLEntity
+    doc := &feedXml{}
+    if err := decoder.Decode(doc); err != nil {
+        return nil, errors.New("feed parse failed : " + feedUrl + " : " + err.Error())
+    }
+
+    base, _ := url.Parse(feedUrl)
+    feed := &Feed{}
+    var items []feedItem
+    switch strings.ToLower(doc.XMLName.Local) {
+    case "rss", "rdf":
+        feed.Title = strings.TrimSpace(doc.Channel.Title)
+        feed.Link = feedLinkUrl(doc.Channel.Links, base)
+        items = append(doc.Channel.Items, doc.Items...)
+    case "feed":
+        feed.Title = strings.TrimSpace(doc.Title)
+        feed.Link = feedLinkUrl(doc.Links, base)
+        items = doc.Entries
+    default:
+        return nil, errors.New("feed parse failed : " + feedUrl + " : unknown root element " + doc.XMLName.Local)
+    }
+
+    for _, item := range items {
+        entry := FeedEntry{
+            Id:      strings.TrimSpace(firstNonEmpty(item.Guid, item.Id)),
+            Title:   strings.TrimSpace(item.Title),
+            Link:    feedLinkUrl(item.Links, base),
+            Author:  feedAuthors(item.Authors, item.Creator),
+            Summary: strings.TrimSpace(firstNonEmpty(item.Summary, item.Description)),
+            Content: strings.TrimSpace(firstNonEmpty(item.Encoded, item.Content.String())),
+            Updated: parseFeedTime(item.Updated),
+        }
+        entry.Published = parseFeedTime(firstNonEmpty(item.Published, item.PubDate, item.Date))
+        if entry.Published.IsZero() {
+            entry.Published = entry.Updated
+        }
+        if entry.Id == "" {
+            entry.Id = entry.Link
+        }
+        feed.Entries = append(feed.Entries, entry)
+    }
+    return feed, nil
+}
+
+// The feedLinkUrl returns url of the alternate link resolved against base.
+func feedLinkUrl(links []feedLink, base *url.URL) string {
+    for _, link := range links {
+        if link.Rel != "" && link.Rel != "alternate" {
+            continue
+        }
+        href := strings.TrimSpace(firstNonEmpty(link.Href, link.Text))
+        if href == "" {
+            continue
+        }
+        if base != nil {
+            if u, err := base.Parse(href); err == nil {
+                return u.String()
+            }
+        }
+        return href
+    }
+    return ""
+}
+
+// The feedAuthors returns names of the authors joined by ", ", or the dc:creator.
+func feedAuthors(authors []feedAuthor, creator string) string {
+    var names []string
+    for _, author := range authors {
+        if name := strings.TrimSpace(firstNonEmpty(author.Name, author.Text)); name != "" {
+            names = append(names, name)
+        }
+    }
+    if len(names) == 0 {
+        return strings.TrimSpace(creator)
+    }
+    return strings.Join(names, ", ")
+}
+
+func (this feedContent) String() string {
+    if this.Type == "xhtml" {
+        return this.Inner
+    }
+    return this.Text
+}
+
+func parseFeedTime(value string) time.Time {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return time.Time{}
+    }
+    for _, layout := range feedTimeLayouts {
+        if t, err := time.Parse(layout, value); err == nil {
+            return t
+        }
+    }
+    return time.Time{}
+}
+
+func firstNonEmpty(values ...string) string {
+    for _, value := range values {
+        if strings.TrimSpace(value) != "" {
+            return value
+        }
+    }
+    return ""
+}
@@ -0,0 +1,90 @@
+package page_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestGetFeedRss(t *testing.T) {
+    rss := `<?xml version="1.0" encoding="gbk"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/" xmlns:atom="http://www.w3.org/2005/Atom">
+<channel>
+  <title>Blog</title>
+  <link>http://example.com/</link>
+  <atom:link href="http://example.com/rss.xml" rel="self"/>
+  <item>
+    <title>First &amp; best</title>
+    <link>/posts/1</link>
+    <guid isPermaLink="false">post-1</guid>
+    <author>hu@example.com</author>
+    <pubDate>Tue, 23 Sep 2014 10:00:00 +0800</pubDate>
+    <description>summary&nbsp;1</description>
+    <content:encoded><![CDATA[<p>full 1</p>]]></content:encoded>
+  </item>
+  <item>
+    <title>Second</title>
+    <link>http://example.com/posts/2</link>
+  </item>
+</channel>
+</rss>`
+    p := page.NewPage(request.NewRequest("http://example.com/rss.xml", "text"))
+    p.SetBodyStr(rss)
+    feed, err := p.GetFeed()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if feed.Title != "Blog" || feed.Link != "http://example.com/" || len(feed.Entries) != 2 {
+        t.Fatalf("feed error: %+v", feed)
+    }
+    entry := feed.Entries[0]
+    if entry.Id != "post-1" || entry.Title != "First & best" || entry.Link != "http://example.com/posts/1" ||
+        entry.Author != "hu@example.com" || entry.Summary != "summary 1" || entry.Content != "<p>full 1</p>" {
+        t.Errorf("entry error: %+v", entry)
+    }
+    if !entry.Published.Equal(time.Date(2014, 9, 23, 2, 0, 0, 0, time.UTC)) {
+        t.Errorf("published error: %v", entry.Published)
+    }
+    if feed.Entries[1].Id != "http://example.com/posts/2" || !feed.Entries[1].Published.IsZero() {
+        t.Errorf("entry without guid error: %+v", feed.Entries[1])
+    }
+}
+
+func TestParseFeedAtom(t *testing.T) {
+    atom := `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>News</title>
+  <link rel="self" href="http://example.com/atom.xml"/>
+  <link href="http://example.com/"/>
+  <entry>
+    <id>tag:example.com,2014:1</id>
+    <title>Hello</title>
+    <link rel="alternate" href="news/1"/>
+    <author><name>Hu</name></author>
+    <updated>2014-09-24T08:00:00Z</updated>
+    <summary>short</summary>
+    <content type="xhtml"><div>long</div></content>
+  </entry>
+</feed>`
+    feed, err := page.ParseFeed(strings.NewReader(atom), "http://example.com/atom.xml")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if feed.Title != "News" || feed.Link != "http://example.com/" || len(feed.Entries) != 1 {
+        t.Fatalf("feed error: %+v", feed)
+    }
+    entry := feed.Entries[0]
+    if entry.Id != "tag:example.com,2014:1" || entry.Link != "http://example.com/news/1" || entry.Author != "Hu" ||
+        entry.Summary != "short" || entry.Content != "<div>long</div>" {
+        t.Errorf("entry error: %+v", entry)
+    }
+    if !entry.Published.Equal(time.Date(2014, 9, 24, 8, 0, 0, 0, time.UTC)) || !entry.Published.Equal(entry.Updated) {
+        t.Errorf("dates error: %v %v", entry.Published, entry.Updated)
+    }
+
+    if _, err = page.ParseFeed(strings.NewReader("<html></html>"), "http://example.com/"); err == nil {
+        t.Error("html should not be parsed as feed")
+    }
+}
@@ -0,0 +1,217 @@
+package page
+
+import (
+    "github.com/PuerkitoBio/goquery"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/url"
+    "strings"
+)
+
+// The Form is a html form found by Page.FindForm. Its fields have default values of the page, including
+// hidden fields like csrf token and viewstate, which can be changed before it is turned into Request.
+type Form struct {
+    action  string
+    method  string
+    enctype string
+    fields  url.Values
+
+    // The buttons saves values of submit buttons by name, and clicked is the one sent by Click.
+    buttons url.Values
+    clicked string
+}
+
+// FindForm returns the form matched by the css selector, or the first form in the element matched. Its
+// action is absolute url resolved against the page, and its method is "GET" or "POST". It returns nil if no
+// form is found.
+func (this *Page) FindForm(selector string) *Form {
+    if this.docParser == nil {
+        return nil
+    }
+    sel := this.docParser.Find(selector).First()
+    if sel.Length() == 0 {
+        return nil
+    }
+    if goquery.NodeName(sel) != "form" {
+        if sel = sel.Find("form").First(); sel.Length() == 0 {
+            return nil
+        }
+    }
+
+    form := &Form{action: this.req.GetUrl(), method: "GET", fields: formFields(sel), buttons: formButtons(sel)}
+    if v, ok := sel.Attr("action"); ok && strings.TrimSpace(v) != "" {
+        form.action = this.mfResolveUrl(v)
+    }
+    if strings.ToUpper(strings.TrimSpace(sel.AttrOr("method", ""))) == "POST" {
+        form.method = "POST"
+    }
+    form.enctype = strings.ToLower(strings.TrimSpace(sel.AttrOr("enctype", "")))
+    return form
+}
+
+// SubmitForm returns request that submits the form matched by the css selector, like a browser does.
+// Action, method and enctype are read from the form, and default values of its fields, including hidden
+// fields like csrf token, are sent with values overriding them. Submit buttons are not sent unless they
+// are in values. The returned request has responce type "html", and it is nil if no form is found.
+func (this *Page) SubmitForm(selector string, values map[string]string) *request.Request {
+    form := this.FindForm(selector)
+    if form == nil {
+        return nil
+    }
+    for key, value := range values {
+        form.Set(key, value)
+    }
+    return form.Request("html")
+}
+
+// GetAction returns absolute url the form is submitted to.
+func (this *Form) GetAction() string {
+    return this.action
+}
+
+// SetAction sets url the form is submitted to, like a search api of the same form.
+func (this *Form) SetAction(action string) *Form {
+    this.action = action
+    return this
+}
+
+// GetMethod returns "GET" or "POST".
+func (this *Form) GetMethod() string {
+    return this.method
+}
+
+// GetEnctype returns enctype of the form in lower case, like "multipart/form-data", or "".
+func (this *Form) GetEnctype() string {
+    return this.enctype
+}
+
+// Get returns the first value of the field, or "".
+func (this *Form) Get(name string) string {
+    return this.fields.Get(name)
+}
+
+// GetValues returns a copy of values of all the fields.
+func (this *Form) GetValues() url.Values {
+    values := make(url.Values, len(this.fields))
+    for name, v := range this.fields {
+        values[name] = append([]string(nil), v...)
+    }
+    return values
+}
+
+// Set sets value of the field, replacing its values, like the query of a search form.
+func (this *Form) Set(name, value string) *Form {
+    this.fields.Set(name, value)
+    return this
+}
+
+// Add adds value to the field, like another option of a multiple select or checkbox.
+func (this *Form) Add(name, value string) *Form {
+    this.fields.Add(name, value)
+    return this
+}
+
+// Del removes the field, like an unchecked filter.
+func (this *Form) Del(name string) *Form {
+    this.fields.Del(name)
+    return this
+}
+
+// Click sends the submit button of the name with its value, like a form whose buttons do different actions.
+// Only the last button clicked is sent, and "" sends no button.
+func (this *Form) Click(button string) *Form {
+    this.clicked = button
+    return this
+}
+
+// Request returns request that submits the form with its values, of the respType. Fields of GET form are in
+// the query of the action replacing it, and fields of POST form are the body encoded by its enctype,
+// "multipart/form-data" or urlencoded.
+func (this *Form) Request(respType string) *request.Request {
+    fields := this.GetValues()
+    if this.clicked != "" {
+        fields.Set(this.clicked, this.buttons.Get(this.clicked))
+    }
+    if this.method != "POST" {
+        u, err := url.Parse(this.action)
+        if err != nil {
+            return nil
+        }
+        u.RawQuery = fields.Encode()
+        u.Fragment = ""
+        return request.NewRequest(u.String(), respType)
+    }
+
+    req := request.NewRequest(this.action, respType).SetMethod("POST")
+    if this.enctype == "multipart/form-data" {
+        return req.SetMultipart(fields)
+    }
+    return req.SetForm(fields)
+}
+
+// The formFields returns default values of enabled fields in the form.
+func formFields(form *goquery.Selection) url.Values {
+    fields := make(url.Values)
+    form.Find("input, select, textarea").Each(func(i int, s *goquery.Selection) {
+        name, ok := s.Attr("name")
+        if !ok || name == "" {
+            return
+        }
+        if _, disabled := s.Attr("disabled"); disabled {
+            return
+        }
+        switch goquery.NodeName(s) {
+        case "input":
+            switch strings.ToLower(s.AttrOr("type", "text")) {
+            case "submit", "button", "image", "reset", "file":
+                return
+            case "checkbox", "radio":
+                if _, checked := s.Attr("checked"); !checked {
+                    return
+                }
+                fields.Add(name, s.AttrOr("value", "on"))
+            default:
+                fields.Add(name, s.AttrOr("value", ""))
+            }
+        case "select":
+            options := s.Find("option[selected]")
+            if options.Length() == 0 {
+                if _, multiple := s.Attr("multiple"); multiple {
+                    return
+                }
+                options = s.Find("option").First()
+            }
+            options.Each(func(i int, o *goquery.Selection) {
+                fields.Add(name, o.AttrOr("value", strings.TrimSpace(o.Text())))
+            })
+        case "textarea":
+            fields.Add(name, s.Text())
+        }
+    })
+    return fields
+}
+
+// The formButtons returns values of enabled submit buttons in the form by their names.
+func formButtons(form *goquery.Selection) url.Values {
+    buttons := make(url.Values)
+    form.Find("input, button").Each(func(i int, s *goquery.Selection) {
+        name, ok := s.Attr("name")
+        if !ok || name == "" {
+            return
+        }
+        if _, disabled := s.Attr("disabled"); disabled {
+            return
+        }
+        switch goquery.NodeName(s) {
+        case "input":
+            if t := strings.ToLower(s.AttrOr("type", "")); t != "submit" && t != "image" {
+                return
+            }
+        case "button":
+            if t := strings.ToLower(s.AttrOr("type", "submit")); t != "submit" {
+                return
+            }
+        }
+        buttons.Add(name, s.AttrOr("value", ""))
+    })
+    return buttons
+}
@@ -0,0 +1,94 @@
+package page_test
+
+import (
+    "net/url"
+    "strings"
+    "testing"
+)
+
+func TestSubmitForm(t *testing.T) {
+    html := `<html><body>
+        <form id="search" action="/search">
+            <input name="q" value="old"/>
+            <select name="sort"><option value="new">new</option><option value="hot" selected>hot</option></select>
+            <input type="submit" name="go" value="Go"/>
+        </form>
+        <form id="login" action="login.php" method="post">
+            <input type="hidden" name="csrf" value="token"/>
+            <input type="checkbox" name="remember" checked/>
+            <input type="checkbox" name="spam"/>
+            <input name="user" disabled value="x"/>
+            <textarea name="note">hi</textarea>
+        </form>
+    </body></html>`
+    p := newHtmlPage("http://example.com/a/index.html", html)
+
+    req := p.SubmitForm("#search", map[string]string{"q": "go spider"})
+    if req == nil || req.GetMethod() != "GET" || req.GetUrl() != "http://example.com/search?q=go+spider&sort=hot" {
+        t.Fatalf("get form error: %v", req)
+    }
+
+    req = p.SubmitForm("#login", map[string]string{"user": "hu"})
+    if req == nil || req.GetMethod() != "POST" || req.GetUrl() != "http://example.com/a/login.php" {
+        t.Fatal("post form error")
+    }
+    if req.GetHeader().Get("Content-Type") != "application/x-www-form-urlencoded" {
+        t.Error("content type error")
+    }
+    values, _ := url.ParseQuery(req.GetPostdata())
+    expected := url.Values{"csrf": {"token"}, "remember": {"on"}, "note": {"hi"}, "user": {"hu"}}
+    if values.Encode() != expected.Encode() {
+        t.Errorf("postdata error: %s", req.GetPostdata())
+    }
+
+    if p.SubmitForm("#none", nil) != nil {
+        t.Error("missing form should be nil")
+    }
+}
+
+func TestFindForm(t *testing.T) {
+    html := `<html><body>
+        <div id="filters"><form action="?page=1#top">
+            <input type="hidden" name="__VIEWSTATE" value="dDwtMTA"/>
+            <select name="color" multiple><option value="red" selected>red</option><option value="blue">blue</option></select>
+            <button name="action" value="search">Search</button>
+            <button name="action2" value="reset" type="reset">Reset</button>
+            <input type="submit" name="export" value="CSV"/>
+        </form></div>
+        <form id="upload" action="/upload" method="POST" enctype="multipart/form-data">
+            <input type="hidden" name="csrf" value="token"/>
+        </form>
+    </body></html>`
+    p := newHtmlPage("http://example.com/list?page=3", html)
+
+    form := p.FindForm("#filters")
+    if form == nil || form.GetMethod() != "GET" || form.GetAction() != "http://example.com/list?page=1#top" {
+        t.Fatalf("form should be found with absolute action: %v", form)
+    }
+    if form.Get("__VIEWSTATE") != "dDwtMTA" {
+        t.Errorf("hidden field should be filled: %v", form.GetValues())
+    }
+    form.GetValues().Set("color", "green")
+    form.Add("color", "blue").Click("export")
+    req := form.Request("html")
+    if req.GetUrl() != "http://example.com/list?__VIEWSTATE=dDwtMTA&color=red&color=blue&export=CSV" {
+        t.Errorf("get form request error: %s", req.GetUrl())
+    }
+    form.Del("color").Click("action")
+    if req = form.Request("html"); req.GetUrl() != "http://example.com/list?__VIEWSTATE=dDwtMTA&action=search" {
+        t.Errorf("clicked button should be sent: %s", req.GetUrl())
+    }
+
+    req = p.FindForm("#upload").Set("title", "report").Request("json")
+    if req.GetMethod() != "POST" || req.GetUrl() != "http://example.com/upload" || req.GetResponceType() != "json" {
+        t.Fatalf("post form request error: %v", req)
+    }
+    if !strings.HasPrefix(req.GetHeader().Get("Content-Type"), "multipart/form-data; boundary=") ||
+        !strings.Contains(req.GetPostdata(), "name=\"csrf\"\r\n\r\ntoken\r\n") ||
+        !strings.Contains(req.GetPostdata(), "name=\"title\"\r\n\r\nreport\r\n") {
+        t.Errorf("multipart body error: %s", req.GetPostdata())
+    }
+    if p.FindForm("#none") != nil {
+        t.Error("missing form should be nil")
+    }
+}
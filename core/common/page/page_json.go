@@ -0,0 +1,119 @@
+package page
+
+import (
+    "encoding/json"
+    "github.com/bitly/go-simplejson"
+    "strconv"
+    "strings"
+)
+
+// GetJsonPath returns value at the dot separated path in the json result, like "data.items.0.name".
+// A number is index of array, "#" maps the rest of path over every element of array, like "data.items.#.name"
+// for names of all the items, and "\." escapes dot in a key. The whole result is returned for empty path.
+// It never returns nil, so Must functions of simplejson can be chained; the value is nil if the page has
+// no json result or the path is not found.
+func (this *Page) GetJsonPath(path string) *simplejson.Json {
+    js := simplejson.New()
+    var value interface{}
+    if this.jsonMap != nil {
+        value = jsonPath(this.jsonMap.Interface(), splitJsonPath(path))
+    }
+    js.SetPath(nil, value)
+    return js
+}
+
+// GetJsonString returns string at the path, or number and bool at the path formatted as string.
+// It returns empty string if the value is not found or is an object or array.
+func (this *Page) GetJsonString(path string) string {
+    switch v := this.GetJsonPath(path).Interface().(type) {
+    case string:
+        return v
+    case json.Number:
+        return v.String()
+    case bool:
+        return strconv.FormatBool(v)
+    }
+    return ""
+}
+
+// GetJsonInt returns integer at the path, or 0 if it is not found or not a number.
+func (this *Page) GetJsonInt(path string) int64 {
+    return this.GetJsonPath(path).MustInt64()
+}
+
+// GetJsonFloat returns number at the path, or 0 if it is not found or not a number.
+func (this *Page) GetJsonFloat(path string) float64 {
+    return this.GetJsonPath(path).MustFloat64()
+}
+
+// GetJsonBool returns bool at the path, or false if it is not found or not a bool.
+func (this *Page) GetJsonBool(path string) bool {
+    return this.GetJsonPath(path).MustBool()
+}
+
+// GetJsonStrings returns strings of array at the path, like "data.items.#.name".
+// Elements that are not string, number or bool are skipped.
+func (this *Page) GetJsonStrings(path string) []string {
+    var strs []string
+    for _, v := range this.GetJsonPath(path).MustArray() {
+        switch v := v.(type) {
+        case string:
+            strs = append(strs, v)
+        case json.Number:
+            strs = append(strs, v.String())
+        case bool:
+            strs = append(strs, strconv.FormatBool(v))
+        }
+    }
+    return strs
+}
+
+// The splitJsonPath splits path by dots that are not escaped.
+func splitJsonPath(path string) []string {
+    if path == "" {
+        return nil
+    }
+    var keys []string
+    var key strings.Builder
+    for i := 0; i < len(path); i++ {
+        switch {
+        case path[i] == '\\' && i+1 < len(path) && path[i+1] == '.':
+            key.WriteByte('.')
+            i++
+        case path[i] == '.':
+            keys = append(keys, key.String())
+            key.Reset()
+        default:
+            key.WriteByte(path[i])
+        }
+    }
+    return append(keys, key.String())
+}
+
+// The jsonPath returns value at the keys in decoded json value, or nil if it is not found.
+func jsonPath(value interface{}, keys []string) interface{} {
+    for i, key := range keys {
+        switch v := value.(type) {
+        case map[string]interface{}:
+            value = v[key]
+        case []interface{}:
+            if key == "#" {
+                values := make([]interface{}, 0, len(v))
+                for _, elem := range v {
+                    if elem = jsonPath(elem, keys[i+1:]); elem != nil {
+                        values = append(values, elem)
+                    }
+                }
+                return values
+            }
+            n, err := strconv.Atoi(key)
+            if err != nil || n < 0 || n >= len(v) {
+                return nil
+            }
+            value = v[n]
+        default:
+            return nil
+        }
+    }
+    return value
+}
@@ -0,0 +1,45 @@
+package page_test
+
+import (
+    "github.com/bitly/go-simplejson"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "reflect"
+    "testing"
+)
+
+func TestGetJsonPath(t *testing.T) {
+    js, err := simplejson.NewJson([]byte(`{"data": {"total": 2, "more": false, "items": [
+        {"name": "a", "price": 1.5, "tags": ["x"]},
+        {"name": "b", "price": 2}
+    ]}, "a.b": "dot"}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := page.NewPage(request.NewRequest("http://a.com/api", "json"))
+    p.SetJson(js)
+
+    if p.GetJsonString("data.items.1.name") != "b" || p.GetJsonString("data.total") != "2" {
+        t.Error("string error")
+    }
+    if p.GetJsonInt("data.total") != 2 || p.GetJsonFloat("data.items.0.price") != 1.5 || p.GetJsonBool("data.more") {
+        t.Error("number or bool error")
+    }
+    if names := p.GetJsonStrings("data.items.#.name"); !reflect.DeepEqual(names, []string{"a", "b"}) {
+        t.Errorf("array path error: %v", names)
+    }
+    if p.GetJsonString(`a\.b`) != "dot" {
+        t.Error("escaped key error")
+    }
+    if p.GetJsonPath("data.items.5.name").Interface() != nil || p.GetJsonString("data.items.x") != "" {
+        t.Error("missing path should be nil")
+    }
+    if p.GetJsonPath("data.items.0.tags").MustStringArray()[0] != "x" {
+        t.Error("simplejson chain error")
+    }
+
+    empty := page.NewPage(request.NewRequest("http://a.com/", "html"))
+    if empty.GetJsonPath("a").MustString("none") != "none" {
+        t.Error("page without json should return default")
+    }
+}
@@ -0,0 +1,57 @@
+package page
+
+import (
+    "github.com/hu17889/go_spider/core/common/language"
+    "net/http"
+    "strings"
+)
+
+// The declaredConfidence is confidence of detected language below which the language declared by the page is
+// taken instead.
+const declaredConfidence = 0.5
+
+// SetLanguage saves language of the page as ISO 639-1 code, like "en" or "zh".
+func (this *Page) SetLanguage(lang string) *Page {
+    this.language = lang
+    return this
+}
+
+// GetLanguage returns language of the page as ISO 639-1 code, like "en" or "zh", detected by Downloader, or
+// "" if it is not known.
+func (this *Page) GetLanguage() string {
+    return this.language
+}
+
+// DetectLanguage detects language of text of the page by language.Detect, takes the language declared by lang
+// attribute of html or Content-Language header if the text is too short to tell, and saves it as language of
+// the page.
+func (this *Page) DetectLanguage() string {
+    lang, confidence := language.Detect(this.GetText())
+    if confidence < declaredConfidence {
+        if declared := this.declaredLanguage(); declared != "" {
+            lang = declared
+        }
+    }
+    this.language = lang
+    return lang
+}
+
+// The declaredLanguage returns primary language of lang attribute of html, or of Content-Language header.
+func (this *Page) declaredLanguage() string {
+    var declared string
+    if this.docParser != nil {
+        declared = this.docParser.Find("html").AttrOr("lang", "")
+    }
+    if declared == "" {
+        declared = http.Header(this.header).Get("Content-Language")
+    }
+    return PrimaryLanguage(declared)
+}
+
+// PrimaryLanguage returns primary language subtag of the language tag in lower case, like "zh" of "zh-CN", or
+// of the first tag of a list like "en-US, fr".
+func PrimaryLanguage(tag string) string {
+    tag = strings.TrimSpace(strings.SplitN(tag, ",", 2)[0])
+    tag = strings.SplitN(strings.SplitN(tag, "-", 2)[0], "_", 2)[0]
+    return strings.ToLower(strings.TrimSpace(tag))
+}
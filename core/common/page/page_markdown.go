@@ -0,0 +1,187 @@
+package page
+
+import (
+    "github.com/PuerkitoBio/goquery"
+    "golang.org/x/net/html"
+    "regexp"
+    "strconv"
+    "strings"
+)
+
+var mdSpaceReg = regexp.MustCompile(`\s+`)
+var mdBlankLineReg = regexp.MustCompile(`\n[ \t]*\n\s*`)
+var mdTrailingSpaceReg = regexp.MustCompile(`([^ ]) \n`)
+
+// GetMarkdown converts the html result to Markdown, using the document parsed already.
+// It returns empty string if the page has no html result.
+func (this *Page) GetMarkdown() string {
+    if this.docParser == nil {
+        return ""
+    }
+    body := this.docParser.Find("body")
+    if body.Length() == 0 {
+        return this.MarkdownOfSelection(this.docParser.Selection)
+    }
+    return this.MarkdownOfSelection(body)
+}
+
+// GetMarkdownOf converts elements matched by the css selector to Markdown.
+// Markdown of each element is separated by a blank line.
+func (this *Page) GetMarkdownOf(selector string) string {
+    if this.docParser == nil {
+        return ""
+    }
+    return this.MarkdownOfSelection(this.docParser.Find(selector))
+}
+
+// MarkdownOfSelection converts the goquery selection to Markdown.
+// Headings, paragraphs, links, images, emphasis, lists, quotes and code blocks are converted.
+// Other elements like table are not converted but their content is kept, and script and style are removed.
+// Relative links are resolved against url of this page.
+func (this *Page) MarkdownOfSelection(s *goquery.Selection) string {
+    md := &mdConverter{page: this}
+    var blocks []string
+    for _, n := range s.Nodes {
+        if block := md.restore(md.clean(md.convert(n))); block != "" {
+            blocks = append(blocks, block)
+        }
+    }
+    return strings.Join(blocks, "\n\n")
+}
+
+// The mdConverter converts html nodes to Markdown.
+// The content of pre elements is saved in codes and replaced at last, so that it is not cleaned.
+type mdConverter struct {
+    page  *Page
+    codes []string
+}
+
+// The convert returns Markdown of the node and its children.
+func (this *mdConverter) convert(n *html.Node) string {
+    switch n.Type {
+    case html.TextNode:
+        return mdSpaceReg.ReplaceAllString(n.Data, " ")
+    case html.ElementNode:
+    case html.DocumentNode:
+        return this.children(n)
+    default:
+        return ""
+    }
+
+    switch n.Data {
+    case "script", "style", "noscript", "head", "template", "iframe", "object", "svg":
+        return ""
+    case "h1", "h2", "h3", "h4", "h5", "h6":
+        level, _ := strconv.Atoi(n.Data[1:])
+        return "\n\n" + strings.Repeat("#", level) + " " + strings.TrimSpace(this.children(n)) + "\n\n"
+    case "br":
+        return "  \n"
+    case "hr":
+        return "\n\n---\n\n"
+    case "a":
+        text := strings.TrimSpace(this.children(n))
+        href := mdAttr(n, "href")
+        if text == "" || href == "" || strings.HasPrefix(href, "javascript:") {
+            return text
+        }
+        return "[" + text + "](" + this.page.mfResolveUrl(href) + ")"
+    case "img":
+        src := mdAttr(n, "src")
+        if src == "" {
+            return ""
+        }
+        return "![" + mdAttr(n, "alt") + "](" + this.page.mfResolveUrl(src) + ")"
+    case "strong", "b":
+        return mdWrap(this.children(n), "**")
+    case "em", "i":
+        return mdWrap(this.children(n), "*")
+    case "code":
+        return mdWrap(mdText(n), "`")
+    case "pre":
+        this.codes = append(this.codes, strings.Trim(mdText(n), "\n"))
+        return "\n\n```\n\x00" + strconv.Itoa(len(this.codes)-1) + "\x00\n```\n\n"
+    case "ul", "ol":
+        return "\n\n" + this.list(n) + "\n\n"
+    case "blockquote":
+        content := this.clean(this.children(n))
+        return "\n\n> " + strings.Replace(content, "\n", "\n> ", -1) + "\n\n"
+    case "p", "div", "section", "article", "header", "footer", "main", "nav", "aside",
+        "table", "tr", "form", "figure", "dl", "dt", "dd", "address", "details", "summary":
+        return "\n\n" + strings.TrimSpace(this.children(n)) + "\n\n"
+    case "td", "th":
+        return " " + this.children(n) + " "
+    }
+    return this.children(n)
+}
+
+func (this *mdConverter) children(n *html.Node) string {
+    var buf []string
+    for c := n.FirstChild; c != nil; c = c.NextSibling {
+        buf = append(buf, this.convert(c))
+    }
+    return strings.Join(buf, "")
+}
+
+// The list converts li children of ul or ol. Nested content is indented under the item.
+func (this *mdConverter) list(n *html.Node) string {
+    var items []string
+    index := 1
+    for c := n.FirstChild; c != nil; c = c.NextSibling {
+        if c.Type != html.ElementNode || c.Data != "li" {
+            continue
+        }
+        marker := "- "
+        if n.Data == "ol" {
+            marker = strconv.Itoa(index) + ". "
+            index++
+        }
+        content := strings.Replace(this.clean(this.children(c)), "\n\n", "\n", -1)
+        indent := strings.Repeat(" ", len(marker))
+        items = append(items, marker+strings.Replace(content, "\n", "\n"+indent, -1))
+    }
+    return strings.Join(items, "\n")
+}
+
+// The clean trims spaces around blank lines and removes extra blank lines.
+// A single space at line end is removed too, while two spaces of line break are kept.
+func (this *mdConverter) clean(md string) string {
+    md = mdBlankLineReg.ReplaceAllString(strings.TrimSpace(md), "\n\n")
+    return mdTrailingSpaceReg.ReplaceAllString(md, "$1\n")
+}
+
+// The restore puts content of code blocks back.
+func (this *mdConverter) restore(md string) string {
+    for i, code := range this.codes {
+        md = strings.Replace(md, "\x00"+strconv.Itoa(i)+"\x00", code, 1)
+    }
+    return md
+}
+
+func mdWrap(s string, mark string) string {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return ""
+    }
+    return mark + s + mark
+}
+
+func mdAttr(n *html.Node, key string) string {
+    for _, attr := range n.Attr {
+        if attr.Key == key {
+            return strings.TrimSpace(attr.Val)
+        }
+    }
+    return ""
+}
+
+// The mdText returns raw text of the node, keeping spaces and newlines.
+func mdText(n *html.Node) string {
+    if n.Type == html.TextNode {
+        return n.Data
+    }
+    var buf []string
+    for c := n.FirstChild; c != nil; c = c.NextSibling {
+        buf = append(buf, mdText(c))
+    }
+    return strings.Join(buf, "")
+}
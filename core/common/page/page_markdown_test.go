@@ -0,0 +1,40 @@
+package page_test
+
+import (
+    "testing"
+)
+
+func TestGetMarkdown(t *testing.T) {
+    html := `<html><head><title>t</title><style>p {}</style></head><body>
+        <h1>Title</h1>
+        <p>Some <b>bold</b> and <em>em</em> text with <a href="/doc">a link</a>.</p>
+        <script>var a = 1;</script>
+        <ul>
+            <li>one</li>
+            <li>two <ol><li>a</li><li>b</li></ol></li>
+        </ul>
+        <pre><code>func main() {
+
+    fmt.Println("hi")
+}</code></pre>
+        <blockquote><p>quote</p></blockquote>
+        <table><tr><td>cell</td></tr></table>
+        <div id="img"><img src="a.png" alt="pic"/></div>
+    </body></html>`
+    p := newHtmlPage("http://example.com/x/", html)
+
+    expected := "# Title\n\n" +
+        "Some **bold** and *em* text with [a link](http://example.com/doc).\n\n" +
+        "- one\n- two\n  1. a\n  2. b\n\n" +
+        "```\nfunc main() {\n\n    fmt.Println(\"hi\")\n}\n```\n\n" +
+        "> quote\n\n" +
+        "cell\n\n" +
+        "![pic](http://example.com/x/a.png)"
+    if md := p.GetMarkdown(); md != expected {
+        t.Errorf("markdown error:\n%q\n%q", md, expected)
+    }
+
+    if md := p.GetMarkdownOf("#img, h1"); md != "# Title\n\n![pic](http://example.com/x/a.png)" {
+        t.Errorf("markdown of selector error: %q", md)
+    }
+}
@@ -0,0 +1,86 @@
+package page
+
+import (
+    "github.com/bitly/go-simplejson"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "io"
+    "strings"
+    "time"
+)
+
+// Message is a message of "sse" or "websocket" responce type, received while the stream is open.
+type Message struct {
+    // The Event is type of server-sent event, which is "message" if it is not set, or "text" or "binary"
+    // of websocket message.
+    Event string
+
+    // The Id is id of the last server-sent event, which is sent in Last-Event-ID header when reconnecting.
+    Id string
+
+    // The Data is data of the message, whose lines of server-sent event are joined by "\n".
+    Data string
+
+    // The Time is when the message is received.
+    Time time.Time
+}
+
+// SetMessages saves channel of messages of "sse" or "websocket" responce type, which is closed when the
+// stream ends, and closer that stops the stream when CloseBody is called.
+func (this *Page) SetMessages(messages <-chan Message, closer io.Closer) *Page {
+    this.messages = messages
+    this.bodyCloser = closer
+    return this
+}
+
+// Messages returns channel of messages of "sse" or "websocket" responce type, which is closed when the stream
+// ends by its limits or fails, or nil for other types. The page is set failed if the stream fails after
+// reconnects.
+func (this *Page) Messages() <-chan Message {
+    return this.messages
+}
+
+// MessagePage returns page of the message of "sse" or "websocket" page, which has the request, header and
+// context of the page, body of data of the message and json of it if it is json, and its own items and
+// target requests.
+func (this *Page) MessagePage(msg Message) *Page {
+    p := NewPage(this.req)
+    p.statusCode = this.statusCode
+    p.header = this.header
+    p.cookies = this.cookies
+    p.proxyHost = this.proxyHost
+    p.ctx = this.ctx
+    p.body = msg.Data
+    p.message = &msg
+    if data := strings.TrimSpace(msg.Data); strings.HasPrefix(data, "{") || strings.HasPrefix(data, "[") {
+        if js, err := simplejson.NewJson([]byte(data)); err == nil {
+            p.jsonMap = js
+        }
+    }
+    return p
+}
+
+// GetMessage returns the message of page returned by MessagePage, or nil for other pages.
+func (this *Page) GetMessage() *Message {
+    return this.message
+}
+
+// SetEmitter sets function that outputs items and target requests of the page by Emit. Spider sets it for
+// "sse" and "websocket" pages.
+func (this *Page) SetEmitter(emitter func(p *Page)) *Page {
+    this.emitter = emitter
+    return this
+}
+
+// Emit outputs items and target requests added so far like the page is processed, and clears them, so
+// PageProcesser of a stream of messages outputs items while the stream is open. It does nothing if the
+// emitter is not set or nothing is added.
+func (this *Page) Emit() {
+    if this.emitter == nil || this.pItems.Len() == 0 && len(this.targetRequests) == 0 {
+        return
+    }
+    out := *this
+    out.bodyCloser, out.emitter = nil, nil
+    this.pItems = page_items.NewPageItems(this.req)
+    this.targetRequests = nil
+    this.emitter(&out)
+}
@@ -0,0 +1,243 @@
+package page
+
+import (
+    "github.com/PuerkitoBio/goquery"
+    "net/url"
+    "regexp"
+    "strings"
+)
+
+var mfRootReg = regexp.MustCompile("^h-[a-z0-9]+(-[a-z0-9]+)*$")
+var mfPropReg = regexp.MustCompile("^(p|u|dt|e)-([a-z0-9]+(-[a-z0-9]+)*)$")
+
+// Microformats parses microformats2 data (h-card, h-event, h-entry and so on) in the html result.
+// The result is like {"items": [{"type": ["h-card"], "properties": {"name": ["..."]}, "children": [...]}]}.
+// A nested microformat that is also a property is saved as the property value with an extra "value" key,
+// and a nested microformat without property class is saved in "children".
+func (this *Page) Microformats() map[string]interface{} {
+    items := make([]interface{}, 0)
+    if this.docParser != nil {
+        items = this.mfFindItems(this.docParser.Selection)
+    }
+    return map[string]interface{}{"items": items}
+}
+
+// The mfFindItems returns all top level microformat roots under the selection.
+func (this *Page) mfFindItems(s *goquery.Selection) []interface{} {
+    items := make([]interface{}, 0)
+    s.Children().Each(func(i int, c *goquery.Selection) {
+        if types, _ := mfClasses(c); len(types) > 0 {
+            items = append(items, this.mfParseItem(c, types))
+        } else {
+            items = append(items, this.mfFindItems(c)...)
+        }
+    })
+    return items
+}
+
+// The mfItem collects parsed result of one microformat root.
+// The prefixes records explicit property prefixes found, which is used by implied properties parsing.
+type mfItem struct {
+    properties map[string]interface{}
+    children   []interface{}
+    prefixes   map[string]bool
+}
+
+func (this *mfItem) add(name string, value interface{}) {
+    values, _ := this.properties[name].([]interface{})
+    this.properties[name] = append(values, value)
+}
+
+func (this *mfItem) has(name string) bool {
+    _, ok := this.properties[name]
+    return ok
+}
+
+// The mfParseItem parses one microformat root element.
+func (this *Page) mfParseItem(s *goquery.Selection, types []string) map[string]interface{} {
+    item := &mfItem{properties: make(map[string]interface{}), prefixes: make(map[string]bool)}
+    this.mfWalk(s, item)
+
+    // implied properties
+    if !item.has("name") && !item.prefixes["p"] && !item.prefixes["e"] {
+        item.add("name", mfImpliedName(s))
+    }
+    if !item.has("photo") {
+        if src, ok := mfImpliedAttr(s, "img", "src"); ok {
+            item.add("photo", this.mfResolveUrl(src))
+        }
+    }
+    if !item.has("url") {
+        if href, ok := mfImpliedAttr(s, "a", "href"); ok {
+            item.add("url", this.mfResolveUrl(href))
+        }
+    }
+
+    result := map[string]interface{}{"type": types, "properties": item.properties}
+    if len(item.children) > 0 {
+        result["children"] = item.children
+    }
+    return result
+}
+
+// The mfWalk collects properties of one microformat root from its descendants.
+func (this *Page) mfWalk(s *goquery.Selection, item *mfItem) {
+    s.Children().Each(func(i int, c *goquery.Selection) {
+        types, props := mfClasses(c)
+        if len(types) > 0 {
+            nested := this.mfParseItem(c, types)
+            if len(props) == 0 {
+                item.children = append(item.children, nested)
+                return
+            }
+            for _, prop := range props {
+                prefix, name := mfSplitProp(prop)
+                value := make(map[string]interface{})
+                for k, v := range nested {
+                    value[k] = v
+                }
+                value["value"] = this.mfNestedValue(c, nested, prefix)
+                item.add(name, value)
+                item.prefixes[prefix] = true
+            }
+            return
+        }
+        for _, prop := range props {
+            prefix, name := mfSplitProp(prop)
+            item.add(name, this.mfPropValue(c, prefix))
+            item.prefixes[prefix] = true
+        }
+        this.mfWalk(c, item)
+    })
+}
+
+// The mfPropValue parses value of one property element by its prefix.
+func (this *Page) mfPropValue(s *goquery.Selection, prefix string) interface{} {
+    tag := goquery.NodeName(s)
+    switch prefix {
+    case "u":
+        attrs := map[string]string{"a": "href", "area": "href", "link": "href", "img": "src",
+            "audio": "src", "video": "src", "source": "src", "iframe": "src", "object": "data"}
+        if attr, ok := attrs[tag]; ok {
+            if v, ok := s.Attr(attr); ok {
+                return this.mfResolveUrl(v)
+            }
+        }
+        if v, ok := mfValueAttr(s, tag); ok {
+            return v
+        }
+    case "dt":
+        if tag == "time" || tag == "ins" || tag == "del" {
+            if v, ok := s.Attr("datetime"); ok {
+                return v
+            }
+        }
+        if v, ok := mfValueAttr(s, tag); ok {
+            return v
+        }
+    case "e":
+        html, _ := s.Html()
+        return map[string]interface{}{"html": strings.TrimSpace(html), "value": mfText(s)}
+    default:
+        if v, ok := mfValueAttr(s, tag); ok {
+            return v
+        }
+    }
+    return mfText(s)
+}
+
+// The mfNestedValue returns the plain "value" of a nested microformat used as a property.
+func (this *Page) mfNestedValue(s *goquery.Selection, nested map[string]interface{}, prefix string) interface{} {
+    props := nested["properties"].(map[string]interface{})
+    key := "name"
+    if prefix == "u" {
+        key = "url"
+    }
+    if values, ok := props[key].([]interface{}); ok && len(values) > 0 {
+        if v, ok := values[0].(string); ok {
+            return v
+        }
+    }
+    return mfText(s)
+}
+
+// The mfResolveUrl resolves relative url against url of this page.
+func (this *Page) mfResolveUrl(ref string) string {
+    if this.req == nil {
+        return ref
+    }
+    base, err := url.Parse(this.req.GetUrl())
+    if err != nil {
+        return ref
+    }
+    u, err := url.Parse(strings.TrimSpace(ref))
+    if err != nil {
+        return ref
+    }
+    return base.ResolveReference(u).String()
+}
+
+// The mfClasses splits class attribute to microformat root types and property names.
+func mfClasses(s *goquery.Selection) ([]string, []string) {
+    var types, props []string
+    class, _ := s.Attr("class")
+    for _, c := range strings.Fields(class) {
+        if mfRootReg.MatchString(c) {
+            types = append(types, c)
+        } else if mfPropReg.MatchString(c) {
+            props = append(props, c)
+        }
+    }
+    return types, props
+}
+
+func mfSplitProp(prop string) (string, string) {
+    parts := strings.SplitN(prop, "-", 2)
+    return parts[0], parts[1]
+}
+
+// The mfValueAttr returns value of the attribute which represents the element value like img alt or abbr title.
+func mfValueAttr(s *goquery.Selection, tag string) (string, bool) {
+    switch tag {
+    case "img", "area":
+        return s.Attr("alt")
+    case "abbr", "acronym":
+        return s.Attr("title")
+    case "data", "input":
+        return s.Attr("value")
+    }
+    return "", false
+}
+
+// The mfImpliedName returns name of microformat root without explicit p-name.
+func mfImpliedName(s *goquery.Selection) string {
+    tag := goquery.NodeName(s)
+    if v, ok := mfValueAttr(s, tag); ok {
+        return v
+    }
+    children := s.Children()
+    if children.Length() == 1 {
+        if v, ok := mfValueAttr(children, goquery.NodeName(children)); ok {
+            return v
+        }
+    }
+    return mfText(s)
+}
+
+// The mfImpliedAttr returns attribute of the root itself or its only child with the tag name.
+func mfImpliedAttr(s *goquery.Selection, tag string, attr string) (string, bool) {
+    if goquery.NodeName(s) == tag {
+        return s.Attr(attr)
+    }
+    children := s.ChildrenFiltered(tag)
+    if children.Length() == 1 {
+        if types, _ := mfClasses(children); len(types) == 0 {
+            return children.Attr(attr)
+        }
+    }
+    return "", false
+}
+
+func mfText(s *goquery.Selection) string {
+    return strings.Join(strings.Fields(s.Text()), " ")
+}
@@ -0,0 +1,59 @@
+package page_test
+
+import (
+    "github.com/PuerkitoBio/goquery"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "strings"
+    "testing"
+)
+
+func newHtmlPage(url string, html string) *page.Page {
+    p := page.NewPage(request.NewRequest(url, "html"))
+    doc, _ := goquery.NewDocumentFromReader(strings.NewReader(html))
+    p.SetBodyStr(html).SetHtmlParser(doc)
+    return p
+}
+
+func TestMicroformats(t *testing.T) {
+    html := `<html><body>
+        <div class="h-card">
+            <a class="p-name u-url" href="/hu">Hu Cong</a>
+            <img class="u-photo" src="photo.png" alt="me"/>
+            <div class="p-org h-card"><span class="p-name">GO_SPIDER</span></div>
+        </div>
+        <div class="h-event"><span class="p-name">Release</span><time class="dt-start" datetime="2014-09-23">Sep 23</time></div>
+        <p class="h-card">Implied Name</p>
+    </body></html>`
+    p := newHtmlPage("http://example.com/about/", html)
+
+    items := p.Microformats()["items"].([]interface{})
+    if len(items) != 3 {
+        t.Fatalf("items count error: %d", len(items))
+    }
+
+    props := items[0].(map[string]interface{})["properties"].(map[string]interface{})
+    if props["name"].([]interface{})[0] != "Hu Cong" {
+        t.Error("name error")
+    }
+    if props["url"].([]interface{})[0] != "http://example.com/hu" {
+        t.Error("url error")
+    }
+    if props["photo"].([]interface{})[0] != "http://example.com/about/photo.png" {
+        t.Error("photo error")
+    }
+    org := props["org"].([]interface{})[0].(map[string]interface{})
+    if org["value"] != "GO_SPIDER" {
+        t.Error("nested value error")
+    }
+
+    props = items[1].(map[string]interface{})["properties"].(map[string]interface{})
+    if props["start"].([]interface{})[0] != "2014-09-23" {
+        t.Error("dt property error")
+    }
+
+    props = items[2].(map[string]interface{})["properties"].(map[string]interface{})
+    if props["name"].([]interface{})[0] != "Implied Name" {
+        t.Error("implied name error")
+    }
+}
@@ -0,0 +1,56 @@
+package page
+
+import (
+    "encoding/xml"
+    "golang.org/x/net/html"
+    "io"
+)
+
+// SetBodyReader saves reader of body for "stream" responce type, which is read while it is downloaded
+// instead of being buffered, and closer of the responce that CloseBody closes.
+func (this *Page) SetBodyReader(r io.Reader, closer io.Closer) *Page {
+    this.bodyReader = r
+    this.bodyCloser = closer
+    return this
+}
+
+// GetBodyReader returns reader of body changed to utf-8 for "stream" responce type, or nil for other types.
+// The body can be read once, before the page is processed, and reading over max body size of Downloader
+// fails unless the body is truncated.
+func (this *Page) GetBodyReader() io.Reader {
+    return this.bodyReader
+}
+
+// CloseBody closes the responce of "stream" responce type. Spider calls it after the page is processed,
+// so the body not read is dropped.
+func (this *Page) CloseBody() error {
+    if this.bodyCloser == nil {
+        return nil
+    }
+    closer := this.bodyCloser
+    this.bodyCloser = nil
+    return closer.Close()
+}
+
+// HtmlTokenizer returns html tokenizer reading body of "stream" responce type token by token, for html
+// documents too large to be parsed by GetHtmlParser. It returns nil for other types.
+func (this *Page) HtmlTokenizer() *html.Tokenizer {
+    if this.bodyReader == nil {
+        return nil
+    }
+    return html.NewTokenizer(this.bodyReader)
+}
+
+// XmlDecoder returns xml decoder reading body of "stream" responce type token by token, like elements of
+// a large export decoded one by one by DecodeElement. It returns nil for other types.
+func (this *Page) XmlDecoder() *xml.Decoder {
+    if this.bodyReader == nil {
+        return nil
+    }
+    decoder := xml.NewDecoder(this.bodyReader)
+    decoder.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+        // the body has been changed to utf-8 by Downloader
+        return input, nil
+    }
+    return decoder
+}
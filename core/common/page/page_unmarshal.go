@@ -0,0 +1,368 @@
+package page
+
+import (
+    "encoding/json"
+    "errors"
+    "github.com/PuerkitoBio/goquery"
+    "github.com/antchfx/htmlquery"
+    "net/url"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Unmarshal fills the struct pointed by v from the html or json result by tags of its exported fields:
+//
+//	Title  string    `css:"h1"`
+//	Url    string    `css:"a.more" attr:"href" conv:"url"`
+//	Price  float64   `css:".price" conv:"currency"`
+//	Date   time.Time `xpath:"//time/@datetime" layout:"2006-01-02"`
+//	Tags   []string  `css:".tag"`
+//	Offers []Offer   `css:".offer"`
+//	Stock  int       `jsonpath:"data.stock"`
+//
+// Tag "css" takes text of elements, or attribute "attr" of them where "html" is inner html; "." is the element
+// itself. Tag "xpath" takes text of nodes like GetXpathStrings, and "jsonpath" takes values at path of the
+// json result like GetJsonPath. Slices get all the values, and other fields the first one. Fields of struct,
+// pointer to struct and slice of struct are filled from each matched element or json value, by selectors
+// relative to it.
+// Values are converted to types of fields: strings are kept, ints, floats and bools are parsed, and
+// time.Time is parsed by "layout", default time.RFC3339. Tag "conv" converts values by "int", "float",
+// "bool", "number" (the first number in text like "1,024 reviews"), "currency" (price like "$1,299.00" or
+// "1.299,00 €") or "url" (absolute url of a relative link).
+// Fields whose values are not found keep their values. It returns error of the first invalid XPath expression
+// or value that can not be converted, or if v is not a pointer to struct.
+func (this *Page) Unmarshal(v interface{}) error {
+    rv := reflect.ValueOf(v)
+    if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+        return errors.New("unmarshal needs a pointer to struct")
+    }
+    scope := extractScope{base: this.GetFinalUrl()}
+    if this.docParser != nil {
+        scope.sel = this.docParser.Selection
+    }
+    if this.jsonMap != nil {
+        scope.json = this.jsonMap.Interface()
+    }
+    return scope.fill(rv.Elem())
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// The extractScope is html elements or json value that selectors of fields are relative to.
+type extractScope struct {
+    sel  *goquery.Selection
+    json interface{}
+    base string
+}
+
+func (this extractScope) fill(rv reflect.Value) error {
+    rt := rv.Type()
+    for i := 0; i < rt.NumField(); i++ {
+        f := rt.Field(i)
+        if f.PkgPath != "" || (f.Tag.Get("css") == "" && f.Tag.Get("xpath") == "" && f.Tag.Get("jsonpath") == "") {
+            continue
+        }
+        if err := this.fillField(rv.Field(i), f); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+func (this extractScope) fillField(fv reflect.Value, f reflect.StructField) error {
+    t := fv.Type()
+    slice := t.Kind() == reflect.Slice
+    elem := t
+    if slice {
+        elem = t.Elem()
+    }
+    ptr := elem.Kind() == reflect.Ptr
+    if ptr {
+        elem = elem.Elem()
+    }
+
+    if elem.Kind() == reflect.Struct && elem != timeType {
+        scopes, err := this.scopes(f)
+        if err != nil {
+            return errors.New("field " + f.Name + " : " + err.Error())
+        }
+        if !slice && len(scopes) > 1 {
+            scopes = scopes[:1]
+        }
+        values := reflect.MakeSlice(reflect.SliceOf(t), 0, len(scopes))
+        if slice {
+            values = reflect.MakeSlice(t, 0, len(scopes))
+        }
+        for _, scope := range scopes {
+            v := reflect.New(elem)
+            if err := scope.fill(v.Elem()); err != nil {
+                return err
+            }
+            if !ptr {
+                v = v.Elem()
+            }
+            values = reflect.Append(values, v)
+        }
+        setValues(fv, values, slice)
+        return nil
+    }
+
+    strs, err := this.values(f)
+    if err != nil {
+        return errors.New("field " + f.Name + " : " + err.Error())
+    }
+    if !slice && len(strs) > 1 {
+        strs = strs[:1]
+    }
+    values := reflect.MakeSlice(reflect.SliceOf(t), 0, len(strs))
+    if slice {
+        values = reflect.MakeSlice(t, 0, len(strs))
+    }
+    for _, s := range strs {
+        v, err := convertValue(s, elem, f.Tag.Get("conv"), f.Tag.Get("layout"), this.base)
+        if err != nil {
+            return errors.New("field " + f.Name + " : " + err.Error())
+        }
+        if ptr {
+            p := reflect.New(elem)
+            p.Elem().Set(v)
+            v = p
+        }
+        values = reflect.Append(values, v)
+    }
+    setValues(fv, values, slice)
+    return nil
+}
+
+// The setValues sets the field to the values if it is a slice, or else to the first value. The field is
+// kept if values is empty.
+func setValues(fv reflect.Value, values reflect.Value, slice bool) {
+    if values.Len() == 0 {
+        return
+    }
+    if slice {
+        fv.Set(values)
+    } else {
+        fv.Set(values.Index(0))
+    }
+}
+
+// The scopes returns scopes of elements or json values matched by selector of the field.
+func (this extractScope) scopes(f reflect.StructField) ([]extractScope, error) {
+    var scopes []extractScope
+    if expr := f.Tag.Get("jsonpath"); expr != "" {
+        value := jsonPath(this.json, splitJsonPath(expr))
+        if values, ok := value.([]interface{}); ok && f.Type.Kind() == reflect.Slice {
+            for _, v := range values {
+                scopes = append(scopes, extractScope{json: v, base: this.base})
+            }
+        } else if value != nil {
+            scopes = append(scopes, extractScope{json: value, base: this.base})
+        }
+        return scopes, nil
+    }
+    if this.sel == nil {
+        return nil, nil
+    }
+    if expr := f.Tag.Get("xpath"); expr != "" {
+        for _, node := range this.sel.Nodes {
+            nodes, err := htmlquery.QueryAll(node, expr)
+            if err != nil {
+                return nil, err
+            }
+            for _, n := range nodes {
+                scopes = append(scopes, extractScope{sel: this.sel.FindNodes(n), base: this.base})
+            }
+        }
+        return scopes, nil
+    }
+    this.find(f.Tag.Get("css")).Each(func(i int, s *goquery.Selection) {
+        scopes = append(scopes, extractScope{sel: s, base: this.base})
+    })
+    return scopes, nil
+}
+
+// The find returns elements of the css selector, or the scope itself for ".".
+func (this extractScope) find(selector string) *goquery.Selection {
+    if selector == "." {
+        return this.sel
+    }
+    return this.sel.Find(selector)
+}
+
+// The values returns strings of elements or json values matched by selector of the field.
+func (this extractScope) values(f reflect.StructField) ([]string, error) {
+    var strs []string
+    if expr := f.Tag.Get("jsonpath"); expr != "" {
+        value := jsonPath(this.json, splitJsonPath(expr))
+        if values, ok := value.([]interface{}); ok {
+            for _, v := range values {
+                if s, ok := jsonScalar(v); ok {
+                    strs = append(strs, s)
+                }
+            }
+        } else if s, ok := jsonScalar(value); ok {
+            strs = append(strs, s)
+        }
+        return strs, nil
+    }
+    if this.sel == nil {
+        return nil, nil
+    }
+    if expr := f.Tag.Get("xpath"); expr != "" {
+        for _, node := range this.sel.Nodes {
+            nodes, err := htmlquery.QueryAll(node, expr)
+            if err != nil {
+                return nil, err
+            }
+            for _, n := range nodes {
+                strs = append(strs, strings.TrimSpace(htmlquery.InnerText(n)))
+            }
+        }
+        return strs, nil
+    }
+    attr := f.Tag.Get("attr")
+    this.find(f.Tag.Get("css")).Each(func(i int, s *goquery.Selection) {
+        switch attr {
+        case "":
+            strs = append(strs, strings.TrimSpace(s.Text()))
+        case "html":
+            html, _ := s.Html()
+            strs = append(strs, strings.TrimSpace(html))
+        default:
+            if value, ok := s.Attr(attr); ok {
+                strs = append(strs, strings.TrimSpace(value))
+            }
+        }
+    })
+    return strs, nil
+}
+
+// The jsonScalar returns string of json string, number or bool.
+func jsonScalar(v interface{}) (string, bool) {
+    switch v := v.(type) {
+    case string:
+        return v, true
+    case json.Number:
+        return v.String(), true
+    case float64:
+        return strconv.FormatFloat(v, 'f', -1, 64), true
+    case bool:
+        return strconv.FormatBool(v), true
+    }
+    return "", false
+}
+
+var firstNumber = regexp.MustCompile(`-?\d[\d.,]*`)
+
+// The convertValue converts the string to value of type t by conv.
+func convertValue(s string, t reflect.Type, conv, layout, base string) (reflect.Value, error) {
+    v := reflect.New(t).Elem()
+    if t == timeType {
+        if layout == "" {
+            layout = time.RFC3339
+        }
+        tm, err := time.Parse(layout, s)
+        if err != nil {
+            return v, err
+        }
+        v.Set(reflect.ValueOf(tm))
+        return v, nil
+    }
+
+    s, err := ConvertText(s, conv, base)
+    if err != nil {
+        return v, err
+    }
+
+    switch t.Kind() {
+    case reflect.String:
+        v.SetString(s)
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+        n, err := strconv.ParseInt(cleanNumber(s), 10, t.Bits())
+        if err != nil {
+            return v, err
+        }
+        v.SetInt(n)
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+        n, err := strconv.ParseUint(cleanNumber(s), 10, t.Bits())
+        if err != nil {
+            return v, err
+        }
+        v.SetUint(n)
+    case reflect.Float32, reflect.Float64:
+        n, err := strconv.ParseFloat(cleanNumber(s), t.Bits())
+        if err != nil {
+            return v, err
+        }
+        v.SetFloat(n)
+    case reflect.Bool:
+        switch strings.ToLower(s) {
+        case "yes", "y", "on":
+            v.SetBool(true)
+        case "no", "n", "off", "":
+        default:
+            b, err := strconv.ParseBool(s)
+            if err != nil {
+                return v, err
+            }
+            v.SetBool(b)
+        }
+    default:
+        return v, errors.New("type " + t.String() + " is not supported")
+    }
+    return v, nil
+}
+
+// ConvertText converts the text by conv of Unmarshal: "number" returns the first number in it without
+// thousands separators, "currency" returns number of the price, and "url" returns absolute url of a link
+// relative to base. Other conv returns the text as it is.
+func ConvertText(s, conv, base string) (string, error) {
+    switch conv {
+    case "url":
+        if b, err := url.Parse(base); err == nil {
+            if u, err := b.Parse(s); err == nil {
+                s = u.String()
+            }
+        }
+    case "number":
+        n := firstNumber.FindString(s)
+        if n == "" {
+            return "", errors.New("no number in " + strconv.Quote(s))
+        }
+        s = strings.TrimRight(strings.Replace(n, ",", "", -1), ".")
+    case "currency":
+        return parseCurrency(s)
+    }
+    return s, nil
+}
+
+// The cleanNumber removes spaces, thousands separators "," and "_" of the number.
+func cleanNumber(s string) string {
+    return strings.NewReplacer(",", "", "_", "", " ", "").Replace(strings.TrimSpace(s))
+}
+
+// The parseCurrency returns number of the price, like "1299.00" of "$1,299.00" or "1.299,00 €". The last
+// "." or "," is decimal separator if it is followed by other than 3 digits, or both of them are used.
+func parseCurrency(s string) (string, error) {
+    n := firstNumber.FindString(s)
+    if n == "" {
+        return "", errors.New("no price in " + strconv.Quote(s))
+    }
+    n = strings.TrimRight(n, ".,")
+    dot, comma := strings.LastIndex(n, "."), strings.LastIndex(n, ",")
+    sep := dot
+    if comma > dot {
+        sep = comma
+    }
+    // a single separator after "0", like "0.500", is also decimal
+    decimal := sep >= 0 && (dot >= 0 && comma >= 0 || len(n)-sep-1 != 3 ||
+        strings.Count(n, n[sep:sep+1]) == 1 && strings.HasPrefix(strings.TrimPrefix(n, "-"), "0"))
+    if !decimal {
+        return strings.NewReplacer(".", "", ",", "").Replace(n), nil
+    }
+    return strings.NewReplacer(".", "", ",", "").Replace(n[:sep]) + "." + n[sep+1:], nil
+}
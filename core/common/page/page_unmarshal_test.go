@@ -0,0 +1,104 @@
+package page_test
+
+import (
+    "github.com/bitly/go-simplejson"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "reflect"
+    "testing"
+    "time"
+)
+
+type offer struct {
+    Seller string  `css:".seller"`
+    Price  float64 `css:".price" conv:"currency"`
+}
+
+type product struct {
+    Title    string    `css:"h1"`
+    Link     string    `css:"a.more" attr:"href" conv:"url"`
+    Price    float64   `css:"#price" conv:"currency"`
+    Reviews  int       `xpath:"//span[@class='reviews']" conv:"number"`
+    InStock  bool      `css:".stock" attr:"data-available"`
+    Date     time.Time `xpath:"//time/@datetime" layout:"2006-01-02"`
+    Tags     []string  `css:".tag"`
+    Offers   []offer   `css:".offer"`
+    Best     *offer    `xpath:"//div[@class='offer'][2]"`
+    Missing  string    `css:".missing"`
+    internal string    `css:"h1"`
+}
+
+func TestUnmarshal(t *testing.T) {
+    html := `<html><body>
+        <h1> Gopher </h1><a class="more" href="/gopher/more">more</a>
+        <span id="price">1.299,50 €</span><span class="reviews">1,024 reviews</span>
+        <span class="stock" data-available="yes"></span><time datetime="2014-09-23">Sep 23</time>
+        <span class="tag">toy</span><span class="tag">blue</span>
+        <div class="offer"><span class="seller">a</span><span class="price">$1,299.00</span></div>
+        <div class="offer"><span class="seller">b</span><span class="price">$999</span></div>
+    </body></html>`
+    p := newHtmlPage("http://example.com/shop/", html)
+
+    v := product{Missing: "kept"}
+    if err := p.Unmarshal(&v); err != nil {
+        t.Fatal(err)
+    }
+    want := product{
+        Title:   "Gopher",
+        Link:    "http://example.com/gopher/more",
+        Price:   1299.5,
+        Reviews: 1024,
+        InStock: true,
+        Date:    time.Date(2014, 9, 23, 0, 0, 0, 0, time.UTC),
+        Tags:    []string{"toy", "blue"},
+        Offers:  []offer{{"a", 1299}, {"b", 999}},
+        Best:    &offer{"b", 999},
+        Missing: "kept",
+    }
+    if !reflect.DeepEqual(v, want) {
+        t.Errorf("unmarshal error: %+v", v)
+    }
+
+    var bad struct {
+        Price int `css:"h1"`
+    }
+    if err := p.Unmarshal(&bad); err == nil {
+        t.Error("conversion error should be returned")
+    }
+    if err := p.Unmarshal(v); err == nil {
+        t.Error("non-pointer should be an error")
+    }
+}
+
+func TestUnmarshalJson(t *testing.T) {
+    js, err := simplejson.NewJson([]byte(`{"data": {"total": 2, "items": [
+        {"name": "a", "price": 1.5, "tags": ["x", "y"]},
+        {"name": "b", "price": 2}
+    ]}}`))
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := page.NewPage(request.NewRequest("http://a.com/api", "json"))
+    p.SetJson(js)
+
+    type item struct {
+        Name  string   `jsonpath:"name"`
+        Price float32  `jsonpath:"price"`
+        Tags  []string `jsonpath:"tags"`
+    }
+    var v struct {
+        Total int      `jsonpath:"data.total"`
+        Names []string `jsonpath:"data.items.#.name"`
+        Items []item   `jsonpath:"data.items"`
+    }
+    if err := p.Unmarshal(&v); err != nil {
+        t.Fatal(err)
+    }
+    if v.Total != 2 || !reflect.DeepEqual(v.Names, []string{"a", "b"}) {
+        t.Errorf("json value error: %+v", v)
+    }
+    if len(v.Items) != 2 || v.Items[0].Price != 1.5 || !reflect.DeepEqual(v.Items[0].Tags, []string{"x", "y"}) ||
+        v.Items[1].Name != "b" || v.Items[1].Tags != nil {
+        t.Errorf("json items error: %+v", v.Items)
+    }
+}
@@ -0,0 +1,48 @@
+package page
+
+import (
+    "errors"
+    "github.com/antchfx/htmlquery"
+    "golang.org/x/net/html"
+    "strings"
+)
+
+// The ErrNoHtml is returned by XPath queries of page that has no html result.
+var ErrNoHtml = errors.New("page has no html result")
+
+// GetXpathNodes returns nodes of the html result matched by the XPath expression, like "//div[@class='x']/a".
+// It queries the document parsed already for goquery, so nodes of an element can be wrapped by
+// GetHtmlParser().FindNodes. An attribute like "//a/@href" is returned as a node whose text is its value.
+func (this *Page) GetXpathNodes(expr string) ([]*html.Node, error) {
+    if this.docParser == nil || len(this.docParser.Nodes) == 0 {
+        return nil, ErrNoHtml
+    }
+    return htmlquery.QueryAll(this.docParser.Nodes[0], expr)
+}
+
+// GetXpathStrings returns trimmed text of nodes matched by the XPath expression, like "//a/@href" for
+// values of attributes or "//h2" for text of elements.
+func (this *Page) GetXpathStrings(expr string) ([]string, error) {
+    nodes, err := this.GetXpathNodes(expr)
+    if err != nil {
+        return nil, err
+    }
+    strs := make([]string, len(nodes))
+    for i, node := range nodes {
+        strs[i] = strings.TrimSpace(htmlquery.InnerText(node))
+    }
+    return strs, nil
+}
+
+// GetXpathString returns trimmed text of the first node matched by the XPath expression,
+// or empty string if no node is matched.
+func (this *Page) GetXpathString(expr string) (string, error) {
+    if this.docParser == nil || len(this.docParser.Nodes) == 0 {
+        return "", ErrNoHtml
+    }
+    node, err := htmlquery.Query(this.docParser.Nodes[0], expr)
+    if err != nil || node == nil {
+        return "", err
+    }
+    return strings.TrimSpace(htmlquery.InnerText(node)), nil
+}
@@ -0,0 +1,33 @@
+package page_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "reflect"
+    "testing"
+)
+
+func TestXpath(t *testing.T) {
+    p := newHtmlPage("http://example.com/", `<html><body>
+        <div class="x"><a href="/a">A</a><a href="/b"> B </a></div>
+        <div class="y"><a href="/c">C</a></div>
+    </body></html>`)
+
+    hrefs, err := p.GetXpathStrings("//div[@class='x']/a/@href")
+    if err != nil || !reflect.DeepEqual(hrefs, []string{"/a", "/b"}) {
+        t.Errorf("attribute query error: %v %v", hrefs, err)
+    }
+    if text, _ := p.GetXpathString("//div[@class='x']/a[2]"); text != "B" {
+        t.Errorf("text query error: %q", text)
+    }
+    nodes, err := p.GetXpathNodes("//div[@class='y']")
+    if err != nil || len(nodes) != 1 || p.GetHtmlParser().FindNodes(nodes...).Find("a").Text() != "C" {
+        t.Error("nodes should be wrapped by goquery")
+    }
+    if _, err = p.GetXpathNodes("//div["); err == nil {
+        t.Error("invalid expression should be error")
+    }
+    if _, err = page.NewPage(request.NewRequest("http://example.com/", "json")).GetXpathNodes("//a"); err != page.ErrNoHtml {
+        t.Error("page without html should be error")
+    }
+}
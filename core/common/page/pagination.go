@@ -0,0 +1,132 @@
+package page
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/url"
+    "strconv"
+    "strings"
+)
+
+// The PageIndexMeta is meta key of the page index of requests added by Pagination, which is an int.
+const PageIndexMeta = "page_index"
+
+// The Pagination follows pages of a listing, by the link of a "next page" element or by an url template with
+// the page number. Requests of the next page keep response type, callback, tag and meta of the current one, and
+// have the page index in meta PageIndexMeta, so the processer knows which page it is by PageIndex.
+type Pagination struct {
+    next     *LinkExtractor
+    template string
+    start    int
+    maxPages int
+    itemsSel string
+}
+
+// NewPagination returns Pagination that follows nothing until SetNextSelector or SetUrlTemplate is called.
+func NewPagination() *Pagination {
+    return &Pagination{start: 1}
+}
+
+// The SetNextSelector follows the link of the first element matched by the css selector, like "a.next" or
+// "a[rel=next]". Pagination stops at the page without it.
+func (this *Pagination) SetNextSelector(selector string) *Pagination {
+    this.next = NewLinkExtractor().SetSelector(selector, "href")
+    return this
+}
+
+// The SetUrlTemplate follows url of the template with "{page}" replaced by the next page number, like
+// "http://a.com/list?page={page}". The start is the number of the first page, usually 0 or 1.
+// Relative templates are resolved against the page url.
+func (this *Pagination) SetUrlTemplate(template string, start int) *Pagination {
+    this.template = template
+    this.start = start
+    return this
+}
+
+// The SetMaxPages limits how many pages are followed, including the first page. Default 0 is no limit,
+// so an url template is followed until a page has no item if SetItemSelector is set.
+func (this *Pagination) SetMaxPages(n int) *Pagination {
+    this.maxPages = n
+    return this
+}
+
+// The SetItemSelector sets css selector of items of the listing, like "div.result". Pages without any item
+// are the end of the listing, and their next page is not followed.
+func (this *Pagination) SetItemSelector(selector string) *Pagination {
+    this.itemsSel = selector
+    return this
+}
+
+// The PageIndex returns index of the page in the listing from 0, which is 0 for the first page and pages not
+// added by Pagination.
+func PageIndex(p *Page) int {
+    if index, ok := p.GetRequest().GetMeta(PageIndexMeta); ok {
+        switch v := index.(type) {
+        case int:
+            return v
+        case float64:
+            // meta restored from json
+            return int(v)
+        }
+    }
+    return 0
+}
+
+// The Requests returns requests of the pages of the url template up to max pages, for adding all
+// the pages at once when the number of pages is known.
+func (this *Pagination) Requests(respType string) []*request.Request {
+    var reqs []*request.Request
+    for index := 0; index < this.maxPages; index++ {
+        u := strings.Replace(this.template, "{page}", strconv.Itoa(this.start+index), -1)
+        reqs = append(reqs, request.NewRequest(u, respType).SetMeta(PageIndexMeta, index))
+    }
+    return reqs
+}
+
+// The Follow adds request of the next page to target requests of the page, and returns whether it is added.
+func (this *Pagination) Follow(p *Page) bool {
+    index := PageIndex(p) + 1
+    if this.maxPages > 0 && index >= this.maxPages {
+        return false
+    }
+    if this.itemsSel != "" {
+        if doc := p.GetHtmlParser(); doc == nil || doc.Find(this.itemsSel).Length() == 0 {
+            return false
+        }
+    }
+    next := this.nextUrl(p, index)
+    if next == "" {
+        return false
+    }
+    req := p.GetRequest()
+    target := request.NewRequest(next, req.GetResponceType()).SetReferer(req.GetUrl()).SetCallback(req.GetCallback()).
+        SetTag(req.GetTag())
+    for key, value := range req.GetMetas() {
+        target.SetMeta(key, value)
+    }
+    p.AddTargetRequestWithParams(target.SetMeta(PageIndexMeta, index))
+    return true
+}
+
+// The nextUrl returns url of the page of the index, or "" if there is none.
+func (this *Pagination) nextUrl(p *Page, index int) string {
+    if this.next != nil {
+        links := this.next.Extract(p)
+        if len(links) == 0 || links[0] == p.GetRequest().GetUrl() {
+            return ""
+        }
+        return links[0]
+    }
+    if this.template == "" {
+        return ""
+    }
+    next := strings.Replace(this.template, "{page}", strconv.Itoa(this.start+index), -1)
+    base, err := url.Parse(p.GetRequest().GetUrl())
+    if err != nil {
+        return next
+    }
+    u, err := base.Parse(next)
+    if err != nil {
+        return ""
+    }
+    return u.String()
+}
@@ -0,0 +1,64 @@
+package page_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "testing"
+)
+
+func TestPaginationNextSelector(t *testing.T) {
+    p := newHtmlPage("http://example.com/list", `<html><body><a class="next" href="/list?p=2">next</a></body></html>`)
+    p.GetRequest().SetMeta("category", "books")
+    pagination := page.NewPagination().SetNextSelector("a.next").SetMaxPages(3)
+    if !pagination.Follow(p) {
+        t.Fatal("next page not followed")
+    }
+    reqs := p.GetTargetRequests()
+    if len(reqs) != 1 || reqs[0].GetUrl() != "http://example.com/list?p=2" || reqs[0].GetReferer() != "http://example.com/list" {
+        t.Fatalf("next request error: %v", reqs)
+    }
+    if category, _ := reqs[0].GetMeta("category"); category != "books" {
+        t.Errorf("meta not kept: %v", category)
+    }
+
+    second := newHtmlPage(reqs[0].GetUrl(), `<html><body><a class="next" href="/list?p=3">next</a></body></html>`)
+    second.GetRequest().SetMeta(page.PageIndexMeta, 1)
+    if page.PageIndex(second) != 1 || !pagination.Follow(second) {
+        t.Fatal("second page not followed")
+    }
+    third := newHtmlPage("http://example.com/list?p=3", `<html><body><a class="next" href="/list?p=4">next</a></body></html>`)
+    third.GetRequest().SetMeta(page.PageIndexMeta, 2)
+    if pagination.Follow(third) {
+        t.Error("followed beyond max pages")
+    }
+
+    last := newHtmlPage("http://example.com/list?p=9", `<html><body></body></html>`)
+    if page.NewPagination().SetNextSelector("a.next").Follow(last) {
+        t.Error("followed page without next link")
+    }
+}
+
+func TestPaginationUrlTemplate(t *testing.T) {
+    pagination := page.NewPagination().SetUrlTemplate("/search?q=go&page={page}", 0).SetItemSelector("div.item")
+    p := newHtmlPage("http://example.com/search?q=go", `<html><body><div class="item">a</div></body></html>`)
+    if !pagination.Follow(p) {
+        t.Fatal("next page not followed")
+    }
+    req := p.GetTargetRequests()[0]
+    if req.GetUrl() != "http://example.com/search?q=go&page=1" {
+        t.Errorf("next url error: %s", req.GetUrl())
+    }
+    if index, _ := req.GetMeta(page.PageIndexMeta); index != 1 {
+        t.Errorf("page index error: %v", index)
+    }
+
+    empty := newHtmlPage(req.GetUrl(), `<html><body>no result</body></html>`)
+    empty.GetRequest().SetMeta(page.PageIndexMeta, 1)
+    if pagination.Follow(empty) {
+        t.Error("followed page without items")
+    }
+
+    reqs := page.NewPagination().SetUrlTemplate("http://example.com/p/{page}", 1).SetMaxPages(3).Requests("html")
+    if len(reqs) != 3 || reqs[2].GetUrl() != "http://example.com/p/3" {
+        t.Errorf("requests error: %v", reqs)
+    }
+}
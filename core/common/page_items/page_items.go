@@ -3,17 +3,25 @@
 package page_items
 
 import (
+    "encoding/json"
     "github.com/hu17889/go_spider/core/common/request"
+    "reflect"
+    "strconv"
+    "strings"
+    "sync"
 )
 
 // PageItems represents an entity save result parsed by PageProcesser and will be output at last.
+// Values can be strings or structured values like nested maps and slices, e.g. images and variants
+// of a product. PageItems is safe for concurrent use.
 type PageItems struct {
 
     // The req is Request object that contains the parsed result, which saved in PageItems.
     req *request.Request
 
     // The items is the container of parsed result.
-    items map[string]string
+    locker sync.RWMutex
+    items  map[string]interface{}
 
     // The skip represents whether send ResultItems to scheduler or not.
     skip bool
@@ -21,7 +29,7 @@ type PageItems struct {
 
 // NewPageItems returns initialized PageItems object.
 func NewPageItems(req *request.Request) *PageItems {
-    items := make(map[string]string)
+    items := make(map[string]interface{})
     return &PageItems{req: req, items: items}
 }
 
@@ -32,18 +40,152 @@ func (this *PageItems) GetRequest() *request.Request {
 
 // AddItem saves a KV result into PageItems.
 func (this *PageItems) AddItem(key string, item string) {
-    this.items[key] = item
+    this.SetValue(key, item)
 }
 
-// GetItem returns value of the key.
+// GetItem returns value of the key. Value that is not a string is returned as json.
 func (this *PageItems) GetItem(key string) (string, bool) {
+    this.locker.RLock()
     t, ok := this.items[key]
-    return t, ok
+    this.locker.RUnlock()
+    if !ok {
+        return "", false
+    }
+    return itemString(t), true
 }
 
-// GetAll returns all the KVs result.
+// GetAll returns a copy of all the KVs result. Values that are not strings are returned as json.
 func (this *PageItems) GetAll() map[string]string {
-    return this.items
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    all := make(map[string]string, len(this.items))
+    for key, item := range this.items {
+        all[key] = itemString(item)
+    }
+    return all
+}
+
+// SetAll replaces all the values with a copy of items.
+func (this *PageItems) SetAll(items map[string]interface{}) *PageItems {
+    this.locker.Lock()
+    this.items = make(map[string]interface{}, len(items))
+    for key, item := range items {
+        this.items[key] = item
+    }
+    this.locker.Unlock()
+    return this
+}
+
+// SetValue saves a value of any type into PageItems, like a nested map, a slice or a struct.
+// The value should not be modified after it is saved, for it is shared with Pipeline.
+func (this *PageItems) SetValue(key string, value interface{}) *PageItems {
+    this.locker.Lock()
+    this.items[key] = value
+    this.locker.Unlock()
+    return this
+}
+
+// AppendValue appends values to the slice of the key, like images of a product found one by one.
+// The value of the key becomes a []interface{} if it is not one.
+func (this *PageItems) AppendValue(key string, values ...interface{}) *PageItems {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    var list []interface{}
+    switch old := this.items[key].(type) {
+    case nil:
+    case []interface{}:
+        list = append(list, old...)
+    default:
+        list = append(list, old)
+    }
+    this.items[key] = append(list, values...)
+    return this
+}
+
+// Get returns value of the key as it is saved, and whether the key is saved.
+func (this *PageItems) Get(key string) (interface{}, bool) {
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    value, ok := this.items[key]
+    return value, ok
+}
+
+// GetValue returns value of the key as it is saved, like Get.
+func (this *PageItems) GetValue(key string) (interface{}, bool) {
+    return this.Get(key)
+}
+
+// GetPath returns value of the path of keys separated by ".", like "variants.0.price".
+// Numbers are indexes of slices. Only maps of string keys and slices are walked, not structs.
+func (this *PageItems) GetPath(path string) (interface{}, bool) {
+    keys := strings.Split(path, ".")
+    value, ok := this.Get(keys[0])
+    for _, key := range keys[1:] {
+        if !ok {
+            break
+        }
+        value, ok = walkValue(value, key)
+    }
+    return value, ok
+}
+
+// GetValues returns a copy of all the values as they are saved.
+func (this *PageItems) GetValues() map[string]interface{} {
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    values := make(map[string]interface{}, len(this.items))
+    for key, value := range this.items {
+        values[key] = value
+    }
+    return values
+}
+
+// Len returns number of the keys.
+func (this *PageItems) Len() int {
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    return len(this.items)
+}
+
+// Delete removes the value of the key.
+func (this *PageItems) Delete(key string) {
+    this.locker.Lock()
+    delete(this.items, key)
+    this.locker.Unlock()
+}
+
+// Merge saves values of other PageItems into this one.
+// If overwrite is false, the keys already in this PageItems keep their values.
+func (this *PageItems) Merge(other *PageItems, overwrite bool) *PageItems {
+    values := other.GetValues()
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    for key, value := range values {
+        if _, ok := this.items[key]; ok && !overwrite {
+            continue
+        }
+        this.items[key] = value
+    }
+    return this
+}
+
+// MarshalJSON returns json object of all the values.
+func (this *PageItems) MarshalJSON() ([]byte, error) {
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    return json.Marshal(this.items)
+}
+
+// UnmarshalJSON replaces all the values with the json object.
+func (this *PageItems) UnmarshalJSON(data []byte) error {
+    items := make(map[string]interface{})
+    if err := json.Unmarshal(data, &items); err != nil {
+        return err
+    }
+    this.locker.Lock()
+    this.items = items
+    this.locker.Unlock()
+    return nil
 }
 
 // SetSkip set skip true to make this page not to be processed by Pipeline.
@@ -56,3 +198,38 @@ func (this *PageItems) SetSkip(skip bool) *PageItems {
 func (this *PageItems) GetSkip() bool {
     return this.skip
 }
+
+// The itemString returns the string value, or json of other values.
+func itemString(item interface{}) string {
+    if s, ok := item.(string); ok {
+        return s
+    }
+    content, err := json.Marshal(item)
+    if err != nil {
+        return ""
+    }
+    return string(content)
+}
+
+// The walkValue returns the element of key in map of string keys, or of index in slice.
+func walkValue(value interface{}, key string) (interface{}, bool) {
+    v := reflect.ValueOf(value)
+    switch v.Kind() {
+    case reflect.Map:
+        if v.Type().Key().Kind() != reflect.String {
+            return nil, false
+        }
+        e := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+        if !e.IsValid() {
+            return nil, false
+        }
+        return e.Interface(), true
+    case reflect.Slice, reflect.Array:
+        i, err := strconv.Atoi(key)
+        if err != nil || i < 0 || i >= v.Len() {
+            return nil, false
+        }
+        return v.Index(i).Interface(), true
+    }
+    return nil, false
+}
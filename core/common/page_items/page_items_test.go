@@ -0,0 +1,110 @@
+package page_items_test
+
+import (
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "strconv"
+    "sync"
+    "testing"
+)
+
+func TestPageItemsValues(t *testing.T) {
+    items := page_items.NewPageItems(request.NewRequest("http://a.com/p/1", "html"))
+    items.AddItem("title", "shoe")
+    items.SetValue("variants", []map[string]interface{}{
+        {"size": "40", "price": 9.5},
+        {"size": "41", "price": 10.5},
+    })
+    items.AppendValue("images", "a.jpg")
+    items.AppendValue("images", "b.jpg", "c.jpg")
+
+    if title, _ := items.GetItem("title"); title != "shoe" {
+        t.Errorf("title should be shoe, not %s", title)
+    }
+    if images, _ := items.GetItem("images"); images != `["a.jpg","b.jpg","c.jpg"]` {
+        t.Errorf("images should be json of the slice, not %s", images)
+    }
+    if price, ok := items.GetPath("variants.1.price"); !ok || price != 10.5 {
+        t.Errorf("price of the second variant should be 10.5, not %v", price)
+    }
+    if _, ok := items.GetPath("variants.2.price"); ok {
+        t.Errorf("index out of range should not be found")
+    }
+
+    content, err := json.Marshal(items)
+    if err != nil {
+        t.Fatal(err)
+    }
+    other := page_items.NewPageItems(nil)
+    if err = json.Unmarshal(content, other); err != nil {
+        t.Fatal(err)
+    }
+    if size, _ := other.GetPath("variants.0.size"); size != "40" || other.Len() != 3 {
+        t.Errorf("items should be unmarshaled from %s", content)
+    }
+}
+
+func TestPageItemsConcurrent(t *testing.T) {
+    items := page_items.NewPageItems(request.NewRequest("http://a.com/", "html"))
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            for j := 0; j < 100; j++ {
+                items.AddItem(strconv.Itoa(i), strconv.Itoa(j))
+                items.AppendValue("all", j)
+                items.GetAll()
+            }
+        }(i)
+    }
+    wg.Wait()
+    if all, _ := items.GetValue("all"); len(all.([]interface{})) != 1000 {
+        t.Errorf("1000 values should be appended, not %d", len(all.([]interface{})))
+    }
+}
+
+func TestPageItemsCollection(t *testing.T) {
+    items := page_items.NewPageItems(request.NewRequest("http://a.com/p/1", "html"))
+    items.SetAll(map[string]interface{}{"title": "shoe", "price": 9.5})
+    if price, ok := items.Get("price"); !ok || price != 9.5 {
+        t.Errorf("price should be 9.5, not %v", price)
+    }
+    if _, ok := items.Get("size"); ok {
+        t.Error("size should not be found")
+    }
+
+    other := page_items.NewPageItems(nil)
+    other.SetAll(map[string]interface{}{"title": "boot", "size": "40"})
+    kept := page_items.NewPageItems(nil).Merge(items, true).Merge(other, false)
+    if title, _ := kept.Get("title"); title != "shoe" || kept.Len() != 3 {
+        t.Errorf("keys should be kept by merge without overwrite: %v", kept.GetValues())
+    }
+    overwritten := page_items.NewPageItems(nil).Merge(items, true).Merge(other, true)
+    if title, _ := overwritten.Get("title"); title != "boot" || overwritten.Len() != 3 {
+        t.Errorf("keys should be overwritten by merge: %v", overwritten.GetValues())
+    }
+    if size, _ := items.Get("size"); size != nil {
+        t.Error("merged items should not be changed")
+    }
+
+    overwritten.Delete("size")
+    overwritten.Delete("missing")
+    if _, ok := overwritten.Get("size"); ok || overwritten.Len() != 2 {
+        t.Errorf("size should be deleted: %v", overwritten.GetValues())
+    }
+
+    p := page.NewPage(request.NewRequest("http://a.com/p/2", "html"))
+    p.AddField("title", "shoe")
+    p.SetItems(map[string]interface{}{"sizes": []string{"40", "41"}})
+    if sizes, _ := p.GetPageItems().GetItem("sizes"); sizes != `["40","41"]` || p.GetPageItems().Len() != 2 {
+        t.Errorf("items should be saved by SetItems: %v", p.GetPageItems().GetValues())
+    }
+
+    items.SetAll(map[string]interface{}{"sku": "a1"})
+    if _, ok := items.Get("title"); ok || items.Len() != 1 {
+        t.Errorf("values should be replaced by SetAll: %v", items.GetValues())
+    }
+}
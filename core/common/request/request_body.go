@@ -0,0 +1,125 @@
+package request
+
+import (
+    "bytes"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "io/ioutil"
+    "mime"
+    "mime/multipart"
+    "net/http"
+    "net/textproto"
+    "net/url"
+    "path/filepath"
+    "sort"
+    "strings"
+)
+
+// FormFile is a file attached to multipart/form-data body by SetMultipart.
+type FormFile struct {
+    // The Field is name of the form field, like "upload".
+    Field string
+    // The FileName is file name sent to the server, like "report.pdf".
+    FileName string
+    // The ContentType is media type of the file, "application/octet-stream" if it is "".
+    ContentType string
+    Content     []byte
+}
+
+// NewFormFile returns FormFile of form field reading the file at path, whose media type is found by its
+// extension or by sniffing its content.
+func NewFormFile(field string, path string) (FormFile, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return FormFile{}, err
+    }
+    contentType := mime.TypeByExtension(filepath.Ext(path))
+    if contentType == "" {
+        contentType = http.DetectContentType(content)
+    }
+    return FormFile{Field: field, FileName: filepath.Base(path), ContentType: contentType, Content: content}, nil
+}
+
+// NewJSONPost returns POST request of "json" responce type whose body is json of v, with Content-Type
+// "application/json", like a call of a search api.
+func NewJSONPost(url string, v interface{}) (*Request, error) {
+    body, err := json.Marshal(v)
+    if err != nil {
+        return nil, err
+    }
+    return NewRequest(url, "json").SetMethod("POST").SetBody(string(body), "application/json"), nil
+}
+
+// NewFormPost returns POST request whose body is urlencoded form, like a search form of a site.
+func NewFormPost(url string, form url.Values, respType string) *Request {
+    return NewRequest(url, respType).SetForm(form)
+}
+
+// NewMultipartPost returns POST request whose body is multipart/form-data of fields and files, like an
+// upload form.
+func NewMultipartPost(url string, fields url.Values, files []FormFile, respType string) *Request {
+    return NewRequest(url, respType).SetMultipart(fields, files...)
+}
+
+// SetJSON sets json of v as body of the request, with Content-Type "application/json". The method is set
+// to "POST" if it is not set.
+func (this *Request) SetJSON(v interface{}) error {
+    body, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    if this.method == "" {
+        this.method = "POST"
+    }
+    this.SetBody(string(body), "application/json")
+    return nil
+}
+
+// SetMultipart sets multipart/form-data of fields and files as body of the request, with Content-Type of
+// its boundary. The method is set to "POST" if it is not set. Fields are written in order of their names
+// and the boundary is made from the content, so the same form has the same body and fingerprint.
+func (this *Request) SetMultipart(fields url.Values, files ...FormFile) *Request {
+    names := make([]string, 0, len(fields))
+    for name := range fields {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    hash := sha1.New()
+    for _, name := range names {
+        hash.Write([]byte(name + "=" + strings.Join(fields[name], "&") + "\n"))
+    }
+    for _, f := range files {
+        hash.Write([]byte(f.Field + ":" + f.FileName + ":" + f.ContentType + "\n"))
+        hash.Write(f.Content)
+    }
+
+    var body bytes.Buffer
+    w := multipart.NewWriter(&body)
+    // writing to a buffer does not fail
+    w.SetBoundary("go_spider" + hex.EncodeToString(hash.Sum(nil)))
+    for _, name := range names {
+        for _, value := range fields[name] {
+            w.WriteField(name, value)
+        }
+    }
+    for _, f := range files {
+        contentType := f.ContentType
+        if contentType == "" {
+            contentType = "application/octet-stream"
+        }
+        h := make(textproto.MIMEHeader)
+        h.Set("Content-Disposition", mime.FormatMediaType("form-data",
+            map[string]string{"name": f.Field, "filename": f.FileName}))
+        h.Set("Content-Type", contentType)
+        part, _ := w.CreatePart(h)
+        part.Write(f.Content)
+    }
+    w.Close()
+
+    if this.method == "" {
+        this.method = "POST"
+    }
+    return this.SetBody(body.String(), w.FormDataContentType())
+}
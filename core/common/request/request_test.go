@@ -0,0 +1,40 @@
+package request_test
+
+import (
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/http"
+    "strings"
+    "testing"
+)
+
+func TestMarshalJSONRedactsCredentials(t *testing.T) {
+    req := request.NewRequest("http://a.com/", "html").
+        SetBasicAuth("user", "s3cret").
+        SetHeader("Proxy-Authorization", "Basic s3cret").
+        SetHeader("Accept-Language", "en")
+    req.GetHeader()["cookie"] = []string{"session=s3cret"}
+    content, err := json.Marshal(req)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if strings.Contains(string(content), "s3cret") || strings.Contains(string(content), "dXNlcjpzM2NyZXQ") {
+        t.Errorf("credentials should not be serialized: %s", content)
+    }
+
+    restored := &request.Request{}
+    if err := json.Unmarshal(content, restored); err != nil {
+        t.Fatal(err)
+    }
+    if restored.GetHeader().Get("Accept-Language") != "en" || restored.GetHeader().Get("Authorization") != "" {
+        t.Errorf("other headers should be serialized: %v", restored.GetHeader())
+    }
+    if req.GetHeader().Get("Authorization") == "" || len(req.GetHeader()["cookie"]) != 1 {
+        t.Errorf("header of the request should not be changed: %v", req.GetHeader())
+    }
+
+    content, _ = json.Marshal(request.NewRequest("http://a.com/", "html").SetHeaders(http.Header{"Cookie": {"a=s3cret"}}))
+    if strings.Contains(string(content), "header") {
+        t.Errorf("header of only credentials should be omitted: %s", content)
+    }
+}
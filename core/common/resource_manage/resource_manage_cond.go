@@ -0,0 +1,69 @@
+package resource_manage
+
+import (
+    "sync"
+)
+
+// ResourceManageCond inherits the ResourceManage interface, and its resource limit can be changed while
+// resources are used, like concurrency of spider adjusted at runtime.
+type ResourceManageCond struct {
+    locker sync.Mutex
+    cond   *sync.Cond
+    capnum uint
+    used   uint
+}
+
+// NewResourceManageCond returns initialized ResourceManageCond object.
+// The num is the resource limit.
+func NewResourceManageCond(num uint) *ResourceManageCond {
+    this := &ResourceManageCond{capnum: num}
+    this.cond = sync.NewCond(&this.locker)
+    return this
+}
+
+// The GetOne apply for one resource.
+// If resource pool is empty, current coroutine will be blocked.
+func (this *ResourceManageCond) GetOne() {
+    this.locker.Lock()
+    for this.used >= this.capnum {
+        this.cond.Wait()
+    }
+    this.used++
+    this.locker.Unlock()
+}
+
+// The FreeOne free resource and return it to resource pool.
+func (this *ResourceManageCond) FreeOne() {
+    this.locker.Lock()
+    if this.used > 0 {
+        this.used--
+    }
+    this.locker.Unlock()
+    this.cond.Broadcast()
+}
+
+// The Has query for how many resource has been used.
+func (this *ResourceManageCond) Has() uint {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.used
+}
+
+// The Left query for how many resource left in the pool. It is 0 if more resources are used than the limit
+// after the limit is lowered.
+func (this *ResourceManageCond) Left() uint {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.used >= this.capnum {
+        return 0
+    }
+    return this.capnum - this.used
+}
+
+// The SetCapnum changes the resource limit. Resources used over a lowered limit are kept until they are freed.
+func (this *ResourceManageCond) SetCapnum(num uint) {
+    this.locker.Lock()
+    this.capnum = num
+    this.locker.Unlock()
+    this.cond.Broadcast()
+}
@@ -0,0 +1,57 @@
+package resource_manage
+
+import (
+    "sync"
+)
+
+// ResourceManageHost limits resources used by each key, like concurrent requests of each host of spider.
+// Unlike ResourceManage, TryGetOne does not block, so that requests of a busy host can be put aside
+// while requests of other hosts are crawled.
+type ResourceManageHost struct {
+    locker sync.Mutex
+    capnum uint
+    used   map[string]uint
+}
+
+// NewResourceManageHost returns initialized ResourceManageHost object.
+// The num is the resource limit of each key.
+func NewResourceManageHost(num uint) *ResourceManageHost {
+    return &ResourceManageHost{capnum: num, used: make(map[string]uint)}
+}
+
+// The TryGetOne apply for one resource of the key, and returns false if resources of the key are used up.
+func (this *ResourceManageHost) TryGetOne(key string) bool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.used[key] >= this.capnum {
+        return false
+    }
+    this.used[key]++
+    return true
+}
+
+// The FreeOne free resource of the key and return it to resource pool.
+func (this *ResourceManageHost) FreeOne(key string) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.used[key] <= 1 {
+        delete(this.used, key)
+        return
+    }
+    this.used[key]--
+}
+
+// The Has query for how many resource of the key has been used.
+func (this *ResourceManageHost) Has(key string) uint {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.used[key]
+}
+
+// The SetCapnum changes the resource limit of each key. Resources used over a lowered limit are kept
+// until they are freed.
+func (this *ResourceManageHost) SetCapnum(num uint) {
+    this.locker.Lock()
+    this.capnum = num
+    this.locker.Unlock()
+}
@@ -1,9 +1,9 @@
-//
 package resource_manage_test
 
 import (
     "github.com/hu17889/go_spider/core/common/resource_manage"
     "testing"
+    "time"
 )
 
 func TestResourceManage(t *testing.T) {
@@ -16,3 +16,46 @@ func TestResourceManage(t *testing.T) {
     mc.GetOne()
     println("incr")
 }
+
+func TestResourceManageCond(t *testing.T) {
+    mc := resource_manage.NewResourceManageCond(1)
+    mc.GetOne()
+    got := make(chan bool)
+    go func() {
+        mc.GetOne()
+        got <- true
+    }()
+    select {
+    case <-got:
+        t.Fatal("resource should not be got over the limit")
+    case <-time.After(50 * time.Millisecond):
+    }
+    mc.SetCapnum(2)
+    select {
+    case <-got:
+    case <-time.After(time.Second):
+        t.Fatal("resource should be got after the limit is raised")
+    }
+    if mc.Has() != 2 || mc.Left() != 0 {
+        t.Errorf("wrong usage: %d %d", mc.Has(), mc.Left())
+    }
+}
+
+func TestResourceManageHost(t *testing.T) {
+    mc := resource_manage.NewResourceManageHost(2)
+    if !mc.TryGetOne("a.com") || !mc.TryGetOne("a.com") || mc.TryGetOne("a.com") {
+        t.Fatal("a.com should have 2 resources")
+    }
+    if !mc.TryGetOne("b.com") || mc.Has("b.com") != 1 {
+        t.Error("b.com should not be limited by a.com")
+    }
+    mc.FreeOne("a.com")
+    if !mc.TryGetOne("a.com") {
+        t.Error("freed resource should be got again")
+    }
+    mc.SetCapnum(1)
+    mc.FreeOne("a.com")
+    if mc.TryGetOne("a.com") || mc.Has("a.com") != 1 {
+        t.Errorf("lowered limit error: %d", mc.Has("a.com"))
+    }
+}
@@ -0,0 +1,163 @@
+package trace
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strconv"
+    "time"
+)
+
+// The OTLPExporter sends spans to a collector by OTLP/HTTP in json, like Jaeger, Tempo or OpenTelemetry
+// Collector listening on port 4318.
+type OTLPExporter struct {
+    endpoint string
+    header   http.Header
+    client   *http.Client
+}
+
+// NewOTLPExporter returns OTLPExporter posting spans to the endpoint, like
+// "http://localhost:4318/v1/traces".
+func NewOTLPExporter(endpoint string) *OTLPExporter {
+    return &OTLPExporter{endpoint: endpoint, header: make(http.Header), client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// The SetHeader sets header of posts to the collector, like "Authorization" of a hosted backend.
+func (this *OTLPExporter) SetHeader(key, value string) *OTLPExporter {
+    this.header.Set(key, value)
+    return this
+}
+
+// The SetClient sets http client of posts to the collector, whose timeout is 10 seconds by default.
+func (this *OTLPExporter) SetClient(client *http.Client) *OTLPExporter {
+    this.client = client
+    return this
+}
+
+// Export posts the spans of the service to the collector.
+func (this *OTLPExporter) Export(service string, spans []*Span) error {
+    body, err := json.Marshal(encodeOTLP(service, spans))
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequest("POST", this.endpoint, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    for key, values := range this.header {
+        req.Header[key] = values
+    }
+    req.Header.Set("Content-Type", "application/json")
+    resp, err := this.client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode/100 != 2 {
+        return errors.New("otlp collector responds " + resp.Status)
+    }
+    return nil
+}
+
+// The otlpTraces is ExportTraceServiceRequest of OTLP in json.
+type otlpTraces struct {
+    ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+    Resource   otlpResource     `json:"resource"`
+    ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+    Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+    Scope otlpScope  `json:"scope"`
+    Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+    Name string `json:"name"`
+}
+
+type otlpSpan struct {
+    TraceId           string          `json:"traceId"`
+    SpanId            string          `json:"spanId"`
+    ParentSpanId      string          `json:"parentSpanId,omitempty"`
+    Name              string          `json:"name"`
+    Kind              int             `json:"kind"`
+    StartTimeUnixNano string          `json:"startTimeUnixNano"`
+    EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+    Attributes        []otlpAttribute `json:"attributes,omitempty"`
+    Status            otlpStatus      `json:"status"`
+}
+
+type otlpStatus struct {
+    Code    int    `json:"code,omitempty"`
+    Message string `json:"message,omitempty"`
+}
+
+type otlpAttribute struct {
+    Key   string                 `json:"key"`
+    Value map[string]interface{} `json:"value"`
+}
+
+// The span kinds and status code of OTLP.
+const (
+    otlpKindInternal = 1
+    otlpKindClient   = 3
+    otlpStatusError  = 2
+)
+
+// The encodeOTLP returns json value of OTLP/HTTP request of the spans of the service. Spans named "download"
+// are of client kind, and the others are internal.
+func encodeOTLP(service string, spans []*Span) interface{} {
+    out := make([]otlpSpan, 0, len(spans))
+    for _, span := range spans {
+        span.locker.Lock()
+        s := otlpSpan{TraceId: span.TraceID, SpanId: span.SpanID, ParentSpanId: span.ParentID, Name: span.Name,
+            Kind: otlpKindInternal, StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+            EndTimeUnixNano: strconv.FormatInt(span.End.UnixNano(), 10)}
+        for _, attr := range span.Attributes {
+            s.Attributes = append(s.Attributes, otlpAttr(attr.Key, attr.Value))
+        }
+        if span.Error != "" {
+            s.Status = otlpStatus{Code: otlpStatusError, Message: span.Error}
+        }
+        span.locker.Unlock()
+        if s.Name == "download" {
+            s.Kind = otlpKindClient
+        }
+        out = append(out, s)
+    }
+    return otlpTraces{ResourceSpans: []otlpResourceSpans{{
+        Resource:   otlpResource{Attributes: []otlpAttribute{otlpAttr("service.name", service)}},
+        ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "go_spider"}, Spans: out}},
+    }}}
+}
+
+// The otlpAttr returns attribute of OTLP whose value is typed by value.
+func otlpAttr(key string, value interface{}) otlpAttribute {
+    var v map[string]interface{}
+    switch value := value.(type) {
+    case string:
+        v = map[string]interface{}{"stringValue": value}
+    case bool:
+        v = map[string]interface{}{"boolValue": value}
+    case int:
+        v = map[string]interface{}{"intValue": strconv.Itoa(value)}
+    case int64:
+        v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+    case float64:
+        v = map[string]interface{}{"doubleValue": value}
+    case time.Duration:
+        v = map[string]interface{}{"stringValue": value.String()}
+    default:
+        b, _ := json.Marshal(value)
+        v = map[string]interface{}{"stringValue": string(b)}
+    }
+    return otlpAttribute{Key: key, Value: v}
+}
@@ -0,0 +1,305 @@
+// Package trace records spans of requests crawled by Spider, like queue wait, dns, connect, download,
+// process and each pipeline, and exports them to tracing backends of OpenTelemetry, like Jaeger, by
+// OTLPExporter.
+package trace
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    mrand "math/rand"
+    "net/http"
+    "sync"
+    "time"
+)
+
+var logger = mlog.Component("trace")
+
+// The Exporter sends ended spans to a tracing backend. It is called by one goroutine at a time.
+type Exporter interface {
+    Export(service string, spans []*Span) error
+}
+
+// The ExporterFunc uses a function as Exporter, like one collecting spans in tests.
+type ExporterFunc func(service string, spans []*Span) error
+
+func (this ExporterFunc) Export(service string, spans []*Span) error {
+    return this(service, spans)
+}
+
+// The Tracer starts spans of a service and passes them to its Exporter in batches when they are ended.
+type Tracer struct {
+    service  string
+    exporter Exporter
+
+    locker      sync.Mutex
+    batch       []*Span
+    batchSize   int
+    sampleRatio float64
+    propagate   bool
+
+    // The exportLocker keeps batches exported in order they are ended.
+    exportLocker sync.Mutex
+}
+
+// The defaultBatchSize is number of spans exported at once by default.
+const defaultBatchSize = 256
+
+// NewTracer returns Tracer of the service name, like "go_spider", whose spans are sent to the exporter.
+// All the traces are sampled by default.
+func NewTracer(service string, exporter Exporter) *Tracer {
+    return &Tracer{service: service, exporter: exporter, batchSize: defaultBatchSize, sampleRatio: 1}
+}
+
+// The GetService returns service name of the Tracer.
+func (this *Tracer) GetService() string {
+    return this.service
+}
+
+// The SetBatchSize sets number of ended spans exported at once, default 256. Spans left are exported by Flush.
+func (this *Tracer) SetBatchSize(n int) *Tracer {
+    if n < 1 {
+        n = 1
+    }
+    this.locker.Lock()
+    this.batchSize = n
+    this.locker.Unlock()
+    return this
+}
+
+// The SetSampleRatio sets ratio of traces recorded, from 0 to 1, like 0.01 for a large crawl. Spans of a
+// trace not sampled are not recorded.
+func (this *Tracer) SetSampleRatio(ratio float64) *Tracer {
+    this.locker.Lock()
+    this.sampleRatio = ratio
+    this.locker.Unlock()
+    return this
+}
+
+// The SetPropagation sets whether Inject adds W3C "traceparent" header of the span to requests, so
+// services crawled that are traced too join the trace. Default is false, as most sites are not ours.
+func (this *Tracer) SetPropagation(propagate bool) *Tracer {
+    this.locker.Lock()
+    this.propagate = propagate
+    this.locker.Unlock()
+    return this
+}
+
+// The Start starts span of the name as child of the span in ctx, or as root of a new trace if ctx has no
+// span, and returns ctx with the span. The span is nil if the trace is not sampled; methods of nil Span do
+// nothing, so it can be used the same way.
+func (this *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+    return this.StartAt(ctx, name, time.Now())
+}
+
+// The StartAt is Start of span that started at the time, like wait of a request since it is queued.
+func (this *Tracer) StartAt(ctx context.Context, name string, start time.Time) (context.Context, *Span) {
+    if this == nil {
+        return ctx, nil
+    }
+    if parent := SpanFromContext(ctx); parent != nil {
+        span := parent.StartChildAt(name, start)
+        return ContextWithSpan(ctx, span), span
+    }
+    this.locker.Lock()
+    sampled := this.sampleRatio >= 1 || this.sampleRatio > 0 && mrand.Float64() < this.sampleRatio
+    this.locker.Unlock()
+    if !sampled {
+        return ctx, nil
+    }
+    span := &Span{tracer: this, TraceID: newID(16), SpanID: newID(8), Name: name, Start: start}
+    return ContextWithSpan(ctx, span), span
+}
+
+// The end adds the ended span to the batch, and exports the batch if it is full.
+func (this *Tracer) end(span *Span) {
+    this.locker.Lock()
+    this.batch = append(this.batch, span)
+    if len(this.batch) < this.batchSize {
+        this.locker.Unlock()
+        return
+    }
+    batch := this.batch
+    this.batch = nil
+    this.locker.Unlock()
+    if err := this.export(batch); err != nil {
+        logger.Error("spans are not exported : "+err.Error(), mlog.F("spans", len(batch)))
+    }
+}
+
+func (this *Tracer) export(spans []*Span) error {
+    if this.exporter == nil || len(spans) == 0 {
+        return nil
+    }
+    this.exportLocker.Lock()
+    defer this.exportLocker.Unlock()
+    return this.exporter.Export(this.service, spans)
+}
+
+// The Flush exports the spans ended but not exported yet, which Spider calls when Run returns.
+func (this *Tracer) Flush() error {
+    if this == nil {
+        return nil
+    }
+    this.locker.Lock()
+    batch := this.batch
+    this.batch = nil
+    this.locker.Unlock()
+    return this.export(batch)
+}
+
+// The newID returns random id of n bytes in hex.
+func newID(n int) string {
+    b := make([]byte, n)
+    if _, err := rand.Read(b); err != nil {
+        mrand.Read(b)
+    }
+    return hex.EncodeToString(b)
+}
+
+// The Attribute is a key and value of a span, whose value is string, bool, int, int64 or float64.
+type Attribute struct {
+    Key   string
+    Value interface{}
+}
+
+// The Span is a timed operation of a trace, like download of a request.
+type Span struct {
+    tracer *Tracer
+
+    // The TraceID is 32 hex digits of the trace, and SpanID is 16 hex digits of the span.
+    TraceID  string
+    SpanID   string
+    ParentID string
+
+    Name  string
+    Start time.Time
+    End   time.Time
+
+    locker     sync.Mutex
+    Attributes []Attribute
+    // The Error is message of the error that failed the operation, or "".
+    Error string
+    ended bool
+}
+
+// The StartChild starts span of the name as child of the span. It returns nil if the span is nil.
+func (this *Span) StartChild(name string) *Span {
+    return this.StartChildAt(name, time.Now())
+}
+
+// The StartChildAt is StartChild of span that started at the time.
+func (this *Span) StartChildAt(name string, start time.Time) *Span {
+    if this == nil {
+        return nil
+    }
+    return &Span{tracer: this.tracer, TraceID: this.TraceID, SpanID: newID(8), ParentID: this.SpanID, Name: name,
+        Start: start}
+}
+
+// The SetAttribute sets attribute of the span, like "http.status_code" of a download.
+func (this *Span) SetAttribute(key string, value interface{}) *Span {
+    if this == nil {
+        return nil
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    for i, attr := range this.Attributes {
+        if attr.Key == key {
+            this.Attributes[i].Value = value
+            return this
+        }
+    }
+    this.Attributes = append(this.Attributes, Attribute{Key: key, Value: value})
+    return this
+}
+
+// The GetAttribute returns value of attribute of the key, or nil.
+func (this *Span) GetAttribute(key string) interface{} {
+    if this == nil {
+        return nil
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    for _, attr := range this.Attributes {
+        if attr.Key == key {
+            return attr.Value
+        }
+    }
+    return nil
+}
+
+// The SetError marks the span failed with the message. An empty message is ignored.
+func (this *Span) SetError(msg string) *Span {
+    if this == nil || msg == "" {
+        return this
+    }
+    this.locker.Lock()
+    this.Error = msg
+    this.locker.Unlock()
+    return this
+}
+
+// The Finish ends the span now and passes it to its Tracer. Spans are ended once; later calls do nothing.
+func (this *Span) Finish() {
+    if this == nil {
+        return
+    }
+    this.locker.Lock()
+    if this.ended {
+        this.locker.Unlock()
+        return
+    }
+    this.ended = true
+    this.End = time.Now()
+    this.locker.Unlock()
+    this.tracer.end(this)
+}
+
+// The Duration returns time from start to end of the span ended.
+func (this *Span) Duration() time.Duration {
+    return this.End.Sub(this.Start)
+}
+
+type spanKey struct{}
+
+// ContextWithSpan returns ctx with the span, which is parent of spans started by ctx. It returns ctx if
+// span is nil.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+    if span == nil {
+        return ctx
+    }
+    return context.WithValue(ctx, spanKey{}, span)
+}
+
+// SpanFromContext returns span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+    if ctx == nil {
+        return nil
+    }
+    span, _ := ctx.Value(spanKey{}).(*Span)
+    return span
+}
+
+// Start starts span of the name as child of the span in ctx and returns ctx with it, like spans of the
+// downloader inside download of Spider. It returns ctx and nil if ctx has no span.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+    span := SpanFromContext(ctx).StartChild(name)
+    return ContextWithSpan(ctx, span), span
+}
+
+// Inject sets W3C "traceparent" header of the span in ctx to the header if its Tracer propagates traces
+// by SetPropagation.
+func Inject(ctx context.Context, header http.Header) {
+    span := SpanFromContext(ctx)
+    if span == nil {
+        return
+    }
+    span.tracer.locker.Lock()
+    propagate := span.tracer.propagate
+    span.tracer.locker.Unlock()
+    if propagate {
+        header.Set("Traceparent", "00-"+span.TraceID+"-"+span.SpanID+"-01")
+    }
+}
@@ -0,0 +1,70 @@
+package trace_test
+
+import (
+    "context"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/trace"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestOTLPExporter(t *testing.T) {
+    var body map[string]interface{}
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+            t.Errorf("spans should be posted as json: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+        }
+        json.NewDecoder(r.Body).Decode(&body)
+    }))
+    defer ts.Close()
+
+    tracer := trace.NewTracer("crawler", trace.NewOTLPExporter(ts.URL+"/v1/traces"))
+    ctx, root := tracer.Start(context.Background(), "crawl")
+    _, child := trace.Start(ctx, "download")
+    child.SetAttribute("http.response.status_code", 500).SetError("http status 500").Finish()
+    root.Finish()
+    if err := tracer.Flush(); err != nil {
+        t.Fatal(err)
+    }
+
+    resource := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+    service := resource["resource"].(map[string]interface{})["attributes"].([]interface{})[0]
+    if service.(map[string]interface{})["value"].(map[string]interface{})["stringValue"] != "crawler" {
+        t.Errorf("service name should be resource attribute: %v", service)
+    }
+    spans := resource["scopeSpans"].([]interface{})[0].(map[string]interface{})["spans"].([]interface{})
+    if len(spans) != 2 {
+        t.Fatalf("spans should be exported: %v", spans)
+    }
+    download := spans[0].(map[string]interface{})
+    if download["name"] != "download" || download["parentSpanId"] != root.SpanID || download["traceId"] != root.TraceID ||
+        download["kind"] != float64(3) {
+        t.Errorf("download span should be client child of crawl: %v", download)
+    }
+    if download["status"].(map[string]interface{})["code"] != float64(2) {
+        t.Errorf("download span should be failed: %v", download["status"])
+    }
+    attr := download["attributes"].([]interface{})[0].(map[string]interface{})
+    if attr["value"].(map[string]interface{})["intValue"] != "500" {
+        t.Errorf("int attribute should be string of intValue: %v", attr)
+    }
+}
+
+func TestSampleRatio(t *testing.T) {
+    exported := 0
+    tracer := trace.NewTracer("crawler", trace.ExporterFunc(func(service string, spans []*trace.Span) error {
+        exported += len(spans)
+        return nil
+    })).SetSampleRatio(0)
+    ctx, span := tracer.Start(context.Background(), "crawl")
+    if span != nil || trace.SpanFromContext(ctx) != nil {
+        t.Fatal("trace should not be sampled")
+    }
+    _, child := trace.Start(ctx, "download")
+    child.SetAttribute("url.full", "http://example.com/").Finish()
+    tracer.Flush()
+    if exported != 0 {
+        t.Errorf("spans of trace not sampled should not be exported: %d", exported)
+    }
+}
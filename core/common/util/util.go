@@ -2,9 +2,14 @@
 package util
 
 import (
+    "hash/fnv"
+    "math/bits"
+    "net/url"
     "os"
     "regexp"
+    "sort"
     "strings"
+    "unicode"
 )
 
 // JsonpToJson modify jsonp string to json string
@@ -65,3 +70,210 @@ func IsNum(a string) bool {
     reg, _ := regexp.Compile("^\\d+$")
     return reg.MatchString(a)
 }
+
+// The NormalizeUrl returns a normalized form of url for comparing and keying.
+// Scheme and host are lowercased, default port and fragment are removed and query params are sorted.
+// The rawurl is returned unchanged if it can not be parsed.
+func NormalizeUrl(rawurl string) string {
+    u, err := url.Parse(strings.TrimSpace(rawurl))
+    if err != nil {
+        return rawurl
+    }
+    u.Scheme = strings.ToLower(u.Scheme)
+    u.Host = strings.ToLower(u.Host)
+    if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+        u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+    }
+    if u.Path == "" {
+        u.Path = "/"
+    }
+    u.Fragment = ""
+
+    query := u.Query()
+    keys := make([]string, 0, len(query))
+    for key := range query {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    parts := make([]string, 0, len(keys))
+    for _, key := range keys {
+        values := query[key]
+        sort.Strings(values)
+        for _, value := range values {
+            parts = append(parts, url.QueryEscape(key)+"="+url.QueryEscape(value))
+        }
+    }
+    u.RawQuery = strings.Join(parts, "&")
+    return u.String()
+}
+
+// The CanonicalizeUrl returns the canonical form of url for crawling it, which is the same resource.
+// Scheme and host are lowercased, default port and fragment are removed, empty path is "/", and percent-encoding
+// is normalized: unreserved characters are decoded and other escapes are uppercased. Query params keep their order.
+// The rawurl is returned unchanged if it can not be parsed.
+func CanonicalizeUrl(rawurl string) string {
+    u, err := url.Parse(strings.TrimSpace(rawurl))
+    if err != nil {
+        return rawurl
+    }
+    u.Scheme = strings.ToLower(u.Scheme)
+    u.Host = strings.ToLower(u.Host)
+    if (u.Scheme == "http" && strings.HasSuffix(u.Host, ":80")) || (u.Scheme == "https" && strings.HasSuffix(u.Host, ":443")) {
+        u.Host = u.Host[:strings.LastIndex(u.Host, ":")]
+    }
+    u.Fragment = ""
+    u.RawFragment = ""
+
+    path := normalizeEscapes(u.EscapedPath())
+    if path == "" && u.Host != "" {
+        path = "/"
+    }
+    u.RawQuery = normalizeEscapes(u.RawQuery)
+    // the path is set escaped, so that escapes like %2F are kept
+    if unescaped, err := url.PathUnescape(path); err == nil {
+        u.Path = unescaped
+        u.RawPath = path
+    }
+    return u.String()
+}
+
+// The normalizeEscapes decodes percent-encoded unreserved characters and uppercases other escapes.
+// Characters that should be escaped, like space, are escaped.
+func normalizeEscapes(s string) string {
+    const hex = "0123456789ABCDEF"
+    var b strings.Builder
+    for i := 0; i < len(s); i++ {
+        c := s[i]
+        if c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+            v := unhex(s[i+1])<<4 | unhex(s[i+2])
+            if isUnreserved(v) {
+                b.WriteByte(v)
+            } else {
+                b.WriteByte('%')
+                b.WriteByte(hex[v>>4])
+                b.WriteByte(hex[v&15])
+            }
+            i += 2
+            continue
+        }
+        if c <= ' ' || c >= 0x7f || c == '%' || c == '"' || c == '<' || c == '>' || c == '\\' || c == '^' || c == '`' ||
+            c == '{' || c == '|' || c == '}' {
+            b.WriteByte('%')
+            b.WriteByte(hex[c>>4])
+            b.WriteByte(hex[c&15])
+            continue
+        }
+        b.WriteByte(c)
+    }
+    return b.String()
+}
+
+func isHex(c byte) bool {
+    return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+    switch {
+    case '0' <= c && c <= '9':
+        return c - '0'
+    case 'a' <= c && c <= 'f':
+        return c - 'a' + 10
+    }
+    return c - 'A' + 10
+}
+
+// The isUnreserved tests whether the character is unreserved by RFC 3986, which is never needed to be escaped.
+func isUnreserved(c byte) bool {
+    return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+        c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// The SimHash returns 64 bits simhash of words of the text, so that similar texts have hashes of small hamming
+// distance. Words are lowercased, and text of CJK characters, which has no spaces, is split into bigrams.
+func SimHash(text string) uint64 {
+    weights := make(map[string]int)
+    for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+        return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+    }) {
+        runes := []rune(word)
+        if len(runes) < 2 || !unicode.Is(unicode.Han, runes[0]) {
+            weights[word]++
+            continue
+        }
+        for i := 0; i+1 < len(runes); i++ {
+            weights[string(runes[i:i+2])]++
+        }
+    }
+
+    var v [64]int
+    for word, weight := range weights {
+        h := fnv.New64a()
+        h.Write([]byte(word))
+        sum := h.Sum64()
+        for i := uint(0); i < 64; i++ {
+            if sum&(1<<i) != 0 {
+                v[i] += weight
+            } else {
+                v[i] -= weight
+            }
+        }
+    }
+    var hash uint64
+    for i := uint(0); i < 64; i++ {
+        if v[i] > 0 {
+            hash |= 1 << i
+        }
+    }
+    return hash
+}
+
+// The HammingDistance returns number of different bits of a and b, like distance of two simhashes.
+func HammingDistance(a, b uint64) int {
+    return bits.OnesCount64(a ^ b)
+}
+
+// The ExpandTemplate returns urls generated by substituting every combination of vars into the template.
+// The variable is written in template like "http://a.com/list?cate={cate}&page={page}".
+// Values are substituted as they are, so they should be escaped by caller if necessary.
+// Duplicate urls are removed, and at most limit urls are returned when limit is larger than 0.
+func ExpandTemplate(template string, vars map[string][]string, limit int) []string {
+    names := make([]string, 0, len(vars))
+    for name, values := range vars {
+        if len(values) == 0 {
+            return nil
+        }
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    var urls []string
+    seen := make(map[string]bool)
+    indexes := make([]int, len(names))
+    for {
+        pairs := make([]string, 0, 2*len(names))
+        for i, name := range names {
+            pairs = append(pairs, "{"+name+"}", vars[name][indexes[i]])
+        }
+        u := strings.NewReplacer(pairs...).Replace(template)
+        if !seen[u] {
+            seen[u] = true
+            urls = append(urls, u)
+            if limit > 0 && len(urls) >= limit {
+                return urls
+            }
+        }
+
+        // next combination
+        i := len(names) - 1
+        for ; i >= 0; i-- {
+            indexes[i]++
+            if indexes[i] < len(vars[names[i]]) {
+                break
+            }
+            indexes[i] = 0
+        }
+        if i < 0 {
+            return urls
+        }
+    }
+}
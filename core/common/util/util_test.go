@@ -0,0 +1,68 @@
+//
+package util_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/util"
+    "strings"
+    "testing"
+)
+
+func TestNormalizeUrl(t *testing.T) {
+    cases := map[string]string{
+        "HTTP://Example.COM:80/a?b=2&a=1#frag": "http://example.com/a?a=1&b=2",
+        "https://example.com:443":              "https://example.com/",
+        "http://example.com:8080/a":            "http://example.com:8080/a",
+    }
+    for in, out := range cases {
+        if r := util.NormalizeUrl(in); r != out {
+            t.Errorf("NormalizeUrl(%s) = %s, want %s", in, r, out)
+        }
+    }
+}
+
+func TestCanonicalizeUrl(t *testing.T) {
+    cases := map[string]string{
+        "HTTP://Example.COM:80/a?b=2&a=1#frag": "http://example.com/a?b=2&a=1",
+        "https://example.com:443":              "https://example.com/",
+        "http://a.com/%7euser/%e4%b8%ad%2f":    "http://a.com/~user/%E4%B8%AD%2F",
+        "http://a.com/a b?q=x y&r=%41":         "http://a.com/a%20b?q=x%20y&r=A",
+    }
+    for in, out := range cases {
+        if r := util.CanonicalizeUrl(in); r != out {
+            t.Errorf("CanonicalizeUrl(%s) = %s, want %s", in, r, out)
+        }
+    }
+}
+
+func TestSimHash(t *testing.T) {
+    text := strings.Repeat("go spider is a concurrent crawler framework written in golang with pluggable modules ", 3) +
+        "it has downloader scheduler page processer and pipeline modules that can be replaced by your own ones " +
+        "pages are downloaded concurrently and parsed results are saved by pipelines into files or databases"
+    a := util.SimHash(text + " posted today")
+    b := util.SimHash(text + " posted yesterday")
+    c := util.SimHash("the quick brown fox jumps over the lazy dog near the river bank in the morning sun")
+    if d := util.HammingDistance(a, b); d > 3 {
+        t.Errorf("similar texts should have near simhashes: %d", d)
+    }
+    if d := util.HammingDistance(a, c); d <= 3 {
+        t.Errorf("different texts should have far simhashes: %d", d)
+    }
+}
+
+func TestExpandTemplate(t *testing.T) {
+    vars := map[string][]string{"id": {"1", "2", "2"}, "page": {"a", "b"}}
+    urls := util.ExpandTemplate("http://a.com/{id}?p={page}", vars, 0)
+    if len(urls) != 4 {
+        t.Fatalf("urls count error: %v", urls)
+    }
+    if urls[0] != "http://a.com/1?p=a" || urls[3] != "http://a.com/2?p=b" {
+        t.Errorf("urls error: %v", urls)
+    }
+
+    if urls = util.ExpandTemplate("http://a.com/{id}?p={page}", vars, 3); len(urls) != 3 {
+        t.Errorf("limit error: %v", urls)
+    }
+    if urls = util.ExpandTemplate("http://a.com/{id}", map[string][]string{"id": {}}, 0); len(urls) != 0 {
+        t.Errorf("empty values error: %v", urls)
+    }
+}
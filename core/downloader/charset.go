@@ -0,0 +1,111 @@
+package downloader
+
+import (
+    "bytes"
+    "golang.org/x/net/html/charset"
+    "golang.org/x/text/encoding"
+    "regexp"
+    "strings"
+    "unicode/utf8"
+)
+
+// The DefaultCharsetCandidates are charsets tried in order when charset of a page is not declared
+// and the body is not utf-8.
+var DefaultCharsetCandidates = []string{"gb18030", "big5", "shift_jis", "euc-kr", "windows-1252"}
+
+// The charsetSniffSize is how many bytes of body are read for declared charset and sniffing.
+const charsetSniffSize = 4096
+
+var metaCharsetReg = regexp.MustCompile(`(?i)<meta[^>]*charset\s*=\s*["']?\s*([a-z0-9_:.\-]+)`)
+var xmlEncodingReg = regexp.MustCompile(`(?i)^\s*<\?xml[^>]*encoding\s*=\s*["']([a-z0-9_:.\-]+)`)
+
+// The SetCharsetCandidates sets charsets tried in order when charset of a page is not declared and the body
+// is not utf-8, like "gb18030", "big5" or "shift_jis". The one decoding the body to most common characters
+// is used. Default is DefaultCharsetCandidates.
+func (this *HttpDownloader) SetCharsetCandidates(names ...string) *HttpDownloader {
+    this.charsetCandidates = names
+    return this
+}
+
+// The detectCharset returns encoding of the body and its name. The charset of Content-Type header is used first,
+// then byte order mark, meta tag of html and encoding of xml declaration. If charset is not declared, utf-8
+// is used if the body is valid utf-8, or the best of candidates is chosen by sniffing.
+func (this *HttpDownloader) detectCharset(headerCharset string, body []byte) (encoding.Encoding, string) {
+    if e, name := charset.Lookup(strings.Trim(headerCharset, `"' `)); e != nil {
+        return e, name
+    }
+    head := body
+    if len(head) > charsetSniffSize {
+        head = head[:charsetSniffSize]
+    }
+    switch {
+    case bytes.HasPrefix(head, []byte("\xef\xbb\xbf")):
+        return charset.Lookup("utf-8")
+    case bytes.HasPrefix(head, []byte("\xfe\xff")):
+        return charset.Lookup("utf-16be")
+    case bytes.HasPrefix(head, []byte("\xff\xfe")):
+        return charset.Lookup("utf-16le")
+    }
+    for _, reg := range []*regexp.Regexp{metaCharsetReg, xmlEncodingReg} {
+        if m := reg.FindSubmatch(head); m != nil {
+            if e, name := charset.Lookup(string(m[1])); e != nil {
+                return e, name
+            }
+        }
+    }
+    if utf8.Valid(body) {
+        return charset.Lookup("utf-8")
+    }
+
+    candidates := this.charsetCandidates
+    if len(candidates) == 0 {
+        candidates = DefaultCharsetCandidates
+    }
+    var best encoding.Encoding
+    var bestName string
+    bestScore := 0
+    for _, label := range candidates {
+        e, name := charset.Lookup(label)
+        if e == nil {
+            continue
+        }
+        decoded, err := e.NewDecoder().Bytes(head)
+        if err != nil {
+            continue
+        }
+        if score := charsetScore(decoded); best == nil || score > bestScore {
+            best, bestName, bestScore = e, name, score
+        }
+    }
+    if best == nil {
+        return charset.Lookup("utf-8")
+    }
+    return best, bestName
+}
+
+// The charsetScore scores text decoded by a charset by its characters. Characters of common scripts get
+// points by bytes they usually take, and replacement and control characters lose points.
+// Other characters, like half width kana of wrong decoding, get no point.
+func charsetScore(text []byte) int {
+    score := 0
+    for _, r := range string(text) {
+        switch {
+        case r == utf8.RuneError:
+            score -= 8
+        case r == '\t' || r == '\n' || r == '\r' || r >= 0x20 && r < 0x7f:
+            score += 1
+        case r >= 0x3040 && r <= 0x30ff:
+            // kana, which japanese text always has but chinese text decoded wrongly seldom has
+            score += 3
+        case r >= 0x4e00 && r <= 0x9fff, r >= 0x3000 && r <= 0x303f, r >= 0xac00 && r <= 0xd7af, r >= 0xff01 && r <= 0xff5e:
+            // CJK ideographs, punctuation, hangul and full width forms
+            score += 2
+        case r >= 0xc0 && r <= 0xff:
+            // latin letters with accents
+            score += 1
+        case r < 0x20 || r >= 0x7f && r < 0xa0:
+            score -= 4
+        }
+    }
+    return score
+}
@@ -0,0 +1,90 @@
+package downloader
+
+import (
+    "bufio"
+    "compress/flate"
+    "compress/gzip"
+    "compress/zlib"
+    "errors"
+    "github.com/andybalholm/brotli"
+    "io"
+    "net/http"
+    "strings"
+)
+
+// The acceptEncoding is Accept-Encoding header sent by HttpDownloader.
+const acceptEncoding = "gzip, deflate, br"
+
+// The SetCompression sets whether requests without Accept-Encoding header are sent with Accept-Encoding
+// "gzip, deflate, br", and responce body is decompressed by its Content-Encoding. If it is false, only gzip
+// body of requests without Accept-Encoding is decompressed by net/http. Default is true.
+func (this *HttpDownloader) SetCompression(enable bool) *HttpDownloader {
+    this.disableCompression = !enable
+    return this
+}
+
+// The decompressedBody closes the decoder and the responce body.
+type decompressedBody struct {
+    io.Reader
+    decoder io.Closer
+    body    io.Closer
+}
+
+func (this *decompressedBody) Close() error {
+    if this.decoder != nil {
+        this.decoder.Close()
+    }
+    return this.body.Close()
+}
+
+// The decompress replaces body of the responce with decompressed body by its Content-Encoding.
+// Content-Encoding and Content-Length are removed, for they are of the compressed body.
+// Unknown encoding is kept as it is.
+func decompress(resp *http.Response) error {
+    encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+    if encoding == "" || encoding == "identity" || resp.Request.Method == "HEAD" {
+        return nil
+    }
+    var reader io.Reader
+    var decoder io.Closer
+    switch encoding {
+    case "gzip", "x-gzip":
+        // empty body of 204 or 304 is not gzip
+        buffered := bufio.NewReader(resp.Body)
+        if _, err := buffered.Peek(1); err == io.EOF {
+            return nil
+        }
+        r, err := gzip.NewReader(buffered)
+        if err != nil {
+            return errors.New("gzip body is broken : " + err.Error())
+        }
+        reader, decoder = r, r
+    case "deflate":
+        // deflate should be zlib format, but some servers send raw deflate
+        buffered := bufio.NewReader(resp.Body)
+        header, err := buffered.Peek(2)
+        if err == io.EOF && len(header) == 0 {
+            return nil
+        }
+        if len(header) == 2 && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+            r, err := zlib.NewReader(buffered)
+            if err != nil {
+                return errors.New("deflate body is broken : " + err.Error())
+            }
+            reader, decoder = r, r
+        } else {
+            r := flate.NewReader(buffered)
+            reader, decoder = r, r
+        }
+    case "br":
+        reader = brotli.NewReader(resp.Body)
+    default:
+        return nil
+    }
+    resp.Body = &decompressedBody{Reader: reader, decoder: decoder, body: resp.Body}
+    resp.Header.Del("Content-Encoding")
+    resp.Header.Del("Content-Length")
+    resp.ContentLength = -1
+    resp.Uncompressed = true
+    return nil
+}
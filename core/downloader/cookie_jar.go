@@ -0,0 +1,136 @@
+package downloader
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/cookiejar"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "sync"
+    "time"
+)
+
+// The CookieJar is http.CookieJar of package net/http/cookiejar, whose cookies can be added before crawl
+// and saved in a file for reusing the session later.
+// Cookies are sent to the domain and path that set them, like a browser does.
+type CookieJar struct {
+    jar *cookiejar.Jar
+
+    // The cookies saves cookies set by each url, for saving the jar.
+    locker  sync.Mutex
+    cookies map[string]map[string]*http.Cookie
+}
+
+// The savedCookie is a cookie in the file saved by CookieJar, with the url that set it.
+type savedCookie struct {
+    Url    string       `json:"url"`
+    Cookie *http.Cookie `json:"cookie"`
+}
+
+func NewCookieJar() *CookieJar {
+    jar, _ := cookiejar.New(nil)
+    return &CookieJar{jar: jar, cookies: make(map[string]map[string]*http.Cookie)}
+}
+
+// The SetCookies saves cookies of the responce of u. It implements http.CookieJar.
+func (this *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+    this.jar.SetCookies(u, cookies)
+
+    // the directory of url path is the default path of cookies, which is kept when the url is loaded
+    dir := path.Dir(u.EscapedPath())
+    if dir == "." || dir == "/" {
+        dir = ""
+    }
+    key := u.Scheme + "://" + u.Host + dir + "/"
+    now := time.Now()
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    for _, c := range cookies {
+        name := c.Name + ";" + c.Domain + ";" + c.Path
+        if c.MaxAge < 0 || (!c.Expires.IsZero() && !c.Expires.After(now)) {
+            delete(this.cookies[key], name)
+            continue
+        }
+        if this.cookies[key] == nil {
+            this.cookies[key] = make(map[string]*http.Cookie)
+        }
+        saved := *c
+        if c.MaxAge > 0 {
+            // the file may be loaded later, so max age is saved as expires
+            saved.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+            saved.MaxAge = 0
+        }
+        this.cookies[key][name] = &saved
+    }
+}
+
+// The Cookies returns cookies to send in request of u. It implements http.CookieJar.
+func (this *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+    return this.jar.Cookies(u)
+}
+
+// The AddCookie adds the cookie as if it is set by the responce of rawurl, like a session cookie copied
+// from the browser. Cookie without Domain is sent to the host of rawurl only.
+func (this *CookieJar) AddCookie(rawurl string, c *http.Cookie) error {
+    u, err := url.Parse(rawurl)
+    if err != nil {
+        return err
+    }
+    this.SetCookies(u, []*http.Cookie{c})
+    return nil
+}
+
+// The Save writes cookies that are not expired to the file as json, session cookies included.
+func (this *CookieJar) Save(file string) error {
+    now := time.Now()
+    var saved []savedCookie
+    this.locker.Lock()
+    for u, cookies := range this.cookies {
+        for _, c := range cookies {
+            if c.Expires.IsZero() || c.Expires.After(now) {
+                saved = append(saved, savedCookie{Url: u, Cookie: c})
+            }
+        }
+    }
+    this.locker.Unlock()
+
+    // write to temp file first so that a broken file is never read.
+    tmp, err := ioutil.TempFile(filepath.Dir(file), "tmp")
+    if err != nil {
+        return err
+    }
+    err = json.NewEncoder(tmp).Encode(saved)
+    tmp.Close()
+    if err == nil {
+        err = os.Rename(tmp.Name(), file)
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+    }
+    return err
+}
+
+// The Load adds cookies in the file saved by Save.
+func (this *CookieJar) Load(file string) error {
+    f, err := os.Open(file)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    var saved []savedCookie
+    if err = json.NewDecoder(f).Decode(&saved); err != nil {
+        return err
+    }
+    for _, s := range saved {
+        if s.Cookie == nil {
+            continue
+        }
+        if err := this.AddCookie(s.Url, s.Cookie); err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,114 @@
+package downloader_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "testing"
+)
+
+func TestCookieJar(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/login":
+            http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+            http.SetCookie(w, &http.Cookie{Name: "remember", Value: "r1", Path: "/", MaxAge: 3600})
+        case "/logout":
+            http.SetCookie(w, &http.Cookie{Name: "remember", Path: "/", MaxAge: -1})
+        }
+        // cookies are sorted, for loaded cookies are sent in any order
+        var values []string
+        for _, c := range r.Cookies() {
+            values = append(values, c.Name+"="+c.Value+";")
+        }
+        sort.Strings(values)
+        w.Write([]byte(strings.Join(values, "")))
+    }))
+    defer ts.Close()
+
+    jar := downloader.NewCookieJar()
+    if err := jar.AddCookie(ts.URL, &http.Cookie{Name: "seed", Value: "x"}); err != nil {
+        t.Fatal(err)
+    }
+    dl := downloader.NewHttpDownloader().SetCookieJar(jar)
+    dl.Download(request.NewRequest(ts.URL+"/login", "text"))
+    if p := dl.Download(request.NewRequest(ts.URL+"/page", "text")); p.GetBodyStr() != "remember=r1;seed=x;session=s1;" {
+        t.Errorf("cookies should be sent: %s", p.GetBodyStr())
+    }
+
+    dir, err := ioutil.TempDir("", "go_spider_cookie")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "cookies.json")
+    dl.Download(request.NewRequest(ts.URL+"/logout", "text"))
+    if err = jar.Save(path); err != nil {
+        t.Fatal(err)
+    }
+
+    loaded := downloader.NewCookieJar()
+    if err = loaded.Load(path); err != nil {
+        t.Fatal(err)
+    }
+    dl = downloader.NewHttpDownloader().SetCookieJar(loaded)
+    if p := dl.Download(request.NewRequest(ts.URL+"/page", "text")); p.GetBodyStr() != "seed=x;session=s1;" {
+        t.Errorf("cookies should be loaded: %s", p.GetBodyStr())
+    }
+}
+
+func TestSessions(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/login" {
+            http.SetCookie(w, &http.Cookie{Name: "user", Value: r.URL.Query().Get("user"), Path: "/"})
+        }
+        var user string
+        if c, err := r.Cookie("user"); err == nil {
+            user = c.Value
+        }
+        w.Write([]byte(user + "|" + r.Header.Get("User-Agent") + "|" + r.Header.Get("X-Token")))
+    }))
+    defer ts.Close()
+
+    dl := downloader.NewHttpDownloader().SetCookieJar(downloader.NewCookieJar()).
+        SetSession("b", downloader.NewSession().SetUserAgent("browser-b").AddHeader("X-Token", "tb"))
+    dl.Download(request.NewRequest(ts.URL+"/login?user=a", "text").SetSession("a"))
+    dl.Download(request.NewRequest(ts.URL+"/login?user=b", "text").SetSession("b"))
+
+    cases := map[string]string{"a": "a|Go-http-client/1.1|", "b": "b|browser-b|tb", "": "|Go-http-client/1.1|"}
+    for session, want := range cases {
+        p := dl.Download(request.NewRequest(ts.URL+"/page", "text").SetSession(session))
+        if p.GetBodyStr() != want {
+            t.Errorf("page of session %q is %q, want %q", session, p.GetBodyStr(), want)
+        }
+    }
+    if dl.GetSession("a") == nil || dl.GetSession("c") != nil {
+        t.Error("session a should be created by its request only")
+    }
+
+    // header of the request overrides header of its session
+    p := dl.Download(request.NewRequest(ts.URL+"/page", "text").SetSession("b").SetHeader("X-Token", "t"))
+    if p.GetBodyStr() != "b|browser-b|t" {
+        t.Errorf("header of request is not sent: %s", p.GetBodyStr())
+    }
+
+    client, err := dl.GetSessionClient("a")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp, err := client.Get(ts.URL + "/page")
+    if err != nil {
+        t.Fatal(err)
+    }
+    body, _ := ioutil.ReadAll(resp.Body)
+    resp.Body.Close()
+    if !strings.HasPrefix(string(body), "a|") {
+        t.Errorf("client of session a does not have its cookies: %s", body)
+    }
+}
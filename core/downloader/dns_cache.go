@@ -0,0 +1,149 @@
+package downloader
+
+import (
+    "context"
+    "errors"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The Resolver resolves host name to ip addresses, like *net.Resolver of an internal dns server, a DNSCache,
+// or a resolver of dns over https.
+type Resolver interface {
+    LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// The DNSCache is Resolver that caches addresses of hosts for ttl, so crawls of many requests to few hosts
+// do not lookup the system resolver for each connection. Failed lookups are cached for negative ttl.
+// Lookups of a host at the same time wait for one lookup. Addresses added by AddHost override dns, like
+// /etc/hosts of the crawl.
+type DNSCache struct {
+    resolver    Resolver
+    ttl         time.Duration
+    negativeTtl time.Duration
+
+    locker  sync.Mutex
+    static  map[string][]string
+    entries map[string]*dnsEntry
+}
+
+type dnsEntry struct {
+    addrs   []string
+    err     error
+    expires time.Time
+    // The ready is closed when the lookup is done.
+    ready chan struct{}
+}
+
+// NewDNSCache returns DNSCache of addresses looked up by resolver, or by net.DefaultResolver if it is nil,
+// which are kept for ttl like time.Minute. Negative ttl is 5 seconds by default.
+func NewDNSCache(resolver Resolver, ttl time.Duration) *DNSCache {
+    if resolver == nil {
+        resolver = net.DefaultResolver
+    }
+    return &DNSCache{
+        resolver:    resolver,
+        ttl:         ttl,
+        negativeTtl: 5 * time.Second,
+        static:      make(map[string][]string),
+        entries:     make(map[string]*dnsEntry),
+    }
+}
+
+// The SetNegativeTtl sets how long failed lookups are cached. The d 0 means they are not cached.
+func (this *DNSCache) SetNegativeTtl(d time.Duration) *DNSCache {
+    this.negativeTtl = d
+    return this
+}
+
+// The AddHost sets addresses of the host, which are used instead of dns and never expire.
+func (this *DNSCache) AddHost(host string, addrs ...string) *DNSCache {
+    this.locker.Lock()
+    this.static[strings.ToLower(host)] = addrs
+    this.locker.Unlock()
+    return this
+}
+
+// The Flush removes all the addresses looked up, like after network is changed.
+func (this *DNSCache) Flush() {
+    this.locker.Lock()
+    this.entries = make(map[string]*dnsEntry)
+    this.locker.Unlock()
+}
+
+func (this *DNSCache) LookupHost(ctx context.Context, host string) ([]string, error) {
+    host = strings.ToLower(host)
+    this.locker.Lock()
+    if addrs, ok := this.static[host]; ok {
+        this.locker.Unlock()
+        return append([]string(nil), addrs...), nil
+    }
+    e, ok := this.entries[host]
+    if ok {
+        select {
+        case <-e.ready:
+            if time.Now().After(e.expires) {
+                ok = false
+            }
+        default:
+        }
+    }
+    if ok {
+        this.locker.Unlock()
+        select {
+        case <-e.ready:
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        }
+        if e.err != nil && ctx.Err() == nil && (errors.Is(e.err, context.Canceled) || errors.Is(e.err, context.DeadlineExceeded)) {
+            // the lookup waited for is canceled by its caller
+            return this.LookupHost(ctx, host)
+        }
+        if e.err != nil {
+            return nil, e.err
+        }
+        return append([]string(nil), e.addrs...), nil
+    }
+    e = &dnsEntry{ready: make(chan struct{})}
+    this.entries[host] = e
+    this.locker.Unlock()
+
+    e.addrs, e.err = this.resolver.LookupHost(ctx, host)
+    ttl := this.ttl
+    if e.err != nil {
+        ttl = this.negativeTtl
+    }
+    e.expires = time.Now().Add(ttl)
+    if ctx.Err() != nil || ttl <= 0 {
+        // a canceled lookup is not the answer of dns
+        this.locker.Lock()
+        if this.entries[host] == e {
+            delete(this.entries, host)
+        }
+        this.locker.Unlock()
+    }
+    close(e.ready)
+    if e.err != nil {
+        return nil, e.err
+    }
+    return append([]string(nil), e.addrs...), nil
+}
+
+// The SetResolver sets Resolver of hosts of connections, like NewDNSCache(nil, time.Minute). The nil means
+// the system resolver, which is default.
+func (this *HttpDownloader) SetResolver(r Resolver) *HttpDownloader {
+    return this.tuneTransport(func(t *http.Transport) {
+        this.resolver = r
+        if this.geoRouter != nil {
+            this.geoRouter.setDownloaderResolver(r)
+        }
+        t.DialContext = this.dialFunc()
+    })
+}
+
+func (this *HttpDownloader) GetResolver() Resolver {
+    return this.resolver
+}
@@ -2,13 +2,34 @@
 package downloader
 
 import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/mlog"
     "github.com/hu17889/go_spider/core/common/page"
     "github.com/hu17889/go_spider/core/common/request"
 )
 
+// The logger writes logs of this package, whose level is set by mlog.SetLevel("downloader", level).
+var logger = mlog.Component("downloader")
+
 // The Downloader interface.
 // You can implement the interface by implement function Download.
 // Function Download need to return Page instance pointer that has request result downloaded from Request.
 type Downloader interface {
     Download(req *request.Request) *page.Page
 }
+
+// The ContextDownloader interface is Downloader whose download is canceled when ctx is done.
+// Function DownloadContext downloads like Download, and sets ctx to the page by Page.SetContext.
+// Spider downloads by it with context of Run, so downloads being crawled are canceled with the crawl.
+type ContextDownloader interface {
+    Downloader
+    DownloadContext(ctx context.Context, req *request.Request) *page.Page
+}
+
+// DownloadContext downloads by DownloadContext of d if it is a ContextDownloader, or by Download.
+func DownloadContext(ctx context.Context, d Downloader, req *request.Request) *page.Page {
+    if cd, ok := d.(ContextDownloader); ok {
+        return cd.DownloadContext(ctx, req)
+    }
+    return d.Download(req)
+}
@@ -0,0 +1,125 @@
+package downloader
+
+import (
+    "context"
+    "golang.org/x/time/rate"
+    "io"
+    "net/url"
+    "strings"
+    "sync"
+)
+
+// The bandwidthLimit limits bytes per second of responce bodies of all the downloads, of each host and of
+// each download by token buckets. Bodies are limited as they are received, before they are decompressed.
+type bandwidthLimit struct {
+    locker      sync.Mutex
+    global      *rate.Limiter
+    hostRate    float64
+    hosts       map[string]*rate.Limiter
+    requestRate float64
+}
+
+// The newBandwidthLimiter returns limiter of bps bytes per second, or nil for no limit. Its burst is the
+// bytes of one second, and at least 4 KB, so the rate is smooth for small bodies and reads are not too small.
+func newBandwidthLimiter(bps float64) *rate.Limiter {
+    if bps <= 0 {
+        return nil
+    }
+    burst := int(bps)
+    if burst < 4096 {
+        burst = 4096
+    }
+    return rate.NewLimiter(rate.Limit(bps), burst)
+}
+
+// The SetBandwidth limits bytes per second of responce bodies of all the downloads, like 1 << 20 for 1 MB/s.
+// Default 0 is no limit. It can be changed while downloading, and applies to downloads started later.
+func (this *HttpDownloader) SetBandwidth(bps float64) *HttpDownloader {
+    this.bandwidth.locker.Lock()
+    this.bandwidth.global = newBandwidthLimiter(bps)
+    this.bandwidth.locker.Unlock()
+    return this
+}
+
+// The SetHostBandwidth limits bytes per second of responce bodies of each host. Default 0 is no limit.
+func (this *HttpDownloader) SetHostBandwidth(bps float64) *HttpDownloader {
+    this.bandwidth.locker.Lock()
+    this.bandwidth.hostRate = bps
+    this.bandwidth.hosts = make(map[string]*rate.Limiter)
+    this.bandwidth.locker.Unlock()
+    return this
+}
+
+// The SetRequestBandwidth limits bytes per second of responce body of each download. Default 0 is no limit.
+func (this *HttpDownloader) SetRequestBandwidth(bps float64) *HttpDownloader {
+    this.bandwidth.locker.Lock()
+    this.bandwidth.requestRate = bps
+    this.bandwidth.locker.Unlock()
+    return this
+}
+
+// The limiters returns limiters of a download from the url.
+func (this *bandwidthLimit) limiters(rawurl string) []*rate.Limiter {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    var limiters []*rate.Limiter
+    if this.global != nil {
+        limiters = append(limiters, this.global)
+    }
+    if this.hostRate > 0 {
+        host := rawurl
+        if u, err := url.Parse(rawurl); err == nil {
+            host = strings.ToLower(u.Host)
+        }
+        l, ok := this.hosts[host]
+        if !ok {
+            l = newBandwidthLimiter(this.hostRate)
+            this.hosts[host] = l
+        }
+        limiters = append(limiters, l)
+    }
+    if this.requestRate > 0 {
+        limiters = append(limiters, newBandwidthLimiter(this.requestRate))
+    }
+    return limiters
+}
+
+// The throttle returns body that is read no faster than limits of the url allow, or body itself if there is
+// no limit. Waiting for the limits is canceled with ctx.
+func (this *bandwidthLimit) throttle(ctx context.Context, rawurl string, body io.ReadCloser) io.ReadCloser {
+    limiters := this.limiters(rawurl)
+    if len(limiters) == 0 {
+        return body
+    }
+    burst := limiters[0].Burst()
+    for _, l := range limiters[1:] {
+        if l.Burst() < burst {
+            burst = l.Burst()
+        }
+    }
+    return &throttledBody{ReadCloser: body, ctx: ctx, limiters: limiters, burst: burst}
+}
+
+// The throttledBody waits for tokens of bytes read from the body, so the connection is read at the limited
+// rate and the sender is slowed down by flow control of tcp.
+type throttledBody struct {
+    io.ReadCloser
+    ctx      context.Context
+    limiters []*rate.Limiter
+    burst    int
+}
+
+func (this *throttledBody) Read(b []byte) (int, error) {
+    if len(b) > this.burst {
+        b = b[:this.burst]
+    }
+    n, err := this.ReadCloser.Read(b)
+    if n > 0 {
+        for _, l := range this.limiters {
+            if werr := l.WaitN(this.ctx, n); werr != nil && err == nil {
+                err = werr
+            }
+        }
+    }
+    return n, err
+}
@@ -0,0 +1,176 @@
+package downloader
+
+import (
+    "context"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/url"
+    "os/exec"
+    "strconv"
+    "time"
+)
+
+// The browserNames are executables of headless browser looked up in PATH if exec path is not set.
+var browserNames = []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "chrome"}
+
+// The BrowserDownloader renders pages built by javascript with headless Chrome or Chromium, and parses
+// the rendered dom like HttpDownloader does. Only "html" requests set by Request.SetRenderJS are rendered;
+// other requests are downloaded by the HttpDownloader.
+// The browser is run once for each page with "--headless --dump-dom", so the page has no http header,
+// cookies or status code. Proxy of the request or HttpDownloader, and User-Agent header of the request
+// or UserAgentPool of HttpDownloader are used. Only http and https urls are rendered, and proxies with
+// credentials are not supported by the browser.
+type BrowserDownloader struct {
+    http *HttpDownloader
+
+    // The execPath is path of the browser executable.
+    execPath string
+
+    // The waitTime is how long javascript runs before the dom is dumped, and timeout kills the browser.
+    waitTime time.Duration
+    timeout  time.Duration
+
+    // The args are extra command line arguments of the browser.
+    args []string
+}
+
+// NewBrowserDownloader returns BrowserDownloader that downloads requests not rendered by the HttpDownloader.
+func NewBrowserDownloader(d *HttpDownloader) *BrowserDownloader {
+    return &BrowserDownloader{http: d, waitTime: 5 * time.Second, timeout: time.Minute}
+}
+
+// The GetHttpDownloader returns the HttpDownloader for requests not rendered.
+func (this *BrowserDownloader) GetHttpDownloader() *HttpDownloader {
+    return this.http
+}
+
+// The SetExecPath sets path of the browser executable.
+// Default is the first one of chromium, chromium-browser, google-chrome, google-chrome-stable and chrome in PATH.
+func (this *BrowserDownloader) SetExecPath(path string) *BrowserDownloader {
+    this.execPath = path
+    return this
+}
+
+// The SetWaitTime sets how long javascript of the page runs before the dom is returned. Default is 5 seconds.
+// It is virtual time of the browser, so the page is returned earlier if it has nothing to do.
+func (this *BrowserDownloader) SetWaitTime(d time.Duration) *BrowserDownloader {
+    this.waitTime = d
+    return this
+}
+
+// The SetTimeout sets how long the browser can run for one page before it is killed. Default is 1 minute.
+func (this *BrowserDownloader) SetTimeout(d time.Duration) *BrowserDownloader {
+    this.timeout = d
+    return this
+}
+
+// The SetArgs sets extra command line arguments of the browser, like "--no-sandbox" when running as root.
+func (this *BrowserDownloader) SetArgs(args []string) *BrowserDownloader {
+    this.args = args
+    return this
+}
+
+func (this *BrowserDownloader) Download(req *request.Request) *page.Page {
+    return this.DownloadContext(req.Context(), req)
+}
+
+// The DownloadContext downloads the request, and the browser is killed when ctx is done.
+func (this *BrowserDownloader) DownloadContext(ctx context.Context, req *request.Request) *page.Page {
+    if !req.GetRenderJS() || req.GetResponceType() != "html" {
+        return this.http.DownloadContext(ctx, req)
+    }
+
+    p := page.NewPage(req)
+    p.SetContext(ctx)
+    if robots := this.http.GetRobots(); robots != nil && !robots.Allowed(req.GetUrl()) {
+        logger.Info(ErrRobotsDisallowed.Error(), mlog.F("url", req.GetUrl()))
+        p.SetStatus(true, ErrRobotsDisallowed.Error())
+        return p
+    }
+    dom, err := this.render(ctx, req)
+    if err != nil {
+        logger.Error("render error : " + err.Error() + " " + req.GetUrl())
+        p.SetStatus(true, err.Error())
+        return p
+    }
+    return this.http.parseHtml(p, dom)
+}
+
+// The render runs the browser and returns the dom of the page.
+func (this *BrowserDownloader) render(ctx context.Context, req *request.Request) (string, error) {
+    path := this.execPath
+    if path == "" {
+        for _, name := range browserNames {
+            if found, err := exec.LookPath(name); err == nil {
+                path = found
+                break
+            }
+        }
+        if path == "" {
+            return "", errors.New("headless browser is not found")
+        }
+    }
+
+    // the url is an argument of the browser, so only web urls are passed, after "--" ending the flags
+    target, err := url.Parse(req.GetUrl())
+    if err != nil {
+        return "", err
+    }
+    if target.Scheme != "http" && target.Scheme != "https" || target.Host == "" {
+        return "", errors.New("headless browser renders only http and https urls : " + req.GetUrl())
+    }
+
+    args := []string{"--headless", "--disable-gpu", "--dump-dom",
+        "--virtual-time-budget=" + strconv.FormatInt(int64(this.waitTime/time.Millisecond), 10)}
+    proxyHost := this.http.proxyFor(req)
+    if proxyHost != "" {
+        server, err := browserProxy(proxyHost)
+        if err != nil {
+            return "", err
+        }
+        args = append(args, "--proxy-server="+server)
+    }
+    if ua := this.http.userAgentFor(req, proxyHost); ua != "" {
+        args = append(args, "--user-agent="+ua)
+    }
+    args = append(args, this.args...)
+    args = append(args, "--", target.String())
+
+    timeoutCtx, cancel := context.WithTimeout(ctx, this.timeout)
+    defer cancel()
+    var out []byte
+    out, err = exec.CommandContext(timeoutCtx, path, args...).Output()
+    if err := ctx.Err(); err != nil {
+        return "", err
+    }
+    if timeoutCtx.Err() != nil {
+        return "", errors.New("headless browser timeout")
+    }
+    if err != nil {
+        return "", err
+    }
+    return string(out), nil
+}
+
+// The browserProxy returns the proxy as "--proxy-server" of the browser. Proxies with credentials are
+// rejected, because the browser ignores them and they would be seen in the process list.
+func browserProxy(proxyHost string) (string, error) {
+    proxyHost, err := NormalizeProxy(proxyHost)
+    if err != nil {
+        return "", err
+    }
+    u, err := url.Parse(proxyHost)
+    if err != nil {
+        return "", err
+    }
+    if u.User != nil {
+        return "", errors.New("headless browser does not support proxy with credentials : " + u.Redacted())
+    }
+    scheme := u.Scheme
+    if scheme == "socks5h" {
+        scheme = "socks5"
+    }
+    return scheme + "://" + u.Host, nil
+}
@@ -0,0 +1,181 @@
+package downloader
+
+import (
+    "crypto/md5"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "time"
+)
+
+// The Cache interface saves downloaded pages so that the same Request needn't be downloaded again.
+// Function Get returns the cached Page of the Request and whether it is found.
+// Function Set saves the Page downloaded from the Request.
+type Cache interface {
+    Get(req *request.Request) (*page.Page, bool)
+    Set(req *request.Request, p *page.Page)
+}
+
+// The ValidatorStore interface saves pages with their ETag and Last-Modified header for conditional requests.
+// Function Load returns the page saved for the Request, whether it is expired or not.
+// Function Save saves the page downloaded from the Request.
+type ValidatorStore interface {
+    Load(req *request.Request) (*page.Page, bool)
+    Save(req *request.Request, p *page.Page)
+}
+
+// The conditionalHeader returns If-None-Match and If-Modified-Since header by validators of the page.
+// It returns nil if the page is nil or has no validators.
+func conditionalHeader(p *page.Page) http.Header {
+    if p == nil {
+        return nil
+    }
+    saved := http.Header(p.GetHeader())
+    header := make(http.Header)
+    if etag := saved.Get("ETag"); etag != "" {
+        header.Set("If-None-Match", etag)
+    }
+    if lastModified := saved.Get("Last-Modified"); lastModified != "" {
+        header.Set("If-Modified-Since", lastModified)
+    }
+    if len(header) == 0 {
+        return nil
+    }
+    return header
+}
+
+// The FileCache saves page results in a directory, one file for each Request.
+// The file is keyed on the request fingerprint and responce type of the request, so html and json result
+// of the same url do not collide. The fingerprint is scheduler.DefaultFingerprint by default, which is the
+// normalized url of GET requests and the method, url and canonical body of POST and api requests.
+// Cached result older than ttl will be downloaded again. The ttl 0 means cached result never expires.
+// FileCache is also a ValidatorStore for conditional requests of HttpDownloader.
+type FileCache struct {
+    dir         string
+    ttl         time.Duration
+    fingerprint func(*request.Request) string
+
+    parser *HttpDownloader
+}
+
+// The fileCacheEntry is the content saved in a cache file.
+type fileCacheEntry struct {
+    Url      string
+    RespType string
+    Body     string
+    Status   int
+    Header   http.Header
+    Cookies  []*http.Cookie
+    Time     time.Time
+}
+
+// NewFileCache returns initialized FileCache object which saves results in dir, or error if the dir can not
+// be created.
+func NewFileCache(dir string, ttl time.Duration) (*FileCache, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, errors.New("cache dir error : " + err.Error())
+    }
+    return &FileCache{dir: dir, ttl: ttl, fingerprint: scheduler.DefaultFingerprint, parser: NewHttpDownloader()}, nil
+}
+
+// The SetFingerprint sets function that returns fingerprint of request for the cache file, like Fingerprint of
+// scheduler.RequestFingerprinter ignoring nonces of api requests, so replayed requests find their pages.
+func (this *FileCache) SetFingerprint(f func(*request.Request) string) *FileCache {
+    this.fingerprint = f
+    return this
+}
+
+// The key returns the cache file name of the request.
+func (this *FileCache) key(req *request.Request) string {
+    sum := md5.Sum([]byte(req.GetResponceType() + "\t" + this.fingerprint(req)))
+    return filepath.Join(this.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get reads the cache file of the request and parses the saved body like HttpDownloader does.
+func (this *FileCache) Get(req *request.Request) (*page.Page, bool) {
+    return this.load(req, true)
+}
+
+// Load reads the cache file of the request even if it is expired, for conditional requests.
+func (this *FileCache) Load(req *request.Request) (*page.Page, bool) {
+    return this.load(req, false)
+}
+
+// Save saves the page like Set does.
+func (this *FileCache) Save(req *request.Request, p *page.Page) {
+    this.Set(req, p)
+}
+
+func (this *FileCache) load(req *request.Request, checkTtl bool) (*page.Page, bool) {
+    content, err := ioutil.ReadFile(this.key(req))
+    if err != nil {
+        return nil, false
+    }
+
+    var entry fileCacheEntry
+    if err = json.Unmarshal(content, &entry); err != nil {
+        logger.Error("cache file broken : " + err.Error())
+        return nil, false
+    }
+    if checkTtl && this.ttl > 0 && time.Since(entry.Time) > this.ttl {
+        return nil, false
+    }
+
+    p := page.NewPage(req)
+    p.SetStatusCode(entry.Status)
+    p.SetHeader(entry.Header)
+    p.SetCookies(entry.Cookies)
+    p = this.parser.parseBody(p, req, entry.Body)
+    if !p.IsSucc() {
+        return nil, false
+    }
+    return p, true
+}
+
+// Set saves successful page into the cache file of the request.
+// The "file" content is not cached because it is saved in file already, and "stream", "sse" and "websocket"
+// content is not buffered.
+func (this *FileCache) Set(req *request.Request, p *page.Page) {
+    if !p.IsSucc() || req.GetResponceType() == "file" || req.GetResponceType() == "stream" || p.Messages() != nil {
+        return
+    }
+    entry := fileCacheEntry{
+        Url:      req.GetUrl(),
+        RespType: req.GetResponceType(),
+        Body:     p.GetBodyStr(),
+        Status:   p.GetStatusCode(),
+        Header:   p.GetHeader(),
+        Cookies:  p.GetCookies(),
+        Time:     time.Now(),
+    }
+    content, err := json.Marshal(entry)
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+
+    // write to temp file first so that a broken file is never read.
+    tmp, err := ioutil.TempFile(this.dir, "tmp")
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    _, err = tmp.Write(content)
+    tmp.Close()
+    if err != nil {
+        logger.Error(err.Error())
+        os.Remove(tmp.Name())
+        return
+    }
+    if err = os.Rename(tmp.Name(), this.key(req)); err != nil {
+        logger.Error(err.Error())
+        os.Remove(tmp.Name())
+    }
+}
@@ -0,0 +1,153 @@
+package downloader_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestFileCache(t *testing.T) {
+    dir, err := ioutil.TempDir("", "go_spider_cache")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    if _, err = downloader.NewFileCache(filepath.Join(dir, "sub", "cache"), time.Hour); err != nil {
+        t.Fatal(err)
+    }
+    ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0644)
+    if _, err = downloader.NewFileCache(filepath.Join(dir, "file", "cache"), time.Hour); err == nil {
+        t.Error("cache dir under a file should be an error")
+    }
+    c, _ := downloader.NewFileCache(dir, time.Hour)
+    req := request.NewRequest("http://example.com/a?y=2&x=1#top", "text")
+    if _, ok := c.Get(req); ok {
+        t.Error("empty cache hit")
+    }
+
+    p := page.NewPage(req)
+    p.SetBodyStr("hello").SetStatus(false, "")
+    c.Set(req, p)
+
+    // url is normalized
+    cp, ok := c.Get(request.NewRequest("HTTP://example.com/a?x=1&y=2", "text"))
+    if !ok || cp.GetBodyStr() != "hello" {
+        t.Error("cache get error")
+    }
+
+    // responce type is part of the key
+    if _, ok := c.Get(request.NewRequest("http://example.com/a?x=1&y=2", "html")); ok {
+        t.Error("responce type collide")
+    }
+
+    // parsed again for json
+    jreq := request.NewRequest("http://example.com/api", "json")
+    jp := page.NewPage(jreq)
+    jp.SetBodyStr(`{"a":1}`).SetStatus(false, "")
+    c.Set(jreq, jp)
+    if cp, ok = c.Get(jreq); !ok || cp.GetJson().Get("a").MustInt() != 1 {
+        t.Error("cache json parse error")
+    }
+
+    // body of POST request is part of the key
+    post := func(body string) *request.Request {
+        return request.NewRequest("http://example.com/search", "text").SetMethod("POST").
+            SetBody(body, "application/x-www-form-urlencoded")
+    }
+    pp := page.NewPage(post("q=a&page=1"))
+    pp.SetBodyStr("page 1").SetStatus(false, "")
+    c.Set(post("q=a&page=1"), pp)
+    if cp, ok = c.Get(post("page=1&q=a")); !ok || cp.GetBodyStr() != "page 1" {
+        t.Error("cache of POST request is not found")
+    }
+    if _, ok = c.Get(post("q=a&page=2")); ok {
+        t.Error("POST requests of different bodies collide")
+    }
+
+    // expired
+    c, _ = downloader.NewFileCache(dir, time.Nanosecond)
+    time.Sleep(time.Millisecond)
+    if _, ok := c.Get(req); ok {
+        t.Error("expired cache hit")
+    }
+}
+
+func TestConditionalRequest(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("If-None-Match") == `"v1"` {
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+        w.Header().Set("ETag", `"v1"`)
+        w.Write([]byte("hello"))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_cache")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    // expired cache is still used for validators
+    store, _ := downloader.NewFileCache(dir, time.Nanosecond)
+    dl := downloader.NewHttpDownloader().SetValidatorStore(store)
+    p := dl.Download(request.NewRequest(ts.URL, "text"))
+    if !p.IsSucc() || p.IsNotModified() || p.GetBodyStr() != "hello" {
+        t.Fatal("first download error")
+    }
+
+    p = dl.Download(request.NewRequest(ts.URL, "text"))
+    if !p.IsSucc() || !p.IsNotModified() || p.GetBodyStr() != "hello" {
+        t.Error("not modified page error")
+    }
+    if p.GetStatusCode() != http.StatusNotModified {
+        t.Error("status code error")
+    }
+}
+
+func TestValidatorMap(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+        w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+        w.Write([]byte(`{"a": 1}`))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_validator")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    file := dir + "/validators.json"
+
+    m := downloader.NewValidatorMap()
+    p := downloader.NewHttpDownloader().SetValidatorStore(m).Download(request.NewRequest(ts.URL, "json"))
+    if !p.IsSucc() || p.IsNotModified() {
+        t.Fatal("first download error")
+    }
+    if err = m.WriteFile(file); err != nil {
+        t.Fatal(err)
+    }
+
+    // validators are read in another run
+    m = downloader.NewValidatorMap()
+    if err = m.ReadFile(file); err != nil {
+        t.Fatal(err)
+    }
+    p = downloader.NewHttpDownloader().SetValidatorStore(m).Download(request.NewRequest(ts.URL, "json"))
+    if !p.IsSucc() || !p.IsNotModified() || p.GetBodyStr() != "" {
+        t.Errorf("not modified page should have no body: %v %s", p.IsNotModified(), p.Errormsg())
+    }
+}
@@ -0,0 +1,83 @@
+package downloader
+
+import (
+    "golang.org/x/net/html"
+    "strings"
+)
+
+// The voidElements never have end tag, so they do not increase nesting depth.
+var voidElements = map[string]bool{
+    "area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+    "input": true, "keygen": true, "link": true, "meta": true, "param": true, "source": true,
+    "track": true, "wbr": true,
+}
+
+// The autoCloseElements are closed implicitly by the next sibling of the same tag, like <li>a<li>b.
+var autoCloseElements = map[string]bool{
+    "p": true, "li": true, "dt": true, "dd": true, "option": true, "tr": true, "td": true, "th": true,
+}
+
+// The htmlDepthExceeds tests whether tag nesting of the html document is deeper than max.
+func htmlDepthExceeds(body string, max int) bool {
+    var stack []string
+    z := html.NewTokenizer(strings.NewReader(body))
+    for {
+        switch z.Next() {
+        case html.ErrorToken:
+            return false
+        case html.StartTagToken:
+            name, _ := z.TagName()
+            tag := string(name)
+            if voidElements[tag] {
+                continue
+            }
+            if autoCloseElements[tag] && len(stack) > 0 && stack[len(stack)-1] == tag {
+                stack = stack[:len(stack)-1]
+            }
+            stack = append(stack, tag)
+            if len(stack) > max {
+                return true
+            }
+        case html.EndTagToken:
+            name, _ := z.TagName()
+            tag := string(name)
+            for i := len(stack) - 1; i >= 0; i-- {
+                if stack[i] == tag {
+                    stack = stack[:i]
+                    break
+                }
+            }
+        }
+    }
+}
+
+// The jsonDepthExceeds tests whether object and array nesting of the json document is deeper than max.
+func jsonDepthExceeds(body []byte, max int) bool {
+    depth := 0
+    instring := false
+    escaped := false
+    for _, c := range body {
+        if instring {
+            if escaped {
+                escaped = false
+            } else if c == '\\' {
+                escaped = true
+            } else if c == '"' {
+                instring = false
+            }
+            continue
+        }
+        switch c {
+        case '"':
+            instring = true
+        case '{', '[':
+            depth++
+            if depth > max {
+                return true
+            }
+        case '}', ']':
+            depth--
+        }
+    }
+    return false
+}
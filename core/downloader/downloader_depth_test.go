@@ -0,0 +1,50 @@
+package downloader
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestHtmlDepthExceeds(t *testing.T) {
+    cases := []struct {
+        name string
+        body string
+        max  int
+        want bool
+    }{
+        {"shallow", "<html><body><div><p>a</p></div></body></html>", 4, false},
+        {"deep", "<html><body>" + strings.Repeat("<div>", 10) + strings.Repeat("</div>", 10) + "</body></html>", 8, true},
+        {"unclosed", "<html><body>" + strings.Repeat("<span>", 10), 8, true},
+        {"siblings", "<html><body>" + strings.Repeat("<div>a</div>", 100) + "</body></html>", 3, false},
+        {"void", "<html><body><div>" + strings.Repeat("<br><img src=a.jpg><input>", 20) + "</div></body></html>", 3, false},
+        {"self closing", "<html><body><div>" + strings.Repeat("<span/><div/>", 20) + "</div></body></html>", 3, false},
+        {"auto closed", "<html><body><ul>" + strings.Repeat("<li>a", 20) + "</ul></body></html>", 4, false},
+    }
+    for _, c := range cases {
+        if got := htmlDepthExceeds(c.body, c.max); got != c.want {
+            t.Errorf("%s: depth exceeds %d should be %v", c.name, c.max, c.want)
+        }
+    }
+}
+
+func TestJsonDepthExceeds(t *testing.T) {
+    cases := []struct {
+        name string
+        body string
+        max  int
+        want bool
+    }{
+        {"shallow", `{"a":[1,{"b":2}]}`, 3, false},
+        {"exact", `{"a":[1,{"b":2}]}`, 2, true},
+        {"deep arrays", strings.Repeat("[", 100) + strings.Repeat("]", 100), 50, true},
+        {"siblings", "[" + strings.Repeat(`{"a":1},`, 100) + `{"a":1}]`, 2, false},
+        {"brackets in strings", `{"a":"[[[{{{","b":"}}]]"}`, 1, false},
+        {"escaped quotes", `{"a":"\"[[[\\","b":"[{\"[{"}`, 1, false},
+        {"escaped then nested", `{"a":"\\"}` + `,{"b":[[1]]}`, 2, true},
+    }
+    for _, c := range cases {
+        if got := jsonDepthExceeds([]byte(c.body), c.max); got != c.want {
+            t.Errorf("%s: depth exceeds %d should be %v", c.name, c.max, c.want)
+        }
+    }
+}
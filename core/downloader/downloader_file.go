@@ -0,0 +1,157 @@
+package downloader
+
+import (
+    "errors"
+    "html"
+    "io"
+    "mime"
+    "net/http"
+    "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The SetFileRoot lets requests of "file" urls, like "file:///data/mirror/index.html", read files under the
+// directory, so a local mirror is crawled like a site. Directories are html pages listing links of their
+// entries. Files out of the directory are not found, so links of pages crawled can not read other local
+// files. Default "" fails "file" urls.
+func (this *HttpDownloader) SetFileRoot(dir string) *HttpDownloader {
+    if dir != "" {
+        if abs, err := filepath.Abs(dir); err == nil {
+            dir = abs
+        }
+    }
+    this.locker.Lock()
+    this.fileRoot = dir
+    this.locker.Unlock()
+    return this
+}
+
+func (this *HttpDownloader) GetFileRoot() string {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.fileRoot
+}
+
+// The roundTrip sends the request by its scheme: "file" and "ftp" urls are read by HttpDownloader, and
+// others are sent by the client.
+func (this *HttpDownloader) roundTrip(client *http.Client, httpreq *http.Request) (*http.Response, error) {
+    switch httpreq.URL.Scheme {
+    case "file":
+        return this.getFile(httpreq)
+    case "ftp":
+        return this.getFtp(httpreq)
+    }
+    return client.Do(httpreq)
+}
+
+// The getFile returns responce of the file of the "file" url, or html listing of the directory.
+func (this *HttpDownloader) getFile(httpreq *http.Request) (*http.Response, error) {
+    root := this.GetFileRoot()
+    if root == "" {
+        return nil, errors.New("file url is not allowed without file root")
+    }
+    if httpreq.URL.Host != "" && httpreq.URL.Host != "localhost" {
+        return nil, errors.New("file url of other host is not supported : " + httpreq.URL.Host)
+    }
+    name := filepath.FromSlash(path.Clean("/" + httpreq.URL.Path))
+    if rel, err := filepath.Rel(root, name); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+        return schemeResponse(httpreq, http.StatusNotFound, nil, "", time.Time{}), nil
+    }
+    f, err := os.Open(name)
+    if err != nil {
+        return schemeResponse(httpreq, fileErrorStatus(err), nil, "", time.Time{}), nil
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return nil, err
+    }
+    if !info.IsDir() {
+        return schemeResponse(httpreq, http.StatusOK, f, contentTypeOf(name), info.ModTime()), nil
+    }
+    entries, err := f.Readdir(-1)
+    f.Close()
+    if err != nil {
+        return nil, err
+    }
+    list := make([]dirEntry, 0, len(entries))
+    for _, e := range entries {
+        list = append(list, dirEntry{name: e.Name(), dir: e.IsDir()})
+    }
+    return listingResponse(httpreq, list, info.ModTime()), nil
+}
+
+// The fileErrorStatus returns http status of error opening a file.
+func fileErrorStatus(err error) int {
+    switch {
+    case os.IsNotExist(err):
+        return http.StatusNotFound
+    case os.IsPermission(err):
+        return http.StatusForbidden
+    }
+    return http.StatusInternalServerError
+}
+
+// The contentTypeOf returns media type of the file by its extension, or "" to be sniffed.
+func contentTypeOf(name string) string {
+    return mime.TypeByExtension(path.Ext(name))
+}
+
+// The dirEntry is a file or a directory in a listing.
+type dirEntry struct {
+    name string
+    dir  bool
+}
+
+// The listingResponse returns html page of links of the entries in the directory of the url, directories
+// first and by name. Links are absolute urls, and links of directories end with "/".
+func listingResponse(httpreq *http.Request, entries []dirEntry, modTime time.Time) *http.Response {
+    sort.Slice(entries, func(i, j int) bool {
+        if entries[i].dir != entries[j].dir {
+            return entries[i].dir
+        }
+        return entries[i].name < entries[j].name
+    })
+    base := *httpreq.URL
+    base.RawQuery, base.Fragment = "", ""
+    if !strings.HasSuffix(base.Path, "/") {
+        base.Path += "/"
+        base.RawPath = ""
+    }
+    var b strings.Builder
+    b.WriteString("<html><head><title>Index of " + html.EscapeString(base.Path) + "</title></head><body><ul>\n")
+    for _, e := range entries {
+        name := e.name
+        if e.dir {
+            name += "/"
+        }
+        link := base.ResolveReference(&url.URL{Path: "./" + name})
+        b.WriteString(`<li><a href="` + html.EscapeString(link.String()) + `">` + html.EscapeString(name) + "</a></li>\n")
+    }
+    b.WriteString("</ul></body></html>\n")
+    return schemeResponse(httpreq, http.StatusOK, io.NopCloser(strings.NewReader(b.String())),
+        "text/html; charset=utf-8", modTime)
+}
+
+// The schemeResponse returns responce of the body read from a "file" or "ftp" url, whose Content-Type is
+// sniffed by the downloader if it is "".
+func schemeResponse(httpreq *http.Request, status int, body io.ReadCloser, contentType string, modTime time.Time) *http.Response {
+    if body == nil {
+        body = io.NopCloser(strings.NewReader(""))
+    }
+    resp := &http.Response{Status: strconv.Itoa(status) + " " + http.StatusText(status), StatusCode: status,
+        Proto: "HTTP/1.0", ProtoMajor: 1, Header: make(http.Header), Body: body, ContentLength: -1, Request: httpreq}
+    if contentType != "" {
+        resp.Header.Set("Content-Type", contentType)
+    }
+    if !modTime.IsZero() {
+        resp.Header.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+    }
+    return resp
+}
@@ -0,0 +1,327 @@
+package downloader
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptrace"
+    "net/textproto"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The ftpCloseTimeout limits waiting for the server to confirm a transfer when the body is closed.
+const ftpCloseTimeout = 5 * time.Second
+
+// The getFtp returns responce of the file of the "ftp" url, or html listing of the directory if the url ends
+// with "/" or is not a file. It logs in by user and password of the url, or as "anonymous". Connections are
+// dialed by the resolver and local addresses of HttpDownloader, in passive mode; proxies are not used.
+// Files not found are responces of status 404, and logins refused are of status 403.
+func (this *HttpDownloader) getFtp(httpreq *http.Request) (*http.Response, error) {
+    u := httpreq.URL
+    if strings.ContainsAny(u.Path, "\r\n") {
+        return nil, errors.New("ftp path is invalid : " + strconv.Quote(u.Path))
+    }
+    addr := u.Host
+    if u.Port() == "" {
+        addr = net.JoinHostPort(u.Hostname(), "21")
+    }
+    this.locker.Lock()
+    dial := newDialFunc(this.dialOptions())
+    this.locker.Unlock()
+
+    ctx := httpreq.Context()
+    c, err := dialFtp(ctx, dial, addr)
+    if err != nil {
+        return nil, err
+    }
+    resp, err := c.get(httpreq)
+    if err != nil {
+        c.stop()
+        c.close()
+        if ctx.Err() != nil {
+            err = ctx.Err()
+        }
+        if status := ftpErrorStatus(err); status != 0 {
+            return schemeResponse(httpreq, status, nil, "", time.Time{}), nil
+        }
+        return nil, err
+    }
+    return resp, nil
+}
+
+// The ftpErrorStatus returns http status of replies of files not found and logins refused, or 0.
+func ftpErrorStatus(err error) int {
+    var terr *textproto.Error
+    if !errors.As(err, &terr) {
+        return 0
+    }
+    switch terr.Code {
+    case 550:
+        return http.StatusNotFound
+    case 530:
+        return http.StatusForbidden
+    }
+    return 0
+}
+
+// The ftpConn is control connection of an ftp server, and its data connection of the current transfer.
+type ftpConn struct {
+    ctx  context.Context
+    dial dialFunc
+    text *textproto.Conn
+    conn net.Conn
+    stop func() bool
+
+    locker sync.Mutex
+    data   net.Conn
+    closed bool
+}
+
+// The dialFtp dials the control connection and reads greeting of the server. Connections are closed when the
+// context is done.
+func dialFtp(ctx context.Context, dial dialFunc, addr string) (*ftpConn, error) {
+    hooks := httptrace.ContextClientTrace(ctx)
+    if hooks != nil && hooks.ConnectStart != nil {
+        hooks.ConnectStart("tcp", addr)
+    }
+    conn, err := dial(ctx, "tcp", addr)
+    if hooks != nil && hooks.ConnectDone != nil {
+        hooks.ConnectDone("tcp", addr, err)
+    }
+    if err != nil {
+        return nil, err
+    }
+    c := &ftpConn{ctx: ctx, dial: dial, text: textproto.NewConn(conn), conn: conn}
+    c.stop = context.AfterFunc(ctx, c.close)
+    if _, _, err = c.text.ReadResponse(2); err != nil {
+        c.stop()
+        c.close()
+        return nil, err
+    }
+    return c, nil
+}
+
+// The get logs in and returns responce of the file or the directory of the url.
+func (this *ftpConn) get(httpreq *http.Request) (*http.Response, error) {
+    user, pass := "anonymous", "anonymous@"
+    if u := httpreq.URL.User; u != nil {
+        user = u.Username()
+        if p, ok := u.Password(); ok {
+            pass = p
+        }
+    }
+    code, _, err := this.cmd(2, "USER "+user)
+    if err != nil {
+        if terr, ok := err.(*textproto.Error); !ok || terr.Code != 331 {
+            return nil, err
+        }
+        if _, _, err = this.cmd(2, "PASS "+pass); err != nil {
+            return nil, err
+        }
+    } else if code != 230 && code != 202 {
+        return nil, &textproto.Error{Code: code, Msg: "unexpected reply of USER"}
+    }
+    if _, _, err = this.cmd(2, "TYPE I"); err != nil {
+        return nil, err
+    }
+
+    name := httpreq.URL.Path
+    if name == "" {
+        name = "/"
+    }
+    if !strings.HasSuffix(name, "/") {
+        resp, err := this.retrieve(httpreq, name)
+        if ftpErrorStatus(err) != http.StatusNotFound {
+            return resp, err
+        }
+        // it may be a directory
+    }
+    if _, _, err = this.cmd(2, "CWD "+name); err != nil {
+        return nil, err
+    }
+    listing, err := this.transfer("LIST")
+    if err != nil {
+        return nil, err
+    }
+    body, err := io.ReadAll(listing)
+    if cerr := listing.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        return nil, err
+    }
+    return listingResponse(httpreq, parseFtpList(string(body)), time.Time{}), nil
+}
+
+// The retrieve returns responce whose body is the file being transferred.
+func (this *ftpConn) retrieve(httpreq *http.Request, name string) (*http.Response, error) {
+    body, err := this.transfer("RETR " + name)
+    if err != nil {
+        return nil, err
+    }
+    return schemeResponse(httpreq, http.StatusOK, body, contentTypeOf(name), time.Time{}), nil
+}
+
+// The transfer opens a passive data connection and sends the command, and returns body of the data
+// connection, whose Close waits for the server to finish the transfer and quits.
+func (this *ftpConn) transfer(command string) (io.ReadCloser, error) {
+    data, err := this.passive()
+    if err != nil {
+        return nil, err
+    }
+    if _, _, err = this.cmd(1, command); err != nil {
+        data.Close()
+        return nil, err
+    }
+    return &ftpBody{Conn: data, c: this}, nil
+}
+
+// The passive dials data connection to the port the server listens on by EPSV, or PASV of servers not
+// supporting it. The address of the server replied to PASV is ignored for the address of the control
+// connection, like servers behind NAT reply their private address.
+func (this *ftpConn) passive() (net.Conn, error) {
+    host, _, err := net.SplitHostPort(this.conn.RemoteAddr().String())
+    if err != nil {
+        return nil, err
+    }
+    var port int
+    if _, msg, err := this.cmd(229, "EPSV"); err == nil {
+        i := strings.Index(msg, "(|||")
+        j := strings.LastIndex(msg, "|)")
+        if i < 0 || j < i+4 {
+            return nil, errors.New("ftp EPSV reply is invalid : " + msg)
+        }
+        if port, err = strconv.Atoi(msg[i+4 : j]); err != nil {
+            return nil, err
+        }
+    } else {
+        _, msg, err := this.cmd(227, "PASV")
+        if err != nil {
+            return nil, err
+        }
+        i := strings.Index(msg, "(")
+        j := strings.LastIndex(msg, ")")
+        if i < 0 || j < i {
+            return nil, errors.New("ftp PASV reply is invalid : " + msg)
+        }
+        parts := strings.Split(msg[i+1:j], ",")
+        if len(parts) != 6 {
+            return nil, errors.New("ftp PASV reply is invalid : " + msg)
+        }
+        p1, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+        p2, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+        if err1 != nil || err2 != nil {
+            return nil, errors.New("ftp PASV reply is invalid : " + msg)
+        }
+        port = p1<<8 | p2
+    }
+    data, err := this.dial(this.ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+    if err != nil {
+        return nil, err
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.closed {
+        data.Close()
+        return nil, errors.New("ftp connection is closed")
+    }
+    this.data = data
+    return data, nil
+}
+
+// The cmd sends the command and reads its reply, which is error unless its code begins with expect.
+func (this *ftpConn) cmd(expect int, command string) (int, string, error) {
+    if err := this.text.PrintfLine("%s", command); err != nil {
+        return 0, "", err
+    }
+    return this.text.ReadResponse(expect)
+}
+
+// The close closes the connections, like when the context is done.
+func (this *ftpConn) close() {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.closed {
+        return
+    }
+    this.closed = true
+    if this.data != nil {
+        this.data.Close()
+    }
+    this.conn.Close()
+}
+
+// The ftpBody is body of a transfer read from the data connection.
+type ftpBody struct {
+    net.Conn
+    c    *ftpConn
+    once sync.Once
+}
+
+// The Close closes the data connection, reads reply of the transfer and quits. Transfers not read to the end
+// are aborted.
+func (this *ftpBody) Close() error {
+    var err error
+    this.once.Do(func() {
+        this.Conn.Close()
+        c := this.c
+        c.stop()
+        c.conn.SetDeadline(time.Now().Add(ftpCloseTimeout))
+        if _, _, err = c.text.ReadResponse(2); err == nil {
+            c.text.PrintfLine("QUIT")
+            c.text.ReadResponse(2)
+        }
+        c.close()
+    })
+    return err
+}
+
+// The parseFtpList returns entries of LIST replies in unix format, like
+// "drwxr-xr-x 2 ftp ftp 4096 Jan 02 15:04 pub", or in dos format, like "01-02-06 03:04PM <DIR> pub".
+// Lines of other formats are skipped.
+func parseFtpList(listing string) []dirEntry {
+    var entries []dirEntry
+    for _, line := range strings.Split(listing, "\n") {
+        line = strings.TrimRight(line, "\r")
+        var e dirEntry
+        if fields, rest := splitFields(line, 8); fields != nil && strings.IndexByte("-dl", fields[0][0]) >= 0 {
+            e.dir = fields[0][0] == 'd'
+            e.name = rest
+            if fields[0][0] == 'l' {
+                if i := strings.Index(rest, " -> "); i >= 0 {
+                    e.name = rest[:i]
+                }
+            }
+        } else if fields, rest := splitFields(line, 3); fields != nil && strings.Count(fields[0], "-") == 2 {
+            e.dir = fields[2] == "<DIR>"
+            e.name = rest
+        }
+        if e.name == "" || e.name == "." || e.name == ".." {
+            continue
+        }
+        entries = append(entries, e)
+    }
+    return entries
+}
+
+// The splitFields returns the first n fields of the line separated by spaces and the rest of it, or nil if
+// the line has not so many fields.
+func splitFields(line string, n int) ([]string, string) {
+    fields := make([]string, 0, n)
+    s := line
+    for i := 0; i < n; i++ {
+        s = strings.TrimLeft(s, " ")
+        j := strings.IndexByte(s, ' ')
+        if j <= 0 {
+            return nil, ""
+        }
+        fields = append(fields, s[:j])
+        s = s[j:]
+    }
+    return fields, strings.TrimLeft(s, " ")
+}
@@ -0,0 +1,397 @@
+package downloader
+
+import (
+    "bufio"
+    "context"
+    "crypto/tls"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "golang.org/x/net/websocket"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The defaultReconnectDelay is wait before reconnecting a broken stream if it is not set.
+const defaultReconnectDelay = time.Second
+
+// The SetStreamLimits sets limits of streams of "sse" and "websocket" pages, like how long they are open and
+// how many times they are reconnected. Fields of stream limits of each request override them. Default is
+// no limit and no reconnect.
+func (this *HttpDownloader) SetStreamLimits(l request.StreamLimits) *HttpDownloader {
+    this.locker.Lock()
+    this.streamLimits = l
+    this.locker.Unlock()
+    return this
+}
+
+func (this *HttpDownloader) GetStreamLimits() request.StreamLimits {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.streamLimits
+}
+
+// The messageStream is a connected stream of messages.
+type messageStream interface {
+    // The next returns the next message, or io.EOF if the server ends the stream.
+    next() (page.Message, error)
+    close()
+}
+
+// The downloadMessages connects the stream of "sse" or "websocket" page, and sends its messages to
+// Page.Messages while the page is processed. Server-sent events are requested by the method, header and
+// postdata of the request like other pages; websocket urls are "ws" or "wss", or "http" or "https" of the
+// same endpoint, and postdata of the request is sent as a text message after each connection. Websockets
+// are not sent by proxies. The page fails if the stream can not be connected; broken streams are
+// reconnected by the stream limits. Page.CloseBody stops the stream.
+func (this *HttpDownloader) downloadMessages(p *page.Page, req *request.Request) *page.Page {
+    if len(req.GetUrl()) == 0 {
+        logger.Error("url is empty")
+        p.SetStatus(true, "url is empty")
+        return p
+    }
+    limits := req.GetStreamLimits().Merge(this.GetStreamLimits())
+    ctx, cancel := context.WithCancel(p.Context())
+    if limits.MaxDuration > 0 {
+        var cancelDuration context.CancelFunc
+        ctx, cancelDuration = context.WithTimeout(ctx, limits.MaxDuration)
+        cancelParent := cancel
+        cancel = func() {
+            cancelDuration()
+            cancelParent()
+        }
+    }
+    p.SetContext(ctx)
+
+    stream, err := this.connectMessages(ctx, p, req, "")
+    if err != nil {
+        cancel()
+        logger.Error(err.Error(), mlog.F("url", req.GetUrl()))
+        p.SetStatus(true, err.Error())
+        return p
+    }
+    messages := make(chan page.Message)
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        this.receiveMessages(ctx, p, req, stream, limits, messages)
+    }()
+    p.SetMessages(messages, closerFunc(func() error {
+        cancel()
+        <-done
+        return nil
+    }))
+    p.SetStatus(false, "")
+    return p
+}
+
+// The closerFunc is a function used as io.Closer.
+type closerFunc func() error
+
+func (this closerFunc) Close() error {
+    return this()
+}
+
+// The receiveMessages sends messages of the stream to the channel until the context is done, the stream
+// limits are reached, or the stream is broken more times than reconnects, which fails the page. The channel
+// is closed when it returns.
+func (this *HttpDownloader) receiveMessages(ctx context.Context, p *page.Page, req *request.Request,
+    stream messageStream, limits request.StreamLimits, messages chan<- page.Message) {
+    defer close(messages)
+    delay := limits.ReconnectDelay
+    if delay <= 0 {
+        delay = defaultReconnectDelay
+    }
+    var lastId string
+    var lastErr error
+    received, failures := 0, 0
+    for {
+        if stream == nil {
+            if failures > limits.Reconnects {
+                if lastErr != io.EOF {
+                    logger.Error("stream fails : "+lastErr.Error(), mlog.F("url", req.GetUrl()))
+                    p.SetStatus(true, lastErr.Error())
+                }
+                return
+            }
+            select {
+            case <-time.After(delay):
+            case <-ctx.Done():
+                return
+            }
+            // the page is being processed, so the stream is reconnected by another page
+            rp := page.NewPage(req)
+            rp.SetContext(ctx)
+            rp.SetProxyHost(p.GetProxyHost())
+            var err error
+            if stream, err = this.connectMessages(ctx, rp, req, lastId); err != nil {
+                if ctx.Err() != nil {
+                    return
+                }
+                lastErr = err
+                failures++
+                continue
+            }
+        }
+
+        msg, err := stream.next()
+        if err != nil {
+            if s, ok := stream.(*sseStream); ok && s.retry > 0 {
+                delay = s.retry
+            }
+            stream.close()
+            stream = nil
+            if ctx.Err() != nil {
+                return
+            }
+            lastErr = err
+            failures++
+            if failures <= limits.Reconnects {
+                logger.Warn("stream is broken, reconnecting", mlog.F("url", req.GetUrl()), mlog.F("error", err))
+            }
+            continue
+        }
+        failures = 0
+        lastId = msg.Id
+        select {
+        case messages <- msg:
+        case <-ctx.Done():
+            stream.close()
+            return
+        }
+        if received++; limits.MaxMessages > 0 && received >= limits.MaxMessages {
+            stream.close()
+            return
+        }
+    }
+}
+
+// The connectMessages connects the stream of the page, and sets header of the responce to the page.
+func (this *HttpDownloader) connectMessages(ctx context.Context, p *page.Page, req *request.Request, lastId string) (messageStream, error) {
+    if req.GetResponceType() == "websocket" {
+        return this.dialWebsocket(ctx, p, req)
+    }
+    header := http.Header{"Accept": {"text/event-stream"}, "Cache-Control": {"no-cache"}, "Accept-Encoding": {"identity"}}
+    if lastId != "" {
+        header.Set("Last-Event-ID", lastId)
+    }
+    resp, err := this.get(p, header)
+    if err != nil {
+        return nil, err
+    }
+    p.SetResponse(resp)
+    p.SetStatusCode(resp.StatusCode)
+    p.SetHeader(resp.Header)
+    p.SetCookies(resp.Cookies())
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        resp.Body.Close()
+        return nil, errors.New("http status " + strconv.Itoa(resp.StatusCode))
+    }
+    return &sseStream{body: resp.Body, r: bufio.NewReader(resp.Body), lastId: lastId, maxSize: this.maxBodySize}, nil
+}
+
+// The sseStream reads server-sent events of text/event-stream body.
+type sseStream struct {
+    body    io.ReadCloser
+    r       *bufio.Reader
+    lastId  string
+    maxSize int64
+
+    // The retry is reconnect delay set by the "retry" field.
+    retry time.Duration
+}
+
+func (this *sseStream) next() (page.Message, error) {
+    var data strings.Builder
+    var event string
+    hasData := false
+    for {
+        line, err := this.r.ReadString('\n')
+        if err != nil {
+            // the event not ended by a blank line is dropped
+            return page.Message{}, err
+        }
+        line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+        if line == "" {
+            if !hasData {
+                event = ""
+                continue
+            }
+            if event == "" {
+                event = "message"
+            }
+            return page.Message{Event: event, Id: this.lastId, Data: strings.TrimSuffix(data.String(), "\n"),
+                Time: time.Now()}, nil
+        }
+        if strings.HasPrefix(line, ":") {
+            // comment, like keep-alive of servers
+            continue
+        }
+        field, value, _ := strings.Cut(line, ":")
+        value = strings.TrimPrefix(value, " ")
+        switch field {
+        case "event":
+            event = value
+        case "data":
+            data.WriteString(value)
+            data.WriteByte('\n')
+            hasData = true
+            if this.maxSize > 0 && int64(data.Len()) > this.maxSize {
+                return page.Message{}, errors.New("message is larger than " + strconv.FormatInt(this.maxSize, 10) + " bytes")
+            }
+        case "id":
+            if !strings.Contains(value, "\x00") {
+                this.lastId = value
+            }
+        case "retry":
+            if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+                this.retry = time.Duration(ms) * time.Millisecond
+            }
+        }
+    }
+}
+
+func (this *sseStream) close() {
+    this.body.Close()
+}
+
+// The dialWebsocket connects the websocket of the request by dial function of HttpDownloader, with header,
+// User-Agent and cookies of the request, and sends postdata of the request.
+func (this *HttpDownloader) dialWebsocket(ctx context.Context, p *page.Page, req *request.Request) (messageStream, error) {
+    if p.GetProxyHost() != "" {
+        return nil, errors.New("websocket is not supported by proxy")
+    }
+    u, err := url.Parse(req.GetUrl())
+    if err != nil {
+        return nil, err
+    }
+    location, origin := *u, url.URL{Host: u.Host}
+    secure := false
+    switch u.Scheme {
+    case "ws", "http":
+        location.Scheme, origin.Scheme = "ws", "http"
+    case "wss", "https":
+        location.Scheme, origin.Scheme = "wss", "https"
+        secure = true
+    default:
+        return nil, errors.New("websocket url is not supported : " + req.GetUrl())
+    }
+    location.Fragment = ""
+    config, err := websocket.NewConfig(location.String(), origin.String())
+    if err != nil {
+        return nil, err
+    }
+    for key, values := range req.GetHeader() {
+        config.Header[key] = values
+    }
+    if o := config.Header.Get("Origin"); o != "" {
+        if config.Origin, err = url.ParseRequestURI(o); err != nil {
+            return nil, err
+        }
+        config.Header.Del("Origin")
+    }
+    if ua := this.userAgentFor(req, ""); ua != "" {
+        config.Header.Set("User-Agent", ua)
+    }
+    cookieUrl := *u
+    cookieUrl.Scheme = origin.Scheme
+    if jar := this.cookieJarFor(req); jar != nil {
+        var cookies []string
+        for _, c := range jar.Cookies(&cookieUrl) {
+            cookies = append(cookies, c.Name+"="+c.Value)
+        }
+        if len(cookies) > 0 {
+            config.Header.Set("Cookie", strings.Join(cookies, "; "))
+        }
+    }
+
+    addr := u.Host
+    if u.Port() == "" && secure {
+        addr = net.JoinHostPort(u.Hostname(), "443")
+    } else if u.Port() == "" {
+        addr = net.JoinHostPort(u.Hostname(), "80")
+    }
+    this.locker.Lock()
+    dial := newDialFunc(this.dialOptions())
+    this.locker.Unlock()
+    conn, err := dial(ctx, "tcp", addr)
+    if err != nil {
+        return nil, err
+    }
+    p.SetRemoteAddr(conn.RemoteAddr().String())
+    // the connection is closed when the stream is stopped, which ends blocked reads
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    fail := func(err error) (messageStream, error) {
+        stop()
+        conn.Close()
+        return nil, err
+    }
+    if secure {
+        tlsConfig := &tls.Config{}
+        client, err := this.client("", this.insecureHost(req.GetUrl()), req.GetSession())
+        if err != nil {
+            return fail(err)
+        }
+        if t, ok := client.Transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+            tlsConfig = t.TLSClientConfig.Clone()
+        }
+        tlsConfig.ServerName = u.Hostname()
+        // websocket handshake is http/1.1
+        tlsConfig.NextProtos = nil
+        tlsConn := tls.Client(conn, tlsConfig)
+        if err := tlsConn.HandshakeContext(ctx); err != nil {
+            return fail(err)
+        }
+        conn = tlsConn
+    }
+    ws, err := websocket.NewClient(config, conn)
+    if err != nil {
+        return fail(err)
+    }
+    if this.maxBodySize > 0 {
+        ws.MaxPayloadBytes = int(this.maxBodySize)
+    }
+    if postdata := req.GetPostdata(); postdata != "" {
+        if err := websocket.Message.Send(ws, postdata); err != nil {
+            return fail(err)
+        }
+    }
+    return &websocketStream{ws: ws, stop: stop}, nil
+}
+
+// The websocketStream receives messages of a websocket.
+type websocketStream struct {
+    ws   *websocket.Conn
+    stop func() bool
+}
+
+// The websocketMessage receives a frame with its payload type.
+var websocketMessage = websocket.Codec{Unmarshal: func(data []byte, payloadType byte, v interface{}) error {
+    msg := v.(*page.Message)
+    msg.Event = "text"
+    if payloadType == websocket.BinaryFrame {
+        msg.Event = "binary"
+    }
+    msg.Data = string(data)
+    return nil
+}}
+
+func (this *websocketStream) next() (page.Message, error) {
+    var msg page.Message
+    if err := websocketMessage.Receive(this.ws, &msg); err != nil {
+        return page.Message{}, err
+    }
+    msg.Time = time.Now()
+    return msg, nil
+}
+
+func (this *websocketStream) close() {
+    this.stop()
+    this.ws.Close()
+}
@@ -0,0 +1,87 @@
+package downloader
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+)
+
+// The RequestMiddleware interface modifies requests before they are downloaded, like signing headers or
+// adding auth tokens. Function ProcessRequest returns the request to download, which can be the same one or
+// a new one. If it returns a page, the page is used without download and following middlewares are skipped.
+type RequestMiddleware interface {
+    ProcessRequest(req *request.Request) (*request.Request, *page.Page)
+}
+
+// The ResponseMiddleware interface inspects pages after they are downloaded, like detecting captcha or bans.
+// Function ProcessResponse returns the page, which can be modified or set failed by SetStatus so that Spider
+// retries the request.
+type ResponseMiddleware interface {
+    ProcessResponse(p *page.Page) *page.Page
+}
+
+// The RequestMiddlewareFunc is a function used as RequestMiddleware.
+type RequestMiddlewareFunc func(req *request.Request) (*request.Request, *page.Page)
+
+func (this RequestMiddlewareFunc) ProcessRequest(req *request.Request) (*request.Request, *page.Page) {
+    return this(req)
+}
+
+// The ResponseMiddlewareFunc is a function used as ResponseMiddleware.
+type ResponseMiddlewareFunc func(p *page.Page) *page.Page
+
+func (this ResponseMiddlewareFunc) ProcessResponse(p *page.Page) *page.Page {
+    return this(p)
+}
+
+// The MiddlewareDownloader calls middlewares around each download of another Downloader.
+// Request middlewares are called in the order they are added, and response middlewares in reverse order,
+// so the first middleware added is the outermost one. Unlike middlewares of Spider, they are called for
+// every retry, and pages set failed by them are retried by Spider.
+type MiddlewareDownloader struct {
+    d Downloader
+
+    requestMiddlewares  []RequestMiddleware
+    responseMiddlewares []ResponseMiddleware
+}
+
+// NewMiddlewareDownloader returns MiddlewareDownloader of the downloader, like HttpDownloader.
+func NewMiddlewareDownloader(d Downloader) *MiddlewareDownloader {
+    return &MiddlewareDownloader{d: d}
+}
+
+// The GetDownloader returns the downloader wrapped.
+func (this *MiddlewareDownloader) GetDownloader() Downloader {
+    return this.d
+}
+
+// The AddRequestMiddleware adds middleware called before download.
+func (this *MiddlewareDownloader) AddRequestMiddleware(m RequestMiddleware) *MiddlewareDownloader {
+    this.requestMiddlewares = append(this.requestMiddlewares, m)
+    return this
+}
+
+// The AddResponseMiddleware adds middleware called after download.
+func (this *MiddlewareDownloader) AddResponseMiddleware(m ResponseMiddleware) *MiddlewareDownloader {
+    this.responseMiddlewares = append(this.responseMiddlewares, m)
+    return this
+}
+
+func (this *MiddlewareDownloader) Download(req *request.Request) *page.Page {
+    return this.DownloadContext(req.Context(), req)
+}
+
+// The DownloadContext calls middlewares around DownloadContext of the wrapped Downloader.
+func (this *MiddlewareDownloader) DownloadContext(ctx context.Context, req *request.Request) *page.Page {
+    var p *page.Page
+    for _, m := range this.requestMiddlewares {
+        if req, p = m.ProcessRequest(req); p != nil {
+            return p
+        }
+    }
+    p = DownloadContext(ctx, this.d, req)
+    for i := len(this.responseMiddlewares) - 1; i >= 0; i-- {
+        p = this.responseMiddlewares[i].ProcessResponse(p)
+    }
+    return p
+}
@@ -0,0 +1,55 @@
+package downloader_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestMiddlewareDownloader(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Authorization") != "Bearer token" {
+            http.Error(w, "denied", http.StatusUnauthorized)
+            return
+        }
+        w.Write([]byte("please solve the captcha"))
+    }))
+    defer ts.Close()
+
+    var order []string
+    d := downloader.NewMiddlewareDownloader(downloader.NewHttpDownloader()).
+        AddRequestMiddleware(downloader.RequestMiddlewareFunc(func(req *request.Request) (*request.Request, *page.Page) {
+            if strings.HasSuffix(req.GetUrl(), "/offline") {
+                p := page.NewPage(req)
+                p.SetBodyStr("offline")
+                return req, p
+            }
+            return req.SetHeader("Authorization", "Bearer token"), nil
+        })).
+        AddResponseMiddleware(downloader.ResponseMiddlewareFunc(func(p *page.Page) *page.Page {
+            order = append(order, "outer")
+            return p
+        })).
+        AddResponseMiddleware(downloader.ResponseMiddlewareFunc(func(p *page.Page) *page.Page {
+            order = append(order, "inner")
+            if strings.Contains(p.GetBodyStr(), "captcha") {
+                p.SetStatus(true, "captcha")
+            }
+            return p
+        }))
+
+    p := d.Download(request.NewRequest(ts.URL, "text"))
+    if p.GetStatusCode() != http.StatusOK || p.IsSucc() || p.Errormsg() != "captcha" {
+        t.Errorf("middlewares should sign request and detect captcha: %d %s", p.GetStatusCode(), p.Errormsg())
+    }
+    if strings.Join(order, ",") != "inner,outer" {
+        t.Errorf("response middlewares order error: %v", order)
+    }
+    if p = d.Download(request.NewRequest(ts.URL+"/offline", "text")); p.GetBodyStr() != "offline" {
+        t.Error("request middleware should short-circuit download")
+    }
+}
@@ -0,0 +1,187 @@
+package downloader
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/iana"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// The prefetchIdle is how long a connection dialed ahead waits for its request before it is closed, and how
+// long connections of an address dialed before are thought kept alive by the transport.
+const prefetchIdle = 10 * time.Second
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// The prefetcher dials connections of requests before they are downloaded, and hands them to the transport
+// by its dial function.
+type prefetcher struct {
+    locker sync.Mutex
+    dial   dialFunc
+
+    // The conns saves connections dialed ahead by address.
+    conns map[string]*prefetchedConn
+
+    // The dialed saves the last time a connection of each address is dialed.
+    dialed map[string]time.Time
+
+    prefetched int64
+    used       int64
+}
+
+type prefetchedConn struct {
+    conn  net.Conn
+    timer *time.Timer
+}
+
+func newPrefetcher() *prefetcher {
+    return &prefetcher{conns: make(map[string]*prefetchedConn), dialed: make(map[string]time.Time)}
+}
+
+// The wrap returns dial function of the transport that takes connections dialed ahead before dialing by dial.
+func (this *prefetcher) wrap(dial dialFunc) dialFunc {
+    this.locker.Lock()
+    this.dial = dial
+    this.locker.Unlock()
+    return func(ctx context.Context, network, addr string) (net.Conn, error) {
+        this.locker.Lock()
+        this.dialed[addr] = time.Now()
+        if pc, ok := this.conns[addr]; ok && network == "tcp" {
+            delete(this.conns, addr)
+            pc.timer.Stop()
+            this.used++
+            this.locker.Unlock()
+            return pc.conn, nil
+        }
+        this.locker.Unlock()
+        return dial(ctx, network, addr)
+    }
+}
+
+// The prefetch dials a connection of the address in background, unless one is dialed ahead already or
+// connections of the address are dialed lately, which are likely kept alive.
+func (this *prefetcher) prefetch(addr string) {
+    this.locker.Lock()
+    now := time.Now()
+    if _, ok := this.conns[addr]; ok || now.Sub(this.dialed[addr]) < prefetchIdle || this.dial == nil {
+        this.locker.Unlock()
+        return
+    }
+    if len(this.dialed) > 4096 {
+        for a, t := range this.dialed {
+            if now.Sub(t) >= prefetchIdle {
+                delete(this.dialed, a)
+            }
+        }
+    }
+    this.dialed[addr] = now
+    dial := this.dial
+    this.locker.Unlock()
+
+    go func() {
+        ctx, cancel := context.WithTimeout(context.Background(), prefetchIdle)
+        conn, err := dial(ctx, "tcp", addr)
+        cancel()
+        if err != nil {
+            logger.Debug("prefetch fails", mlog.F("addr", addr), mlog.F("error", err))
+            return
+        }
+        this.locker.Lock()
+        defer this.locker.Unlock()
+        if _, ok := this.conns[addr]; ok {
+            conn.Close()
+            return
+        }
+        pc := &prefetchedConn{conn: conn}
+        pc.timer = time.AfterFunc(prefetchIdle, func() {
+            this.locker.Lock()
+            stale := this.conns[addr] == pc
+            if stale {
+                delete(this.conns, addr)
+            }
+            this.locker.Unlock()
+            if stale {
+                conn.Close()
+            }
+        })
+        this.conns[addr] = pc
+        this.prefetched++
+    }()
+}
+
+// The SetPrefetch sets whether Prefetch dials connections of requests ahead, so dns lookup and tcp handshake
+// of hosts not connected are done while other requests are downloaded. Connections not used in 10 seconds
+// are closed. TLS handshakes are done by the transport on the connections, with its timeouts. It replaces
+// dial function of the transport, so it should be called after SetTransport.
+func (this *HttpDownloader) SetPrefetch(enable bool) *HttpDownloader {
+    return this.tuneTransport(func(t *http.Transport) {
+        this.prefetcher = nil
+        if enable {
+            this.prefetcher = newPrefetcher()
+        }
+        t.DialContext = this.dialFunc()
+    })
+}
+
+// The Prefetch resolves host of the request and dials a connection to it in background if SetPrefetch is
+// set, like for requests about to be polled from Scheduler. Requests sent by proxies are not prefetched.
+func (this *HttpDownloader) Prefetch(req *request.Request) {
+    this.locker.Lock()
+    p := this.prefetcher
+    proxied := this.proxyHost != "" || this.proxyPool != nil || this.geoRouter != nil && this.geoRouter.hasProxies()
+    this.locker.Unlock()
+    if p == nil || proxied || req.GetProxyHost() != "" {
+        return
+    }
+    u, err := url.Parse(req.GetUrl())
+    if err != nil || u.Hostname() == "" {
+        return
+    }
+    port := u.Port()
+    if port == "" {
+        if u.Scheme != "http" && u.Scheme != "https" {
+            return
+        }
+        n, _ := iana.ServicePort(u.Scheme)
+        port = strconv.Itoa(n)
+    }
+    p.prefetch(net.JoinHostPort(u.Hostname(), port))
+}
+
+// The GetPrefetchStats returns number of connections dialed ahead by Prefetch, and of them used by downloads.
+func (this *HttpDownloader) GetPrefetchStats() (prefetched, used int64) {
+    this.locker.Lock()
+    p := this.prefetcher
+    this.locker.Unlock()
+    if p == nil {
+        return 0, 0
+    }
+    p.locker.Lock()
+    defer p.locker.Unlock()
+    return p.prefetched, p.used
+}
+
+// The DialContext dials the address like connections of downloads, by the resolver, local addresses, ip
+// family and socket options of the downloader, but not by its proxies or connections of Prefetch.
+func (this *HttpDownloader) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+    this.locker.Lock()
+    opts := this.dialOptions()
+    this.locker.Unlock()
+    return newDialFunc(opts)(ctx, network, addr)
+}
+
+// The dialFunc returns dial function of the transport by the resolver and local addresses, which takes
+// connections of the prefetcher first.
+func (this *HttpDownloader) dialFunc() dialFunc {
+    dial := dialFunc(newDialFunc(this.dialOptions()))
+    if this.prefetcher != nil {
+        return this.prefetcher.wrap(dial)
+    }
+    return dial
+}
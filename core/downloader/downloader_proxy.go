@@ -0,0 +1,352 @@
+package downloader
+
+import (
+    "context"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "golang.org/x/net/proxy"
+    "io"
+    "net"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+)
+
+// The NormalizeProxy returns url of the proxy in formats of proxy services: "host:port" and
+// "user:pass@host:port" are http proxies, and "host:port:user:pass" is a http proxy with the credentials,
+// which are escaped in the url. Urls with scheme are returned as they are. It returns error if the proxy is
+// none of them, or its scheme is not "http", "https", "socks5" or "socks5h".
+func NormalizeProxy(proxy string) (string, error) {
+    proxy = strings.TrimSpace(proxy)
+    if !strings.Contains(proxy, "://") {
+        // the password may have ":" and "@"
+        parts := strings.SplitN(proxy, ":", 4)
+        switch {
+        case len(parts) == 4 && isPort(parts[1]):
+            u := &url.URL{Scheme: "http", Host: parts[0] + ":" + parts[1], User: url.UserPassword(parts[2], parts[3])}
+            proxy = u.String()
+        case strings.Contains(proxy, "@"), len(parts) == 2:
+            proxy = "http://" + proxy
+        default:
+            return "", errors.New("proxy is not a url or host:port:user:pass : " + RedactProxy(proxy))
+        }
+    }
+    u, err := url.Parse(proxy)
+    if err != nil {
+        return "", errors.New("proxy is not a url : " + RedactProxy(proxy))
+    }
+    switch u.Scheme {
+    case "http", "https", "socks5", "socks5h":
+    default:
+        return "", errors.New("proxy scheme is not supported : " + u.Redacted())
+    }
+    if u.Host == "" {
+        return "", errors.New("proxy has no host : " + u.Redacted())
+    }
+    return proxy, nil
+}
+
+// The isPort tests whether s is a port number.
+func isPort(s string) bool {
+    n, err := strconv.Atoi(s)
+    return err == nil && n > 0 && n < 65536
+}
+
+// The RedactProxy returns the proxy with its password replaced by "xxxxx", for logs.
+func RedactProxy(proxy string) string {
+    if u, err := url.Parse(proxy); err == nil && u.Host != "" {
+        return u.Redacted()
+    }
+    if parts := strings.SplitN(proxy, ":", 4); len(parts) == 4 && isPort(parts[1]) {
+        return parts[0] + ":" + parts[1] + ":" + parts[2] + ":xxxxx"
+    }
+    if i := strings.LastIndex(proxy, "@"); i >= 0 {
+        return "xxxxx" + proxy[i:]
+    }
+    return proxy
+}
+
+// The newProxyTransport returns transport cloned from base that sends requests by the proxy in a format of
+// NormalizeProxy. The "http" and "https" proxies are used by http.Transport directly, which sends credentials
+// of the proxy by Proxy-Authorization header, also with CONNECT of https targets; "socks5" and "socks5h"
+// proxies are dialed by package golang.org/x/net/proxy, with username and password authentication. Target
+// hosts of "socks5" proxies are resolved by the resolver, or by the system resolver if it is nil.
+func newProxyTransport(base *http.Transport, proxyHost string, resolver Resolver) (*http.Transport, error) {
+    proxyHost, err := NormalizeProxy(proxyHost)
+    if err != nil {
+        return nil, err
+    }
+    u, err := url.Parse(proxyHost)
+    if err != nil {
+        return nil, err
+    }
+
+    transport := base.Clone()
+    transport.Proxy = nil
+    switch u.Scheme {
+    case "http", "https":
+        transport.Proxy = http.ProxyURL(u)
+    case "socks5", "socks5h":
+        var auth *proxy.Auth
+        if u.User != nil {
+            password, _ := u.User.Password()
+            auth = &proxy.Auth{User: u.User.Username(), Password: password}
+        }
+        dialer, err := proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
+        if err != nil {
+            return nil, err
+        }
+        cd, ok := dialer.(proxy.ContextDialer)
+        if !ok {
+            return nil, errors.New("socks5 dialer does not support context")
+        }
+        if u.Scheme == "socks5h" {
+            // the proxy resolves target host
+            transport.DialContext = cd.DialContext
+        } else {
+            transport.DialContext = localResolveDial(cd.DialContext, resolver)
+        }
+    }
+    return transport, nil
+}
+
+// The localResolveDial resolves target host by the resolver before dialing, so the proxy gets ip address only.
+func localResolveDial(dial func(ctx context.Context, network, addr string) (net.Conn, error), resolver Resolver) func(ctx context.Context, network, addr string) (net.Conn, error) {
+    if resolver == nil {
+        resolver = net.DefaultResolver
+    }
+    return func(ctx context.Context, network, addr string) (net.Conn, error) {
+        host, port, err := net.SplitHostPort(addr)
+        if err != nil {
+            return nil, err
+        }
+        if net.ParseIP(host) == nil {
+            addrs, err := resolver.LookupHost(ctx, host)
+            if err != nil {
+                return nil, err
+            }
+            if len(addrs) == 0 {
+                return nil, errors.New("no address of host : " + host)
+            }
+            host = addrs[0]
+        }
+        return dial(ctx, network, net.JoinHostPort(host, port))
+    }
+}
+
+// The proxyFor returns proxy of the request, or proxy of its session, or a proxy of ProxyPool, or the proxy
+// of HttpDownloader.
+func (this *HttpDownloader) proxyFor(req *request.Request) string {
+    if proxyHost := req.GetProxyHost(); proxyHost != "" {
+        return this.proxyCredentials(proxyHost)
+    }
+    if s := this.sessionFor(req); s != nil && s.GetProxyHost() != "" {
+        return s.GetProxyHost()
+    }
+    if this.geoRouter != nil {
+        if u, err := url.Parse(req.GetUrl()); err == nil && u.Hostname() != "" {
+            if pool := this.geoRouter.proxyPool(req.Context(), u.Hostname()); pool != nil {
+                if proxyHost := pool.Get(); proxyHost != "" {
+                    return proxyHost
+                }
+            }
+        }
+    }
+    if this.proxyPool != nil {
+        if proxyHost := this.proxyPool.Get(); proxyHost != "" {
+            return proxyHost
+        }
+    }
+    return this.proxyHost
+}
+
+// The proxyCredentials returns the proxy with credentials of the same proxy in HttpDownloader, its sessions or
+// proxy pools, as proxies of requests restored from checkpoints, dead letters or shared schedulers are
+// serialized without credentials by request.ProxyId. The proxy is returned as it is if it has credentials or
+// none of them is the same.
+func (this *HttpDownloader) proxyCredentials(proxyHost string) string {
+    if normalized, err := NormalizeProxy(proxyHost); err != nil {
+        return proxyHost
+    } else if u, err := url.Parse(normalized); err != nil || u.User != nil {
+        return proxyHost
+    }
+    id := request.ProxyId(proxyHost)
+    this.locker.Lock()
+    candidates := []string{this.proxyHost}
+    for _, s := range this.sessions {
+        candidates = append(candidates, s.GetProxyHost())
+    }
+    this.locker.Unlock()
+    pools := []*ProxyPool{this.proxyPool}
+    if this.geoRouter != nil {
+        pools = append(pools, this.geoRouter.proxyPools()...)
+    }
+    for _, pool := range pools {
+        if pool != nil {
+            candidates = append(candidates, pool.list()...)
+        }
+    }
+    for _, candidate := range candidates {
+        if candidate != "" && candidate != proxyHost && request.ProxyId(candidate) == id {
+            return candidate
+        }
+    }
+    return proxyHost
+}
+
+// The userAgentFor returns User-Agent header of the request, or User-Agent of its session, or one of
+// UserAgentPool if it is not set.
+func (this *HttpDownloader) userAgentFor(req *request.Request, proxyHost string) string {
+    if ua := req.GetHeader().Get("User-Agent"); ua != "" {
+        return ua
+    }
+    if s := this.sessionFor(req); s != nil && s.GetUserAgent() != "" {
+        return s.GetUserAgent()
+    }
+    if this.userAgents == nil {
+        return ""
+    }
+    return this.userAgents.Get(req.GetUrl(), proxyHost)
+}
+
+// The client returns http client of the proxy, which has the cookie jar of the session, or of HttpDownloader
+// if session is "", and the transport of HttpDownloader.
+// If insecure is true, certificates of hosts are not verified by the client.
+// Clients are cached for each proxy and session so that connections are reused.
+func (this *HttpDownloader) client(proxyHost string, insecure bool, session string) (*http.Client, error) {
+    key := proxyHost
+    if insecure {
+        key += " insecure"
+    }
+    jar := this.jar
+    if session != "" {
+        key += " session=" + session
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if client, ok := this.clients[key]; ok {
+        return client, nil
+    }
+    if session != "" {
+        jar = this.session(session).GetCookieJar()
+    }
+    if this.transport == nil {
+        this.transport = NewTransport()
+    }
+    base := this.transport
+    if insecure {
+        base = insecureTransport(base)
+    }
+    client := &http.Client{Jar: jar, Transport: base, CheckRedirect: this.checkRedirect}
+    if this.roundTripper != nil {
+        client.Transport = this.roundTripper
+    } else if proxyHost != "" {
+        transport, err := newProxyTransport(base, proxyHost, this.resolver)
+        if err != nil {
+            return nil, err
+        }
+        client.Transport = transport
+    }
+    if this.clients == nil {
+        this.clients = make(map[string]*http.Client)
+    }
+    this.clients[key] = client
+    return client, nil
+}
+
+// The GetClient returns http client of the default proxy, which has the cookie jar and transport of
+// HttpDownloader, like for login requests sharing the session with the crawl.
+func (this *HttpDownloader) GetClient() (*http.Client, error) {
+    return this.client(this.proxyHost, false, "")
+}
+
+// The GetSessionClient returns http client of the session of the key, which has its cookie jar and proxy,
+// like for login requests of its account.
+func (this *HttpDownloader) GetSessionClient(key string) (*http.Client, error) {
+    this.locker.Lock()
+    proxyHost := this.session(key).GetProxyHost()
+    if proxyHost == "" {
+        proxyHost = this.proxyHost
+    }
+    this.locker.Unlock()
+    return this.client(proxyHost, false, key)
+}
+
+// The get sends request of the page with its method, postdata and header, and the extra header,
+// by http client of proxy of the page.
+func (this *HttpDownloader) get(p *page.Page, header http.Header) (*http.Response, error) {
+    req := p.GetRequest()
+    client, err := this.client(p.GetProxyHost(), this.insecureHost(req.GetUrl()), req.GetSession())
+    if err != nil {
+        return nil, err
+    }
+    var body io.Reader
+    if req.GetPostdata() != "" {
+        body = strings.NewReader(req.GetPostdata())
+    }
+    httpreq, err := http.NewRequestWithContext(p.Context(), req.GetMethod(), req.GetUrl(), body)
+    if err != nil {
+        return nil, err
+    }
+    if referer := req.GetReferer(); referer != "" {
+        httpreq.Header.Set("Referer", referer)
+    }
+    session := this.sessionFor(req)
+    if session != nil {
+        for key, values := range session.GetHeader() {
+            httpreq.Header[key] = values
+        }
+    }
+    for key, values := range req.GetHeader() {
+        httpreq.Header[key] = values
+    }
+    if host := httpreq.Header.Get("Host"); host != "" {
+        httpreq.Host = host
+    }
+    if body != nil && httpreq.Header.Get("Content-Type") == "" {
+        // like a browser submitting a form
+        httpreq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    }
+    if ua := this.userAgentFor(req, p.GetProxyHost()); ua != "" {
+        httpreq.Header.Set("User-Agent", ua)
+    }
+    profile := this.headerProfile
+    if session != nil && session.GetHeaderProfile() != nil {
+        profile = session.GetHeaderProfile()
+    }
+    if profile != nil {
+        for key, values := range profile.Headers(req, httpreq.Header.Get("User-Agent")) {
+            if httpreq.Header.Get(key) == "" {
+                httpreq.Header[key] = values
+            }
+        }
+    }
+    for key, values := range header {
+        httpreq.Header[key] = values
+    }
+    if !this.disableCompression && httpreq.Header.Get("Accept-Encoding") == "" {
+        httpreq.Header.Set("Accept-Encoding", acceptEncoding)
+    }
+
+    httpreq = withRemoteAddr(withTrace(httpreq), p)
+    httpreq, release := this.withTimeouts(httpreq, req)
+    resp, err := this.roundTrip(client, httpreq)
+    if err != nil {
+        release(nil)
+        err = timeoutCause(httpreq, err)
+        p.SetErrorClass(ClassifyError(err))
+        return nil, err
+    }
+    resp.Body = this.bandwidth.throttle(httpreq.Context(), req.GetUrl(), resp.Body)
+    if !this.disableCompression {
+        if err = decompress(resp); err != nil {
+            resp.Body.Close()
+            release(nil)
+            return nil, err
+        }
+    }
+    resp.Body = &timeoutBody{ReadCloser: resp.Body, httpreq: httpreq, release: release}
+    return resp, nil
+}
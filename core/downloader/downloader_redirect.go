@@ -0,0 +1,62 @@
+package downloader
+
+import (
+    "errors"
+    "golang.org/x/net/publicsuffix"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// The ErrCrossDomainRedirect is error of a download redirected to other domain when it is forbidden by
+// SetSameDomainRedirects.
+var ErrCrossDomainRedirect = errors.New("redirect to other domain is forbidden")
+
+// The defaultMaxRedirects is how many redirects are followed by default, same as package net/http.
+const defaultMaxRedirects = 10
+
+// The SetMaxRedirects limits redirects followed by a download, and the download is failed after more
+// redirects. The n 0 does not follow redirects, and the page is the 3xx responce. Default is 10.
+func (this *HttpDownloader) SetMaxRedirects(n int) *HttpDownloader {
+    this.locker.Lock()
+    this.maxRedirects = n
+    this.locker.Unlock()
+    return this
+}
+
+// The SetSameDomainRedirects sets whether a download is failed when it is redirected to other domain,
+// like a parked domain or a login page of a third party. Subdomains of the same registered domain,
+// like "example.com" and "www.example.com", are the same domain.
+func (this *HttpDownloader) SetSameDomainRedirects(same bool) *HttpDownloader {
+    this.locker.Lock()
+    this.sameDomainRedirects = same
+    this.locker.Unlock()
+    return this
+}
+
+// The checkRedirect is CheckRedirect of http clients, which applies the redirect policy.
+func (this *HttpDownloader) checkRedirect(req *http.Request, via []*http.Request) error {
+    this.locker.Lock()
+    max, same := this.maxRedirects, this.sameDomainRedirects
+    this.locker.Unlock()
+    if max == 0 {
+        return http.ErrUseLastResponse
+    }
+    if len(via) > max {
+        return errors.New("stopped after " + strconv.Itoa(max) + " redirects")
+    }
+    if same && registeredDomain(req.URL.Hostname()) != registeredDomain(via[0].URL.Hostname()) {
+        return ErrCrossDomainRedirect
+    }
+    return nil
+}
+
+// The registeredDomain returns domain of the host registered under a public suffix, like "example.co.uk"
+// of "www.example.co.uk", or the host itself if it is an ip address or a public suffix.
+func registeredDomain(host string) string {
+    host = strings.ToLower(host)
+    if domain, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+        return domain
+    }
+    return host
+}
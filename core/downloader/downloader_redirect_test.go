@@ -0,0 +1,62 @@
+package downloader_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestRedirectPolicy(t *testing.T) {
+    var ts *httptest.Server
+    ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/a":
+            http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+        case "/b":
+            http.Redirect(w, r, "/c", http.StatusFound)
+        case "/other":
+            // same server by other host name
+            http.Redirect(w, r, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)+"/c", http.StatusFound)
+        default:
+            w.Write([]byte("final"))
+        }
+    }))
+    defer ts.Close()
+
+    d := downloader.NewHttpDownloader()
+    p := d.Download(request.NewRequest(ts.URL+"/a", "text"))
+    if !p.IsSucc() || p.GetBodyStr() != "final" || p.GetFinalUrl() != ts.URL+"/c" {
+        t.Fatalf("redirects should be followed: %s %s", p.Errormsg(), p.GetFinalUrl())
+    }
+    expected := []page.Redirect{
+        {Url: ts.URL + "/a", StatusCode: http.StatusMovedPermanently, Location: ts.URL + "/b"},
+        {Url: ts.URL + "/b", StatusCode: http.StatusFound, Location: ts.URL + "/c"},
+    }
+    if redirects := p.GetRedirects(); !reflect.DeepEqual(redirects, expected) {
+        t.Errorf("redirect chain error: %+v", redirects)
+    }
+
+    p = d.SetMaxRedirects(1).Download(request.NewRequest(ts.URL+"/a", "text"))
+    if p.IsSucc() || !strings.Contains(p.Errormsg(), "stopped after 1 redirects") {
+        t.Errorf("redirects over the limit should fail: %s", p.Errormsg())
+    }
+
+    p = d.SetMaxRedirects(0).Download(request.NewRequest(ts.URL+"/a", "text"))
+    if p.GetStatusCode() != http.StatusMovedPermanently || p.GetFinalUrl() != ts.URL+"/a" || len(p.GetRedirects()) != 0 {
+        t.Errorf("redirect should not be followed: %d %s", p.GetStatusCode(), p.GetFinalUrl())
+    }
+
+    d.SetMaxRedirects(10).SetSameDomainRedirects(true)
+    if p = d.Download(request.NewRequest(ts.URL+"/a", "text")); !p.IsSucc() {
+        t.Errorf("redirect of the same domain should be followed: %s", p.Errormsg())
+    }
+    p = d.Download(request.NewRequest(ts.URL+"/other", "text"))
+    if p.IsSucc() || !strings.Contains(p.Errormsg(), downloader.ErrCrossDomainRedirect.Error()) {
+        t.Errorf("redirect to other domain should fail: %s", p.Errormsg())
+    }
+}
@@ -0,0 +1,308 @@
+package downloader
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/md5"
+    "encoding/hex"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "io"
+    "io/ioutil"
+    "mime"
+    "net/http"
+    neturl "net/url"
+    "os"
+    "path"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+)
+
+// The downloadStream copies responce body into a file, or writer of fileWriter, without buffering it in memory.
+// Responces of media type not allowed are not read. The max body size limit is also enforced; the file is removed if body is over the limit and
+// truncateBody is false.
+func (this *HttpDownloader) downloadStream(p *page.Page, req *request.Request) *page.Page {
+    var err error
+    var url string
+    if url = req.GetUrl(); len(url) == 0 {
+        logger.Error("url is empty")
+        p.SetStatus(true, "url is empty")
+        return p
+    }
+
+    var resp *http.Response
+    if resp, err = this.get(p, nil); err != nil {
+        logger.Error(err.Error())
+        p.SetStatus(true, err.Error())
+        return p
+    }
+    defer resp.Body.Close()
+    p.SetResponse(resp)
+    p.SetStatusCode(resp.StatusCode)
+    p.SetHeader(resp.Header)
+    p.SetCookies(resp.Cookies())
+
+    if contentType := resp.Header.Get("Content-Type"); !mediaTypeAllowed(this.fileTypes, contentType) {
+        errmsg := "content type " + contentType + " is not allowed"
+        logger.Warn(errmsg, mlog.F("url", url))
+        p.SetBodyOutcome(page.BodyTypeRejected)
+        p.SetStatus(true, errmsg)
+        return p
+    }
+
+    errmsg := "responce body is larger than " + strconv.FormatInt(this.maxBodySize, 10) + " bytes"
+    if this.maxBodySize > 0 && resp.ContentLength > this.maxBodySize && !this.truncateBody {
+        logger.Error(errmsg, mlog.F("url", url))
+        p.SetBodyOutcome(page.BodyTooLarge)
+        p.SetStatus(true, errmsg)
+        return p
+    }
+    if this.fileWriter != nil {
+        return this.streamToWriter(p, req, resp.Body, errmsg)
+    }
+
+    var body io.Reader = resp.Body
+    if this.maxBodySize > 0 {
+        // read one more byte to find out body over the limit
+        body = io.LimitReader(resp.Body, this.maxBodySize+1)
+    }
+
+    filePath := this.streamFilePath(req)
+    if err = os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+        logger.Error(err.Error())
+        p.SetStatus(true, err.Error())
+        return p
+    }
+
+    // write to temp file first so that a broken file is never left at the path.
+    tmp, err := ioutil.TempFile(filepath.Dir(filePath), "tmp")
+    if err != nil {
+        logger.Error(err.Error())
+        p.SetStatus(true, err.Error())
+        return p
+    }
+    size, err := io.Copy(tmp, body)
+    if err == nil && this.maxBodySize > 0 && size > this.maxBodySize {
+        if this.truncateBody {
+            size = this.maxBodySize
+            err = tmp.Truncate(size)
+            p.SetBodyOutcome(page.BodyTruncated)
+        } else {
+            logger.Error(errmsg, mlog.F("url", url))
+            tmp.Close()
+            os.Remove(tmp.Name())
+            p.SetBodyOutcome(page.BodyTooLarge)
+            p.SetStatus(true, errmsg)
+            return p
+        }
+    }
+    tmp.Close()
+    if err == nil {
+        err = os.Rename(tmp.Name(), filePath)
+    }
+    if err != nil {
+        logger.Error(err.Error())
+        os.Remove(tmp.Name())
+        p.SetStatus(true, err.Error())
+        return p
+    }
+
+    p.SetFile(filePath, size).SetStatus(false, "")
+    return p
+}
+
+// The streamToWriter copies body to the writer of fileWriter.
+func (this *HttpDownloader) streamToWriter(p *page.Page, req *request.Request, body io.Reader, errmsg string) *page.Page {
+    w, err := this.fileWriter(req, p)
+    if err != nil {
+        logger.Error(err.Error())
+        p.SetStatus(true, err.Error())
+        return p
+    }
+    var size int64
+    if this.maxBodySize > 0 {
+        size, err = io.Copy(w, io.LimitReader(body, this.maxBodySize))
+        // one more byte means body over the limit
+        if n, _ := io.ReadFull(body, make([]byte, 1)); err == nil && n > 0 {
+            if this.truncateBody {
+                p.SetBodyOutcome(page.BodyTruncated)
+            } else {
+                p.SetBodyOutcome(page.BodyTooLarge)
+                err = errors.New(errmsg)
+            }
+        }
+    } else {
+        size, err = io.Copy(w, body)
+    }
+    if cerr := w.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        logger.Error(err.Error(), mlog.F("url", req.GetUrl()))
+        p.SetStatus(true, err.Error())
+        return p
+    }
+    p.SetFile("", size).SetStatus(false, "")
+    return p
+}
+
+// The mediaTypeAllowed tests whether media type of the Content-Type is one of the types, like "image/*".
+// Empty types allow all the types.
+func mediaTypeAllowed(types []string, contentType string) bool {
+    if len(types) == 0 {
+        return true
+    }
+    mediatype := "application/octet-stream"
+    if contentType != "" {
+        var err error
+        if mediatype, _, err = mime.ParseMediaType(contentType); err != nil {
+            return false
+        }
+    }
+    for _, t := range types {
+        t = strings.ToLower(t)
+        if t == mediatype || strings.HasSuffix(t, "/*") && strings.HasPrefix(mediatype, t[:len(t)-1]) {
+            return true
+        }
+    }
+    return false
+}
+
+// The streamFilePath returns the file path of request for "file" content.
+func (this *HttpDownloader) streamFilePath(req *request.Request) string {
+    if this.filePath != nil {
+        return this.filePath(req)
+    }
+    dir := this.fileDir
+    if dir == "" {
+        dir = os.TempDir()
+    }
+    sum := md5.Sum([]byte(req.GetUrl()))
+    name := hex.EncodeToString(sum[:])
+    if u, err := neturl.Parse(req.GetUrl()); err == nil {
+        name += path.Ext(u.Path)
+    }
+    return filepath.Join(dir, name)
+}
+
+// The downloadReader leaves responce body open for Page.GetBodyReader instead of reading it, so PageProcesser
+// reads it while it is downloaded. The body is changed to utf-8 by charset of Content-Type header or sniffed
+// from its head, and reading over the max body size fails unless truncateBody is true. Page.CloseBody
+// closes the responce.
+func (this *HttpDownloader) downloadReader(p *page.Page, req *request.Request) *page.Page {
+    var err error
+    var url string
+    if url = req.GetUrl(); len(url) == 0 {
+        logger.Error("url is empty")
+        p.SetStatus(true, "url is empty")
+        return p
+    }
+
+    var resp *http.Response
+    if resp, err = this.get(p, nil); err != nil {
+        logger.Error(err.Error())
+        p.SetStatus(true, err.Error())
+        return p
+    }
+    p.SetResponse(resp)
+    p.SetStatusCode(resp.StatusCode)
+    p.SetHeader(resp.Header)
+    p.SetCookies(resp.Cookies())
+
+    if contentType := resp.Header.Get("Content-Type"); !mediaTypeAllowed(this.contentTypes, contentType) {
+        resp.Body.Close()
+        errmsg := "content type " + contentType + " is not allowed"
+        logger.Warn(errmsg, mlog.F("url", url))
+        p.SetBodyOutcome(page.BodyTypeRejected)
+        p.SetStatus(true, errmsg)
+        return p
+    }
+
+    errmsg := "responce body is larger than " + strconv.FormatInt(this.maxBodySize, 10) + " bytes"
+    if this.maxBodySize > 0 && resp.ContentLength > this.maxBodySize && !this.truncateBody {
+        resp.Body.Close()
+        logger.Error(errmsg, mlog.F("url", url))
+        p.SetBodyOutcome(page.BodyTooLarge)
+        p.SetStatus(true, errmsg)
+        return p
+    }
+
+    var body io.Reader = resp.Body
+    if this.maxBodySize > 0 {
+        body = &limitBody{r: resp.Body, n: this.maxBodySize, truncate: this.truncateBody, p: p, errmsg: errmsg}
+    }
+    // the page is set failed if the head is over the limit
+    p.SetStatus(false, "")
+    br := bufio.NewReaderSize(body, charsetSniffSize)
+    // error of the head is returned again when the body is read
+    head, _ := br.Peek(charsetSniffSize)
+    enc, name := this.detectCharset(this.getCharset(resp.Header), sniffHead(head))
+    var r io.Reader = br
+    if name == "utf-8" {
+        // the byte order mark is removed
+        if bytes.HasPrefix(head, []byte("\xef\xbb\xbf")) {
+            br.Discard(3)
+        }
+    } else {
+        r = enc.NewDecoder().Reader(br)
+    }
+    p.SetBodyReader(r, resp.Body)
+    return p
+}
+
+// The sniffHead returns head of body without the last rune cut by the sniff size, which is not invalid utf-8.
+func sniffHead(head []byte) []byte {
+    for i := len(head) - 1; i >= 0 && i >= len(head)-utf8.UTFMax; i-- {
+        if utf8.RuneStart(head[i]) {
+            if !utf8.FullRune(head[i:]) {
+                return head[:i]
+            }
+            break
+        }
+    }
+    return head
+}
+
+// The limitBody reads at most n bytes of body for "stream" content. Body over the limit is truncated if
+// truncate is true, or else reading it fails and the page is set failed.
+type limitBody struct {
+    r        io.Reader
+    n        int64
+    truncate bool
+    p        *page.Page
+    errmsg   string
+    err      error
+}
+
+func (this *limitBody) Read(b []byte) (int, error) {
+    if this.err != nil {
+        return 0, this.err
+    }
+    if this.n <= 0 {
+        // one more byte means body over the limit
+        n, err := io.ReadFull(this.r, make([]byte, 1))
+        switch {
+        case n == 0:
+            this.err = err
+        case this.truncate:
+            this.p.SetBodyOutcome(page.BodyTruncated)
+            this.err = io.EOF
+        default:
+            logger.Error(this.errmsg, mlog.F("url", this.p.GetRequest().GetUrl()))
+            this.p.SetBodyOutcome(page.BodyTooLarge)
+            this.p.SetStatus(true, this.errmsg)
+            this.err = errors.New(this.errmsg)
+        }
+        return 0, this.err
+    }
+    if int64(len(b)) > this.n {
+        b = b[:this.n]
+    }
+    n, err := this.r.Read(b)
+    this.n -= int64(n)
+    return n, err
+}
@@ -2,16 +2,37 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-//
 package downloader_test
 
 import (
+    "archive/zip"
+    "bytes"
+    "compress/gzip"
+    "compress/zlib"
+    "context"
     "fmt"
     "github.com/PuerkitoBio/goquery"
+    "github.com/andybalholm/brotli"
     "github.com/hu17889/go_spider/core/common/page"
     "github.com/hu17889/go_spider/core/common/request"
     "github.com/hu17889/go_spider/core/downloader"
+    "golang.org/x/net/websocket"
+    "golang.org/x/text/encoding/charmap"
+    "golang.org/x/text/encoding/japanese"
+    "golang.org/x/text/encoding/simplifiedchinese"
+    "golang.org/x/text/encoding/traditionalchinese"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/textproto"
+    "net/url"
+    "os"
+    "strings"
+    "sync/atomic"
     "testing"
+    "time"
 )
 
 func TestDownloadHtml(t *testing.T) {
@@ -97,3 +118,841 @@ func TestCharSetChange(t *testing.T) {
     //fmt.Println(body)
 
 }
+
+func TestMaxBodySize(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/chunked" {
+            w.Write([]byte(strings.Repeat("a", 8)))
+            w.(http.Flusher).Flush()
+            w.Write([]byte(strings.Repeat("a", 8)))
+            return
+        }
+        w.Write([]byte(strings.Repeat("a", 16)))
+    }))
+    defer ts.Close()
+
+    dl := downloader.NewHttpDownloader().SetMaxBodySize(10)
+    if p := dl.Download(request.NewRequest(ts.URL, "text")); p.IsSucc() || p.GetBodyOutcome() != page.BodyTooLarge {
+        t.Error("over size body should fail")
+    }
+    if p := dl.Download(request.NewRequest(ts.URL+"/chunked", "text")); p.IsSucc() {
+        t.Error("over size chunked body should fail")
+    }
+
+    dl.SetTruncateBody(true)
+    if p := dl.Download(request.NewRequest(ts.URL+"/chunked", "text")); !p.IsSucc() || len(p.GetBodyStr()) != 10 ||
+        p.GetBodyOutcome() != page.BodyTruncated {
+        t.Error("body should be truncated")
+    }
+
+    dl.SetMaxBodySize(100).SetTruncateBody(false)
+    if p := dl.Download(request.NewRequest(ts.URL, "text")); !p.IsSucc() || len(p.GetBodyStr()) != 16 || p.GetBodyOutcome() != "" {
+        t.Error("body under limit error")
+    }
+}
+
+func TestContentTypes(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/video.mp4" {
+            w.Header().Set("Content-Type", "video/mp4")
+            w.Header().Set("Content-Length", "1000000")
+            w.Write(make([]byte, 1000000))
+            return
+        }
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte("<html><title>a</title></html>"))
+    }))
+    defer ts.Close()
+
+    dl := downloader.NewHttpDownloader().SetContentTypes("text/html", "application/json")
+    if p := dl.Download(request.NewRequest(ts.URL+"/", "html")); !p.IsSucc() || p.GetBodyOutcome() != "" {
+        t.Errorf("allowed content type error: %s", p.Errormsg())
+    }
+    p := dl.Download(request.NewRequest(ts.URL+"/video.mp4", "html"))
+    if p.IsSucc() || p.GetBodyOutcome() != page.BodyTypeRejected || p.GetBodyStr() != "" {
+        t.Error("content type not allowed should be rejected")
+    }
+
+    dl.SetContentTypes("text/*")
+    if p := dl.Download(request.NewRequest(ts.URL+"/", "text")); !p.IsSucc() {
+        t.Error("wildcard content type error")
+    }
+}
+
+func TestDownloadReader(t *testing.T) {
+    gbk, _ := simplifiedchinese.GBK.NewEncoder().String("<item>中文</item>")
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/gbk" {
+            w.Header().Set("Content-Type", "text/xml; charset=gbk")
+            w.Write([]byte(gbk))
+            return
+        }
+        w.Write([]byte("\xef\xbb\xbf" + strings.Repeat("a", 8)))
+        w.(http.Flusher).Flush()
+        w.Write([]byte(strings.Repeat("a", 8)))
+    }))
+    defer ts.Close()
+
+    dl := downloader.NewHttpDownloader()
+    p := dl.Download(request.NewRequest(ts.URL+"/gbk", "stream"))
+    if !p.IsSucc() || p.GetBodyReader() == nil || p.GetBodyStr() != "" {
+        t.Fatalf("stream page error: %s", p.Errormsg())
+    }
+    body, err := ioutil.ReadAll(p.GetBodyReader())
+    if err != nil || string(body) != "<item>中文</item>" {
+        t.Errorf("stream body should be changed to utf-8: %q %v", body, err)
+    }
+    p.CloseBody()
+
+    p = dl.Download(request.NewRequest(ts.URL, "stream"))
+    if body, _ := ioutil.ReadAll(p.GetBodyReader()); string(body) != strings.Repeat("a", 16) {
+        t.Errorf("byte order mark should be removed: %q", body)
+    }
+    p.CloseBody()
+
+    dl.SetMaxBodySize(10)
+    p = dl.Download(request.NewRequest(ts.URL, "stream"))
+    if _, err := ioutil.ReadAll(p.GetBodyReader()); err == nil || p.IsSucc() || p.GetBodyOutcome() != page.BodyTooLarge {
+        t.Error("reading over size body should fail")
+    }
+    p.CloseBody()
+
+    dl.SetTruncateBody(true)
+    p = dl.Download(request.NewRequest(ts.URL, "stream"))
+    body, err = ioutil.ReadAll(p.GetBodyReader())
+    if err != nil || len(body) != 7 || !p.IsSucc() || p.GetBodyOutcome() != page.BodyTruncated {
+        t.Errorf("stream body should be truncated: %q %v", body, err)
+    }
+    p.CloseBody()
+}
+
+func TestDownloadStream(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(strings.Repeat("a", 16)))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_stream")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    dl := downloader.NewHttpDownloader().SetFileDir(dir)
+    p := dl.Download(request.NewRequest(ts.URL+"/a.bin", "file"))
+    if !p.IsSucc() || p.GetFileSize() != 16 || p.GetBodyStr() != "" {
+        t.Fatalf("stream download error: %s", p.Errormsg())
+    }
+    if !strings.HasSuffix(p.GetFilePath(), ".bin") {
+        t.Error("file extension error")
+    }
+    if content, _ := ioutil.ReadFile(p.GetFilePath()); len(content) != 16 {
+        t.Error("file content error")
+    }
+
+    dl.SetMaxBodySize(10)
+    if p = dl.Download(request.NewRequest(ts.URL+"/b.bin", "file")); p.IsSucc() {
+        t.Error("over size file should fail")
+    }
+    dl.SetTruncateBody(true)
+    if p = dl.Download(request.NewRequest(ts.URL+"/b.bin", "file")); !p.IsSucc() || p.GetFileSize() != 10 {
+        t.Error("file should be truncated")
+    }
+}
+
+func TestDownloadResponse(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("X-Api-Version", "2")
+        w.WriteHeader(http.StatusAccepted)
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    p := downloader.NewHttpDownloader().Download(request.NewRequest(ts.URL, "text"))
+    if p.GetStatusCode() != http.StatusAccepted || http.Header(p.GetHeader()).Get("X-Api-Version") != "2" {
+        t.Error("status code or header error")
+    }
+    if resp := p.GetResponse(); resp == nil || resp.StatusCode != http.StatusAccepted || resp.Request.URL.String() != ts.URL {
+        t.Error("raw responce error")
+    }
+}
+
+func TestDownloadPost(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(r.Method + " " + r.FormValue("q") + " " + r.Header.Get("X-Token")))
+    }))
+    defer ts.Close()
+
+    req := request.NewRequest(ts.URL, "text").SetMethod("POST").SetPostdata("q=go").
+        SetHeader("Content-Type", "application/x-www-form-urlencoded").SetHeader("X-Token", "t")
+    if p := downloader.NewHttpDownloader().Download(req); p.GetBodyStr() != "POST go t" {
+        t.Errorf("post error: %s", p.GetBodyStr())
+    }
+}
+
+func TestDetectJson(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/vnd.api+json; charset=utf-8")
+        w.Write([]byte(`{"data": {"id": "7"}}`))
+    }))
+    defer ts.Close()
+
+    p := downloader.NewHttpDownloader().Download(request.NewRequest(ts.URL, "text"))
+    if !p.IsSucc() || p.GetJsonString("data.id") != "7" {
+        t.Errorf("json content should be parsed: %s", p.Errormsg())
+    }
+}
+
+func TestExtractDocuments(t *testing.T) {
+    var docx bytes.Buffer
+    zw := zip.NewWriter(&docx)
+    w, _ := zw.Create("word/document.xml")
+    w.Write([]byte(`<w:document><w:body><w:p><w:r><w:t>Annual &amp; report</w:t></w:r></w:p>` +
+        `<w:p><w:r><w:t>Revenue grew</w:t></w:r></w:p></w:body></w:document>`))
+    w, _ = zw.Create("docProps/core.xml")
+    w.Write([]byte(`<cp:coreProperties><dc:title>Report 2024</dc:title><dc:creator>Hu Cong</dc:creator></cp:coreProperties>`))
+    zw.Close()
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "application/octet-stream")
+        w.Write(docx.Bytes())
+    }))
+    defer ts.Close()
+
+    p := downloader.NewHttpDownloader().SetExtractDocuments(true).Download(request.NewRequest(ts.URL+"/report.docx", "html"))
+    if !p.IsSucc() {
+        t.Fatal(p.Errormsg())
+    }
+    doc := p.GetDocument()
+    if doc == nil || doc.Format != "docx" || doc.Title != "Report 2024" || doc.Author != "Hu Cong" {
+        t.Fatalf("document is %#v", doc)
+    }
+    if p.GetText() != "Annual & report\nRevenue grew" {
+        t.Errorf("text is %q", p.GetText())
+    }
+    if title := p.GetHtmlParser().Find("title").Text(); title != "Report 2024" {
+        t.Errorf("title of html is %q", title)
+    }
+    if n := p.GetHtmlParser().Find("p").Length(); n != 2 {
+        t.Errorf("html has %d paragraphs, want 2", n)
+    }
+
+    // documents are not extracted by default
+    p = downloader.NewHttpDownloader().Download(request.NewRequest(ts.URL, "text"))
+    if p.GetDocument() != nil || !strings.HasPrefix(p.GetBodyStr(), "PK") {
+        t.Errorf("document is extracted when extraction is disabled")
+    }
+}
+
+func TestDetectLanguage(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`<html><body><p>This page is written in english, and its language is detected.</p></body></html>`))
+    }))
+    defer ts.Close()
+
+    if p := downloader.NewHttpDownloader().Download(request.NewRequest(ts.URL, "html")); p.GetLanguage() != "" {
+        t.Errorf("language should not be detected by default: %s", p.GetLanguage())
+    }
+    p := downloader.NewHttpDownloader().SetDetectLanguage(true).Download(request.NewRequest(ts.URL, "html"))
+    if p.GetLanguage() != "en" {
+        t.Errorf("language should be detected: %q", p.GetLanguage())
+    }
+}
+
+// The bufferCloser is a writer of file content in memory.
+type bufferCloser struct {
+    bytes.Buffer
+    closed bool
+}
+
+func (this *bufferCloser) Close() error {
+    this.closed = true
+    return nil
+}
+
+func TestDownloadStreamWriter(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/page" {
+            w.Header().Set("Content-Type", "text/html")
+        } else {
+            w.Header().Set("Content-Type", "image/png")
+        }
+        w.Write([]byte{0x89, 'P', 'N', 'G', 0xff, 0x00, 0x01, 0x02})
+    }))
+    defer ts.Close()
+
+    var w *bufferCloser
+    dl := downloader.NewHttpDownloader().SetFileContentTypes("image/*", "application/pdf").
+        SetFileWriterFunc(func(req *request.Request, p *page.Page) (io.WriteCloser, error) {
+            w = &bufferCloser{}
+            return w, nil
+        })
+    p := dl.Download(request.NewRequest(ts.URL+"/a.png", "file"))
+    if !p.IsSucc() || p.GetFileSize() != 8 || !w.closed || w.String() != "\x89PNG\xff\x00\x01\x02" {
+        t.Fatalf("binary content should be written as it is: %s", p.Errormsg())
+    }
+
+    w = nil
+    if p = dl.Download(request.NewRequest(ts.URL+"/page", "file")); p.IsSucc() || w != nil {
+        t.Error("content type not allowed should not be downloaded")
+    }
+
+    dl.SetMaxBodySize(4)
+    if p = dl.Download(request.NewRequest(ts.URL+"/b.png", "file")); p.IsSucc() {
+        t.Error("over size file should fail")
+    }
+    dl.SetTruncateBody(true)
+    if p = dl.Download(request.NewRequest(ts.URL+"/b.png", "file")); !p.IsSucc() || w.Len() != 4 {
+        t.Error("file should be truncated")
+    }
+}
+
+func TestCharsetDetect(t *testing.T) {
+    gbk, _ := simplifiedchinese.GBK.NewEncoder().String("<p>中文网页的标题</p>")
+    big5, _ := traditionalchinese.Big5.NewEncoder().String("<p>繁體中文網頁的標題</p>")
+    sjis, _ := japanese.ShiftJIS.NewEncoder().String("<p>日本語のウェブページです</p>")
+    latin1, _ := charmap.ISO8859_1.NewEncoder().String("<p>café déjà vu</p>")
+    tests := []struct {
+        contentType string
+        body        string
+        text        string
+    }{
+        {"text/html; charset=gb2312", gbk, "中文网页的标题"},
+        {"text/html", `<meta http-equiv="Content-Type" content="text/html; charset=gbk">` + gbk, "中文网页的标题"},
+        {"text/html", `<meta charset="iso-8859-1">` + latin1, "café déjà vu"},
+        {"text/html", gbk, "中文网页的标题"},
+        {"text/html", big5, "繁體中文網頁的標題"},
+        {"text/html", sjis, "日本語のウェブページです"},
+        {"text/html", "\xef\xbb\xbf<p>utf-8 页面</p>", "utf-8 页面"},
+    }
+    for _, test := range tests {
+        ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            w.Header().Set("Content-Type", test.contentType)
+            w.Write([]byte(test.body))
+        }))
+        p := downloader.NewHttpDownloader().Download(request.NewRequest(ts.URL, "html"))
+        ts.Close()
+        if text := p.GetHtmlParser().Find("p").Text(); text != test.text {
+            t.Errorf("%s %q: text should be %q, not %q", test.contentType, test.body, test.text, text)
+        }
+    }
+}
+
+func TestTimeouts(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slowheader" {
+            time.Sleep(200 * time.Millisecond)
+        }
+        w.Write([]byte("<p>head</p>"))
+        w.(http.Flusher).Flush()
+        if r.URL.Path == "/slowbody" {
+            time.Sleep(200 * time.Millisecond)
+        }
+        w.Write([]byte("<p>tail</p>"))
+    }))
+    defer ts.Close()
+
+    dl := downloader.NewHttpDownloader().SetTimeouts(request.Timeouts{ResponseHeader: 50 * time.Millisecond})
+    if p := dl.Download(request.NewRequest(ts.URL+"/slowheader", "html")); p.IsSucc() || p.Errormsg() != "responce header timeout" {
+        t.Errorf("page should fail by responce header timeout: %s", p.Errormsg())
+    }
+    req := request.NewRequest(ts.URL+"/slowheader", "html").SetTimeouts(request.Timeouts{ResponseHeader: time.Second})
+    if p := dl.Download(req); !p.IsSucc() {
+        t.Errorf("timeout of request should override timeout of downloader: %s", p.Errormsg())
+    }
+    if p := dl.Download(request.NewRequest(ts.URL+"/slowbody", "html").SetTimeout(50 * time.Millisecond)); p.IsSucc() || p.Errormsg() != "download timeout" {
+        t.Errorf("page should fail by total timeout while reading body: %s", p.Errormsg())
+    }
+}
+
+func TestClassifyError(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        time.Sleep(200 * time.Millisecond)
+    }))
+    defer ts.Close()
+    tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    defer tlsServer.Close()
+    closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+    closed.Close()
+
+    dl := downloader.NewHttpDownloader()
+    for _, c := range []struct {
+        req   *request.Request
+        class string
+    }{
+        {request.NewRequest(ts.URL, "text").SetTimeout(50 * time.Millisecond), downloader.ErrorTimeout},
+        {request.NewRequest(tlsServer.URL, "text"), downloader.ErrorTLS},
+        {request.NewRequest(closed.URL, "text"), downloader.ErrorConnect},
+    } {
+        if p := dl.Download(c.req); p.IsSucc() || p.GetErrorClass() != c.class {
+            t.Errorf("error class of %s should be %s: %s %s", c.req.GetUrl(), c.class, p.GetErrorClass(), p.Errormsg())
+        }
+    }
+    if c := downloader.ClassifyError(fmt.Errorf("get : %w", &net.DNSError{Err: "no such host", Name: "a.invalid"})); c != downloader.ErrorDNS {
+        t.Errorf("dns error class error: %s", c)
+    }
+    if c := downloader.ClassifyError(io.ErrUnexpectedEOF); c != "" {
+        t.Errorf("other error should have no class: %s", c)
+    }
+}
+
+func TestHTTP2(t *testing.T) {
+    ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(r.Proto))
+    }))
+    ts.EnableHTTP2 = true
+    ts.StartTLS()
+    defer ts.Close()
+
+    transport := downloader.NewTransport()
+    transport.TLSClientConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+    dl := downloader.NewHttpDownloader().SetTransport(transport)
+    for i := 0; i < 2; i++ {
+        if p := dl.Download(request.NewRequest(ts.URL, "text")); p.GetBodyStr() != "HTTP/2.0" {
+            t.Errorf("HTTP/2 should be used: %s %s", p.GetBodyStr(), p.Errormsg())
+        }
+    }
+
+    transport = downloader.NewTransport()
+    transport.TLSClientConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+    dl = downloader.NewHttpDownloader().SetTransport(transport).SetHTTP2(false)
+    if p := dl.Download(request.NewRequest(ts.URL, "text")); p.GetBodyStr() != "HTTP/1.1" {
+        t.Errorf("HTTP/1.1 should be used: %s %s", p.GetBodyStr(), p.Errormsg())
+    }
+}
+
+func TestContentEncoding(t *testing.T) {
+    const body = "<p>compressed page</p>"
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Accept-Encoding") != "gzip, deflate, br" {
+            t.Errorf("Accept-Encoding should be sent: %s", r.Header.Get("Accept-Encoding"))
+        }
+        encoding := strings.TrimPrefix(r.URL.Path, "/")
+        w.Header().Set("Content-Encoding", encoding)
+        var wc io.WriteCloser
+        switch encoding {
+        case "gzip":
+            wc = gzip.NewWriter(w)
+        case "deflate":
+            wc = zlib.NewWriter(w)
+        case "br":
+            wc = brotli.NewWriter(w)
+        }
+        wc.Write([]byte(body))
+        wc.Close()
+    }))
+    defer ts.Close()
+
+    dl := downloader.NewHttpDownloader()
+    for _, encoding := range []string{"gzip", "deflate", "br"} {
+        if p := dl.Download(request.NewRequest(ts.URL+"/"+encoding, "text")); p.GetBodyStr() != body {
+            t.Errorf("%s body should be decompressed: %q %s", encoding, p.GetBodyStr(), p.Errormsg())
+        }
+    }
+}
+
+func TestDownloadMethodAndBody(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        body, _ := ioutil.ReadAll(r.Body)
+        user, password, _ := r.BasicAuth()
+        w.Header().Set("X-Method", r.Method)
+        fmt.Fprintf(w, "%s %s %s %s:%s %s %s", r.Method, body, r.Header.Get("Content-Type"), user, password,
+            strings.Join(r.Header["X-Tag"], ","), r.Host)
+    }))
+    defer ts.Close()
+
+    d := downloader.NewHttpDownloader()
+    req := request.NewRequest(ts.URL, "text").SetMethod("PUT").SetBody(`{"a":1}`, "application/json").
+        SetBasicAuth("u", "p").AddHeader("X-Tag", "a").AddHeader("X-Tag", "b").SetHeader("Host", "api.example.com")
+    if p := d.Download(req); p.GetBodyStr() != `PUT {"a":1} application/json u:p a,b api.example.com` {
+        t.Errorf("put error: %s", p.GetBodyStr())
+    }
+
+    form := url.Values{"q": {"go spider"}}
+    req = request.NewRequest(ts.URL, "text").SetForm(form)
+    if p := d.Download(req); p.GetBodyStr() != "POST q=go+spider application/x-www-form-urlencoded :  "+ts.Listener.Addr().String() {
+        t.Errorf("form error: %s", p.GetBodyStr())
+    }
+
+    req = request.NewRequest(ts.URL, "text").SetMethod("HEAD").SetHeaders(http.Header{"X-Tag": {"c"}})
+    if p := d.Download(req); !p.IsSucc() || p.GetBodyStr() != "" || http.Header(p.GetHeader()).Get("X-Method") != "HEAD" {
+        t.Errorf("head error: %v %s", p.Errormsg(), p.GetBodyStr())
+    }
+}
+
+func TestDownloadPostBodies(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+            if err := r.ParseMultipartForm(1 << 20); err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            f, header, err := r.FormFile("upload")
+            if err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            content, _ := ioutil.ReadAll(f)
+            fmt.Fprintf(w, "%s %s %s %s %x", r.FormValue("title"), header.Filename, header.Header.Get("Content-Type"),
+                r.Form["tag"], content)
+            return
+        }
+        body, _ := ioutil.ReadAll(r.Body)
+        w.Header().Set("Content-Type", "application/json")
+        fmt.Fprintf(w, `{"method": %q, "type": %q, "body": %q}`, r.Method, r.Header.Get("Content-Type"), body)
+    }))
+    defer ts.Close()
+
+    d := downloader.NewHttpDownloader()
+    req, err := request.NewJSONPost(ts.URL, map[string]interface{}{"q": "go", "page": 2})
+    if err != nil {
+        t.Fatal(err)
+    }
+    p := d.Download(req)
+    if p.GetJsonString("method") != "POST" || p.GetJsonString("type") != "application/json" ||
+        p.GetJsonString("body") != `{"page":2,"q":"go"}` {
+        t.Errorf("json post error: %s", p.GetBodyStr())
+    }
+    if _, err := request.NewJSONPost(ts.URL, func() {}); err == nil {
+        t.Error("json post of function should fail")
+    }
+
+    p = d.Download(request.NewRequest(ts.URL, "json").SetMethod("POST").SetPostdata("q=go"))
+    if p.GetJsonString("type") != "application/x-www-form-urlencoded" {
+        t.Errorf("postdata should be sent as form by default: %s", p.GetBodyStr())
+    }
+    p = d.Download(request.NewFormPost(ts.URL, url.Values{"q": {"go spider"}}, "json"))
+    if p.GetJsonString("method") != "POST" || p.GetJsonString("body") != "q=go+spider" {
+        t.Errorf("form post error: %s", p.GetBodyStr())
+    }
+
+    dir, err := ioutil.TempDir("", "upload")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := dir + "/logo.png"
+    ioutil.WriteFile(path, []byte{0x89, 'P', 'N', 'G', 0xff, 0x00}, 0644)
+    file, err := request.NewFormFile("upload", path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    fields := url.Values{"title": {"logo"}, "tag": {"a", "b"}}
+    req = request.NewMultipartPost(ts.URL, fields, []request.FormFile{file}, "text")
+    other := request.NewMultipartPost(ts.URL, fields, []request.FormFile{file}, "text")
+    if req.GetPostdata() != other.GetPostdata() || req.GetHeader().Get("Content-Type") != other.GetHeader().Get("Content-Type") {
+        t.Error("the same form should have the same body")
+    }
+    // the binary body survives serialization, like in a disk queue
+    data, err := req.MarshalJSON()
+    if err != nil {
+        t.Fatal(err)
+    }
+    req = &request.Request{}
+    if err := req.UnmarshalJSON(data); err != nil || req.GetPostdata() != other.GetPostdata() {
+        t.Fatalf("multipart body is not serialized: %v", err)
+    }
+    if p = d.Download(req); p.GetBodyStr() != "logo logo.png image/png [a b] 89504e47ff00" {
+        t.Errorf("multipart post error: %s", p.GetBodyStr())
+    }
+}
+
+func TestBandwidth(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        n := 12 << 10
+        if r.URL.Path == "/small" {
+            n = 4 << 10
+        }
+        w.Write(bytes.Repeat([]byte("a"), n))
+    }))
+    defer ts.Close()
+
+    // 8 KB of burst is read at once, and the rest 4 KB waits for half a second
+    dl := downloader.NewHttpDownloader().SetBandwidth(8 << 10)
+    start := time.Now()
+    p := dl.Download(request.NewRequest(ts.URL+"/large", "text"))
+    if d := time.Since(start); !p.IsSucc() || len(p.GetBodyStr()) != 12<<10 || d < 400*time.Millisecond {
+        t.Errorf("body should be read at the limited rate: %v %d %s", d, len(p.GetBodyStr()), p.Errormsg())
+    }
+
+    dl = downloader.NewHttpDownloader().SetHostBandwidth(8 << 10)
+    dl.Download(request.NewRequest(ts.URL+"/small", "text"))
+    dl.Download(request.NewRequest(ts.URL+"/small", "text"))
+    start = time.Now()
+    if p = dl.Download(request.NewRequest(ts.URL+"/small", "text")); !p.IsSucc() || time.Since(start) < 400*time.Millisecond {
+        t.Errorf("downloads of a host should share its limit: %v %s", time.Since(start), p.Errormsg())
+    }
+}
+
+func TestLocalAddrPool(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        host, _, _ := net.SplitHostPort(r.RemoteAddr)
+        w.Header().Set("Connection", "close")
+        w.Write([]byte(host))
+    }))
+    defer ts.Close()
+
+    dl := downloader.NewHttpDownloader().SetLocalAddrPool(downloader.NewLocalAddrPool("127.0.0.1", "127.0.0.2"))
+    var addrs []string
+    for i := 0; i < 3; i++ {
+        p := dl.Download(request.NewRequest(ts.URL, "text"))
+        if !p.IsSucc() {
+            t.Skip("local address can not be bound: " + p.Errormsg())
+        }
+        addrs = append(addrs, p.GetBodyStr())
+    }
+    if addrs[0] != "127.0.0.1" || addrs[1] != "127.0.0.2" || addrs[2] != "127.0.0.1" {
+        t.Errorf("connections should be bound round-robin: %v", addrs)
+    }
+
+    pool := downloader.NewLocalAddrPool("127.0.0.1", "127.0.0.2").SetSticky(true)
+    dl = downloader.NewHttpDownloader().SetLocalAddrPool(pool)
+    for i := 0; i < 2; i++ {
+        if p := dl.Download(request.NewRequest(ts.URL, "text")); p.GetBodyStr() != "127.0.0.1" {
+            t.Errorf("connections of a host should keep its address: %s", p.GetBodyStr())
+        }
+    }
+    if ip := pool.Get("other.example.com"); ip.String() != "127.0.0.2" {
+        t.Errorf("other host should get next address: %v", ip)
+    }
+}
+
+// The countingResolver resolves all the hosts but "bad.test" to 127.0.0.1, and counts lookups.
+type countingResolver struct {
+    lookups int32
+}
+
+func (this *countingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+    atomic.AddInt32(&this.lookups, 1)
+    if host == "bad.test" {
+        return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+    }
+    return []string{"127.0.0.1"}, nil
+}
+
+func TestDNSCache(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Connection", "close")
+        w.Write([]byte(r.Host))
+    }))
+    defer ts.Close()
+    _, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+    r := &countingResolver{}
+    c := downloader.NewDNSCache(r, time.Minute).AddHost("static.test", "127.0.0.1")
+    dl := downloader.NewHttpDownloader().SetResolver(c)
+    for _, host := range []string{"crawl.test", "crawl.test", "static.test"} {
+        p := dl.Download(request.NewRequest("http://"+host+":"+port+"/", "text"))
+        if !p.IsSucc() || p.GetBodyStr() != host+":"+port {
+            t.Errorf("%s should be resolved by resolver: %s", host, p.Errormsg())
+        }
+    }
+    for i := 0; i < 2; i++ {
+        if p := dl.Download(request.NewRequest("http://bad.test:"+port+"/", "text")); p.IsSucc() {
+            t.Error("host not found should fail")
+        }
+    }
+    if r.lookups != 2 {
+        t.Errorf("addresses and failures should be cached: %d lookups", r.lookups)
+    }
+    c.Flush()
+    if addrs, err := c.LookupHost(context.Background(), "crawl.test"); err != nil || len(addrs) != 1 || r.lookups != 3 {
+        t.Errorf("flushed host should be looked up again: %v %v %d", addrs, err, r.lookups)
+    }
+}
+
+func TestIPFamily(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Connection", "close")
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+    _, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+    u := "http://dual.test:" + port + "/"
+
+    // nothing listens on ::1, so IPv6 fails and IPv4 is connected
+    c := downloader.NewDNSCache(nil, time.Minute).AddHost("dual.test", "::1", "127.0.0.1")
+    dl := downloader.NewHttpDownloader().SetResolver(c)
+    if p := dl.Download(request.NewRequest(u, "text")); !p.IsSucc() || p.GetRemoteAddr() != "127.0.0.1:"+port {
+        t.Errorf("ipv4 should be connected after ipv6 fails: %s %s", p.GetRemoteAddr(), p.Errormsg())
+    }
+    dl.SetFallbackDelay(-1)
+    if p := dl.Download(request.NewRequest(u, "text")); !p.IsSucc() {
+        t.Errorf("addresses should be dialed one by one: %s", p.Errormsg())
+    }
+    if p := dl.SetIPFamily(downloader.IPv6Only).Download(request.NewRequest(u, "text")); p.IsSucc() {
+        t.Error("ipv4 address should not be connected by IPv6Only")
+    }
+    if p := dl.SetIPFamily(downloader.IPv4Only).Download(request.NewRequest(u, "text")); !p.IsSucc() ||
+        p.GetRemoteAddr() != "127.0.0.1:"+port {
+        t.Errorf("ipv4 address should be connected by IPv4Only: %s", p.Errormsg())
+    }
+
+    dl = downloader.NewHttpDownloader().SetIPFamily(downloader.IPv6Only)
+    if p := dl.Download(request.NewRequest(ts.URL, "text")); p.IsSucc() {
+        t.Error("ipv4 address should not be connected by IPv6Only of the system resolver")
+    }
+}
+
+func TestFileScheme(t *testing.T) {
+    dir := t.TempDir()
+    os.Mkdir(dir+"/docs", 0755)
+    os.WriteFile(dir+"/docs/a.html", []byte("<html><body>a</body></html>"), 0644)
+    os.WriteFile(dir+"/secret.txt", []byte("secret"), 0644)
+
+    if p := downloader.NewHttpDownloader().Download(request.NewRequest("file://"+dir+"/docs/a.html", "html")); p.IsSucc() {
+        t.Error("file url should fail without file root")
+    }
+    dl := downloader.NewHttpDownloader().SetFileRoot(dir + "/docs")
+    p := dl.Download(request.NewRequest("file://"+dir+"/docs/a.html", "html"))
+    if !p.IsSucc() || p.GetHtmlParser().Find("body").Text() != "a" {
+        t.Errorf("file should be downloaded: %s", p.Errormsg())
+    }
+    p = dl.Download(request.NewRequest("file://"+dir+"/docs", "html"))
+    if href, _ := p.GetHtmlParser().Find("a").Attr("href"); href != "file://"+dir+"/docs/a.html" {
+        t.Errorf("directory should list absolute links: %s", p.GetBodyStr())
+    }
+    for _, u := range []string{"file://" + dir + "/docs/b.html", "file://" + dir + "/docs/../secret.txt"} {
+        if p := dl.Download(request.NewRequest(u, "text")); p.GetStatusCode() != http.StatusNotFound {
+            t.Errorf("%s should not be found: %d %s", u, p.GetStatusCode(), p.GetBodyStr())
+        }
+    }
+}
+
+// The serveFtp serves the files and the directory "/pub" to one client of the listener, in passive mode.
+func serveFtp(t *testing.T, l net.Listener, files map[string]string) {
+    conn, err := l.Accept()
+    if err != nil {
+        return
+    }
+    defer conn.Close()
+    text := textproto.NewConn(conn)
+    text.PrintfLine("220 ready")
+    var data net.Listener
+    for {
+        line, err := text.ReadLine()
+        if err != nil {
+            return
+        }
+        cmd, arg, _ := strings.Cut(line, " ")
+        switch cmd {
+        case "USER":
+            text.PrintfLine("331 password please")
+        case "PASS":
+            if arg != "guest@" {
+                t.Errorf("anonymous password should be sent: %s", arg)
+            }
+            text.PrintfLine("230 logged in")
+        case "TYPE", "CWD":
+            if cmd == "CWD" && arg != "/pub" && arg != "/pub/" {
+                text.PrintfLine("550 no such directory")
+                continue
+            }
+            text.PrintfLine("200 ok")
+        case "EPSV":
+            text.PrintfLine("500 not supported")
+        case "PASV":
+            data, _ = net.Listen("tcp", "127.0.0.1:0")
+            port := data.Addr().(*net.TCPAddr).Port
+            text.PrintfLine("227 Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff)
+        case "RETR", "LIST":
+            body, ok := files[arg]
+            if cmd == "LIST" {
+                body, ok = "drwxr-xr-x 2 ftp ftp 4096 Jan 02 15:04 old files\r\n"+
+                    "-rw-r--r-- 1 ftp ftp 10 Jan 02 15:04 a.txt\r\n", true
+            }
+            if !ok {
+                data.Close()
+                text.PrintfLine("550 no such file")
+                continue
+            }
+            dc, err := data.Accept()
+            data.Close()
+            if err != nil {
+                return
+            }
+            text.PrintfLine("150 opening data connection")
+            dc.Write([]byte(body))
+            dc.Close()
+            text.PrintfLine("226 transfer complete")
+        case "QUIT":
+            text.PrintfLine("221 bye")
+            return
+        default:
+            text.PrintfLine("502 not implemented")
+        }
+    }
+}
+
+func TestFtpScheme(t *testing.T) {
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer l.Close()
+    go func() {
+        for i := 0; i < 3; i++ {
+            serveFtp(t, l, map[string]string{"/pub/a.txt": "0123456789"})
+        }
+    }()
+
+    dl := downloader.NewHttpDownloader()
+    base := "ftp://anonymous:guest%40@" + l.Addr().String()
+    p := dl.Download(request.NewRequest(base+"/pub/a.txt", "text"))
+    if !p.IsSucc() || p.GetBodyStr() != "0123456789" {
+        t.Errorf("file should be retrieved: %s %s", p.Errormsg(), p.GetBodyStr())
+    }
+    p = dl.Download(request.NewRequest(base+"/pub", "html"))
+    var links []string
+    p.GetHtmlParser().Find("a").Each(func(i int, s *goquery.Selection) {
+        href, _ := s.Attr("href")
+        links = append(links, href)
+    })
+    if len(links) != 2 || links[0] != base+"/pub/old%20files/" || links[1] != base+"/pub/a.txt" {
+        t.Errorf("directory should list links of entries: %v %s", links, p.Errormsg())
+    }
+    if p := dl.Download(request.NewRequest(base+"/pub/b.txt", "text")); p.GetStatusCode() != http.StatusNotFound {
+        t.Errorf("missing file should not be found: %d %s", p.GetStatusCode(), p.Errormsg())
+    }
+}
+
+func TestWebsocket(t *testing.T) {
+    ts := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+        var sub string
+        websocket.Message.Receive(ws, &sub)
+        websocket.Message.Send(ws, "ack "+sub+" "+ws.Request().Header.Get("X-Token"))
+        websocket.Message.Send(ws, []byte{1, 2})
+    }))
+    defer ts.Close()
+
+    req := request.NewRequest("ws"+strings.TrimPrefix(ts.URL, "http"), "websocket").SetPostdata("quotes").
+        SetHeader("X-Token", "abc")
+    p := downloader.NewHttpDownloader().Download(req)
+    if !p.IsSucc() {
+        t.Fatal(p.Errormsg())
+    }
+    var got []page.Message
+    for msg := range p.Messages() {
+        got = append(got, msg)
+    }
+    p.CloseBody()
+    if len(got) != 2 || got[0].Event != "text" || got[0].Data != "ack quotes abc" || got[1].Event != "binary" ||
+        got[1].Data != "\x01\x02" {
+        t.Errorf("messages should be received until the server closes: %v", got)
+    }
+    if !p.IsSucc() {
+        t.Errorf("stream closed by the server should not fail: %s", p.Errormsg())
+    }
+
+    // the stream is stopped by max duration
+    blocked := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+        var s string
+        websocket.Message.Receive(ws, &s)
+    }))
+    defer blocked.Close()
+    req = request.NewRequest(blocked.URL, "websocket").SetStreamLimits(request.StreamLimits{MaxDuration: 50 * time.Millisecond})
+    p = downloader.NewHttpDownloader().Download(req)
+    if _, ok := <-p.Messages(); ok || !p.IsSucc() {
+        t.Errorf("stream should end at max duration: %s", p.Errormsg())
+    }
+    p.CloseBody()
+}
@@ -0,0 +1,149 @@
+package downloader
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/request"
+    "io"
+    "net/http"
+    "net/http/httptrace"
+    "sync"
+    "time"
+)
+
+// The SetTimeouts sets timeouts of downloads. Fields of timeouts of each request override them.
+// Timeouts are applied to each request, so requests of different proxies and hosts share the transport.
+// Default is no timeout. It can be changed while downloading, and applies to downloads started later.
+func (this *HttpDownloader) SetTimeouts(t request.Timeouts) *HttpDownloader {
+    this.locker.Lock()
+    this.timeouts = t
+    this.locker.Unlock()
+    return this
+}
+
+func (this *HttpDownloader) GetTimeouts() request.Timeouts {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.timeouts
+}
+
+// The SetTimeout sets the total timeout of downloads, including reading the body.
+func (this *HttpDownloader) SetTimeout(d time.Duration) *HttpDownloader {
+    this.locker.Lock()
+    this.timeouts.Total = d
+    this.locker.Unlock()
+    return this
+}
+
+// The timeoutError is error of a download phase over its timeout.
+type timeoutError struct {
+    phase string
+}
+
+func (this *timeoutError) Error() string {
+    return this.phase + " timeout"
+}
+
+func (this *timeoutError) Timeout() bool {
+    return true
+}
+
+// The phaseTimer cancels the request if a phase is not done before its timeout.
+type phaseTimer struct {
+    cancel context.CancelCauseFunc
+
+    locker sync.Mutex
+    timers map[string]*time.Timer
+}
+
+// The start starts timer of the phase if it is not started.
+func (this *phaseTimer) start(phase string, d time.Duration) {
+    if d <= 0 {
+        return
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if _, ok := this.timers[phase]; ok {
+        return
+    }
+    this.timers[phase] = time.AfterFunc(d, func() {
+        this.cancel(&timeoutError{phase: phase})
+    })
+}
+
+// The stop stops timer of the phase after it is done.
+func (this *phaseTimer) stop(phase string) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if timer, ok := this.timers[phase]; ok {
+        timer.Stop()
+    }
+}
+
+// The withTimeouts returns the request with context that is canceled by the timeouts of the request,
+// or timeouts of HttpDownloader, and the function that releases the context after the body is read.
+func (this *HttpDownloader) withTimeouts(httpreq *http.Request, req *request.Request) (*http.Request, context.CancelCauseFunc) {
+    t := req.GetTimeouts().Merge(this.GetTimeouts())
+    if t == (request.Timeouts{}) {
+        return httpreq, func(error) {}
+    }
+    ctx, cancel := context.WithCancelCause(httpreq.Context())
+    if t.Total > 0 {
+        var cancelTotal context.CancelFunc
+        ctx, cancelTotal = context.WithTimeoutCause(ctx, t.Total, &timeoutError{phase: "download"})
+        cancelParent := cancel
+        cancel = func(cause error) {
+            cancelParent(cause)
+            cancelTotal()
+        }
+    }
+    timer := &phaseTimer{cancel: cancel, timers: make(map[string]*time.Timer)}
+    trace := &httptrace.ClientTrace{
+        ConnectStart:         func(network, addr string) { timer.start("connect", t.Connect) },
+        ConnectDone:          func(network, addr string, err error) { timer.stop("connect") },
+        TLSHandshakeStart:    func() { timer.start("tls handshake", t.TLSHandshake) },
+        TLSHandshakeDone:     func(tls.ConnectionState, error) { timer.stop("tls handshake") },
+        WroteRequest:         func(httptrace.WroteRequestInfo) { timer.start("responce header", t.ResponseHeader) },
+        GotFirstResponseByte: func() { timer.stop("responce header") },
+    }
+    release := func(cause error) {
+        timer.locker.Lock()
+        for _, phase := range timer.timers {
+            phase.Stop()
+        }
+        timer.locker.Unlock()
+        cancel(cause)
+    }
+    return httpreq.WithContext(httptrace.WithClientTrace(ctx, trace)), release
+}
+
+// The timeoutCause returns the timeout error of the request if its context is canceled by a timeout.
+func timeoutCause(httpreq *http.Request, err error) error {
+    var terr *timeoutError
+    if errors.As(context.Cause(httpreq.Context()), &terr) {
+        return terr
+    }
+    return err
+}
+
+// The timeoutBody releases context of the request when the body is closed.
+type timeoutBody struct {
+    io.ReadCloser
+    httpreq *http.Request
+    release context.CancelCauseFunc
+}
+
+func (this *timeoutBody) Read(b []byte) (int, error) {
+    n, err := this.ReadCloser.Read(b)
+    if err != nil && err != io.EOF {
+        err = timeoutCause(this.httpreq, err)
+    }
+    return n, err
+}
+
+func (this *timeoutBody) Close() error {
+    err := this.ReadCloser.Close()
+    this.release(nil)
+    return err
+}
@@ -0,0 +1,120 @@
+package downloader
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strings"
+)
+
+// The tuneTLS changes tls config of the transport by f. The config is cloned, as it may be shared.
+func (this *HttpDownloader) tuneTLS(f func(c *tls.Config)) *HttpDownloader {
+    return this.tuneTransport(func(t *http.Transport) {
+        if t.TLSClientConfig == nil {
+            t.TLSClientConfig = &tls.Config{}
+        } else {
+            t.TLSClientConfig = t.TLSClientConfig.Clone()
+        }
+        f(t.TLSClientConfig)
+        // connections of the old config are not reused
+        t.CloseIdleConnections()
+    })
+}
+
+// The SetRootCAs sets certificate authorities that verify certificates of https hosts, like the CA of
+// a corporate network. Default nil is the CAs of the system.
+func (this *HttpDownloader) SetRootCAs(pool *x509.CertPool) *HttpDownloader {
+    return this.tuneTLS(func(c *tls.Config) {
+        c.RootCAs = pool
+    })
+}
+
+// The LoadRootCAs adds certificate authorities in the pem files to the CAs of the system, and sets them
+// by SetRootCAs.
+func (this *HttpDownloader) LoadRootCAs(paths ...string) error {
+    pool, err := x509.SystemCertPool()
+    if err != nil {
+        pool = x509.NewCertPool()
+    }
+    for _, path := range paths {
+        data, err := ioutil.ReadFile(path)
+        if err != nil {
+            return err
+        }
+        if !pool.AppendCertsFromPEM(data) {
+            return errors.New("no certificate found in " + path)
+        }
+    }
+    this.SetRootCAs(pool)
+    return nil
+}
+
+// The SetClientCertificates sets certificates sent to https hosts that require client certificate auth.
+func (this *HttpDownloader) SetClientCertificates(certs ...tls.Certificate) *HttpDownloader {
+    return this.tuneTLS(func(c *tls.Config) {
+        c.Certificates = certs
+    })
+}
+
+// The LoadClientCertificate loads client certificate from pem files of the certificate and its key,
+// and sets it by SetClientCertificates.
+func (this *HttpDownloader) LoadClientCertificate(certFile, keyFile string) error {
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return err
+    }
+    this.SetClientCertificates(cert)
+    return nil
+}
+
+// The SetTLSVersions sets the lowest and highest tls versions, like tls.VersionTLS12 and tls.VersionTLS13.
+// The 0 means default of package crypto/tls.
+func (this *HttpDownloader) SetTLSVersions(min, max uint16) *HttpDownloader {
+    return this.tuneTLS(func(c *tls.Config) {
+        c.MinVersion = min
+        c.MaxVersion = max
+    })
+}
+
+// The SetInsecureSkipVerify sets hosts whose certificates are not verified, like internal hosts of
+// self-signed certificates. Host "*" means all the hosts, and no host verifies all the certificates again.
+// Requests of these hosts are sent by another client, so certificates of other hosts are verified as usual,
+// except hosts that requests of these hosts are redirected to.
+func (this *HttpDownloader) SetInsecureSkipVerify(hosts ...string) *HttpDownloader {
+    insecure := make(map[string]bool)
+    for _, host := range hosts {
+        insecure[strings.ToLower(host)] = true
+    }
+    this.locker.Lock()
+    this.insecureHosts = insecure
+    this.clients = nil
+    this.locker.Unlock()
+    return this
+}
+
+// The insecureHost returns whether certificate of host of the url is not verified.
+func (this *HttpDownloader) insecureHost(rawurl string) bool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if len(this.insecureHosts) == 0 {
+        return false
+    }
+    if this.insecureHosts["*"] {
+        return true
+    }
+    u, err := url.Parse(rawurl)
+    return err == nil && this.insecureHosts[strings.ToLower(u.Hostname())]
+}
+
+// The insecureTransport returns clone of the transport that does not verify certificates.
+func insecureTransport(t *http.Transport) *http.Transport {
+    t = t.Clone()
+    if t.TLSClientConfig == nil {
+        t.TLSClientConfig = &tls.Config{}
+    }
+    t.TLSClientConfig.InsecureSkipVerify = true
+    return t
+}
@@ -0,0 +1,59 @@
+package downloader_test
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+    ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        fmt.Fprintf(w, "%d %x", len(r.TLS.PeerCertificates), r.TLS.Version)
+    }))
+    ts.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+    ts.StartTLS()
+    defer ts.Close()
+
+    download := func(d *downloader.HttpDownloader) (string, bool) {
+        p := d.Download(request.NewRequest(ts.URL, "text"))
+        return p.GetBodyStr(), p.IsSucc()
+    }
+    if _, ok := download(downloader.NewHttpDownloader()); ok {
+        t.Fatal("certificate of unknown authority should be rejected")
+    }
+
+    pool := x509.NewCertPool()
+    pool.AddCert(ts.Certificate())
+    d := downloader.NewHttpDownloader().SetRootCAs(pool)
+    if body, ok := download(d); !ok || body != "0 304" {
+        t.Errorf("certificate should be verified by root CAs: %s", body)
+    }
+    d.SetClientCertificates(ts.TLS.Certificates[0]).SetTLSVersions(tls.VersionTLS12, tls.VersionTLS12)
+    if body, ok := download(d); !ok || body != "1 303" {
+        t.Errorf("client certificate and tls version should be sent: %s", body)
+    }
+
+    // the certificate is not valid for localhost even by its CA
+    localhost := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+    d = downloader.NewHttpDownloader().SetRootCAs(pool).SetInsecureSkipVerify("other.example.com")
+    if p := d.Download(request.NewRequest(localhost, "text")); p.IsSucc() {
+        t.Error("certificate of other host should be verified")
+    }
+    d.SetInsecureSkipVerify("LocalHost")
+    if p := d.Download(request.NewRequest(localhost, "text")); !p.IsSucc() {
+        t.Errorf("certificate of insecure host should not be verified: %s", p.Errormsg())
+    }
+    if body, ok := download(d); !ok || body != "0 304" {
+        t.Errorf("certificate of other host should still be verified by root CAs: %s", body)
+    }
+    d.SetInsecureSkipVerify()
+    if p := d.Download(request.NewRequest(localhost, "text")); p.IsSucc() {
+        t.Error("certificate should be verified again")
+    }
+}
@@ -0,0 +1,87 @@
+package downloader
+
+import (
+    "crypto/tls"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/trace"
+    "net/http"
+    "net/http/httptrace"
+    "sync"
+)
+
+// The withTrace returns the request with hooks recording spans "dns", "connect", "tls handshake" and "ttfb",
+// from the request written to the first byte of its responce, as children of the span of its context, which
+// Spider starts for each download. The "traceparent" header is set by trace.Inject. The request is returned
+// as it is if its context has no span.
+func withTrace(httpreq *http.Request) *http.Request {
+    span := trace.SpanFromContext(httpreq.Context())
+    if span == nil {
+        return httpreq
+    }
+    trace.Inject(httpreq.Context(), httpreq.Header)
+
+    // hooks are called by goroutines of the transport, and again for each redirect
+    var locker sync.Mutex
+    var dns, handshake, ttfb *trace.Span
+    connects := make(map[string]*trace.Span)
+    finish := func(s *trace.Span, err error) {
+        if err != nil {
+            s.SetError(err.Error())
+        }
+        s.Finish()
+    }
+    hooks := &httptrace.ClientTrace{
+        GotConn: func(info httptrace.GotConnInfo) {
+            span.SetAttribute("net.conn.reused", info.Reused)
+        },
+        DNSStart: func(info httptrace.DNSStartInfo) {
+            locker.Lock()
+            dns = span.StartChild("dns").SetAttribute("net.host.name", info.Host)
+            locker.Unlock()
+        },
+        DNSDone: func(info httptrace.DNSDoneInfo) {
+            locker.Lock()
+            finish(dns, info.Err)
+            locker.Unlock()
+        },
+        ConnectStart: func(network, addr string) {
+            locker.Lock()
+            connects[network+" "+addr] = span.StartChild("connect").SetAttribute("net.peer.address", addr)
+            locker.Unlock()
+        },
+        ConnectDone: func(network, addr string, err error) {
+            locker.Lock()
+            finish(connects[network+" "+addr], err)
+            delete(connects, network+" "+addr)
+            locker.Unlock()
+        },
+        TLSHandshakeStart: func() {
+            locker.Lock()
+            handshake = span.StartChild("tls handshake")
+            locker.Unlock()
+        },
+        TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+            locker.Lock()
+            finish(handshake, err)
+            locker.Unlock()
+        },
+        WroteRequest: func(info httptrace.WroteRequestInfo) {
+            locker.Lock()
+            ttfb = span.StartChild("ttfb")
+            locker.Unlock()
+        },
+        GotFirstResponseByte: func() {
+            locker.Lock()
+            finish(ttfb, nil)
+            locker.Unlock()
+        },
+    }
+    return httpreq.WithContext(httptrace.WithClientTrace(httpreq.Context(), hooks))
+}
+
+// The startSpan starts span of the name as child of the span of context of the page, and returns function
+// that ends it.
+func startSpan(p *page.Page, name string) func() {
+    _, span := trace.Start(p.Context(), name)
+    return span.Finish
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/page"
+    "net"
+    "net/http"
+    "net/http/httptrace"
+    "time"
+)
+
+// IPFamily is family of ip addresses HttpDownloader connects to.
+type IPFamily int
+
+const (
+    // IPAuto connects to addresses of both families, racing them by happy eyeballs (RFC 6555).
+    IPAuto IPFamily = iota
+    // IPv4Only connects to IPv4 addresses only.
+    IPv4Only
+    // IPv6Only connects to IPv6 addresses only.
+    IPv6Only
+)
+
+// The defaultFallbackDelay is how long dialing addresses of the first family goes on before addresses of
+// the other family are dialed too, like net.Dialer.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// The SetIPFamily sets family of ip addresses of connections. Default IPAuto connects to IPv6 and IPv4
+// addresses of hosts in order of their dns answer, and dials addresses of the other family too when the first
+// one is not connected in the fallback delay, so a broken IPv6 route does not stall downloads.
+func (this *HttpDownloader) SetIPFamily(family IPFamily) *HttpDownloader {
+    return this.tuneTransport(func(t *http.Transport) {
+        this.ipFamily = family
+        t.DialContext = this.dialFunc()
+    })
+}
+
+func (this *HttpDownloader) GetIPFamily() IPFamily {
+    return this.ipFamily
+}
+
+// The SetFallbackDelay sets how long connecting to addresses of one family goes on before addresses of the
+// other family are raced by IPAuto. Default 0 is 300ms; negative disables racing, so addresses are dialed one
+// by one.
+func (this *HttpDownloader) SetFallbackDelay(d time.Duration) *HttpDownloader {
+    return this.tuneTransport(func(t *http.Transport) {
+        this.fallbackDelay = d
+        t.DialContext = this.dialFunc()
+    })
+}
+
+func (this *HttpDownloader) GetFallbackDelay() time.Duration {
+    return this.fallbackDelay
+}
+
+// The familyOf returns family of the ip address, or IPAuto if it is not an ip address.
+func familyOf(ip string) IPFamily {
+    parsed := net.ParseIP(ip)
+    if parsed == nil {
+        return IPAuto
+    }
+    if parsed.To4() != nil {
+        return IPv4Only
+    }
+    return IPv6Only
+}
+
+// The familyNetwork returns network "tcp4" or "tcp6" of the family, or the network as it is for IPAuto.
+func familyNetwork(network string, family IPFamily) string {
+    if network != "tcp" {
+        return network
+    }
+    switch family {
+    case IPv4Only:
+        return "tcp4"
+    case IPv6Only:
+        return "tcp6"
+    }
+    return network
+}
+
+// The dialSerial dials the addresses of the port in order until one is connected, and returns error of the
+// first one if none is.
+func dialSerial(ctx context.Context, d *net.Dialer, network string, ips []string, port string) (net.Conn, error) {
+    var firstErr error
+    for _, ip := range ips {
+        conn, err := d.DialContext(ctx, network, net.JoinHostPort(ip, port))
+        if err == nil {
+            return conn, nil
+        }
+        if firstErr == nil {
+            firstErr = err
+        }
+        if ctx.Err() != nil {
+            break
+        }
+    }
+    return nil, firstErr
+}
+
+// The dialParallel dials addresses of the family of the first address, and addresses of the other family
+// after the fallback delay or as soon as the first family fails, and returns the connection connected first.
+// The error of the first family is returned if both fail.
+func dialParallel(ctx context.Context, d *net.Dialer, network string, ips []string, port string,
+    fallbackDelay time.Duration) (net.Conn, error) {
+    var primaries, fallbacks []string
+    for _, ip := range ips {
+        if familyOf(ip) == familyOf(ips[0]) {
+            primaries = append(primaries, ip)
+        } else {
+            fallbacks = append(fallbacks, ip)
+        }
+    }
+    if len(fallbacks) == 0 || fallbackDelay < 0 {
+        return dialSerial(ctx, d, network, ips, port)
+    }
+    if fallbackDelay == 0 {
+        fallbackDelay = defaultFallbackDelay
+    }
+
+    type dialResult struct {
+        conn    net.Conn
+        err     error
+        primary bool
+    }
+    ctx, cancel := context.WithCancel(ctx)
+    defer cancel()
+    results := make(chan dialResult, 2)
+    dial := func(ips []string, primary bool) {
+        go func() {
+            conn, err := dialSerial(ctx, d, network, ips, port)
+            results <- dialResult{conn: conn, err: err, primary: primary}
+        }()
+    }
+    dial(primaries, true)
+    timer := time.NewTimer(fallbackDelay)
+    defer timer.Stop()
+
+    pending, fallback := 1, false
+    var primaryErr, fallbackErr error
+    for {
+        select {
+        case <-timer.C:
+            if !fallback {
+                dial(fallbacks, false)
+                pending, fallback = pending+1, true
+            }
+        case r := <-results:
+            pending--
+            if r.err == nil {
+                if pending > 0 {
+                    // the loser is canceled, or closed if it is connected anyway
+                    go func() {
+                        if late := <-results; late.conn != nil {
+                            late.conn.Close()
+                        }
+                    }()
+                }
+                return r.conn, nil
+            }
+            if r.primary {
+                primaryErr = r.err
+            } else {
+                fallbackErr = r.err
+            }
+            if !fallback {
+                dial(fallbacks, false)
+                pending, fallback = pending+1, true
+            } else if pending == 0 {
+                if primaryErr != nil {
+                    return nil, primaryErr
+                }
+                return nil, fallbackErr
+            }
+        }
+    }
+}
+
+// The withRemoteAddr returns the request recording remote address of its connection on the page, which is
+// address of the proxy if the page is downloaded by a proxy.
+func withRemoteAddr(httpreq *http.Request, p *page.Page) *http.Request {
+    hooks := &httptrace.ClientTrace{
+        GotConn: func(info httptrace.GotConnInfo) {
+            if addr := info.Conn.RemoteAddr(); addr != nil {
+                p.SetRemoteAddr(addr.String())
+            }
+        },
+    }
+    return httpreq.WithContext(httptrace.WithClientTrace(httpreq.Context(), hooks))
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "errors"
+    "net"
+    "os"
+    "strings"
+    "syscall"
+)
+
+// The classes of download errors returned by ClassifyError, which HttpDownloader saves in Page.SetErrorClass.
+const (
+    ErrorDNS     = "dns"
+    ErrorConnect = "connect"
+    ErrorTLS     = "tls"
+    ErrorTimeout = "timeout"
+)
+
+// ClassifyError returns class of the download error: ErrorDNS if the host is not found, ErrorConnect if the
+// connection is refused, reset or unreachable, ErrorTLS for handshake and certificate errors, and ErrorTimeout
+// for timeouts like those of SetTimeouts. It returns "" for other errors.
+func ClassifyError(err error) string {
+    if err == nil {
+        return ""
+    }
+    var dnsErr *net.DNSError
+    if errors.As(err, &dnsErr) {
+        if dnsErr.IsTimeout {
+            return ErrorTimeout
+        }
+        return ErrorDNS
+    }
+    var terr *timeoutError
+    var nerr net.Error
+    if errors.As(err, &terr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+        errors.As(err, &nerr) && nerr.Timeout() {
+        return ErrorTimeout
+    }
+    var certErr *tls.CertificateVerificationError
+    var recordErr tls.RecordHeaderError
+    var authorityErr x509.UnknownAuthorityError
+    var hostnameErr x509.HostnameError
+    var invalidErr x509.CertificateInvalidError
+    if errors.As(err, &certErr) || errors.As(err, &recordErr) || errors.As(err, &authorityErr) ||
+        errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) || strings.Contains(err.Error(), "tls: ") {
+        // alerts of the server, like "remote error: tls: handshake failure", have no exported type
+        return ErrorTLS
+    }
+    var opErr *net.OpError
+    if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+        errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) ||
+        errors.As(err, &opErr) && (opErr.Op == "dial" || opErr.Op == "proxyconnect") {
+        return ErrorConnect
+    }
+    return ""
+}
@@ -0,0 +1,296 @@
+package downloader
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// GeoIP looks up region of ip addresses, like country code "US" by a reader of a GeoLite2 database.
+// It returns "" if the region is unknown.
+type GeoIP interface {
+    Region(ip net.IP) string
+}
+
+// GeoIPFunc adapts a function to GeoIP.
+type GeoIPFunc func(ip net.IP) string
+
+func (this GeoIPFunc) Region(ip net.IP) string {
+    return this(ip)
+}
+
+// The GeoIPTable is GeoIP of ip ranges, like those of a csv file of a GeoIP vendor. The most specific range
+// containing an address is its region.
+type GeoIPTable struct {
+    locker sync.RWMutex
+    ranges []geoRange
+}
+
+type geoRange struct {
+    network *net.IPNet
+    region  string
+}
+
+// NewGeoIPTable returns GeoIPTable without ranges.
+func NewGeoIPTable() *GeoIPTable {
+    return &GeoIPTable{}
+}
+
+// The Add adds the range in CIDR notation of the region, like "192.0.2.0/24" of "DE". It returns error if the
+// range is not CIDR notation.
+func (this *GeoIPTable) Add(cidr string, region string) error {
+    _, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+    if err != nil {
+        return err
+    }
+    this.locker.Lock()
+    this.ranges = append(this.ranges, geoRange{network: network, region: region})
+    this.locker.Unlock()
+    return nil
+}
+
+func (this *GeoIPTable) Region(ip net.IP) string {
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    region, bits := "", -1
+    for _, r := range this.ranges {
+        if ones, _ := r.network.Mask.Size(); ones > bits && r.network.Contains(ip) {
+            region, bits = r.region, ones
+        }
+    }
+    return region
+}
+
+// The GeoRouter resolves target hosts, looks up their region by GeoIP, and picks proxies and local addresses
+// of the region for requests of them, which lowers latency and geo-blocking. Requests of hosts of regions
+// without pools use proxies and local addresses of HttpDownloader. Regions of hosts are kept for ttl.
+type GeoRouter struct {
+    geoip    GeoIP
+    resolver Resolver
+    ttl      time.Duration
+    // The downloaderResolver is Resolver of HttpDownloader the router is set to, used if resolver is nil.
+    downloaderResolver Resolver
+
+    locker     sync.Mutex
+    proxies    map[string]*ProxyPool
+    localAddrs map[string]*LocalAddrPool
+    hosts      map[string]geoHost
+}
+
+type geoHost struct {
+    region  string
+    expires time.Time
+}
+
+// NewGeoRouter returns GeoRouter looking up regions by geoip. Regions of hosts are kept for 10 minutes by
+// default.
+func NewGeoRouter(geoip GeoIP) *GeoRouter {
+    return &GeoRouter{geoip: geoip, ttl: 10 * time.Minute, proxies: make(map[string]*ProxyPool),
+        localAddrs: make(map[string]*LocalAddrPool), hosts: make(map[string]geoHost)}
+}
+
+// The SetResolver sets Resolver of target hosts. The nil means Resolver of HttpDownloader the router is set to,
+// so hosts are resolved once by its DNSCache and the same way as connections, or the system resolver if the
+// downloader has none, which is default.
+func (this *GeoRouter) SetResolver(r Resolver) *GeoRouter {
+    this.locker.Lock()
+    this.resolver = r
+    this.locker.Unlock()
+    return this
+}
+
+// The SetTTL sets how long the region of a host is kept.
+func (this *GeoRouter) SetTTL(ttl time.Duration) *GeoRouter {
+    this.locker.Lock()
+    this.ttl = ttl
+    this.locker.Unlock()
+    return this
+}
+
+// The SetProxyPool sets ProxyPool of proxies in the region, used for requests of hosts in the region. The nil
+// pool removes the region.
+func (this *GeoRouter) SetProxyPool(region string, pool *ProxyPool) *GeoRouter {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if pool == nil {
+        delete(this.proxies, region)
+    } else {
+        this.proxies[region] = pool
+    }
+    return this
+}
+
+func (this *GeoRouter) GetProxyPool(region string) *ProxyPool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.proxies[region]
+}
+
+// The proxyPools returns ProxyPool of all the regions.
+func (this *GeoRouter) proxyPools() []*ProxyPool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    pools := make([]*ProxyPool, 0, len(this.proxies))
+    for _, pool := range this.proxies {
+        pools = append(pools, pool)
+    }
+    return pools
+}
+
+// The SetLocalAddrPool sets LocalAddrPool of local addresses routed to the region, which connections to hosts
+// in the region are bound to. The nil pool removes the region.
+func (this *GeoRouter) SetLocalAddrPool(region string, pool *LocalAddrPool) *GeoRouter {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if pool == nil {
+        delete(this.localAddrs, region)
+    } else {
+        this.localAddrs[region] = pool
+    }
+    return this
+}
+
+func (this *GeoRouter) GetLocalAddrPool(region string) *LocalAddrPool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.localAddrs[region]
+}
+
+// The Region returns region of the host by the first of its addresses whose region is known, or "" if none
+// is known or the host is not resolved.
+func (this *GeoRouter) Region(ctx context.Context, host string) string {
+    host = strings.ToLower(host)
+    now := time.Now()
+    this.locker.Lock()
+    h, ok := this.hosts[host]
+    resolver, ttl := this.resolver, this.ttl
+    if resolver == nil {
+        resolver = this.downloaderResolver
+    }
+    this.locker.Unlock()
+    if ok && now.Before(h.expires) {
+        return h.region
+    }
+
+    var addrs []string
+    if net.ParseIP(host) != nil {
+        addrs = []string{host}
+    } else {
+        var err error
+        if resolver != nil {
+            addrs, err = resolver.LookupHost(ctx, host)
+        } else {
+            addrs, err = net.DefaultResolver.LookupHost(ctx, host)
+        }
+        if err != nil && ctx.Err() != nil {
+            // a canceled lookup is not the answer of dns
+            return ""
+        }
+    }
+    region := ""
+    for _, addr := range addrs {
+        if ip := net.ParseIP(addr); ip != nil {
+            if region = this.geoip.Region(ip); region != "" {
+                break
+            }
+        }
+    }
+
+    this.locker.Lock()
+    if len(this.hosts) > 4096 {
+        for key, h := range this.hosts {
+            if !now.Before(h.expires) {
+                delete(this.hosts, key)
+            }
+        }
+    }
+    this.hosts[host] = geoHost{region: region, expires: now.Add(ttl)}
+    this.locker.Unlock()
+    return region
+}
+
+// The proxyPool returns ProxyPool of the region of the host, or nil if its region has no proxies.
+func (this *GeoRouter) proxyPool(ctx context.Context, host string) *ProxyPool {
+    if !this.hasProxies() {
+        return nil
+    }
+    return this.GetProxyPool(this.Region(ctx, host))
+}
+
+// The localAddrPool returns LocalAddrPool of the region of the host, or nil if its region has no local
+// addresses.
+func (this *GeoRouter) localAddrPool(ctx context.Context, host string) *LocalAddrPool {
+    this.locker.Lock()
+    n := len(this.localAddrs)
+    this.locker.Unlock()
+    if n == 0 {
+        return nil
+    }
+    return this.GetLocalAddrPool(this.Region(ctx, host))
+}
+
+func (this *GeoRouter) hasProxies() bool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return len(this.proxies) > 0
+}
+
+// The setDownloaderResolver sets Resolver of HttpDownloader the router is set to.
+func (this *GeoRouter) setDownloaderResolver(r Resolver) {
+    this.locker.Lock()
+    this.downloaderResolver = r
+    this.locker.Unlock()
+}
+
+// The poolOf returns ProxyPool of a region that has the proxy, or nil.
+func (this *GeoRouter) poolOf(proxy string) *ProxyPool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    for _, pool := range this.proxies {
+        if pool.has(proxy) {
+            return pool
+        }
+    }
+    return nil
+}
+
+// The SetGeoRouter sets GeoRouter picking proxies and local addresses of the region of the host of requests
+// without proxy of their own or of their session. Outcome of each download is reported to the pool of its
+// proxy. The nil means proxies and local addresses are picked regardless of hosts, which is default.
+func (this *HttpDownloader) SetGeoRouter(r *GeoRouter) *HttpDownloader {
+    return this.tuneTransport(func(t *http.Transport) {
+        this.geoRouter = r
+        if r != nil {
+            r.setDownloaderResolver(this.resolver)
+        }
+        t.DialContext = this.dialFunc()
+    })
+}
+
+func (this *HttpDownloader) GetGeoRouter() *GeoRouter {
+    return this.geoRouter
+}
+
+// The proxyPoolOf returns ProxyPool that has the proxy, of GeoRouter or of HttpDownloader, or nil.
+func (this *HttpDownloader) proxyPoolOf(proxy string) *ProxyPool {
+    if this.geoRouter != nil {
+        if pool := this.geoRouter.poolOf(proxy); pool != nil {
+            return pool
+        }
+    }
+    if this.proxyPool != nil && this.proxyPool.has(proxy) {
+        return this.proxyPool
+    }
+    return nil
+}
+
+// The BanProxy bans the proxy at once by ProxyPool that has it, of GeoRouter or of HttpDownloader, like when
+// it gets a captcha page.
+func (this *HttpDownloader) BanProxy(proxy string) {
+    if pool := this.proxyPoolOf(proxy); pool != nil {
+        pool.Ban(proxy)
+    }
+}
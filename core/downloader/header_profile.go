@@ -0,0 +1,191 @@
+package downloader
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+)
+
+// The HeaderProfile sets a coherent set of browser headers to each request, matching its User-Agent:
+// Accept, Accept-Language, Upgrade-Insecure-Requests, Sec-Fetch-* and client hints Sec-CH-UA* of Chromium
+// browsers. Requests of "json" and "jsonp" responce type are sent like fetch() of a page, and other requests
+// like navigation of a document; Sec-Fetch-Site follows Referer. Headers set to the request are kept.
+// A profile of a browser sends its first User-Agent of DefaultUserAgents if the request has none, so use
+// NewUserAgentPool(profile.UserAgents()) to rotate User-Agents of the same browser; a profile of "auto"
+// follows any User-Agent, and sends headers of Chrome for User-Agents of unknown browsers.
+type HeaderProfile struct {
+    browser  string
+    language string
+}
+
+// The headerProfileBrowsers are browsers of HeaderProfile, with the substring of their User-Agents.
+var headerProfileBrowsers = map[string]string{
+    "chrome":  "Chrome/",
+    "edge":    "Edg/",
+    "firefox": "Firefox/",
+    "safari":  "Version/",
+}
+
+// NewHeaderProfile returns HeaderProfile of the browser, "chrome", "edge", "firefox", "safari" or "auto".
+// It panics if the browser is unknown.
+func NewHeaderProfile(browser string) *HeaderProfile {
+    browser = strings.ToLower(browser)
+    if _, ok := headerProfileBrowsers[browser]; !ok && browser != "auto" {
+        panic("unknown browser of header profile : " + browser)
+    }
+    return &HeaderProfile{browser: browser}
+}
+
+// The SetLanguage sets Accept-Language, like "de-DE,de;q=0.9,en;q=0.8". Default is English of the browser.
+func (this *HeaderProfile) SetLanguage(language string) *HeaderProfile {
+    this.language = language
+    return this
+}
+
+// The UserAgents returns User-Agents of the browser in DefaultUserAgents, or all of them for "auto".
+func (this *HeaderProfile) UserAgents() []string {
+    var agents []string
+    for _, ua := range DefaultUserAgents {
+        if this.browser == "auto" || uaBrowser(ua) == this.browser {
+            agents = append(agents, ua)
+        }
+    }
+    return agents
+}
+
+// The uaBrowser returns browser of the User-Agent, or "" if it is unknown.
+func uaBrowser(ua string) string {
+    switch {
+    case strings.Contains(ua, "Edg/"):
+        return "edge"
+    case strings.Contains(ua, "Firefox/"):
+        return "firefox"
+    case strings.Contains(ua, "Chrome/"):
+        return "chrome"
+    case strings.Contains(ua, "Safari/") && strings.Contains(ua, "Version/"):
+        return "safari"
+    }
+    return ""
+}
+
+// The uaPlatform returns platform of the User-Agent in format of Sec-CH-UA-Platform.
+func uaPlatform(ua string) string {
+    switch {
+    case strings.Contains(ua, "Android"):
+        return "Android"
+    case strings.Contains(ua, "iPhone"), strings.Contains(ua, "iPad"):
+        return "iOS"
+    case strings.Contains(ua, "Windows"):
+        return "Windows"
+    case strings.Contains(ua, "Macintosh"):
+        return "macOS"
+    case strings.Contains(ua, "CrOS"):
+        return "Chrome OS"
+    case strings.Contains(ua, "Linux"):
+        return "Linux"
+    }
+    return "Unknown"
+}
+
+var uaMajorVersion = regexp.MustCompile(`(?:Chrome|Edg)/(\d+)`)
+
+// The Headers returns headers of the request sent with the User-Agent, and the User-Agent itself if ua is
+// empty and the profile is of a browser.
+func (this *HeaderProfile) Headers(req *request.Request, ua string) http.Header {
+    header := make(http.Header)
+    if ua == "" && this.browser != "auto" {
+        if agents := this.UserAgents(); len(agents) > 0 {
+            ua = agents[0]
+            header.Set("User-Agent", ua)
+        }
+    }
+    browser := uaBrowser(ua)
+    if browser == "" {
+        browser = "chrome"
+    }
+    mobile := strings.Contains(ua, "Mobile") || strings.Contains(ua, "Android")
+
+    xhr := req.GetResponceType() == "json" || req.GetResponceType() == "jsonp"
+    if xhr {
+        header.Set("Accept", "application/json, text/plain, */*")
+    } else if browser == "chrome" || browser == "edge" {
+        header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,"+
+            "image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7")
+    } else {
+        header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+    }
+    switch {
+    case this.language != "":
+        header.Set("Accept-Language", this.language)
+    case browser == "firefox":
+        header.Set("Accept-Language", "en-US,en;q=0.5")
+    default:
+        header.Set("Accept-Language", "en-US,en;q=0.9")
+    }
+
+    if browser == "chrome" || browser == "edge" {
+        version := "140"
+        if m := uaMajorVersion.FindStringSubmatch(ua); m != nil {
+            version = m[1]
+        }
+        brand := `"Google Chrome";v="` + version + `"`
+        if browser == "edge" {
+            brand = `"Microsoft Edge";v="` + version + `"`
+        }
+        header.Set("Sec-CH-UA", `"Chromium";v="`+version+`", "Not=A?Brand";v="24", `+brand)
+        if mobile {
+            header.Set("Sec-CH-UA-Mobile", "?1")
+        } else {
+            header.Set("Sec-CH-UA-Mobile", "?0")
+        }
+        header.Set("Sec-CH-UA-Platform", `"`+uaPlatform(ua)+`"`)
+    }
+
+    header.Set("Sec-Fetch-Site", fetchSite(req))
+    if xhr {
+        header.Set("Sec-Fetch-Mode", "cors")
+        header.Set("Sec-Fetch-Dest", "empty")
+    } else {
+        header.Set("Upgrade-Insecure-Requests", "1")
+        header.Set("Sec-Fetch-Mode", "navigate")
+        header.Set("Sec-Fetch-User", "?1")
+        header.Set("Sec-Fetch-Dest", "document")
+    }
+    return header
+}
+
+// The fetchSite returns Sec-Fetch-Site of the request by its Referer: "none" without Referer, "same-origin",
+// "same-site" of the same registered domain, or "cross-site".
+func fetchSite(req *request.Request) string {
+    referer := req.GetReferer()
+    if referer == "" {
+        referer = req.GetHeader().Get("Referer")
+    }
+    if referer == "" {
+        return "none"
+    }
+    from, err1 := url.Parse(referer)
+    to, err2 := url.Parse(req.GetUrl())
+    if err1 != nil || err2 != nil {
+        return "cross-site"
+    }
+    if from.Scheme == to.Scheme && strings.EqualFold(from.Host, to.Host) {
+        return "same-origin"
+    }
+    if registeredDomain(from.Hostname()) == registeredDomain(to.Hostname()) {
+        return "same-site"
+    }
+    return "cross-site"
+}
+
+// The SetHeaderProfile sets HeaderProfile that sets browser headers to requests, matching their User-Agent.
+func (this *HttpDownloader) SetHeaderProfile(profile *HeaderProfile) *HttpDownloader {
+    this.headerProfile = profile
+    return this
+}
+
+func (this *HttpDownloader) GetHeaderProfile() *HeaderProfile {
+    return this.headerProfile
+}
@@ -0,0 +1,72 @@
+package downloader_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+func TestHeaderProfile(t *testing.T) {
+    var header http.Header
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        header = r.Header
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    dl := downloader.NewHttpDownloader().SetHeaderProfile(downloader.NewHeaderProfile("chrome"))
+    dl.Download(request.NewRequest(ts.URL, "html"))
+    if ua := header.Get("User-Agent"); !strings.Contains(ua, "Chrome/140") {
+        t.Errorf("user agent of the browser should be sent: %s", ua)
+    }
+    for key, value := range map[string]string{
+        "Sec-Ch-Ua":          `"Chromium";v="140", "Not=A?Brand";v="24", "Google Chrome";v="140"`,
+        "Sec-Ch-Ua-Mobile":   "?0",
+        "Sec-Ch-Ua-Platform": `"Windows"`,
+        "Sec-Fetch-Site":     "none",
+        "Sec-Fetch-Mode":     "navigate",
+        "Sec-Fetch-Dest":     "document",
+        "Accept-Language":    "en-US,en;q=0.9",
+    } {
+        if header.Get(key) != value {
+            t.Errorf("%s should be %s: %s", key, value, header.Get(key))
+        }
+    }
+    if !strings.HasPrefix(header.Get("Accept"), "text/html") || header.Get("Accept-Encoding") == "" {
+        t.Errorf("accept headers of document should be sent: %v", header)
+    }
+
+    // headers follow user agent of the request, and headers of the request are kept
+    firefox := "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:143.0) Gecko/20100101 Firefox/143.0"
+    dl.SetHeaderProfile(downloader.NewHeaderProfile("auto").SetLanguage("de-DE,de;q=0.9"))
+    dl.Download(request.NewRequest(ts.URL+"/api", "json").SetHeader("User-Agent", firefox).
+        SetReferer(ts.URL+"/").SetHeader("Sec-Fetch-Dest", "script"))
+    if header.Get("Sec-Ch-Ua") != "" {
+        t.Error("client hints should not be sent by firefox")
+    }
+    for key, value := range map[string]string{
+        "Accept":          "application/json, text/plain, */*",
+        "Accept-Language": "de-DE,de;q=0.9",
+        "Sec-Fetch-Site":  "same-origin",
+        "Sec-Fetch-Mode":  "cors",
+        "Sec-Fetch-Dest":  "script",
+    } {
+        if header.Get(key) != value {
+            t.Errorf("%s should be %s: %s", key, value, header.Get(key))
+        }
+    }
+
+    profile := downloader.NewHeaderProfile("safari")
+    for _, ua := range profile.UserAgents() {
+        if !strings.Contains(ua, "Version/") || strings.Contains(ua, "Chrome/") {
+            t.Errorf("user agents should be of safari: %s", ua)
+        }
+    }
+    req := request.NewRequest("http://www.example.com/", "html").SetReferer("http://shop.example.com/")
+    if site := profile.Headers(req, "").Get("Sec-Fetch-Site"); site != "same-site" {
+        t.Errorf("sec-fetch-site of the same domain should be same-site: %s", site)
+    }
+}
@@ -0,0 +1,153 @@
+package downloader
+
+import (
+    "context"
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The LocalAddrPool binds outgoing connections to local ip addresses of a multi-homed crawl host, so the
+// load is spread over the addresses without proxies. Addresses are picked round-robin for each connection;
+// if it is sticky, connections of the same host keep the address picked for its first connection.
+// Connections to http proxies are bound too, but connections of socks proxies are not.
+type LocalAddrPool struct {
+    addrs  []net.IP
+    sticky bool
+
+    locker sync.Mutex
+    next   int
+    hosts  map[string]net.IP
+}
+
+// NewLocalAddrPool returns LocalAddrPool of the ip addresses, like "192.0.2.10" and "2001:db8::10".
+// It panics if an address is not an ip address.
+func NewLocalAddrPool(addrs ...string) *LocalAddrPool {
+    pool := &LocalAddrPool{hosts: make(map[string]net.IP)}
+    for _, addr := range addrs {
+        ip := net.ParseIP(strings.TrimSpace(addr))
+        if ip == nil {
+            panic("local address is not an ip address : " + addr)
+        }
+        pool.addrs = append(pool.addrs, ip)
+    }
+    return pool
+}
+
+// The SetSticky sets whether connections of a host keep the same address. Default is false.
+func (this *LocalAddrPool) SetSticky(sticky bool) *LocalAddrPool {
+    this.sticky = sticky
+    return this
+}
+
+// The Get returns local address of a connection to the host, or nil if the pool is empty.
+func (this *LocalAddrPool) Get(host string) net.IP {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if len(this.addrs) == 0 {
+        return nil
+    }
+    host = strings.ToLower(host)
+    if this.sticky {
+        if ip, ok := this.hosts[host]; ok {
+            return ip
+        }
+    }
+    ip := this.addrs[this.next%len(this.addrs)]
+    this.next++
+    if this.sticky {
+        this.hosts[host] = ip
+    }
+    return ip
+}
+
+// The DialContext dials the address from a local address of the pool, like DialContext of net.Dialer.
+func (this *LocalAddrPool) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+    return newDialFunc(dialOptions{localAddrs: this})(ctx, network, addr)
+}
+
+// The SetLocalAddrPool binds outgoing connections to local addresses of the pool. The nil means connections
+// are dialed from the default address, which is default.
+func (this *HttpDownloader) SetLocalAddrPool(pool *LocalAddrPool) *HttpDownloader {
+    return this.tuneTransport(func(t *http.Transport) {
+        this.localAddrs = pool
+        t.DialContext = this.dialFunc()
+    })
+}
+
+// The dialOptions are options of dial function of HttpDownloader.
+type dialOptions struct {
+    resolver      Resolver
+    localAddrs    *LocalAddrPool
+    family        IPFamily
+    fallbackDelay time.Duration
+    socket        SocketOptions
+    geo           *GeoRouter
+}
+
+// The dialOptions returns options of dial function of the downloader. It should be called with locker.
+func (this *HttpDownloader) dialOptions() dialOptions {
+    return dialOptions{resolver: this.resolver, localAddrs: this.localAddrs, family: this.ipFamily,
+        fallbackDelay: this.fallbackDelay, socket: this.socketOptions, geo: this.geoRouter}
+}
+
+// The newDialFunc returns dial function of transport that resolves hosts by the resolver and binds
+// connections to local addresses of the pool; nil resolver is the system resolver and nil pool is the
+// default local address. Addresses of a host of the ip family are raced by dialParallel, and socket options
+// are set on each socket before it connects. Local addresses of the region of the host by GeoRouter are used
+// instead of the pool.
+func newDialFunc(opts dialOptions) func(ctx context.Context, network, addr string) (net.Conn, error) {
+    resolver, pool, family, fallbackDelay := opts.resolver, opts.localAddrs, opts.family, opts.fallbackDelay
+    dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, FallbackDelay: fallbackDelay,
+        Control: opts.socket.control()}
+    if resolver == nil && pool == nil && opts.geo == nil {
+        if family == IPAuto {
+            return dialer.DialContext
+        }
+        return func(ctx context.Context, network, addr string) (net.Conn, error) {
+            return dialer.DialContext(ctx, familyNetwork(network, family), addr)
+        }
+    }
+    return func(ctx context.Context, network, addr string) (net.Conn, error) {
+        host, port, err := net.SplitHostPort(addr)
+        if err != nil {
+            return nil, err
+        }
+        d := *dialer
+        network = familyNetwork(network, family)
+        addrs := pool
+        if opts.geo != nil {
+            if regional := opts.geo.localAddrPool(ctx, host); regional != nil {
+                addrs = regional
+            }
+        }
+        var local net.IP
+        if addrs != nil {
+            if local = addrs.Get(host); local != nil {
+                d.LocalAddr = &net.TCPAddr{IP: local}
+                // the remote address should be of the same family as the local address
+                network = familyNetwork(network, familyOf(local.String()))
+            }
+        }
+        ips := []string{host}
+        if resolver != nil && net.ParseIP(host) == nil {
+            if ips, err = resolver.LookupHost(ctx, host); err != nil {
+                return nil, err
+            }
+        }
+        kept := ips[:0]
+        for _, ip := range ips {
+            f := familyOf(ip)
+            if f != IPAuto && (local != nil && f != familyOf(local.String()) || family != IPAuto && f != family) {
+                continue
+            }
+            kept = append(kept, ip)
+        }
+        if len(kept) == 0 || local != nil && family != IPAuto && familyOf(local.String()) != family {
+            return nil, &net.DNSError{Err: "no address of the ip family", Name: host}
+        }
+        return dialParallel(ctx, &d, network, kept, port, fallbackDelay)
+    }
+}
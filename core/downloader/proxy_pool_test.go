@@ -0,0 +1,137 @@
+package downloader_test
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestProxyPool(t *testing.T) {
+    good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("good"))
+    }))
+    defer good.Close()
+    bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusBadGateway)
+    }))
+    defer bad.Close()
+
+    pool := downloader.NewProxyPool([]string{good.URL, bad.URL}).SetBan(2, time.Hour)
+    dl := downloader.NewHttpDownloader().SetProxyPool(pool)
+    var proxies []string
+    for i := 0; i < 6; i++ {
+        p := dl.Download(request.NewRequest("http://target.example/", "text"))
+        proxies = append(proxies, p.GetProxyHost())
+    }
+    // proxies are rotated until the bad one is banned after 2 failures
+    expected := []string{good.URL, bad.URL, good.URL, bad.URL, good.URL, good.URL}
+    for i := range expected {
+        if proxies[i] != expected[i] {
+            t.Fatalf("proxy rotation error: %v", proxies)
+        }
+    }
+    for _, s := range pool.Stats() {
+        if s.Proxy == good.URL && (s.Success != 4 || s.Score != 1 || s.Banned) {
+            t.Errorf("good proxy stats error: %+v", s)
+        }
+        if s.Proxy == bad.URL && (s.Failure != 2 || s.Score != 0 || !s.Banned) {
+            t.Errorf("bad proxy stats error: %+v", s)
+        }
+    }
+
+    // proxy of the request is used and not reported
+    if p := dl.Download(request.NewRequest("http://target.example/", "text").SetProxyHost(bad.URL)); p.GetProxyHost() != bad.URL {
+        t.Error("proxy of request should be used")
+    }
+
+    // the proxy whose ban ends first is used if all the proxies are banned
+    pool.Ban(good.URL)
+    if pool.Get() != bad.URL {
+        t.Error("proxy banned earlier should be used")
+    }
+    pool.Remove(bad.URL)
+    if pool.Get() != good.URL || len(pool.Stats()) != 1 {
+        t.Error("remove error")
+    }
+}
+
+func TestGeoRouter(t *testing.T) {
+    // each proxy answers its name
+    proxy := func(name string, status int) *httptest.Server {
+        return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            w.WriteHeader(status)
+            w.Write([]byte(name))
+        }))
+    }
+    eu, us, other := proxy("eu", http.StatusOK), proxy("us", http.StatusBadGateway), proxy("other", http.StatusOK)
+    defer eu.Close()
+    defer us.Close()
+    defer other.Close()
+
+    table := downloader.NewGeoIPTable()
+    table.Add("192.0.2.0/24", "EU")
+    table.Add("192.0.2.128/25", "US")
+    if table.Add("192.0.2.1", "EU") == nil {
+        t.Error("range should be CIDR notation")
+    }
+    resolver := downloader.NewDNSCache(nil, time.Minute).AddHost("eu.test", "192.0.2.1").
+        AddHost("us.test", "192.0.2.200").AddHost("cn.test", "198.51.100.1")
+    geo := downloader.NewGeoRouter(table).SetResolver(resolver).
+        SetProxyPool("EU", downloader.NewProxyPool([]string{eu.URL})).
+        SetProxyPool("US", downloader.NewProxyPool([]string{us.URL}).SetBan(1, time.Hour))
+    if region := geo.Region(context.Background(), "us.test"); region != "US" {
+        t.Errorf("the most specific range should be the region: %s", region)
+    }
+    dl := downloader.NewHttpDownloader().SetGeoRouter(geo).SetProxyPool(downloader.NewProxyPool([]string{other.URL}))
+    for host, expected := range map[string]string{"eu.test": "eu", "us.test": "us", "cn.test": "other"} {
+        if p := dl.Download(request.NewRequest("http://"+host+"/", "text")); p.GetBodyStr() != expected {
+            t.Errorf("%s should be downloaded by proxy of its region: %s", host, p.GetBodyStr())
+        }
+    }
+    for _, s := range geo.GetProxyPool("US").Stats() {
+        if !s.Banned || s.Failure != 1 {
+            t.Errorf("failure should be reported to the pool of the region: %+v", s)
+        }
+    }
+    dl.BanProxy(eu.URL)
+    if s := geo.GetProxyPool("EU").Stats(); !s[0].Banned {
+        t.Error("proxy should be banned by the pool of its region")
+    }
+
+    // router without resolver resolves hosts by the resolver of the downloader
+    geo = downloader.NewGeoRouter(table)
+    downloader.NewHttpDownloader().SetResolver(resolver).SetGeoRouter(geo)
+    if region := geo.Region(context.Background(), "eu.test"); region != "EU" {
+        t.Errorf("host should be resolved by resolver of the downloader: %s", region)
+    }
+    geo = downloader.NewGeoRouter(table)
+    downloader.NewHttpDownloader().SetGeoRouter(geo).SetResolver(resolver)
+    if region := geo.Region(context.Background(), "us.test"); region != "US" {
+        t.Errorf("host should be resolved by resolver set after the router: %s", region)
+    }
+
+    // connections to hosts of the region are bound to its local addresses
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        host, _, _ := net.SplitHostPort(r.RemoteAddr)
+        w.Write([]byte(host))
+    }))
+    defer ts.Close()
+    _, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+    table.Add("127.0.0.0/8", "LOCAL")
+    resolver.AddHost("local.test", "127.0.0.1")
+    geo = downloader.NewGeoRouter(table).SetResolver(resolver).
+        SetLocalAddrPool("LOCAL", downloader.NewLocalAddrPool("127.0.0.2"))
+    dl = downloader.NewHttpDownloader().SetResolver(resolver).SetGeoRouter(geo)
+    p := dl.Download(request.NewRequest("http://local.test:"+port+"/", "text"))
+    if !p.IsSucc() {
+        t.Skip("local address can not be bound: " + p.Errormsg())
+    }
+    if p.GetBodyStr() != "127.0.0.2" {
+        t.Errorf("connection should be bound to local address of the region: %s", p.GetBodyStr())
+    }
+}
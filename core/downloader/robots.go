@@ -0,0 +1,270 @@
+package downloader
+
+import (
+    "bufio"
+    "errors"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The ErrRobotsDisallowed is error message of page whose url is disallowed by robots.txt.
+var ErrRobotsDisallowed = errors.New("disallowed by robots.txt")
+
+// The Robots fetches robots.txt of each host and tells whether an url can be crawled.
+// Rules of the group matching the user agent are used, or rules of group "*" if no group matches.
+// The longest matching Allow or Disallow path wins, and Allow wins if they are of the same length.
+// Paths can have wildcard "*" and end anchor "$".
+// If robots.txt is not found (4xx), everything is allowed; if it can not be fetched (network error or 5xx),
+// everything is allowed and it is fetched again after a minute.
+type Robots struct {
+    userAgent string
+    client    *http.Client
+    ttl       time.Duration
+
+    locker sync.Mutex
+    hosts  map[string]*robotsEntry
+}
+
+// The robotsEntry is robots.txt of a host. The ready is closed after the rules are fetched,
+// so that concurrent requests of the host wait for one fetch.
+type robotsEntry struct {
+    ready   chan struct{}
+    rules   *robotsRules
+    expires time.Time
+}
+
+// The robotsRules is the group of robots.txt for the user agent.
+type robotsRules struct {
+    allows     []string
+    disallows  []string
+    crawlDelay time.Duration
+}
+
+// NewRobots returns Robots that uses rules for the userAgent, like "go_spider".
+// The userAgent is also sent when robots.txt is fetched. Empty userAgent uses group "*" only.
+func NewRobots(userAgent string) *Robots {
+    return &Robots{
+        userAgent: userAgent,
+        client:    &http.Client{Timeout: 30 * time.Second},
+        ttl:       24 * time.Hour,
+        hosts:     make(map[string]*robotsEntry),
+    }
+}
+
+// The SetClient sets http client for fetching robots.txt, like one with the proxy of crawl.
+func (this *Robots) SetClient(c *http.Client) *Robots {
+    this.client = c
+    return this
+}
+
+// The SetTtl sets how long robots.txt of a host is cached. Default is 24 hours.
+func (this *Robots) SetTtl(ttl time.Duration) *Robots {
+    this.ttl = ttl
+    return this
+}
+
+// The Allowed tests whether the url can be crawled. Urls that are not http or https are allowed.
+func (this *Robots) Allowed(rawurl string) bool {
+    u, err := url.Parse(rawurl)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+        return true
+    }
+    path := u.EscapedPath()
+    if path == "" {
+        path = "/"
+    }
+    if u.RawQuery != "" {
+        path += "?" + u.RawQuery
+    }
+    return this.rules(u).allowed(path)
+}
+
+// The CrawlDelay returns Crawl-delay of host of the url, or 0 if it is not set.
+func (this *Robots) CrawlDelay(rawurl string) time.Duration {
+    u, err := url.Parse(rawurl)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+        return 0
+    }
+    return this.rules(u).crawlDelay
+}
+
+// The rules returns rules of host of the url, and fetches robots.txt if it is not cached.
+func (this *Robots) rules(u *url.URL) *robotsRules {
+    host := u.Scheme + "://" + u.Host
+
+    this.locker.Lock()
+    entry, ok := this.hosts[host]
+    if ok {
+        select {
+        case <-entry.ready:
+            if time.Now().After(entry.expires) {
+                ok = false
+            }
+        default:
+        }
+    }
+    if !ok {
+        entry = &robotsEntry{ready: make(chan struct{})}
+        this.hosts[host] = entry
+        this.locker.Unlock()
+
+        rules, ttl := this.fetch(host + "/robots.txt")
+        entry.rules = rules
+        entry.expires = time.Now().Add(ttl)
+        close(entry.ready)
+        return rules
+    }
+    this.locker.Unlock()
+
+    <-entry.ready
+    return entry.rules
+}
+
+// The fetch downloads and parses robots.txt, and returns how long the rules can be cached.
+func (this *Robots) fetch(robotsUrl string) (*robotsRules, time.Duration) {
+    httpreq, err := http.NewRequest("GET", robotsUrl, nil)
+    if err != nil {
+        return &robotsRules{}, this.ttl
+    }
+    if this.userAgent != "" {
+        httpreq.Header.Set("User-Agent", this.userAgent)
+    }
+    resp, err := this.client.Do(httpreq)
+    if err != nil {
+        logger.Warn("robots.txt fetch error : " + err.Error())
+        return &robotsRules{}, time.Minute
+    }
+    defer resp.Body.Close()
+    switch {
+    case resp.StatusCode >= 500:
+        logger.Warn("robots.txt fetch error : http status " + strconv.Itoa(resp.StatusCode) + " " + robotsUrl)
+        return &robotsRules{}, time.Minute
+    case resp.StatusCode >= 400:
+        return &robotsRules{}, this.ttl
+    }
+    // robots.txt over 500KB is ignored, as Google does.
+    return parseRobots(io.LimitReader(resp.Body, 500*1024), this.userAgent), this.ttl
+}
+
+// The parseRobots returns rules of the group for the userAgent in robots.txt.
+// The group whose User-agent is the longest one contained in userAgent is chosen.
+func parseRobots(r io.Reader, userAgent string) *robotsRules {
+    agent := strings.ToLower(userAgent)
+    var matched, star *robotsRules
+    matchedLen := 0
+
+    // the current group, which can have several User-agent lines
+    var group []*robotsRules
+    inRules := false
+    scanner := bufio.NewScanner(r)
+    for scanner.Scan() {
+        line := scanner.Text()
+        if i := strings.Index(line, "#"); i >= 0 {
+            line = line[:i]
+        }
+        i := strings.Index(line, ":")
+        if i < 0 {
+            continue
+        }
+        key := strings.ToLower(strings.TrimSpace(line[:i]))
+        value := strings.TrimSpace(line[i+1:])
+
+        if key == "user-agent" {
+            if inRules {
+                group = nil
+                inRules = false
+            }
+            name := strings.ToLower(value)
+            rules := &robotsRules{}
+            if name == "*" {
+                if star == nil {
+                    star = rules
+                }
+                group = append(group, star)
+            } else if name != "" && strings.Contains(agent, name) && len(name) >= matchedLen {
+                if matched == nil || len(name) > matchedLen {
+                    matched = rules
+                    matchedLen = len(name)
+                }
+                group = append(group, matched)
+            }
+            continue
+        }
+
+        inRules = true
+        for _, rules := range group {
+            switch key {
+            case "allow":
+                if value != "" {
+                    rules.allows = append(rules.allows, value)
+                }
+            case "disallow":
+                if value != "" {
+                    rules.disallows = append(rules.disallows, value)
+                }
+            case "crawl-delay":
+                if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+                    rules.crawlDelay = time.Duration(seconds * float64(time.Second))
+                }
+            }
+        }
+    }
+    if matched != nil {
+        return matched
+    }
+    if star != nil {
+        return star
+    }
+    return &robotsRules{}
+}
+
+// The allowed tests whether the path with query is allowed by the rules.
+func (this *robotsRules) allowed(path string) bool {
+    allow := longestMatch(this.allows, path)
+    disallow := longestMatch(this.disallows, path)
+    return disallow < 0 || allow >= disallow
+}
+
+// The longestMatch returns length of the longest pattern matching the path, or -1 if none matches.
+func longestMatch(patterns []string, path string) int {
+    longest := -1
+    for _, pattern := range patterns {
+        if len(pattern) > longest && robotsMatch(pattern, path) {
+            longest = len(pattern)
+        }
+    }
+    return longest
+}
+
+// The robotsMatch tests whether the path starts with the pattern, which can have "*" and end anchor "$".
+func robotsMatch(pattern, path string) bool {
+    anchored := strings.HasSuffix(pattern, "$")
+    if anchored {
+        pattern = pattern[:len(pattern)-1]
+    }
+    parts := strings.Split(pattern, "*")
+    if !strings.HasPrefix(path, parts[0]) {
+        return false
+    }
+    if len(parts) == 1 {
+        return !anchored || len(path) == len(parts[0])
+    }
+    pos := len(parts[0])
+    last := parts[len(parts)-1]
+    for _, part := range parts[1 : len(parts)-1] {
+        i := strings.Index(path[pos:], part)
+        if i < 0 {
+            return false
+        }
+        pos += i + len(part)
+    }
+    if anchored {
+        return len(path)-len(last) >= pos && strings.HasSuffix(path, last)
+    }
+    return strings.Contains(path[pos:], last)
+}
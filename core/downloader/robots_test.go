@@ -0,0 +1,81 @@
+package downloader_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net/http"
+    "net/http/httptest"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestRobots(t *testing.T) {
+    var fetched int32
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            atomic.AddInt32(&fetched, 1)
+            w.Write([]byte(`# comment
+User-agent: *
+Disallow: /
+
+User-agent: other
+User-agent: go_spider
+Disallow: /private
+Allow: /private/open
+Disallow: /*.pdf$
+Disallow: /search?
+Crawl-delay: 1.5
+`))
+            return
+        }
+        w.Write([]byte("<html><body>ok</body></html>"))
+    }))
+    defer ts.Close()
+
+    robots := downloader.NewRobots("go_spider/1.0")
+    cases := map[string]bool{
+        "/":                  true,
+        "/private":           false,
+        "/private/a":         false,
+        "/private/open/a":    true,
+        "/a.pdf":             false,
+        "/a.pdf?x=1":         true,
+        "/search?q=go":       false,
+        "/search":            true,
+        "/public/index.html": true,
+    }
+    for path, allowed := range cases {
+        if robots.Allowed(ts.URL+path) != allowed {
+            t.Errorf("%s should be allowed %v", path, allowed)
+        }
+    }
+    if robots.CrawlDelay(ts.URL) != 1500*time.Millisecond {
+        t.Error("crawl delay error")
+    }
+    if downloader.NewRobots("").Allowed(ts.URL + "/private/open") {
+        t.Error("group * should disallow everything")
+    }
+    if fetched != 2 {
+        t.Errorf("robots.txt should be fetched once for each Robots: %d", fetched)
+    }
+
+    d := downloader.NewHttpDownloader().SetRobots(robots)
+    if p := d.Download(request.NewRequest(ts.URL+"/private", "html")); p.IsSucc() || p.Errormsg() != downloader.ErrRobotsDisallowed.Error() {
+        t.Error("disallowed url should not be downloaded")
+    }
+    if p := d.Download(request.NewRequest(ts.URL+"/private/open", "html")); !p.IsSucc() {
+        t.Error("allowed url should be downloaded")
+    }
+}
+
+func TestRobotsNotFound(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.NotFound(w, r)
+    }))
+    defer ts.Close()
+
+    if !downloader.NewRobots("go_spider").Allowed(ts.URL + "/private") {
+        t.Error("everything should be allowed without robots.txt")
+    }
+}
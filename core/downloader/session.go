@@ -0,0 +1,137 @@
+package downloader
+
+import (
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/http"
+)
+
+// The Session is isolated client state of one account, like account A and account B logged in the same site.
+// Requests of its key by Request.SetSession are sent with its cookie jar, proxy, User-Agent, header profile
+// and header, instead of those of HttpDownloader, so cookies of one session are never sent in another.
+// Settings of a Session should be done before it is set to HttpDownloader.
+type Session struct {
+    jar           http.CookieJar
+    proxyHost     string
+    userAgent     string
+    headerProfile *HeaderProfile
+    header        http.Header
+}
+
+// NewSession returns Session with an empty CookieJar of its own.
+func NewSession() *Session {
+    return &Session{jar: NewCookieJar(), header: make(http.Header)}
+}
+
+// The SetCookieJar sets cookie jar of the session, like the jar returned by spider.Login of its account,
+// or a CookieJar loaded from its file. Nil jar sends no cookies.
+func (this *Session) SetCookieJar(jar http.CookieJar) *Session {
+    this.jar = jar
+    return this
+}
+
+func (this *Session) GetCookieJar() http.CookieJar {
+    return this.jar
+}
+
+// The SetProxyHost sets proxy of the session, so its account always comes from the same address.
+// Proxy of the request overrides it, and requests of the session without proxy use ProxyPool or proxy of
+// HttpDownloader.
+func (this *Session) SetProxyHost(proxy string) *Session {
+    this.proxyHost = proxy
+    return this
+}
+
+func (this *Session) GetProxyHost() string {
+    return this.proxyHost
+}
+
+// The SetUserAgent sets User-Agent of the session instead of UserAgentPool, like the browser the account
+// logged in by.
+func (this *Session) SetUserAgent(ua string) *Session {
+    this.userAgent = ua
+    return this
+}
+
+func (this *Session) GetUserAgent() string {
+    return this.userAgent
+}
+
+// The SetHeaderProfile sets HeaderProfile of the session instead of the one of HttpDownloader.
+func (this *Session) SetHeaderProfile(profile *HeaderProfile) *Session {
+    this.headerProfile = profile
+    return this
+}
+
+func (this *Session) GetHeaderProfile() *HeaderProfile {
+    return this.headerProfile
+}
+
+// The AddHeader adds header sent in requests of the session, like Authorization of its api token.
+// Header of the request overrides it.
+func (this *Session) AddHeader(key string, value string) *Session {
+    this.header.Add(key, value)
+    return this
+}
+
+func (this *Session) GetHeader() http.Header {
+    return this.header
+}
+
+// The SetSession sets Session of the key. Requests of a key without Session have a new Session of their own,
+// with an empty CookieJar. Nil session removes the key.
+func (this *HttpDownloader) SetSession(key string, s *Session) *HttpDownloader {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if s == nil {
+        delete(this.sessions, key)
+    } else {
+        if this.sessions == nil {
+            this.sessions = make(map[string]*Session)
+        }
+        this.sessions[key] = s
+    }
+    // clients of the session are created again by it
+    this.clients = nil
+    return this
+}
+
+// The GetSession returns Session of the key, or nil if no request of it has been sent and it is not set.
+func (this *HttpDownloader) GetSession(key string) *Session {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.sessions[key]
+}
+
+// The sessionFor returns Session of the request, or nil if it is not in a session.
+func (this *HttpDownloader) sessionFor(req *request.Request) *Session {
+    key := req.GetSession()
+    if key == "" {
+        return nil
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.session(key)
+}
+
+// The session returns Session of the key, creating it if it is not set. It is called with locker held.
+func (this *HttpDownloader) session(key string) *Session {
+    s, ok := this.sessions[key]
+    if !ok {
+        logger.Info("session is created", mlog.F("session", key))
+        s = NewSession()
+        if this.sessions == nil {
+            this.sessions = make(map[string]*Session)
+        }
+        this.sessions[key] = s
+    }
+    return s
+}
+
+// The cookieJarFor returns cookie jar of session of the request, or the cookie jar of HttpDownloader.
+func (this *HttpDownloader) cookieJarFor(req *request.Request) http.CookieJar {
+    if s := this.sessionFor(req); s != nil {
+        return s.GetCookieJar()
+    }
+    return this.GetCookieJar()
+}
@@ -0,0 +1,48 @@
+package downloader
+
+import (
+    "net/http"
+    "syscall"
+)
+
+// SocketOptions are options set on sockets of connections of HttpDownloader, so crawl traffic can be classified
+// and shaped by network devices. Zero options are left as the system sets them. They are supported on linux,
+// and connections fail by them on other systems.
+type SocketOptions struct {
+    // The TOS is IPv4 type of service or IPv6 traffic class, whose upper 6 bits are DSCP, like 8<<2 of DSCP
+    // CS1 for low priority bulk traffic.
+    TOS int
+
+    // The TTL is IPv4 time to live or IPv6 hop limit.
+    TTL int
+
+    // The Mark is SO_MARK of the socket, matched by iptables and policy routing rules. It needs CAP_NET_ADMIN.
+    Mark int
+}
+
+// The SetSocketOptions sets options of sockets of connections, including connections to proxies, but not to
+// socks proxies.
+func (this *HttpDownloader) SetSocketOptions(opts SocketOptions) *HttpDownloader {
+    return this.tuneTransport(func(t *http.Transport) {
+        this.socketOptions = opts
+        t.DialContext = this.dialFunc()
+    })
+}
+
+func (this *HttpDownloader) GetSocketOptions() SocketOptions {
+    return this.socketOptions
+}
+
+// The control returns Control function of net.Dialer setting the options, or nil if they are zero.
+func (this SocketOptions) control() func(network, address string, c syscall.RawConn) error {
+    if this == (SocketOptions{}) {
+        return nil
+    }
+    return func(network, address string, c syscall.RawConn) error {
+        var err error
+        if cerr := c.Control(func(fd uintptr) { err = setSocketOptions(fd, network, this) }); cerr != nil {
+            return cerr
+        }
+        return err
+    }
+}
@@ -0,0 +1,37 @@
+package downloader
+
+import (
+    "os"
+    "strings"
+    "syscall"
+)
+
+// The setSocketOptions sets the options on the socket of the network "tcp4" or "tcp6".
+func setSocketOptions(fd uintptr, network string, opts SocketOptions) error {
+    s := int(fd)
+    ipv6 := strings.HasSuffix(network, "6")
+    if opts.TOS != 0 {
+        level, name := syscall.IPPROTO_IP, syscall.IP_TOS
+        if ipv6 {
+            level, name = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+        }
+        if err := syscall.SetsockoptInt(s, level, name, opts.TOS); err != nil {
+            return os.NewSyscallError("setsockopt tos", err)
+        }
+    }
+    if opts.TTL != 0 {
+        level, name := syscall.IPPROTO_IP, syscall.IP_TTL
+        if ipv6 {
+            level, name = syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS
+        }
+        if err := syscall.SetsockoptInt(s, level, name, opts.TTL); err != nil {
+            return os.NewSyscallError("setsockopt ttl", err)
+        }
+    }
+    if opts.Mark != 0 {
+        if err := syscall.SetsockoptInt(s, syscall.SOL_SOCKET, syscall.SO_MARK, opts.Mark); err != nil {
+            return os.NewSyscallError("setsockopt mark", err)
+        }
+    }
+    return nil
+}
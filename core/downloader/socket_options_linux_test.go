@@ -0,0 +1,49 @@
+package downloader_test
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/http/httptrace"
+    "syscall"
+    "testing"
+)
+
+func TestSocketOptions(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    // options are read from the socket of the connection when it is got
+    getsockopt := func(conn net.Conn, level, name int) int {
+        raw, err := conn.(*net.TCPConn).SyscallConn()
+        if err != nil {
+            t.Fatal(err)
+        }
+        var value int
+        raw.Control(func(fd uintptr) { value, _ = syscall.GetsockoptInt(int(fd), level, name) })
+        return value
+    }
+    var tos, ttl int
+    ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+        GotConn: func(info httptrace.GotConnInfo) {
+            tos = getsockopt(info.Conn, syscall.IPPROTO_IP, syscall.IP_TOS)
+            ttl = getsockopt(info.Conn, syscall.IPPROTO_IP, syscall.IP_TTL)
+        },
+    })
+
+    dl := downloader.NewHttpDownloader().SetSocketOptions(downloader.SocketOptions{TOS: 8 << 2, TTL: 42})
+    p := dl.Download(request.NewRequest(ts.URL, "text").SetContext(ctx))
+    if !p.IsSucc() || tos != 8<<2 || ttl != 42 {
+        t.Errorf("socket options should be set: tos %d ttl %d %s", tos, ttl, p.Errormsg())
+    }
+
+    dl = downloader.NewHttpDownloader().SetSocketOptions(downloader.SocketOptions{Mark: 7})
+    if p := dl.Download(request.NewRequest(ts.URL, "text")); !p.IsSucc() {
+        t.Skip("socket mark can not be set: " + p.Errormsg())
+    }
+}
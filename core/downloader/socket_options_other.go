@@ -0,0 +1,13 @@
+//go:build !linux
+
+package downloader
+
+import (
+    "errors"
+    "runtime"
+)
+
+// The setSocketOptions fails, as socket options are set on linux only.
+func setSocketOptions(fd uintptr, network string, opts SocketOptions) error {
+    return errors.New("socket options are not supported on " + runtime.GOOS)
+}
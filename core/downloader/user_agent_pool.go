@@ -0,0 +1,84 @@
+package downloader
+
+import (
+    "math/rand"
+    "net/url"
+    "sync"
+    "time"
+)
+
+// The DefaultUserAgents are User-Agent headers of common desktop and mobile browsers.
+var DefaultUserAgents = []string{
+    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36",
+    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/139.0.0.0 Safari/537.36",
+    "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36 Edg/140.0.0.0",
+    "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:143.0) Gecko/20100101 Firefox/143.0",
+    "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36",
+    "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.6 Safari/605.1.15",
+    "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:143.0) Gecko/20100101 Firefox/143.0",
+    "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Safari/537.36",
+    "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:143.0) Gecko/20100101 Firefox/143.0",
+    "Mozilla/5.0 (iPhone; CPU iPhone OS 18_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/18.6 Mobile/15E148 Safari/604.1",
+    "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/140.0.0.0 Mobile Safari/537.36",
+}
+
+// The UserAgentPool picks a random User-Agent for each request that has no User-Agent header.
+// If it is sticky, requests of the same host by the same proxy keep the first User-Agent picked for them,
+// like one browser session does.
+type UserAgentPool struct {
+    agents []string
+    sticky bool
+
+    locker   sync.Mutex
+    rand     *rand.Rand
+    sessions map[string]string
+}
+
+// NewUserAgentPool returns UserAgentPool of the agents, or of DefaultUserAgents if agents is empty.
+func NewUserAgentPool(agents []string) *UserAgentPool {
+    if len(agents) == 0 {
+        agents = DefaultUserAgents
+    }
+    return &UserAgentPool{
+        agents:   agents,
+        rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+        sessions: make(map[string]string),
+    }
+}
+
+// The SetSticky sets whether requests of a host by a proxy keep the same User-Agent. Default is false.
+func (this *UserAgentPool) SetSticky(sticky bool) *UserAgentPool {
+    this.sticky = sticky
+    return this
+}
+
+// The Get returns User-Agent for the url downloaded by the proxy, which is empty for no proxy.
+func (this *UserAgentPool) Get(rawurl string, proxyHost string) string {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if !this.sticky {
+        return this.agents[this.rand.Intn(len(this.agents))]
+    }
+    key := userAgentSession(rawurl, proxyHost)
+    agent, ok := this.sessions[key]
+    if !ok {
+        agent = this.agents[this.rand.Intn(len(this.agents))]
+        this.sessions[key] = agent
+    }
+    return agent
+}
+
+// The Forget drops the User-Agent kept for host of the url by the proxy, like after the page is blocked,
+// so a new one is picked for the next request.
+func (this *UserAgentPool) Forget(rawurl string, proxyHost string) {
+    this.locker.Lock()
+    delete(this.sessions, userAgentSession(rawurl, proxyHost))
+    this.locker.Unlock()
+}
+
+func userAgentSession(rawurl string, proxyHost string) string {
+    if u, err := url.Parse(rawurl); err == nil {
+        return proxyHost + " " + u.Host
+    }
+    return proxyHost + " " + rawurl
+}
@@ -0,0 +1,49 @@
+package downloader_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+func TestUserAgentPool(t *testing.T) {
+    var agent string
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        agent = r.Header.Get("User-Agent")
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    agents := []string{"agent-a", "agent-b", "agent-c"}
+    dl := downloader.NewHttpDownloader().SetUserAgentPool(downloader.NewUserAgentPool(agents))
+    seen := make(map[string]bool)
+    for i := 0; i < 50; i++ {
+        dl.Download(request.NewRequest(ts.URL, "text"))
+        seen[agent] = true
+    }
+    if len(seen) != 3 {
+        t.Errorf("user agents should be rotated: %v", seen)
+    }
+    dl.Download(request.NewRequest(ts.URL, "text").SetHeader("User-Agent", "mine"))
+    if agent != "mine" {
+        t.Error("user agent of request should be kept")
+    }
+
+    sticky := downloader.NewUserAgentPool(nil).SetSticky(true)
+    first := sticky.Get("http://a.com/1", "")
+    for i := 0; i < 20; i++ {
+        if sticky.Get("http://a.com/"+string(rune('a'+i)), "") != first {
+            t.Fatal("user agent of host should be sticky")
+        }
+    }
+    changed := false
+    for i := 0; i < 50 && !changed; i++ {
+        sticky.Forget("http://a.com/", "")
+        changed = sticky.Get("http://a.com/", "") != first
+    }
+    if !changed {
+        t.Error("user agent forgotten should be picked again")
+    }
+}
@@ -0,0 +1,110 @@
+package downloader
+
+import (
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/util"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// The ValidatorMap is ValidatorStore that keeps only ETag and Last-Modified of each url in memory,
+// so periodic re-crawls send conditional requests without caching page bodies like FileCache.
+// The page of 304 Not Modified responce has no body; set Spider.SetSkipNotModified to skip processing it.
+// The validators can be kept between runs by WriteFile and ReadFile.
+type ValidatorMap struct {
+    locker     sync.Mutex
+    validators map[string]validators
+}
+
+// The validators are validator headers of a url.
+type validators struct {
+    ETag         string `json:"etag,omitempty"`
+    LastModified string `json:"lastModified,omitempty"`
+}
+
+func NewValidatorMap() *ValidatorMap {
+    return &ValidatorMap{validators: make(map[string]validators)}
+}
+
+// Load returns page with validator headers saved for the url of the request.
+func (this *ValidatorMap) Load(req *request.Request) (*page.Page, bool) {
+    this.locker.Lock()
+    v, ok := this.validators[util.NormalizeUrl(req.GetUrl())]
+    this.locker.Unlock()
+    if !ok {
+        return nil, false
+    }
+    header := make(http.Header)
+    if v.ETag != "" {
+        header.Set("ETag", v.ETag)
+    }
+    if v.LastModified != "" {
+        header.Set("Last-Modified", v.LastModified)
+    }
+    p := page.NewPage(req)
+    p.SetStatusCode(http.StatusOK)
+    p.SetHeader(header)
+    return p, true
+}
+
+// Save saves ETag and Last-Modified header of the page, or removes the url if the page has neither.
+func (this *ValidatorMap) Save(req *request.Request, p *page.Page) {
+    header := http.Header(p.GetHeader())
+    v := validators{ETag: header.Get("ETag"), LastModified: header.Get("Last-Modified")}
+    key := util.NormalizeUrl(req.GetUrl())
+    this.locker.Lock()
+    if v.ETag == "" && v.LastModified == "" {
+        delete(this.validators, key)
+    } else {
+        this.validators[key] = v
+    }
+    this.locker.Unlock()
+}
+
+// The WriteFile writes all the validators to the file.
+func (this *ValidatorMap) WriteFile(file string) error {
+    this.locker.Lock()
+    content, err := json.Marshal(this.validators)
+    this.locker.Unlock()
+    if err != nil {
+        return err
+    }
+
+    // write to temp file first so that a broken file is never read.
+    tmp, err := ioutil.TempFile(filepath.Dir(file), "tmp")
+    if err != nil {
+        return err
+    }
+    _, err = tmp.Write(content)
+    tmp.Close()
+    if err == nil {
+        err = os.Rename(tmp.Name(), file)
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+    }
+    return err
+}
+
+// The ReadFile adds validators in the file written by WriteFile.
+func (this *ValidatorMap) ReadFile(file string) error {
+    content, err := ioutil.ReadFile(file)
+    if err != nil {
+        return err
+    }
+    saved := make(map[string]validators)
+    if err = json.Unmarshal(content, &saved); err != nil {
+        return err
+    }
+    this.locker.Lock()
+    for key, v := range saved {
+        this.validators[key] = v
+    }
+    this.locker.Unlock()
+    return nil
+}
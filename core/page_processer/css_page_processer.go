@@ -0,0 +1,167 @@
+package page_processer
+
+import (
+    "github.com/PuerkitoBio/goquery"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "regexp"
+    "strings"
+)
+
+// The CssPageProcesser extracts fields of html pages by css selectors and follows links matched by css
+// selectors, so simple crawls need no PageProcesser written in Go.
+// A field is the trimmed text of the first matched element, or its attribute if attr is set; attr "html"
+// means the inner html. List fields have values of all the matched elements.
+// Pages without any field found are skipped by pipelines.
+type CssPageProcesser struct {
+    fields   []cssField
+    follows  []*page.LinkExtractor
+    itemUrls []*regexp.Regexp
+}
+
+type cssField struct {
+    name       string
+    selector   string
+    attr       string
+    list       bool
+    transforms TransformChain
+}
+
+// NewCssPageProcesser returns CssPageProcesser without fields and links to follow.
+func NewCssPageProcesser() *CssPageProcesser {
+    return &CssPageProcesser{}
+}
+
+// The AddField adds field of the first element matched by the selector, like "h1" with attr "" for its text.
+func (this *CssPageProcesser) AddField(name, selector, attr string) *CssPageProcesser {
+    this.fields = append(this.fields, cssField{name: name, selector: selector, attr: attr})
+    return this
+}
+
+// The AddListField adds field of all the elements matched by the selector, whose value is []string.
+func (this *CssPageProcesser) AddListField(name, selector, attr string) *CssPageProcesser {
+    this.fields = append(this.fields, cssField{name: name, selector: selector, attr: attr, list: true})
+    return this
+}
+
+// The Transform adds transforms applied in order to values of the field of the name, like Collapse and
+// DateTransform. Values a transform fails on are dropped.
+func (this *CssPageProcesser) Transform(name string, transforms ...Transform) *CssPageProcesser {
+    for i := range this.fields {
+        if this.fields[i].name == name {
+            this.fields[i].transforms = append(this.fields[i].transforms, transforms...)
+        }
+    }
+    return this
+}
+
+// The Follow adds links of elements matched by the selector, like "a.next", as target requests of "html"
+// pages. If allow regexps are set, only links matching one of them are followed.
+// It panics if an expr can not be compiled.
+func (this *CssPageProcesser) Follow(selector string, allow ...string) *CssPageProcesser {
+    this.follows = append(this.follows, page.NewLinkExtractor().SetSelector(selector, "href").Allow(allow...))
+    return this
+}
+
+// The SetItemUrls sets regexps of urls whose pages have fields extracted, like "/item/\d+".
+// Other pages are only followed. Default is all the pages. It panics if an expr can not be compiled.
+func (this *CssPageProcesser) SetItemUrls(exprs ...string) *CssPageProcesser {
+    this.itemUrls = nil
+    for _, expr := range exprs {
+        this.itemUrls = append(this.itemUrls, regexp.MustCompile(expr))
+    }
+    return this
+}
+
+// The GetFields returns names of fields in the order they are added.
+func (this *CssPageProcesser) GetFields() []string {
+    names := make([]string, 0, len(this.fields))
+    for _, f := range this.fields {
+        names = append(names, f.name)
+    }
+    return names
+}
+
+func (this *CssPageProcesser) Process(p *page.Page) {
+    doc := p.GetHtmlParser()
+    if !p.IsSucc() || doc == nil {
+        p.SetSkip(true)
+        return
+    }
+    for _, f := range this.follows {
+        for _, req := range f.ExtractRequests(p, "html") {
+            p.AddTargetRequestWithParams(req)
+        }
+    }
+
+    if !isItemUrl(this.itemUrls, p.GetRequest().GetUrl()) {
+        p.SetSkip(true)
+        return
+    }
+    found := false
+    for _, f := range this.fields {
+        s := doc.Find(f.selector)
+        if s.Length() == 0 {
+            logger.Debug("selector matches nothing", mlog.F("url", p.GetRequest().GetUrl()),
+                mlog.F("field", f.name), mlog.F("selector", f.selector))
+            continue
+        }
+        if !f.list {
+            if value, ok := f.transform(p, cssValue(s.First(), f.attr)); ok {
+                p.AddField(f.name, value)
+                found = true
+            }
+            continue
+        }
+        found = true
+        values := make([]string, 0, s.Length())
+        s.Each(func(i int, e *goquery.Selection) {
+            if value, ok := f.transform(p, cssValue(e, f.attr)); ok {
+                values = append(values, value)
+            }
+        })
+        p.AddValue(f.name, values)
+    }
+    if !found {
+        p.SetSkip(true)
+    }
+}
+
+// The transform applies transforms of the field to the value. It returns false if a transform fails.
+func (this cssField) transform(p *page.Page, s string) (string, bool) {
+    if len(this.transforms) == 0 {
+        return s, true
+    }
+    s, err := this.transforms.Apply(s, p.GetFinalUrl())
+    if err != nil {
+        logger.Debug("transform fails", mlog.F("url", p.GetRequest().GetUrl()), mlog.F("field", this.name),
+            mlog.F("error", err))
+        return "", false
+    }
+    return s, true
+}
+
+// The isItemUrl tests whether the url matches one of the regexps of item urls, or there is no regexp.
+func isItemUrl(itemUrls []*regexp.Regexp, url string) bool {
+    if len(itemUrls) == 0 {
+        return true
+    }
+    for _, reg := range itemUrls {
+        if reg.MatchString(url) {
+            return true
+        }
+    }
+    return false
+}
+
+// The cssValue returns text, inner html or attribute of the element.
+func cssValue(s *goquery.Selection, attr string) string {
+    switch attr {
+    case "":
+        return strings.TrimSpace(s.Text())
+    case "html":
+        html, _ := s.Html()
+        return strings.TrimSpace(html)
+    }
+    return strings.TrimSpace(s.AttrOr(attr, ""))
+}
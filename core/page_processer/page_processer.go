@@ -2,13 +2,74 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-//
 package page_processer
 
 import (
+    "github.com/hu17889/go_spider/core/common/mlog"
     "github.com/hu17889/go_spider/core/common/page"
+    "io"
 )
 
+// The logger writes logs of this package, whose level is set by mlog.SetLevel("page_processer", level).
+var logger = mlog.Component("page_processer")
+
 type PageProcesser interface {
     Process(p *page.Page)
 }
+
+// The PageProcesserFunc is a function used as PageProcesser.
+type PageProcesserFunc func(p *page.Page)
+
+func (this PageProcesserFunc) Process(p *page.Page) {
+    this(p)
+}
+
+// The StreamPageProcesser processes page of "stream" responce type by reading its body while it is
+// downloaded, for documents too large to be buffered, like xml or html exports of hundreds of MB.
+// The body is Page.GetBodyReader changed to utf-8, and Page.HtmlTokenizer and Page.XmlDecoder read it token
+// by token. It is used instead of Process for "stream" pages if PageProcesser of Spider or callback of the
+// request implements it.
+type StreamPageProcesser interface {
+    ProcessStream(p *page.Page, body io.Reader)
+}
+
+// The StreamPageProcesserFunc is a function used as StreamPageProcesser and PageProcesser, whose Process
+// passes body of "stream" pages only.
+type StreamPageProcesserFunc func(p *page.Page, body io.Reader)
+
+func (this StreamPageProcesserFunc) ProcessStream(p *page.Page, body io.Reader) {
+    this(p, body)
+}
+
+func (this StreamPageProcesserFunc) Process(p *page.Page) {
+    if body := p.GetBodyReader(); body != nil {
+        this(p, body)
+    } else {
+        logger.Error("page is not of stream responce type", mlog.F("url", p.GetRequest().GetUrl()))
+    }
+}
+
+// The MessagePageProcesser processes page of "sse" or "websocket" responce type by receiving its messages
+// while the stream is open, like quotes of a live feed. The messages are Page.Messages, which is closed
+// when the stream ends, and Page.Emit outputs items and target requests added so far. It is used instead of
+// Process for these pages if PageProcesser of Spider or callback of the request implements it; other
+// PageProcessers process Page.MessagePage of each message.
+type MessagePageProcesser interface {
+    ProcessMessages(p *page.Page, messages <-chan page.Message)
+}
+
+// The MessagePageProcesserFunc is a function used as MessagePageProcesser and PageProcesser, whose Process
+// passes messages of "sse" and "websocket" pages only.
+type MessagePageProcesserFunc func(p *page.Page, messages <-chan page.Message)
+
+func (this MessagePageProcesserFunc) ProcessMessages(p *page.Page, messages <-chan page.Message) {
+    this(p, messages)
+}
+
+func (this MessagePageProcesserFunc) Process(p *page.Page) {
+    if messages := p.Messages(); messages != nil {
+        this(p, messages)
+    } else {
+        logger.Error("page is not of sse or websocket responce type", mlog.F("url", p.GetRequest().GetUrl()))
+    }
+}
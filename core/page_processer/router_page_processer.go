@@ -0,0 +1,79 @@
+package page_processer
+
+import (
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "regexp"
+)
+
+// The RouterPageProcesser routes each page to one of several PageProcessers, so listing pages, detail pages
+// and api responces of a site are parsed by separate processers instead of one Process with a big switch.
+// A page is routed by tag of its request set by Request.SetTag, or by regexp on url of its request. Routes are
+// checked in the order they are added and the first matched one is used; pages matching no route go to the
+// default processer, or are skipped with an error logged if it is not set.
+type RouterPageProcesser struct {
+    routes []pageRoute
+    def    PageProcesser
+}
+
+type pageRoute struct {
+    tag string
+    url *regexp.Regexp
+    p   PageProcesser
+}
+
+// NewRouterPageProcesser returns RouterPageProcesser without routes.
+func NewRouterPageProcesser() *RouterPageProcesser {
+    return &RouterPageProcesser{}
+}
+
+// The Handle routes pages whose request url matches the regexp, like `/item/\d+`, to p.
+// It panics if expr can not be compiled.
+func (this *RouterPageProcesser) Handle(expr string, p PageProcesser) *RouterPageProcesser {
+    this.routes = append(this.routes, pageRoute{url: regexp.MustCompile(expr), p: p})
+    return this
+}
+
+// The HandleFunc routes pages whose request url matches the regexp to function f.
+func (this *RouterPageProcesser) HandleFunc(expr string, f func(p *page.Page)) *RouterPageProcesser {
+    return this.Handle(expr, PageProcesserFunc(f))
+}
+
+// The HandleTag routes pages whose request has the tag, like "detail", to p.
+func (this *RouterPageProcesser) HandleTag(tag string, p PageProcesser) *RouterPageProcesser {
+    this.routes = append(this.routes, pageRoute{tag: tag, p: p})
+    return this
+}
+
+// The HandleTagFunc routes pages whose request has the tag to function f.
+func (this *RouterPageProcesser) HandleTagFunc(tag string, f func(p *page.Page)) *RouterPageProcesser {
+    return this.HandleTag(tag, PageProcesserFunc(f))
+}
+
+// The SetDefault sets processer of pages matching no route, like seed pages.
+func (this *RouterPageProcesser) SetDefault(p PageProcesser) *RouterPageProcesser {
+    this.def = p
+    return this
+}
+
+// The Route returns processer of the page, or nil if no route matches and default is not set.
+func (this *RouterPageProcesser) Route(p *page.Page) PageProcesser {
+    req := p.GetRequest()
+    for _, r := range this.routes {
+        if r.url == nil && r.tag == req.GetTag() || r.url != nil && r.url.MatchString(req.GetUrl()) {
+            return r.p
+        }
+    }
+    return this.def
+}
+
+func (this *RouterPageProcesser) Process(p *page.Page) {
+    processer := this.Route(p)
+    if processer == nil {
+        logger.Error("page matches no route of processer", mlog.F("url", p.GetRequest().GetUrl()),
+            mlog.F("tag", p.GetRequest().GetTag()))
+        p.SetSkip(true)
+        return
+    }
+    processer.Process(p)
+}
@@ -0,0 +1,277 @@
+package page_processer
+
+import (
+    "errors"
+    "github.com/PuerkitoBio/goquery"
+    "github.com/antchfx/xpath"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "gopkg.in/yaml.v3"
+    "io/ioutil"
+    "regexp"
+)
+
+// The ExtractRules is the extraction spec of RulePageProcesser, read from a YAML or JSON file like:
+//
+//	fields:
+//	    - name: title
+//	      selector: h1
+//	    - name: price
+//	      selector: .price
+//	      transform: [currency]
+//	      required: true
+//	    - name: date
+//	      selector: time
+//	      transform: ["date:Jan 2, 2006@America/New_York"]
+//	    - name: sku
+//	      xpath: //meta[@itemprop='sku']/@content
+//	      regex: 'SKU-(\d+)'
+//	    - name: tags
+//	      selector: .tag
+//	      transform: [lower]
+//	      list: true
+//	follow:
+//	    - selector: a.next
+//	    - selector: .items a
+//	      allow: ["/item/"]
+//	items: ["/item/"]
+type ExtractRules struct {
+    Fields []FieldRule  `yaml:"fields" json:"fields"`
+    Follow []FollowRule `yaml:"follow" json:"follow"`
+    // The Items are regexps of urls whose pages have fields extracted. Default is all the pages.
+    Items []string `yaml:"items" json:"items"`
+}
+
+// The FieldRule extracts a field by one of css selector, XPath expression or json path.
+// Values are taken like Page.Unmarshal: text of elements matched by the selector or their attribute attr,
+// where "html" is inner html, text of nodes matched by the XPath expression, or values at the json path.
+// Then the regex takes its first group, or the whole match if it has no group, and the transforms are applied
+// in order, like "trim", "unescape", "currency" or "date:2006-01-02" (see ParseTransform). Empty values, and
+// values the regex does not match or a transform fails on, are dropped.
+type FieldRule struct {
+    Name      string   `yaml:"name" json:"name"`
+    Selector  string   `yaml:"selector" json:"selector"`
+    Xpath     string   `yaml:"xpath" json:"xpath"`
+    Jsonpath  string   `yaml:"jsonpath" json:"jsonpath"`
+    Attr      string   `yaml:"attr" json:"attr"`
+    Regex     string   `yaml:"regex" json:"regex"`
+    Transform []string `yaml:"transform" json:"transform"`
+    // The List field has all the values as []string, and other fields the first one.
+    List bool `yaml:"list" json:"list"`
+    // The Default is value of the field if no value is found.
+    Default string `yaml:"default" json:"default"`
+    // The Required field skips the page if no value is found, like a price missing on a page of no product.
+    Required bool `yaml:"required" json:"required"`
+}
+
+// The FollowRule follows links of elements matched by css selector, like "a.next", as target requests of
+// "html" pages. If allow regexps are set, only links matching one of them are followed, and links matching
+// deny regexps are not.
+type FollowRule struct {
+    Selector string   `yaml:"selector" json:"selector"`
+    Allow    []string `yaml:"allow" json:"allow"`
+    Deny     []string `yaml:"deny" json:"deny"`
+}
+
+// The RulePageProcesser extracts fields of html and json pages by ExtractRules read at runtime, so scrapers
+// are declared by files instead of Go code, and rules are changed without recompiling, like by
+// Spider.SetPageProcesser of a RulePageProcesser loaded again.
+// Pages without any field found are skipped by pipelines.
+type RulePageProcesser struct {
+    rules    ExtractRules
+    fields   []ruleField
+    follows  []*page.LinkExtractor
+    itemUrls []*regexp.Regexp
+}
+
+type ruleField struct {
+    FieldRule
+    regex      *regexp.Regexp
+    transforms TransformChain
+}
+
+// NewRulePageProcesser returns RulePageProcesser of the rules. It returns error of a field without name or
+// with not exactly one selector, a bad regexp or XPath expression, or an unknown transform.
+func NewRulePageProcesser(rules ExtractRules) (*RulePageProcesser, error) {
+    if len(rules.Fields) == 0 {
+        return nil, errors.New("rules have no fields")
+    }
+    this := &RulePageProcesser{rules: rules}
+    for _, f := range rules.Fields {
+        n := 0
+        for _, s := range []string{f.Selector, f.Xpath, f.Jsonpath} {
+            if s != "" {
+                n++
+            }
+        }
+        if f.Name == "" || n != 1 {
+            return nil, errors.New("field needs name and one of selector, xpath and jsonpath : " + f.Name)
+        }
+        if f.Xpath != "" {
+            if _, err := xpath.Compile(f.Xpath); err != nil {
+                return nil, errors.New("field " + f.Name + " : " + err.Error())
+            }
+        }
+        rf := ruleField{FieldRule: f}
+        var err error
+        if rf.transforms, err = ParseTransforms(f.Transform); err != nil {
+            return nil, errors.New("field " + f.Name + " : " + err.Error())
+        }
+        if f.Regex != "" {
+            if rf.regex, err = regexp.Compile(f.Regex); err != nil {
+                return nil, errors.New("field " + f.Name + " : " + err.Error())
+            }
+        }
+        this.fields = append(this.fields, rf)
+    }
+    for _, expr := range rules.Items {
+        reg, err := regexp.Compile(expr)
+        if err != nil {
+            return nil, err
+        }
+        this.itemUrls = append(this.itemUrls, reg)
+    }
+    for _, f := range rules.Follow {
+        for _, expr := range append(append([]string{}, f.Allow...), f.Deny...) {
+            if _, err := regexp.Compile(expr); err != nil {
+                return nil, err
+            }
+        }
+        this.follows = append(this.follows,
+            page.NewLinkExtractor().SetSelector(f.Selector, "href").Allow(f.Allow...).Deny(f.Deny...))
+    }
+    return this, nil
+}
+
+// LoadRules reads ExtractRules from a YAML or JSON file, and returns its RulePageProcesser.
+func LoadRules(path string) (*RulePageProcesser, error) {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var rules ExtractRules
+    if err = yaml.Unmarshal(content, &rules); err != nil {
+        return nil, errors.New("rules " + path + " : " + err.Error())
+    }
+    p, err := NewRulePageProcesser(rules)
+    if err != nil {
+        return nil, errors.New("rules " + path + " : " + err.Error())
+    }
+    return p, nil
+}
+
+// The GetRules returns the ExtractRules of the RulePageProcesser.
+func (this *RulePageProcesser) GetRules() ExtractRules {
+    return this.rules
+}
+
+// The GetFields returns names of fields in the order of the rules.
+func (this *RulePageProcesser) GetFields() []string {
+    names := make([]string, 0, len(this.fields))
+    for _, f := range this.fields {
+        names = append(names, f.Name)
+    }
+    return names
+}
+
+func (this *RulePageProcesser) Process(p *page.Page) {
+    if !p.IsSucc() {
+        p.SetSkip(true)
+        return
+    }
+    if p.GetHtmlParser() != nil {
+        for _, f := range this.follows {
+            for _, req := range f.ExtractRequests(p, "html") {
+                p.AddTargetRequestWithParams(req)
+            }
+        }
+    }
+
+    if !isItemUrl(this.itemUrls, p.GetRequest().GetUrl()) {
+        p.SetSkip(true)
+        return
+    }
+    values := make(map[string][]string, len(this.fields))
+    for _, f := range this.fields {
+        vs := fieldValues(p, f)
+        if len(vs) == 0 && f.Default != "" {
+            vs = []string{f.Default}
+        }
+        if len(vs) == 0 && f.Required {
+            logger.Debug("required field is not found", mlog.F("url", p.GetRequest().GetUrl()),
+                mlog.F("field", f.Name))
+            p.SetSkip(true)
+            return
+        }
+        values[f.Name] = vs
+    }
+    found := false
+    for _, f := range this.fields {
+        vs := values[f.Name]
+        if len(vs) == 0 {
+            continue
+        }
+        found = true
+        if f.List {
+            p.AddValue(f.Name, vs)
+        } else {
+            p.AddField(f.Name, vs[0])
+        }
+    }
+    if !found {
+        p.SetSkip(true)
+    }
+}
+
+// The fieldValues returns values of the field in the page after its regex and transforms.
+func fieldValues(p *page.Page, f ruleField) []string {
+    var raw []string
+    switch {
+    case f.Jsonpath != "":
+        if v := p.GetJsonPath(f.Jsonpath).Interface(); v != nil {
+            if _, ok := v.([]interface{}); ok {
+                raw = p.GetJsonStrings(f.Jsonpath)
+            } else if s := p.GetJsonString(f.Jsonpath); s != "" {
+                raw = []string{s}
+            }
+        }
+    case f.Xpath != "":
+        if p.GetHtmlParser() != nil {
+            raw, _ = p.GetXpathStrings(f.Xpath)
+        }
+    default:
+        if doc := p.GetHtmlParser(); doc != nil {
+            doc.Find(f.Selector).Each(func(i int, s *goquery.Selection) {
+                raw = append(raw, cssValue(s, f.Attr))
+            })
+        }
+    }
+
+    vs := make([]string, 0, len(raw))
+    for _, s := range raw {
+        if f.regex != nil {
+            m := f.regex.FindStringSubmatch(s)
+            if m == nil {
+                continue
+            }
+            s = m[0]
+            if len(m) > 1 {
+                s = m[1]
+            }
+        }
+        s, err := f.transforms.Apply(s, p.GetFinalUrl())
+        if err != nil {
+            logger.Debug("transform fails", mlog.F("url", p.GetRequest().GetUrl()), mlog.F("field", f.Name),
+                mlog.F("error", err))
+            continue
+        }
+        if s == "" {
+            continue
+        }
+        vs = append(vs, s)
+        if !f.List {
+            break
+        }
+    }
+    return vs
+}
@@ -0,0 +1,176 @@
+package page_processer
+
+import (
+    "errors"
+    "github.com/hu17889/go_spider/core/common/page"
+    "html"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The Transform converts a value extracted from a page, like trimming it or parsing its date. The base is url
+// of the page, which relative links are resolved against. A value it returns error of is dropped.
+type Transform func(s string, base string) (string, error)
+
+// The TransformChain is transforms applied to a value in order.
+type TransformChain []Transform
+
+// The Apply applies the transforms to the value in order, and returns error of the first one failing.
+func (this TransformChain) Apply(s string, base string) (string, error) {
+    for _, t := range this {
+        var err error
+        if s, err = t(s, base); err != nil {
+            return "", err
+        }
+    }
+    return s, nil
+}
+
+// The Trim removes spaces around the value.
+func Trim(s string, base string) (string, error) {
+    return strings.TrimSpace(s), nil
+}
+
+// The Lower converts the value to lower case.
+func Lower(s string, base string) (string, error) {
+    return strings.ToLower(s), nil
+}
+
+// The Upper converts the value to upper case.
+func Upper(s string, base string) (string, error) {
+    return strings.ToUpper(s), nil
+}
+
+// The Collapse trims the value and collapses its spaces and line breaks to one space.
+func Collapse(s string, base string) (string, error) {
+    return strings.Join(strings.Fields(s), " "), nil
+}
+
+// The Unescape decodes html entities of the value, like "&amp;" and "&#39;" in inner html or json of a page.
+func Unescape(s string, base string) (string, error) {
+    return html.UnescapeString(s), nil
+}
+
+// The Number returns the first number in the value without thousands separators, like "1024" of
+// "1,024 reviews".
+func Number(s string, base string) (string, error) {
+    return page.ConvertText(s, "number", base)
+}
+
+// The Currency returns number of the price, like "1299.00" of "$1,299.00" or "1.299,00 €".
+func Currency(s string, base string) (string, error) {
+    return page.ConvertText(s, "currency", base)
+}
+
+// The Url returns absolute url of a link relative to the page.
+func Url(s string, base string) (string, error) {
+    return page.ConvertText(s, "url", base)
+}
+
+// The RegexTransform returns Transform taking the first group of the regexp in the value, or the whole match
+// if it has no group. Values it does not match are dropped. It panics if the expr can not be compiled.
+func RegexTransform(expr string) Transform {
+    reg := regexp.MustCompile(expr)
+    return func(s string, base string) (string, error) {
+        m := reg.FindStringSubmatch(s)
+        if m == nil {
+            return "", errors.New("regexp " + expr + " does not match " + strconv.Quote(s))
+        }
+        if len(m) > 1 {
+            return m[1], nil
+        }
+        return m[0], nil
+    }
+}
+
+// The ReplaceTransform returns Transform replacing matches of the regexp in the value by repl, where "$1" is
+// the first group. It panics if the expr can not be compiled.
+func ReplaceTransform(expr, repl string) Transform {
+    reg := regexp.MustCompile(expr)
+    return func(s string, base string) (string, error) {
+        return reg.ReplaceAllString(s, repl), nil
+    }
+}
+
+// The DateTransform returns Transform parsing the value by the first of the layouts it fits, like
+// "Jan 2, 2006", and formatting it in RFC 3339. Dates without zone are in loc, which is UTC if it is nil.
+func DateTransform(loc *time.Location, layouts ...string) Transform {
+    if loc == nil {
+        loc = time.UTC
+    }
+    return func(s string, base string) (string, error) {
+        s = strings.TrimSpace(s)
+        for _, layout := range layouts {
+            if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+                return t.Format(time.RFC3339), nil
+            }
+        }
+        return "", errors.New("date " + strconv.Quote(s) + " fits no layout")
+    }
+}
+
+var namedTransforms = map[string]Transform{
+    "trim": Trim, "lower": Lower, "upper": Upper, "collapse": Collapse, "unescape": Unescape, "number": Number,
+    "currency": Currency, "url": Url,
+}
+
+// ParseTransform returns Transform of its spec in rules: a name of "trim", "lower", "upper", "collapse",
+// "unescape", "number", "currency" and "url", or a name with argument after ":":
+//
+//	regex:SKU-(\d+)                       the first group of the regexp, see RegexTransform
+//	replace:\s*/\s*:/                     matches of the regexp before the last ":" replaced by the rest
+//	date:Jan 2, 2006|2006-01-02@Asia/Tokyo  layouts separated by "|" and time zone after the last "@"
+//
+// It returns error of an unknown name, a bad regexp or an unknown time zone.
+func ParseTransform(spec string) (Transform, error) {
+    if t, ok := namedTransforms[spec]; ok {
+        return t, nil
+    }
+    i := strings.Index(spec, ":")
+    if i < 0 {
+        return nil, errors.New("transform is not supported : " + spec)
+    }
+    name, arg := spec[:i], spec[i+1:]
+    switch name {
+    case "regex":
+        if _, err := regexp.Compile(arg); err != nil {
+            return nil, err
+        }
+        return RegexTransform(arg), nil
+    case "replace":
+        j := strings.LastIndex(arg, ":")
+        if j < 0 {
+            return nil, errors.New("replace transform needs regexp and replacement : " + spec)
+        }
+        if _, err := regexp.Compile(arg[:j]); err != nil {
+            return nil, err
+        }
+        return ReplaceTransform(arg[:j], arg[j+1:]), nil
+    case "date":
+        loc := time.UTC
+        if j := strings.LastIndex(arg, "@"); j >= 0 {
+            var err error
+            if loc, err = time.LoadLocation(arg[j+1:]); err != nil {
+                return nil, err
+            }
+            arg = arg[:j]
+        }
+        return DateTransform(loc, strings.Split(arg, "|")...), nil
+    }
+    return nil, errors.New("transform is not supported : " + spec)
+}
+
+// ParseTransforms returns TransformChain of the specs of ParseTransform.
+func ParseTransforms(specs []string) (TransformChain, error) {
+    chain := make(TransformChain, 0, len(specs))
+    for _, spec := range specs {
+        t, err := ParseTransform(spec)
+        if err != nil {
+            return nil, err
+        }
+        chain = append(chain, t)
+    }
+    return chain, nil
+}
@@ -2,10 +2,16 @@
 package pipeline
 
 import (
+    "context"
     "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
     "github.com/hu17889/go_spider/core/common/page_items"
 )
 
+// The logger writes logs of this package, whose level is set by mlog.SetLevel("pipeline", level).
+var logger = mlog.Component("pipeline")
+
 // The interface Pipeline can be implemented to customize ways of persistent.
 type Pipeline interface {
     // The Process implements result persistent.
@@ -21,3 +27,27 @@ type CollectPipeline interface {
     // The GetCollected returns result saved in in process's memory temporarily.
     GetCollected() []*page_items.PageItems
 }
+
+// The interface FlushPipeline is Pipeline that buffers results, like batched inserts of database.
+type FlushPipeline interface {
+    Pipeline
+
+    // The Flush writes results buffered. It is called by Spider when Run returns.
+    Flush()
+}
+
+// The interface ContextPipeline is Pipeline that is canceled with the crawl, like writes to remote databases.
+// Spider calls ProcessContext instead of Process, with context of the request that is canceled when Run is stopped.
+type ContextPipeline interface {
+    Pipeline
+
+    ProcessContext(ctx context.Context, items *page_items.PageItems, t com_interfaces.Task)
+}
+
+// The interface PagePipeline is Pipeline that needs the downloaded page, like archiving responce bodies.
+// Spider calls ProcessPage instead of Process, and context of the request is p.Context().
+type PagePipeline interface {
+    Pipeline
+
+    ProcessPage(p *page.Page, t com_interfaces.Task)
+}
@@ -0,0 +1,249 @@
+package pipeline
+
+import (
+    "bytes"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// The batchTimeLayout is layout of start time in names of batches.
+const batchTimeLayout = "20060102T150405.000Z"
+
+// The BatchManifest describes a complete batch of PipelineBatch, so downstream ETL consumes only batches whose
+// manifests are saved, and checks them by count and checksum.
+type BatchManifest struct {
+    // The Name is name of the batch file, like "items-20140901T150405.000Z-000001.jsonl.gz".
+    Name  string `json:"name"`
+    Count int    `json:"count"`
+    // The Size and SHA256 are bytes and hex sha256 of the batch file as it is saved.
+    Size   int    `json:"size"`
+    SHA256 string `json:"sha256"`
+    Gzip   bool   `json:"gzip,omitempty"`
+    // The Start and End are times the first and the last items of the batch are processed.
+    Start    time.Time `json:"start"`
+    End      time.Time `json:"end"`
+    Taskname string    `json:"taskname,omitempty"`
+}
+
+// The BatchSink saves batches of PipelineBatch, like DirBatchSink to a directory or PipelineS3.BatchSink to
+// object storage.
+type BatchSink interface {
+    // The Save saves the batch file of the name and then its manifest of name with ".manifest.json", so a batch
+    // is complete when its manifest is there.
+    Save(name string, batch []byte, manifest []byte) error
+}
+
+// The PipelineBatch accumulates items as json lines with url of the request in key "url", like
+// PipelineJsonLines, and saves them by BatchSink as a batch every SetBatchSize items or SetInterval after the
+// first item of a batch, and by Flush when Run of Spider returns. Each batch is saved with a BatchManifest.
+// A batch failed to be saved is kept and saved again with the next batch.
+type PipelineBatch struct {
+    sink     BatchSink
+    prefix   string
+    size     int
+    interval time.Duration
+    gzip     bool
+
+    locker   sync.Mutex
+    buf      bytes.Buffer
+    count    int
+    start    time.Time
+    end      time.Time
+    taskname string
+    seq      int
+    timer    *time.Timer
+
+    // The saveLocker orders saves of batches, and pending saves batches failed to be saved.
+    saveLocker sync.Mutex
+    pending    []pendingBatch
+}
+
+type pendingBatch struct {
+    name     string
+    batch    []byte
+    manifest []byte
+}
+
+// NewPipelineBatch returns PipelineBatch saving batches by the sink, named with prefix "items". Default batch
+// is 1000 items or 1 minute.
+func NewPipelineBatch(sink BatchSink) *PipelineBatch {
+    return &PipelineBatch{sink: sink, prefix: "items", size: 1000, interval: time.Minute}
+}
+
+// The SetPrefix sets prefix of names of batches, like "news/items".
+func (this *PipelineBatch) SetPrefix(prefix string) *PipelineBatch {
+    this.prefix = prefix
+    return this
+}
+
+// The SetBatchSize sets how many items a batch has at most.
+func (this *PipelineBatch) SetBatchSize(n int) *PipelineBatch {
+    if n < 1 {
+        n = 1
+    }
+    this.size = n
+    return this
+}
+
+// The SetInterval sets how long after its first item a batch is saved even if it is not full. The 0 means
+// batches are saved only when they are full or flushed.
+func (this *PipelineBatch) SetInterval(d time.Duration) *PipelineBatch {
+    this.interval = d
+    return this
+}
+
+// The SetGzip sets whether batches are gzipped. Default is false.
+func (this *PipelineBatch) SetGzip(gzip bool) *PipelineBatch {
+    this.gzip = gzip
+    return this
+}
+
+func (this *PipelineBatch) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    values := items.GetValues()
+    values["url"] = items.GetRequest().GetUrl()
+    line, err := json.Marshal(values)
+    if err != nil {
+        logger.Error("batch pipeline error : " + err.Error())
+        return
+    }
+    now := time.Now().UTC()
+    this.locker.Lock()
+    if this.count == 0 {
+        this.start = now
+        if t != nil {
+            this.taskname = t.Taskname()
+        }
+        if this.interval > 0 {
+            seq := this.seq
+            this.timer = time.AfterFunc(this.interval, func() { this.cut(seq) })
+        }
+    }
+    this.buf.Write(line)
+    this.buf.WriteByte('\n')
+    this.count++
+    this.end = now
+    full := this.count >= this.size
+    seq := this.seq
+    this.locker.Unlock()
+    if full {
+        this.cut(seq)
+    }
+}
+
+// The Flush saves the items accumulated as a batch, and batches failed to be saved before.
+func (this *PipelineBatch) Flush() {
+    this.locker.Lock()
+    seq := this.seq
+    this.locker.Unlock()
+    this.cut(seq)
+}
+
+// The cut saves the batch of the sequence number if it is still accumulated, as a timer of a batch fires after
+// the batch is full.
+func (this *PipelineBatch) cut(seq int) {
+    this.locker.Lock()
+    if seq != this.seq || this.count == 0 {
+        this.locker.Unlock()
+        this.save(nil)
+        return
+    }
+    if this.timer != nil {
+        this.timer.Stop()
+        this.timer = nil
+    }
+    manifest := BatchManifest{Count: this.count, Gzip: this.gzip, Start: this.start, End: this.end,
+        Taskname: this.taskname}
+    batch := append([]byte(nil), this.buf.Bytes()...)
+    this.buf.Reset()
+    this.count = 0
+    this.seq++
+    name := this.prefix + "-" + this.start.Format(batchTimeLayout) + "-" + strconv.Itoa(1000000 + this.seq)[1:] + ".jsonl"
+    this.locker.Unlock()
+
+    if this.gzip {
+        var buf bytes.Buffer
+        zw := gzip.NewWriter(&buf)
+        zw.Write(batch)
+        zw.Close()
+        batch = buf.Bytes()
+        name += ".gz"
+    }
+    sum := sha256.Sum256(batch)
+    manifest.Name, manifest.Size, manifest.SHA256 = filepath.Base(name), len(batch), hex.EncodeToString(sum[:])
+    content, err := json.Marshal(manifest)
+    if err != nil {
+        logger.Error("batch pipeline error : " + err.Error())
+        return
+    }
+    this.save(&pendingBatch{name: name, batch: batch, manifest: content})
+}
+
+// The save saves batches failed to be saved before and the batch.
+func (this *PipelineBatch) save(b *pendingBatch) {
+    this.saveLocker.Lock()
+    defer this.saveLocker.Unlock()
+    if b != nil {
+        this.pending = append(this.pending, *b)
+    }
+    kept := this.pending[:0]
+    for _, p := range this.pending {
+        if err := this.sink.Save(p.name, p.batch, p.manifest); err != nil {
+            logger.Error("batch pipeline saves " + p.name + " later : " + err.Error())
+            kept = append(kept, p)
+        }
+    }
+    this.pending = kept
+}
+
+// The DirBatchSink saves batches as files in a directory. Each file is written to a temporary file, synced,
+// and renamed, so a batch file is complete once it is there, and its manifest is saved after it.
+type DirBatchSink struct {
+    dir string
+}
+
+// NewDirBatchSink returns DirBatchSink of the directory, which is created if needed.
+func NewDirBatchSink(dir string) *DirBatchSink {
+    return &DirBatchSink{dir: dir}
+}
+
+func (this *DirBatchSink) Save(name string, batch []byte, manifest []byte) error {
+    path := filepath.Join(this.dir, filepath.FromSlash(name))
+    if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+        return err
+    }
+    if err := writeFileAtomic(path, batch); err != nil {
+        return err
+    }
+    return writeFileAtomic(path+".manifest.json", manifest)
+}
+
+// The writeFileAtomic writes the file by a temporary file of the same directory renamed to path.
+func writeFileAtomic(path string, data []byte) error {
+    f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+    if err != nil {
+        return err
+    }
+    _, err = f.Write(data)
+    if serr := f.Sync(); err == nil {
+        err = serr
+    }
+    if cerr := f.Close(); err == nil {
+        err = cerr
+    }
+    if err == nil {
+        err = os.Rename(f.Name(), path)
+    }
+    if err != nil {
+        os.Remove(f.Name())
+    }
+    return err
+}
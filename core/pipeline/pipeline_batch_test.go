@@ -0,0 +1,115 @@
+package pipeline_test
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "io/ioutil"
+    "os"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// The flakySink keeps saved batches in memory, and fails while fail is true.
+type flakySink struct {
+    locker    sync.Mutex
+    fail      bool
+    batches   map[string][]byte
+    manifests map[string][]byte
+}
+
+func (this *flakySink) Save(name string, batch []byte, manifest []byte) error {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.fail {
+        return errors.New("unavailable")
+    }
+    this.batches[name] = batch
+    this.manifests[name] = manifest
+    return nil
+}
+
+func TestPipelineBatch(t *testing.T) {
+    dir, err := ioutil.TempDir("", "batch")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    p := pipeline.NewPipelineBatch(pipeline.NewDirBatchSink(dir)).SetBatchSize(2).SetInterval(0)
+    for i := 0; i < 5; i++ {
+        p.Process(testItems(i), nil)
+    }
+    if files := readFiles(t, dir); len(files) != 4 {
+        t.Fatalf("files before flush %v", files)
+    }
+    p.Flush()
+
+    files := readFiles(t, dir)
+    if len(files) != 6 {
+        t.Fatalf("files %v", files)
+    }
+    count := 0
+    for name, content := range files {
+        if !strings.HasSuffix(name, ".manifest.json") {
+            continue
+        }
+        var m pipeline.BatchManifest
+        if err := json.Unmarshal([]byte(content), &m); err != nil {
+            t.Fatal(err)
+        }
+        batch, ok := files[m.Name]
+        if !ok || name != m.Name+".manifest.json" {
+            t.Fatalf("manifest %s of batch %s", name, m.Name)
+        }
+        sum := sha256.Sum256([]byte(batch))
+        if m.SHA256 != hex.EncodeToString(sum[:]) || m.Size != len(batch) {
+            t.Errorf("checksum of %s", m.Name)
+        }
+        if lines := strings.Count(batch, "\n"); lines != m.Count {
+            t.Errorf("%s has %d lines of count %d", m.Name, lines, m.Count)
+        }
+        if m.Start.IsZero() || m.End.Before(m.Start) {
+            t.Errorf("time range of %s %v %v", m.Name, m.Start, m.End)
+        }
+        count += m.Count
+    }
+    if count != 5 {
+        t.Errorf("count %d", count)
+    }
+}
+
+func TestPipelineBatchIntervalAndRetry(t *testing.T) {
+    sink := &flakySink{fail: true, batches: map[string][]byte{}, manifests: map[string][]byte{}}
+    p := pipeline.NewPipelineBatch(sink).SetPrefix("news").SetBatchSize(100).SetInterval(50 * time.Millisecond)
+    p.Process(testItems(1), nil)
+    time.Sleep(200 * time.Millisecond)
+
+    sink.locker.Lock()
+    if len(sink.batches) != 0 {
+        t.Fatalf("batches saved by failed sink %v", sink.batches)
+    }
+    sink.fail = false
+    sink.locker.Unlock()
+
+    p.Process(testItems(2), nil)
+    p.Flush()
+    sink.locker.Lock()
+    defer sink.locker.Unlock()
+    if len(sink.batches) != 2 {
+        t.Fatalf("batches %v", sink.batches)
+    }
+    for name, batch := range sink.batches {
+        if !strings.HasPrefix(name, "news-") || !strings.HasSuffix(name, ".jsonl") {
+            t.Errorf("name %s", name)
+        }
+        var values map[string]interface{}
+        if err := json.Unmarshal(batch, &values); err != nil || values["url"] == nil {
+            t.Errorf("batch %s : %s", name, batch)
+        }
+    }
+}
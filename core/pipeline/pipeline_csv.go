@@ -0,0 +1,107 @@
+package pipeline
+
+import (
+    "bytes"
+    "encoding/csv"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "sort"
+    "time"
+)
+
+// The PipelineCsv writes each PageItems as a csv record of url of the request and its items, for spreadsheets
+// and data tools. Columns are the fields of NewPipelineCsv, or keys of the first PageItems sorted if no field
+// is set, and keys not in the columns are not written. Items that are not strings are written as json.
+// Each file starts with the header "url" and the columns. The file is rotated by SetRotateSize or
+// SetRotateInterval. Records are buffered and written by Flush when Run of Spider returns.
+type PipelineCsv struct {
+    rotateFile
+
+    fields []string
+    buf    bytes.Buffer
+    w      *csv.Writer
+}
+
+// NewPipelineCsv returns PipelineCsv that appends to the file of path like "data/items.csv".
+func NewPipelineCsv(path string, fields ...string) *PipelineCsv {
+    this := &PipelineCsv{rotateFile: rotateFile{path: path}, fields: fields}
+    this.w = csv.NewWriter(&this.buf)
+    this.onCreate = this.writeHeader
+    return this
+}
+
+// The SetRotateSize rotates the file before it grows larger than n bytes. Default 0 is no limit.
+func (this *PipelineCsv) SetRotateSize(n int64) *PipelineCsv {
+    this.maxSize = n
+    return this
+}
+
+// The SetRotateInterval rotates the file when it is older than d, like time.Hour. Default 0 is no limit.
+func (this *PipelineCsv) SetRotateInterval(d time.Duration) *PipelineCsv {
+    this.interval = d
+    return this
+}
+
+// The SetGzip sets whether rotated files are gzipped. Default is false.
+func (this *PipelineCsv) SetGzip(gzip bool) *PipelineCsv {
+    this.gzip = gzip
+    return this
+}
+
+// The GetFields returns the columns after url, which are empty until the first PageItems if they are inferred.
+func (this *PipelineCsv) GetFields() []string {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return append([]string(nil), this.fields...)
+}
+
+func (this *PipelineCsv) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if len(this.fields) == 0 {
+        for key := range items.GetAll() {
+            this.fields = append(this.fields, key)
+        }
+        sort.Strings(this.fields)
+    }
+    record := make([]string, 0, len(this.fields)+1)
+    record = append(record, items.GetRequest().GetUrl())
+    for _, field := range this.fields {
+        value, _ := items.GetItem(field)
+        record = append(record, value)
+    }
+    if err := this.write(this.encode(record)); err != nil {
+        logger.Error("csv pipeline error : " + err.Error())
+    }
+}
+
+// The encode returns the csv line of the record. It is called with locker held.
+func (this *PipelineCsv) encode(record []string) []byte {
+    this.buf.Reset()
+    this.w.Write(record)
+    this.w.Flush()
+    return append([]byte(nil), this.buf.Bytes()...)
+}
+
+// The writeHeader writes header to the new file. It is called with locker held.
+func (this *PipelineCsv) writeHeader() {
+    header := this.encode(append([]string{"url"}, this.fields...))
+    n, _ := this.rotateFile.w.Write(header)
+    this.size += int64(n)
+}
+
+// The Flush writes records buffered to the file.
+func (this *PipelineCsv) Flush() {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if err := this.flush(); err != nil {
+        logger.Error("csv pipeline error : " + err.Error())
+    }
+}
+
+// The Close writes records buffered and closes the file. The file is opened again by Process.
+func (this *PipelineCsv) Close() error {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.close()
+}
@@ -0,0 +1,212 @@
+package pipeline
+
+import (
+    "bytes"
+    "crypto/md5"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "net/http"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The esDateReg matches date layout in index template, like "{2006.01.02}".
+var esDateReg = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// The PipelineElasticsearch indexes results into Elasticsearch by bulk api.
+// Each PageItems is a document of its items and field "url" of the request, whose id is md5 of the url,
+// so a page crawled again replaces its document.
+// Documents are sent in batches; a worker filling a batch waits while the previous batch is sent, so
+// crawling slows down when Elasticsearch is slow. Batches failed by network error, 429 or 5xx, and documents
+// rejected with 429, are retried with exponential backoff. Documents left are sent by Flush when Run returns.
+type PipelineElasticsearch struct {
+    url    string
+    index  string
+    client *http.Client
+
+    user     string
+    password string
+
+    batchSize int
+    retries   int
+    backoff   time.Duration
+
+    locker sync.Mutex
+    docs   []esDoc
+
+    // The sendLocker makes batches sent one by one.
+    sendLocker sync.Mutex
+}
+
+// The esDoc is a document with its bulk action line.
+type esDoc struct {
+    action []byte
+    source []byte
+}
+
+// The esBulkResponce is the responce of bulk api.
+type esBulkResponce struct {
+    Errors bool `json:"errors"`
+    Items  []map[string]struct {
+        Status int             `json:"status"`
+        Error  json.RawMessage `json:"error"`
+    } `json:"items"`
+}
+
+// NewPipelineElasticsearch returns PipelineElasticsearch of Elasticsearch at url like "http://127.0.0.1:9200".
+// The index is name of index, which can have date layout of package time in braces, like "crawl-{2006.01.02}"
+// for daily indices. Default batch size is 500, and failed batches are retried 3 times from 1 second.
+func NewPipelineElasticsearch(url string, index string) *PipelineElasticsearch {
+    return &PipelineElasticsearch{
+        url:       strings.TrimRight(url, "/"),
+        index:     index,
+        client:    &http.Client{Timeout: time.Minute},
+        batchSize: 500,
+        retries:   3,
+        backoff:   time.Second,
+    }
+}
+
+// The SetBasicAuth sets user and password of Elasticsearch.
+func (this *PipelineElasticsearch) SetBasicAuth(user, password string) *PipelineElasticsearch {
+    this.user = user
+    this.password = password
+    return this
+}
+
+// The SetBatchSize sets how many documents are sent by one bulk request.
+func (this *PipelineElasticsearch) SetBatchSize(n int) *PipelineElasticsearch {
+    if n < 1 {
+        n = 1
+    }
+    this.batchSize = n
+    return this
+}
+
+// The SetRetry sets how many times a failed batch is retried, and the wait before the first retry,
+// which is doubled for each retry.
+func (this *PipelineElasticsearch) SetRetry(retries int, backoff time.Duration) *PipelineElasticsearch {
+    this.retries = retries
+    this.backoff = backoff
+    return this
+}
+
+// The indexName returns the index name of the template at time t.
+func (this *PipelineElasticsearch) indexName(t time.Time) string {
+    return esDateReg.ReplaceAllStringFunc(this.index, func(layout string) string {
+        return t.Format(layout[1 : len(layout)-1])
+    })
+}
+
+func (this *PipelineElasticsearch) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    url := items.GetRequest().GetUrl()
+    source := items.GetValues()
+    source["url"] = url
+    sum := md5.Sum([]byte(url))
+    action := map[string]map[string]string{"index": {"_index": this.indexName(time.Now()), "_id": hex.EncodeToString(sum[:])}}
+    doc := esDoc{}
+    var err error
+    if doc.action, err = json.Marshal(action); err == nil {
+        doc.source, err = json.Marshal(source)
+    }
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+
+    this.locker.Lock()
+    this.docs = append(this.docs, doc)
+    var batch []esDoc
+    if len(this.docs) >= this.batchSize {
+        batch = this.docs
+        this.docs = nil
+    }
+    this.locker.Unlock()
+    if batch != nil {
+        this.send(batch)
+    }
+}
+
+// The Flush sends documents left in the batch.
+func (this *PipelineElasticsearch) Flush() {
+    this.locker.Lock()
+    batch := this.docs
+    this.docs = nil
+    this.locker.Unlock()
+    if len(batch) > 0 {
+        this.send(batch)
+    }
+}
+
+// The send sends the batch and retries failed documents. Documents still failed are logged and dropped.
+func (this *PipelineElasticsearch) send(batch []esDoc) {
+    this.sendLocker.Lock()
+    defer this.sendLocker.Unlock()
+    backoff := this.backoff
+    for i := 0; ; i++ {
+        var err error
+        if batch, err = this.bulk(batch); len(batch) == 0 {
+            return
+        }
+        if i >= this.retries {
+            logger.Error("elasticsearch pipeline drops " + strconv.Itoa(len(batch)) + " documents : " + err.Error())
+            return
+        }
+        logger.Warn("elasticsearch pipeline retries " + strconv.Itoa(len(batch)) + " documents : " + err.Error())
+        time.Sleep(backoff)
+        backoff *= 2
+    }
+}
+
+// The bulk sends documents by bulk api, and returns documents to be retried with the error.
+func (this *PipelineElasticsearch) bulk(docs []esDoc) ([]esDoc, error) {
+    var body bytes.Buffer
+    for _, doc := range docs {
+        body.Write(doc.action)
+        body.WriteByte('\n')
+        body.Write(doc.source)
+        body.WriteByte('\n')
+    }
+    req, err := http.NewRequest("POST", this.url+"/_bulk", &body)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/x-ndjson")
+    if this.user != "" {
+        req.SetBasicAuth(this.user, this.password)
+    }
+    resp, err := this.client.Do(req)
+    if err != nil {
+        return docs, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+        return docs, errors.New("http status " + strconv.Itoa(resp.StatusCode))
+    }
+    if resp.StatusCode != http.StatusOK {
+        logger.Error("elasticsearch pipeline drops " + strconv.Itoa(len(docs)) + " documents : http status " + strconv.Itoa(resp.StatusCode))
+        return nil, nil
+    }
+
+    var result esBulkResponce
+    if err = json.NewDecoder(resp.Body).Decode(&result); err != nil || !result.Errors {
+        return nil, nil
+    }
+    var retry []esDoc
+    for i, item := range result.Items {
+        for _, r := range item {
+            if r.Status == http.StatusTooManyRequests && i < len(docs) {
+                retry = append(retry, docs[i])
+            } else if r.Status >= 300 {
+                logger.Error("elasticsearch pipeline document error : " + string(r.Error))
+            }
+        }
+    }
+    return retry, errors.New("documents are rejected with http status 429")
+}
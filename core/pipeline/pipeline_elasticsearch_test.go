@@ -0,0 +1,68 @@
+package pipeline_test
+
+import (
+    "bufio"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestPipelineElasticsearch(t *testing.T) {
+    var locker sync.Mutex
+    var bulks [][]string
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/_bulk" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+            http.NotFound(w, r)
+            return
+        }
+        var lines []string
+        scanner := bufio.NewScanner(r.Body)
+        for scanner.Scan() {
+            lines = append(lines, scanner.Text())
+        }
+        locker.Lock()
+        bulks = append(bulks, lines)
+        n := len(bulks)
+        locker.Unlock()
+        switch n {
+        case 1:
+            w.WriteHeader(http.StatusServiceUnavailable)
+        case 2:
+            // the second document is rejected for too many requests
+            w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},{"index":{"status":429,"error":{}}}]}`))
+        default:
+            w.Write([]byte(`{"errors":false,"items":[]}`))
+        }
+    }))
+    defer ts.Close()
+
+    pip := pipeline.NewPipelineElasticsearch(ts.URL, "crawl-{2006}").SetBatchSize(2).SetRetry(3, time.Millisecond)
+    for _, title := range []string{"a", "b", "c"} {
+        items := page_items.NewPageItems(request.NewRequest("http://a.com/"+title, "html"))
+        items.AddItem("title", title)
+        pip.Process(items, nil)
+    }
+    if len(bulks) != 3 || len(bulks[0]) != 4 || len(bulks[2]) != 2 {
+        t.Fatalf("batch should be retried: %v", bulks)
+    }
+    var action map[string]map[string]string
+    json.Unmarshal([]byte(bulks[2][0]), &action)
+    if action["index"]["_index"] != "crawl-"+time.Now().Format("2006") || len(action["index"]["_id"]) != 32 {
+        t.Errorf("action error: %s", bulks[2][0])
+    }
+    if !strings.Contains(bulks[2][1], `"title":"b"`) || !strings.Contains(bulks[2][1], `"url":"http://a.com/b"`) {
+        t.Errorf("rejected document should be retried: %s", bulks[2][1])
+    }
+
+    pip.Flush()
+    if len(bulks) != 4 || !strings.Contains(bulks[3][1], `"title":"c"`) {
+        t.Error("flush error")
+    }
+}
@@ -0,0 +1,69 @@
+package pipeline
+
+import (
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "time"
+)
+
+// The PipelineJsonLines writes each PageItems as a json object in a line of the file, with url of the request
+// in key "url", for tools like jq, pandas or BigQuery. The file is rotated by SetRotateSize or SetRotateInterval.
+// Lines are buffered and written by Flush when Run of Spider returns, or when the file is rotated.
+type PipelineJsonLines struct {
+    rotateFile
+}
+
+// NewPipelineJsonLines returns PipelineJsonLines that appends to the file of path like "data/items.jsonl".
+func NewPipelineJsonLines(path string) *PipelineJsonLines {
+    return &PipelineJsonLines{rotateFile: rotateFile{path: path}}
+}
+
+// The SetRotateSize rotates the file before it grows larger than n bytes. Default 0 is no limit.
+func (this *PipelineJsonLines) SetRotateSize(n int64) *PipelineJsonLines {
+    this.maxSize = n
+    return this
+}
+
+// The SetRotateInterval rotates the file when it is older than d, like time.Hour. Default 0 is no limit.
+func (this *PipelineJsonLines) SetRotateInterval(d time.Duration) *PipelineJsonLines {
+    this.interval = d
+    return this
+}
+
+// The SetGzip sets whether rotated files are gzipped. Default is false.
+func (this *PipelineJsonLines) SetGzip(gzip bool) *PipelineJsonLines {
+    this.gzip = gzip
+    return this
+}
+
+func (this *PipelineJsonLines) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    values := items.GetValues()
+    values["url"] = items.GetRequest().GetUrl()
+    line, err := json.Marshal(values)
+    if err != nil {
+        logger.Error("json lines pipeline error : " + err.Error())
+        return
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if err := this.write(append(line, '\n')); err != nil {
+        logger.Error("json lines pipeline error : " + err.Error())
+    }
+}
+
+// The Flush writes lines buffered to the file.
+func (this *PipelineJsonLines) Flush() {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if err := this.flush(); err != nil {
+        logger.Error("json lines pipeline error : " + err.Error())
+    }
+}
+
+// The Close writes lines buffered and closes the file. The file is opened again by Process.
+func (this *PipelineJsonLines) Close() error {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.close()
+}
@@ -0,0 +1,42 @@
+package pipeline
+
+import (
+    "context"
+    "github.com/segmentio/kafka-go"
+    "time"
+)
+
+// The KafkaPublisher publishes messages to a topic of Kafka. Messages of the same key go to the same partition.
+// Each message is written synchronously and acknowledged by all in-sync replicas, so PipelineQueue knows
+// whether it is delivered.
+type KafkaPublisher struct {
+    writer *kafka.Writer
+}
+
+// NewKafkaPublisher returns KafkaPublisher of brokers like "127.0.0.1:9092" and the topic.
+func NewKafkaPublisher(brokers []string, topic string) *KafkaPublisher {
+    return &KafkaPublisher{writer: &kafka.Writer{
+        Addr:         kafka.TCP(brokers...),
+        Topic:        topic,
+        Balancer:     &kafka.Hash{},
+        RequiredAcks: kafka.RequireAll,
+        // retries are done by PipelineQueue
+        MaxAttempts:  1,
+        BatchTimeout: time.Millisecond,
+        WriteTimeout: 30 * time.Second,
+    }}
+}
+
+// The GetWriter returns kafka-go writer of the publisher, for setting like Compression or Transport of tls and sasl.
+func (this *KafkaPublisher) GetWriter() *kafka.Writer {
+    return this.writer
+}
+
+func (this *KafkaPublisher) Publish(key []byte, message []byte) error {
+    return this.writer.WriteMessages(context.Background(), kafka.Message{Key: key, Value: message})
+}
+
+// The Close closes connections to Kafka after the spider stops.
+func (this *KafkaPublisher) Close() error {
+    return this.writer.Close()
+}
@@ -0,0 +1,175 @@
+package pipeline
+
+import (
+    "context"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/util"
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The MongoCollection writes models to a MongoDB collection in bulk. It is implemented by *mongo.Collection.
+type MongoCollection interface {
+    BulkWrite(ctx context.Context, models []mongo.WriteModel,
+        opts ...options.Lister[options.BulkWriteOptions]) (*mongo.BulkWriteResult, error)
+}
+
+// The PipelineMongo upserts each PageItems as a document of a MongoDB collection, keyed by canonical url of
+// the request in field "url", or by an item set by SetKey, so re-crawled pages update their documents instead
+// of duplicating them. Documents have the items, "url", "taskname", "crawled_at" of the last crawl and
+// "created_at" of the first one. Structured items are saved as sub-documents and arrays; "." and leading "$"
+// of keys, which MongoDB does not allow, are replaced by "_".
+// Documents are written by unordered bulk writes of batches, and documents left are written by Flush when
+// Run of Spider returns. An index on the key field is recommended, like a unique index on "url".
+type PipelineMongo struct {
+    coll      MongoCollection
+    key       string
+    batchSize int
+    timeout   time.Duration
+
+    locker sync.Mutex
+    // The docs are documents of the batch by key, and keys keeps their order.
+    docs map[string]bson.M
+    keys []string
+}
+
+// NewPipelineMongo returns PipelineMongo of the collection, like client.Database("crawl").Collection("pages").
+// Default batch size is 100 and default timeout of a bulk write is 30 seconds.
+func NewPipelineMongo(coll MongoCollection) *PipelineMongo {
+    return &PipelineMongo{coll: coll, key: "url", batchSize: 100, timeout: 30 * time.Second, docs: make(map[string]bson.M)}
+}
+
+// The SetKey sets the item that identifies a document instead of the url, like "sku" of products found on
+// several pages. PageItems without the item are dropped.
+func (this *PipelineMongo) SetKey(key string) *PipelineMongo {
+    this.key = mongoKey(key)
+    return this
+}
+
+// The SetBatchSize sets how many documents are written by one bulk write. The n 1 writes each document at once.
+func (this *PipelineMongo) SetBatchSize(n int) *PipelineMongo {
+    if n < 1 {
+        n = 1
+    }
+    this.batchSize = n
+    return this
+}
+
+// The SetTimeout sets timeout of a bulk write.
+func (this *PipelineMongo) SetTimeout(d time.Duration) *PipelineMongo {
+    this.timeout = d
+    return this
+}
+
+func (this *PipelineMongo) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    doc := mongoDocument(items.GetValues())
+    doc["url"] = util.CanonicalizeUrl(items.GetRequest().GetUrl())
+    if t != nil {
+        doc["taskname"] = t.Taskname()
+    }
+    key, ok := doc[this.key]
+    if !ok {
+        logger.Error("mongo pipeline drops items without key " + this.key + " : " + items.GetRequest().GetUrl())
+        return
+    }
+
+    this.locker.Lock()
+    // the last crawl of a key in the batch wins, so one upsert of the key is written
+    id := bsonKey(key)
+    if _, ok := this.docs[id]; !ok {
+        this.keys = append(this.keys, id)
+    }
+    this.docs[id] = doc
+    var batch []bson.M
+    if len(this.keys) >= this.batchSize {
+        batch = this.take()
+    }
+    this.locker.Unlock()
+    if batch != nil {
+        this.write(batch)
+    }
+}
+
+// The take returns documents of the batch in order and empties it. It is called with locker held.
+func (this *PipelineMongo) take() []bson.M {
+    batch := make([]bson.M, 0, len(this.keys))
+    for _, id := range this.keys {
+        batch = append(batch, this.docs[id])
+    }
+    this.docs = make(map[string]bson.M)
+    this.keys = nil
+    return batch
+}
+
+// The Flush writes documents left in the batch.
+func (this *PipelineMongo) Flush() {
+    this.locker.Lock()
+    batch := this.take()
+    this.locker.Unlock()
+    if len(batch) > 0 {
+        this.write(batch)
+    }
+}
+
+// The write upserts the documents by one bulk write. Errors are logged and the documents are dropped.
+func (this *PipelineMongo) write(docs []bson.M) {
+    now := time.Now()
+    models := make([]mongo.WriteModel, 0, len(docs))
+    for _, doc := range docs {
+        doc["crawled_at"] = now
+        models = append(models, mongo.NewUpdateOneModel().
+            SetFilter(bson.M{this.key: doc[this.key]}).
+            SetUpdate(bson.M{"$set": doc, "$setOnInsert": bson.M{"created_at": now}}).
+            SetUpsert(true))
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), this.timeout)
+    defer cancel()
+    if _, err := this.coll.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+        logger.Error("mongo pipeline error : " + err.Error())
+    }
+}
+
+// The bsonKey returns string of the key value for finding documents of the same key in a batch.
+func bsonKey(key interface{}) string {
+    if s, ok := key.(string); ok {
+        return s
+    }
+    return fmt.Sprintf("%v", key)
+}
+
+// The mongoDocument converts values to a document, and replaces "." and leading "$" of keys by "_".
+func mongoDocument(values map[string]interface{}) bson.M {
+    doc := make(bson.M, len(values))
+    for key, value := range values {
+        doc[mongoKey(key)] = mongoValue(value)
+    }
+    return doc
+}
+
+func mongoValue(value interface{}) interface{} {
+    switch v := value.(type) {
+    case map[string]interface{}:
+        return mongoDocument(v)
+    case []interface{}:
+        values := make(bson.A, len(v))
+        for i, e := range v {
+            values[i] = mongoValue(e)
+        }
+        return values
+    }
+    return value
+}
+
+func mongoKey(key string) string {
+    key = strings.Replace(key, ".", "_", -1)
+    if strings.HasPrefix(key, "$") {
+        key = "_" + key[1:]
+    }
+    return key
+}
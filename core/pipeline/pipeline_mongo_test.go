@@ -0,0 +1,65 @@
+package pipeline_test
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "go.mongodb.org/mongo-driver/v2/bson"
+    "go.mongodb.org/mongo-driver/v2/mongo"
+    "go.mongodb.org/mongo-driver/v2/mongo/options"
+    "testing"
+)
+
+// The mongoCollection saves models of bulk writes.
+type mongoCollection struct {
+    writes [][]mongo.WriteModel
+}
+
+func (this *mongoCollection) BulkWrite(ctx context.Context, models []mongo.WriteModel,
+    opts ...options.Lister[options.BulkWriteOptions]) (*mongo.BulkWriteResult, error) {
+    this.writes = append(this.writes, models)
+    return &mongo.BulkWriteResult{}, nil
+}
+
+func TestPipelineMongo(t *testing.T) {
+    coll := &mongoCollection{}
+    pip := pipeline.NewPipelineMongo(coll).SetBatchSize(2)
+    for _, u := range []string{"HTTP://A.com/1#top", "http://a.com/1", "http://a.com/2", "http://a.com/3"} {
+        items := page_items.NewPageItems(request.NewRequest(u, "html"))
+        items.AddItem("title", u)
+        items.SetValue("spec.size", map[string]interface{}{"$unit": "cm", "list": []interface{}{1, 2}})
+        pip.Process(items, task("mongo"))
+    }
+    if len(coll.writes) != 1 || len(coll.writes[0]) != 2 {
+        t.Fatalf("same url in a batch should be upserted once: %v", coll.writes)
+    }
+    pip.Flush()
+    if len(coll.writes) != 2 || len(coll.writes[1]) != 1 {
+        t.Fatalf("documents left should be flushed: %v", coll.writes)
+    }
+
+    model := coll.writes[0][0].(*mongo.UpdateOneModel)
+    update := model.Update.(bson.M)
+    doc := update["$set"].(bson.M)
+    if filter := model.Filter.(bson.M); filter["url"] != "http://a.com/1" || !*model.Upsert {
+        t.Errorf("document should be upserted by canonical url: %v", filter)
+    }
+    if doc["title"] != "http://a.com/1" || doc["taskname"] != "mongo" || doc["crawled_at"] == nil {
+        t.Errorf("wrong document: %v", doc)
+    }
+    spec, ok := doc["spec_size"].(bson.M)
+    if !ok || spec["_unit"] != "cm" || len(spec["list"].(bson.A)) != 2 {
+        t.Errorf("keys should be converted: %v", doc)
+    }
+    if _, ok := update["$setOnInsert"].(bson.M)["created_at"]; !ok {
+        t.Error("created_at should be set on insert")
+    }
+
+    pip.SetKey("sku")
+    pip.Process(page_items.NewPageItems(request.NewRequest("http://a.com/4", "html")), task("mongo"))
+    pip.Flush()
+    if len(coll.writes) != 2 {
+        t.Error("items without key should be dropped")
+    }
+}
@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The Publisher publishes messages to a topic of a message queue, like Kafka or NSQ.
+// The key decides the partition of the message if the queue has partitions.
+type Publisher interface {
+    Publish(key []byte, message []byte) error
+}
+
+// The QueueMessage is the json message of a PageItems published by PipelineQueue.
+type QueueMessage struct {
+    Url      string                 `json:"url"`
+    Taskname string                 `json:"taskname"`
+    Time     time.Time              `json:"time"`
+    Items    map[string]interface{} `json:"items"`
+}
+
+// The PipelineQueue publishes each PageItems as a json QueueMessage, so that results are processed by
+// other services consuming the topic. The key of message is host of the url, so results of a host
+// go to the same partition of Kafka and keep their order.
+// A message failed to be published is retried with exponential backoff, and then it is passed to
+// the failure handler, which logs it by default.
+type PipelineQueue struct {
+    publisher Publisher
+
+    retries int
+    backoff time.Duration
+    onFail  func(message []byte, err error)
+}
+
+// NewPipelineQueue returns PipelineQueue of the publisher, like NewKafkaPublisher or NewNsqPublisher.
+// Failed messages are retried 3 times from 1 second by default.
+func NewPipelineQueue(publisher Publisher) *PipelineQueue {
+    return &PipelineQueue{
+        publisher: publisher,
+        retries:   3,
+        backoff:   time.Second,
+        onFail: func(message []byte, err error) {
+            logger.Error("queue pipeline drops message " + string(message) + " : " + err.Error())
+        },
+    }
+}
+
+// The SetRetry sets how many times a failed message is published again, and the wait before the first retry,
+// which is doubled for each retry.
+func (this *PipelineQueue) SetRetry(retries int, backoff time.Duration) *PipelineQueue {
+    this.retries = retries
+    this.backoff = backoff
+    return this
+}
+
+// The SetFailureHandler sets function called with the message that is not published after retries,
+// like one saving it to a file for publishing later.
+func (this *PipelineQueue) SetFailureHandler(f func(message []byte, err error)) *PipelineQueue {
+    this.onFail = f
+    return this
+}
+
+func (this *PipelineQueue) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    msg := &QueueMessage{Url: items.GetRequest().GetUrl(), Time: time.Now(), Items: items.GetValues()}
+    if t != nil {
+        msg.Taskname = t.Taskname()
+    }
+    message, err := json.Marshal(msg)
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    var key []byte
+    if u, err := url.Parse(msg.Url); err == nil {
+        key = []byte(u.Host)
+    }
+
+    backoff := this.backoff
+    for i := 0; ; i++ {
+        if err = this.publisher.Publish(key, message); err == nil {
+            return
+        }
+        if i >= this.retries {
+            break
+        }
+        logger.Warn("queue pipeline retries message : " + err.Error())
+        time.Sleep(backoff)
+        backoff *= 2
+    }
+    this.onFail(message, err)
+}
+
+// The NsqPublisher publishes messages to a topic of nsqd by its http api.
+// NSQ has no partitions, so keys are ignored.
+type NsqPublisher struct {
+    url    string
+    client *http.Client
+}
+
+// NewNsqPublisher returns NsqPublisher of nsqd http address like "127.0.0.1:4151" and the topic.
+func NewNsqPublisher(addr string, topic string) *NsqPublisher {
+    if !strings.Contains(addr, "://") {
+        addr = "http://" + addr
+    }
+    return &NsqPublisher{
+        url:    strings.TrimRight(addr, "/") + "/pub?topic=" + url.QueryEscape(topic),
+        client: &http.Client{Timeout: 30 * time.Second},
+    }
+}
+
+func (this *NsqPublisher) Publish(key []byte, message []byte) error {
+    resp, err := this.client.Post(this.url, "application/octet-stream", bytes.NewReader(message))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+        return errors.New("nsq http status " + strconv.Itoa(resp.StatusCode) + " : " + strings.TrimSpace(string(body)))
+    }
+    return nil
+}
@@ -0,0 +1,93 @@
+package pipeline_test
+
+import (
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+type task string
+
+func (this task) Taskname() string {
+    return string(this)
+}
+
+// The flakyPublisher fails the first failures messages.
+type flakyPublisher struct {
+    failures int
+    keys     []string
+    messages [][]byte
+}
+
+func (this *flakyPublisher) Publish(key []byte, message []byte) error {
+    if this.failures > 0 {
+        this.failures--
+        return errors.New("broker is down")
+    }
+    this.keys = append(this.keys, string(key))
+    this.messages = append(this.messages, message)
+    return nil
+}
+
+func TestPipelineQueue(t *testing.T) {
+    publisher := &flakyPublisher{failures: 2}
+    var failed [][]byte
+    pip := pipeline.NewPipelineQueue(publisher).SetRetry(2, time.Millisecond).
+        SetFailureHandler(func(message []byte, err error) { failed = append(failed, message) })
+
+    items := page_items.NewPageItems(request.NewRequest("http://a.com/x", "html"))
+    items.AddItem("title", "x")
+    pip.Process(items, task("test"))
+    if len(publisher.messages) != 1 || publisher.keys[0] != "a.com" || len(failed) != 0 {
+        t.Fatalf("message should be published after retries: %v %v", publisher.keys, failed)
+    }
+    var msg pipeline.QueueMessage
+    if err := json.Unmarshal(publisher.messages[0], &msg); err != nil {
+        t.Fatal(err)
+    }
+    if msg.Url != "http://a.com/x" || msg.Taskname != "test" || msg.Items["title"] != "x" {
+        t.Errorf("message error: %s", publisher.messages[0])
+    }
+
+    publisher.failures = 3
+    pip.Process(items, task("test"))
+    if len(publisher.messages) != 1 || len(failed) != 1 {
+        t.Error("message should be passed to failure handler")
+    }
+}
+
+func TestNsqPublisher(t *testing.T) {
+    var topic, body string
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/pub" || r.Method != "POST" {
+            http.NotFound(w, r)
+            return
+        }
+        topic = r.URL.Query().Get("topic")
+        content, _ := ioutil.ReadAll(r.Body)
+        body = string(content)
+        if topic == "bad" {
+            http.Error(w, "INVALID_TOPIC", http.StatusBadRequest)
+            return
+        }
+        w.Write([]byte("OK"))
+    }))
+    defer ts.Close()
+
+    if err := pipeline.NewNsqPublisher(ts.URL, "items").Publish(nil, []byte("hello")); err != nil {
+        t.Fatal(err)
+    }
+    if topic != "items" || body != "hello" {
+        t.Errorf("publish error: %s %s", topic, body)
+    }
+    if err := pipeline.NewNsqPublisher(ts.URL, "bad").Publish(nil, []byte("hello")); err == nil {
+        t.Error("publish should fail")
+    }
+}
@@ -0,0 +1,157 @@
+package pipeline
+
+import (
+    "bufio"
+    "compress/gzip"
+    "io"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The rotateFile is the output file of PipelineCsv and PipelineJsonLines. The file is rotated when it is larger
+// than maxSize or older than interval: it is renamed with its creation time like "items-20140901-150405.csv",
+// and optionally gzipped, then a new file of the path is created.
+type rotateFile struct {
+    path     string
+    maxSize  int64
+    interval time.Duration
+    gzip     bool
+
+    locker  sync.Mutex
+    file    *os.File
+    w       *bufio.Writer
+    size    int64
+    created time.Time
+
+    // The onCreate is called with locker held when a new file is created, like for writing csv header.
+    onCreate func()
+}
+
+// The open opens the file of the path for appending, creating its directory if needed.
+// It is called with locker held.
+func (this *rotateFile) open() error {
+    if this.file != nil {
+        return nil
+    }
+    if err := os.MkdirAll(filepath.Dir(this.path), 0755); err != nil {
+        return err
+    }
+    f, err := os.OpenFile(this.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+    if err != nil {
+        return err
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+    this.file, this.w = f, bufio.NewWriter(f)
+    this.size, this.created = info.Size(), time.Now()
+    if this.size == 0 && this.onCreate != nil {
+        this.onCreate()
+    }
+    return nil
+}
+
+// The write writes a record to the file, rotating it before if needed. It is called with locker held.
+func (this *rotateFile) write(record []byte) error {
+    if this.file != nil && this.needRotate(len(record)) {
+        if err := this.rotate(); err != nil {
+            return err
+        }
+    }
+    if err := this.open(); err != nil {
+        return err
+    }
+    n, err := this.w.Write(record)
+    this.size += int64(n)
+    return err
+}
+
+func (this *rotateFile) needRotate(n int) bool {
+    if this.maxSize > 0 && this.size > 0 && this.size+int64(n) > this.maxSize {
+        return true
+    }
+    return this.interval > 0 && time.Since(this.created) >= this.interval
+}
+
+// The rotate closes the file and renames it with its creation time. It is called with locker held.
+func (this *rotateFile) rotate() error {
+    created := this.created
+    if err := this.close(); err != nil {
+        return err
+    }
+    ext := filepath.Ext(this.path)
+    if ext == ".gz" {
+        // like "crawl.warc.gz"
+        ext = filepath.Ext(strings.TrimSuffix(this.path, ext)) + ext
+    }
+    base := strings.TrimSuffix(this.path, ext) + "-" + created.Format("20060102-150405")
+    name := base + ext
+    for i := 1; fileExists(name) || fileExists(name+".gz"); i++ {
+        name = base + "." + strconv.Itoa(i) + ext
+    }
+    if err := os.Rename(this.path, name); err != nil {
+        return err
+    }
+    if this.gzip {
+        return gzipFile(name)
+    }
+    return nil
+}
+
+// The flush writes records buffered to the file. It is called with locker held.
+func (this *rotateFile) flush() error {
+    if this.w == nil {
+        return nil
+    }
+    return this.w.Flush()
+}
+
+// The close flushes and closes the file. It is called with locker held.
+func (this *rotateFile) close() error {
+    if this.file == nil {
+        return nil
+    }
+    err := this.w.Flush()
+    if cerr := this.file.Close(); err == nil {
+        err = cerr
+    }
+    this.file, this.w = nil, nil
+    return err
+}
+
+func fileExists(path string) bool {
+    _, err := os.Stat(path)
+    return err == nil
+}
+
+// The gzipFile compresses the file to path with suffix ".gz" and removes it.
+func gzipFile(path string) error {
+    src, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer src.Close()
+    dst, err := os.Create(path + ".gz")
+    if err != nil {
+        return err
+    }
+    zw := gzip.NewWriter(dst)
+    _, err = io.Copy(zw, src)
+    if cerr := zw.Close(); err == nil {
+        err = cerr
+    }
+    if cerr := dst.Close(); err == nil {
+        err = cerr
+    }
+    if err != nil {
+        os.Remove(path + ".gz")
+        return err
+    }
+    return os.Remove(path)
+}
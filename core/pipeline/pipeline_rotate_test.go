@@ -0,0 +1,139 @@
+package pipeline_test
+
+import (
+    "compress/gzip"
+    "encoding/csv"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+func testItems(n int) *page_items.PageItems {
+    items := page_items.NewPageItems(request.NewRequest("http://a.com/"+strconv.Itoa(n), "html"))
+    items.AddItem("title", "title "+strconv.Itoa(n))
+    items.AddItem("price", strconv.Itoa(n*10))
+    items.SetValue("tags", []string{"a", "b"})
+    return items
+}
+
+// The readFiles returns contents of files in the dir by name, and ungzips files of ".gz".
+func readFiles(t *testing.T, dir string) map[string]string {
+    infos, err := ioutil.ReadDir(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    files := make(map[string]string)
+    for _, info := range infos {
+        f, err := os.Open(filepath.Join(dir, info.Name()))
+        if err != nil {
+            t.Fatal(err)
+        }
+        var data []byte
+        if strings.HasSuffix(info.Name(), ".gz") {
+            zr, err := gzip.NewReader(f)
+            if err != nil {
+                t.Fatal(err)
+            }
+            data, err = ioutil.ReadAll(zr)
+        } else {
+            data, err = ioutil.ReadAll(f)
+        }
+        f.Close()
+        if err != nil {
+            t.Fatal(err)
+        }
+        files[info.Name()] = string(data)
+    }
+    return files
+}
+
+func TestPipelineJsonLines(t *testing.T) {
+    dir, err := ioutil.TempDir("", "jsonl")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    pip := pipeline.NewPipelineJsonLines(filepath.Join(dir, "out", "items.jsonl")).SetRotateSize(200).SetGzip(true)
+    for i := 0; i < 5; i++ {
+        pip.Process(testItems(i), task("jsonl"))
+    }
+    pip.Flush()
+    if err := pip.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    files := readFiles(t, filepath.Join(dir, "out"))
+    var names, lines []string
+    for name, content := range files {
+        names = append(names, name)
+        if len(content) > 200 {
+            t.Errorf("file %s is larger than rotate size: %d", name, len(content))
+        }
+        lines = append(lines, strings.Split(strings.TrimSpace(content), "\n")...)
+    }
+    sort.Strings(names)
+    if len(names) < 2 || names[len(names)-1] != "items.jsonl" || !strings.HasSuffix(names[0], ".jsonl.gz") {
+        t.Fatalf("rotated files should be gzipped: %v", names)
+    }
+    if len(lines) != 5 {
+        t.Fatalf("wrong lines: %v", lines)
+    }
+    var item map[string]interface{}
+    if err := json.Unmarshal([]byte(lines[0]), &item); err != nil {
+        t.Fatal(err)
+    }
+    if !strings.HasPrefix(item["url"].(string), "http://a.com/") || len(item["tags"].([]interface{})) != 2 {
+        t.Errorf("wrong item: %v", item)
+    }
+}
+
+func TestPipelineCsv(t *testing.T) {
+    dir, err := ioutil.TempDir("", "csv")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    pip := pipeline.NewPipelineCsv(filepath.Join(dir, "items.csv")).SetRotateSize(150)
+    for i := 0; i < 4; i++ {
+        pip.Process(testItems(i), task("csv"))
+    }
+    pip.Flush()
+    defer pip.Close()
+    if strings.Join(pip.GetFields(), ",") != "price,tags,title" {
+        t.Errorf("fields should be inferred from keys: %v", pip.GetFields())
+    }
+
+    files := readFiles(t, dir)
+    if len(files) < 2 {
+        t.Fatalf("file should be rotated: %v", files)
+    }
+    rows := 0
+    for name, content := range files {
+        records, err := csv.NewReader(strings.NewReader(content)).ReadAll()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if strings.Join(records[0], ",") != "url,price,tags,title" {
+            t.Errorf("file %s should start with header: %v", name, records[0])
+        }
+        for _, record := range records[1:] {
+            if record[2] != `["a","b"]` || !strings.HasPrefix(record[3], "title ") {
+                t.Errorf("wrong record: %v", record)
+            }
+        }
+        rows += len(records) - 1
+    }
+    if rows != 4 {
+        t.Errorf("wrong rows: %d", rows)
+    }
+}
@@ -0,0 +1,335 @@
+package pipeline
+
+import (
+    "bytes"
+    "compress/gzip"
+    "crypto/hmac"
+    "crypto/sha1"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The s3TimeLayout is layout of crawl time in object keys.
+const s3TimeLayout = "20060102T150405.000000000Z"
+
+// The PipelineS3 archives responce bodies of pages to S3 compatible object storage, like AWS S3, MinIO or
+// Ceph, so pages can be processed again later without crawling them again. It is a PagePipeline.
+// Body of a page is saved at "<prefix>pages/<sha1 of url>/<crawl time>.body", with ".gz" appended if it is
+// gzip compressed, so all the crawls of an url are listed under one key prefix. Bodies are utf-8 text
+// converted by downloader, and pages of "file" responce type are not archived.
+// An index line of json with url, key, time, status code and Content-Type of each page is buffered, and
+// the lines are saved as "<prefix>index/<time>.jsonl" objects of SetIndexBatchSize lines, and by Flush
+// when Run returns. Requests are signed by AWS Signature Version 4, and failed uploads are retried with
+// exponential backoff.
+type PipelineS3 struct {
+    endpoint  string
+    bucket    string
+    region    string
+    accessKey string
+    secretKey string
+    token     string
+    prefix    string
+
+    virtualHost bool
+    compress    bool
+    client      *http.Client
+
+    retries int
+    backoff time.Duration
+
+    indexBatchSize int
+    locker         sync.Mutex
+    index          []byte
+    indexLines     int
+}
+
+// The s3IndexEntry is an index line of an archived page.
+type s3IndexEntry struct {
+    Url         string    `json:"url"`
+    Key         string    `json:"key"`
+    Time        time.Time `json:"time"`
+    StatusCode  int       `json:"status_code"`
+    ContentType string    `json:"content_type,omitempty"`
+    Size        int       `json:"size"`
+    Gzip        bool      `json:"gzip,omitempty"`
+    Taskname    string    `json:"taskname,omitempty"`
+}
+
+// NewPipelineS3 returns PipelineS3 of the bucket at endpoint like "https://s3.us-east-1.amazonaws.com" or
+// "http://127.0.0.1:9000", with access key of the region. Objects are addressed in path style like
+// "<endpoint>/<bucket>/<key>" by default. Default index batch size is 1000, and failed uploads are retried
+// 3 times from 1 second.
+func NewPipelineS3(endpoint, bucket, region, accessKey, secretKey string) *PipelineS3 {
+    return &PipelineS3{
+        endpoint:       strings.TrimRight(endpoint, "/"),
+        bucket:         bucket,
+        region:         region,
+        accessKey:      accessKey,
+        secretKey:      secretKey,
+        client:         &http.Client{Timeout: time.Minute},
+        retries:        3,
+        backoff:        time.Second,
+        indexBatchSize: 1000,
+    }
+}
+
+// The SetSessionToken sets session token of temporary credentials, which is sent in X-Amz-Security-Token header.
+func (this *PipelineS3) SetSessionToken(token string) *PipelineS3 {
+    this.token = token
+    return this
+}
+
+// The SetPrefix sets prefix of all the object keys, like "crawl/news/".
+func (this *PipelineS3) SetPrefix(prefix string) *PipelineS3 {
+    this.prefix = prefix
+    return this
+}
+
+// The SetVirtualHost sets whether objects are addressed in virtual hosted style like
+// "https://<bucket>.s3.amazonaws.com/<key>" instead of path style.
+func (this *PipelineS3) SetVirtualHost(virtualHost bool) *PipelineS3 {
+    this.virtualHost = virtualHost
+    return this
+}
+
+// The SetGzip sets whether bodies are gzip compressed before they are saved.
+func (this *PipelineS3) SetGzip(compress bool) *PipelineS3 {
+    this.compress = compress
+    return this
+}
+
+// The SetIndexBatchSize sets how many index lines are saved in one index object.
+func (this *PipelineS3) SetIndexBatchSize(n int) *PipelineS3 {
+    if n < 1 {
+        n = 1
+    }
+    this.indexBatchSize = n
+    return this
+}
+
+// The SetRetry sets how many times a failed upload is retried, and the wait before the first retry,
+// which is doubled for each retry.
+func (this *PipelineS3) SetRetry(retries int, backoff time.Duration) *PipelineS3 {
+    this.retries = retries
+    this.backoff = backoff
+    return this
+}
+
+// The Process does nothing, because bodies are archived by ProcessPage.
+func (this *PipelineS3) Process(items *page_items.PageItems, t com_interfaces.Task) {
+}
+
+// The ProcessPage saves body of the page and buffers its index line.
+func (this *PipelineS3) ProcessPage(p *page.Page, t com_interfaces.Task) {
+    if p.GetRequest().GetResponceType() == "file" {
+        return
+    }
+    body := []byte(p.GetBodyStr())
+    now := time.Now().UTC()
+    u := p.GetRequest().GetUrl()
+    sum := sha1.Sum([]byte(u))
+    entry := s3IndexEntry{
+        Url:        u,
+        Key:        this.prefix + "pages/" + hex.EncodeToString(sum[:]) + "/" + now.Format(s3TimeLayout) + ".body",
+        Time:       now,
+        StatusCode: p.GetStatusCode(),
+        Size:       len(body),
+        Gzip:       this.compress,
+    }
+    if header := p.GetHeader(); header != nil {
+        entry.ContentType = http.Header(header).Get("Content-Type")
+    }
+    if t != nil {
+        entry.Taskname = t.Taskname()
+    }
+    contentType := "text/plain; charset=utf-8"
+    if this.compress {
+        var buf bytes.Buffer
+        zw := gzip.NewWriter(&buf)
+        zw.Write(body)
+        zw.Close()
+        body = buf.Bytes()
+        entry.Key += ".gz"
+        contentType = "application/gzip"
+    }
+    if err := this.upload(entry.Key, body, contentType); err != nil {
+        logger.Error("s3 pipeline drops " + u + " : " + err.Error())
+        return
+    }
+
+    line, err := json.Marshal(entry)
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    this.locker.Lock()
+    this.index = append(append(this.index, line...), '\n')
+    this.indexLines++
+    var index []byte
+    if this.indexLines >= this.indexBatchSize {
+        index = this.index
+        this.index, this.indexLines = nil, 0
+    }
+    this.locker.Unlock()
+    if index != nil {
+        this.saveIndex(index)
+    }
+}
+
+// The Flush saves index lines left in the buffer.
+func (this *PipelineS3) Flush() {
+    this.locker.Lock()
+    index := this.index
+    this.index, this.indexLines = nil, 0
+    this.locker.Unlock()
+    if len(index) > 0 {
+        this.saveIndex(index)
+    }
+}
+
+func (this *PipelineS3) saveIndex(index []byte) {
+    key := this.prefix + "index/" + time.Now().UTC().Format(s3TimeLayout) + ".jsonl"
+    if err := this.upload(key, index, "application/x-ndjson"); err != nil {
+        logger.Error("s3 pipeline drops index " + key + " : " + err.Error())
+    }
+}
+
+// The upload puts the object, and retries it on network error, 429 or 5xx.
+func (this *PipelineS3) upload(key string, body []byte, contentType string) error {
+    backoff := this.backoff
+    for i := 0; ; i++ {
+        retry, err := this.put(key, body, contentType)
+        if err == nil || !retry || i >= this.retries {
+            return err
+        }
+        logger.Warn("s3 pipeline retries " + key + " : " + err.Error())
+        time.Sleep(backoff)
+        backoff *= 2
+    }
+}
+
+// The put sends a PutObject request, and returns whether it can be retried if it is failed.
+func (this *PipelineS3) put(key string, body []byte, contentType string) (bool, error) {
+    target := this.endpoint + "/" + s3Escape(this.bucket) + "/" + s3Escape(key)
+    if this.virtualHost {
+        u, err := url.Parse(this.endpoint)
+        if err != nil {
+            return false, err
+        }
+        u.Host = this.bucket + "." + u.Host
+        target = u.String() + "/" + s3Escape(key)
+    }
+    req, err := http.NewRequest("PUT", target, bytes.NewReader(body))
+    if err != nil {
+        return false, err
+    }
+    req.Header.Set("Content-Type", contentType)
+    this.sign(req, body, time.Now().UTC())
+    resp, err := this.client.Do(req)
+    if err != nil {
+        return true, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode/100 == 2 {
+        return false, nil
+    }
+    err = errors.New("http status " + strconv.Itoa(resp.StatusCode))
+    return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// The sign adds Authorization header of AWS Signature Version 4 to the request.
+func (this *PipelineS3) sign(req *http.Request, body []byte, t time.Time) {
+    payload := sha256.Sum256(body)
+    payloadHash := hex.EncodeToString(payload[:])
+    amzDate := t.Format("20060102T150405Z")
+    req.Header.Set("X-Amz-Date", amzDate)
+    req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+    if this.token != "" {
+        req.Header.Set("X-Amz-Security-Token", this.token)
+    }
+
+    headers := map[string]string{"host": req.URL.Host}
+    for key, values := range req.Header {
+        headers[strings.ToLower(key)] = strings.TrimSpace(strings.Join(values, ","))
+    }
+    names := make([]string, 0, len(headers))
+    for name := range headers {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    var canonicalHeaders strings.Builder
+    for _, name := range names {
+        canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+    }
+    signedHeaders := strings.Join(names, ";")
+
+    canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+        canonicalHeaders.String(), signedHeaders, payloadHash}, "\n")
+    scope := amzDate[:8] + "/" + this.region + "/s3/aws4_request"
+    requestHash := sha256.Sum256([]byte(canonicalRequest))
+    stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+    key := []byte("AWS4" + this.secretKey)
+    for _, part := range []string{amzDate[:8], this.region, "s3", "aws4_request"} {
+        key = s3Hmac(key, part)
+    }
+    signature := hex.EncodeToString(s3Hmac(key, stringToSign))
+    req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+this.accessKey+"/"+scope+
+        ", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func s3Hmac(key []byte, data string) []byte {
+    h := hmac.New(sha256.New, key)
+    h.Write([]byte(data))
+    return h.Sum(nil)
+}
+
+// The s3Escape escapes the object key for url path, keeping "/" and the unreserved characters of RFC 3986
+// as signature version 4 requires.
+func s3Escape(key string) string {
+    var b strings.Builder
+    for i := 0; i < len(key); i++ {
+        c := key[i]
+        if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+            c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+            b.WriteByte(c)
+        } else {
+            b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+        }
+    }
+    return b.String()
+}
+
+// The BatchSink returns BatchSink of PipelineBatch saving batches as "<prefix>batches/<name>" objects, each
+// uploaded before its manifest object.
+func (this *PipelineS3) BatchSink() BatchSink {
+    return s3BatchSink{s3: this}
+}
+
+type s3BatchSink struct {
+    s3 *PipelineS3
+}
+
+func (this s3BatchSink) Save(name string, batch []byte, manifest []byte) error {
+    key := this.s3.prefix + "batches/" + name
+    contentType := "application/x-ndjson"
+    if strings.HasSuffix(name, ".gz") {
+        contentType = "application/gzip"
+    }
+    if err := this.s3.upload(key, batch, contentType); err != nil {
+        return err
+    }
+    return this.s3.upload(key+".manifest.json", manifest, "application/json")
+}
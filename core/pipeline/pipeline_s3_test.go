@@ -0,0 +1,89 @@
+package pipeline_test
+
+import (
+    "bytes"
+    "compress/gzip"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestPipelineS3(t *testing.T) {
+    var locker sync.Mutex
+    objects := make(map[string][]byte)
+    failed := false
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        auth := r.Header.Get("Authorization")
+        if r.Method != "PUT" || !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") ||
+            !strings.Contains(auth, "/us-east-1/s3/aws4_request, SignedHeaders=content-type;host;x-amz-content-sha256;x-amz-date, Signature=") {
+            w.WriteHeader(http.StatusForbidden)
+            return
+        }
+        body, _ := ioutil.ReadAll(r.Body)
+        locker.Lock()
+        defer locker.Unlock()
+        if !failed {
+            failed = true
+            w.WriteHeader(http.StatusServiceUnavailable)
+            return
+        }
+        objects[r.URL.Path] = body
+    }))
+    defer ts.Close()
+
+    pip := pipeline.NewPipelineS3(ts.URL, "archive", "us-east-1", "key", "secret").SetPrefix("crawl/").
+        SetGzip(true).SetIndexBatchSize(2).SetRetry(1, time.Millisecond)
+    for _, u := range []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"} {
+        p := page.NewPage(request.NewRequest(u, "html")).SetBodyStr("<html>" + u + "</html>")
+        p.SetStatusCode(200)
+        p.SetHeader(map[string][]string{"Content-Type": {"text/html; charset=gbk"}})
+        pip.ProcessPage(p, nil)
+    }
+    pip.ProcessPage(page.NewPage(request.NewRequest("http://example.com/f.zip", "file")), nil)
+    pip.Flush()
+
+    var pages, indexes []string
+    for path := range objects {
+        if strings.HasPrefix(path, "/archive/crawl/pages/") && strings.HasSuffix(path, ".body.gz") {
+            pages = append(pages, path)
+        } else if strings.HasPrefix(path, "/archive/crawl/index/") && strings.HasSuffix(path, ".jsonl") {
+            indexes = append(indexes, path)
+        } else {
+            t.Errorf("object key error: %s", path)
+        }
+    }
+    if len(pages) != 3 || len(indexes) != 2 {
+        t.Fatalf("3 pages and 2 index objects should be saved: %v %v", pages, indexes)
+    }
+
+    entries := 0
+    for _, path := range indexes {
+        for _, line := range strings.Split(strings.TrimSpace(string(objects[path])), "\n") {
+            var entry map[string]interface{}
+            if err := json.Unmarshal([]byte(line), &entry); err != nil {
+                t.Fatal(err)
+            }
+            entries++
+            zr, err := gzip.NewReader(bytes.NewReader(objects["/archive/"+entry["key"].(string)]))
+            if err != nil {
+                t.Fatalf("body of %s should be gzip compressed: %v", entry["url"], err)
+            }
+            body, _ := ioutil.ReadAll(zr)
+            if string(body) != "<html>"+entry["url"].(string)+"</html>" || entry["status_code"] != 200.0 ||
+                entry["content_type"] != "text/html; charset=gbk" || entry["gzip"] != true {
+                t.Errorf("archived page error: %s %s", line, body)
+            }
+        }
+    }
+    if entries != 3 {
+        t.Errorf("index lines count error: %d", entries)
+    }
+}
@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+    "database/sql"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// The SqlUrlKey is the item key in column mapping of PipelineSql for url of the request.
+const SqlUrlKey = ":url"
+
+// The PipelineSql inserts results into a table of MySQL, PostgreSQL or other database of package database/sql.
+// Each PageItems is a row whose columns are mapped from item keys; missing items are inserted as NULL.
+// Rows are inserted in batches, and rows left are inserted by Flush when Run of Spider returns.
+// Connections are pooled by sql.DB, which can be tuned by its SetMaxOpenConns and SetMaxIdleConns.
+// The driver of database needs to be imported by the user, like github.com/go-sql-driver/mysql.
+type PipelineSql struct {
+    db    *sql.DB
+    table string
+
+    // The columns are sorted column names, and keys are item keys of them.
+    columns []string
+    keys    []string
+
+    dialect   string
+    batchSize int
+
+    locker sync.Mutex
+    rows   [][]interface{}
+}
+
+// NewPipelineSql returns PipelineSql that inserts into the table. The mapping is column name to item key,
+// and SqlUrlKey is the url of the request. Default dialect is "mysql" and default batch size is 100.
+func NewPipelineSql(db *sql.DB, table string, mapping map[string]string) *PipelineSql {
+    this := &PipelineSql{db: db, table: table, dialect: "mysql", batchSize: 100}
+    for column := range mapping {
+        this.columns = append(this.columns, column)
+    }
+    sort.Strings(this.columns)
+    for _, column := range this.columns {
+        this.keys = append(this.keys, mapping[column])
+    }
+    return this
+}
+
+// The SetDialect sets sql dialect of the database, "mysql" or "postgres".
+// It decides quotes of names and placeholders of values, like `name` and ? for "mysql", "name" and $1 for "postgres".
+func (this *PipelineSql) SetDialect(dialect string) *PipelineSql {
+    this.dialect = dialect
+    return this
+}
+
+// The SetBatchSize sets how many rows are inserted by one statement. The n 1 inserts each row at once.
+func (this *PipelineSql) SetBatchSize(n int) *PipelineSql {
+    if n < 1 {
+        n = 1
+    }
+    this.batchSize = n
+    return this
+}
+
+func (this *PipelineSql) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    row := make([]interface{}, len(this.keys))
+    for i, key := range this.keys {
+        if key == SqlUrlKey {
+            row[i] = items.GetRequest().GetUrl()
+        } else if value, ok := items.GetItem(key); ok {
+            row[i] = value
+        }
+    }
+
+    this.locker.Lock()
+    this.rows = append(this.rows, row)
+    var batch [][]interface{}
+    if len(this.rows) >= this.batchSize {
+        batch = this.rows
+        this.rows = nil
+    }
+    this.locker.Unlock()
+    if batch != nil {
+        this.insert(batch)
+    }
+}
+
+// The Flush inserts rows left in the batch.
+func (this *PipelineSql) Flush() {
+    this.locker.Lock()
+    batch := this.rows
+    this.rows = nil
+    this.locker.Unlock()
+    if len(batch) > 0 {
+        this.insert(batch)
+    }
+}
+
+func (this *PipelineSql) quote(name string) string {
+    if this.dialect == "postgres" {
+        return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+    }
+    return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+func (this *PipelineSql) placeholder(n int) string {
+    if this.dialect == "postgres" {
+        return "$" + strconv.Itoa(n)
+    }
+    return "?"
+}
+
+// The insert inserts the rows by one statement. Errors are logged and the rows are dropped.
+func (this *PipelineSql) insert(rows [][]interface{}) {
+    columns := make([]string, len(this.columns))
+    for i, column := range this.columns {
+        columns[i] = this.quote(column)
+    }
+    var query strings.Builder
+    query.WriteString("INSERT INTO " + this.quote(this.table) + " (" + strings.Join(columns, ", ") + ") VALUES ")
+    args := make([]interface{}, 0, len(rows)*len(this.columns))
+    for i, row := range rows {
+        if i > 0 {
+            query.WriteString(", ")
+        }
+        query.WriteString("(")
+        for j, value := range row {
+            if j > 0 {
+                query.WriteString(", ")
+            }
+            args = append(args, value)
+            query.WriteString(this.placeholder(len(args)))
+        }
+        query.WriteString(")")
+    }
+    if _, err := this.db.Exec(query.String(), args...); err != nil {
+        logger.Error("sql pipeline insert " + strconv.Itoa(len(rows)) + " rows error : " + err.Error())
+    }
+}
@@ -0,0 +1,112 @@
+package pipeline_test
+
+import (
+    "database/sql"
+    "database/sql/driver"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "sync"
+    "testing"
+)
+
+// The recordDriver is a sql driver that records statements executed.
+type recordDriver struct {
+    locker sync.Mutex
+    execs  []recordExec
+}
+
+// The recorder is registered as driver "record" once, as sql.Register panics for a name registered twice.
+var recorder = &recordDriver{}
+
+func init() {
+    sql.Register("record", recorder)
+}
+
+// The reset forgets statements executed, so each test sees its own.
+func (this *recordDriver) reset() {
+    this.locker.Lock()
+    this.execs = nil
+    this.locker.Unlock()
+}
+
+type recordExec struct {
+    query string
+    args  []driver.Value
+}
+
+func (this *recordDriver) Open(name string) (driver.Conn, error) {
+    return &recordConn{this}, nil
+}
+
+type recordConn struct {
+    d *recordDriver
+}
+
+func (this *recordConn) Prepare(query string) (driver.Stmt, error) {
+    return &recordStmt{this.d, query}, nil
+}
+
+func (this *recordConn) Close() error {
+    return nil
+}
+
+func (this *recordConn) Begin() (driver.Tx, error) {
+    return nil, driver.ErrSkip
+}
+
+type recordStmt struct {
+    d     *recordDriver
+    query string
+}
+
+func (this *recordStmt) Close() error {
+    return nil
+}
+
+func (this *recordStmt) NumInput() int {
+    return -1
+}
+
+func (this *recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+    this.d.locker.Lock()
+    this.d.execs = append(this.d.execs, recordExec{this.query, args})
+    this.d.locker.Unlock()
+    return driver.RowsAffected(1), nil
+}
+
+func (this *recordStmt) Query(args []driver.Value) (driver.Rows, error) {
+    return nil, driver.ErrSkip
+}
+
+func TestPipelineSql(t *testing.T) {
+    d := recorder
+    d.reset()
+    db, err := sql.Open("record", "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer db.Close()
+
+    pip := pipeline.NewPipelineSql(db, "pages", map[string]string{"title": "title", "url": pipeline.SqlUrlKey})
+    pip.SetDialect("postgres").SetBatchSize(2)
+    for _, title := range []string{"a", "b", ""} {
+        items := page_items.NewPageItems(request.NewRequest("http://a.com/"+title, "html"))
+        if title != "" {
+            items.AddItem("title", title)
+        }
+        pip.Process(items, nil)
+    }
+    if len(d.execs) != 1 {
+        t.Fatalf("rows should be inserted in batch: %d", len(d.execs))
+    }
+    if d.execs[0].query != `INSERT INTO "pages" ("title", "url") VALUES ($1, $2), ($3, $4)` || len(d.execs[0].args) != 4 ||
+        d.execs[0].args[2] != "b" || d.execs[0].args[3] != "http://a.com/b" {
+        t.Errorf("insert error: %v", d.execs[0])
+    }
+
+    pip.Flush()
+    if len(d.execs) != 2 || d.execs[1].query != `INSERT INTO "pages" ("title", "url") VALUES ($1, $2)` || d.execs[1].args[0] != nil {
+        t.Errorf("flush error: %v", d.execs)
+    }
+}
@@ -0,0 +1,245 @@
+package pipeline
+
+import (
+    "bytes"
+    "compress/gzip"
+    "crypto/rand"
+    "crypto/sha1"
+    "encoding/base32"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "net/http"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// The warcRevisitProfile is WARC-Profile of revisit records whose payload is the same as an earlier record.
+const warcRevisitProfile = "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest"
+
+// The PipelineWarc writes responces and requests of pages in WARC 1.1 format, which is replayed by web archive
+// tools like pywb and OpenWayback. It is a PagePipeline, and needs Spider.SetKeepRawBody(true) to archive bodies
+// as they are received; bodies of pages without raw body are archived as utf-8 text.
+// Each file starts with a warcinfo record, and each page is a response record of its final url after redirects
+// with a request record concurrent to it. A page of the same payload as an earlier page is written as a revisit
+// record without payload if SetDeduplicate is true, which is the default; payload digests of the crawl are kept
+// in memory for it. Records are gzipped one by one if the path ends with ".gz", like "data/crawl.warc.gz".
+// Pages not downloaded by http, like pages of cache, and pages of "file" responce type are not archived.
+// The file is rotated by SetRotateSize or SetRotateInterval. Records are buffered and written by Flush when Run
+// of Spider returns, or when the file is rotated.
+type PipelineWarc struct {
+    rotateFile
+
+    compress    bool
+    deduplicate bool
+
+    // The warcinfoId is id of warcinfo record of the current file, and digests saves target uri and date of
+    // the first record of each payload digest.
+    warcinfoId string
+    digests    map[string][2]string
+}
+
+// NewPipelineWarc returns PipelineWarc that appends to the file of path like "data/crawl.warc.gz".
+func NewPipelineWarc(path string) *PipelineWarc {
+    this := &PipelineWarc{
+        rotateFile:  rotateFile{path: path},
+        compress:    strings.HasSuffix(path, ".gz"),
+        deduplicate: true,
+        digests:     make(map[string][2]string),
+    }
+    this.onCreate = this.writeWarcinfo
+    return this
+}
+
+// The SetRotateSize rotates the file before it grows larger than n bytes, like 1 GB of common WARC files.
+// Default 0 is no limit.
+func (this *PipelineWarc) SetRotateSize(n int64) *PipelineWarc {
+    this.maxSize = n
+    return this
+}
+
+// The SetRotateInterval rotates the file when it is older than d, like time.Hour. Default 0 is no limit.
+func (this *PipelineWarc) SetRotateInterval(d time.Duration) *PipelineWarc {
+    this.interval = d
+    return this
+}
+
+// The SetDeduplicate sets whether pages of the same payload as an earlier page are written as revisit records.
+func (this *PipelineWarc) SetDeduplicate(deduplicate bool) *PipelineWarc {
+    this.deduplicate = deduplicate
+    return this
+}
+
+// The Process does nothing, because pages are archived by ProcessPage.
+func (this *PipelineWarc) Process(items *page_items.PageItems, t com_interfaces.Task) {
+}
+
+// The ProcessPage writes response and request records of the page.
+func (this *PipelineWarc) ProcessPage(p *page.Page, t com_interfaces.Task) {
+    resp := p.GetResponse()
+    if resp == nil || resp.Request == nil || p.GetRequest().GetResponceType() == "file" {
+        return
+    }
+    payload := p.GetRawBody()
+    if payload == nil {
+        payload = []byte(p.GetBodyStr())
+    }
+    uri := p.GetFinalUrl()
+    date := time.Now().UTC().Format(time.RFC3339)
+    payloadDigest := warcDigest(payload)
+
+    // http headers of the body read, which is not chunked and is decompressed unless its encoding is unknown
+    header := resp.Header.Clone()
+    header.Del("Transfer-Encoding")
+    header.Set("Content-Length", strconv.Itoa(len(payload)))
+    var head bytes.Buffer
+    head.WriteString(resp.Proto + " " + resp.Status + "\r\n")
+    header.Write(&head)
+    head.WriteString("\r\n")
+
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    // rotate before the records are built, so they refer to warcinfo of the new file
+    if this.file != nil && this.needRotate(head.Len()+len(payload)+2048) {
+        if err := this.rotate(); err != nil {
+            logger.Error("warc pipeline error : " + err.Error())
+            return
+        }
+    }
+    if err := this.open(); err != nil {
+        logger.Error("warc pipeline error : " + err.Error())
+        return
+    }
+    responseId := warcRecordId()
+    fields := []string{"WARC-Type", "response", "WARC-Record-ID", responseId, "WARC-Warcinfo-ID", this.warcinfoId,
+        "WARC-Date", date, "WARC-Target-URI", uri, "Content-Type", "application/http;msgtype=response",
+        "WARC-Payload-Digest", payloadDigest}
+    block := append(head.Bytes(), payload...)
+    if first, ok := this.digests[payloadDigest]; ok && this.deduplicate {
+        fields[1] = "revisit"
+        fields = append(fields, "WARC-Profile", warcRevisitProfile, "WARC-Refers-To-Target-URI", first[0],
+            "WARC-Refers-To-Date", first[1])
+        block = head.Bytes()
+    } else {
+        this.digests[payloadDigest] = [2]string{uri, date}
+    }
+    err := this.writeRecord(fields, block)
+    if err == nil {
+        fields = []string{"WARC-Type", "request", "WARC-Record-ID", warcRecordId(), "WARC-Warcinfo-ID", this.warcinfoId,
+            "WARC-Date", date, "WARC-Target-URI", uri, "WARC-Concurrent-To", responseId,
+            "Content-Type", "application/http;msgtype=request"}
+        err = this.writeRecord(fields, warcRequest(p, resp.Request))
+    }
+    if err != nil {
+        logger.Error("warc pipeline error : " + err.Error())
+    }
+}
+
+// The warcRequest returns the http request of the final url as it is sent, without headers added by transport.
+func warcRequest(p *page.Page, req *http.Request) []byte {
+    var buf bytes.Buffer
+    buf.WriteString(req.Method + " " + req.URL.RequestURI() + " HTTP/1.1\r\n")
+    host := req.Host
+    if host == "" {
+        host = req.URL.Host
+    }
+    buf.WriteString("Host: " + host + "\r\n")
+    header := req.Header.Clone()
+    if header == nil {
+        header = make(http.Header)
+    }
+    var body string
+    if r := p.GetRequest(); req.Method == r.GetMethod() && r.GetPostdata() != "" {
+        body = r.GetPostdata()
+        header.Set("Content-Length", strconv.Itoa(len(body)))
+    }
+    header.Write(&buf)
+    buf.WriteString("\r\n" + body)
+    return buf.Bytes()
+}
+
+// The writeWarcinfo writes warcinfo record at start of a new file. It is called with locker held.
+func (this *PipelineWarc) writeWarcinfo() {
+    this.warcinfoId = warcRecordId()
+    fields := []string{"WARC-Type", "warcinfo", "WARC-Record-ID", this.warcinfoId,
+        "WARC-Date", time.Now().UTC().Format(time.RFC3339), "WARC-Filename", filepath.Base(this.path),
+        "Content-Type", "application/warc-fields"}
+    block := "software: go_spider\r\nformat: WARC File Format 1.1\r\n" +
+        "conformsTo: http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/\r\n"
+    if record, err := this.encodeRecord(fields, []byte(block)); err == nil {
+        n, _ := this.w.Write(record)
+        this.size += int64(n)
+    }
+}
+
+// The writeRecord writes a record of the fields and block. It is called with locker held.
+func (this *PipelineWarc) writeRecord(fields []string, block []byte) error {
+    record, err := this.encodeRecord(fields, block)
+    if err != nil {
+        return err
+    }
+    return this.write(record)
+}
+
+// The encodeRecord returns the record of the header fields in pairs of name and value, and the block,
+// gzipped if the file is gzipped. Fields of empty value are left out, like WARC-Warcinfo-ID of a file
+// appended to.
+func (this *PipelineWarc) encodeRecord(fields []string, block []byte) ([]byte, error) {
+    var buf bytes.Buffer
+    buf.WriteString("WARC/1.1\r\n")
+    for i := 0; i+1 < len(fields); i += 2 {
+        if fields[i+1] != "" {
+            buf.WriteString(fields[i] + ": " + fields[i+1] + "\r\n")
+        }
+    }
+    buf.WriteString("WARC-Block-Digest: " + warcDigest(block) + "\r\n")
+    buf.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+    buf.Write(block)
+    buf.WriteString("\r\n\r\n")
+    if !this.compress {
+        return buf.Bytes(), nil
+    }
+    var gz bytes.Buffer
+    zw := gzip.NewWriter(&gz)
+    if _, err := zw.Write(buf.Bytes()); err != nil {
+        return nil, err
+    }
+    if err := zw.Close(); err != nil {
+        return nil, err
+    }
+    return gz.Bytes(), nil
+}
+
+// The Flush writes records buffered to the file.
+func (this *PipelineWarc) Flush() {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if err := this.flush(); err != nil {
+        logger.Error("warc pipeline error : " + err.Error())
+    }
+}
+
+// The Close writes records buffered and closes the file. The file is opened again by ProcessPage.
+func (this *PipelineWarc) Close() error {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.close()
+}
+
+// The warcDigest returns sha1 digest in base32 like "sha1:3I42H3S6NNFQ2MSVX7XZKYAYSCX5QBYJ".
+func warcDigest(data []byte) string {
+    sum := sha1.Sum(data)
+    return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// The warcRecordId returns a random uuid like "<urn:uuid:...>".
+func warcRecordId() string {
+    var b [16]byte
+    rand.Read(b[:])
+    b[6] = b[6]&0x0f | 0x40
+    b[8] = b[8]&0x3f | 0x80
+    return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
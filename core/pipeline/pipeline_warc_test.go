@@ -0,0 +1,123 @@
+package pipeline_test
+
+import (
+    "bufio"
+    "compress/gzip"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "net/textproto"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "testing"
+)
+
+type warcRecord struct {
+    header textproto.MIMEHeader
+    block  []byte
+}
+
+func readWarc(t *testing.T, path string) []warcRecord {
+    f, err := os.Open(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer f.Close()
+    zr, err := gzip.NewReader(f)
+    if err != nil {
+        t.Fatal(err)
+    }
+    r := bufio.NewReader(zr)
+    var records []warcRecord
+    for {
+        version, err := r.ReadString('\n')
+        if err == io.EOF {
+            return records
+        }
+        if version != "WARC/1.1\r\n" {
+            t.Fatalf("warc version line error: %q", version)
+        }
+        header, err := textproto.NewReader(r).ReadMIMEHeader()
+        if err != nil {
+            t.Fatal(err)
+        }
+        n, _ := strconv.Atoi(header.Get("Content-Length"))
+        block := make([]byte, n+4)
+        if _, err := io.ReadFull(r, block); err != nil || string(block[n:]) != "\r\n\r\n" {
+            t.Fatalf("record block error: %v", err)
+        }
+        records = append(records, warcRecord{header: header, block: block[:n]})
+    }
+}
+
+func TestPipelineWarc(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", "text/html; charset=iso-8859-1")
+        if r.URL.Path == "/c" {
+            w.Write([]byte("<p>other</p>"))
+            return
+        }
+        w.Write([]byte("<p>caf\xe9</p>"))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_warc")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "crawl.warc.gz")
+
+    d := downloader.NewHttpDownloader().SetKeepRawBody(true)
+    pip := pipeline.NewPipelineWarc(path)
+    for _, u := range []string{"/a", "/b", "/c"} {
+        p := d.Download(request.NewRequest(ts.URL+u, "html").SetHeader("X-Test", "1"))
+        if !p.IsSucc() {
+            t.Fatal(p.Errormsg())
+        }
+        pip.ProcessPage(p, nil)
+    }
+    if err := pip.Close(); err != nil {
+        t.Fatal(err)
+    }
+
+    records := readWarc(t, path)
+    types := make([]string, len(records))
+    for i, r := range records {
+        types[i] = r.header.Get("WARC-Type")
+        if r.header.Get("WARC-Block-Digest") == "" || !strings.HasPrefix(r.header.Get("WARC-Record-ID"), "<urn:uuid:") {
+            t.Errorf("record header error: %v", r.header)
+        }
+    }
+    if strings.Join(types, ",") != "warcinfo,response,request,revisit,request,response,request" {
+        t.Fatalf("record types error: %v", types)
+    }
+
+    response := records[1]
+    if response.header.Get("WARC-Target-URI") != ts.URL+"/a" || response.header.Get("WARC-Warcinfo-ID") != records[0].header.Get("WARC-Record-ID") {
+        t.Errorf("response record header error: %v", response.header)
+    }
+    if !strings.HasPrefix(string(response.block), "HTTP/1.1 200 OK\r\n") ||
+        !strings.Contains(string(response.block), "Content-Length: 11\r\nContent-Type: text/html; charset=iso-8859-1\r\n") ||
+        !strings.HasSuffix(string(response.block), "\r\n\r\n<p>caf\xe9</p>") {
+        t.Errorf("response block should have raw body: %q", response.block)
+    }
+    if req := records[2]; req.header.Get("WARC-Concurrent-To") != response.header.Get("WARC-Record-ID") ||
+        !strings.HasPrefix(string(req.block), "GET /a HTTP/1.1\r\nHost: "+strings.TrimPrefix(ts.URL, "http://")+"\r\n") ||
+        !strings.Contains(string(req.block), "X-Test: 1\r\n") {
+        t.Errorf("request record error: %v %q", req.header, req.block)
+    }
+
+    revisit := records[3]
+    if revisit.header.Get("WARC-Refers-To-Target-URI") != ts.URL+"/a" ||
+        revisit.header.Get("WARC-Payload-Digest") != response.header.Get("WARC-Payload-Digest") ||
+        !strings.HasSuffix(string(revisit.block), "\r\n\r\n") || strings.Contains(string(revisit.block), "<p>") {
+        t.Errorf("revisit record error: %v %q", revisit.header, revisit.block)
+    }
+}
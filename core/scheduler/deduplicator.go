@@ -0,0 +1,98 @@
+package scheduler
+
+import (
+    "crypto/md5"
+    "encoding/gob"
+    "io"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "sync"
+)
+
+// The Deduplicator interface records fingerprints of requests pushed to Scheduler.
+// Function Seen adds the fingerprint and returns whether it has been added before; it should be atomic,
+// so that only one of the spiders racing on the same fingerprint gets false.
+// Unlike the duplicate removing of QueueScheduler, fingerprints are kept after requests are polled.
+type Deduplicator interface {
+    Seen(fingerprint string) bool
+}
+
+// The PersistentDeduplicator interface is Deduplicator that can be saved in a file, for checkpoint of Spider.
+// Function Save writes all the fingerprints to the file.
+// Function Load adds fingerprints saved in the file, keeping fingerprints added already.
+type PersistentDeduplicator interface {
+    Deduplicator
+    Save(path string) error
+    Load(path string) error
+}
+
+// The MapDeduplicator keeps md5 of fingerprints in memory.
+type MapDeduplicator struct {
+    locker sync.Mutex
+    keys   map[[md5.Size]byte]bool
+}
+
+func NewMapDeduplicator() *MapDeduplicator {
+    return &MapDeduplicator{keys: make(map[[md5.Size]byte]bool)}
+}
+
+func (this *MapDeduplicator) Seen(fingerprint string) bool {
+    key := md5.Sum([]byte(fingerprint))
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.keys[key] {
+        return true
+    }
+    this.keys[key] = true
+    return false
+}
+
+// Save writes md5 of fingerprints to the file.
+func (this *MapDeduplicator) Save(path string) error {
+    this.locker.Lock()
+    keys := make([][md5.Size]byte, 0, len(this.keys))
+    for key := range this.keys {
+        keys = append(keys, key)
+    }
+    this.locker.Unlock()
+    return writeFile(path, func(w io.Writer) error {
+        return gob.NewEncoder(w).Encode(keys)
+    })
+}
+
+// Load adds fingerprints saved by Save.
+func (this *MapDeduplicator) Load(path string) error {
+    f, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+    var keys [][md5.Size]byte
+    if err = gob.NewDecoder(f).Decode(&keys); err != nil {
+        return err
+    }
+    this.locker.Lock()
+    for _, key := range keys {
+        this.keys[key] = true
+    }
+    this.locker.Unlock()
+    return nil
+}
+
+// The writeFile writes the file by write function, to temp file first so that a broken file is never read.
+func writeFile(path string, write func(w io.Writer) error) error {
+    tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp")
+    if err != nil {
+        return err
+    }
+    err = write(tmp)
+    tmp.Close()
+    if err == nil {
+        err = os.Rename(tmp.Name(), path)
+    }
+    if err != nil {
+        os.Remove(tmp.Name())
+    }
+    return err
+}
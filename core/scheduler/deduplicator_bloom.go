@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+    "crypto/md5"
+    "encoding/binary"
+    "encoding/gob"
+    "errors"
+    "io"
+    "math"
+    "os"
+    "sync"
+)
+
+// The BloomDeduplicator is a Deduplicator of bloom filter, which uses fixed memory for millions of
+// fingerprints. A new fingerprint may be taken as seen by the false positive rate, but a seen fingerprint
+// is never taken as new. It can be saved in a file and loaded after restart.
+type BloomDeduplicator struct {
+    locker sync.Mutex
+    bits   []uint64
+    m      uint64
+    k      uint64
+}
+
+// The bloomFile is the content of file saved by BloomDeduplicator.
+type bloomFile struct {
+    M    uint64
+    K    uint64
+    Bits []uint64
+}
+
+// NewBloomDeduplicator returns BloomDeduplicator sized for n fingerprints with false positive rate p,
+// like 10000000 and 0.001 which uses about 17MB memory.
+func NewBloomDeduplicator(n uint64, p float64) *BloomDeduplicator {
+    if n == 0 {
+        n = 1
+    }
+    if p <= 0 || p >= 1 {
+        p = 0.001
+    }
+    m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+    k := uint64(math.Ceil(float64(m) / float64(n) * math.Ln2))
+    if k == 0 {
+        k = 1
+    }
+    return &BloomDeduplicator{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// LoadBloomDeduplicator reads BloomDeduplicator saved by Save.
+func LoadBloomDeduplicator(path string) (*BloomDeduplicator, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+    var bf bloomFile
+    if err = gob.NewDecoder(f).Decode(&bf); err != nil {
+        return nil, err
+    }
+    return &BloomDeduplicator{bits: bf.Bits, m: bf.M, k: bf.K}, nil
+}
+
+// The locations returns bit indexes of the fingerprint by double hashing.
+func (this *BloomDeduplicator) locations(fingerprint string) []uint64 {
+    sum := md5.Sum([]byte(fingerprint))
+    h1 := binary.LittleEndian.Uint64(sum[0:8])
+    h2 := binary.LittleEndian.Uint64(sum[8:16])
+    locations := make([]uint64, this.k)
+    for i := uint64(0); i < this.k; i++ {
+        locations[i] = (h1 + i*h2) % this.m
+    }
+    return locations
+}
+
+func (this *BloomDeduplicator) Seen(fingerprint string) bool {
+    locations := this.locations(fingerprint)
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    seen := true
+    for _, l := range locations {
+        if this.bits[l/64]&(1<<(l%64)) == 0 {
+            seen = false
+            this.bits[l/64] |= 1 << (l % 64)
+        }
+    }
+    return seen
+}
+
+// Save writes the filter to the file, which can be read by LoadBloomDeduplicator.
+func (this *BloomDeduplicator) Save(path string) error {
+    this.locker.Lock()
+    bf := bloomFile{M: this.m, K: this.k, Bits: append([]uint64(nil), this.bits...)}
+    this.locker.Unlock()
+    return writeFile(path, func(w io.Writer) error {
+        return gob.NewEncoder(w).Encode(&bf)
+    })
+}
+
+// Load adds fingerprints of the filter saved by Save, which needs to be of the same size.
+func (this *BloomDeduplicator) Load(path string) error {
+    loaded, err := LoadBloomDeduplicator(path)
+    if err != nil {
+        return err
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if loaded.m != this.m || loaded.k != this.k {
+        return errors.New("bloom filter size is different : " + path)
+    }
+    for i, word := range loaded.bits {
+        this.bits[i] |= word
+    }
+    return nil
+}
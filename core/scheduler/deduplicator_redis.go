@@ -0,0 +1,38 @@
+package scheduler
+
+import (
+    "github.com/gomodule/redigo/redis"
+)
+
+// The RedisDeduplicator saves fingerprints in a redis set, so they are kept after restart and shared by
+// spiders on different machines.
+type RedisDeduplicator struct {
+    pool *redis.Pool
+    key  string
+}
+
+// NewRedisDeduplicator returns RedisDeduplicator that saves fingerprints in set of the key.
+func NewRedisDeduplicator(pool *redis.Pool, key string) *RedisDeduplicator {
+    return &RedisDeduplicator{pool: pool, key: key}
+}
+
+// Seen adds the fingerprint by SADD, which is atomic among spiders.
+// If redis fails, the fingerprint is taken as new so that no request is lost.
+func (this *RedisDeduplicator) Seen(fingerprint string) bool {
+    conn := this.pool.Get()
+    defer conn.Close()
+    added, err := redis.Int(conn.Do("SADD", this.key, fingerprint))
+    if err != nil {
+        logger.Error("redis dedup error : " + err.Error())
+        return false
+    }
+    return added == 0
+}
+
+// Clear removes all the fingerprints.
+func (this *RedisDeduplicator) Clear() error {
+    conn := this.pool.Get()
+    defer conn.Close()
+    _, err := conn.Do("DEL", this.key)
+    return err
+}
@@ -0,0 +1,86 @@
+package scheduler_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "testing"
+)
+
+func TestDeduplicator(t *testing.T) {
+    for _, d := range []scheduler.Deduplicator{scheduler.NewMapDeduplicator(), scheduler.NewBloomDeduplicator(1000, 0.001)} {
+        s := scheduler.NewQueueScheduler(false).SetDeduplicator(d)
+        s.Push(request.NewRequest("http://baidu.com", "html"))
+        s.Poll()
+        s.Push(request.NewRequest("http://baidu.com/", "html"))
+        s.Push(request.NewRequest("http://qq.com", "html"))
+        if s.Count() != 1 {
+            t.Errorf("polled request should be duplicate: %T", d)
+        }
+    }
+}
+
+func TestBloomDeduplicator(t *testing.T) {
+    d := scheduler.NewBloomDeduplicator(10000, 0.01)
+    for i := 0; i < 10000; i++ {
+        d.Seen("http://a.com/" + strconv.Itoa(i))
+    }
+    falsePositive := 0
+    // Seen adds the fingerprint too, so a few are tested only.
+    for i := 10000; i < 11000; i++ {
+        if d.Seen("http://a.com/" + strconv.Itoa(i)) {
+            falsePositive++
+        }
+    }
+    if falsePositive > 30 {
+        t.Errorf("false positive rate is too high: %d", falsePositive)
+    }
+
+    dir, err := ioutil.TempDir("", "go_spider_bloom")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "bloom")
+    if err = d.Save(path); err != nil {
+        t.Fatal(err)
+    }
+    d, err = scheduler.LoadBloomDeduplicator(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !d.Seen("http://a.com/1") {
+        t.Error("loaded filter lost fingerprint")
+    }
+}
+
+func TestMapDeduplicatorSave(t *testing.T) {
+    dir, err := ioutil.TempDir("", "go_spider_dedup")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "dedup")
+
+    d := scheduler.NewMapDeduplicator()
+    d.Seen("a")
+    if err = d.Save(path); err != nil {
+        t.Fatal(err)
+    }
+    loaded := scheduler.NewMapDeduplicator()
+    loaded.Seen("b")
+    if err = loaded.Load(path); err != nil {
+        t.Fatal(err)
+    }
+    if !loaded.Seen("a") || !loaded.Seen("b") || loaded.Seen("c") {
+        t.Error("loaded fingerprints should be added")
+    }
+
+    var _ scheduler.PersistentDeduplicator = scheduler.NewBloomDeduplicator(100, 0.01)
+    if scheduler.NewBloomDeduplicator(1000, 0.01).Load(path) == nil {
+        t.Error("file of other deduplicator should not be loaded")
+    }
+}
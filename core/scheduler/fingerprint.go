@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+    "crypto/md5"
+    "encoding/hex"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/util"
+    "mime"
+    "net/url"
+    "strings"
+)
+
+// The RequestFingerprinter returns fingerprints of requests, so that requests of the same method, url and body
+// are duplicate, like the same query of a search api by POST.
+// The fingerprint of a GET or HEAD request without body is the url normalized, same as fingerprints saved by
+// schedulers before. Other fingerprints are the method, the url and md5 of the body, where params of urlencoded
+// forms and keys of json objects are sorted, so their order does not matter. Fingerprints of requests of a
+// session of Request.SetSession end with the session, as each account sees its own page.
+// Its Fingerprint can be set by Spider.SetRequestFingerprint or SetFingerprint of Scheduler.
+type RequestFingerprinter struct {
+    urlFunc func(string) string
+    ignored map[string]bool
+    headers []string
+}
+
+// NewRequestFingerprinter returns RequestFingerprinter that normalizes urls by util.NormalizeUrl.
+func NewRequestFingerprinter() *RequestFingerprinter {
+    return &RequestFingerprinter{urlFunc: util.NormalizeUrl, ignored: make(map[string]bool)}
+}
+
+// The SetUrlFunc sets function that returns the url in fingerprints, like Canonicalize of UrlCanonicalizer.
+func (this *RequestFingerprinter) SetUrlFunc(f func(string) string) *RequestFingerprinter {
+    this.urlFunc = f
+    return this
+}
+
+// The IgnoreBodyFields adds params of forms and top level keys of json bodies that do not change the response,
+// like timestamps or nonces of api requests.
+func (this *RequestFingerprinter) IgnoreBodyFields(names ...string) *RequestFingerprinter {
+    for _, name := range names {
+        this.ignored[name] = true
+    }
+    return this
+}
+
+// The IncludeHeaders adds headers whose values are part of fingerprints, like "Accept-Language" of localized
+// pages or "Authorization" of apis answering each user differently.
+func (this *RequestFingerprinter) IncludeHeaders(names ...string) *RequestFingerprinter {
+    this.headers = append(this.headers, names...)
+    return this
+}
+
+// The Fingerprint returns fingerprint of the request.
+func (this *RequestFingerprinter) Fingerprint(req *request.Request) string {
+    fingerprint := this.urlFunc(req.GetUrl())
+    method := req.GetMethod()
+    body := req.GetPostdata()
+    if body != "" || (method != "GET" && method != "HEAD") {
+        sum := md5.Sum([]byte(this.canonicalBody(body, req.GetHeader().Get("Content-Type"))))
+        fingerprint = method + " " + fingerprint + " " + hex.EncodeToString(sum[:])
+    }
+    for _, name := range this.headers {
+        if values := req.GetHeader().Values(name); len(values) > 0 {
+            fingerprint += " " + strings.ToLower(name) + "=" + strings.Join(values, ",")
+        }
+    }
+    if session := req.GetSession(); session != "" {
+        fingerprint += " session=" + session
+    }
+    return fingerprint
+}
+
+// The canonicalBody sorts names of params of urlencoded form and keys of json object, and removes the ignored fields.
+// Other bodies are returned unchanged.
+func (this *RequestFingerprinter) canonicalBody(body string, contentType string) string {
+    mediaType, _, _ := mime.ParseMediaType(contentType)
+    switch {
+    case mediaType == "application/x-www-form-urlencoded":
+        form, err := url.ParseQuery(body)
+        if err != nil {
+            return body
+        }
+        for name := range this.ignored {
+            form.Del(name)
+        }
+        return form.Encode()
+    case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+        var value interface{}
+        if err := json.Unmarshal([]byte(body), &value); err != nil {
+            return body
+        }
+        if object, ok := value.(map[string]interface{}); ok {
+            for name := range this.ignored {
+                delete(object, name)
+            }
+        }
+        // keys of maps are sorted by json.Marshal
+        canonical, err := json.Marshal(value)
+        if err != nil {
+            return body
+        }
+        return string(canonical)
+    }
+    return body
+}
+
+var defaultFingerprinter = NewRequestFingerprinter()
+
+// DefaultFingerprint returns fingerprint of the request by RequestFingerprinter, which is the normalized url of
+// GET requests and the method, normalized url and canonical body of others.
+// Schedulers that save fingerprints should use it by default, so the same request always has the same fingerprint.
+func DefaultFingerprint(req *request.Request) string {
+    return defaultFingerprinter.Fingerprint(req)
+}
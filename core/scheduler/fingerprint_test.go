@@ -0,0 +1,60 @@
+package scheduler_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "net/url"
+    "testing"
+)
+
+func TestRequestFingerprinter(t *testing.T) {
+    api := "http://a.com/search"
+    get := request.NewRequest("HTTP://a.com/search?b=2&a=1", "json")
+    if scheduler.DefaultFingerprint(get) != "http://a.com/search?a=1&b=2" {
+        t.Errorf("fingerprint of GET request should be normalized url: %s", scheduler.DefaultFingerprint(get))
+    }
+
+    form := func(q string, page string) *request.Request {
+        return request.NewRequest(api, "json").SetForm(url.Values{"q": {q}, "page": {page}, "ts": {q + page}})
+    }
+    json := func(body string) *request.Request {
+        return request.NewRequest(api, "json").SetMethod("POST").SetBody(body, "application/json; charset=utf-8")
+    }
+    f := scheduler.DefaultFingerprint
+    if f(form("go", "1")) != f(request.NewRequest(api, "json").SetMethod("POST").
+        SetBody("ts=go1&page=1&q=go", "application/x-www-form-urlencoded")) {
+        t.Error("forms of params in another order should be duplicate")
+    }
+    if f(form("go", "1")) == f(form("go", "2")) || f(form("go", "1")) == f(get) {
+        t.Error("requests of different body should not be duplicate")
+    }
+    if f(json(`{"q":"go","page":1}`)) != f(json(`{"page":1, "q":"go"}`)) || f(json(`{"q":"go"}`)) == f(json(`{"q":"js"}`)) {
+        t.Error("json bodies should be compared by values")
+    }
+    if f(request.NewRequest(api, "json").SetMethod("DELETE")) == f(request.NewRequest(api, "json")) {
+        t.Error("method should be in fingerprint")
+    }
+    if f(request.NewRequest(api, "json").SetSession("a")) == f(request.NewRequest(api, "json").SetSession("b")) {
+        t.Error("requests of different sessions should not be duplicate")
+    }
+
+    custom := scheduler.NewRequestFingerprinter().IgnoreBodyFields("ts").IncludeHeaders("Authorization").
+        SetUrlFunc(scheduler.NewUrlCanonicalizer().Canonicalize)
+    a := form("go", "1").SetHeader("Authorization", "user-a").SetReferer("x")
+    b := request.NewRequest(api+"?utm_source=x", "json").SetForm(url.Values{"q": {"go"}, "page": {"1"}, "ts": {"other"}}).
+        SetHeader("Authorization", "user-a")
+    if custom.Fingerprint(a) != custom.Fingerprint(b) {
+        t.Errorf("ignored fields should not be in fingerprint: %s %s", custom.Fingerprint(a), custom.Fingerprint(b))
+    }
+    if custom.Fingerprint(a) == custom.Fingerprint(b.SetHeader("Authorization", "user-b")) {
+        t.Error("included header should be in fingerprint")
+    }
+
+    s := scheduler.NewQueueScheduler(true)
+    s.Push(form("go", "1"))
+    s.Push(form("go", "1"))
+    s.Push(form("go", "2"))
+    if s.Count() != 2 {
+        t.Errorf("duplicate POST requests should be removed: %d", s.Count())
+    }
+}
@@ -0,0 +1,130 @@
+package remote
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The logger writes logs of this package, whose level is set by mlog.SetLevel("scheduler", level).
+var logger = mlog.Component("scheduler")
+
+// Client is the Scheduler of a spider that pushes and polls requests of Server at addr.
+// It is also a scheduler.DoneScheduler that tells Server when a polled request is crawled.
+// Errors of Server are logged, and Poll returns nil when Server can not be reached.
+type Client struct {
+    addr   string
+    client *http.Client
+
+    // The ids saves ids of requests polled and not done yet.
+    locker sync.Mutex
+    ids    map[*request.Request]string
+}
+
+// NewClient returns Client of Server at addr like "http://10.0.0.1:8899".
+func NewClient(addr string) *Client {
+    return &Client{
+        addr:   strings.TrimRight(addr, "/"),
+        client: &http.Client{Timeout: 30 * time.Second},
+        ids:    make(map[*request.Request]string),
+    }
+}
+
+func (this *Client) post(path string, contentType string, body []byte) (*http.Response, error) {
+    resp, err := this.client.Post(this.addr+path, contentType, bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+        resp.Body.Close()
+        return nil, errors.New("remote scheduler " + path + " error : http status " + strconv.Itoa(resp.StatusCode))
+    }
+    return resp, nil
+}
+
+func (this *Client) Push(requ *request.Request) {
+    this.push("/push", requ)
+}
+
+// Requeue pushes the request polled before, which is not removed as duplicate if Scheduler of Server
+// is a scheduler.RequeueScheduler.
+func (this *Client) Requeue(requ *request.Request) {
+    this.push("/requeue", requ)
+}
+
+func (this *Client) push(path string, requ *request.Request) {
+    body, err := json.Marshal([]*request.Request{requ})
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    resp, err := this.post(path, "application/json", body)
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    resp.Body.Close()
+}
+
+func (this *Client) Poll() *request.Request {
+    resp, err := this.post("/poll", "application/json", nil)
+    if err != nil {
+        logger.Error(err.Error())
+        return nil
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode == http.StatusNoContent {
+        return nil
+    }
+    var polled polledRequest
+    if err = json.NewDecoder(resp.Body).Decode(&polled); err != nil || polled.Request == nil {
+        logger.Error("remote scheduler poll responce broken")
+        return nil
+    }
+    this.locker.Lock()
+    this.ids[polled.Request] = polled.Id
+    this.locker.Unlock()
+    return polled.Request
+}
+
+// Done tells Server that the request polled by this client is crawled.
+func (this *Client) Done(requ *request.Request) {
+    this.locker.Lock()
+    id, ok := this.ids[requ]
+    delete(this.ids, requ)
+    this.locker.Unlock()
+    if !ok {
+        return
+    }
+    resp, err := this.post("/done", "application/x-www-form-urlencoded", []byte(url.Values{"id": {id}}.Encode()))
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    resp.Body.Close()
+}
+
+func (this *Client) Count() int {
+    resp, err := this.client.Get(this.addr + "/count")
+    if err != nil {
+        logger.Error(err.Error())
+        return 0
+    }
+    defer resp.Body.Close()
+    var result struct {
+        Count int `json:"count"`
+    }
+    if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+        logger.Error("remote scheduler count responce broken")
+        return 0
+    }
+    return result.Count
+}
@@ -0,0 +1,96 @@
+package remote_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "github.com/hu17889/go_spider/core/scheduler/remote"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestRemoteScheduler(t *testing.T) {
+    qs := scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+    ts := httptest.NewServer(remote.NewServer(qs))
+    defer ts.Close()
+
+    var a, b scheduler.DoneScheduler = remote.NewClient(ts.URL), remote.NewClient(ts.URL + "/")
+    a.Push(request.NewRequest("http://baidu.com", "html").SetMeta("depth", 1))
+    b.Push(request.NewRequest("http://baidu.com/", "html"))
+    b.Push(request.NewRequest("http://qq.com", "json"))
+    if a.Count() != 2 {
+        t.Fatalf("count error: %d", a.Count())
+    }
+
+    r := b.Poll()
+    if r == nil || r.GetUrl() != "http://baidu.com" || r.GetResponceType() != "html" {
+        t.Fatal("poll error")
+    }
+    if depth, _ := r.GetMeta("depth"); depth != float64(1) {
+        t.Error("meta error")
+    }
+    b.Done(r)
+    if r = a.Poll(); r == nil || r.GetUrl() != "http://qq.com" {
+        t.Fatal("poll error")
+    }
+    if a.Poll() != nil || b.Count() != 1 {
+        t.Error("only the request being crawled should be left")
+    }
+    a.Done(r)
+    if b.Count() != 0 {
+        t.Error("scheduler should be empty")
+    }
+}
+
+func TestRemoteVisibilityTimeout(t *testing.T) {
+    qs := scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+    ts := httptest.NewServer(remote.NewServer(qs).SetVisibilityTimeout(50 * time.Millisecond))
+    defer ts.Close()
+
+    a, b := remote.NewClient(ts.URL), remote.NewClient(ts.URL)
+    a.Push(request.NewRequest("http://baidu.com", "html"))
+    if r := a.Poll(); r == nil || a.Count() != 1 {
+        t.Fatalf("request being crawled should be counted: %v %d", r, a.Count())
+    }
+    if b.Poll() != nil {
+        t.Fatal("request being crawled should not be polled")
+    }
+
+    // the request of a crashed client is polled again after visibility timeout
+    time.Sleep(100 * time.Millisecond)
+    r := b.Poll()
+    if r == nil || r.GetUrl() != "http://baidu.com" {
+        t.Fatalf("expired request should be polled again: %v", r)
+    }
+    b.Done(r)
+    if b.Count() != 0 {
+        t.Errorf("done request should not be counted: %d", b.Count())
+    }
+}
+
+func TestRemoteServerRequests(t *testing.T) {
+    ts := httptest.NewServer(remote.NewServer(scheduler.NewQueueScheduler(false)))
+    defer ts.Close()
+
+    for _, path := range []string{"/push", "/requeue", "/poll", "/done"} {
+        resp, err := http.Get(ts.URL + path)
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusMethodNotAllowed {
+            t.Errorf("GET %s should be refused: %d", path, resp.StatusCode)
+        }
+    }
+    body := `[{"url":"http://a.com/` + strings.Repeat("a", 33<<20) + `"}]`
+    resp, err := http.Post(ts.URL+"/push", "application/json", strings.NewReader(body))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusRequestEntityTooLarge {
+        t.Errorf("large body should be refused: %d", resp.StatusCode)
+    }
+}
@@ -0,0 +1,172 @@
+// Package remote shares a Scheduler between spiders on different machines over http.
+// Run Server with the Scheduler in one process, and use Client as Scheduler of each spider.
+// For a Scheduler that needs no server process, see scheduler.RedisScheduler.
+package remote
+
+import (
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// The polledRequest is responce of poll, which has id of the request for done.
+type polledRequest struct {
+    Id      string           `json:"id"`
+    Request *request.Request `json:"request"`
+}
+
+// The maxBodySize limits bodies of requests to Server.
+const maxBodySize = 32 << 20
+
+// Server serves the Scheduler over http with paths "/push", "/requeue", "/poll", "/done" and "/count".
+// Requests pushed by all the clients are removed duplicate by the Scheduler, like QueueScheduler with
+// a Deduplicator. Callbacks of requests are not sent over http. A request polled but not done before
+// visibility timeout is pushed again, by Requeue if the Scheduler is a scheduler.RequeueScheduler so it is
+// not removed as duplicate, and polled by other clients, so requests of a crashed spider are crawled by
+// others. All the paths but "/count" take POST requests only.
+type Server struct {
+    s scheduler.Scheduler
+
+    // The polled saves requests polled by clients and not done yet, by their ids.
+    locker            sync.Mutex
+    nextId            uint64
+    polled            map[string]*polledEntry
+    visibilityTimeout time.Duration
+}
+
+// The polledEntry is a request polled by a client, which is pushed again after its deadline.
+type polledEntry struct {
+    req      *request.Request
+    deadline time.Time
+}
+
+// NewServer returns Server of the Scheduler.
+func NewServer(s scheduler.Scheduler) *Server {
+    return &Server{s: s, polled: make(map[string]*polledEntry), visibilityTimeout: 10 * time.Minute}
+}
+
+// SetVisibilityTimeout sets how long a polled request can be crawled before it is pushed again.
+// Default is 10 minutes.
+func (this *Server) SetVisibilityTimeout(timeout time.Duration) *Server {
+    this.locker.Lock()
+    this.visibilityTimeout = timeout
+    this.locker.Unlock()
+    return this
+}
+
+func (this *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    switch r.URL.Path {
+    case "/push", "/requeue", "/poll", "/done":
+        if r.Method != "POST" {
+            w.Header().Set("Allow", "POST")
+            http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+    }
+    switch r.URL.Path {
+    case "/push", "/requeue":
+        var reqs []*request.Request
+        if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+            bodyError(w, err)
+            return
+        }
+        s, requeue := this.s.(scheduler.RequeueScheduler)
+        requeue = requeue && r.URL.Path == "/requeue"
+        for _, req := range reqs {
+            if req == nil || req.GetUrl() == "" {
+                continue
+            }
+            if requeue {
+                s.Requeue(req)
+            } else {
+                this.s.Push(req)
+            }
+        }
+        w.WriteHeader(http.StatusNoContent)
+    case "/poll":
+        this.requeueExpired()
+        req := this.s.Poll()
+        if req == nil {
+            w.WriteHeader(http.StatusNoContent)
+            return
+        }
+        this.locker.Lock()
+        this.nextId++
+        id := strconv.FormatUint(this.nextId, 10)
+        this.polled[id] = &polledEntry{req: req, deadline: time.Now().Add(this.visibilityTimeout)}
+        this.locker.Unlock()
+        this.writeJson(w, &polledRequest{Id: id, Request: req})
+    case "/done":
+        if err := r.ParseForm(); err != nil {
+            bodyError(w, err)
+            return
+        }
+        id := r.FormValue("id")
+        this.locker.Lock()
+        entry, ok := this.polled[id]
+        delete(this.polled, id)
+        this.locker.Unlock()
+        if s, isDone := this.s.(scheduler.DoneScheduler); ok && isDone {
+            s.Done(entry.req)
+        }
+        w.WriteHeader(http.StatusNoContent)
+    case "/count":
+        this.requeueExpired()
+        this.locker.Lock()
+        inflight := len(this.polled)
+        this.locker.Unlock()
+        // requests being crawled are counted, so spiders do not exit while they may be pushed again
+        this.writeJson(w, map[string]int{"count": this.s.Count() + inflight})
+    default:
+        http.NotFound(w, r)
+    }
+}
+
+// The requeueExpired pushes requests polled and not done before their deadlines again.
+func (this *Server) requeueExpired() {
+    now := time.Now()
+    var expired []*request.Request
+    this.locker.Lock()
+    for id, entry := range this.polled {
+        if now.After(entry.deadline) {
+            expired = append(expired, entry.req)
+            delete(this.polled, id)
+        }
+    }
+    this.locker.Unlock()
+    for _, req := range expired {
+        logger.Warn("remote scheduler request is not done before visibility timeout", mlog.F("url", req.GetUrl()))
+        if s, ok := this.s.(scheduler.DoneScheduler); ok {
+            s.Done(req)
+        }
+        if s, ok := this.s.(scheduler.RequeueScheduler); ok {
+            s.Requeue(req)
+        } else {
+            this.s.Push(req)
+        }
+    }
+}
+
+// The bodyError writes error of reading body of the request.
+func bodyError(w http.ResponseWriter, err error) {
+    var tooLarge *http.MaxBytesError
+    if errors.As(err, &tooLarge) {
+        http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+        return
+    }
+    http.Error(w, err.Error(), http.StatusBadRequest)
+}
+
+func (this *Server) writeJson(w http.ResponseWriter, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    if err := json.NewEncoder(w).Encode(v); err != nil {
+        logger.Error(err.Error())
+    }
+}
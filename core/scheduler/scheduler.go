@@ -2,15 +2,67 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-//
 package scheduler
 
 import (
+    "github.com/hu17889/go_spider/core/common/mlog"
     "github.com/hu17889/go_spider/core/common/request"
+    "time"
 )
 
+// The logger writes logs of this package, whose level is set by mlog.SetLevel("scheduler", level).
+var logger = mlog.Component("scheduler")
+
 type Scheduler interface {
     Push(requ *request.Request)
     Poll() *request.Request
     Count() int
 }
+
+// The InspectableScheduler interface is Scheduler whose requests can be looked at without crawling them.
+// Function Peek returns the next request without removing it, or nil if it is empty.
+// Function Drain removes and returns all the requests, for saving them when Spider is stopped.
+// Function Snapshot returns all the requests without removing them, for saving checkpoint.
+type InspectableScheduler interface {
+    Scheduler
+    Peek() *request.Request
+    Drain() []*request.Request
+    Snapshot() []*request.Request
+}
+
+// The FingerprintScheduler interface is Scheduler that removes duplicate requests by their fingerprints.
+// Function SetFingerprint sets function that returns the fingerprint of a request.
+// Requests of the same fingerprint are duplicate.
+type FingerprintScheduler interface {
+    Scheduler
+    SetFingerprint(f func(*request.Request) string)
+}
+
+// The DoneScheduler interface is Scheduler that needs to know when a polled request is crawled,
+// like RedisScheduler that polls the request again if it is not done in time.
+type DoneScheduler interface {
+    Scheduler
+    Done(requ *request.Request)
+}
+
+// The DeduplicatorScheduler interface is Scheduler that removes requests pushed before by a Deduplicator.
+// Function GetDeduplicator returns the Deduplicator, or nil if it is not set.
+type DeduplicatorScheduler interface {
+    Scheduler
+    GetDeduplicator() Deduplicator
+}
+
+// The RequeueScheduler interface is Scheduler that takes back a request polled before, like a request to be
+// retried. Function Requeue pushes the request without removing it as duplicate of itself.
+type RequeueScheduler interface {
+    Scheduler
+    Requeue(requ *request.Request)
+}
+
+// The TimedScheduler interface is Scheduler that holds requests until their fetch time, like PoliteScheduler
+// spacing requests of each host. Poll returns nil while all the requests wait, and Spider keeps running.
+// Function NextTime returns the earliest fetch time of requests, or zero time if it is empty.
+type TimedScheduler interface {
+    Scheduler
+    NextTime() time.Time
+}
@@ -0,0 +1,255 @@
+package scheduler
+
+import (
+    "bytes"
+    "crypto/md5"
+    "encoding/binary"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/request"
+    "go.etcd.io/bbolt"
+    "sync"
+    "time"
+)
+
+var (
+    boltQueueBucket = []byte("queue")
+    boltSeenBucket  = []byte("seen")
+)
+
+// The BoltScheduler saves requests in a BoltDB file, so frontiers of tens of millions of requests do not
+// need to fit in memory, and the crawl is continued from the file after a crash or restart.
+// Requests are saved in bucket "queue" in order of Push, and md5 of fingerprints of pushed requests are saved
+// in bucket "seen". Concurrent pushes are written in batched transactions, and requests are read ahead
+// in batches. A polled request is removed from the file when it is done, so requests being crawled when
+// the process crashes are polled again after restart.
+type BoltScheduler struct {
+    db *bbolt.DB
+
+    // The rm is whether duplicate requests are removed by fingerprints in bucket "seen".
+    rm          bool
+    fingerprint func(*request.Request) string
+
+    locker    sync.Mutex
+    readBatch int
+    // The buffer saves requests read ahead, and cursor is key of the last request read.
+    buffer []boltItem
+    cursor []byte
+    // The count is number of requests in the file that are not polled.
+    count int
+    // The polled saves keys of requests being crawled, for removing them when done.
+    polled map[*request.Request][]byte
+}
+
+type boltItem struct {
+    key  []byte
+    requ *request.Request
+}
+
+// NewBoltScheduler opens or creates BoltDB file of the path. Requests left in the file are polled first.
+// The file can not be opened by other processes until Close is called.
+func NewBoltScheduler(path string, rmDuplicate bool) (*BoltScheduler, error) {
+    db, err := bbolt.Open(path, 0644, &bbolt.Options{Timeout: time.Second})
+    if err != nil {
+        return nil, err
+    }
+    count := 0
+    err = db.Update(func(tx *bbolt.Tx) error {
+        queue, err := tx.CreateBucketIfNotExists(boltQueueBucket)
+        if err != nil {
+            return err
+        }
+        if _, err = tx.CreateBucketIfNotExists(boltSeenBucket); err != nil {
+            return err
+        }
+        count = queue.Stats().KeyN
+        return nil
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &BoltScheduler{
+        db:          db,
+        rm:          rmDuplicate,
+        fingerprint: DefaultFingerprint,
+        readBatch:   100,
+        count:       count,
+        polled:      make(map[*request.Request][]byte),
+    }, nil
+}
+
+// SetFingerprint sets function that returns the fingerprint of request for removing duplicate.
+// Default is DefaultFingerprint. It should not be changed for an existing file.
+func (this *BoltScheduler) SetFingerprint(f func(*request.Request) string) {
+    this.locker.Lock()
+    this.fingerprint = f
+    this.locker.Unlock()
+}
+
+// SetReadBatch sets how many requests are read from the file at once. Default is 100.
+func (this *BoltScheduler) SetReadBatch(n int) *BoltScheduler {
+    this.locker.Lock()
+    if n > 0 {
+        this.readBatch = n
+    }
+    this.locker.Unlock()
+    return this
+}
+
+// Close closes the file. Requests being crawled are kept in the file and polled again when it is opened.
+func (this *BoltScheduler) Close() error {
+    return this.db.Close()
+}
+
+// Push saves the request to the file. If duplicate requests are removed, a request whose fingerprint
+// has been pushed is ignored, even if it has been crawled already.
+func (this *BoltScheduler) Push(requ *request.Request) {
+    this.push(requ, true)
+}
+
+// Requeue saves the request polled before again, which is not removed as duplicate of itself.
+func (this *BoltScheduler) Requeue(requ *request.Request) {
+    this.push(requ, false)
+}
+
+func (this *BoltScheduler) push(requ *request.Request, dedup bool) {
+    item, err := json.Marshal(requ)
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    this.locker.Lock()
+    key := md5.Sum([]byte(this.fingerprint(requ)))
+    // the request polled before is saved under a new key, so the old one is removed
+    old := this.polled[requ]
+    delete(this.polled, requ)
+    this.locker.Unlock()
+
+    added := false
+    err = this.db.Batch(func(tx *bbolt.Tx) error {
+        added = false
+        if this.rm && dedup {
+            seen := tx.Bucket(boltSeenBucket)
+            if seen.Get(key[:]) != nil {
+                return nil
+            }
+            if err := seen.Put(key[:], []byte{}); err != nil {
+                return err
+            }
+        }
+        queue := tx.Bucket(boltQueueBucket)
+        if old != nil {
+            if err := queue.Delete(old); err != nil {
+                return err
+            }
+        }
+        seq, err := queue.NextSequence()
+        if err != nil {
+            return err
+        }
+        added = true
+        return queue.Put(boltKey(seq), item)
+    })
+    if err != nil {
+        logger.Error("bolt push error : " + err.Error())
+        return
+    }
+    if added {
+        this.locker.Lock()
+        this.count++
+        this.locker.Unlock()
+    }
+}
+
+func boltKey(seq uint64) []byte {
+    key := make([]byte, 8)
+    binary.BigEndian.PutUint64(key, seq)
+    return key
+}
+
+// Poll returns the earliest request that is not polled, or nil if there is none.
+// The request is kept in the file until Done is called.
+func (this *BoltScheduler) Poll() *request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if len(this.buffer) == 0 {
+        this.readAhead()
+    }
+    if len(this.buffer) == 0 {
+        return nil
+    }
+    item := this.buffer[0]
+    this.buffer = this.buffer[1:]
+    this.polled[item.requ] = item.key
+    this.count--
+    return item.requ
+}
+
+// The readAhead reads a batch of requests after cursor into buffer. It is called with locker held.
+func (this *BoltScheduler) readAhead() {
+    var broken [][]byte
+    err := this.db.View(func(tx *bbolt.Tx) error {
+        c := tx.Bucket(boltQueueBucket).Cursor()
+        var k, v []byte
+        if this.cursor == nil {
+            k, v = c.First()
+        } else if k, v = c.Seek(this.cursor); bytes.Equal(k, this.cursor) {
+            k, v = c.Next()
+        }
+        for ; k != nil && len(this.buffer) < this.readBatch; k, v = c.Next() {
+            key := append([]byte(nil), k...)
+            this.cursor = key
+            requ := &request.Request{}
+            if err := json.Unmarshal(v, requ); err != nil {
+                logger.Error("bolt request broken : " + string(v))
+                broken = append(broken, key)
+                continue
+            }
+            this.buffer = append(this.buffer, boltItem{key: key, requ: requ})
+        }
+        return nil
+    })
+    if err != nil {
+        logger.Error("bolt poll error : " + err.Error())
+    }
+    if len(broken) > 0 {
+        this.count -= len(broken)
+        this.delete(broken...)
+    }
+}
+
+// Done removes the request polled by this scheduler from the file, after it is crawled.
+func (this *BoltScheduler) Done(requ *request.Request) {
+    this.locker.Lock()
+    key, ok := this.polled[requ]
+    delete(this.polled, requ)
+    this.locker.Unlock()
+    if ok {
+        this.delete(key)
+    }
+}
+
+func (this *BoltScheduler) delete(keys ...[]byte) {
+    err := this.db.Batch(func(tx *bbolt.Tx) error {
+        queue := tx.Bucket(boltQueueBucket)
+        for _, key := range keys {
+            if err := queue.Delete(key); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        logger.Error("bolt done error : " + err.Error())
+    }
+}
+
+// Count returns number of requests that are not polled.
+func (this *BoltScheduler) Count() int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.count < 0 {
+        return 0
+    }
+    return this.count
+}
@@ -0,0 +1,67 @@
+package scheduler_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestBoltScheduler(t *testing.T) {
+    dir, err := ioutil.TempDir("", "bolt")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "frontier.db")
+
+    s, err := scheduler.NewBoltScheduler(path, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s.SetReadBatch(2)
+    for _, u := range []string{"http://a.com/1", "http://a.com/2", "http://a.com/3", "http://a.com/1"} {
+        s.Push(request.NewRequest(u, "html").SetMeta("u", u))
+    }
+    if s.Count() != 3 {
+        t.Fatalf("duplicate request should be removed: %d", s.Count())
+    }
+    first := s.Poll()
+    second := s.Poll()
+    if first.GetUrl() != "http://a.com/1" || second.GetUrl() != "http://a.com/2" || s.Count() != 1 {
+        t.Fatalf("wrong poll: %s %s %d", first.GetUrl(), second.GetUrl(), s.Count())
+    }
+    if v, _ := first.GetMeta("u"); v != "http://a.com/1" {
+        t.Errorf("meta should be saved: %v", v)
+    }
+    s.Done(first)
+    s.Close()
+
+    // the request not done is polled again after restart, and fingerprints are kept
+    s, err = scheduler.NewBoltScheduler(path, true)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+    s.Push(request.NewRequest("http://a.com/1", "html"))
+    if s.Count() != 2 {
+        t.Fatalf("requests left should be loaded: %d", s.Count())
+    }
+    var urls []string
+    for r := s.Poll(); r != nil; r = s.Poll() {
+        urls = append(urls, r.GetUrl())
+        if len(urls) == 1 {
+            s.Requeue(r)
+        } else {
+            s.Done(r)
+        }
+    }
+    if len(urls) != 3 || urls[0] != "http://a.com/2" || urls[1] != "http://a.com/3" || urls[2] != "http://a.com/2" {
+        t.Errorf("wrong order: %v", urls)
+    }
+    if s.Count() != 0 {
+        t.Errorf("wrong count: %d", s.Count())
+    }
+}
@@ -0,0 +1,226 @@
+package scheduler
+
+import (
+    "container/heap"
+    "github.com/hu17889/go_spider/core/common/request"
+    "sort"
+    "sync"
+    "time"
+)
+
+// The DelayScheduler holds requests until their time of Request.SetNotBefore, like a request retried in
+// 10 minutes or crawled at 3am, and then pushes them to the Scheduler it wraps, which polls them as usual.
+// Requests of no time or a past one are pushed at once. The waiting requests are kept in a heap by their
+// time, so Poll only looks at the earliest one. Spider keeps running while requests wait.
+// Requests waiting are in memory; they are saved with SetPendingRequestFile or checkpoint by Drain and
+// Snapshot, and their time is kept.
+type DelayScheduler struct {
+    locker sync.Mutex
+    inner  Scheduler
+    queue  delayQueue
+
+    // The seq is order of Push for requests of the same time.
+    seq uint64
+}
+
+// The delayItem is a request waiting in the heap. The requeue is whether it is pushed to the inner
+// Scheduler by Requeue.
+type delayItem struct {
+    req     *request.Request
+    seq     uint64
+    requeue bool
+}
+
+// The delayQueue implements heap.Interface.
+type delayQueue []delayItem
+
+func (this delayQueue) Len() int {
+    return len(this)
+}
+
+func (this delayQueue) Less(i, j int) bool {
+    ti, tj := this[i].req.GetNotBefore(), this[j].req.GetNotBefore()
+    if !ti.Equal(tj) {
+        return ti.Before(tj)
+    }
+    return this[i].seq < this[j].seq
+}
+
+func (this delayQueue) Swap(i, j int) {
+    this[i], this[j] = this[j], this[i]
+}
+
+func (this *delayQueue) Push(x interface{}) {
+    *this = append(*this, x.(delayItem))
+}
+
+func (this *delayQueue) Pop() interface{} {
+    old := *this
+    item := old[len(old)-1]
+    *this = old[:len(old)-1]
+    return item
+}
+
+// NewDelayScheduler returns DelayScheduler pushing requests to inner when their time comes, like
+// NewDelayScheduler(NewPriorityScheduler(true)).
+func NewDelayScheduler(inner Scheduler) *DelayScheduler {
+    return &DelayScheduler{inner: inner}
+}
+
+// GetScheduler returns the Scheduler wrapped by the DelayScheduler.
+func (this *DelayScheduler) GetScheduler() Scheduler {
+    return this.inner
+}
+
+func (this *DelayScheduler) Push(requ *request.Request) {
+    this.push(requ, false)
+}
+
+// Requeue pushes the request polled before, which is pushed by Requeue of the inner Scheduler if it is a
+// RequeueScheduler when its time comes.
+func (this *DelayScheduler) Requeue(requ *request.Request) {
+    this.push(requ, true)
+}
+
+func (this *DelayScheduler) push(requ *request.Request, requeue bool) {
+    if requ.GetNotBefore().After(time.Now()) {
+        this.locker.Lock()
+        this.seq++
+        heap.Push(&this.queue, delayItem{req: requ, seq: this.seq, requeue: requeue})
+        this.locker.Unlock()
+        return
+    }
+    this.pushInner(requ, requeue)
+}
+
+// The pushInner pushes the request to the inner Scheduler.
+func (this *DelayScheduler) pushInner(requ *request.Request, requeue bool) {
+    if s, ok := this.inner.(RequeueScheduler); ok && requeue {
+        s.Requeue(requ)
+    } else {
+        this.inner.Push(requ)
+    }
+}
+
+// The due removes and returns requests whose time has come.
+func (this *DelayScheduler) due(now time.Time) []delayItem {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    var items []delayItem
+    for this.queue.Len() > 0 && !this.queue[0].req.GetNotBefore().After(now) {
+        items = append(items, heap.Pop(&this.queue).(delayItem))
+    }
+    return items
+}
+
+// Poll pushes requests whose time has come to the inner Scheduler, and polls it.
+func (this *DelayScheduler) Poll() *request.Request {
+    for _, item := range this.due(time.Now()) {
+        this.pushInner(item.req, item.requeue)
+    }
+    return this.inner.Poll()
+}
+
+// Count returns number of requests in the inner Scheduler and requests waiting.
+func (this *DelayScheduler) Count() int {
+    this.locker.Lock()
+    n := this.queue.Len()
+    this.locker.Unlock()
+    return n + this.inner.Count()
+}
+
+// The NextTime returns the earliest time of requests waiting, or of requests of the inner Scheduler if
+// it is a TimedScheduler. It returns zero time if no request waits.
+func (this *DelayScheduler) NextTime() time.Time {
+    var next time.Time
+    if s, ok := this.inner.(TimedScheduler); ok {
+        next = s.NextTime()
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.queue.Len() > 0 {
+        if t := this.queue[0].req.GetNotBefore(); next.IsZero() || t.Before(next) {
+            next = t
+        }
+    }
+    return next
+}
+
+// Peek returns the request that the inner Scheduler will poll next, or the earliest request waiting if
+// the inner Scheduler is empty. It returns nil if the inner Scheduler is not an InspectableScheduler.
+func (this *DelayScheduler) Peek() *request.Request {
+    s, ok := this.inner.(InspectableScheduler)
+    if !ok {
+        return nil
+    }
+    if requ := s.Peek(); requ != nil {
+        return requ
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.queue.Len() == 0 {
+        return nil
+    }
+    return this.queue[0].req
+}
+
+// Snapshot returns requests of the inner Scheduler and then requests waiting by their time, without
+// removing them. Requests of the inner Scheduler are left out if it is not an InspectableScheduler.
+func (this *DelayScheduler) Snapshot() []*request.Request {
+    var reqs []*request.Request
+    if s, ok := this.inner.(InspectableScheduler); ok {
+        reqs = s.Snapshot()
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return append(reqs, this.list()...)
+}
+
+func (this *DelayScheduler) list() []*request.Request {
+    items := make(delayQueue, len(this.queue))
+    copy(items, this.queue)
+    sort.Sort(items)
+    reqs := make([]*request.Request, 0, len(items))
+    for _, item := range items {
+        reqs = append(reqs, item.req)
+    }
+    return reqs
+}
+
+// Drain removes all the requests and returns requests of the inner Scheduler and then requests waiting
+// by their time. Requests of the inner Scheduler are left in it if it is not an InspectableScheduler.
+func (this *DelayScheduler) Drain() []*request.Request {
+    var reqs []*request.Request
+    if s, ok := this.inner.(InspectableScheduler); ok {
+        reqs = s.Drain()
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    reqs = append(reqs, this.list()...)
+    this.queue = nil
+    return reqs
+}
+
+// SetFingerprint sets the fingerprint function to the inner Scheduler if it is a FingerprintScheduler.
+func (this *DelayScheduler) SetFingerprint(f func(*request.Request) string) {
+    if s, ok := this.inner.(FingerprintScheduler); ok {
+        s.SetFingerprint(f)
+    } else {
+        logger.Error("scheduler does not support request fingerprint")
+    }
+}
+
+// GetDeduplicator returns Deduplicator of the inner Scheduler, or nil.
+func (this *DelayScheduler) GetDeduplicator() Deduplicator {
+    if s, ok := this.inner.(DeduplicatorScheduler); ok {
+        return s.GetDeduplicator()
+    }
+    return nil
+}
+
+// Done tells the inner Scheduler that the request is crawled if it is a DoneScheduler.
+func (this *DelayScheduler) Done(requ *request.Request) {
+    if s, ok := this.inner.(DoneScheduler); ok {
+        s.Done(requ)
+    }
+}
@@ -0,0 +1,285 @@
+package scheduler
+
+import (
+    "container/heap"
+    "crypto/md5"
+    "github.com/hu17889/go_spider/core/common/request"
+    "sort"
+    "strings"
+    "sync"
+)
+
+// The FairPolicy is how FairScheduler dispatches requests of different hosts.
+type FairPolicy int
+
+const (
+    // The PolicyFIFO polls all the requests by priority and in order of Push, like PriorityScheduler.
+    PolicyFIFO FairPolicy = iota
+    // The PolicyRoundRobin polls one request of each host in turn, so a host of many requests does not keep
+    // all the workers busy while other hosts wait.
+    PolicyRoundRobin
+    // The PolicyWeighted polls requests of each host in turn, as many in a turn as weight of its domain.
+    PolicyWeighted
+)
+
+// The FairScheduler dispatches requests of hosts by its FairPolicy, so a broad crawl interleaves hosts
+// instead of draining them one by one. Requests of a host are polled by priority and in order of Push.
+// Hosts take turns in order of their first request; a host without requests leaves the turns until it has
+// requests again.
+type FairScheduler struct {
+    locker sync.Mutex
+    rm     bool
+    rmKey  map[[md5.Size]byte]bool
+    policy FairPolicy
+
+    // The hosts are requests of each host, and turns are hosts with requests in order of their turn.
+    hosts map[string]*fairHost
+    turns []string
+    turn  int
+    count int
+
+    // The seq is order of Push for requests of the same priority.
+    seq uint64
+
+    // The weights are weights of domains for PolicyWeighted.
+    weights map[string]int
+
+    // The fingerprint returns key of request for removing duplicate.
+    fingerprint func(*request.Request) string
+
+    // The dedup removes requests pushed before, even if they have been polled.
+    dedup Deduplicator
+}
+
+// The fairHost is requests of a host, and how many of them are polled in its turn by PolicyWeighted.
+type fairHost struct {
+    queue  priorityQueue
+    weight int
+    credit int
+}
+
+// NewFairScheduler returns FairScheduler of the policy. Duplicate requests are removed if rmDuplicate is true.
+func NewFairScheduler(policy FairPolicy, rmDuplicate bool) *FairScheduler {
+    return &FairScheduler{rm: rmDuplicate, rmKey: make(map[[md5.Size]byte]bool), policy: policy,
+        hosts: make(map[string]*fairHost), weights: make(map[string]int), fingerprint: DefaultFingerprint}
+}
+
+// The SetPolicy changes the policy. Requests in the scheduler are dispatched by the new policy.
+func (this *FairScheduler) SetPolicy(policy FairPolicy) *FairScheduler {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if policy == this.policy {
+        return this
+    }
+    items := this.items()
+    this.policy = policy
+    this.reset()
+    for _, item := range items {
+        this.add(item)
+    }
+    return this
+}
+
+func (this *FairScheduler) GetPolicy() FairPolicy {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.policy
+}
+
+// The SetWeight sets weight of the domain and its subdomains for PolicyWeighted, like 5 for "example.com"
+// so 5 requests of "www.example.com" are polled in its turn. Weight of the longest domain matching a host is
+// used, and default weight is 1.
+func (this *FairScheduler) SetWeight(domain string, weight int) *FairScheduler {
+    if weight < 1 {
+        weight = 1
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.weights[strings.ToLower(strings.TrimPrefix(domain, "."))] = weight
+    for name, h := range this.hosts {
+        h.weight = this.weightOf(name)
+    }
+    return this
+}
+
+// The weightOf returns weight of the host by weights of its domains.
+func (this *FairScheduler) weightOf(host string) int {
+    if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.Contains(host[i:], "]") {
+        host = host[:i]
+    }
+    for domain := host; domain != ""; {
+        if w, ok := this.weights[domain]; ok {
+            return w
+        }
+        i := strings.IndexByte(domain, '.')
+        if i < 0 {
+            break
+        }
+        domain = domain[i+1:]
+    }
+    return 1
+}
+
+// SetFingerprint sets function that returns the fingerprint of request for removing duplicate.
+// Default is DefaultFingerprint.
+func (this *FairScheduler) SetFingerprint(f func(*request.Request) string) {
+    this.locker.Lock()
+    this.fingerprint = f
+    this.locker.Unlock()
+}
+
+// SetDeduplicator sets Deduplicator that removes requests whose fingerprints have been pushed before.
+func (this *FairScheduler) SetDeduplicator(d Deduplicator) *FairScheduler {
+    this.locker.Lock()
+    this.dedup = d
+    this.locker.Unlock()
+    return this
+}
+
+func (this *FairScheduler) GetDeduplicator() Deduplicator {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.dedup
+}
+
+func (this *FairScheduler) key(requ *request.Request) [md5.Size]byte {
+    return md5.Sum([]byte(this.fingerprint(requ)))
+}
+
+func (this *FairScheduler) Push(requ *request.Request) {
+    this.push(requ, true)
+}
+
+// Requeue pushes the request polled before, which is not removed by Deduplicator.
+func (this *FairScheduler) Requeue(requ *request.Request) {
+    this.push(requ, false)
+}
+
+func (this *FairScheduler) push(requ *request.Request, dedup bool) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if dedup && this.dedup != nil && this.dedup.Seen(this.fingerprint(requ)) {
+        return
+    }
+    if this.rm {
+        key := this.key(requ)
+        if this.rmKey[key] {
+            return
+        }
+        this.rmKey[key] = true
+    }
+    this.seq++
+    this.add(priorityItem{req: requ, seq: this.seq})
+}
+
+// The add adds the item to queue of its host, which takes the last turn if it had no requests.
+func (this *FairScheduler) add(item priorityItem) {
+    name := ""
+    if this.policy != PolicyFIFO {
+        name = politeHostName(item.req.GetUrl())
+    }
+    h := this.hosts[name]
+    if h == nil {
+        h = &fairHost{weight: this.weightOf(name)}
+        this.hosts[name] = h
+        this.turns = append(this.turns, name)
+    }
+    heap.Push(&h.queue, item)
+    this.count++
+}
+
+func (this *FairScheduler) Poll() *request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if len(this.turns) == 0 {
+        return nil
+    }
+    if this.turn >= len(this.turns) {
+        this.turn = 0
+    }
+    name := this.turns[this.turn]
+    h := this.hosts[name]
+    if h.credit <= 0 {
+        h.credit = 1
+        if this.policy == PolicyWeighted {
+            h.credit = h.weight
+        }
+    }
+    requ := heap.Pop(&h.queue).(priorityItem).req
+    h.credit--
+    this.count--
+    if this.rm {
+        delete(this.rmKey, this.key(requ))
+    }
+    if h.queue.Len() == 0 {
+        // the next host takes this turn
+        delete(this.hosts, name)
+        this.turns = append(this.turns[:this.turn], this.turns[this.turn+1:]...)
+    } else if h.credit <= 0 {
+        this.turn++
+    }
+    return requ
+}
+
+func (this *FairScheduler) Count() int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.count
+}
+
+// Peek returns the request that Poll will return next without removing it.
+func (this *FairScheduler) Peek() *request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if len(this.turns) == 0 {
+        return nil
+    }
+    turn := this.turn
+    if turn >= len(this.turns) {
+        turn = 0
+    }
+    return this.hosts[this.turns[turn]].queue[0].req
+}
+
+// Snapshot returns all the requests by priority and in order of Push without removing them.
+func (this *FairScheduler) Snapshot() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return requestsOf(this.items())
+}
+
+// The items returns items of all the hosts by priority and in order of Push.
+func (this *FairScheduler) items() priorityQueue {
+    items := make(priorityQueue, 0, this.count)
+    for _, h := range this.hosts {
+        items = append(items, h.queue...)
+    }
+    sort.Sort(items)
+    return items
+}
+
+func requestsOf(items priorityQueue) []*request.Request {
+    reqs := make([]*request.Request, 0, len(items))
+    for _, item := range items {
+        reqs = append(reqs, item.req)
+    }
+    return reqs
+}
+
+// The reset removes all the requests, keeping keys of duplicate.
+func (this *FairScheduler) reset() {
+    this.hosts = make(map[string]*fairHost)
+    this.turns = nil
+    this.turn = 0
+    this.count = 0
+}
+
+// Drain removes all the requests and returns them by priority and in order of Push.
+func (this *FairScheduler) Drain() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    reqs := requestsOf(this.items())
+    this.reset()
+    this.rmKey = make(map[[md5.Size]byte]bool)
+    return reqs
+}
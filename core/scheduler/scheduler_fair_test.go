@@ -0,0 +1,71 @@
+package scheduler_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "strings"
+    "testing"
+)
+
+// The pollAll returns urls of all the requests polled, without scheme.
+func pollAll(s scheduler.Scheduler) string {
+    var urls []string
+    for r := s.Poll(); r != nil; r = s.Poll() {
+        urls = append(urls, strings.TrimPrefix(r.GetUrl(), "http://"))
+    }
+    return strings.Join(urls, " ")
+}
+
+func TestFairScheduler(t *testing.T) {
+    push := func(s *scheduler.FairScheduler) *scheduler.FairScheduler {
+        for _, u := range []string{"a.com/1", "a.com/2", "a.com/3", "a.com/4", "b.com/1", "www.c.com/1", "b.com/2",
+            "a.com/1"} {
+            s.Push(request.NewRequest("http://"+u, "html"))
+        }
+        return s
+    }
+
+    s := push(scheduler.NewFairScheduler(scheduler.PolicyFIFO, true))
+    if s.Count() != 7 {
+        t.Fatalf("count error: %d", s.Count())
+    }
+    if got := pollAll(s); got != "a.com/1 a.com/2 a.com/3 a.com/4 b.com/1 www.c.com/1 b.com/2" {
+        t.Errorf("fifo order is %s", got)
+    }
+
+    s = push(scheduler.NewFairScheduler(scheduler.PolicyRoundRobin, true))
+    if s.Peek().GetUrl() != "http://a.com/1" {
+        t.Errorf("peek error: %s", s.Peek().GetUrl())
+    }
+    if got := pollAll(s); got != "a.com/1 b.com/1 www.c.com/1 a.com/2 b.com/2 a.com/3 a.com/4" {
+        t.Errorf("round robin order is %s", got)
+    }
+
+    s = push(scheduler.NewFairScheduler(scheduler.PolicyWeighted, true).SetWeight("a.com", 2).SetWeight("c.com", 3))
+    if got := pollAll(s); got != "a.com/1 a.com/2 b.com/1 www.c.com/1 a.com/3 a.com/4 b.com/2" {
+        t.Errorf("weighted order is %s", got)
+    }
+
+    // a host without requests takes the last turn when it has requests again, and priority orders a host
+    s = scheduler.NewFairScheduler(scheduler.PolicyRoundRobin, false)
+    s.Push(request.NewRequest("http://a.com/1", "html"))
+    s.Push(request.NewRequest("http://b.com/1", "html"))
+    s.Poll()
+    s.Push(request.NewRequest("http://a.com/2", "html"))
+    s.Push(request.NewRequest("http://b.com/2", "html").SetPriority(1))
+    if got := pollAll(s); got != "b.com/2 a.com/2 b.com/1" {
+        t.Errorf("order of hosts again is %s", got)
+    }
+
+    // requests are dispatched again by a new policy
+    s = push(scheduler.NewFairScheduler(scheduler.PolicyFIFO, true)).SetPolicy(scheduler.PolicyRoundRobin)
+    if snapshot := s.Snapshot(); len(snapshot) != 7 || snapshot[1].GetUrl() != "http://a.com/2" {
+        t.Errorf("snapshot should be in order of push: %v", snapshot)
+    }
+    if got := pollAll(s); got != "a.com/1 b.com/1 www.c.com/1 a.com/2 b.com/2 a.com/3 a.com/4" {
+        t.Errorf("order of new policy is %s", got)
+    }
+    if s.Push(request.NewRequest("http://a.com/1", "html")); s.Count() != 1 || len(s.Drain()) != 1 || s.Count() != 0 {
+        t.Error("drain error")
+    }
+}
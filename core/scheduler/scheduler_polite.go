@@ -0,0 +1,283 @@
+package scheduler
+
+import (
+    "container/heap"
+    "crypto/md5"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/url"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The PoliteScheduler schedules fetch time of each host, so requests of a host are spaced out by its delay
+// while requests of other hosts are polled, instead of workers sleeping before downloads. The delay of a host
+// is the larger one of the delay of SetDelay and the crawl delay of SetCrawlDelay, like Crawl-delay of
+// robots.txt. Poll returns the request of the largest priority among hosts whose time has come, or nil while
+// all the requests wait; requests of a host are polled by priority and in order of Push.
+// Spider keeps running while requests wait, and it leaves Crawl-delay to the PoliteScheduler.
+type PoliteScheduler struct {
+    locker sync.Mutex
+    rm     bool
+    rmKey  map[[md5.Size]byte]bool
+    hosts  map[string]*politeHost
+    count  int
+
+    // The seq is order of Push for requests of the same priority.
+    seq uint64
+
+    delay      time.Duration
+    crawlDelay func(rawurl string) time.Duration
+
+    // The fingerprint returns key of request for removing duplicate.
+    fingerprint func(*request.Request) string
+
+    // The dedup removes requests pushed before, even if they have been polled.
+    dedup Deduplicator
+}
+
+// The politeHost is requests and fetch time of a host.
+type politeHost struct {
+    queue priorityQueue
+    next  time.Time
+
+    // The delay is delay of the host, which is known after crawl delay of the host is got.
+    delay time.Duration
+    known bool
+}
+
+// NewPoliteScheduler returns PoliteScheduler of no delay. Duplicate requests are removed if rmDuplicate is true.
+func NewPoliteScheduler(rmDuplicate bool) *PoliteScheduler {
+    return &PoliteScheduler{rm: rmDuplicate, rmKey: make(map[[md5.Size]byte]bool),
+        hosts: make(map[string]*politeHost), fingerprint: DefaultFingerprint}
+}
+
+// The SetDelay sets the least time between requests of each host.
+func (this *PoliteScheduler) SetDelay(d time.Duration) *PoliteScheduler {
+    this.locker.Lock()
+    this.delay = d
+    this.locker.Unlock()
+    return this
+}
+
+// The SetCrawlDelay sets function that returns crawl delay of host of the url, like Robots.CrawlDelay of
+// HttpDownloader for Crawl-delay of robots.txt. It is called once for each host, when its first request
+// is polled.
+func (this *PoliteScheduler) SetCrawlDelay(f func(rawurl string) time.Duration) *PoliteScheduler {
+    this.locker.Lock()
+    this.crawlDelay = f
+    for _, h := range this.hosts {
+        h.known = false
+    }
+    this.locker.Unlock()
+    return this
+}
+
+// SetFingerprint sets function that returns the fingerprint of request for removing duplicate.
+// Default is DefaultFingerprint.
+func (this *PoliteScheduler) SetFingerprint(f func(*request.Request) string) {
+    this.locker.Lock()
+    this.fingerprint = f
+    this.locker.Unlock()
+}
+
+// SetDeduplicator sets Deduplicator that removes requests whose fingerprints have been pushed before.
+func (this *PoliteScheduler) SetDeduplicator(d Deduplicator) *PoliteScheduler {
+    this.locker.Lock()
+    this.dedup = d
+    this.locker.Unlock()
+    return this
+}
+
+func (this *PoliteScheduler) GetDeduplicator() Deduplicator {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.dedup
+}
+
+func (this *PoliteScheduler) key(requ *request.Request) [md5.Size]byte {
+    return md5.Sum([]byte(this.fingerprint(requ)))
+}
+
+// The politeHostName returns host of the url in lower case.
+func politeHostName(rawurl string) string {
+    if u, err := url.Parse(rawurl); err == nil {
+        return strings.ToLower(u.Host)
+    }
+    return ""
+}
+
+func (this *PoliteScheduler) Push(requ *request.Request) {
+    this.push(requ, true)
+}
+
+// Requeue pushes the request polled before, which is not removed by Deduplicator.
+func (this *PoliteScheduler) Requeue(requ *request.Request) {
+    this.push(requ, false)
+}
+
+func (this *PoliteScheduler) push(requ *request.Request, dedup bool) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if dedup && this.dedup != nil && this.dedup.Seen(this.fingerprint(requ)) {
+        return
+    }
+    if this.rm {
+        key := this.key(requ)
+        if this.rmKey[key] {
+            return
+        }
+        this.rmKey[key] = true
+    }
+    name := politeHostName(requ.GetUrl())
+    h := this.hosts[name]
+    if h == nil {
+        h = &politeHost{}
+        this.hosts[name] = h
+    }
+    this.seq++
+    this.count++
+    heap.Push(&h.queue, priorityItem{req: requ, seq: this.seq})
+}
+
+// The ready returns host whose time has come with the request of the largest priority, or nil.
+// Empty hosts whose time has passed are removed.
+func (this *PoliteScheduler) ready(now time.Time) (string, *politeHost) {
+    var name string
+    var best *politeHost
+    for n, h := range this.hosts {
+        if h.next.After(now) {
+            continue
+        }
+        if h.queue.Len() == 0 {
+            delete(this.hosts, n)
+            continue
+        }
+        if best == nil || morePolite(h.queue[0], best.queue[0]) {
+            name, best = n, h
+        }
+    }
+    return name, best
+}
+
+// The morePolite returns whether item a is polled before item b.
+func morePolite(a, b priorityItem) bool {
+    pa, pb := a.req.GetPriority(), b.req.GetPriority()
+    if pa != pb {
+        return pa > pb
+    }
+    return a.seq < b.seq
+}
+
+func (this *PoliteScheduler) Poll() *request.Request {
+    now := time.Now()
+    this.locker.Lock()
+    name, h := this.ready(now)
+    if h == nil {
+        this.locker.Unlock()
+        return nil
+    }
+    requ := heap.Pop(&h.queue).(priorityItem).req
+    this.count--
+    if this.rm {
+        delete(this.rmKey, this.key(requ))
+    }
+    crawlDelay := this.crawlDelay
+    if h.known || crawlDelay == nil {
+        h.next = now.Add(this.hostDelay(h))
+        this.locker.Unlock()
+        return requ
+    }
+    // the host waits for the delay of SetDelay while its crawl delay is got, which may download robots.txt
+    h.next = now.Add(this.delay)
+    this.locker.Unlock()
+
+    d := crawlDelay(requ.GetUrl())
+    this.locker.Lock()
+    if this.hosts[name] == nil {
+        this.hosts[name] = h
+    }
+    h.delay, h.known = d, true
+    h.next = now.Add(this.hostDelay(h))
+    this.locker.Unlock()
+    return requ
+}
+
+// The hostDelay returns the larger one of the delay and the crawl delay of the host.
+func (this *PoliteScheduler) hostDelay(h *politeHost) time.Duration {
+    if h.known && h.delay > this.delay {
+        return h.delay
+    }
+    return this.delay
+}
+
+// The NextTime returns the earliest fetch time of requests, or zero time if it is empty.
+func (this *PoliteScheduler) NextTime() time.Time {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    var next time.Time
+    for _, h := range this.hosts {
+        if h.queue.Len() > 0 && (next.IsZero() || h.next.Before(next)) {
+            next = h.next
+        }
+    }
+    return next
+}
+
+func (this *PoliteScheduler) Count() int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.count
+}
+
+// Peek returns the request that Poll will return next without removing it, or the first request of the
+// host of the earliest fetch time if all the requests wait.
+func (this *PoliteScheduler) Peek() *request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if _, h := this.ready(time.Now()); h != nil {
+        return h.queue[0].req
+    }
+    var first *politeHost
+    for _, h := range this.hosts {
+        if h.queue.Len() > 0 && (first == nil || h.next.Before(first.next)) {
+            first = h
+        }
+    }
+    if first == nil {
+        return nil
+    }
+    return first.queue[0].req
+}
+
+// Snapshot returns all the requests by priority and in order of Push without removing them.
+func (this *PoliteScheduler) Snapshot() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.list()
+}
+
+func (this *PoliteScheduler) list() []*request.Request {
+    items := make(priorityQueue, 0, this.count)
+    for _, h := range this.hosts {
+        items = append(items, h.queue...)
+    }
+    sort.Sort(items)
+    reqs := make([]*request.Request, 0, len(items))
+    for _, item := range items {
+        reqs = append(reqs, item.req)
+    }
+    return reqs
+}
+
+// Drain removes all the requests and returns them by priority and in order of Push.
+func (this *PoliteScheduler) Drain() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    reqs := this.list()
+    this.hosts = make(map[string]*politeHost)
+    this.count = 0
+    this.rmKey = make(map[[md5.Size]byte]bool)
+    return reqs
+}
@@ -0,0 +1,176 @@
+package scheduler
+
+import (
+    "container/heap"
+    "crypto/md5"
+    "github.com/hu17889/go_spider/core/common/request"
+    "sort"
+    "sync"
+)
+
+// The PriorityScheduler polls requests of larger priority first, and requests of the same priority
+// in order of Push. Priority is set by Request.SetPriority or Page.AddTargetRequestWithPriority.
+type PriorityScheduler struct {
+    locker sync.Mutex
+    rm     bool
+    rmKey  map[[md5.Size]byte]bool
+    queue  priorityQueue
+
+    // The seq is order of Push for requests of the same priority.
+    seq uint64
+
+    // The fingerprint returns key of request for removing duplicate.
+    fingerprint func(*request.Request) string
+
+    // The dedup removes requests pushed before, even if they have been polled.
+    dedup Deduplicator
+}
+
+// The priorityItem is a request in the heap.
+type priorityItem struct {
+    req *request.Request
+    seq uint64
+}
+
+// The priorityQueue implements heap.Interface.
+type priorityQueue []priorityItem
+
+func (this priorityQueue) Len() int {
+    return len(this)
+}
+
+func (this priorityQueue) Less(i, j int) bool {
+    pi, pj := this[i].req.GetPriority(), this[j].req.GetPriority()
+    if pi != pj {
+        return pi > pj
+    }
+    return this[i].seq < this[j].seq
+}
+
+func (this priorityQueue) Swap(i, j int) {
+    this[i], this[j] = this[j], this[i]
+}
+
+func (this *priorityQueue) Push(x interface{}) {
+    *this = append(*this, x.(priorityItem))
+}
+
+func (this *priorityQueue) Pop() interface{} {
+    old := *this
+    item := old[len(old)-1]
+    *this = old[:len(old)-1]
+    return item
+}
+
+func NewPriorityScheduler(rmDuplicate bool) *PriorityScheduler {
+    return &PriorityScheduler{rm: rmDuplicate, rmKey: make(map[[md5.Size]byte]bool), fingerprint: DefaultFingerprint}
+}
+
+// SetFingerprint sets function that returns the fingerprint of request for removing duplicate.
+// Default is DefaultFingerprint.
+func (this *PriorityScheduler) SetFingerprint(f func(*request.Request) string) {
+    this.locker.Lock()
+    this.fingerprint = f
+    this.locker.Unlock()
+}
+
+// SetDeduplicator sets Deduplicator that removes requests whose fingerprints have been pushed before.
+func (this *PriorityScheduler) SetDeduplicator(d Deduplicator) *PriorityScheduler {
+    this.locker.Lock()
+    this.dedup = d
+    this.locker.Unlock()
+    return this
+}
+
+func (this *PriorityScheduler) GetDeduplicator() Deduplicator {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.dedup
+}
+
+func (this *PriorityScheduler) key(requ *request.Request) [md5.Size]byte {
+    return md5.Sum([]byte(this.fingerprint(requ)))
+}
+
+func (this *PriorityScheduler) Push(requ *request.Request) {
+    this.push(requ, true)
+}
+
+// Requeue pushes the request polled before, which is not removed by Deduplicator.
+func (this *PriorityScheduler) Requeue(requ *request.Request) {
+    this.push(requ, false)
+}
+
+func (this *PriorityScheduler) push(requ *request.Request, dedup bool) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if dedup && this.dedup != nil && this.dedup.Seen(this.fingerprint(requ)) {
+        return
+    }
+    if this.rm {
+        key := this.key(requ)
+        if this.rmKey[key] {
+            return
+        }
+        this.rmKey[key] = true
+    }
+    this.seq++
+    heap.Push(&this.queue, priorityItem{req: requ, seq: this.seq})
+}
+
+func (this *PriorityScheduler) Poll() *request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.queue.Len() <= 0 {
+        return nil
+    }
+    requ := heap.Pop(&this.queue).(priorityItem).req
+    if this.rm {
+        delete(this.rmKey, this.key(requ))
+    }
+    return requ
+}
+
+func (this *PriorityScheduler) Count() int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.queue.Len()
+}
+
+// Peek returns the request that Poll will return next without removing it.
+func (this *PriorityScheduler) Peek() *request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.queue.Len() <= 0 {
+        return nil
+    }
+    return this.queue[0].req
+}
+
+// Snapshot returns all the requests in order of Poll without removing them.
+func (this *PriorityScheduler) Snapshot() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.list()
+}
+
+func (this *PriorityScheduler) list() []*request.Request {
+    items := make(priorityQueue, len(this.queue))
+    copy(items, this.queue)
+    sort.Sort(items)
+    reqs := make([]*request.Request, 0, len(items))
+    for _, item := range items {
+        reqs = append(reqs, item.req)
+    }
+    return reqs
+}
+
+// Drain removes all the requests and returns them in order of Poll.
+func (this *PriorityScheduler) Drain() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    reqs := this.list()
+    this.queue = nil
+    this.rmKey = make(map[[md5.Size]byte]bool)
+    return reqs
+}
@@ -0,0 +1,150 @@
+package scheduler_test
+
+import (
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestPriorityScheduler(t *testing.T) {
+    s := scheduler.NewPriorityScheduler(true)
+    s.Push(request.NewRequest("http://a.com/detail/1", "html"))
+    s.Push(request.NewRequest("http://a.com/list/1", "html").SetPriority(10))
+    s.Push(request.NewRequest("http://a.com/detail/2", "html"))
+    s.Push(request.NewRequest("http://a.com/old", "html").SetPriority(-1))
+    s.Push(request.NewRequest("http://a.com/list/2", "html").SetPriority(10))
+    s.Push(request.NewRequest("http://a.com/list/1", "html").SetPriority(10))
+    if s.Count() != 5 {
+        t.Fatalf("count error: %d", s.Count())
+    }
+
+    expected := []string{"http://a.com/list/1", "http://a.com/list/2", "http://a.com/detail/1", "http://a.com/detail/2", "http://a.com/old"}
+    snapshot := s.Snapshot()
+    if s.Peek().GetUrl() != expected[0] {
+        t.Error("peek error")
+    }
+    for i, url := range expected {
+        if snapshot[i].GetUrl() != url {
+            t.Errorf("snapshot %d should be %s: %s", i, url, snapshot[i].GetUrl())
+        }
+        if r := s.Poll(); r.GetUrl() != url {
+            t.Errorf("poll %d should be %s: %s", i, url, r.GetUrl())
+        }
+    }
+    if s.Poll() != nil {
+        t.Error("scheduler should be empty")
+    }
+
+    // priority is saved with the request
+    content, _ := json.Marshal(request.NewRequest("http://a.com", "html").SetPriority(3))
+    r := &request.Request{}
+    if json.Unmarshal(content, r); r.GetPriority() != 3 {
+        t.Error("priority should be serialized")
+    }
+}
+
+func TestPoliteScheduler(t *testing.T) {
+    s := scheduler.NewPoliteScheduler(true).SetDelay(20 * time.Millisecond).
+        SetCrawlDelay(func(rawurl string) time.Duration {
+            if strings.Contains(rawurl, "slow.com") {
+                return 100 * time.Millisecond
+            }
+            return 0
+        })
+    var _ scheduler.TimedScheduler = s
+    s.Push(request.NewRequest("http://a.com/1", "html"))
+    s.Push(request.NewRequest("http://a.com/2", "html").SetPriority(5))
+    s.Push(request.NewRequest("http://slow.com/1", "html"))
+    s.Push(request.NewRequest("http://slow.com/2", "html"))
+    s.Push(request.NewRequest("http://a.com/1", "html"))
+    if s.Count() != 4 {
+        t.Fatalf("count error: %d", s.Count())
+    }
+
+    // hosts are polled by priority, and then wait for their delays
+    if r := s.Poll(); r.GetUrl() != "http://a.com/2" {
+        t.Errorf("request of the largest priority should be polled: %s", r.GetUrl())
+    }
+    if r := s.Poll(); r.GetUrl() != "http://slow.com/1" {
+        t.Errorf("request of ready host should be polled: %s", r.GetUrl())
+    }
+    if r := s.Poll(); r != nil {
+        t.Errorf("requests should wait for delay of their hosts: %s", r.GetUrl())
+    }
+    if next := s.NextTime(); next.IsZero() || time.Until(next) > 20*time.Millisecond {
+        t.Errorf("next time should be delay of a.com: %v", time.Until(next))
+    }
+
+    start := time.Now()
+    polled := make(map[string]time.Duration)
+    for s.Count() > 0 {
+        if r := s.Poll(); r != nil {
+            polled[r.GetUrl()] = time.Since(start)
+        }
+        time.Sleep(time.Millisecond)
+    }
+    if d := polled["http://a.com/1"]; d < 15*time.Millisecond || d > 80*time.Millisecond {
+        t.Errorf("a.com should be polled after delay: %v", d)
+    }
+    if d := polled["http://slow.com/2"]; d < 90*time.Millisecond {
+        t.Errorf("slow.com should be polled after crawl delay: %v", d)
+    }
+    if !s.NextTime().IsZero() {
+        t.Error("next time of empty scheduler should be zero")
+    }
+}
+
+func TestDelayScheduler(t *testing.T) {
+    s := scheduler.NewDelayScheduler(scheduler.NewPriorityScheduler(true))
+    var _ scheduler.TimedScheduler = s
+    var _ scheduler.InspectableScheduler = s
+    s.Push(request.NewRequest("http://a.com/later", "html").SetDelay(60 * time.Millisecond))
+    s.Push(request.NewRequest("http://a.com/soon", "html").SetDelay(20 * time.Millisecond))
+    s.Push(request.NewRequest("http://a.com/now", "html"))
+    s.Push(request.NewRequest("http://a.com/now", "html"))
+    if s.Count() != 3 {
+        t.Fatalf("count error: %d", s.Count())
+    }
+    if r := s.Poll(); r == nil || r.GetUrl() != "http://a.com/now" {
+        t.Fatalf("request of no time should be polled at once: %v", r)
+    }
+    if r := s.Poll(); r != nil {
+        t.Errorf("requests should wait for their time: %s", r.GetUrl())
+    }
+    if next := s.NextTime(); next.IsZero() || time.Until(next) > 20*time.Millisecond {
+        t.Errorf("next time should be time of the earliest request: %v", time.Until(next))
+    }
+
+    data, err := json.Marshal(s.Snapshot())
+    if err != nil {
+        t.Fatal(err)
+    }
+    var saved []*request.Request
+    if err := json.Unmarshal(data, &saved); err != nil {
+        t.Fatal(err)
+    }
+    if len(saved) != 2 || saved[0].GetUrl() != "http://a.com/soon" || saved[0].GetNotBefore().IsZero() {
+        t.Errorf("waiting requests should be saved by their time: %s", data)
+    }
+
+    start := time.Now()
+    polled := make(map[string]time.Duration)
+    for s.Count() > 0 {
+        if r := s.Poll(); r != nil {
+            polled[r.GetUrl()] = time.Since(start)
+        }
+        time.Sleep(time.Millisecond)
+    }
+    if d := polled["http://a.com/soon"]; d < 10*time.Millisecond || d > 40*time.Millisecond {
+        t.Errorf("request should be polled at its time: %v", d)
+    }
+    if d := polled["http://a.com/later"]; d < 50*time.Millisecond {
+        t.Errorf("request should be polled at its time: %v", d)
+    }
+    if !s.NextTime().IsZero() {
+        t.Error("next time of empty scheduler should be zero")
+    }
+}
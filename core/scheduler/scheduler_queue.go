@@ -2,7 +2,6 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-//
 package scheduler
 
 import (
@@ -18,22 +17,67 @@ type QueueScheduler struct {
     rm     bool
     rmKey  map[[md5.Size]byte]*list.Element
     queue  *list.List
+
+    // The fingerprint returns key of request for removing duplicate.
+    fingerprint func(*request.Request) string
+
+    // The dedup removes requests pushed before, even if they have been polled.
+    dedup Deduplicator
 }
 
 func NewQueueScheduler(rmDuplicate bool) *QueueScheduler {
     queue := list.New()
     rmKey := make(map[[md5.Size]byte]*list.Element)
     locker := new(sync.Mutex)
-    return &QueueScheduler{rm: rmDuplicate, queue: queue, rmKey: rmKey, locker: locker}
+    return &QueueScheduler{rm: rmDuplicate, queue: queue, rmKey: rmKey, locker: locker, fingerprint: DefaultFingerprint}
+}
+
+// SetFingerprint sets function that returns the fingerprint of request for removing duplicate.
+// Default is DefaultFingerprint.
+func (this *QueueScheduler) SetFingerprint(f func(*request.Request) string) {
+    this.locker.Lock()
+    this.fingerprint = f
+    this.locker.Unlock()
+}
+
+// SetDeduplicator sets Deduplicator that removes requests whose fingerprints have been pushed before,
+// like BloomDeduplicator for huge crawls or RedisDeduplicator shared by spiders.
+func (this *QueueScheduler) SetDeduplicator(d Deduplicator) *QueueScheduler {
+    this.locker.Lock()
+    this.dedup = d
+    this.locker.Unlock()
+    return this
+}
+
+func (this *QueueScheduler) GetDeduplicator() Deduplicator {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.dedup
+}
+
+func (this *QueueScheduler) key(requ *request.Request) [md5.Size]byte {
+    return md5.Sum([]byte(this.fingerprint(requ)))
 }
 
 func (this *QueueScheduler) Push(requ *request.Request) {
+    this.push(requ, true)
+}
+
+// Requeue pushes the request polled before, which is not removed by Deduplicator.
+func (this *QueueScheduler) Requeue(requ *request.Request) {
+    this.push(requ, false)
+}
+
+func (this *QueueScheduler) push(requ *request.Request, dedup bool) {
     this.locker.Lock()
+    defer this.locker.Unlock()
+    if dedup && this.dedup != nil && this.dedup.Seen(this.fingerprint(requ)) {
+        return
+    }
     var key [md5.Size]byte
     if this.rm {
-        key = md5.Sum([]byte(requ.GetUrl()))
+        key = this.key(requ)
         if _, ok := this.rmKey[key]; ok {
-            this.locker.Unlock()
             return
         }
     }
@@ -41,7 +85,6 @@ func (this *QueueScheduler) Push(requ *request.Request) {
     if this.rm {
         this.rmKey[key] = e
     }
-    this.locker.Unlock()
 }
 
 func (this *QueueScheduler) Poll() *request.Request {
@@ -52,10 +95,9 @@ func (this *QueueScheduler) Poll() *request.Request {
     }
     e := this.queue.Front()
     requ := e.Value.(*request.Request)
-    key := md5.Sum([]byte(requ.GetUrl()))
     this.queue.Remove(e)
     if this.rm {
-        delete(this.rmKey, key)
+        delete(this.rmKey, this.key(requ))
     }
     this.locker.Unlock()
     return requ
@@ -67,3 +109,38 @@ func (this *QueueScheduler) Count() int {
     this.locker.Unlock()
     return len
 }
+
+// Peek returns the request that Poll will return next without removing it.
+func (this *QueueScheduler) Peek() *request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.queue.Len() <= 0 {
+        return nil
+    }
+    return this.queue.Front().Value.(*request.Request)
+}
+
+// Snapshot returns all the requests in order of Poll without removing them.
+func (this *QueueScheduler) Snapshot() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.list()
+}
+
+func (this *QueueScheduler) list() []*request.Request {
+    reqs := make([]*request.Request, 0, this.queue.Len())
+    for e := this.queue.Front(); e != nil; e = e.Next() {
+        reqs = append(reqs, e.Value.(*request.Request))
+    }
+    return reqs
+}
+
+// Drain removes all the requests and returns them in order of Poll.
+func (this *QueueScheduler) Drain() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    reqs := this.list()
+    this.queue.Init()
+    this.rmKey = make(map[[md5.Size]byte]*list.Element)
+    return reqs
+}
@@ -0,0 +1,206 @@
+package scheduler
+
+import (
+    "encoding/json"
+    "github.com/gomodule/redigo/redis"
+    "github.com/hu17889/go_spider/core/common/request"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// The pushScript adds fingerprint to the set and pushes the request only if the fingerprint is new,
+// so that one of the spiders racing on the same request wins.
+var pushScript = redis.NewScript(2, `
+if redis.call("SADD", KEYS[1], ARGV[1]) == 1 then
+    redis.call("RPUSH", KEYS[2], ARGV[2])
+    return 1
+end
+return 0
+`)
+
+// The pollScript moves expired requests of processing back to the queue, then pops one request and
+// saves it in processing with its deadline.
+var pollScript = redis.NewScript(2, `
+local expired = redis.call("ZRANGEBYSCORE", KEYS[2], "-inf", ARGV[1])
+for _, item in ipairs(expired) do
+    redis.call("ZREM", KEYS[2], item)
+    redis.call("LPUSH", KEYS[1], item)
+end
+local item = redis.call("LPOP", KEYS[1])
+if item then
+    redis.call("ZADD", KEYS[2], ARGV[2], item)
+end
+return item
+`)
+
+// The RedisScheduler saves requests in redis, so that several spiders can share one crawl task.
+// Requests are saved in list "<key>:queue", fingerprints of pushed requests are saved in set "<key>:seen",
+// and requests being crawled are saved in sorted set "<key>:processing" by their deadlines.
+// A request polled but not done before visibility timeout is polled again, so requests of a crashed spider
+// are crawled by others. Deadlines are computed by clock of each spider, so the clocks should be synchronized.
+type RedisScheduler struct {
+    pool *redis.Pool
+    key  string
+
+    // The rm is whether duplicate requests are removed by fingerprint set.
+    rm          bool
+    fingerprint func(*request.Request) string
+
+    visibilityTimeout time.Duration
+
+    // The polled saves serialized requests being crawled, for removing them from processing when done.
+    locker sync.Mutex
+    polled map[*request.Request]string
+}
+
+// NewRedisScheduler returns RedisScheduler that connects to redis server of addr like "127.0.0.1:6379".
+// The key is prefix of redis keys, which should be the same for spiders of one crawl task.
+func NewRedisScheduler(addr string, key string, rmDuplicate bool) *RedisScheduler {
+    pool := &redis.Pool{
+        MaxIdle:     10,
+        IdleTimeout: 240 * time.Second,
+        Dial: func() (redis.Conn, error) {
+            return redis.Dial("tcp", addr)
+        },
+    }
+    return NewRedisSchedulerWithPool(pool, key, rmDuplicate)
+}
+
+// NewRedisSchedulerWithPool returns RedisScheduler that uses connections of the pool.
+func NewRedisSchedulerWithPool(pool *redis.Pool, key string, rmDuplicate bool) *RedisScheduler {
+    return &RedisScheduler{
+        pool:              pool,
+        key:               key,
+        rm:                rmDuplicate,
+        fingerprint:       DefaultFingerprint,
+        visibilityTimeout: 10 * time.Minute,
+        polled:            make(map[*request.Request]string),
+    }
+}
+
+// SetFingerprint sets function that returns the fingerprint of request for removing duplicate.
+// Spiders sharing the task should use the same function. Default is DefaultFingerprint.
+func (this *RedisScheduler) SetFingerprint(f func(*request.Request) string) {
+    this.fingerprint = f
+}
+
+// SetVisibilityTimeout sets how long a polled request can be crawled before it is polled again.
+// Default is 10 minutes.
+func (this *RedisScheduler) SetVisibilityTimeout(timeout time.Duration) *RedisScheduler {
+    this.visibilityTimeout = timeout
+    return this
+}
+
+func (this *RedisScheduler) queueKey() string {
+    return this.key + ":queue"
+}
+
+func (this *RedisScheduler) seenKey() string {
+    return this.key + ":seen"
+}
+
+func (this *RedisScheduler) processingKey() string {
+    return this.key + ":processing"
+}
+
+// Push adds the request to the queue. If duplicate requests are removed, a request whose fingerprint
+// has been pushed by any spider is ignored, even if it has been crawled already.
+func (this *RedisScheduler) Push(requ *request.Request) {
+    item, err := json.Marshal(requ)
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+
+    conn := this.pool.Get()
+    defer conn.Close()
+    if this.rm {
+        _, err = pushScript.Do(conn, this.seenKey(), this.queueKey(), this.fingerprint(requ), item)
+    } else {
+        _, err = conn.Do("RPUSH", this.queueKey(), item)
+    }
+    if err != nil {
+        logger.Error("redis push error : " + err.Error())
+    }
+}
+
+// Requeue pushes the request polled before, which is not removed by fingerprint set.
+func (this *RedisScheduler) Requeue(requ *request.Request) {
+    item, err := json.Marshal(requ)
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    conn := this.pool.Get()
+    defer conn.Close()
+    if _, err = conn.Do("RPUSH", this.queueKey(), item); err != nil {
+        logger.Error("redis push error : " + err.Error())
+    }
+}
+
+// Poll pops a request from the queue, or returns nil if the queue is empty.
+// The request is kept in processing until Done is called.
+func (this *RedisScheduler) Poll() *request.Request {
+    conn := this.pool.Get()
+    defer conn.Close()
+
+    now := time.Now()
+    deadline := now.Add(this.visibilityTimeout)
+    item, err := redis.Bytes(pollScript.Do(conn, this.queueKey(), this.processingKey(),
+        strconv.FormatInt(now.UnixNano(), 10), strconv.FormatInt(deadline.UnixNano(), 10)))
+    if err == redis.ErrNil {
+        return nil
+    } else if err != nil {
+        logger.Error("redis poll error : " + err.Error())
+        return nil
+    }
+
+    requ := &request.Request{}
+    if err = json.Unmarshal(item, requ); err != nil {
+        logger.Error("redis request broken : " + string(item))
+        conn.Do("ZREM", this.processingKey(), item)
+        return nil
+    }
+    this.locker.Lock()
+    this.polled[requ] = string(item)
+    this.locker.Unlock()
+    return requ
+}
+
+// Done removes the request polled by this scheduler from processing, after it is crawled.
+func (this *RedisScheduler) Done(requ *request.Request) {
+    this.locker.Lock()
+    item, ok := this.polled[requ]
+    delete(this.polled, requ)
+    this.locker.Unlock()
+    if !ok {
+        return
+    }
+
+    conn := this.pool.Get()
+    defer conn.Close()
+    if _, err := conn.Do("ZREM", this.processingKey(), item); err != nil {
+        logger.Error("redis done error : " + err.Error())
+    }
+}
+
+// Count returns number of requests in the queue, not including requests being crawled.
+func (this *RedisScheduler) Count() int {
+    conn := this.pool.Get()
+    defer conn.Close()
+    n, err := redis.Int(conn.Do("LLEN", this.queueKey()))
+    if err != nil {
+        logger.Error("redis count error : " + err.Error())
+        return 0
+    }
+    return n
+}
+
+// Clear removes the queue, fingerprints and processing requests of the task.
+func (this *RedisScheduler) Clear() error {
+    conn := this.pool.Get()
+    defer conn.Close()
+    _, err := conn.Do("DEL", this.queueKey(), this.seenKey(), this.processingKey())
+    return err
+}
@@ -0,0 +1,61 @@
+package scheduler_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "os"
+    "testing"
+    "time"
+)
+
+// The test needs redis server of env REDIS_ADDR like "127.0.0.1:6379".
+func TestRedisScheduler(t *testing.T) {
+    addr := os.Getenv("REDIS_ADDR")
+    if addr == "" {
+        t.Skip("REDIS_ADDR is not set")
+    }
+
+    a := scheduler.NewRedisScheduler(addr, "go_spider_test", true)
+    b := scheduler.NewRedisScheduler(addr, "go_spider_test", true)
+    if err := a.Clear(); err != nil {
+        t.Fatal(err)
+    }
+    defer a.Clear()
+
+    // the request is pushed once by two spiders
+    a.Push(request.NewRequest("http://baidu.com/?a=1&b=2", "html"))
+    b.Push(request.NewRequest("http://baidu.com/?b=2&a=1", "html"))
+    b.Push(request.NewRequest("http://qq.com", "json"))
+    if a.Count() != 2 {
+        t.Fatalf("count error: %d", a.Count())
+    }
+
+    r := a.Poll()
+    if r == nil || r.GetUrl() != "http://baidu.com/?a=1&b=2" || r.GetResponceType() != "html" {
+        t.Fatal("poll error")
+    }
+    a.Done(r)
+    if b.Count() != 1 {
+        t.Error("count after poll error")
+    }
+
+    // the request not done in time is polled again
+    b.SetVisibilityTimeout(10 * time.Millisecond)
+    if r = b.Poll(); r == nil || r.GetUrl() != "http://qq.com" {
+        t.Fatal("poll error")
+    }
+    if a.Poll() != nil {
+        t.Error("request being crawled should not be polled")
+    }
+    time.Sleep(20 * time.Millisecond)
+    if r = a.Poll(); r == nil || r.GetUrl() != "http://qq.com" {
+        t.Error("expired request should be polled again")
+    }
+    a.Done(r)
+
+    // crawled request is still duplicate
+    a.Push(request.NewRequest("http://qq.com", "json"))
+    if a.Count() != 0 {
+        t.Error("crawled request should not be pushed again")
+    }
+}
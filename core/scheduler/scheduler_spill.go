@@ -0,0 +1,306 @@
+package scheduler
+
+import (
+    "bufio"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "os"
+    "sync"
+    "time"
+)
+
+// The SpillScheduler keeps requests of the Scheduler it wraps within a memory budget, so a long crawl
+// finding millions of links does not run out of memory. Requests pushed while requests in memory are over
+// the budget are appended to a spill file on disk, and they are read back in order they are pushed when
+// requests in memory fall below half of the budget. Memory of a request is approximated by size of its url,
+// body and header. Requests in the spill file are polled after those in memory, so priority of
+// PriorityScheduler only orders requests in memory, and duplicates are removed by the inner Scheduler when
+// they are read back.
+type SpillScheduler struct {
+    locker sync.Mutex
+    inner  Scheduler
+    memory int64
+
+    // The used is approximate bytes of requests in the inner Scheduler.
+    used int64
+
+    path    string
+    writer  *os.File
+    buffer  *bufio.Writer
+    reader  *os.File
+    lines   *bufio.Reader
+    readOff int64
+    spilled int
+}
+
+// NewSpillScheduler returns SpillScheduler keeping requests of inner within memory bytes and spilling the
+// others to file of the path, which is truncated. Memory of 0 or less spills all the requests beyond the
+// first one.
+func NewSpillScheduler(inner Scheduler, path string, memory int64) (*SpillScheduler, error) {
+    writer, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+    if err != nil {
+        return nil, err
+    }
+    reader, err := os.Open(path)
+    if err != nil {
+        writer.Close()
+        return nil, err
+    }
+    return &SpillScheduler{inner: inner, memory: memory, path: path, writer: writer, buffer: bufio.NewWriter(writer),
+        reader: reader, lines: bufio.NewReader(reader)}, nil
+}
+
+// GetScheduler returns the Scheduler wrapped by the SpillScheduler.
+func (this *SpillScheduler) GetScheduler() Scheduler {
+    return this.inner
+}
+
+// The GetMemory returns approximate bytes of requests in memory.
+func (this *SpillScheduler) GetMemory() int64 {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.used
+}
+
+// The GetSpilled returns number of requests in the spill file.
+func (this *SpillScheduler) GetSpilled() int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.spilled
+}
+
+// The requestSize returns approximate bytes of the request in memory.
+func requestSize(requ *request.Request) int64 {
+    // fields and struct of the request
+    n := 256 + len(requ.GetUrl()) + len(requ.GetPostdata())
+    for key, values := range requ.GetHeader() {
+        n += len(key)
+        for _, value := range values {
+            n += len(value)
+        }
+    }
+    return int64(n)
+}
+
+func (this *SpillScheduler) Push(requ *request.Request) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    size := requestSize(requ)
+    if this.spilled > 0 || this.used > 0 && this.used+size > this.memory {
+        this.spill(requ)
+        return
+    }
+    this.pushInner(requ, size, false)
+}
+
+// Requeue pushes the request polled before to the inner Scheduler, by Requeue if it is a RequeueScheduler.
+// It is kept in memory, like a request being retried.
+func (this *SpillScheduler) Requeue(requ *request.Request) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.pushInner(requ, requestSize(requ), true)
+}
+
+// The pushInner pushes the request to the inner Scheduler, and counts its memory unless it is removed as
+// duplicate.
+func (this *SpillScheduler) pushInner(requ *request.Request, size int64, requeue bool) {
+    n := this.inner.Count()
+    if s, ok := this.inner.(RequeueScheduler); ok && requeue {
+        s.Requeue(requ)
+    } else {
+        this.inner.Push(requ)
+    }
+    if this.inner.Count() > n {
+        this.used += size
+    }
+}
+
+// The spill appends the request to the spill file. The request is dropped with an error logged if it can
+// not be written.
+func (this *SpillScheduler) spill(requ *request.Request) {
+    line, err := json.Marshal(requ)
+    if err == nil {
+        _, err = this.buffer.Write(append(line, '\n'))
+    }
+    if err != nil {
+        logger.Error("request is not spilled : "+err.Error(), mlog.F("url", requ.GetUrl()))
+        return
+    }
+    this.spilled++
+}
+
+// The refill reads requests of the spill file back to the inner Scheduler while requests in memory are
+// below half of the budget. The spill file is truncated when all its requests are read.
+func (this *SpillScheduler) refill() {
+    if this.spilled > 0 {
+        if err := this.buffer.Flush(); err != nil {
+            logger.Error("spill file is not written : " + err.Error())
+        }
+    }
+    for this.spilled > 0 && (this.used == 0 || this.used < this.memory/2) {
+        line, err := this.lines.ReadBytes('\n')
+        if err != nil {
+            logger.Error("spill file is not read : " + err.Error())
+            this.spilled = 0
+            break
+        }
+        this.readOff += int64(len(line))
+        this.spilled--
+        requ := &request.Request{}
+        if err := json.Unmarshal(line, requ); err != nil {
+            logger.Error("spilled request line broken : " + string(line))
+            continue
+        }
+        this.pushInner(requ, requestSize(requ), false)
+    }
+    if this.spilled == 0 && this.readOff > 0 {
+        this.reset()
+    }
+}
+
+// The reset truncates the spill file.
+func (this *SpillScheduler) reset() {
+    this.buffer.Reset(this.writer)
+    if err := this.writer.Truncate(0); err != nil {
+        logger.Error("spill file is not truncated : " + err.Error())
+    }
+    this.reader.Seek(0, 0)
+    this.lines.Reset(this.reader)
+    this.readOff, this.spilled = 0, 0
+}
+
+// Poll reads requests of the spill file back if there is room in memory, and polls the inner Scheduler.
+func (this *SpillScheduler) Poll() *request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.refill()
+    requ := this.inner.Poll()
+    if requ != nil {
+        if this.used -= requestSize(requ); this.used < 0 || this.inner.Count() == 0 {
+            this.used = 0
+        }
+    }
+    return requ
+}
+
+// Count returns number of requests in the inner Scheduler and in the spill file.
+func (this *SpillScheduler) Count() int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.inner.Count() + this.spilled
+}
+
+// The NextTime returns NextTime of the inner Scheduler if it is a TimedScheduler, or zero time.
+func (this *SpillScheduler) NextTime() time.Time {
+    if s, ok := this.inner.(TimedScheduler); ok {
+        return s.NextTime()
+    }
+    return time.Time{}
+}
+
+// Peek returns the request that will be polled next. It returns nil if the inner Scheduler is not an
+// InspectableScheduler.
+func (this *SpillScheduler) Peek() *request.Request {
+    s, ok := this.inner.(InspectableScheduler)
+    if !ok {
+        return nil
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.refill()
+    return s.Peek()
+}
+
+// Snapshot returns requests of the inner Scheduler and then requests of the spill file, without removing
+// them. Requests of the inner Scheduler are left out if it is not an InspectableScheduler.
+func (this *SpillScheduler) Snapshot() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    var reqs []*request.Request
+    if s, ok := this.inner.(InspectableScheduler); ok {
+        reqs = s.Snapshot()
+    }
+    return append(reqs, this.readSpilled()...)
+}
+
+// The readSpilled returns requests of the spill file not read back yet.
+func (this *SpillScheduler) readSpilled() []*request.Request {
+    if this.spilled == 0 {
+        return nil
+    }
+    if err := this.buffer.Flush(); err != nil {
+        logger.Error("spill file is not written : " + err.Error())
+    }
+    f, err := os.Open(this.path)
+    if err != nil {
+        logger.Error("spill file is not read : " + err.Error())
+        return nil
+    }
+    defer f.Close()
+    if _, err = f.Seek(this.readOff, 0); err != nil {
+        logger.Error("spill file is not read : " + err.Error())
+        return nil
+    }
+    // the reader of refill buffers lines ahead of readOff, so the file is opened again
+    var reqs []*request.Request
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for scanner.Scan() {
+        requ := &request.Request{}
+        if err := json.Unmarshal(scanner.Bytes(), requ); err != nil {
+            continue
+        }
+        reqs = append(reqs, requ)
+    }
+    return reqs
+}
+
+// Drain removes all the requests and returns requests of the inner Scheduler and then requests of the spill
+// file. Requests of the inner Scheduler are left in it if it is not an InspectableScheduler.
+func (this *SpillScheduler) Drain() []*request.Request {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    var reqs []*request.Request
+    if s, ok := this.inner.(InspectableScheduler); ok {
+        reqs = s.Drain()
+        this.used = 0
+    }
+    reqs = append(reqs, this.readSpilled()...)
+    this.reset()
+    return reqs
+}
+
+// SetFingerprint sets the fingerprint function to the inner Scheduler if it is a FingerprintScheduler.
+func (this *SpillScheduler) SetFingerprint(f func(*request.Request) string) {
+    if s, ok := this.inner.(FingerprintScheduler); ok {
+        s.SetFingerprint(f)
+    } else {
+        logger.Error("scheduler does not support request fingerprint")
+    }
+}
+
+// GetDeduplicator returns Deduplicator of the inner Scheduler, or nil.
+func (this *SpillScheduler) GetDeduplicator() Deduplicator {
+    if s, ok := this.inner.(DeduplicatorScheduler); ok {
+        return s.GetDeduplicator()
+    }
+    return nil
+}
+
+// Done tells the inner Scheduler that the request is crawled if it is a DoneScheduler.
+func (this *SpillScheduler) Done(requ *request.Request) {
+    if s, ok := this.inner.(DoneScheduler); ok {
+        s.Done(requ)
+    }
+}
+
+// Close closes and removes the spill file. Requests in it are lost.
+func (this *SpillScheduler) Close() error {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.reader.Close()
+    this.writer.Close()
+    this.spilled = 0
+    return os.Remove(this.path)
+}
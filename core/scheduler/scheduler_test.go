@@ -2,13 +2,17 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-//
 package scheduler_test
 
 import (
     "fmt"
     "github.com/hu17889/go_spider/core/common/request"
     "github.com/hu17889/go_spider/core/scheduler"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
     "testing"
 )
 
@@ -58,3 +62,107 @@ func TestQueueScheduler(t *testing.T) {
     }
     fmt.Printf("%v\n", r1)
 }
+
+func TestQueueSchedulerPeekDrain(t *testing.T) {
+    var s scheduler.InspectableScheduler = scheduler.NewQueueScheduler(true)
+    if s.Peek() != nil || len(s.Drain()) != 0 {
+        t.Error("empty scheduler error")
+    }
+
+    s.Push(request.NewRequest("http://baidu.com", "html"))
+    s.Push(request.NewRequest("http://qq.com", "html"))
+    if r := s.Peek(); r == nil || r.GetUrl() != "http://baidu.com" || s.Count() != 2 {
+        t.Error("peek error")
+    }
+
+    if reqs := s.Snapshot(); len(reqs) != 2 || s.Count() != 2 {
+        t.Error("snapshot error")
+    }
+
+    reqs := s.Drain()
+    if len(reqs) != 2 || reqs[0].GetUrl() != "http://baidu.com" || reqs[1].GetUrl() != "http://qq.com" {
+        t.Error("drain error")
+    }
+    if s.Count() != 0 || s.Poll() != nil {
+        t.Error("scheduler should be empty after drain")
+    }
+
+    // duplicate keys are removed too
+    s.Push(request.NewRequest("http://baidu.com", "html"))
+    if s.Count() != 1 {
+        t.Error("request should be pushed again after drain")
+    }
+}
+
+func TestQueueSchedulerFingerprint(t *testing.T) {
+    // normalized url by default
+    s := scheduler.NewQueueScheduler(true)
+    s.Push(request.NewRequest("http://baidu.com/?a=1&b=2", "html"))
+    s.Push(request.NewRequest("HTTP://baidu.com/?b=2&a=1#top", "html"))
+    if s.Count() != 1 {
+        t.Error("normalized url should be duplicate")
+    }
+
+    // fragment is kept
+    s = scheduler.NewQueueScheduler(true)
+    s.SetFingerprint(func(r *request.Request) string { return r.GetUrl() })
+    s.Push(request.NewRequest("http://baidu.com/#/a", "html"))
+    s.Push(request.NewRequest("http://baidu.com/#/b", "html"))
+    if s.Count() != 2 {
+        t.Error("custom fingerprint error")
+    }
+    s.Poll()
+    s.Push(request.NewRequest("http://baidu.com/#/b", "html"))
+    if s.Count() != 1 {
+        t.Error("fingerprint should be kept until poll")
+    }
+}
+
+func TestSpillScheduler(t *testing.T) {
+    dir, err := ioutil.TempDir("", "spill")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "spill.jsonl")
+    s, err := scheduler.NewSpillScheduler(scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator()), path, 2000)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer s.Close()
+
+    for i := 0; i < 20; i++ {
+        s.Push(request.NewRequest("http://a.com/"+strconv.Itoa(i), "html").SetBody(strings.Repeat("x", 100), "text/plain"))
+    }
+    s.Push(request.NewRequest("http://a.com/0", "html").SetBody(strings.Repeat("x", 100), "text/plain"))
+    if s.GetMemory() > 2000 || s.GetSpilled() == 0 || s.Count() != 21 {
+        t.Errorf("requests over memory should be spilled: %d %d %d", s.GetMemory(), s.GetSpilled(), s.Count())
+    }
+    if reqs := s.Snapshot(); len(reqs) != 21 || reqs[20].GetUrl() != "http://a.com/0" || s.Count() != 21 {
+        t.Errorf("snapshot should have spilled requests: %d", len(reqs))
+    }
+
+    // spilled requests are read back in order, and the duplicate is removed then
+    for i := 0; i < 20; i++ {
+        r := s.Poll()
+        if r == nil || r.GetUrl() != "http://a.com/"+strconv.Itoa(i) || r.GetPostdata() != strings.Repeat("x", 100) {
+            t.Fatalf("wrong request %d: %v", i, r)
+        }
+        if s.GetMemory() > 2000 {
+            t.Errorf("memory is over the budget: %d", s.GetMemory())
+        }
+    }
+    if r := s.Poll(); r != nil || s.Count() != 0 || s.GetSpilled() != 0 {
+        t.Errorf("scheduler should be empty: %v %d", r, s.Count())
+    }
+    if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+        t.Errorf("spill file should be truncated: %v", err)
+    }
+
+    for i := 0; i < 10; i++ {
+        s.Push(request.NewRequest("http://b.com/"+strconv.Itoa(i), "html"))
+    }
+    if reqs := s.Drain(); len(reqs) != 10 || reqs[9].GetUrl() != "http://b.com/9" || s.Count() != 0 {
+        t.Errorf("drain should return spilled requests: %d %d", len(reqs), s.Count())
+    }
+}
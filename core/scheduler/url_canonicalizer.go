@@ -0,0 +1,115 @@
+package scheduler
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/util"
+    "net/url"
+    "strings"
+)
+
+// The DefaultDroppedParams are query params that do not change the page, like tracking params of campaigns
+// and session ids. Names ending with "*" are prefixes.
+var DefaultDroppedParams = []string{
+    "utm_*", "gclid", "fbclid", "msclkid", "yclid", "_ga", "mc_cid", "mc_eid",
+    "jsessionid", "phpsessid", "aspsessionid*", "sid", "sessionid", "session_id", "cfid", "cftoken",
+}
+
+// The UrlCanonicalizer returns canonical urls, so that urls of the same page have the same fingerprint.
+// It lowercases scheme and host, removes default port, fragment and params like utm_source and session ids,
+// including path params like ";jsessionid=...", and sorts query params.
+// Its Fingerprint can be set by Spider.SetRequestFingerprint or SetFingerprint of Scheduler.
+type UrlCanonicalizer struct {
+    names    map[string]bool
+    prefixes []string
+    sort     bool
+}
+
+// NewUrlCanonicalizer returns UrlCanonicalizer that drops DefaultDroppedParams and sorts query params.
+func NewUrlCanonicalizer() *UrlCanonicalizer {
+    c := &UrlCanonicalizer{names: make(map[string]bool), sort: true}
+    return c.DropParams(DefaultDroppedParams...)
+}
+
+// The DropParams adds names of query params to drop, case insensitive. Names ending with "*" are prefixes.
+func (this *UrlCanonicalizer) DropParams(names ...string) *UrlCanonicalizer {
+    for _, name := range names {
+        name = strings.ToLower(name)
+        if strings.HasSuffix(name, "*") {
+            this.prefixes = append(this.prefixes, name[:len(name)-1])
+        } else {
+            this.names[name] = true
+        }
+    }
+    return this
+}
+
+// The KeepParams removes names from dropped params, like "sid" of a site where it is an id of content.
+func (this *UrlCanonicalizer) KeepParams(names ...string) *UrlCanonicalizer {
+    for _, name := range names {
+        delete(this.names, strings.ToLower(name))
+    }
+    return this
+}
+
+// The SetSortQuery sets whether query params are sorted. Default is true.
+func (this *UrlCanonicalizer) SetSortQuery(sort bool) *UrlCanonicalizer {
+    this.sort = sort
+    return this
+}
+
+// The Canonicalize returns canonical form of the url, or the rawurl if it can not be parsed.
+func (this *UrlCanonicalizer) Canonicalize(rawurl string) string {
+    u, err := url.Parse(util.CanonicalizeUrl(rawurl))
+    if err != nil {
+        return rawurl
+    }
+
+    // path params like "/a;jsessionid=1"
+    if i := strings.Index(u.Path, ";"); i >= 0 {
+        var kept []string
+        for _, param := range strings.Split(u.Path[i+1:], ";") {
+            if !this.dropped(strings.SplitN(param, "=", 2)[0]) {
+                kept = append(kept, param)
+            }
+        }
+        u.Path = strings.Join(append([]string{u.Path[:i]}, kept...), ";")
+        u.RawPath = ""
+    }
+
+    if u.RawQuery != "" {
+        var kept []string
+        for _, param := range strings.Split(u.RawQuery, "&") {
+            if param == "" {
+                continue
+            }
+            name, err := url.QueryUnescape(strings.SplitN(param, "=", 2)[0])
+            if err != nil || !this.dropped(name) {
+                kept = append(kept, param)
+            }
+        }
+        u.RawQuery = strings.Join(kept, "&")
+    }
+    if this.sort {
+        return util.NormalizeUrl(u.String())
+    }
+    return u.String()
+}
+
+// The Fingerprint returns fingerprint of the request by RequestFingerprinter with canonical url,
+// which is the canonical url of GET requests.
+func (this *UrlCanonicalizer) Fingerprint(req *request.Request) string {
+    return NewRequestFingerprinter().SetUrlFunc(this.Canonicalize).Fingerprint(req)
+}
+
+func (this *UrlCanonicalizer) dropped(name string) bool {
+    name = strings.ToLower(name)
+    if this.names[name] {
+        return true
+    }
+    for _, prefix := range this.prefixes {
+        if strings.HasPrefix(name, prefix) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,29 @@
+package scheduler_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "testing"
+)
+
+func TestUrlCanonicalizer(t *testing.T) {
+    c := scheduler.NewUrlCanonicalizer().DropParams("ref").KeepParams("sid")
+    for in, out := range map[string]string{
+        "HTTP://A.com:80/p?b=2&a=1&utm_source=x&UTM_Medium=y#top": "http://a.com/p?a=1&b=2",
+        "http://a.com/p;jsessionid=ABC?id=1&PHPSESSID=x&ref=home": "http://a.com/p?id=1",
+        "http://a.com/p?sid=7&gclid=1":                            "http://a.com/p?sid=7",
+        "http://a.com":                                            "http://a.com/",
+    } {
+        if r := c.Canonicalize(in); r != out {
+            t.Errorf("Canonicalize(%s) = %s, want %s", in, r, out)
+        }
+    }
+
+    s := scheduler.NewQueueScheduler(true)
+    s.SetFingerprint(c.Fingerprint)
+    s.Push(request.NewRequest("http://a.com/p?id=1&utm_source=x", "html"))
+    s.Push(request.NewRequest("http://a.com/p?utm_campaign=y&id=1", "html"))
+    if s.Count() != 1 {
+        t.Errorf("urls of the same canonical url should be duplicate: %d", s.Count())
+    }
+}
@@ -0,0 +1,137 @@
+package scheduler
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/url"
+    "path"
+    "regexp"
+    "strings"
+    "sync"
+)
+
+// The DefaultDeniedExtensions are extensions of files that are not web pages, like images, media and archives.
+var DefaultDeniedExtensions = []string{
+    "jpg", "jpeg", "png", "gif", "bmp", "webp", "svg", "ico", "tif", "tiff",
+    "mp3", "wav", "ogg", "flac", "aac", "mp4", "avi", "mov", "wmv", "flv", "mkv", "webm",
+    "zip", "rar", "7z", "gz", "tgz", "bz2", "tar", "xz", "iso", "dmg", "exe", "msi", "apk", "bin",
+    "pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "css", "js", "woff", "woff2", "ttf", "eot",
+}
+
+// The UrlFilter decides which requests are pushed to Scheduler, so page processers do not filter links themselves.
+// A request is allowed only if its url
+//   - does not match any deny regexp,
+//   - matches one of allow regexps if they are set,
+//   - is on one of allowed domains or their subdomains if they are set,
+//   - does not have a denied file extension in its path.
+//
+// If it stays on seed domains, domains of requests of depth 0, like urls added to Spider, are allowed domains.
+// It is used by Spider.SetUrlFilter.
+type UrlFilter struct {
+    allows []*regexp.Regexp
+    denies []*regexp.Regexp
+
+    extensions map[string]bool
+
+    stayOnSeeds bool
+    locker      sync.RWMutex
+    domains     map[string]bool
+}
+
+func NewUrlFilter() *UrlFilter {
+    return &UrlFilter{extensions: make(map[string]bool), domains: make(map[string]bool)}
+}
+
+// The Allow adds regexps of allowed urls. It panics if an expr can not be compiled.
+func (this *UrlFilter) Allow(exprs ...string) *UrlFilter {
+    for _, expr := range exprs {
+        this.allows = append(this.allows, regexp.MustCompile(expr))
+    }
+    return this
+}
+
+// The Deny adds regexps of denied urls, which win over allowed ones. It panics if an expr can not be compiled.
+func (this *UrlFilter) Deny(exprs ...string) *UrlFilter {
+    for _, expr := range exprs {
+        this.denies = append(this.denies, regexp.MustCompile(expr))
+    }
+    return this
+}
+
+// The AllowDomains adds allowed domains like "example.com", whose subdomains are allowed too.
+func (this *UrlFilter) AllowDomains(domains ...string) *UrlFilter {
+    this.locker.Lock()
+    for _, domain := range domains {
+        this.domains[strings.ToLower(strings.TrimPrefix(domain, "."))] = true
+    }
+    this.locker.Unlock()
+    return this
+}
+
+// The StayOnSeedDomains sets whether hosts of requests of depth 0 are added to allowed domains.
+func (this *UrlFilter) StayOnSeedDomains(stay bool) *UrlFilter {
+    this.stayOnSeeds = stay
+    return this
+}
+
+// The DenyExtensions adds denied file extensions like "jpg", case insensitive. Use DefaultDeniedExtensions
+// to skip files that are not web pages.
+func (this *UrlFilter) DenyExtensions(exts ...string) *UrlFilter {
+    for _, ext := range exts {
+        this.extensions[strings.ToLower(strings.TrimPrefix(ext, "."))] = true
+    }
+    return this
+}
+
+// The Allowed tests whether the request can be pushed to Scheduler.
+func (this *UrlFilter) Allowed(req *request.Request) bool {
+    rawurl := req.GetUrl()
+    for _, r := range this.denies {
+        if r.MatchString(rawurl) {
+            return false
+        }
+    }
+    if len(this.allows) > 0 {
+        matched := false
+        for _, r := range this.allows {
+            if matched = r.MatchString(rawurl); matched {
+                break
+            }
+        }
+        if !matched {
+            return false
+        }
+    }
+
+    u, err := url.Parse(rawurl)
+    if err != nil {
+        return false
+    }
+    if len(this.extensions) > 0 {
+        if ext := path.Ext(u.Path); ext != "" && this.extensions[strings.ToLower(ext[1:])] {
+            return false
+        }
+    }
+
+    host := strings.ToLower(u.Hostname())
+    if this.stayOnSeeds && req.GetDepth() == 0 {
+        this.AllowDomains(host)
+        return true
+    }
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    if len(this.domains) == 0 {
+        return true
+    }
+    // host and its parent domains, like a.b.com, b.com and com
+    for name := host; name != ""; {
+        if this.domains[name] {
+            return true
+        }
+        i := strings.Index(name, ".")
+        if i < 0 {
+            break
+        }
+        name = name[i+1:]
+    }
+    return false
+}
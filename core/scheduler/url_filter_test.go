@@ -0,0 +1,40 @@
+package scheduler_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "testing"
+)
+
+func TestUrlFilter(t *testing.T) {
+    f := scheduler.NewUrlFilter().Allow(`/(news|list)/`).Deny(`[?&]sort=`).
+        AllowDomains("a.com").DenyExtensions(scheduler.DefaultDeniedExtensions...)
+    for url, allowed := range map[string]bool{
+        "http://a.com/news/1":           true,
+        "http://www.a.com/list/2":       true,
+        "http://a.com/about/":           false,
+        "http://a.com/news/1?sort=date": false,
+        "http://a.com/news/logo.PNG":    false,
+        "http://b.com/news/1":           false,
+        "http://aa.com/news/1":          false,
+        "http://a.com.evil.com/news/1":  false,
+    } {
+        if f.Allowed(request.NewRequest(url, "html")) != allowed {
+            t.Errorf("%s should be allowed: %v", url, allowed)
+        }
+    }
+}
+
+func TestUrlFilterStayOnSeedDomains(t *testing.T) {
+    f := scheduler.NewUrlFilter().StayOnSeedDomains(true)
+    if !f.Allowed(request.NewRequest("http://a.com/", "html")) {
+        t.Fatal("seed should be allowed")
+    }
+    if !f.Allowed(request.NewRequest("http://a.com/x", "html").SetDepth(1)) ||
+        !f.Allowed(request.NewRequest("http://sub.a.com/x", "html").SetDepth(1)) {
+        t.Error("links on seed domain should be allowed")
+    }
+    if f.Allowed(request.NewRequest("http://b.com/x", "html").SetDepth(1)) {
+        t.Error("links off seed domains should be denied")
+    }
+}
@@ -0,0 +1,112 @@
+package spider
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/trace"
+    "sync"
+)
+
+// The SetAsyncPipelines runs pipelines in n workers of their own instead of in download workers, so slow
+// pipelines like database writes do not stall downloads. Pages wait for the pipeline workers in a queue of
+// queueSize pages; when the queue is full, download workers wait to push their pages and Run does not
+// dispatch new requests until there is room, so memory is bounded when pipelines can not keep up.
+// Pipelines are called concurrently by the workers and pages are not passed in order they are downloaded.
+// Run returns after all the pages queued are passed to pipelines. Default n 0 runs pipelines synchronously.
+// It should be called before Run.
+func (this *Spider) SetAsyncPipelines(n int, queueSize int) *Spider {
+    if queueSize < 0 {
+        queueSize = 0
+    }
+    this.pipelineWorkers, this.pipelineQueueSize = n, queueSize
+    return this
+}
+
+// The SetPipelineQueueMemory limits bytes of pages waiting in the queue of SetAsyncPipelines, approximated by
+// size of their bodies, so a queue of large pages does not run out of memory. Beyond it, download workers wait
+// to push their pages like when the queue is full; a page larger than the limit is queued alone. Default 0
+// means no limit. It should be called before Run.
+func (this *Spider) SetPipelineQueueMemory(bytes int64) *Spider {
+    this.pipelineQueueMemory = bytes
+    return this
+}
+
+// The pipelineQueue passes pages to pipelines in workers of SetAsyncPipelines.
+type pipelineQueue struct {
+    pages   chan *page.Page
+    workers sync.WaitGroup
+
+    // The used is bytes of pages in the queue, which push keeps within memory if memory is not 0.
+    memory int64
+    locker sync.Mutex
+    room   *sync.Cond
+    used   int64
+}
+
+// The pageSize returns approximate bytes of the page in memory.
+func pageSize(p *page.Page) int64 {
+    return int64(len(p.GetBodyStr()) + len(p.GetRawBody()))
+}
+
+// The push adds the page to the queue, waiting while the queue is full or over its memory.
+func (this *pipelineQueue) push(p *page.Page) {
+    if this.memory > 0 {
+        size := pageSize(p)
+        this.locker.Lock()
+        for this.used > 0 && this.used+size > this.memory {
+            this.room.Wait()
+        }
+        this.used += size
+        this.locker.Unlock()
+    }
+    this.pages <- p
+}
+
+// The pop releases memory of the page taken from the queue.
+func (this *pipelineQueue) pop(p *page.Page) {
+    if this.memory > 0 {
+        this.locker.Lock()
+        this.used -= pageSize(p)
+        this.locker.Unlock()
+        this.room.Broadcast()
+    }
+}
+
+// The len returns number of pages waiting in the queue.
+func (this *pipelineQueue) len() int {
+    return len(this.pages)
+}
+
+// The startPipelineQueue starts pipeline workers if SetAsyncPipelines is set. It returns function that
+// waits for pages queued to be passed to pipelines and stops the workers, which is called after download
+// workers are done.
+func (this *Spider) startPipelineQueue(runCtx context.Context) func() {
+    if this.pipelineWorkers <= 0 {
+        return func() {}
+    }
+    q := &pipelineQueue{pages: make(chan *page.Page, this.pipelineQueueSize), memory: this.pipelineQueueMemory}
+    q.room = sync.NewCond(&q.locker)
+    for i := 0; i < this.pipelineWorkers; i++ {
+        q.workers.Add(1)
+        go func() {
+            defer q.workers.Done()
+            for p := range q.pages {
+                q.pop(p)
+                ctx, release := requestContext(runCtx, p.GetRequest())
+                ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(p.Context()))
+                this.output(ctx, p)
+                release()
+            }
+        }()
+    }
+    this.runLocker.Lock()
+    this.pipelineQueue = q
+    this.runLocker.Unlock()
+    return func() {
+        close(q.pages)
+        q.workers.Wait()
+        this.runLocker.Lock()
+        this.pipelineQueue = nil
+        this.runLocker.Unlock()
+    }
+}
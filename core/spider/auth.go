@@ -0,0 +1,361 @@
+package spider
+
+import (
+    "context"
+    "crypto/md5"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "github.com/PuerkitoBio/goquery"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "hash"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// The Authenticator logs in before the crawl starts, and logs in again when a page shows the session is expired.
+type Authenticator interface {
+    // The Login logs in by the client, which has cookie jar and transport of HttpDownloader, so the session
+    // cookies are sent with the crawl.
+    Login(client *http.Client) error
+
+    // The Authorize adds credentials to the request before it is downloaded, like Authorization header.
+    Authorize(req *request.Request)
+
+    // The Expired tests whether the page shows the session is expired, like 401 or redirect to login page.
+    // The request is downloaded again after login.
+    Expired(p *page.Page) bool
+}
+
+// The SetAuthenticator sets Authenticator that logs in before Run crawls, and logs in again when the session
+// is expired. If login fails when Run starts, Run returns without crawling.
+func (this *Spider) SetAuthenticator(a Authenticator) *Spider {
+    this.authenticator = a
+    return this
+}
+
+// The login logs in by the authenticator. Concurrent workers finding the session expired log in once,
+// as generation changes after each login.
+func (this *Spider) login(generation int64) error {
+    this.authLocker.Lock()
+    defer this.authLocker.Unlock()
+    if generation != this.authGeneration {
+        return nil
+    }
+    client := &http.Client{}
+    if d, ok := this.findHttpDownloader(); ok {
+        var err error
+        if client, err = d.GetClient(); err != nil {
+            return err
+        }
+    }
+    if err := this.authenticator.Login(client); err != nil {
+        return err
+    }
+    this.authGeneration++
+    return nil
+}
+
+// The authGenerationNow returns how many times the authenticator has logged in.
+func (this *Spider) authGenerationNow() int64 {
+    this.authLocker.Lock()
+    defer this.authLocker.Unlock()
+    return this.authGeneration
+}
+
+// The authorizedDownload downloads the request with credentials of the authenticator,
+// and downloads it again after login if the session is expired.
+func (this *Spider) authorizedDownload(ctx context.Context, req *request.Request) *page.Page {
+    if this.authenticator == nil {
+        return downloader.DownloadContext(ctx, this.pDownloader, req)
+    }
+    generation := this.authGenerationNow()
+    this.authenticator.Authorize(req)
+    p := downloader.DownloadContext(ctx, this.pDownloader, req)
+    if !this.authenticator.Expired(p) {
+        return p
+    }
+    logger.Warn("session is expired, login again", mlog.F("url", req.GetUrl()))
+    if err := this.login(generation); err != nil {
+        logger.Error("login failed : " + err.Error())
+        p.SetStatus(true, "login failed : "+err.Error())
+        return p
+    }
+    this.authenticator.Authorize(req)
+    return downloader.DownloadContext(ctx, this.pDownloader, req)
+}
+
+// The FormLogin is Authenticator that submits the login form like a browser does. The login page is fetched,
+// and the form is submitted with its hidden fields, like csrf token, and the values.
+type FormLogin struct {
+    loginUrl string
+    selector string
+    values   map[string]string
+    check    func(body string) bool
+    expired  func(p *page.Page) bool
+}
+
+// NewFormLogin returns FormLogin that submits the first form of the login page with values,
+// like {"username": "u", "password": "p"}.
+func NewFormLogin(loginUrl string, values map[string]string) *FormLogin {
+    return &FormLogin{loginUrl: loginUrl, selector: "form", values: values}
+}
+
+// The SetFormSelector sets css selector of the login form. Default is "form".
+func (this *FormLogin) SetFormSelector(selector string) *FormLogin {
+    this.selector = selector
+    return this
+}
+
+// The SetCheck sets function called with body of the last responce of login, and login fails if it returns
+// false, like when the body has "wrong password".
+func (this *FormLogin) SetCheck(check func(body string) bool) *FormLogin {
+    this.check = check
+    return this
+}
+
+// The SetExpired sets function that tests whether a page shows the session is expired. Default tests whether
+// the status is 401, or the page is redirected to path of the login url.
+func (this *FormLogin) SetExpired(expired func(p *page.Page) bool) *FormLogin {
+    this.expired = expired
+    return this
+}
+
+func (this *FormLogin) Login(client *http.Client) error {
+    resp, err := client.Get(this.loginUrl)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 400 {
+        return errors.New("login page http status " + strconv.Itoa(resp.StatusCode))
+    }
+    doc, err := goquery.NewDocumentFromReader(resp.Body)
+    if err != nil {
+        return err
+    }
+    p := page.NewPage(request.NewRequest(resp.Request.URL.String(), "html")).SetHtmlParser(doc)
+    req := p.SubmitForm(this.selector, this.values)
+    if req == nil {
+        return errors.New("login form is not found : " + this.selector)
+    }
+
+    var body io.Reader
+    if req.GetPostdata() != "" {
+        body = strings.NewReader(req.GetPostdata())
+    }
+    httpreq, err := http.NewRequest(req.GetMethod(), req.GetUrl(), body)
+    if err != nil {
+        return err
+    }
+    for key, values := range req.GetHeader() {
+        httpreq.Header[key] = values
+    }
+    httpreq.Header.Set("Referer", p.GetRequest().GetUrl())
+    resp, err = client.Do(httpreq)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 400 {
+        return errors.New("login failed with http status " + strconv.Itoa(resp.StatusCode))
+    }
+    if this.check != nil {
+        content, err := ioutil.ReadAll(resp.Body)
+        if err != nil {
+            return err
+        }
+        if !this.check(string(content)) {
+            return errors.New("login check failed : " + this.loginUrl)
+        }
+    }
+    return nil
+}
+
+func (this *FormLogin) Authorize(req *request.Request) {}
+
+func (this *FormLogin) Expired(p *page.Page) bool {
+    if this.expired != nil {
+        return this.expired(p)
+    }
+    if p.GetStatusCode() == http.StatusUnauthorized {
+        return true
+    }
+    login, err := url.Parse(this.loginUrl)
+    if err != nil {
+        return false
+    }
+    final, err := url.Parse(p.GetFinalUrl())
+    if err != nil || final.String() == p.GetRequest().GetUrl() {
+        return false
+    }
+    return final.Host == login.Host && final.Path == login.Path
+}
+
+// The BasicAuth is Authenticator that sends user and password by http basic authentication.
+// The credentials are sent to the host of its url only.
+type BasicAuth struct {
+    host     string
+    user     string
+    password string
+}
+
+// NewBasicAuth returns BasicAuth that sends the user and password to host of the rawurl.
+func NewBasicAuth(rawurl string, user string, password string) *BasicAuth {
+    return &BasicAuth{host: urlHost(rawurl), user: user, password: password}
+}
+
+func (this *BasicAuth) Login(client *http.Client) error {
+    return nil
+}
+
+func (this *BasicAuth) Authorize(req *request.Request) {
+    if urlHost(req.GetUrl()) == this.host {
+        req.SetBasicAuth(this.user, this.password)
+    }
+}
+
+func (this *BasicAuth) Expired(p *page.Page) bool {
+    return false
+}
+
+// The DigestAuth is Authenticator of http digest authentication with qop "auth". The Login gets the challenge
+// of the server from its url, and a new challenge is got when the server answers 401 for a stale nonce.
+// The credentials are sent to the host of its url only.
+type DigestAuth struct {
+    rawurl   string
+    host     string
+    user     string
+    password string
+
+    locker    sync.Mutex
+    challenge map[string]string
+    count     int
+}
+
+// NewDigestAuth returns DigestAuth that gets the challenge from rawurl, a page that needs authentication.
+func NewDigestAuth(rawurl string, user string, password string) *DigestAuth {
+    return &DigestAuth{rawurl: rawurl, host: urlHost(rawurl), user: user, password: password}
+}
+
+func (this *DigestAuth) Login(client *http.Client) error {
+    resp, err := client.Get(this.rawurl)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    header := resp.Header.Get("WWW-Authenticate")
+    if resp.StatusCode != http.StatusUnauthorized || !strings.HasPrefix(strings.ToLower(header), "digest ") {
+        return errors.New("digest challenge is not found : " + this.rawurl)
+    }
+    challenge := parseDigestChallenge(header[len("digest "):])
+    if challenge["nonce"] == "" {
+        return errors.New("digest challenge has no nonce : " + header)
+    }
+    this.locker.Lock()
+    this.challenge = challenge
+    this.count = 0
+    this.locker.Unlock()
+    return nil
+}
+
+func (this *DigestAuth) Authorize(req *request.Request) {
+    u, err := url.Parse(req.GetUrl())
+    if err != nil || u.Host != this.host {
+        return
+    }
+    this.locker.Lock()
+    challenge := this.challenge
+    this.count++
+    count := this.count
+    this.locker.Unlock()
+    if challenge == nil {
+        return
+    }
+
+    var h func() hash.Hash = md5.New
+    algorithm := challenge["algorithm"]
+    if strings.ToUpper(algorithm) == "SHA-256" {
+        h = sha256.New
+    }
+    digest := func(s string) string {
+        d := h()
+        d.Write([]byte(s))
+        return hex.EncodeToString(d.Sum(nil))
+    }
+    uri := u.RequestURI()
+    nc := strconv.FormatInt(int64(count), 16)
+    nc = strings.Repeat("0", 8-len(nc)) + nc
+    b := make([]byte, 8)
+    rand.Read(b)
+    cnonce := hex.EncodeToString(b)
+    ha1 := digest(this.user + ":" + challenge["realm"] + ":" + this.password)
+    ha2 := digest(req.GetMethod() + ":" + uri)
+
+    auth := `Digest username="` + this.user + `", realm="` + challenge["realm"] + `", nonce="` + challenge["nonce"] +
+        `", uri="` + uri + `"`
+    if challenge["qop"] != "" {
+        response := digest(ha1 + ":" + challenge["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+        auth += `, qop=auth, nc=` + nc + `, cnonce="` + cnonce + `", response="` + response + `"`
+    } else {
+        auth += `, response="` + digest(ha1+":"+challenge["nonce"]+":"+ha2) + `"`
+    }
+    if opaque, ok := challenge["opaque"]; ok {
+        auth += `, opaque="` + opaque + `"`
+    }
+    if algorithm != "" {
+        auth += `, algorithm=` + algorithm
+    }
+    req.SetHeader("Authorization", auth)
+}
+
+func (this *DigestAuth) Expired(p *page.Page) bool {
+    return p.GetStatusCode() == http.StatusUnauthorized && urlHost(p.GetRequest().GetUrl()) == this.host
+}
+
+// The parseDigestChallenge parses parameters of digest challenge like `realm="r", nonce="n", qop="auth"`.
+func parseDigestChallenge(s string) map[string]string {
+    params := make(map[string]string)
+    for len(s) > 0 {
+        s = strings.TrimLeft(s, " ,")
+        i := strings.Index(s, "=")
+        if i < 0 {
+            break
+        }
+        key := strings.ToLower(strings.TrimSpace(s[:i]))
+        s = strings.TrimLeft(s[i+1:], " ")
+        var value string
+        if strings.HasPrefix(s, `"`) {
+            end := strings.Index(s[1:], `"`)
+            if end < 0 {
+                value, s = s[1:], ""
+            } else {
+                value, s = s[1:end+1], s[end+2:]
+            }
+        } else if end := strings.Index(s, ","); end >= 0 {
+            value, s = strings.TrimSpace(s[:end]), s[end:]
+        } else {
+            value, s = strings.TrimSpace(s), ""
+        }
+        params[key] = value
+    }
+    return params
+}
+
+// The urlHost returns host of the url, or empty string if it is broken.
+func urlHost(rawurl string) string {
+    u, err := url.Parse(rawurl)
+    if err != nil {
+        return ""
+    }
+    return u.Host
+}
@@ -0,0 +1,214 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/http"
+    "net/url"
+    "regexp"
+    "sync"
+    "time"
+)
+
+// The AutoThrottle adapts delay between requests of each host to how the host responds, instead of static
+// rate limits that either waste capacity or get the crawler banned.
+// The delay of a host moves to its download latency divided by the target concurrency, and it is doubled when
+// the host answers with a ban signal: a ban status code like 429 or 403, or a page matching a ban signature
+// like a captcha page. After threshold consecutive ban signals the host is paused for the cool-off,
+// and requests of the host wait until it is over.
+type AutoThrottle struct {
+    startDelay time.Duration
+    maxDelay   time.Duration
+    target     float64
+
+    banCodes      map[int]bool
+    banSignatures []*regexp.Regexp
+    banThreshold  int
+    coolOff       time.Duration
+
+    locker sync.Mutex
+    hosts  map[string]*throttleHost
+}
+
+// The throttleHost is the state of a host. The next is the earliest time of next request of the host.
+type throttleHost struct {
+    delay       time.Duration
+    next        time.Time
+    bans        int
+    pausedUntil time.Time
+}
+
+// NewAutoThrottle returns AutoThrottle whose delay of each host starts at startDelay and is no more than maxDelay.
+// By default the target concurrency is 1, 429 and 403 are ban status codes, and a host is paused for
+// a minute after 3 consecutive ban signals.
+func NewAutoThrottle(startDelay, maxDelay time.Duration) *AutoThrottle {
+    return &AutoThrottle{
+        startDelay:   startDelay,
+        maxDelay:     maxDelay,
+        target:       1,
+        banCodes:     map[int]bool{http.StatusTooManyRequests: true, http.StatusForbidden: true},
+        banThreshold: 3,
+        coolOff:      time.Minute,
+        hosts:        make(map[string]*throttleHost),
+    }
+}
+
+// The SetTargetConcurrency sets average number of requests being downloaded from a host at once.
+// A larger target makes delays shorter.
+func (this *AutoThrottle) SetTargetConcurrency(n float64) *AutoThrottle {
+    if n > 0 {
+        this.target = n
+    }
+    return this
+}
+
+// The SetBanStatusCodes sets http status codes that are ban signals.
+func (this *AutoThrottle) SetBanStatusCodes(codes ...int) *AutoThrottle {
+    this.banCodes = make(map[int]bool, len(codes))
+    for _, code := range codes {
+        this.banCodes[code] = true
+    }
+    return this
+}
+
+// The AddBanSignature makes pages whose body matches the regexp ban signals, like "(?i)captcha".
+// It panics if the expr can not be compiled.
+func (this *AutoThrottle) AddBanSignature(expr string) *AutoThrottle {
+    this.banSignatures = append(this.banSignatures, regexp.MustCompile(expr))
+    return this
+}
+
+// The SetCoolOff pauses a host for coolOff after threshold consecutive ban signals. The threshold 0 never pauses.
+func (this *AutoThrottle) SetCoolOff(threshold int, coolOff time.Duration) *AutoThrottle {
+    this.banThreshold = threshold
+    this.coolOff = coolOff
+    return this
+}
+
+// The Delay returns current delay between requests of host of the url.
+func (this *AutoThrottle) Delay(rawurl string) time.Duration {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return this.host(throttleHostName(rawurl)).delay
+}
+
+// The Paused returns how long host of the url is still paused, or 0 if it is not paused.
+func (this *AutoThrottle) Paused(rawurl string) time.Duration {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if d := this.host(throttleHostName(rawurl)).pausedUntil.Sub(time.Now()); d > 0 {
+        return d
+    }
+    return 0
+}
+
+func throttleHostName(rawurl string) string {
+    if u, err := url.Parse(rawurl); err == nil {
+        return u.Host
+    }
+    return rawurl
+}
+
+// The host returns state of the host. It is called with locker held.
+func (this *AutoThrottle) host(name string) *throttleHost {
+    h := this.hosts[name]
+    if h == nil {
+        h = &throttleHost{delay: this.startDelay}
+        this.hosts[name] = h
+    }
+    return h
+}
+
+// The wait blocks until a request to host of the url is allowed by the delay and cool-off of the host.
+// The request takes the next time of its host, so concurrent requests of the host are spaced out.
+func (this *AutoThrottle) wait(rawurl string) {
+    this.locker.Lock()
+    h := this.host(throttleHostName(rawurl))
+    now := time.Now()
+    start := h.next
+    if start.Before(h.pausedUntil) {
+        start = h.pausedUntil
+    }
+    if start.Before(now) {
+        start = now
+    }
+    h.next = start.Add(h.delay)
+    this.locker.Unlock()
+
+    if sleep := start.Sub(now); sleep > 0 {
+        time.Sleep(sleep)
+    }
+}
+
+// The isBanned tests whether the page is a ban signal.
+func (this *AutoThrottle) isBanned(p *page.Page) bool {
+    if this.banCodes[p.GetStatusCode()] {
+        return true
+    }
+    if !p.IsSucc() || len(this.banSignatures) == 0 {
+        return false
+    }
+    body := p.GetBodyStr()
+    for _, reg := range this.banSignatures {
+        if reg.MatchString(body) {
+            return true
+        }
+    }
+    return false
+}
+
+// The observe adapts delay of host of the page downloaded in latency.
+func (this *AutoThrottle) observe(p *page.Page, latency time.Duration) {
+    banned := this.isBanned(p)
+    rawurl := p.GetRequest().GetUrl()
+
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    h := this.host(throttleHostName(rawurl))
+    if banned {
+        h.delay *= 2
+        if h.delay < this.startDelay {
+            h.delay = this.startDelay
+        }
+        if h.delay <= 0 {
+            h.delay = time.Second
+        }
+        if h.bans++; this.banThreshold > 0 && h.bans >= this.banThreshold {
+            h.bans = 0
+            h.pausedUntil = time.Now().Add(this.coolOff)
+            logger.Warn("host is paused after ban signals", mlog.F("url", rawurl), mlog.F("cool_off", this.coolOff))
+        }
+    } else {
+        h.bans = 0
+        // failed downloads and error status do not make the delay shorter, as their latency is not real work
+        delay := (h.delay + time.Duration(float64(latency)/this.target)) / 2
+        if delay > h.delay || (p.IsSucc() && p.GetStatusCode() < 400) {
+            h.delay = delay
+        }
+    }
+    if this.maxDelay > 0 && h.delay > this.maxDelay {
+        h.delay = this.maxDelay
+    }
+}
+
+// The SetAutoThrottle adapts delay between requests of each host by the AutoThrottle, and pauses hosts
+// that answer with ban signals. It works with rate limits and delays set by other settings.
+func (this *Spider) SetAutoThrottle(t *AutoThrottle) *Spider {
+    this.autoThrottle = t
+    return this
+}
+
+// The throttlePaused requeues the request if its host is paused by AutoThrottle, so workers are not
+// blocked during the cool-off. It returns true if the request is requeued.
+func (this *Spider) throttlePaused(req *request.Request) bool {
+    if this.autoThrottle == nil {
+        return false
+    }
+    d := this.autoThrottle.Paused(req.GetUrl())
+    if d <= 0 {
+        return false
+    }
+    this.requeueAfter(req, d)
+    return true
+}
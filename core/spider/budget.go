@@ -0,0 +1,133 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/url"
+    "regexp"
+    "strings"
+    "sync"
+)
+
+// The Budget caps the number of pages crawled of each domain, so a broad crawl is not stuck in one huge site.
+// Pages are counted when they are going to be downloaded, and retries of a request are not counted again.
+// When the budget of a domain is used up, its requests are dropped before they are pushed to Scheduler,
+// and requests already in Scheduler are dropped when they are polled.
+// Urls matching a pattern are also limited by the budget of the pattern, which is shared by all the domains.
+type Budget struct {
+    perDomain int
+    domains   map[string]int
+    patterns  []*budgetPattern
+
+    locker sync.Mutex
+    counts map[string]int
+}
+
+type budgetPattern struct {
+    reg   *regexp.Regexp
+    limit int
+    count int
+}
+
+// NewBudget returns Budget of perDomain pages for each domain. The perDomain 0 means no limit,
+// so only domains set by SetDomainLimit and urls matching patterns are limited.
+func NewBudget(perDomain int) *Budget {
+    return &Budget{perDomain: perDomain, domains: make(map[string]int), counts: make(map[string]int)}
+}
+
+// The SetDomainLimit sets budget of the domain like "example.com", which is shared by its subdomains.
+// The limit 0 means no limit for the domain.
+func (this *Budget) SetDomainLimit(domain string, limit int) *Budget {
+    this.domains[strings.ToLower(domain)] = limit
+    return this
+}
+
+// The AddPattern limits urls matching the regexp to limit pages, like "/search\?" for search result pages.
+// It panics if the expr can not be compiled.
+func (this *Budget) AddPattern(expr string, limit int) *Budget {
+    this.patterns = append(this.patterns, &budgetPattern{reg: regexp.MustCompile(expr), limit: limit})
+    return this
+}
+
+// The Counts returns number of pages crawled of each domain. Subdomains of a domain set by SetDomainLimit
+// are counted in the domain.
+func (this *Budget) Counts() map[string]int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    counts := make(map[string]int, len(this.counts))
+    for domain, n := range this.counts {
+        counts[domain] = n
+    }
+    return counts
+}
+
+// The domain returns the domain counting the url and its limit.
+func (this *Budget) domain(rawurl string) (string, int) {
+    host := rawurl
+    if u, err := url.Parse(rawurl); err == nil {
+        host = u.Hostname()
+    }
+    host = strings.ToLower(host)
+    for name := host; name != ""; {
+        if limit, ok := this.domains[name]; ok {
+            return name, limit
+        }
+        i := strings.Index(name, ".")
+        if i < 0 {
+            break
+        }
+        name = name[i+1:]
+    }
+    return host, this.perDomain
+}
+
+// The exhausted tests whether the domain or a pattern of the request has no budget left.
+func (this *Budget) exhausted(req *request.Request) bool {
+    domain, limit := this.domain(req.GetUrl())
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if limit > 0 && this.counts[domain] >= limit {
+        return true
+    }
+    for _, p := range this.patterns {
+        if p.limit > 0 && p.count >= p.limit && p.reg.MatchString(req.GetUrl()) {
+            return true
+        }
+    }
+    return false
+}
+
+// The take counts a page of the request, and returns false if the budget is used up.
+func (this *Budget) take(req *request.Request) bool {
+    domain, limit := this.domain(req.GetUrl())
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if limit > 0 && this.counts[domain] >= limit {
+        return false
+    }
+    var matched []*budgetPattern
+    for _, p := range this.patterns {
+        if p.reg.MatchString(req.GetUrl()) {
+            if p.limit > 0 && p.count >= p.limit {
+                return false
+            }
+            matched = append(matched, p)
+        }
+    }
+    for _, p := range matched {
+        p.count++
+    }
+    this.counts[domain]++
+    return true
+}
+
+// The SetBudget limits pages crawled of each domain by the Budget. Requests over the budget are dropped,
+// and pages of each domain are in metrics as go_spider_budget_pages_total.
+func (this *Spider) SetBudget(b *Budget) *Spider {
+    this.budget = b
+    if b == nil {
+        this.metrics.setBudgetCounts(nil)
+    } else {
+        this.metrics.setBudgetCounts(b.Counts)
+    }
+    return this
+}
@@ -0,0 +1,160 @@
+package spider
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/http"
+    "net/url"
+    "regexp"
+)
+
+// The CaptchaSolver solves captcha of a page, like by sending its image or site key to an external solving
+// service, and returns the solution applied to the request downloaded again.
+type CaptchaSolver interface {
+    Solve(ctx context.Context, p *page.Page) (*CaptchaSolution, error)
+}
+
+// The CaptchaSolverFunc is a function used as CaptchaSolver.
+type CaptchaSolverFunc func(ctx context.Context, p *page.Page) (*CaptchaSolution, error)
+
+func (this CaptchaSolverFunc) Solve(ctx context.Context, p *page.Page) (*CaptchaSolution, error) {
+    return this(ctx, p)
+}
+
+// The CaptchaSolution is how the request passes the captcha: Cookies are saved in the cookie jar of
+// HttpDownloader, or sent in Cookie header if there is no jar, Params are added to query of the url and
+// Header is sent with the request, like a token of the solving service.
+type CaptchaSolution struct {
+    Cookies []*http.Cookie
+    Params  map[string]string
+    Header  map[string]string
+}
+
+// The apply returns copy of the request with the solution applied.
+func (this *CaptchaSolution) apply(sp *Spider, req *request.Request) *request.Request {
+    solved := req.Clone()
+    if len(this.Params) > 0 {
+        if u, err := url.Parse(req.GetUrl()); err == nil {
+            query := u.Query()
+            for key, value := range this.Params {
+                query.Set(key, value)
+            }
+            u.RawQuery = query.Encode()
+            solved.SetUrl(u.String())
+        }
+    }
+    for key, value := range this.Header {
+        solved.SetHeader(key, value)
+    }
+    if len(this.Cookies) > 0 {
+        d, ok := sp.findHttpDownloader()
+        u, err := url.Parse(solved.GetUrl())
+        if ok && d.GetCookieJar() != nil && err == nil {
+            d.GetCookieJar().SetCookies(u, this.Cookies)
+        } else {
+            for _, cookie := range this.Cookies {
+                solved.AddHeader("Cookie", (&http.Cookie{Name: cookie.Name, Value: cookie.Value}).String())
+            }
+        }
+    }
+    return solved
+}
+
+// The CaptchaDetector finds captcha pages by http status codes, css selectors of captcha widgets and regexps
+// of bodies. A page is a captcha page if any of the rules matches. Set its Detect by Spider.SetCaptchaHandler.
+type CaptchaDetector struct {
+    statusCodes map[int]bool
+    selectors   []string
+    markers     []*regexp.Regexp
+}
+
+// NewCaptchaDetector returns CaptchaDetector without rules, which finds no captcha page.
+func NewCaptchaDetector() *CaptchaDetector {
+    return &CaptchaDetector{statusCodes: make(map[int]bool)}
+}
+
+// The AddStatusCodes adds http status codes of captcha pages, like 429 of some anti-bot services.
+func (this *CaptchaDetector) AddStatusCodes(codes ...int) *CaptchaDetector {
+    for _, code := range codes {
+        this.statusCodes[code] = true
+    }
+    return this
+}
+
+// The AddSelector adds css selectors of captcha widgets in "html" pages, like ".g-recaptcha" or "#captcha".
+func (this *CaptchaDetector) AddSelector(selectors ...string) *CaptchaDetector {
+    this.selectors = append(this.selectors, selectors...)
+    return this
+}
+
+// The AddMarker adds regexps of bodies of captcha pages, like "(?i)verify you are human".
+// It panics if an expr can not be compiled.
+func (this *CaptchaDetector) AddMarker(exprs ...string) *CaptchaDetector {
+    for _, expr := range exprs {
+        this.markers = append(this.markers, regexp.MustCompile(expr))
+    }
+    return this
+}
+
+// The Detect returns whether the page is a captcha page.
+func (this *CaptchaDetector) Detect(p *page.Page) bool {
+    if this.statusCodes[p.GetStatusCode()] {
+        return true
+    }
+    body := p.GetBodyStr()
+    for _, marker := range this.markers {
+        if marker.MatchString(body) {
+            return true
+        }
+    }
+    if len(this.selectors) > 0 && p.GetRequest().GetResponceType() == "html" {
+        if doc := p.GetHtmlParser(); doc != nil {
+            for _, selector := range this.selectors {
+                if doc.Find(selector).Length() > 0 {
+                    return true
+                }
+            }
+        }
+    }
+    return false
+}
+
+// The SetCaptchaHandler sets function that detects captcha pages, like CaptchaDetector.Detect, and the solver
+// of them. When a captcha page is detected, the worker waits for the solver, and downloads the request again
+// with the solution applied, whose page has the request with the solution. If there is no solver, or the captcha is not solved, the page is set failed and
+// retried like a page rejected by the responce validator, so captcha pages never flow into results.
+// Captcha pages are counted by result in Metrics.
+func (this *Spider) SetCaptchaHandler(detect func(*page.Page) bool, solver CaptchaSolver) *Spider {
+    this.captchaDetect = detect
+    this.captchaSolver = solver
+    return this
+}
+
+// The solveCaptcha solves captcha of the page and downloads the request again. It returns the page, and the
+// error message if the captcha is not solved.
+func (this *Spider) solveCaptcha(ctx context.Context, req *request.Request, p *page.Page) (*page.Page, string) {
+    logger.Warn("captcha is detected", mlog.F("url", req.GetUrl()), mlog.F("status", p.GetStatusCode()))
+    if this.captchaSolver == nil {
+        this.metrics.captcha("failed")
+        return p, "captcha is detected"
+    }
+    solution, err := this.captchaSolver.Solve(ctx, p)
+    if err != nil || solution == nil {
+        this.metrics.captcha("failed")
+        msg := "captcha is not solved"
+        if err != nil {
+            msg += " : " + err.Error()
+        }
+        return p, msg
+    }
+    p.CloseBody()
+    p = this.fetch(ctx, solution.apply(this, req))
+    if p.GetStatusCode() != 0 && this.captchaDetect(p) {
+        this.metrics.captcha("failed")
+        return p, "captcha is not solved"
+    }
+    this.metrics.captcha("solved")
+    return p, ""
+}
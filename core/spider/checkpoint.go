@@ -0,0 +1,188 @@
+package spider
+
+import (
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strconv"
+    "time"
+)
+
+// The checkpointVersion is the version of checkpoint file format.
+// Checkpoint of newer version is refused instead of being read wrongly.
+const checkpointVersion = 1
+
+// The checkpoint is the content of checkpoint file.
+// Requests are requests left in Scheduler, being crawled and waiting to be retried when the checkpoint is taken.
+// Duplicate requests are removed by Scheduler again when they are added, so fingerprints are not saved.
+type checkpoint struct {
+    Version  int                `json:"version"`
+    Time     time.Time          `json:"time"`
+    Requests []*request.Request `json:"requests"`
+    // The Stats are statistics of the crawl so far, added to Stats of the run resuming the crawl.
+    Stats *Stats `json:"stats,omitempty"`
+}
+
+// The EnableCheckpoint saves checkpoint to the file every interval while Run is running, and when Run is stopped.
+// The checkpoint has requests left in Scheduler and requests being crawled, which are taken while dispatch
+// is blocked, so no request is lost. If Deduplicator of Scheduler is a scheduler.PersistentDeduplicator,
+// it is saved in file "<path>.dedup" too.
+// If the file exists when Run starts, the crawl is resumed from it after a crash or restart.
+// The files are removed when the crawl is complete.
+// The Scheduler needs to be a scheduler.InspectableScheduler.
+func (this *Spider) EnableCheckpoint(path string, interval time.Duration) *Spider {
+    this.checkpointPath = path
+    this.checkpointInterval = interval
+    return this
+}
+
+// The LoadCheckpoint adds requests in the checkpoint file to Scheduler, and adds fingerprints of the saved
+// Deduplicator to Deduplicator of Scheduler. Requests being crawled when the checkpoint was taken are crawled again.
+func (this *Spider) LoadCheckpoint(path string) error {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    var cp checkpoint
+    if err = json.Unmarshal(content, &cp); err != nil {
+        return err
+    }
+    if cp.Version > checkpointVersion {
+        return errors.New("checkpoint version " + strconv.Itoa(cp.Version) + " is not supported : " + path)
+    }
+    // requests are added before fingerprints, or they are removed as duplicate
+    this.AddRequests(cp.Requests)
+    if cp.Stats != nil {
+        this.runLocker.Lock()
+        this.stats.resume(cp.Stats)
+        this.runLocker.Unlock()
+    }
+    if d := this.persistentDeduplicator(); d != nil {
+        if err = d.Load(dedupPath(path)); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    }
+    return nil
+}
+
+// The dedupPath returns path of Deduplicator file of the checkpoint.
+func dedupPath(path string) string {
+    return path + ".dedup"
+}
+
+// The persistentDeduplicator returns Deduplicator of Scheduler if it can be saved, or nil.
+func (this *Spider) persistentDeduplicator() scheduler.PersistentDeduplicator {
+    s, ok := this.pScheduler.(scheduler.DeduplicatorScheduler)
+    if !ok {
+        return nil
+    }
+    d, _ := s.GetDeduplicator().(scheduler.PersistentDeduplicator)
+    return d
+}
+
+// The resumeCheckpoint loads the checkpoint file if checkpoint is enabled and the file exists.
+func (this *Spider) resumeCheckpoint() {
+    if this.checkpointPath == "" || this.checkpointInterval <= 0 {
+        return
+    }
+    if _, err := os.Stat(this.checkpointPath); err != nil {
+        return
+    }
+    if err := this.LoadCheckpoint(this.checkpointPath); err != nil {
+        logger.Error("checkpoint is not resumed : " + err.Error())
+        return
+    }
+    logger.Info("crawl is resumed from checkpoint : " + this.checkpointPath)
+}
+
+// The startCheckpoint starts saving checkpoint if it is enabled, and returns function that stops it.
+// The stop function saves the last checkpoint, or removes the files if the crawl is complete.
+func (this *Spider) startCheckpoint() func(complete bool) {
+    if this.checkpointPath == "" || this.checkpointInterval <= 0 {
+        return func(bool) {}
+    }
+    stop := make(chan struct{})
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        ticker := time.NewTicker(this.checkpointInterval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ticker.C:
+                this.saveCheckpoint()
+            case <-stop:
+                return
+            }
+        }
+    }()
+    return func(complete bool) {
+        close(stop)
+        <-done
+        if !complete {
+            this.saveCheckpoint()
+            return
+        }
+        for _, path := range []string{this.checkpointPath, dedupPath(this.checkpointPath)} {
+            if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+                logger.Error(err.Error())
+            }
+        }
+    }
+}
+
+// The saveCheckpoint writes requests in Scheduler and being crawled to the checkpoint file.
+func (this *Spider) saveCheckpoint() {
+    s, ok := this.pScheduler.(scheduler.InspectableScheduler)
+    if !ok {
+        logger.Error("scheduler can not be inspected, checkpoint is not saved")
+        return
+    }
+
+    // fingerprints are saved before requests, so that every fingerprint saved has its request saved or crawled
+    if d := this.persistentDeduplicator(); d != nil {
+        if err := d.Save(dedupPath(this.checkpointPath)); err != nil {
+            logger.Error(err.Error())
+            return
+        }
+    }
+
+    this.dispatchLocker.Lock()
+    reqs := s.Snapshot()
+    for req := range this.inflight {
+        reqs = append(reqs, req)
+    }
+    for req := range this.delayed {
+        if !this.inflight[req] {
+            reqs = append(reqs, req)
+        }
+    }
+    this.dispatchLocker.Unlock()
+
+    stats := this.stats.snapshot()
+    content, err := json.Marshal(&checkpoint{Version: checkpointVersion, Time: time.Now(), Requests: reqs, Stats: &stats})
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+
+    // write to temp file first so that a broken file is never read.
+    tmp, err := ioutil.TempFile(filepath.Dir(this.checkpointPath), "tmp")
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    _, err = tmp.Write(content)
+    tmp.Close()
+    if err == nil {
+        err = os.Rename(tmp.Name(), this.checkpointPath)
+    }
+    if err != nil {
+        logger.Error(err.Error())
+        os.Remove(tmp.Name())
+    }
+}
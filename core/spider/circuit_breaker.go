@@ -0,0 +1,171 @@
+package spider
+
+import (
+    "errors"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "sync"
+    "time"
+)
+
+// The ErrCircuitOpen is error of requests failed because circuit breaker of their host is open, passed to
+// failed request handler.
+var ErrCircuitOpen = errors.New("circuit breaker of host is open")
+
+// The circuitBreaker stops downloads of hosts that fail again and again.
+type circuitBreaker struct {
+    failures int
+    cooldown time.Duration
+
+    locker sync.Mutex
+    hosts  map[string]*hostCircuit
+}
+
+// The hostCircuit is state of circuit breaker of a host. The circuit is open until openUntil if it is not zero,
+// and probing is whether a probe request is being downloaded after it.
+type hostCircuit struct {
+    failures  int
+    openUntil time.Time
+    probing   bool
+}
+
+// The SetCircuitBreaker opens circuit breaker of a host after failures consecutive downloads of the host fail by
+// dns, connect, tls and timeout errors or 5xx status, so a dead host does not use up retries and workers.
+// Requests of the host are not downloaded while it is open; they are put aside until cooldown is over, which
+// uses one of their retries like SetRetryTimes, and they fail with ErrCircuitOpen when retries are used up.
+// After cooldown one request is downloaded as probe: the circuit is closed if it succeeds, or opened again for
+// cooldown if it fails. Pages of 4xx status do not count as failures of the host. The failures 0 disables it.
+func (this *Spider) SetCircuitBreaker(failures int, cooldown time.Duration) *Spider {
+    if failures <= 0 {
+        this.circuitBreaker = nil
+        return this
+    }
+    this.circuitBreaker = &circuitBreaker{failures: failures, cooldown: cooldown, hosts: make(map[string]*hostCircuit)}
+    return this
+}
+
+// The GetOpenCircuits returns hosts whose circuit breakers are open with time they are probed after.
+func (this *Spider) GetOpenCircuits() map[string]time.Time {
+    open := make(map[string]time.Time)
+    if this.circuitBreaker == nil {
+        return open
+    }
+    this.circuitBreaker.locker.Lock()
+    defer this.circuitBreaker.locker.Unlock()
+    for host, c := range this.circuitBreaker.hosts {
+        if !c.openUntil.IsZero() {
+            open[host] = c.openUntil
+        }
+    }
+    return open
+}
+
+// The circuitFailure tests whether the error type of errorType is a failure of the host.
+func circuitFailure(t string) bool {
+    switch t {
+    case "dns", "connect", "tls", "timeout", "network", "http_5xx":
+        return true
+    }
+    return false
+}
+
+// The allow returns whether the request of the host can be downloaded, or how long its host is still open.
+// The first request after cooldown is allowed as probe.
+func (this *circuitBreaker) allow(host string) (time.Duration, bool) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    c, ok := this.hosts[host]
+    if !ok || c.openUntil.IsZero() {
+        return 0, true
+    }
+    if d := c.openUntil.Sub(time.Now()); d > 0 {
+        return d, false
+    }
+    if c.probing {
+        return this.cooldown, false
+    }
+    c.probing = true
+    return 0, true
+}
+
+// The isOpen tests whether circuit of the host is open, or a probe of it is being downloaded.
+func (this *circuitBreaker) isOpen(host string) bool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    c, ok := this.hosts[host]
+    return ok && !c.openUntil.IsZero()
+}
+
+// The observe counts outcome of the page downloaded, and returns true if circuit of its host is opened by it.
+func (this *circuitBreaker) observe(p *page.Page) bool {
+    host := requestHost(p.GetRequest())
+    failed := (!p.IsSucc() || p.GetStatusCode() >= 400) && circuitFailure(errorType(p))
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    c, ok := this.hosts[host]
+    if p.Context().Err() != nil {
+        // a canceled download tells nothing about the host
+        if ok {
+            c.probing = false
+        }
+        return false
+    }
+    if !failed {
+        delete(this.hosts, host)
+        return false
+    }
+    if !ok {
+        c = &hostCircuit{}
+        this.hosts[host] = c
+    }
+    c.failures++
+    if c.probing || c.openUntil.IsZero() && c.failures >= this.failures {
+        c.openUntil, c.probing = time.Now().Add(this.cooldown), false
+        return true
+    }
+    return false
+}
+
+// The circuitOpen puts aside the request if circuit of its host is open, or fails it if its retries are used up.
+// It returns false if the request can be downloaded.
+func (this *Spider) circuitOpen(req *request.Request) bool {
+    if this.circuitBreaker == nil {
+        return false
+    }
+    d, ok := this.circuitBreaker.allow(requestHost(req))
+    if ok {
+        return false
+    }
+    n := req.GetRetries() + 1
+    if n > this.retryBudget(req) {
+        logger.Warn(ErrCircuitOpen.Error(), mlog.F("url", req.GetUrl()))
+        this.dropRequest("circuit_open")
+        if this.failedRequestHandler != nil {
+            this.failedRequestHandler(req, ErrCircuitOpen)
+        }
+        this.deadLetter(req.Context(), &DeadLetter{Kind: DeadLetterRequest, Request: req, Attempts: req.GetRetries(),
+            Error: ErrCircuitOpen.Error()}, nil)
+        return true
+    }
+    req.SetRetries(n)
+    this.requeueAfter(req, d)
+    return true
+}
+
+// The retryable tests whether the failed request can be downloaded again at once, which it can not if
+// circuit of its host is open.
+func (this *Spider) retryable(req *request.Request) bool {
+    return this.circuitBreaker == nil || !this.circuitBreaker.isOpen(requestHost(req))
+}
+
+// The observeCircuit counts outcome of the page downloaded by circuit breaker.
+func (this *Spider) observeCircuit(p *page.Page) {
+    if this.circuitBreaker == nil {
+        return
+    }
+    if this.circuitBreaker.observe(p) {
+        logger.Warn("circuit breaker of host is opened", mlog.F("host", requestHost(p.GetRequest())),
+            mlog.F("error", errorType(p)), mlog.F("cooldown", this.circuitBreaker.cooldown))
+    }
+}
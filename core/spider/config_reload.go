@@ -0,0 +1,152 @@
+package spider
+
+import (
+    "bytes"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/page_processer"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "io/ioutil"
+    "net/url"
+    "reflect"
+    "strings"
+    "time"
+)
+
+// The Reload applies crawl rules of the Config to the Spider while Run is running, like a new url filter or
+// rate limit of a long-running monitor, without restarting it. Requests being crawled finish by the old rules,
+// and requests after them follow the new ones. The Config is the whole truth: rules removed from it are reset,
+// like no max depth, no url filter, no delays and rate limits, all errors retried and no headers, except that
+// threadnum, threadnum_per_host and retry_times not set keep their values.
+// The reloaded rules are processor (a registered PageProcesser, for new extraction rules) or rules (its file
+// is read again), threadnum, threadnum_per_host, max_depth, retry_times, retry_status_codes, timeouts,
+// random_delay, host_delay, global_rate_limit, host_rate_limit, bandwidth, host_bandwidth, headers and filter;
+// if filter stays on seed domains, domains of seeds of the Config are allowed. Other settings like seeds,
+// pipelines, proxies and user agents need a restart.
+// Nothing is changed if the Config has an error, like a bad regexp, an unregistered processor or bad rules.
+func (this *Config) Reload(sp *Spider) error {
+    var p page_processer.PageProcesser
+    if this.Processor != "" || this.Rules != "" {
+        var err error
+        if p, err = this.pageProcesser(); err != nil {
+            return err
+        }
+    }
+    var filter *scheduler.UrlFilter
+    if this.Filter != nil {
+        var err error
+        if filter, err = this.Filter.urlFilter(); err != nil {
+            return err
+        }
+        if this.Filter.StayOnSeedDomains {
+            for _, seed := range this.Seeds {
+                if u, err := url.Parse(seed); err == nil && u.Hostname() != "" {
+                    filter.AllowDomains(strings.ToLower(u.Hostname()))
+                }
+            }
+        }
+    }
+    if this.RandomDelay.Min > this.RandomDelay.Max {
+        return errors.New("min of random_delay must not be larger than max")
+    }
+
+    if p != nil && p != sp.GetPageProcesser() {
+        sp.SetPageProcesser(p)
+    }
+    if this.Threadnum > 0 {
+        sp.SetThreadnum(this.Threadnum)
+    }
+    if this.ThreadnumPerHost > 0 {
+        sp.SetThreadnumPerHost(this.ThreadnumPerHost)
+    }
+    sp.SetMaxDepth(this.MaxDepth)
+    if this.RetryTimes != nil {
+        sp.SetRetryTimes(*this.RetryTimes)
+    }
+    sp.SetRetryStatusCodes(this.RetryStatusCodes)
+    if d, ok := sp.findHttpDownloader(); ok {
+        t := this.Timeouts
+        d.SetTimeouts(request.Timeouts{Connect: time.Duration(t.Connect), TLSHandshake: time.Duration(t.TLSHandshake),
+            ResponseHeader: time.Duration(t.ResponseHeader), Total: time.Duration(t.Total)})
+        d.SetBandwidth(this.Bandwidth).SetHostBandwidth(this.HostBandwidth)
+    }
+    sp.SetRandomDelay(time.Duration(this.RandomDelay.Min), time.Duration(this.RandomDelay.Max))
+    sp.SetHostDelay(time.Duration(this.HostDelay.Min), time.Duration(this.HostDelay.Max))
+    sp.SetGlobalRateLimit(this.GlobalRateLimit)
+    sp.SetHostRateLimit(this.HostRateLimit)
+    sp.setHeaders(this.Headers)
+    sp.SetUrlFilter(filter)
+    return nil
+}
+
+// The restartSettings returns copy of the Config with the rules reloaded by Reload cleared, for finding out
+// changes that need a restart.
+func (this Config) restartSettings() Config {
+    this.Processor, this.Rules, this.Threadnum, this.ThreadnumPerHost, this.MaxDepth = "", "", 0, 0, 0
+    this.RetryTimes, this.RetryStatusCodes, this.Timeouts = nil, nil, TimeoutsConfig{}
+    this.RandomDelay, this.HostDelay, this.GlobalRateLimit, this.HostRateLimit = DelayConfig{}, DelayConfig{}, 0, 0
+    this.Bandwidth, this.HostBandwidth = 0, 0
+    this.Headers, this.Filter, this.Seeds = nil, nil, nil
+    return this
+}
+
+// The WatchConfig checks the config file every interval, and reloads it by Config.Reload when its content is
+// changed, so crawl rules are tweaked by editing the file. A config with error is logged and the rules are kept.
+// Changes of settings that are not reloaded are logged as warning. It returns function that stops watching.
+func (this *Spider) WatchConfig(path string, interval time.Duration) func() {
+    last, err := LoadConfig(path)
+    if err != nil {
+        logger.Error("config is not watched : "+err.Error(), mlog.F("path", path))
+    }
+    return WatchFile(path, interval, func() error {
+        c, err := LoadConfig(path)
+        if err != nil {
+            return err
+        }
+        if err = c.Reload(this); err != nil {
+            return err
+        }
+        if last != nil && !reflect.DeepEqual(last.restartSettings(), c.restartSettings()) {
+            logger.Warn("config is reloaded, but changes of seeds, pipelines, proxies or other settings need a restart",
+                mlog.F("path", path))
+        } else {
+            logger.Info("config is reloaded", mlog.F("path", path))
+        }
+        last = c
+        return nil
+    })
+}
+
+// The WatchFile checks the file every interval, and calls reload when its content is changed, like for
+// reloading extraction rules of a spec file. Errors of reload are logged, and reload is called again when the
+// file is changed again. It returns function that stops watching, and waits for a reload being done.
+func WatchFile(path string, interval time.Duration, reload func() error) func() {
+    content, _ := ioutil.ReadFile(path)
+    quit := make(chan struct{})
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        for {
+            select {
+            case <-quit:
+                return
+            case <-ticker.C:
+            }
+            current, err := ioutil.ReadFile(path)
+            if err != nil || bytes.Equal(current, content) {
+                continue
+            }
+            content = current
+            if err = reload(); err != nil {
+                logger.Error("file is not reloaded : "+err.Error(), mlog.F("path", path))
+            }
+        }
+    }()
+    return func() {
+        close(quit)
+        <-done
+    }
+}
@@ -0,0 +1,141 @@
+package spider
+
+import (
+    "crypto/md5"
+    "encoding/hex"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/util"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "golang.org/x/net/html"
+    "strings"
+    "sync"
+)
+
+// The ContentDeduplicator finds pages whose content is seen before, like the same page of a mirror or of
+// urls with different params. Function Duplicate records the page and returns whether it is duplicate.
+type ContentDeduplicator interface {
+    Duplicate(p *page.Page) bool
+}
+
+// The SetContentDeduplicator sets ContentDeduplicator, so that pages of duplicate content are not processed
+// and their links are not followed. Pages crawled again by Revisit are duplicate if they are not changed.
+func (this *Spider) SetContentDeduplicator(d ContentDeduplicator) *Spider {
+    this.contentDeduplicator = d
+    return this
+}
+
+// The duplicateContent tests whether the page is duplicate by ContentDeduplicator.
+func (this *Spider) duplicateContent(p *page.Page) bool {
+    if this.contentDeduplicator == nil || !p.IsSucc() || !this.contentDeduplicator.Duplicate(p) {
+        return false
+    }
+    logger.Info("duplicate content is skipped", mlog.F("url", p.GetRequest().GetUrl()))
+    this.metrics.duplicate()
+    this.stats.duplicate()
+    return true
+}
+
+// The ContentHashDeduplicator finds pages of exactly the same body by md5 of the body.
+// Md5 are recorded in a scheduler.Deduplicator, like BloomDeduplicator for large crawls or RedisDeduplicator
+// shared by spiders.
+type ContentHashDeduplicator struct {
+    d scheduler.Deduplicator
+}
+
+// NewContentHashDeduplicator returns ContentHashDeduplicator that records md5 in d, or in a
+// scheduler.MapDeduplicator if d is nil.
+func NewContentHashDeduplicator(d scheduler.Deduplicator) *ContentHashDeduplicator {
+    if d == nil {
+        d = scheduler.NewMapDeduplicator()
+    }
+    return &ContentHashDeduplicator{d: d}
+}
+
+func (this *ContentHashDeduplicator) Duplicate(p *page.Page) bool {
+    body := p.GetBodyStr()
+    if body == "" {
+        return false
+    }
+    sum := md5.Sum([]byte(body))
+    return this.d.Seen("content:" + hex.EncodeToString(sum[:]))
+}
+
+// The SimHashDeduplicator finds pages of nearly the same text, like pages differing in ads or timestamps only,
+// by simhash of visible text of html pages or of body of other pages. Pages whose simhashes differ in no more
+// than max distance bits are duplicate.
+// Simhashes are indexed by four 16 bits bands, so a near one shares a band by pigeonhole, and max distance
+// is at most 3.
+type SimHashDeduplicator struct {
+    maxDistance int
+
+    locker sync.Mutex
+    bands  [4]map[uint16][]uint64
+}
+
+// NewSimHashDeduplicator returns SimHashDeduplicator of the max distance, from 0 to 3. 3 is usual for pages.
+func NewSimHashDeduplicator(maxDistance int) *SimHashDeduplicator {
+    if maxDistance < 0 {
+        maxDistance = 0
+    } else if maxDistance > 3 {
+        maxDistance = 3
+    }
+    d := &SimHashDeduplicator{maxDistance: maxDistance}
+    for i := range d.bands {
+        d.bands[i] = make(map[uint16][]uint64)
+    }
+    return d
+}
+
+func (this *SimHashDeduplicator) Duplicate(p *page.Page) bool {
+    text := p.GetBodyStr()
+    if doc := p.GetHtmlParser(); doc != nil {
+        var b strings.Builder
+        for _, n := range doc.Nodes {
+            visibleText(n, &b)
+        }
+        text = b.String()
+    }
+    if strings.TrimSpace(text) == "" {
+        return false
+    }
+    return this.Seen(util.SimHash(text))
+}
+
+// The Seen adds the simhash and returns whether a near one has been added before.
+func (this *SimHashDeduplicator) Seen(hash uint64) bool {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    for i, band := range this.bands {
+        for _, h := range band[uint16(hash>>(16*uint(i)))] {
+            if util.HammingDistance(h, hash) <= this.maxDistance {
+                return true
+            }
+        }
+    }
+    for i, band := range this.bands {
+        key := uint16(hash >> (16 * uint(i)))
+        band[key] = append(band[key], hash)
+    }
+    return false
+}
+
+// The visibleText writes text of the node without scripts, styles and comments.
+func visibleText(n *html.Node, b *strings.Builder) {
+    switch n.Type {
+    case html.TextNode:
+        b.WriteString(n.Data)
+        b.WriteString(" ")
+        return
+    case html.ElementNode:
+        switch n.Data {
+        case "script", "style", "noscript", "template":
+            return
+        }
+    case html.CommentNode:
+        return
+    }
+    for c := n.FirstChild; c != nil; c = c.NextSibling {
+        visibleText(c, b)
+    }
+}
@@ -0,0 +1,269 @@
+package spider
+
+import (
+    "bufio"
+    "encoding/xml"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "io"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// The CrawlNode is a url of CrawlGraph. Crawled is whether it is downloaded, with StatusCode of its responce
+// and Error of a failed download.
+type CrawlNode struct {
+    Url        string `json:"url"`
+    Depth      int    `json:"depth"`
+    Crawled    bool   `json:"crawled"`
+    StatusCode int    `json:"status_code"`
+    Error      string `json:"error,omitempty"`
+}
+
+// The CrawlEdge is a link from page of From to request of To. Dropped is why the request was not pushed to
+// Scheduler, like "depth", "filter" or "budget", or "" if it was pushed.
+type CrawlEdge struct {
+    From    string `json:"from"`
+    To      string `json:"to"`
+    Dropped string `json:"dropped,omitempty"`
+}
+
+// The CrawlGraph records which page discovered which urls, so site structure and link depth can be analyzed,
+// and Path tells why a page was reached. Set it by Spider.SetCrawlGraph and export it by WriteEdgeList,
+// WriteGraphML or WriteDot after Run. It keeps every url and link in memory.
+type CrawlGraph struct {
+    locker sync.Mutex
+    nodes  map[string]*CrawlNode
+    order  []string
+    edges  []CrawlEdge
+    // The parents are the first page discovering each url.
+    parents map[string]string
+}
+
+func NewCrawlGraph() *CrawlGraph {
+    return &CrawlGraph{nodes: make(map[string]*CrawlNode), parents: make(map[string]string)}
+}
+
+// The node returns node of the url, adding it if it is new. It is called with locker held.
+func (this *CrawlGraph) node(url string) *CrawlNode {
+    n, ok := this.nodes[url]
+    if !ok {
+        n = &CrawlNode{Url: url}
+        this.nodes[url] = n
+        this.order = append(this.order, url)
+    }
+    return n
+}
+
+// The link records link from the page to the request, which was dropped for the reason if it is not "".
+func (this *CrawlGraph) link(from *page.Page, to *request.Request, dropped string) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    src := this.node(from.GetRequest().GetUrl())
+    dst := this.node(to.GetUrl())
+    if _, ok := this.parents[dst.Url]; !ok && dst.Url != src.Url {
+        this.parents[dst.Url] = src.Url
+        dst.Depth = to.GetDepth()
+    }
+    this.edges = append(this.edges, CrawlEdge{From: src.Url, To: dst.Url, Dropped: dropped})
+}
+
+// The crawled records outcome of download of the page.
+func (this *CrawlGraph) crawled(p *page.Page) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    n := this.node(p.GetRequest().GetUrl())
+    n.Crawled, n.StatusCode, n.Depth = true, p.GetStatusCode(), p.GetRequest().GetDepth()
+    n.Error = ""
+    if !p.IsSucc() {
+        n.Error = p.Errormsg()
+    }
+}
+
+// The Nodes returns urls of the graph in order they are found.
+func (this *CrawlGraph) Nodes() []CrawlNode {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    nodes := make([]CrawlNode, len(this.order))
+    for i, url := range this.order {
+        nodes[i] = *this.nodes[url]
+    }
+    return nodes
+}
+
+// The Edges returns links of the graph in order they are found. A link found again is recorded again.
+func (this *CrawlGraph) Edges() []CrawlEdge {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return append([]CrawlEdge(nil), this.edges...)
+}
+
+// The Node returns node of the url, and false if the url is not in the graph.
+func (this *CrawlGraph) Node(url string) (CrawlNode, bool) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    n, ok := this.nodes[url]
+    if !ok {
+        return CrawlNode{}, false
+    }
+    return *n, true
+}
+
+// The Path returns urls from a seed to the url by the first page discovering each of them, like
+// [seed, category, url]. It returns nil if the url is not in the graph.
+func (this *CrawlGraph) Path(url string) []string {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if _, ok := this.nodes[url]; !ok {
+        return nil
+    }
+    path := []string{url}
+    seen := map[string]bool{url: true}
+    for {
+        parent, ok := this.parents[url]
+        if !ok || seen[parent] {
+            break
+        }
+        path = append(path, parent)
+        seen[parent] = true
+        url = parent
+    }
+    for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+        path[i], path[j] = path[j], path[i]
+    }
+    return path
+}
+
+// The WriteEdgeList writes links as lines of tab separated from, to and dropped reason.
+func (this *CrawlGraph) WriteEdgeList(w io.Writer) error {
+    bw := bufio.NewWriter(w)
+    for _, e := range this.Edges() {
+        fmt.Fprintf(bw, "%s\t%s\t%s\n", e.From, e.To, e.Dropped)
+    }
+    return bw.Flush()
+}
+
+// The WriteDot writes the graph in DOT language of Graphviz. Urls not crawled are dashed, and failed ones red.
+func (this *CrawlGraph) WriteDot(w io.Writer) error {
+    bw := bufio.NewWriter(w)
+    bw.WriteString("digraph crawl {\n")
+    for _, n := range this.Nodes() {
+        attrs := []string{"depth=" + strconv.Itoa(n.Depth)}
+        if n.Crawled {
+            attrs = append(attrs, "status="+strconv.Itoa(n.StatusCode))
+        } else {
+            attrs = append(attrs, "style=dashed")
+        }
+        if n.Error != "" || n.StatusCode >= 400 {
+            attrs = append(attrs, "color=red")
+        }
+        fmt.Fprintf(bw, "  %s [%s];\n", strconv.Quote(n.Url), strings.Join(attrs, ", "))
+    }
+    for _, e := range this.Edges() {
+        if e.Dropped != "" {
+            fmt.Fprintf(bw, "  %s -> %s [label=%s, style=dotted];\n", strconv.Quote(e.From), strconv.Quote(e.To),
+                strconv.Quote(e.Dropped))
+        } else {
+            fmt.Fprintf(bw, "  %s -> %s;\n", strconv.Quote(e.From), strconv.Quote(e.To))
+        }
+    }
+    bw.WriteString("}\n")
+    return bw.Flush()
+}
+
+// The graphmlKey is a declared attribute of GraphML.
+type graphmlKey struct {
+    Id   string `xml:"id,attr"`
+    For  string `xml:"for,attr"`
+    Name string `xml:"attr.name,attr"`
+    Type string `xml:"attr.type,attr"`
+}
+
+type graphmlData struct {
+    Key   string `xml:"key,attr"`
+    Value string `xml:",chardata"`
+}
+
+type graphmlNode struct {
+    Id   string        `xml:"id,attr"`
+    Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+    Source string        `xml:"source,attr"`
+    Target string        `xml:"target,attr"`
+    Data   []graphmlData `xml:"data,omitempty"`
+}
+
+type graphml struct {
+    XMLName xml.Name     `xml:"graphml"`
+    Xmlns   string       `xml:"xmlns,attr"`
+    Keys    []graphmlKey `xml:"key"`
+    Graph   struct {
+        EdgeDefault string        `xml:"edgedefault,attr"`
+        Nodes       []graphmlNode `xml:"node"`
+        Edges       []graphmlEdge `xml:"edge"`
+    } `xml:"graph"`
+}
+
+// The WriteGraphML writes the graph in GraphML, for tools like Gephi. Nodes have url, depth, crawled,
+// status code and error, and edges have dropped reason.
+func (this *CrawlGraph) WriteGraphML(w io.Writer) error {
+    doc := graphml{Xmlns: "http://graphml.graphdrawing.org/xmlns", Keys: []graphmlKey{
+        {"url", "node", "url", "string"},
+        {"depth", "node", "depth", "int"},
+        {"crawled", "node", "crawled", "boolean"},
+        {"status", "node", "status_code", "int"},
+        {"error", "node", "error", "string"},
+        {"dropped", "edge", "dropped", "string"},
+    }}
+    doc.Graph.EdgeDefault = "directed"
+    ids := make(map[string]string)
+    for i, n := range this.Nodes() {
+        id := "n" + strconv.Itoa(i)
+        ids[n.Url] = id
+        data := []graphmlData{{"url", n.Url}, {"depth", strconv.Itoa(n.Depth)},
+            {"crawled", strconv.FormatBool(n.Crawled)}, {"status", strconv.Itoa(n.StatusCode)}}
+        if n.Error != "" {
+            data = append(data, graphmlData{"error", n.Error})
+        }
+        doc.Graph.Nodes = append(doc.Graph.Nodes, graphmlNode{Id: id, Data: data})
+    }
+    for _, e := range this.Edges() {
+        edge := graphmlEdge{Source: ids[e.From], Target: ids[e.To]}
+        if e.Dropped != "" {
+            edge.Data = []graphmlData{{"dropped", e.Dropped}}
+        }
+        doc.Graph.Edges = append(doc.Graph.Edges, edge)
+    }
+    if _, err := io.WriteString(w, xml.Header); err != nil {
+        return err
+    }
+    enc := xml.NewEncoder(w)
+    enc.Indent("", "  ")
+    if err := enc.Encode(doc); err != nil {
+        return err
+    }
+    _, err := io.WriteString(w, "\n")
+    return err
+}
+
+// The Depths returns number of pages of each depth, counting urls crawled only.
+func (this *CrawlGraph) Depths() map[int]int {
+    depths := make(map[int]int)
+    for _, n := range this.Nodes() {
+        if n.Crawled {
+            depths[n.Depth]++
+        }
+    }
+    return depths
+}
+
+// The SetCrawlGraph sets CrawlGraph recording links from each processed page to its target requests, and
+// downloads of pages.
+func (this *Spider) SetCrawlGraph(g *CrawlGraph) *Spider {
+    this.crawlGraph = g
+    return this
+}
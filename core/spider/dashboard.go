@@ -0,0 +1,353 @@
+package spider
+
+import (
+    "crypto/subtle"
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "io"
+    "io/ioutil"
+    "mime"
+    "net"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+var errInvalidThreadnum = errors.New("threadnum should be a positive integer")
+
+// The maxQueueList is the most requests listed at "/queue" of the dashboard.
+const maxQueueList = 1000
+
+// The QueueStatus is requests in Scheduler listed by the dashboard. Requests is nil if Scheduler is not a
+// scheduler.InspectableScheduler.
+type QueueStatus struct {
+    Depth    int                `json:"depth"`
+    Requests []*request.Request `json:"requests"`
+}
+
+// The Status is the state of Spider shown by the dashboard.
+type Status struct {
+    Taskname      string            `json:"taskname"`
+    Running       bool              `json:"running"`
+    Paused        bool              `json:"paused"`
+    Stopped       bool              `json:"stopped"`
+    Threadnum     uint              `json:"threadnum"`
+    ActiveWorkers uint              `json:"active_workers"`
+    QueueDepth    int               `json:"queue_depth"`
+    PipelineQueue int               `json:"pipeline_queue"`
+    Pages         uint64            `json:"pages"`
+    Errors        uint64            `json:"errors"`
+    Bytes         uint64            `json:"bytes"`
+    Dropped       map[string]uint64 `json:"dropped"`
+    Hosts         []HostStatus      `json:"hosts"`
+    RecentErrors  []ErrorRecord     `json:"recent_errors"`
+}
+
+// The HostStatus is downloads of a host.
+type HostStatus struct {
+    Host           string  `json:"host"`
+    Pages          uint64  `json:"pages"`
+    Errors         uint64  `json:"errors"`
+    AverageSeconds float64 `json:"average_seconds"`
+}
+
+// The status fills counters of Metrics in s.
+func (this *Metrics) status(s *Status) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    for _, n := range this.pages {
+        s.Pages += n
+    }
+    for _, n := range this.errors {
+        s.Errors += n
+    }
+    s.Bytes = this.bytes
+    s.Dropped = make(map[string]uint64, len(this.dropped))
+    for reason, n := range this.dropped {
+        s.Dropped[reason] = n
+    }
+    s.Hosts = make([]HostStatus, 0, len(this.hosts))
+    for host, l := range this.hosts {
+        s.Hosts = append(s.Hosts, HostStatus{Host: host, Pages: l.count, Errors: l.errors,
+            AverageSeconds: l.seconds / float64(l.count)})
+    }
+    sort.Slice(s.Hosts, func(i, j int) bool { return s.Hosts[i].Host < s.Hosts[j].Host })
+    s.RecentErrors = append([]ErrorRecord(nil), this.recentErrors...)
+}
+
+// The Status returns the current state of Spider.
+func (this *Spider) Status() Status {
+    s := Status{
+        Taskname:   this.taskname,
+        Paused:     this.IsPaused(),
+        Stopped:    this.isStopped(),
+        QueueDepth: this.queueDepth(),
+    }
+    this.runLocker.Lock()
+    s.Running = this.runDone != nil
+    s.Threadnum = this.threadnum
+    if s.Running && this.mc != nil {
+        s.ActiveWorkers = this.mc.Has()
+    }
+    if this.pipelineQueue != nil {
+        s.PipelineQueue = this.pipelineQueue.len()
+    }
+    this.runLocker.Unlock()
+    this.metrics.status(&s)
+    return s
+}
+
+// The Dashboard returns http.Handler of the dashboard, which shows queue depth, active workers, throughput,
+// hosts and recent errors of Spider, and has buttons to pause, resume and stop it and change its threadnum.
+// Besides the page at "/", it serves Status as json at "/status", Metrics at "/metrics", recent errors as json
+// at "/errors" and QueueStatus of the first n requests (form value "n", default 100) at "/queue", and takes
+// POST requests at "/pause", "/resume", "/stop" and "/threadnum" with query value "n". A POST request at
+// "/config" reloads crawl rules by Config.Reload from its body, which is json, or yaml or toml by Content-Type
+// like "application/yaml". A POST request at "/seeds" adds seeds while Run is running, which are a json array
+// of urls or of objects of "url" and optional "tag", with responce type of query value "type" ("html" by
+// default). Other fields of requests, like headers, sessions or proxies, are not taken from seeds. Set
+// SetExitWhenComplete(false) to keep Run waiting for seeds.
+// Control requests need Content-Type of json, yaml or toml and no Origin of other sites, so web pages can not
+// send them by forms or simple cross-origin requests. If SetDashboardToken is set, all the requests but the
+// page at "/" need the token.
+func (this *Spider) Dashboard() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/" {
+            http.NotFound(w, r)
+            return
+        }
+        w.Header().Set("Content-Type", "text/html; charset=utf-8")
+        w.Write([]byte(dashboardPage))
+    })
+    mux.HandleFunc("/status", this.authorized(this.serveStatus))
+    mux.HandleFunc("/metrics", this.authorized(this.metrics.ServeHTTP))
+    mux.HandleFunc("/errors", this.authorized(func(w http.ResponseWriter, r *http.Request) {
+        writeJson(w, this.metrics.RecentErrors())
+    }))
+    mux.HandleFunc("/queue", this.authorized(this.serveQueue))
+    control := map[string]func(r *http.Request) error{
+        "/pause": func(*http.Request) error {
+            this.Pause()
+            return nil
+        },
+        "/resume": func(*http.Request) error {
+            this.Resume()
+            return nil
+        },
+        "/stop": func(*http.Request) error {
+            this.Stop()
+            return nil
+        },
+        "/threadnum": func(r *http.Request) error {
+            n, err := strconv.ParseUint(r.FormValue("n"), 10, 32)
+            if err != nil || n == 0 {
+                return errInvalidThreadnum
+            }
+            this.SetThreadnum(uint(n))
+            return nil
+        },
+        "/config": func(r *http.Request) error {
+            content, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+            if err != nil {
+                return err
+            }
+            ext := ".json"
+            if t := r.Header.Get("Content-Type"); strings.Contains(t, "yaml") {
+                ext = ".yaml"
+            } else if strings.Contains(t, "toml") {
+                ext = ".toml"
+            }
+            c, err := parseConfig(content, ext)
+            if err != nil {
+                return errors.New("config : " + err.Error())
+            }
+            return c.Reload(this)
+        },
+        "/seeds": func(r *http.Request) error {
+            reqs, err := readSeeds(r)
+            if err != nil {
+                return err
+            }
+            this.AddRequests(reqs)
+            return nil
+        },
+    }
+    for path, f := range control {
+        f := f
+        mux.HandleFunc(path, this.authorized(func(w http.ResponseWriter, r *http.Request) {
+            if r.Method != "POST" {
+                w.Header().Set("Allow", "POST")
+                http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+                return
+            }
+            if !sameOrigin(r) {
+                http.Error(w, "cross-origin request is refused", http.StatusForbidden)
+                return
+            }
+            if !controlContentType(r.Header.Get("Content-Type")) {
+                http.Error(w, "Content-Type should be json, yaml or toml", http.StatusUnsupportedMediaType)
+                return
+            }
+            if err := f(r); err != nil {
+                http.Error(w, err.Error(), http.StatusBadRequest)
+                return
+            }
+            this.serveStatus(w, r)
+        }))
+    }
+    return mux
+}
+
+// The SetDashboardToken sets token that requests to Dashboard need, in header "Authorization: Bearer <token>"
+// or in form value "token", like "?token=<token>" of the dashboard page. The "" means no token, which is
+// default, so the dashboard should be reachable only by the operator.
+func (this *Spider) SetDashboardToken(token string) *Spider {
+    this.dashboardToken = token
+    return this
+}
+
+// The authorized returns handler that refuses requests without the token of SetDashboardToken.
+func (this *Spider) authorized(h http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if this.dashboardToken != "" {
+            token := r.URL.Query().Get("token")
+            if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+                token = strings.TrimPrefix(auth, "Bearer ")
+            }
+            if subtle.ConstantTimeCompare([]byte(token), []byte(this.dashboardToken)) != 1 {
+                w.Header().Set("WWW-Authenticate", `Bearer realm="go_spider"`)
+                http.Error(w, "unauthorized", http.StatusUnauthorized)
+                return
+            }
+        }
+        h(w, r)
+    }
+}
+
+// The sameOrigin tests whether the request is not sent by a page of another site, by its Origin and
+// Sec-Fetch-Site headers which browsers set.
+func sameOrigin(r *http.Request) bool {
+    switch r.Header.Get("Sec-Fetch-Site") {
+    case "", "same-origin", "none":
+    default:
+        return false
+    }
+    origin := r.Header.Get("Origin")
+    if origin == "" {
+        return true
+    }
+    u, err := url.Parse(origin)
+    return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// The controlContentType tests whether Content-Type of a control request is json, yaml or toml, which web
+// pages of other sites can not send without a preflight request.
+func controlContentType(contentType string) bool {
+    mediatype, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return false
+    }
+    switch mediatype {
+    case "application/json", "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml",
+        "application/toml", "text/toml", "text/x-toml":
+        return true
+    }
+    return false
+}
+
+// The seed is a seed at "/seeds", a json string of its url or a json object of its url and tag.
+type seed struct {
+    Url string `json:"url"`
+    Tag string `json:"tag"`
+}
+
+func (this *seed) UnmarshalJSON(data []byte) error {
+    if len(data) > 0 && data[0] == '"' {
+        return json.Unmarshal(data, &this.Url)
+    }
+    type fields seed
+    return json.Unmarshal(data, (*fields)(this))
+}
+
+// The readSeeds returns requests of body of the request at "/seeds".
+func readSeeds(r *http.Request) ([]*request.Request, error) {
+    if mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediatype != "application/json" {
+        return nil, errors.New("seeds should be json")
+    }
+    var seeds []seed
+    if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&seeds); err != nil {
+        return nil, errors.New("seeds : " + err.Error())
+    }
+    respType := r.URL.Query().Get("type")
+    if respType == "" {
+        respType = "html"
+    }
+    reqs := make([]*request.Request, 0, len(seeds))
+    for _, s := range seeds {
+        u, err := url.Parse(s.Url)
+        if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+            return nil, errors.New("seed is not a http url : " + s.Url)
+        }
+        reqs = append(reqs, request.NewRequest(s.Url, respType).SetTag(s.Tag))
+    }
+    return reqs, nil
+}
+
+// The serveQueue writes QueueStatus of the first n requests in Scheduler.
+func (this *Spider) serveQueue(w http.ResponseWriter, r *http.Request) {
+    n := 100
+    if v := r.FormValue("n"); v != "" {
+        var err error
+        if n, err = strconv.Atoi(v); err != nil || n < 0 {
+            http.Error(w, "n should be a non-negative integer", http.StatusBadRequest)
+            return
+        }
+    }
+    if n > maxQueueList {
+        n = maxQueueList
+    }
+    this.runLocker.Lock()
+    s := this.pScheduler
+    this.runLocker.Unlock()
+    q := QueueStatus{Depth: s.Count()}
+    if inspectable, ok := s.(scheduler.InspectableScheduler); ok {
+        q.Requests = inspectable.Snapshot()
+        if len(q.Requests) > n {
+            q.Requests = q.Requests[:n]
+        }
+    }
+    writeJson(w, q)
+}
+
+func (this *Spider) serveStatus(w http.ResponseWriter, r *http.Request) {
+    writeJson(w, this.Status())
+}
+
+// The writeJson writes v as json.
+func writeJson(w http.ResponseWriter, v interface{}) {
+    content, err := json.Marshal(v)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.Write(content)
+}
+
+// The ServeDashboard serves Dashboard at addr like ":8080" in background.
+// It returns error if addr can not be listened, and the server runs until the process exits.
+// The dashboard can control Spider, so addr should not be reachable by others, like "127.0.0.1:8080", or
+// SetDashboardToken should be set.
+func (this *Spider) ServeDashboard(addr string) error {
+    l, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+    go http.Serve(l, this.Dashboard())
+    return nil
+}
@@ -0,0 +1,114 @@
+package spider
+
+// The dashboardPage polls "status" every second and draws pages per second of the last minutes.
+// Paths are relative to the page, so that Dashboard can be mounted under a path.
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go_spider</title>
+<style>
+body { font-family: sans-serif; margin: 20px; color: #222; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+td, th { border: 1px solid #ccc; padding: 4px 8px; text-align: left; font-size: 13px; }
+.cards span { display: inline-block; margin-right: 24px; }
+.cards b { font-size: 20px; display: block; }
+canvas { border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<h2>go_spider <span id="taskname"></span> <small id="state"></small></h2>
+<p>
+<button onclick="control('pause')">Pause</button>
+<button onclick="control('resume')">Resume</button>
+<button onclick="if (confirm('Stop spider?')) control('stop')">Stop</button>
+threadnum <input id="threadnum" type="number" min="1" style="width: 60px">
+<button onclick="control('threadnum?n=' + document.getElementById('threadnum').value)">Set</button>
+</p>
+<p class="cards">
+<span>queue<b id="queue_depth"></b></span>
+<span>active workers<b id="active_workers"></b></span>
+<span>pages<b id="pages"></b></span>
+<span>errors<b id="errors"></b></span>
+<span>pages/s<b id="rate"></b></span>
+</p>
+<canvas id="graph" width="600" height="120"></canvas>
+<h3>Hosts</h3>
+<table id="hosts"><tr><th>host</th><th>pages</th><th>errors</th><th>average seconds</th></tr></table>
+<h3>Recent errors</h3>
+<table id="recent_errors"><tr><th>time</th><th>url</th><th>status</th><th>type</th><th>message</th></tr></table>
+<script>
+var rates = [], last = null, threadnumSet = false;
+
+function text(id, value) { document.getElementById(id).textContent = value; }
+
+function fill(id, rows) {
+    var table = document.getElementById(id);
+    while (table.rows.length > 1) table.deleteRow(1);
+    rows.forEach(function (cells) {
+        var tr = table.insertRow();
+        cells.forEach(function (cell) { tr.insertCell().textContent = cell; });
+    });
+}
+
+function draw() {
+    var canvas = document.getElementById('graph'), ctx = canvas.getContext('2d');
+    ctx.clearRect(0, 0, canvas.width, canvas.height);
+    var max = Math.max.apply(null, rates.concat([1]));
+    ctx.beginPath();
+    rates.forEach(function (v, i) {
+        var x = canvas.width - (rates.length - 1 - i) * 3, y = canvas.height - v / max * (canvas.height - 10);
+        if (i == 0) ctx.moveTo(x, y); else ctx.lineTo(x, y);
+    });
+    ctx.strokeStyle = '#36c';
+    ctx.stroke();
+}
+
+function show(s) {
+    text('taskname', s.taskname);
+    text('state', s.stopped ? 'stopped' : s.paused ? 'paused' : s.running ? 'running' : 'idle');
+    text('queue_depth', s.queue_depth);
+    text('active_workers', s.active_workers + ' / ' + s.threadnum);
+    text('pages', s.pages);
+    text('errors', s.errors);
+    if (!threadnumSet) {
+        document.getElementById('threadnum').value = s.threadnum;
+        threadnumSet = true;
+    }
+    var now = Date.now();
+    if (last) {
+        var rate = (s.pages - last.pages) / ((now - last.time) / 1000);
+        rates.push(rate);
+        if (rates.length > 200) rates.shift();
+        text('rate', rate.toFixed(1));
+        draw();
+    }
+    last = {pages: s.pages, time: now};
+    fill('hosts', s.hosts.map(function (h) { return [h.host, h.pages, h.errors, h.average_seconds.toFixed(3)]; }));
+    fill('recent_errors', s.recent_errors.slice().reverse().map(function (e) {
+        return [new Date(e.time).toLocaleTimeString(), e.url, e.status_code, e.type, e.message];
+    }));
+}
+
+var token = new URLSearchParams(location.search).get('token');
+
+function headers(h) {
+    if (token) h['Authorization'] = 'Bearer ' + token;
+    return h;
+}
+
+function control(path) {
+    fetch(path, {method: 'POST', body: '{}', headers: headers({'Content-Type': 'application/json'})})
+        .then(function (r) { return r.ok ? r.json().then(show) : r.text().then(alert); });
+}
+
+function poll() {
+    fetch('status', {headers: headers({})}).then(function (r) { return r.json(); }).then(show).catch(function () {});
+}
+
+poll();
+setInterval(poll, 1000);
+</script>
+</body>
+</html>
+`
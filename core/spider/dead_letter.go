@@ -0,0 +1,120 @@
+package spider
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "net/http"
+    "os"
+    "time"
+    "unicode/utf8"
+)
+
+// The kinds of DeadLetter.
+const (
+    DeadLetterRequest = "request"
+    DeadLetterItem    = "item"
+)
+
+// The DeadLetter is a request failed after retries, or a page whose items are invalid by the item validator,
+// with the context to find out why: attempts of download, the last error, and status, header and body of the
+// last responce. It is passed to the dead letter pipeline as PageItems of keys of its json fields.
+type DeadLetter struct {
+    // The Kind is DeadLetterRequest or DeadLetterItem.
+    Kind     string           `json:"kind"`
+    Url      string           `json:"url"`
+    Request  *request.Request `json:"request"`
+    Attempts int              `json:"attempts"`
+
+    // The Error is the last error of the request, or why the items are invalid.
+    Error      string      `json:"error"`
+    StatusCode int         `json:"status_code,omitempty"`
+    Header     http.Header `json:"header,omitempty"`
+
+    // The Body is the responce body truncated to the size of SetDeadLetterPipeline.
+    Body          string `json:"body,omitempty"`
+    BodyTruncated bool   `json:"body_truncated,omitempty"`
+
+    // The Items are values of the invalid items.
+    Items map[string]interface{} `json:"items,omitempty"`
+    Time  time.Time              `json:"time"`
+}
+
+// The SetDeadLetterPipeline sets pipeline passed DeadLetter of requests failed after retries and of pages of
+// invalid items, like PipelineJsonLines writing them to a file for LoadDeadLetters. Bodies of responces are
+// truncated to maxBodySize bytes; 0 leaves the body out. Failed requests are also passed to the failed
+// request handler and invalid items to the error pipeline of SetItemValidator.
+func (this *Spider) SetDeadLetterPipeline(pip pipeline.Pipeline, maxBodySize int) *Spider {
+    this.deadLetterPipeline, this.deadLetterBodySize = pip, maxBodySize
+    return this
+}
+
+// The deadLetter passes the dead letter with status, header and body of the page to the dead letter pipeline.
+// The page is nil if the request is not downloaded.
+func (this *Spider) deadLetter(ctx context.Context, letter *DeadLetter, p *page.Page) {
+    pip := this.deadLetterPipeline
+    if pip == nil {
+        return
+    }
+    letter.Url = letter.Request.GetUrl()
+    letter.Time = time.Now()
+    if p != nil {
+        letter.StatusCode = p.GetStatusCode()
+        letter.Header = p.GetHeader()
+        if n := this.deadLetterBodySize; n > 0 {
+            body := p.GetBodyStr()
+            if len(body) > n {
+                for n > 0 && !utf8.RuneStart(body[n]) {
+                    n--
+                }
+                body, letter.BodyTruncated = body[:n], true
+            }
+            letter.Body = body
+        }
+    }
+
+    content, err := json.Marshal(letter)
+    if err != nil {
+        logger.Error("dead letter error : " + err.Error())
+        return
+    }
+    var values map[string]interface{}
+    json.Unmarshal(content, &values)
+    // the request is kept as it is, for pipelines of PageItems.GetValue
+    values["request"] = letter.Request
+    items := page_items.NewPageItems(letter.Request)
+    for k, v := range values {
+        items.SetValue(k, v)
+    }
+    if this.safePipeline(pip, letter.Url, func() { this.processItems(ctx, pip, items) }) {
+        this.stats.item(pipelineName(pip))
+    }
+}
+
+// LoadDeadLetters reads dead letters written by PipelineJsonLines of SetDeadLetterPipeline, so their requests
+// can be added to Spider again after the cause is fixed, like those of kind DeadLetterItem after extraction
+// rules are fixed. Broken lines are skipped.
+func LoadDeadLetters(path string) ([]*DeadLetter, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var letters []*DeadLetter
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for scanner.Scan() {
+        var letter DeadLetter
+        if err := json.Unmarshal(scanner.Bytes(), &letter); err != nil || letter.Request == nil {
+            logger.Error("dead letter line broken : " + scanner.Text())
+            continue
+        }
+        letters = append(letters, &letter)
+    }
+    return letters, scanner.Err()
+}
@@ -0,0 +1,77 @@
+package spider
+
+import (
+    "bufio"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/request"
+    "os"
+    "sync"
+    "time"
+)
+
+// The FailedRequestFile appends requests failed after retries to a file, one json line for each request.
+// Use it by Spider.SetFailedRequestHandler(NewFailedRequestFile(path).Handle).
+type FailedRequestFile struct {
+    locker sync.Mutex
+    pFile  *os.File
+
+    path string
+}
+
+// The failedRequest is one line in FailedRequestFile.
+type failedRequest struct {
+    Request *request.Request `json:"request"`
+    Error   string           `json:"error"`
+    Time    time.Time        `json:"time"`
+}
+
+// NewFailedRequestFile returns initialized FailedRequestFile object which appends to file of the path.
+func NewFailedRequestFile(path string) *FailedRequestFile {
+    pFile, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+    if err != nil {
+        panic("File '" + path + "' in FailedRequestFile open failed.")
+    }
+    return &FailedRequestFile{path: path, pFile: pFile}
+}
+
+// Handle saves the failed request and its error in the file.
+func (this *FailedRequestFile) Handle(req *request.Request, err error) {
+    line, merr := json.Marshal(&failedRequest{Request: req, Error: err.Error(), Time: time.Now()})
+    if merr != nil {
+        logger.Error(merr.Error())
+        return
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if _, werr := this.pFile.Write(append(line, '\n')); werr != nil {
+        logger.Error(werr.Error())
+    }
+}
+
+// Close closes the file.
+func (this *FailedRequestFile) Close() error {
+    return this.pFile.Close()
+}
+
+// LoadFailedRequests reads requests saved by FailedRequestFile, so they can be added to Spider again.
+// Broken lines are skipped.
+func LoadFailedRequests(path string) ([]*request.Request, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var reqs []*request.Request
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for scanner.Scan() {
+        var line failedRequest
+        if err := json.Unmarshal(scanner.Bytes(), &line); err != nil || line.Request == nil {
+            logger.Error("failed request line broken : " + scanner.Text())
+            continue
+        }
+        reqs = append(reqs, line.Request)
+    }
+    return reqs, scanner.Err()
+}
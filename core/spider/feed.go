@@ -0,0 +1,128 @@
+package spider
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "sync"
+    "time"
+)
+
+// The FeedWatcher polls RSS and Atom feeds by an interval, and returns requests of entries not seen before,
+// which are seeds of news or blog monitoring. Feeds are downloaded by Downloader of Spider as "text" requests,
+// and requests of entries have meta "feed" of the feed url, "title", "author" and "published" in RFC 3339
+// if they are set. Entries are identified by guid or id, and their links if they have none.
+// Entries seen are kept in memory, so all the entries of feeds are new when Spider starts again;
+// Scheduler removing duplicate urls drops those crawled before.
+type FeedWatcher struct {
+    urls     []string
+    interval time.Duration
+    respType string
+    since    time.Time
+
+    locker sync.Mutex
+    // The seen saves ids of entries seen by feed url.
+    seen map[string]map[string]bool
+}
+
+// NewFeedWatcher returns FeedWatcher of the feeds polled every interval, whose entries are requested as respType.
+func NewFeedWatcher(interval time.Duration, respType string, urls ...string) *FeedWatcher {
+    return &FeedWatcher{urls: urls, interval: interval, respType: respType, seen: make(map[string]map[string]bool)}
+}
+
+// The SetSince skips entries published before t, like old entries of feeds polled for the first time.
+// Entries without date are not skipped.
+func (this *FeedWatcher) SetSince(t time.Time) *FeedWatcher {
+    this.since = t
+    return this
+}
+
+// The Poll downloads the feeds once by the Downloader and returns requests of new entries.
+// Feeds that fail are logged and skipped.
+func (this *FeedWatcher) Poll(ctx context.Context, d downloader.Downloader) []*request.Request {
+    var reqs []*request.Request
+    for _, feedUrl := range this.urls {
+        p := downloader.DownloadContext(ctx, d, request.NewRequest(feedUrl, "text"))
+        if p == nil || !p.IsSucc() {
+            errormsg := "nil page"
+            if p != nil {
+                errormsg = p.Errormsg()
+            }
+            logger.Error("feed download failed", mlog.F("url", feedUrl), mlog.F("error", errormsg))
+            continue
+        }
+        feed, err := p.GetFeed()
+        if err != nil {
+            logger.Error(err.Error())
+            continue
+        }
+
+        this.locker.Lock()
+        seen := this.seen[feedUrl]
+        if seen == nil {
+            seen = make(map[string]bool)
+            this.seen[feedUrl] = seen
+        }
+        for _, entry := range feed.Entries {
+            if entry.Link == "" || seen[entry.Id] {
+                continue
+            }
+            seen[entry.Id] = true
+            if !this.since.IsZero() && !entry.Published.IsZero() && entry.Published.Before(this.since) {
+                continue
+            }
+            req := request.NewRequest(entry.Link, this.respType).SetReferer(feedUrl).SetMeta("feed", feedUrl)
+            if entry.Title != "" {
+                req.SetMeta("title", entry.Title)
+            }
+            if entry.Author != "" {
+                req.SetMeta("author", entry.Author)
+            }
+            if !entry.Published.IsZero() {
+                req.SetMeta("published", entry.Published.Format(time.RFC3339))
+            }
+            reqs = append(reqs, req)
+        }
+        this.locker.Unlock()
+    }
+    return reqs
+}
+
+// The SetFeedWatcher polls feeds of the FeedWatcher when Run starts and every its interval, and adds requests
+// of their new entries to Scheduler. The FeedWatcher keeps Run running until Stop is called.
+func (this *Spider) SetFeedWatcher(w *FeedWatcher) *Spider {
+    this.feedWatcher = w
+    return this
+}
+
+// The startFeedWatcher polls feeds in background while Run is running. It returns function that stops polling.
+func (this *Spider) startFeedWatcher(ctx context.Context) func() {
+    w := this.feedWatcher
+    if w == nil {
+        return func() {}
+    }
+    ctx, cancel := context.WithCancel(ctx)
+    done := make(chan struct{})
+    go func() {
+        defer close(done)
+        var ticker <-chan time.Time
+        if w.interval > 0 {
+            t := time.NewTicker(w.interval)
+            defer t.Stop()
+            ticker = t.C
+        }
+        for {
+            this.AddRequests(w.Poll(ctx, this.pDownloader))
+            select {
+            case <-ticker:
+            case <-ctx.Done():
+                return
+            }
+        }
+    }()
+    return func() {
+        cancel()
+        <-done
+    }
+}
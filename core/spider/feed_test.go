@@ -0,0 +1,62 @@
+package spider_test
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/spider"
+    "net/http"
+    "net/http/httptest"
+    "sort"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+func TestFeedWatcher(t *testing.T) {
+    var polls int32
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path != "/feed.xml" {
+            w.Write([]byte("post"))
+            return
+        }
+        items := `<item><title>Post 1</title><link>/1</link><pubDate>Tue, 23 Sep 2014 10:00:00 +0000</pubDate></item>
+            <item><title>Post 2</title><link>/2</link></item>`
+        if atomic.AddInt32(&polls, 1) > 1 {
+            items = `<item><title>Post 3</title><link>/3</link></item>
+                <item><title>Post 2</title><link>/2</link></item>`
+        }
+        w.Write([]byte(`<rss version="2.0"><channel><title>t</title>` + items + `</channel></rss>`))
+    }))
+    defer ts.Close()
+
+    pp := &countPageProcesser{n: 3}
+    watcher := spider.NewFeedWatcher(20*time.Millisecond, "text", ts.URL+"/feed.xml")
+    pp.sp = spider.NewSpider(pp, "feed").CloseStrace().SetObeyRobots(false).SetFeedWatcher(watcher)
+    pp.sp.Run()
+
+    var urls []string
+    for _, p := range pp.pages {
+        urls = append(urls, p.GetRequest().GetUrl())
+    }
+    sort.Strings(urls)
+    if len(urls) != 3 || urls[0] != ts.URL+"/1" || urls[2] != ts.URL+"/3" {
+        t.Fatalf("new entries should be crawled once: %v", urls)
+    }
+    for _, p := range pp.pages {
+        if p.GetRequest().GetUrl() == ts.URL+"/1" {
+            title, _ := p.GetRequest().GetMeta("title")
+            published, _ := p.GetRequest().GetMeta("published")
+            feed, _ := p.GetRequest().GetMeta("feed")
+            if title != "Post 1" || published != "2014-09-23T10:00:00Z" || feed != ts.URL+"/feed.xml" {
+                t.Errorf("entry meta error: %v %v %v", title, published, feed)
+            }
+        }
+    }
+
+    // old entries are skipped
+    since := spider.NewFeedWatcher(0, "text", ts.URL+"/feed.xml").SetSince(time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC))
+    atomic.StoreInt32(&polls, 0)
+    reqs := since.Poll(context.Background(), pp.sp.GetDownloader())
+    if len(reqs) != 1 || reqs[0].GetUrl() != ts.URL+"/2" {
+        t.Errorf("entries before since should be skipped: %v", reqs)
+    }
+}
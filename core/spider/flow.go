@@ -0,0 +1,193 @@
+package spider
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/page_processer"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "golang.org/x/time/rate"
+    "sync"
+)
+
+// The Flow is a crawl of named stages, like "list" → "detail" → "reviews", for sites whose pages are not
+// alike. Each stage has its own PageProcesser, rate limit and pipelines, and declares by Next the stage of
+// requests found by its pages. The stage of a request is its tag of Request.SetTag; requests without tag,
+// like seeds, are in the first stage added. It is set to Spider by SetFlow.
+type Flow struct {
+    locker sync.RWMutex
+    stages map[string]*Stage
+    // The order is names of stages in the order they are added, whose first is the start stage.
+    order []string
+}
+
+// The Stage is a named step of Flow.
+type Stage struct {
+    flow      *Flow
+    name      string
+    processer page_processer.PageProcesser
+    next      string
+    limiter   *rate.Limiter
+    pipelines []pipeline.Pipeline
+    skip      bool
+}
+
+// NewFlow returns Flow without stages.
+func NewFlow() *Flow {
+    return &Flow{stages: make(map[string]*Stage)}
+}
+
+// The Stage adds stage of the name whose pages are parsed by p, or replaces processer of the stage if it is
+// added already. The first stage added is the start stage of requests without tag.
+func (this *Flow) Stage(name string, p page_processer.PageProcesser) *Stage {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    stage, ok := this.stages[name]
+    if !ok {
+        stage = &Stage{flow: this, name: name}
+        this.stages[name] = stage
+        this.order = append(this.order, name)
+    }
+    stage.processer = p
+    return stage
+}
+
+// The StageFunc adds stage of the name whose pages are parsed by function f.
+func (this *Flow) StageFunc(name string, f func(p *page.Page)) *Stage {
+    return this.Stage(name, page_processer.PageProcesserFunc(f))
+}
+
+// The GetStage returns stage of the name, or nil if it is not added.
+func (this *Flow) GetStage(name string) *Stage {
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    return this.stages[name]
+}
+
+// The stageOf returns stage of the request by its tag, or nil if the tag is not a stage.
+func (this *Flow) stageOf(req *request.Request) *Stage {
+    this.locker.RLock()
+    defer this.locker.RUnlock()
+    if req.GetTag() == "" && len(this.order) > 0 {
+        return this.stages[this.order[0]]
+    }
+    return this.stages[req.GetTag()]
+}
+
+// The Process parses the page by processer of its stage, and puts target requests without tag in the next
+// stage. Pages of requests whose tag is not a stage are skipped with an error logged.
+func (this *Flow) Process(p *page.Page) {
+    stage := this.stageOf(p.GetRequest())
+    if stage == nil {
+        logger.Error("request is in no stage of flow", mlog.F("url", p.GetRequest().GetUrl()),
+            mlog.F("tag", p.GetRequest().GetTag()))
+        p.SetSkip(true)
+        return
+    }
+    this.locker.RLock()
+    processer, next, skip := stage.processer, stage.nextName(), stage.skip
+    this.locker.RUnlock()
+    processer.Process(p)
+    for _, req := range p.GetTargetRequests() {
+        if req.GetTag() == "" {
+            req.SetTag(next)
+        } else if this.GetStage(req.GetTag()) == nil {
+            logger.Warn("target request is in no stage of flow", mlog.F("url", req.GetUrl()),
+                mlog.F("tag", req.GetTag()))
+        }
+    }
+    if skip {
+        p.SetSkip(true)
+    }
+}
+
+// The wait blocks until rate limit of stage of the request allows it.
+func (this *Flow) wait(req *request.Request) {
+    if stage := this.stageOf(req); stage != nil {
+        if limiter := stage.getLimiter(); limiter != nil {
+            limiter.Wait(context.Background())
+        }
+    }
+}
+
+// The GetName returns name of the stage.
+func (this *Stage) GetName() string {
+    return this.name
+}
+
+// The Next sets stage of requests found by pages of the stage without tag, like "detail" of "list". Requests
+// tagged by the processer, like by AddTargetRequestsWithTag, go to their own stage. Default is the stage
+// itself, like next pages of a list.
+func (this *Stage) Next(name string) *Stage {
+    this.flow.locker.Lock()
+    this.next = name
+    this.flow.locker.Unlock()
+    return this
+}
+
+// The GetNext returns stage of requests found by pages of the stage.
+func (this *Stage) GetNext() string {
+    this.flow.locker.RLock()
+    defer this.flow.locker.RUnlock()
+    return this.nextName()
+}
+
+func (this *Stage) nextName() string {
+    if this.next == "" {
+        return this.name
+    }
+    return this.next
+}
+
+// The SetRateLimit limits requests per second of downloads of the stage, retries included, on top of the
+// limits of Spider, like crawling a slow review api gently while list pages go fast. 0 means no limit.
+func (this *Stage) SetRateLimit(rps float64) *Stage {
+    this.flow.locker.Lock()
+    this.limiter = newLimiter(rps, 1)
+    this.flow.locker.Unlock()
+    return this
+}
+
+func (this *Stage) getLimiter() *rate.Limiter {
+    this.flow.locker.RLock()
+    defer this.flow.locker.RUnlock()
+    return this.limiter
+}
+
+// The AddPipeline adds pipeline that is passed only PageItems of pages of the stage, like reviews to their
+// own file. Pipelines of Spider are passed PageItems of all the stages. It is added to Spider by SetFlow.
+func (this *Stage) AddPipeline(p pipeline.Pipeline) *Stage {
+    this.flow.locker.Lock()
+    this.pipelines = append(this.pipelines, p)
+    this.flow.locker.Unlock()
+    return this
+}
+
+// The SetSkip sets whether PageItems of pages of the stage are skipped by all the pipelines, like list pages
+// that only find requests of the next stage.
+func (this *Stage) SetSkip(skip bool) *Stage {
+    this.flow.locker.Lock()
+    this.skip = skip
+    this.flow.locker.Unlock()
+    return this
+}
+
+// The SetFlow sets the Flow as PageProcesser of Spider, and adds pipelines of its stages, which are filtered
+// by the stage of items. Stages and their pipelines should be added before it is called.
+func (this *Spider) SetFlow(flow *Flow) *Spider {
+    this.SetPageProcesser(flow)
+    flow.locker.RLock()
+    defer flow.locker.RUnlock()
+    for _, name := range flow.order {
+        stage := flow.stages[name]
+        filter := func(items *page_items.PageItems) bool {
+            return flow.stageOf(items.GetRequest()) == stage
+        }
+        for _, pip := range stage.pipelines {
+            this.AddPipelineWith(pip, filter, 0)
+        }
+    }
+    return this
+}
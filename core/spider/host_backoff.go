@@ -0,0 +1,117 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net/http"
+    "sync"
+    "time"
+)
+
+// The hostBackoff saves until when each host is backed off after 429 or 503 responces.
+type hostBackoff struct {
+    defaultDelay time.Duration
+    maxDelay     time.Duration
+
+    locker sync.Mutex
+    until  map[string]time.Time
+}
+
+// The SetHostBackoff makes host of a 429 Too Many Requests or 503 Service Unavailable responce backed off:
+// requests of the host are not downloaded until the delay of Retry-After header is over, or defaultDelay if
+// the responce has no Retry-After, no more than max. Requests of the host polled meanwhile are requeued to
+// Scheduler after the delay, so workers go on with other hosts.
+// The 429 and 503 responces are retried after the delay, by SetRetryBackoff or SetRetryTimes, and they are
+// failed if their retries are used up. The max 0 means no limit, and defaultDelay 0 backs off hosts of
+// responces with Retry-After only.
+func (this *Spider) SetHostBackoff(defaultDelay, max time.Duration) *Spider {
+    this.hostBackoff = &hostBackoff{defaultDelay: defaultDelay, maxDelay: max, until: make(map[string]time.Time)}
+    return this
+}
+
+// The backoffStatus tests whether the status code is a signal of backing off the host.
+func backoffStatus(code int) bool {
+    return code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable
+}
+
+// The observe backs off host of the page if it is a 429 or 503 responce, and returns the delay.
+func (this *hostBackoff) observe(p *page.Page) (time.Duration, bool) {
+    if !backoffStatus(p.GetStatusCode()) {
+        return 0, false
+    }
+    delay, ok := retryAfter(p)
+    if !ok {
+        delay = this.defaultDelay
+    }
+    if this.maxDelay > 0 && delay > this.maxDelay {
+        delay = this.maxDelay
+    }
+    if delay <= 0 {
+        return 0, false
+    }
+    host := requestHost(p.GetRequest())
+    until := time.Now().Add(delay)
+    this.locker.Lock()
+    if until.After(this.until[host]) {
+        this.until[host] = until
+    }
+    this.locker.Unlock()
+    return delay, true
+}
+
+// The remaining returns how long host of the url is still backed off, or 0 if it is not.
+func (this *hostBackoff) remaining(req *request.Request) time.Duration {
+    host := requestHost(req)
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    until, ok := this.until[host]
+    if !ok {
+        return 0
+    }
+    d := until.Sub(time.Now())
+    if d <= 0 {
+        delete(this.until, host)
+        return 0
+    }
+    return d
+}
+
+// The backedOff requeues the request after backoff of its host, and returns false if its host is not backed off.
+func (this *Spider) backedOff(req *request.Request) bool {
+    if this.hostBackoff == nil {
+        return false
+    }
+    d := this.hostBackoff.remaining(req)
+    if d <= 0 {
+        return false
+    }
+    this.requeueAfter(req, d)
+    return true
+}
+
+// The observeBackoff backs off host of the page downloaded if it is a 429 or 503 responce.
+func (this *Spider) observeBackoff(p *page.Page) {
+    if this.hostBackoff == nil {
+        return
+    }
+    if delay, ok := this.hostBackoff.observe(p); ok {
+        logger.Warn("host is backed off", mlog.F("host", requestHost(p.GetRequest())),
+            mlog.F("status", p.GetStatusCode()), mlog.F("delay", delay))
+    }
+}
+
+// The retryDelay returns delay before the failed page is retried, which is delay of its Retry-After header
+// or the remaining backoff of its host if it is larger.
+func (this *Spider) retryDelay(p *page.Page) (time.Duration, bool) {
+    delay, ok := retryAfter(p)
+    if this.hostBackoff != nil {
+        if this.hostBackoff.maxDelay > 0 && delay > this.hostBackoff.maxDelay {
+            delay = this.hostBackoff.maxDelay
+        }
+        if d := this.hostBackoff.remaining(p.GetRequest()); d > delay {
+            delay, ok = d, true
+        }
+    }
+    return delay, ok
+}
@@ -0,0 +1,110 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/resource_manage"
+    "strings"
+)
+
+// The maxHostWaiting limits requests put aside because their hosts are busy. When it is reached, Run waits
+// for workers instead of polling Scheduler, so requests are not all moved from Scheduler to memory.
+const maxHostWaiting = 1000
+
+// The SetThreadnumPerHost sets number of requests of each host crawled at once, while SetThreadnum sets number
+// of all the requests crawled at once. Requests of a host crawling n requests are put aside and crawled
+// when the host is free, so workers go on with requests of other hosts. The n 0 means no limit.
+// It can be changed while Run is running.
+func (this *Spider) SetThreadnumPerHost(n uint) *Spider {
+    this.runLocker.Lock()
+    defer this.runLocker.Unlock()
+    if n == 0 {
+        this.hostSlots = nil
+    } else if this.hostSlots != nil {
+        this.hostSlots.SetCapnum(n)
+    } else {
+        this.hostSlots = resource_manage.NewResourceManageHost(n)
+    }
+    this.wakeup()
+    return this
+}
+
+// The hostLimit returns ResourceManageHost of SetThreadnumPerHost, or nil if there is no limit.
+func (this *Spider) hostLimit() *resource_manage.ResourceManageHost {
+    this.runLocker.Lock()
+    defer this.runLocker.Unlock()
+    return this.hostSlots
+}
+
+// The nextRequest returns a request to be crawled whose host is not busy, with function that frees the resource
+// of the host got for it. Requests put aside whose host is free now come first, then requests polled from
+// Scheduler; requests of busy hosts are put aside. With SetHostPartition, the resource is of the shard of the
+// host instead. It returns nil if there is no request to be crawled now. It is called by Run only.
+func (this *Spider) nextRequest() (*request.Request, func()) {
+    slots, key := this.hostLimit(), requestHost
+    if this.shards != nil {
+        slots, key = this.shards.slots, this.shards.key
+    }
+    if slots == nil {
+        // requests put aside before the limit is removed are crawled first
+        if req := this.takeWaiting(nil); req != nil {
+            return req, func() {}
+        }
+        return this.poll(), func() {}
+    }
+    if this.hostWaiting == nil {
+        this.hostWaiting = make(map[string][]*request.Request)
+    }
+    req := this.takeWaiting(slots)
+    for req == nil && this.hostWaitingCount < maxHostWaiting {
+        if req = this.poll(); req == nil {
+            return nil, nil
+        }
+        if host := key(req); !slots.TryGetOne(host) {
+            this.hostWaiting[host] = append(this.hostWaiting[host], req)
+            this.hostWaitingCount++
+            req = nil
+        }
+    }
+    if req == nil {
+        return nil, nil
+    }
+    host := key(req)
+    return req, func() {
+        slots.FreeOne(host)
+    }
+}
+
+// The takeWaiting returns first request put aside whose host gets one resource of slots, or any request put
+// aside if slots is nil.
+func (this *Spider) takeWaiting(slots *resource_manage.ResourceManageHost) *request.Request {
+    for host, reqs := range this.hostWaiting {
+        if slots != nil && !slots.TryGetOne(host) {
+            continue
+        }
+        req := reqs[0]
+        if len(reqs) == 1 {
+            delete(this.hostWaiting, host)
+        } else {
+            this.hostWaiting[host] = reqs[1:]
+        }
+        this.hostWaitingCount--
+        return req
+    }
+    return nil
+}
+
+// The unpollWaiting pushes requests put aside back to Scheduler when Run returns.
+func (this *Spider) unpollWaiting() {
+    for _, reqs := range this.hostWaiting {
+        for _, req := range reqs {
+            this.unpoll(req)
+        }
+    }
+    this.hostWaiting = nil
+    this.hostWaitingCount = 0
+}
+
+// The requestHost returns host of the request in lower case.
+func requestHost(req *request.Request) string {
+    return strings.ToLower(urlHost(req.GetUrl()))
+}
@@ -0,0 +1,266 @@
+package spider
+
+import (
+    "context"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/iana"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The ErrHostDead is error of requests failed because their host does not answer the liveness probe, passed to
+// failed request handler.
+var ErrHostDead = errors.New("host is dead")
+
+// The HostProbe tests whether the address "host:port" is alive, like TCPProbe by a tcp connect, or an ICMP echo
+// of your own pinger, which needs raw sockets and finds ICMPv6 message types in package iana.
+type HostProbe func(ctx context.Context, addr string) error
+
+// The probeDialKey is key of context of probes, whose value is dial function of HttpDownloader of Spider.
+type probeDialKey struct{}
+
+// TCPProbe returns HostProbe that connects to the address in timeout and closes the connection at once, which
+// is much cheaper than a download timing out on a dead host. Probes of Spider connect by HttpDownloader.DialContext
+// of its downloader, so they resolve hosts by its resolver and bind its local addresses and socket options.
+func TCPProbe(timeout time.Duration) HostProbe {
+    return func(ctx context.Context, addr string) error {
+        dial := (&net.Dialer{}).DialContext
+        if d, ok := ctx.Value(probeDialKey{}).(func(context.Context, string, string) (net.Conn, error)); ok {
+            dial = d
+        }
+        ctx, cancel := context.WithTimeout(ctx, timeout)
+        defer cancel()
+        conn, err := dial(ctx, "tcp", addr)
+        if err != nil {
+            return err
+        }
+        return conn.Close()
+    }
+}
+
+// The hostLiveness probes each address once and keeps the result for ttl.
+type hostLiveness struct {
+    probe HostProbe
+    ttl   time.Duration
+
+    locker sync.Mutex
+    addrs  map[string]*hostProbe
+}
+
+// The hostProbe is result of the probe of an address, which is known when done is closed.
+type hostProbe struct {
+    done    chan struct{}
+    err     error
+    expires time.Time
+}
+
+// The SetHostLiveness probes the host of requests by probe before the first of them is downloaded, like
+// TCPProbe(3 * time.Second), and requests of a dead host fail with ErrHostDead at once without downloads, so a
+// broad crawl does not waste workers timing out on dead domains. Requests of a host wait for one probe of it,
+// and its result is kept for ttl, or while Spider runs if ttl is 0. Only http and https urls are probed, and
+// probes connect to hosts directly, not by proxies. Probes are canceled by Stop, and canceled probes are not
+// kept. Nil probe disables it.
+func (this *Spider) SetHostLiveness(probe HostProbe, ttl time.Duration) *Spider {
+    if probe == nil {
+        this.hostLiveness = nil
+        return this
+    }
+    this.hostLiveness = &hostLiveness{probe: probe, ttl: ttl, addrs: make(map[string]*hostProbe)}
+    return this
+}
+
+// The GetDeadHosts returns addresses "host:port" whose probes failed with their errors.
+func (this *Spider) GetDeadHosts() map[string]error {
+    dead := make(map[string]error)
+    if this.hostLiveness == nil {
+        return dead
+    }
+    this.hostLiveness.locker.Lock()
+    defer this.hostLiveness.locker.Unlock()
+    now := time.Now()
+    for addr, probe := range this.hostLiveness.addrs {
+        select {
+        case <-probe.done:
+            if probe.err != nil && (probe.expires.IsZero() || now.Before(probe.expires)) {
+                dead[addr] = probe.err
+            }
+        default:
+        }
+    }
+    return dead
+}
+
+// The ProbeHosts probes hosts of the urls concurrently by threadnum before a large batch of them is added, and
+// returns the dead addresses with their errors. The results are kept, so requests of the dead hosts fail
+// without probing them again. It probes nothing if SetHostLiveness is not set.
+func (this *Spider) ProbeHosts(urls ...string) map[string]error {
+    dead := make(map[string]error)
+    liveness := this.hostLiveness
+    if liveness == nil {
+        return dead
+    }
+    addrs := make(map[string]bool)
+    for _, rawurl := range urls {
+        if addr := probeAddr(rawurl); addr != "" {
+            addrs[addr] = true
+        }
+    }
+
+    var locker sync.Mutex
+    var wg sync.WaitGroup
+    n := this.GetThreadnum()
+    if n == 0 {
+        n = 1
+    }
+    slots := make(chan struct{}, n)
+    for addr := range addrs {
+        wg.Add(1)
+        slots <- struct{}{}
+        go func(addr string) {
+            defer func() { <-slots; wg.Done() }()
+            if err := liveness.check(this.probeContext(), context.Background(), addr); err != nil {
+                locker.Lock()
+                dead[addr] = err
+                locker.Unlock()
+            }
+        }(addr)
+    }
+    wg.Wait()
+    return dead
+}
+
+// The startProbes sets context of probes while Run is running, which is canceled by Stop or when the returned
+// function is called.
+func (this *Spider) startProbes(ctx context.Context) func() {
+    ctx, cancel := context.WithCancel(this.withProbeDial(ctx))
+    this.probeLocker.Lock()
+    this.probeCtx, this.cancelProbes = ctx, cancel
+    this.probeLocker.Unlock()
+    return func() {
+        this.probeLocker.Lock()
+        this.probeCtx, this.cancelProbes = nil, nil
+        this.probeLocker.Unlock()
+        cancel()
+    }
+}
+
+// The stopProbes cancels probes being run by Run.
+func (this *Spider) stopProbes() {
+    this.probeLocker.Lock()
+    if this.cancelProbes != nil {
+        this.cancelProbes()
+    }
+    this.probeLocker.Unlock()
+}
+
+// The probeContext returns context of probes, of Run if it is running.
+func (this *Spider) probeContext() context.Context {
+    this.probeLocker.Lock()
+    ctx := this.probeCtx
+    this.probeLocker.Unlock()
+    if ctx != nil {
+        return ctx
+    }
+    return this.withProbeDial(context.Background())
+}
+
+// The withProbeDial returns ctx with dial function of HttpDownloader of Spider for TCPProbe.
+func (this *Spider) withProbeDial(ctx context.Context) context.Context {
+    if d, ok := this.findHttpDownloader(); ok {
+        var dial func(context.Context, string, string) (net.Conn, error) = d.DialContext
+        return context.WithValue(ctx, probeDialKey{}, dial)
+    }
+    return ctx
+}
+
+// The check returns error of the probe of the address, probing it by probeCtx if it is not probed or its
+// result is expired. Callers wait for the probe being run by another one until ctx is done.
+func (this *hostLiveness) check(probeCtx, ctx context.Context, addr string) error {
+    this.locker.Lock()
+    probe, ok := this.addrs[addr]
+    if ok {
+        select {
+        case <-probe.done:
+            if !probe.expires.IsZero() && !time.Now().Before(probe.expires) {
+                ok = false
+            }
+        default:
+        }
+    }
+    if !ok {
+        probe = &hostProbe{done: make(chan struct{})}
+        this.addrs[addr] = probe
+        // the probe is not canceled with the request, as its result is shared by requests of the host
+        go func() {
+            probe.err = this.probe(probeCtx, addr)
+            if probeCtx.Err() != nil {
+                // the host is not known to be dead, so it is probed again by the next run
+                probe.err = nil
+                this.locker.Lock()
+                if this.addrs[addr] == probe {
+                    delete(this.addrs, addr)
+                }
+                this.locker.Unlock()
+            } else if this.ttl > 0 {
+                probe.expires = time.Now().Add(this.ttl)
+            }
+            close(probe.done)
+        }()
+    }
+    this.locker.Unlock()
+
+    select {
+    case <-probe.done:
+        return probe.err
+    case <-ctx.Done():
+        return nil
+    }
+}
+
+// The probeAddr returns address "host:port" of the http or https url, or "" if it is not probed.
+func probeAddr(rawurl string) string {
+    u, err := url.Parse(rawurl)
+    if err != nil || u.Hostname() == "" {
+        return ""
+    }
+    scheme, port := strings.ToLower(u.Scheme), u.Port()
+    if scheme != "http" && scheme != "https" {
+        return ""
+    }
+    if port == "" {
+        n, _ := iana.ServicePort(scheme)
+        port = strconv.Itoa(n)
+    }
+    return net.JoinHostPort(strings.ToLower(u.Hostname()), port)
+}
+
+// The hostDead fails the request if its host is dead by the liveness probe. It returns false if the request can
+// be downloaded.
+func (this *Spider) hostDead(ctx context.Context, req *request.Request) bool {
+    liveness := this.hostLiveness
+    if liveness == nil {
+        return false
+    }
+    addr := probeAddr(req.GetUrl())
+    if addr == "" {
+        return false
+    }
+    err := liveness.check(this.probeContext(), ctx, addr)
+    if err == nil {
+        return false
+    }
+    logger.Warn(ErrHostDead.Error(), mlog.F("url", req.GetUrl()), mlog.F("error", err.Error()))
+    this.dropRequest("host_dead")
+    if this.failedRequestHandler != nil {
+        this.failedRequestHandler(req, ErrHostDead)
+    }
+    this.deadLetter(ctx, &DeadLetter{Kind: DeadLetterRequest, Request: req, Error: ErrHostDead.Error() + " : " +
+        err.Error()}, nil)
+    return true
+}
@@ -0,0 +1,65 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/resource_manage"
+    "hash/fnv"
+    "strconv"
+)
+
+// The SetHostPartition sets whether requests are partitioned by hash of their hosts across threadnum shard
+// workers, so all the requests of a host are crawled one by one by the same worker. Keep-alive connections
+// of a host are reused, and its rate limit and delays are kept exactly, while hosts of other shards are
+// crawled at once. Requests of a busy shard are put aside like SetThreadnumPerHost, which it supersedes.
+// The number of shards is threadnum when Run starts; SetThreadnum while Run is running does not add shards.
+func (this *Spider) SetHostPartition(partition bool) *Spider {
+    this.hostPartition = partition
+    return this
+}
+
+// The hostShards are shard workers of SetHostPartition while Run is running. Each worker runs crawl tasks
+// of its hosts in order, and slots lets one task of a shard be dispatched at once.
+type hostShards struct {
+    tasks []chan func()
+    slots *resource_manage.ResourceManageHost
+}
+
+// The newHostShards starts n shard workers.
+func newHostShards(n uint) *hostShards {
+    shards := &hostShards{tasks: make([]chan func(), n), slots: resource_manage.NewResourceManageHost(1)}
+    for i := range shards.tasks {
+        tasks := make(chan func())
+        shards.tasks[i] = tasks
+        go func() {
+            for task := range tasks {
+                task()
+            }
+        }()
+    }
+    return shards
+}
+
+// The shard returns index of shard worker of the request by fnv hash of its host.
+func (this *hostShards) shard(req *request.Request) int {
+    h := fnv.New32a()
+    h.Write([]byte(requestHost(req)))
+    return int(h.Sum32() % uint32(len(this.tasks)))
+}
+
+// The key returns key of slots of the request, which is its shard.
+func (this *hostShards) key(req *request.Request) string {
+    return strconv.Itoa(this.shard(req))
+}
+
+// The run runs the crawl task of the request by its shard worker. The slot of the shard is got by nextRequest,
+// so the worker is free or about to be free.
+func (this *hostShards) run(req *request.Request, task func()) {
+    this.tasks[this.shard(req)] <- task
+}
+
+// The stop stops shard workers after their tasks are done.
+func (this *hostShards) stop() {
+    for _, tasks := range this.tasks {
+        close(tasks)
+    }
+}
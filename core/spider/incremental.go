@@ -0,0 +1,202 @@
+package spider
+
+import (
+    "crypto/md5"
+    "encoding/hex"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/util"
+    "go.etcd.io/bbolt"
+    "sync"
+    "time"
+)
+
+// The changes of pages found by Incremental.
+const (
+    ChangeAdded     = "added"
+    ChangeModified  = "modified"
+    ChangeUnchanged = "unchanged"
+)
+
+// The ChangeRecord is content hash and last crawl time of a url saved in ChangeStore.
+type ChangeRecord struct {
+    Hash      string    `json:"hash"`
+    CrawledAt time.Time `json:"crawled_at"`
+}
+
+// The ChangeEvent is change of a page found by Incremental. LastCrawled is zero for added pages.
+type ChangeEvent struct {
+    Url         string    `json:"url"`
+    Change      string    `json:"change"`
+    Hash        string    `json:"hash"`
+    LastCrawled time.Time `json:"last_crawled"`
+}
+
+// The ChangeStore saves ChangeRecord of crawled urls for Incremental.
+// Function Get returns record of the url, and false if the url is not crawled before.
+// Function Put saves record of the url.
+type ChangeStore interface {
+    Get(url string) (ChangeRecord, bool)
+    Put(url string, record ChangeRecord) error
+}
+
+// The MapChangeStore keeps ChangeRecord in memory, for change monitoring by a long-running Spider.
+type MapChangeStore struct {
+    locker  sync.Mutex
+    records map[string]ChangeRecord
+}
+
+func NewMapChangeStore() *MapChangeStore {
+    return &MapChangeStore{records: make(map[string]ChangeRecord)}
+}
+
+func (this *MapChangeStore) Get(url string) (ChangeRecord, bool) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    record, ok := this.records[url]
+    return record, ok
+}
+
+func (this *MapChangeStore) Put(url string, record ChangeRecord) error {
+    this.locker.Lock()
+    this.records[url] = record
+    this.locker.Unlock()
+    return nil
+}
+
+// The boltChangeBucket is bucket of records in file of BoltChangeStore.
+var boltChangeBucket = []byte("changes")
+
+// The BoltChangeStore saves ChangeRecord in a BoltDB file, so changes are found across runs.
+type BoltChangeStore struct {
+    db *bbolt.DB
+}
+
+// OpenBoltChangeStore opens or creates the BoltDB file of path.
+func OpenBoltChangeStore(path string) (*BoltChangeStore, error) {
+    db, err := bbolt.Open(path, 0644, &bbolt.Options{Timeout: time.Second})
+    if err != nil {
+        return nil, err
+    }
+    err = db.Update(func(tx *bbolt.Tx) error {
+        _, err := tx.CreateBucketIfNotExists(boltChangeBucket)
+        return err
+    })
+    if err != nil {
+        db.Close()
+        return nil, err
+    }
+    return &BoltChangeStore{db: db}, nil
+}
+
+// The Close closes the BoltDB file.
+func (this *BoltChangeStore) Close() error {
+    return this.db.Close()
+}
+
+func (this *BoltChangeStore) Get(url string) (ChangeRecord, bool) {
+    var record ChangeRecord
+    ok := false
+    this.db.View(func(tx *bbolt.Tx) error {
+        if content := tx.Bucket(boltChangeBucket).Get([]byte(url)); content != nil {
+            ok = json.Unmarshal(content, &record) == nil
+        }
+        return nil
+    })
+    return record, ok
+}
+
+func (this *BoltChangeStore) Put(url string, record ChangeRecord) error {
+    content, err := json.Marshal(record)
+    if err != nil {
+        return err
+    }
+    // concurrent puts of download workers are written in batched transactions
+    return this.db.Batch(func(tx *bbolt.Tx) error {
+        return tx.Bucket(boltChangeBucket).Put([]byte(url), content)
+    })
+}
+
+// The Incremental finds changes of pages by their content hash saved in ChangeStore, so re-crawls pass only
+// added and modified pages to pipelines, like a change monitor. Pages of 304 Not Modified are unchanged.
+// Default hash is md5 of body; SetHash can hash PageItems instead, so changes of ads or timestamps on pages
+// are not changes of the content.
+type Incremental struct {
+    store   ChangeStore
+    hash    func(*page.Page) string
+    handler func(ChangeEvent)
+}
+
+// NewIncremental returns Incremental saving records in the store, like OpenBoltChangeStore for a local file.
+func NewIncremental(store ChangeStore) *Incremental {
+    return &Incremental{store: store, hash: bodyHash}
+}
+
+// The SetHash sets function that returns content hash of the processed page.
+func (this *Incremental) SetHash(f func(*page.Page) string) *Incremental {
+    this.hash = f
+    return this
+}
+
+// The SetChangeHandler sets function called with change of each page, including unchanged pages.
+func (this *Incremental) SetChangeHandler(h func(ChangeEvent)) *Incremental {
+    this.handler = h
+    return this
+}
+
+// The bodyHash returns md5 of body of the page.
+func bodyHash(p *page.Page) string {
+    sum := md5.Sum([]byte(p.GetBodyStr()))
+    return hex.EncodeToString(sum[:])
+}
+
+// The ItemsHash returns md5 of json of PageItems of the page, for Incremental.SetHash.
+func ItemsHash(p *page.Page) string {
+    content, _ := json.Marshal(p.GetPageItems())
+    sum := md5.Sum(content)
+    return hex.EncodeToString(sum[:])
+}
+
+// The detect saves record of the page and returns its change.
+func (this *Incremental) detect(p *page.Page) (ChangeEvent, error) {
+    url := util.NormalizeUrl(p.GetRequest().GetUrl())
+    last, ok := this.store.Get(url)
+    event := ChangeEvent{Url: p.GetRequest().GetUrl(), Change: ChangeAdded, LastCrawled: last.CrawledAt}
+    if p.IsNotModified() && ok {
+        event.Change, event.Hash = ChangeUnchanged, last.Hash
+    } else {
+        event.Hash = this.hash(p)
+        if ok && event.Hash == last.Hash {
+            event.Change = ChangeUnchanged
+        } else if ok {
+            event.Change = ChangeModified
+        }
+    }
+    return event, this.store.Put(url, ChangeRecord{Hash: event.Hash, CrawledAt: time.Now()})
+}
+
+// The SetIncremental sets Incremental, so pages whose content is not changed since the last crawl are not
+// passed to pipelines. They are still processed and their links are followed, for finding added pages.
+// Failed pages are not recorded. Changes are counted in Changes of Stats.
+func (this *Spider) SetIncremental(inc *Incremental) *Spider {
+    this.incremental = inc
+    return this
+}
+
+// The pageChanged records change of the processed page by Incremental, and returns whether it is added or
+// modified. It returns true without Incremental.
+func (this *Spider) pageChanged(p *page.Page) bool {
+    if this.incremental == nil || !p.IsSucc() || p.GetStatusCode() >= 400 {
+        return true
+    }
+    event, err := this.incremental.detect(p)
+    if err != nil {
+        logger.Error("change is not saved : "+err.Error(), mlog.F("url", event.Url))
+    }
+    this.stats.change(event.Change)
+    if this.incremental.handler != nil {
+        this.incremental.handler(event)
+    }
+    return event.Change != ChangeUnchanged
+}
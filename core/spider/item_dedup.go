@@ -0,0 +1,119 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "os"
+    "strings"
+)
+
+// The ItemDeduplicator drops PageItems whose identity is emitted before, like the same product found by
+// category, search and tag pages. Identity is values of the keys, like "sku", or of the function set by
+// SetIdentity; items without identity are not deduplicated.
+// Identities are recorded in a scheduler.Deduplicator, like a MapDeduplicator for this run, a
+// RedisDeduplicator shared by spiders and runs, or a PersistentDeduplicator saved in the file of SetFile.
+type ItemDeduplicator struct {
+    keys     []string
+    identity func(*page_items.PageItems) string
+    d        scheduler.Deduplicator
+    path     string
+}
+
+// NewItemDeduplicator returns ItemDeduplicator of identity keys that records identities in d, or in a
+// scheduler.MapDeduplicator if d is nil. Keys can be paths like "variants.0.sku" as of PageItems.GetPath.
+func NewItemDeduplicator(d scheduler.Deduplicator, keys ...string) *ItemDeduplicator {
+    if d == nil {
+        d = scheduler.NewMapDeduplicator()
+    }
+    return &ItemDeduplicator{keys: keys, d: d}
+}
+
+// The SetIdentity sets function that returns identity of PageItems instead of keys, like a normalized
+// name and brand. Returning "" means the items have no identity.
+func (this *ItemDeduplicator) SetIdentity(f func(*page_items.PageItems) string) *ItemDeduplicator {
+    this.identity = f
+    return this
+}
+
+// The SetFile sets file of identities for deduplication across runs: identities saved in it are loaded
+// when Run starts and all the identities are saved when Run returns. The Deduplicator must be a
+// scheduler.PersistentDeduplicator, or the file is not used.
+func (this *ItemDeduplicator) SetFile(path string) *ItemDeduplicator {
+    this.path = path
+    return this
+}
+
+// The Identity returns identity of the PageItems, which is values of the keys joined, or "" if any of them
+// has no value.
+func (this *ItemDeduplicator) Identity(items *page_items.PageItems) string {
+    if this.identity != nil {
+        return this.identity(items)
+    }
+    if len(this.keys) == 0 {
+        return ""
+    }
+    values := make([]string, len(this.keys))
+    for i, key := range this.keys {
+        value, ok := items.GetPath(key)
+        if !ok || value == nil || value == "" {
+            return ""
+        }
+        values[i] = valueString(value)
+    }
+    return strings.Join(values, "\x00")
+}
+
+// The Duplicate records identity of the PageItems and returns whether it is emitted before.
+func (this *ItemDeduplicator) Duplicate(items *page_items.PageItems) bool {
+    id := this.Identity(items)
+    return id != "" && this.d.Seen("item:"+id)
+}
+
+// The persistent returns Deduplicator saved in the file of SetFile, or nil.
+func (this *ItemDeduplicator) persistent() scheduler.PersistentDeduplicator {
+    if this.path == "" {
+        return nil
+    }
+    d, _ := this.d.(scheduler.PersistentDeduplicator)
+    return d
+}
+
+// The load adds identities saved in the file of SetFile.
+func (this *ItemDeduplicator) load() {
+    if d := this.persistent(); d != nil {
+        if err := d.Load(this.path); err != nil && !os.IsNotExist(err) {
+            logger.Error("item identities are not loaded : "+err.Error(), mlog.F("path", this.path))
+        }
+    }
+}
+
+// The save writes identities to the file of SetFile.
+func (this *ItemDeduplicator) save() {
+    if d := this.persistent(); d != nil {
+        if err := d.Save(this.path); err != nil {
+            logger.Error("item identities are not saved : "+err.Error(), mlog.F("path", this.path))
+        }
+    }
+}
+
+// The SetItemDeduplicator sets ItemDeduplicator, so that PageItems of identity emitted before are not passed
+// to pipelines. They are counted in DuplicateItems of Stats. Unlike deduplication of requests and
+// ContentDeduplicator, pages are still processed and their links are followed. Items are checked after
+// item validator, so invalid items do not take identities.
+func (this *Spider) SetItemDeduplicator(d *ItemDeduplicator) *Spider {
+    this.itemDeduplicator = d
+    return this
+}
+
+// The duplicateItems tests whether PageItems of the page are duplicate by ItemDeduplicator.
+func (this *Spider) duplicateItems(p *page.Page) bool {
+    if this.itemDeduplicator == nil || !this.itemDeduplicator.Duplicate(p.GetPageItems()) {
+        return false
+    }
+    logger.Debug("duplicate item is skipped", mlog.F("url", p.GetRequest().GetUrl()))
+    this.metrics.duplicateItem()
+    this.stats.duplicateItem()
+    return true
+}
@@ -0,0 +1,278 @@
+package spider
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "net/url"
+    "regexp"
+    "strconv"
+    "unicode/utf8"
+)
+
+// The InvalidReasonKey is key of the reason saved in PageItems passed to the error pipeline of
+// Spider.SetItemValidator.
+const InvalidReasonKey = "invalid_reason"
+
+// The ItemValidator declares the schema of PageItems: required keys, types and constraints of values.
+// Set its Check by Spider.SetItemValidator, so half-scraped items are dropped before pipelines instead of
+// filling up databases. Keys can be paths like "variants.0.price" as of PageItems.GetPath.
+type ItemValidator struct {
+    keys  []string
+    rules map[string]*itemRule
+}
+
+// The itemRule is constraints of value of a key.
+type itemRule struct {
+    required bool
+    kind     string
+    pattern  *regexp.Regexp
+    hasRange bool
+    min, max float64
+    minLen   int
+    maxLen   int
+    oneOf    map[string]bool
+}
+
+// NewItemValidator returns ItemValidator that accepts all the PageItems.
+func NewItemValidator() *ItemValidator {
+    return &ItemValidator{rules: make(map[string]*itemRule)}
+}
+
+func (this *ItemValidator) rule(key string) *itemRule {
+    r, ok := this.rules[key]
+    if !ok {
+        r = &itemRule{}
+        this.rules[key] = r
+        this.keys = append(this.keys, key)
+    }
+    return r
+}
+
+// The Require adds keys that must have a value which is not empty string. Constraints of keys not required
+// are checked only if the keys have values.
+func (this *ItemValidator) Require(keys ...string) *ItemValidator {
+    for _, key := range keys {
+        this.rule(key).required = true
+    }
+    return this
+}
+
+// The SetType sets type of value of the key: "string", "int", "float", "bool", "url" (absolute http or
+// https url), "list" or "map". Strings are accepted as "int", "float" and "bool" if they can be parsed.
+// It panics if the type is unknown.
+func (this *ItemValidator) SetType(key string, kind string) *ItemValidator {
+    switch kind {
+    case "string", "int", "float", "bool", "url", "list", "map":
+    default:
+        panic("unknown item type : " + kind)
+    }
+    this.rule(key).kind = kind
+    return this
+}
+
+// The SetPattern sets regexp that the value of the key must match, like `^\d{4}-\d{2}-\d{2}$`.
+// It panics if expr can not be compiled.
+func (this *ItemValidator) SetPattern(key string, expr string) *ItemValidator {
+    this.rule(key).pattern = regexp.MustCompile(expr)
+    return this
+}
+
+// The SetRange sets range of number value of the key, like price from 0.01 to 100000.
+func (this *ItemValidator) SetRange(key string, min float64, max float64) *ItemValidator {
+    r := this.rule(key)
+    r.hasRange, r.min, r.max = true, min, max
+    return this
+}
+
+// The SetLength sets range of length of the value of the key, characters of strings and elements of lists
+// and maps. Max 0 is no limit.
+func (this *ItemValidator) SetLength(key string, min int, max int) *ItemValidator {
+    r := this.rule(key)
+    r.minLen, r.maxLen = min, max
+    return this
+}
+
+// The SetOneOf sets values allowed for the key, like currencies "USD" and "EUR".
+func (this *ItemValidator) SetOneOf(key string, values ...string) *ItemValidator {
+    r := this.rule(key)
+    r.oneOf = make(map[string]bool)
+    for _, v := range values {
+        r.oneOf[v] = true
+    }
+    return this
+}
+
+// The Check returns the first rule the PageItems fails, like "price: 0 is out of range [0.01, 100000]",
+// or "" if it is valid.
+func (this *ItemValidator) Check(items *page_items.PageItems) string {
+    for _, key := range this.keys {
+        if reason := this.rules[key].check(items, key); reason != "" {
+            return key + ": " + reason
+        }
+    }
+    return ""
+}
+
+// The Validate returns whether the PageItems is valid.
+func (this *ItemValidator) Validate(items *page_items.PageItems) bool {
+    return this.Check(items) == ""
+}
+
+func (this *itemRule) check(items *page_items.PageItems, key string) string {
+    value, ok := items.GetPath(key)
+    if !ok || value == nil || value == "" {
+        if this.required {
+            return "is required"
+        }
+        return ""
+    }
+    if reason := checkType(value, this.kind); reason != "" {
+        return reason
+    }
+    if this.hasRange {
+        n, ok := toNumber(value)
+        if !ok {
+            return "is not a number"
+        }
+        if n < this.min || n > this.max {
+            return fmt.Sprintf("%g is out of range [%g, %g]", n, this.min, this.max)
+        }
+    }
+    if this.minLen > 0 || this.maxLen > 0 {
+        n := valueLen(value)
+        if n < this.minLen || (this.maxLen > 0 && n > this.maxLen) {
+            return "length " + strconv.Itoa(n) + " is out of range"
+        }
+    }
+    if this.pattern != nil && !this.pattern.MatchString(valueString(value)) {
+        return "does not match " + this.pattern.String()
+    }
+    if this.oneOf != nil && !this.oneOf[valueString(value)] {
+        return strconv.Quote(valueString(value)) + " is not allowed"
+    }
+    return ""
+}
+
+// The checkType returns why the value is not of the type, or "" if it is.
+func checkType(value interface{}, kind string) string {
+    ok := true
+    switch kind {
+    case "string":
+        _, ok = value.(string)
+    case "int":
+        var n float64
+        if n, ok = toNumber(value); ok {
+            ok = n == float64(int64(n))
+        }
+    case "float":
+        _, ok = toNumber(value)
+    case "bool":
+        switch v := value.(type) {
+        case bool:
+        case string:
+            _, err := strconv.ParseBool(v)
+            ok = err == nil
+        default:
+            ok = false
+        }
+    case "url":
+        s, isString := value.(string)
+        u, err := url.Parse(s)
+        ok = isString && err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+    case "list":
+        _, ok = value.([]interface{})
+        if _, isStrings := value.([]string); isStrings {
+            ok = true
+        }
+    case "map":
+        _, ok = value.(map[string]interface{})
+    }
+    if !ok {
+        return "is not " + kind
+    }
+    return ""
+}
+
+// The toNumber returns number of the value, which is a number or a string of number.
+func toNumber(value interface{}) (float64, bool) {
+    switch v := value.(type) {
+    case float64:
+        return v, true
+    case float32:
+        return float64(v), true
+    case int:
+        return float64(v), true
+    case int64:
+        return float64(v), true
+    case json.Number:
+        n, err := v.Float64()
+        return n, err == nil
+    case string:
+        n, err := strconv.ParseFloat(v, 64)
+        return n, err == nil
+    }
+    return 0, false
+}
+
+// The valueLen returns characters of string, or elements of list and map.
+func valueLen(value interface{}) int {
+    switch v := value.(type) {
+    case []interface{}:
+        return len(v)
+    case []string:
+        return len(v)
+    case map[string]interface{}:
+        return len(v)
+    }
+    return utf8.RuneCountInString(valueString(value))
+}
+
+// The valueString returns the string value, or json of other values.
+func valueString(value interface{}) string {
+    if s, ok := value.(string); ok {
+        return s
+    }
+    content, _ := json.Marshal(value)
+    return string(content)
+}
+
+// The SetItemValidator sets function called with PageItems of each page before pipelines, which returns why
+// the PageItems is invalid, or "" if it is valid. ItemValidator.Check declares the schema of items.
+// Invalid items are not passed to pipelines, and they are counted in InvalidItems of Stats. If errorPipeline
+// is not nil, it is passed a copy of the invalid items with the reason saved as InvalidReasonKey, for
+// reviewing what is broken in extraction rules.
+func (this *Spider) SetItemValidator(v func(*page_items.PageItems) string, errorPipeline pipeline.Pipeline) *Spider {
+    this.itemValidator, this.invalidItemPipeline = v, errorPipeline
+    return this
+}
+
+// The validItems checks PageItems of the page by the item validator, and passes invalid items to the error
+// pipeline. It returns whether the items are valid.
+func (this *Spider) validItems(ctx context.Context, p *page.Page) bool {
+    if this.itemValidator == nil {
+        return true
+    }
+    items := p.GetPageItems()
+    reason := this.itemValidator(items)
+    if reason == "" {
+        return true
+    }
+    logger.Debug("item is invalid : "+reason, mlog.F("url", p.GetRequest().GetUrl()))
+    this.stats.invalidItem()
+    this.metrics.invalidItem()
+    this.deadLetter(ctx, &DeadLetter{Kind: DeadLetterItem, Request: p.GetRequest(), Attempts: 1, Error: reason,
+        Items: items.GetValues()}, p)
+    if pip := this.invalidItemPipeline; pip != nil {
+        invalid := page_items.NewPageItems(items.GetRequest()).Merge(items, true)
+        invalid.AddItem(InvalidReasonKey, reason)
+        if this.safePipeline(pip, p.GetRequest().GetUrl(), func() { this.processItems(ctx, pip, invalid) }) {
+            this.stats.item(pipelineName(pip))
+        }
+    }
+    return false
+}
@@ -0,0 +1,40 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+)
+
+// The SetLanguages sets languages of pages processed, like "zh" and "en", by Page.GetLanguage that
+// HttpDownloader detects if its SetDetectLanguage is set, or else by Page.DetectLanguage. Pages of other languages are not processed, so their links are not followed and
+// their items are not output; they are counted as dropped for reason "language". Pages whose language is not
+// known are processed. No languages means pages of all the languages are processed, which is default.
+func (this *Spider) SetLanguages(langs ...string) *Spider {
+    if len(langs) == 0 {
+        this.languages = nil
+        return this
+    }
+    this.languages = make(map[string]bool, len(langs))
+    for _, lang := range langs {
+        this.languages[page.PrimaryLanguage(lang)] = true
+    }
+    return this
+}
+
+// The otherLanguage tests whether the page is of a language not processed.
+func (this *Spider) otherLanguage(p *page.Page) bool {
+    if this.languages == nil || !p.IsSucc() {
+        return false
+    }
+    lang := p.GetLanguage()
+    if lang == "" {
+        lang = p.DetectLanguage()
+    }
+    if lang == "" || this.languages[lang] {
+        return false
+    }
+    logger.Debug("page of other language is skipped", mlog.F("url", p.GetRequest().GetUrl()),
+        mlog.F("language", lang))
+    this.dropRequest("language")
+    return true
+}
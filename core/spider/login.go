@@ -0,0 +1,43 @@
+package spider
+
+import (
+    "errors"
+    "github.com/hu17889/go_spider/core/downloader"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "strconv"
+)
+
+// Login posts the form to loginURL, follows redirects and returns cookie jar that has the session cookies.
+// The jar is a downloader.CookieJar, which can be used by Spider.SetCookieJar to crawl pages that need login,
+// and can be saved for reusing the session.
+// If check is not nil, it is called with the body of the last responce and Login fails if it returns false,
+// so that a failed login is found before crawling.
+func Login(loginURL string, form map[string]string, check func(body string) bool) (http.CookieJar, error) {
+    jar := downloader.NewCookieJar()
+    values := make(url.Values)
+    for key, value := range form {
+        values.Set(key, value)
+    }
+    client := &http.Client{Jar: jar}
+    resp, err := client.PostForm(loginURL, values)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 400 {
+        return nil, errors.New("login failed with http status " + strconv.Itoa(resp.StatusCode))
+    }
+
+    if check != nil {
+        body, err := ioutil.ReadAll(resp.Body)
+        if err != nil {
+            return nil, err
+        }
+        if !check(string(body)) {
+            return nil, errors.New("login check failed : " + loginURL)
+        }
+    }
+    return jar, nil
+}
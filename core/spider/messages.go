@@ -0,0 +1,36 @@
+package spider
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/page_processer"
+)
+
+// The processMessages processes messages of "sse" or "websocket" page while its stream is open. The page is
+// passed to page_processer.MessagePageProcesser or callback func(*page.Page, <-chan page.Message), whose
+// Page.Emit outputs items and target requests as they are added; other PageProcessers process
+// Page.MessagePage of each message, which is output after it is processed.
+func (this *Spider) processMessages(ctx context.Context, p *page.Page) {
+    p.SetEmitter(func(out *page.Page) {
+        this.emit(ctx, out)
+    })
+    var mp page_processer.MessagePageProcesser
+    switch callback := p.GetRequest().GetCallback().(type) {
+    case func(*page.Page, <-chan page.Message):
+        mp = page_processer.MessagePageProcesserFunc(callback)
+    case page_processer.MessagePageProcesser:
+        mp = callback
+    case nil:
+        mp, _ = this.GetPageProcesser().(page_processer.MessagePageProcesser)
+    }
+    if mp != nil {
+        mp.ProcessMessages(p, p.Messages())
+        p.Emit()
+    } else {
+        for msg := range p.Messages() {
+            m := p.MessagePage(msg)
+            this.process(m)
+            this.emit(ctx, m)
+        }
+    }
+}
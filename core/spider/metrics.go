@@ -0,0 +1,334 @@
+package spider
+
+import (
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/downloader"
+    "net"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The Metrics counts downloads, errors and pipeline work of Spider, and serves them in Prometheus text format.
+// It is an http.Handler, so it can be served by Spider.ServeMetrics or mounted on other http server.
+type Metrics struct {
+    locker sync.Mutex
+
+    // The pages counts downloaded pages by http status code, 0 for network errors.
+    pages map[int]uint64
+    bytes uint64
+
+    // The errors counts failed downloads and http error status by type.
+    errors map[string]uint64
+
+    // The dropped counts requests dropped before they are pushed to Scheduler by reason.
+    dropped map[string]uint64
+
+    // The duplicates counts pages skipped by ContentDeduplicator.
+    duplicates uint64
+
+    // The captchas counts captcha pages by result of solving, "solved" or "failed".
+    captchas map[string]uint64
+
+    // The hosts saves count, errors and total seconds of downloads of each host.
+    hosts map[string]*latency
+
+    // The recentErrors saves the last failed downloads, the latest last.
+    recentErrors []ErrorRecord
+
+    // The invalidItems counts PageItems rejected by item validator.
+    invalidItems uint64
+
+    // The duplicateItems counts PageItems skipped by ItemDeduplicator.
+    duplicateItems uint64
+
+    pipelineItems   uint64
+    pipelineSeconds float64
+
+    // The pipelineErrors counts panics of each pipeline by its type.
+    pipelineErrors map[string]uint64
+
+    // The queueDepth returns number of requests in Scheduler when metrics are scraped.
+    queueDepth func() int
+
+    // The budgetCounts returns pages crawled of each domain by Budget, or it is nil without Budget.
+    budgetCounts func() map[string]int
+}
+
+type latency struct {
+    count   uint64
+    errors  uint64
+    seconds float64
+}
+
+// The maxRecentErrors is how many failed downloads are kept by Metrics.
+const maxRecentErrors = 50
+
+// The ErrorRecord is a failed download or a page of http error status.
+type ErrorRecord struct {
+    Time       time.Time `json:"time"`
+    Url        string    `json:"url"`
+    StatusCode int       `json:"status_code"`
+    Type       string    `json:"type"`
+    Message    string    `json:"message"`
+}
+
+func newMetrics(queueDepth func() int) *Metrics {
+    return &Metrics{
+        pages:    make(map[int]uint64),
+        errors:   make(map[string]uint64),
+        dropped:  make(map[string]uint64),
+        captchas: make(map[string]uint64),
+
+        pipelineErrors: make(map[string]uint64),
+        hosts:          make(map[string]*latency),
+        queueDepth:     queueDepth,
+    }
+}
+
+// The errorType returns type of failed page for metrics. Network errors are "dns", "connect", "tls" and
+// "timeout" by Page.GetErrorClass, or "network" if their class is not known.
+func errorType(p *page.Page) string {
+    switch {
+    case p.Errormsg() == downloader.ErrRobotsDisallowed.Error():
+        return "robots"
+    case p.GetStatusCode() == 0 && p.GetErrorClass() != "":
+        return p.GetErrorClass()
+    case p.GetStatusCode() == 0:
+        return "network"
+    case p.GetStatusCode() >= 500:
+        return "http_5xx"
+    case p.GetStatusCode() >= 400:
+        return "http_4xx"
+    }
+    return "other"
+}
+
+// The download records the page downloaded in d.
+func (this *Metrics) download(p *page.Page, d time.Duration) {
+    host := p.GetRequest().GetUrl()
+    if u, err := url.Parse(host); err == nil {
+        host = u.Host
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.pages[p.GetStatusCode()]++
+    this.bytes += uint64(len(p.GetBodyStr())) + uint64(p.GetFileSize())
+    l := this.hosts[host]
+    if l == nil {
+        l = &latency{}
+        this.hosts[host] = l
+    }
+    if !p.IsSucc() || p.GetStatusCode() >= 400 {
+        t := errorType(p)
+        this.errors[t]++
+        l.errors++
+        if len(this.recentErrors) == maxRecentErrors {
+            this.recentErrors = append(this.recentErrors[:0], this.recentErrors[1:]...)
+        }
+        this.recentErrors = append(this.recentErrors, ErrorRecord{Time: time.Now(),
+            Url: p.GetRequest().GetUrl(), StatusCode: p.GetStatusCode(), Type: t, Message: p.Errormsg()})
+    }
+    l.count++
+    l.seconds += d.Seconds()
+}
+
+// The reject records a page rejected by responce validator.
+func (this *Metrics) reject() {
+    this.locker.Lock()
+    this.errors["rejected"]++
+    this.locker.Unlock()
+}
+
+// The drop records a request dropped for the reason, like "depth".
+func (this *Metrics) drop(reason string) {
+    this.locker.Lock()
+    this.dropped[reason]++
+    this.locker.Unlock()
+}
+
+// The captcha records a captcha page by result of solving.
+func (this *Metrics) captcha(result string) {
+    this.locker.Lock()
+    this.captchas[result]++
+    this.locker.Unlock()
+}
+
+// The duplicate records a page of duplicate content.
+func (this *Metrics) duplicate() {
+    this.locker.Lock()
+    this.duplicates++
+    this.locker.Unlock()
+}
+
+// The invalidItem records PageItems rejected by item validator.
+func (this *Metrics) invalidItem() {
+    this.locker.Lock()
+    this.invalidItems++
+    this.locker.Unlock()
+}
+
+// The duplicateItem records PageItems of identity emitted before.
+func (this *Metrics) duplicateItem() {
+    this.locker.Lock()
+    this.duplicateItems++
+    this.locker.Unlock()
+}
+
+// The pipelineError records a panic of the pipeline of the name.
+func (this *Metrics) pipelineError(name string) {
+    this.locker.Lock()
+    this.pipelineErrors[name]++
+    this.locker.Unlock()
+}
+
+// The setBudgetCounts sets function that returns pages crawled of each domain.
+func (this *Metrics) setBudgetCounts(f func() map[string]int) {
+    this.locker.Lock()
+    this.budgetCounts = f
+    this.locker.Unlock()
+}
+
+// The pipeline records a page processed by all the pipelines in d.
+func (this *Metrics) pipeline(d time.Duration) {
+    this.locker.Lock()
+    this.pipelineItems++
+    this.pipelineSeconds += d.Seconds()
+    this.locker.Unlock()
+}
+
+// The ServeHTTP writes metrics in Prometheus text exposition format.
+func (this *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    var b strings.Builder
+    this.locker.Lock()
+    b.WriteString("# HELP go_spider_pages_total Downloaded pages by http status code, 0 for network errors.\n")
+    b.WriteString("# TYPE go_spider_pages_total counter\n")
+    codes := make([]int, 0, len(this.pages))
+    for code := range this.pages {
+        codes = append(codes, code)
+    }
+    sort.Ints(codes)
+    for _, code := range codes {
+        fmt.Fprintf(&b, "go_spider_pages_total{code=\"%d\"} %d\n", code, this.pages[code])
+    }
+
+    b.WriteString("# HELP go_spider_bytes_total Bytes of downloaded pages.\n")
+    b.WriteString("# TYPE go_spider_bytes_total counter\n")
+    fmt.Fprintf(&b, "go_spider_bytes_total %d\n", this.bytes)
+
+    b.WriteString("# HELP go_spider_errors_total Failed downloads and http error status by type.\n")
+    b.WriteString("# TYPE go_spider_errors_total counter\n")
+    for _, t := range sortedKeys(this.errors) {
+        fmt.Fprintf(&b, "go_spider_errors_total{type=%s} %d\n", strconv.Quote(t), this.errors[t])
+    }
+
+    b.WriteString("# HELP go_spider_dropped_requests_total Requests dropped before they are pushed to Scheduler by reason.\n")
+    b.WriteString("# TYPE go_spider_dropped_requests_total counter\n")
+    for _, reason := range sortedKeys(this.dropped) {
+        fmt.Fprintf(&b, "go_spider_dropped_requests_total{reason=%s} %d\n", strconv.Quote(reason), this.dropped[reason])
+    }
+
+    b.WriteString("# HELP go_spider_duplicate_pages_total Pages of duplicate content that are not processed.\n")
+    b.WriteString("# TYPE go_spider_duplicate_pages_total counter\n")
+    fmt.Fprintf(&b, "go_spider_duplicate_pages_total %d\n", this.duplicates)
+
+    b.WriteString("# HELP go_spider_captchas_total Captcha pages by result of solving.\n")
+    b.WriteString("# TYPE go_spider_captchas_total counter\n")
+    for _, result := range sortedKeys(this.captchas) {
+        fmt.Fprintf(&b, "go_spider_captchas_total{result=%s} %d\n", strconv.Quote(result), this.captchas[result])
+    }
+
+    b.WriteString("# HELP go_spider_download_seconds Download latency by host.\n")
+    b.WriteString("# TYPE go_spider_download_seconds summary\n")
+    hosts := make([]string, 0, len(this.hosts))
+    for host := range this.hosts {
+        hosts = append(hosts, host)
+    }
+    sort.Strings(hosts)
+    for _, host := range hosts {
+        l := this.hosts[host]
+        fmt.Fprintf(&b, "go_spider_download_seconds_sum{host=%s} %g\n", strconv.Quote(host), l.seconds)
+        fmt.Fprintf(&b, "go_spider_download_seconds_count{host=%s} %d\n", strconv.Quote(host), l.count)
+    }
+
+    b.WriteString("# HELP go_spider_invalid_items_total Items rejected by item validator before pipelines.\n")
+    b.WriteString("# TYPE go_spider_invalid_items_total counter\n")
+    fmt.Fprintf(&b, "go_spider_invalid_items_total %d\n", this.invalidItems)
+
+    b.WriteString("# HELP go_spider_duplicate_items_total Items of identity emitted before that are not passed to pipelines.\n")
+    b.WriteString("# TYPE go_spider_duplicate_items_total counter\n")
+    fmt.Fprintf(&b, "go_spider_duplicate_items_total %d\n", this.duplicateItems)
+
+    b.WriteString("# HELP go_spider_pipeline_seconds Time of pages processed by pipelines.\n")
+    b.WriteString("# TYPE go_spider_pipeline_seconds summary\n")
+    fmt.Fprintf(&b, "go_spider_pipeline_seconds_sum %g\n", this.pipelineSeconds)
+    fmt.Fprintf(&b, "go_spider_pipeline_seconds_count %d\n", this.pipelineItems)
+
+    b.WriteString("# HELP go_spider_pipeline_errors_total Panics of pipelines by type.\n")
+    b.WriteString("# TYPE go_spider_pipeline_errors_total counter\n")
+    for _, name := range sortedKeys(this.pipelineErrors) {
+        fmt.Fprintf(&b, "go_spider_pipeline_errors_total{pipeline=%s} %d\n", strconv.Quote(name), this.pipelineErrors[name])
+    }
+    budgetCounts := this.budgetCounts
+    this.locker.Unlock()
+
+    if budgetCounts != nil {
+        counts := budgetCounts()
+        domains := make([]string, 0, len(counts))
+        for domain := range counts {
+            domains = append(domains, domain)
+        }
+        sort.Strings(domains)
+        b.WriteString("# HELP go_spider_budget_pages_total Pages crawled of each domain counted by Budget.\n")
+        b.WriteString("# TYPE go_spider_budget_pages_total counter\n")
+        for _, domain := range domains {
+            fmt.Fprintf(&b, "go_spider_budget_pages_total{domain=%s} %d\n", strconv.Quote(domain), counts[domain])
+        }
+    }
+
+    b.WriteString("# HELP go_spider_queue_depth Requests in Scheduler.\n")
+    b.WriteString("# TYPE go_spider_queue_depth gauge\n")
+    fmt.Fprintf(&b, "go_spider_queue_depth %d\n", this.queueDepth())
+
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    w.Write([]byte(b.String()))
+}
+
+func sortedKeys(m map[string]uint64) []string {
+    keys := make([]string, 0, len(m))
+    for key := range m {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// The RecentErrors returns the last failed downloads, the latest last.
+func (this *Metrics) RecentErrors() []ErrorRecord {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return append([]ErrorRecord(nil), this.recentErrors...)
+}
+
+// The GetMetrics returns Metrics of the spider.
+func (this *Spider) GetMetrics() *Metrics {
+    return this.metrics
+}
+
+// The ServeMetrics serves Metrics at path "/metrics" of addr like ":9090" in background, for Prometheus.
+// It returns error if addr can not be listened, and the server runs until the process exits.
+func (this *Spider) ServeMetrics(addr string) error {
+    l, err := net.Listen("tcp", addr)
+    if err != nil {
+        return err
+    }
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", this.metrics)
+    go http.Serve(l, mux)
+    return nil
+}
@@ -0,0 +1,72 @@
+package spider
+
+import (
+    "bufio"
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/request"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+)
+
+// The savePendingRequests saves requests in the file, one json line for each request.
+// The file is removed if there is no request.
+func savePendingRequests(path string, reqs []*request.Request) error {
+    if len(reqs) == 0 {
+        if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+        return nil
+    }
+
+    // write to temp file first so that a broken file is never read.
+    tmp, err := ioutil.TempFile(filepath.Dir(path), "tmp")
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(tmp)
+    for _, req := range reqs {
+        line, err := json.Marshal(req)
+        if err != nil {
+            logger.Error(err.Error())
+            continue
+        }
+        w.Write(append(line, '\n'))
+    }
+    if err = w.Flush(); err != nil {
+        tmp.Close()
+        os.Remove(tmp.Name())
+        return err
+    }
+    tmp.Close()
+    if err = os.Rename(tmp.Name(), path); err != nil {
+        os.Remove(tmp.Name())
+        return err
+    }
+    return nil
+}
+
+// The loadPendingRequests reads requests saved by savePendingRequests.
+// It returns no request if the file does not exist. Broken lines are skipped.
+func loadPendingRequests(path string) ([]*request.Request, error) {
+    f, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return nil, nil
+    } else if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    var reqs []*request.Request
+    scanner := bufio.NewScanner(f)
+    scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+    for scanner.Scan() {
+        req := &request.Request{}
+        if err := json.Unmarshal(scanner.Bytes(), req); err != nil {
+            logger.Error("pending request line broken : " + scanner.Text())
+            continue
+        }
+        reqs = append(reqs, req)
+    }
+    return reqs, scanner.Err()
+}
@@ -0,0 +1,84 @@
+package spider
+
+import (
+    "context"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/trace"
+    "github.com/hu17889/go_spider/core/pipeline"
+)
+
+// The pipelineOption is item filter and concurrency of a pipeline added by AddPipelineWith.
+type pipelineOption struct {
+    filter func(*page_items.PageItems) bool
+
+    // The slots limits calls of the pipeline at once, or it is nil for no limit.
+    slots chan struct{}
+}
+
+// The AddPipelineWith adds pipeline that is passed only PageItems the filter returns true for, like items of
+// one type to its own table, and is called by at most concurrency download or pipeline workers at once, like
+// 1 for a pipeline that is not safe for concurrent use. Nil filter passes all the items and concurrency 0
+// means no limit.
+func (this *Spider) AddPipelineWith(p pipeline.Pipeline, filter func(*page_items.PageItems) bool, concurrency int) *Spider {
+    opt := &pipelineOption{filter: filter}
+    if concurrency > 0 {
+        opt.slots = make(chan struct{}, concurrency)
+    }
+    this.pPiplelines = append(this.pPiplelines, p)
+    this.pipelineOptions = append(this.pipelineOptions, opt)
+    return this
+}
+
+// The runPipeline passes the page to the pipeline by its option. It returns false if the pipeline panics.
+func (this *Spider) runPipeline(ctx context.Context, pip pipeline.Pipeline, opt *pipelineOption, p *page.Page) bool {
+    if opt != nil && opt.filter != nil && !opt.filter(p.GetPageItems()) {
+        return true
+    }
+    if opt != nil && opt.slots != nil {
+        opt.slots <- struct{}{}
+        defer func() {
+            <-opt.slots
+        }()
+    }
+    ctx, span := trace.Start(ctx, "pipeline "+pipelineName(pip))
+    ok := this.safePipeline(pip, p.GetRequest().GetUrl(), func() {
+        if pp, ok := pip.(pipeline.PagePipeline); ok {
+            pp.ProcessPage(p, this)
+        } else {
+            this.processItems(ctx, pip, p.GetPageItems())
+        }
+    })
+    if !ok {
+        span.SetError("pipeline panics")
+    }
+    span.Finish()
+    return ok
+}
+
+// The processItems passes the PageItems to the pipeline by ProcessContext of pipeline.ContextPipeline or Process.
+func (this *Spider) processItems(ctx context.Context, pip pipeline.Pipeline, items *page_items.PageItems) {
+    if cp, ok := pip.(pipeline.ContextPipeline); ok {
+        cp.ProcessContext(ctx, items, this)
+    } else {
+        pip.Process(items, this)
+    }
+}
+
+// The safePipeline calls f of the pipeline, and recovers panic of it so that other pipelines still get the
+// items. The panic is logged and counted in PipelineErrors of Stats. It returns false if f panics.
+func (this *Spider) safePipeline(pip pipeline.Pipeline, url string, f func()) (ok bool) {
+    defer func() {
+        if r := recover(); r != nil {
+            name := pipelineName(pip)
+            logger.Error(fmt.Sprintf("pipeline panics : %v", r), mlog.F("pipeline", name), mlog.F("url", url))
+            this.stats.pipelineError(name)
+            this.metrics.pipelineError(name)
+            ok = false
+        }
+    }()
+    f()
+    return true
+}
@@ -0,0 +1,45 @@
+package spider
+
+import (
+    "math/rand"
+    "sync"
+    "time"
+)
+
+// The randomDelay draws delays uniformly between min and max.
+// It is safe for concurrent use because rand.Rand is not.
+type randomDelay struct {
+    locker sync.Mutex
+    rand   *rand.Rand
+
+    min time.Duration
+    max time.Duration
+}
+
+func newRandomDelay(min, max time.Duration, seed int64) *randomDelay {
+    return &randomDelay{rand: rand.New(rand.NewSource(seed)), min: min, max: max}
+}
+
+// The next returns a delay in [min, max].
+func (this *randomDelay) next() time.Duration {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if this.max <= this.min {
+        return this.min
+    }
+    return this.min + time.Duration(this.rand.Int63n(int64(this.max-this.min)+1))
+}
+
+// The set changes min and max, while delays are drawn.
+func (this *randomDelay) set(min, max time.Duration) {
+    this.locker.Lock()
+    this.min, this.max = min, max
+    this.locker.Unlock()
+}
+
+// The seed resets the random source so that the delays are repeatable.
+func (this *randomDelay) seed(seed int64) {
+    this.locker.Lock()
+    this.rand.Seed(seed)
+    this.locker.Unlock()
+}
@@ -0,0 +1,38 @@
+package spider
+
+import (
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestRandomDelay(t *testing.T) {
+    a := newRandomDelay(10*time.Millisecond, 20*time.Millisecond, 1)
+    b := newRandomDelay(10*time.Millisecond, 20*time.Millisecond, 2)
+    b.seed(1)
+    for i := 0; i < 100; i++ {
+        da, db := a.next(), b.next()
+        if da != db {
+            t.Fatal("seeded delays should be the same")
+        }
+        if da < 10*time.Millisecond || da > 20*time.Millisecond {
+            t.Fatalf("delay out of range: %v", da)
+        }
+    }
+
+    if d := newRandomDelay(time.Second, time.Second, 1).next(); d != time.Second {
+        t.Error("fixed delay error")
+    }
+
+    var wg sync.WaitGroup
+    for i := 0; i < 10; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 100; j++ {
+                a.next()
+            }
+        }()
+    }
+    wg.Wait()
+}
@@ -0,0 +1,121 @@
+package spider
+
+import (
+    "context"
+    "golang.org/x/time/rate"
+    "math/rand"
+    "net/url"
+    "sync"
+    "time"
+)
+
+// The rateLimit limits requests per second of all the requests and of each host by token buckets,
+// and keeps delay with random jitter between requests of each host.
+type rateLimit struct {
+    global *rate.Limiter
+
+    locker    sync.Mutex
+    hostRate  float64
+    hostBurst int
+    hosts     map[string]*rate.Limiter
+
+    // The next saves the earliest time of next request of each host, for the host delay.
+    hostDelay  time.Duration
+    hostJitter time.Duration
+    rand       *rand.Rand
+    next       map[string]time.Time
+}
+
+func newRateLimit() *rateLimit {
+    return &rateLimit{
+        hostBurst: 1,
+        hosts:     make(map[string]*rate.Limiter),
+        rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+        next:      make(map[string]time.Time),
+    }
+}
+
+// The newLimiter returns limiter of rps requests per second with burst, or nil for no limit.
+func newLimiter(rps float64, burst int) *rate.Limiter {
+    if rps <= 0 {
+        return nil
+    }
+    if burst < 1 {
+        burst = 1
+    }
+    return rate.NewLimiter(rate.Limit(rps), burst)
+}
+
+func (this *rateLimit) setGlobal(rps float64) {
+    this.locker.Lock()
+    this.global = newLimiter(rps, 1)
+    this.locker.Unlock()
+}
+
+func (this *rateLimit) setHost(rps float64) {
+    this.locker.Lock()
+    this.hostRate = rps
+    this.hosts = make(map[string]*rate.Limiter)
+    this.locker.Unlock()
+}
+
+func (this *rateLimit) setHostBurst(burst int) {
+    this.locker.Lock()
+    this.hostBurst = burst
+    this.hosts = make(map[string]*rate.Limiter)
+    this.locker.Unlock()
+}
+
+func (this *rateLimit) setHostDelay(delay, jitter time.Duration) {
+    this.locker.Lock()
+    this.hostDelay = delay
+    this.hostJitter = jitter
+    this.next = make(map[string]time.Time)
+    this.locker.Unlock()
+}
+
+// The wait blocks until both the global limit and the limit of host of the url allow a request.
+func (this *rateLimit) wait(rawurl string) {
+    name := rawurl
+    if u, err := url.Parse(rawurl); err == nil {
+        name = u.Host
+    }
+
+    this.locker.Lock()
+    global := this.global
+    var host *rate.Limiter
+    if this.hostRate > 0 {
+        if host = this.hosts[name]; host == nil {
+            host = newLimiter(this.hostRate, this.hostBurst)
+            this.hosts[name] = host
+        }
+    }
+
+    // the request takes the next time of its host, so concurrent requests of the host are spaced out
+    var sleep time.Duration
+    if this.hostDelay > 0 || this.hostJitter > 0 {
+        now := time.Now()
+        start := this.next[name]
+        if start.Before(now) {
+            start = now
+        }
+        interval := this.hostDelay
+        if this.hostJitter > 0 {
+            interval += time.Duration(this.rand.Int63n(int64(this.hostJitter) + 1))
+        }
+        this.next[name] = start.Add(interval)
+        sleep = start.Sub(now)
+    }
+    this.locker.Unlock()
+
+    if sleep > 0 {
+        time.Sleep(sleep)
+    }
+    // waiting for host first so that the global token is not held by a request of a busy host
+    if host != nil {
+        host.Wait(context.Background())
+    }
+    if global != nil {
+        global.Wait(context.Background())
+    }
+}
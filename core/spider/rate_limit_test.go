@@ -0,0 +1,80 @@
+package spider
+
+import (
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestRateLimit(t *testing.T) {
+    l := newRateLimit()
+    l.setHost(20)
+
+    // first request of each host is not limited
+    start := time.Now()
+    l.wait("http://a.com/1")
+    l.wait("http://b.com/1")
+    if time.Since(start) > 30*time.Millisecond {
+        t.Error("hosts should be limited separately")
+    }
+    l.wait("http://a.com/2")
+    if d := time.Since(start); d < 40*time.Millisecond {
+        t.Errorf("host limit error: %v", d)
+    }
+
+    // the global limit is more restrictive
+    l.setGlobal(10)
+    l.wait("http://c.com/1")
+    start = time.Now()
+    l.wait("http://d.com/1")
+    if d := time.Since(start); d < 80*time.Millisecond {
+        t.Errorf("global limit error: %v", d)
+    }
+
+    l.setGlobal(0)
+    l.setHost(0)
+    start = time.Now()
+    for i := 0; i < 10; i++ {
+        l.wait("http://a.com/")
+    }
+    if time.Since(start) > 30*time.Millisecond {
+        t.Error("zero should be no limit")
+    }
+}
+
+func TestHostBurstAndDelay(t *testing.T) {
+    l := newRateLimit()
+    l.setHost(10)
+    l.setHostBurst(3)
+    start := time.Now()
+    for i := 0; i < 3; i++ {
+        l.wait("http://a.com/")
+    }
+    if time.Since(start) > 30*time.Millisecond {
+        t.Error("burst requests should not be limited")
+    }
+    l.wait("http://a.com/")
+    if d := time.Since(start); d < 80*time.Millisecond {
+        t.Errorf("request after burst should be limited: %v", d)
+    }
+
+    l = newRateLimit()
+    l.setHostDelay(30*time.Millisecond, 20*time.Millisecond)
+    start = time.Now()
+    var wg sync.WaitGroup
+    for i := 0; i < 3; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            l.wait("http://a.com/")
+        }()
+    }
+    l.wait("http://b.com/")
+    if time.Since(start) > 20*time.Millisecond {
+        t.Error("other host should not be delayed")
+    }
+    wg.Wait()
+    if d := time.Since(start); d < 60*time.Millisecond || d > 150*time.Millisecond {
+        t.Errorf("concurrent requests of a host should be delayed: %v", d)
+    }
+}
@@ -0,0 +1,83 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "regexp"
+    "strconv"
+)
+
+// The ResponseValidator declares what a good page looks like, for soft-blocked pages like captcha or
+// access denied pages that are answered with status 200. Set its Validate by Spider.SetResponseValidator,
+// so pages failing any of the rules are retried by another proxy and User-Agent instead of being processed.
+type ResponseValidator struct {
+    statusCodes map[int]bool
+    minBodySize int
+    selectors   []string
+    markers     []*regexp.Regexp
+}
+
+// NewResponseValidator returns ResponseValidator that accepts all the pages downloaded successfully.
+func NewResponseValidator() *ResponseValidator {
+    return &ResponseValidator{}
+}
+
+// The SetStatusCodes sets http status codes of good pages, like 200. Default is any status code.
+func (this *ResponseValidator) SetStatusCodes(codes ...int) *ResponseValidator {
+    this.statusCodes = make(map[int]bool)
+    for _, code := range codes {
+        this.statusCodes[code] = true
+    }
+    return this
+}
+
+// The SetMinBodySize sets the smallest body in bytes of good pages, since block pages are often tiny.
+func (this *ResponseValidator) SetMinBodySize(n int) *ResponseValidator {
+    this.minBodySize = n
+    return this
+}
+
+// The RequireSelector adds css selectors that must match an element of good "html" pages, like the
+// content block that block pages do not have.
+func (this *ResponseValidator) RequireSelector(selectors ...string) *ResponseValidator {
+    this.selectors = append(this.selectors, selectors...)
+    return this
+}
+
+// The AddBanMarker adds regexps of bodies of block pages, like "captcha" or "(?i)access denied".
+// It panics if an expr can not be compiled.
+func (this *ResponseValidator) AddBanMarker(exprs ...string) *ResponseValidator {
+    for _, expr := range exprs {
+        this.markers = append(this.markers, regexp.MustCompile(expr))
+    }
+    return this
+}
+
+// The Check returns the first rule the page fails, or "" if it is a good page.
+func (this *ResponseValidator) Check(p *page.Page) string {
+    if len(this.statusCodes) > 0 && !this.statusCodes[p.GetStatusCode()] {
+        return "status " + strconv.Itoa(p.GetStatusCode())
+    }
+    body := p.GetBodyStr()
+    if len(body) < this.minBodySize {
+        return "body of " + strconv.Itoa(len(body)) + " bytes"
+    }
+    for _, marker := range this.markers {
+        if marker.MatchString(body) {
+            return "ban marker " + marker.String()
+        }
+    }
+    if len(this.selectors) > 0 && p.GetRequest().GetResponceType() == "html" {
+        doc := p.GetHtmlParser()
+        for _, selector := range this.selectors {
+            if doc == nil || doc.Find(selector).Length() == 0 {
+                return "no element of " + selector
+            }
+        }
+    }
+    return ""
+}
+
+// The Validate returns whether the page is good, for Spider.SetResponseValidator.
+func (this *ResponseValidator) Validate(p *page.Page) bool {
+    return this.Check(p) == ""
+}
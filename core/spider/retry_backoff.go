@@ -0,0 +1,111 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "time"
+)
+
+// The SetRetryBackoff makes failed requests requeued to Scheduler instead of being downloaded again at once.
+// The request waits base * 2^n before it is requeued for the nth retry, no more than max, or the delay of
+// Retry-After header if it is larger. Retries are limited by Request.SetMaxRetries or SetRetryTimes, and the
+// count is saved in the request by Request.SetRetries. Workers are free while requests wait, and Run does
+// not return until waiting requests are crawled. The base 0 disables it.
+func (this *Spider) SetRetryBackoff(base, max time.Duration) *Spider {
+    this.retryBackoffBase = base
+    this.retryBackoffMax = max
+    return this
+}
+
+// The retryBudget returns how many times the request can be requeued.
+func (this *Spider) retryBudget(req *request.Request) int {
+    if n := req.GetMaxRetries(); n < 0 {
+        return 0
+    } else if n > 0 {
+        return n
+    }
+    return int(this.GetRetryTimes())
+}
+
+// The backoff returns wait time before the nth retry.
+func (this *Spider) backoff(n int) time.Duration {
+    delay := this.retryBackoffBase
+    for i := 1; i < n; i++ {
+        delay *= 2
+        if this.retryBackoffMax > 0 && delay >= this.retryBackoffMax {
+            break
+        }
+    }
+    if this.retryBackoffMax > 0 && delay > this.retryBackoffMax {
+        delay = this.retryBackoffMax
+    }
+    return delay
+}
+
+// The retryLater requeues the request after backoff, and returns false if its retries are used up.
+func (this *Spider) retryLater(req *request.Request, p *page.Page) bool {
+    n := req.GetRetries() + 1
+    if n > this.retryBudget(req) {
+        return false
+    }
+    req.SetRetries(n)
+    delay := this.backoff(n)
+    if d, ok := this.retryDelay(p); ok && d > delay {
+        delay = d
+    }
+    logger.Info("request is retried", mlog.F("url", req.GetUrl()), mlog.F("delay", delay), mlog.F("retries", n))
+    this.requeueAfter(req, delay)
+    return true
+}
+
+// The requeueAfter requeues the request after delay. Requests returned by request middlewares are requeued
+// as they were polled, with their retries.
+func (this *Spider) requeueAfter(req *request.Request, delay time.Duration) {
+    this.dispatchLocker.Lock()
+    if polled, ok := this.middlewareRequests[req]; ok {
+        polled.SetRetries(req.GetRetries())
+        req = polled
+    }
+    this.delayed[req] = time.AfterFunc(delay, func() {
+        this.dispatchLocker.Lock()
+        defer this.dispatchLocker.Unlock()
+        if _, ok := this.delayed[req]; !ok {
+            return
+        }
+        delete(this.delayed, req)
+        req.SetQueuedAt(time.Now())
+        this.requeue(req)
+        this.wakeup()
+    })
+    this.dispatchLocker.Unlock()
+}
+
+// The hasDelayed tests whether some requests are waiting to be retried or revisited.
+func (this *Spider) hasDelayed() bool {
+    this.dispatchLocker.Lock()
+    defer this.dispatchLocker.Unlock()
+    return len(this.delayed) > 0
+}
+
+// The flushDelayed requeues requests waiting to be retried or revisited at once, when Run is stopped.
+func (this *Spider) flushDelayed() {
+    this.dispatchLocker.Lock()
+    defer this.dispatchLocker.Unlock()
+    for req, timer := range this.delayed {
+        timer.Stop()
+        delete(this.delayed, req)
+        this.requeue(req)
+    }
+}
+
+// The requeue pushes the request polled before back to Scheduler, which is not removed as duplicate
+// if Scheduler is a scheduler.RequeueScheduler.
+func (this *Spider) requeue(req *request.Request) {
+    if s, ok := this.pScheduler.(scheduler.RequeueScheduler); ok {
+        s.Requeue(req)
+    } else {
+        this.pScheduler.Push(req)
+    }
+}
@@ -0,0 +1,160 @@
+package spider
+
+import (
+    "crypto/md5"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/util"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The Revisit decides when crawled urls are crawled again, so Spider runs as a continuous monitor.
+// Urls matching a pattern are revisited by the interval of the first matching pattern, and other urls by
+// the default interval; the interval 0 means no revisit.
+// If it is adaptive, the interval of each url is halved when its page changes and doubled when it does not,
+// within the min and max interval. A page changes if its body changes; 304 Not Modified means no change.
+type Revisit struct {
+    interval time.Duration
+    patterns []revisitPattern
+
+    minInterval time.Duration
+    maxInterval time.Duration
+
+    // The changefreq makes urls of sitemaps revisited by their changefreq.
+    changefreq bool
+
+    // The states saves interval and body hash of each url for adaptive intervals.
+    locker sync.Mutex
+    states map[string]*revisitState
+}
+
+type revisitPattern struct {
+    reg      *regexp.Regexp
+    interval time.Duration
+}
+
+type revisitState struct {
+    interval time.Duration
+    hash     [md5.Size]byte
+}
+
+// NewRevisit returns Revisit of the default interval for all the urls. The interval 0 revisits
+// only urls matching patterns added by AddPattern.
+func NewRevisit(interval time.Duration) *Revisit {
+    return &Revisit{interval: interval, states: make(map[string]*revisitState)}
+}
+
+// The AddPattern sets interval of urls matching the regexp, like a short interval for listing pages.
+// It panics if the expr can not be compiled.
+func (this *Revisit) AddPattern(expr string, interval time.Duration) *Revisit {
+    this.patterns = append(this.patterns, revisitPattern{reg: regexp.MustCompile(expr), interval: interval})
+    return this
+}
+
+// The SetAdaptive makes intervals adapt to how often pages change, between min and max.
+func (this *Revisit) SetAdaptive(min, max time.Duration) *Revisit {
+    this.minInterval = min
+    this.maxInterval = max
+    return this
+}
+
+// The SetChangefreq makes urls found in sitemaps revisited by their changefreq, like 24 hours for "daily",
+// instead of the default interval. Urls of "always" are revisited by the min interval of SetAdaptive, or
+// hourly, and urls of "never" are not revisited. Patterns of AddPattern come first.
+func (this *Revisit) SetChangefreq(enabled bool) *Revisit {
+    this.changefreq = enabled
+    return this
+}
+
+// The changefreqIntervals is revisit interval of changefreq of sitemaps.
+var changefreqIntervals = map[string]time.Duration{
+    "always":  time.Hour,
+    "hourly":  time.Hour,
+    "daily":   24 * time.Hour,
+    "weekly":  7 * 24 * time.Hour,
+    "monthly": 30 * 24 * time.Hour,
+    "yearly":  365 * 24 * time.Hour,
+    "never":   0,
+}
+
+// The baseInterval returns interval of the request by patterns, or by changefreq of sitemap.
+func (this *Revisit) baseInterval(req *request.Request) time.Duration {
+    url := req.GetUrl()
+    for _, p := range this.patterns {
+        if p.reg.MatchString(url) {
+            return p.interval
+        }
+    }
+    if this.changefreq {
+        freq, _ := req.GetMeta("changefreq")
+        s, _ := freq.(string)
+        s = strings.ToLower(s)
+        if interval, ok := changefreqIntervals[s]; ok {
+            if s == "always" && this.minInterval > 0 {
+                return this.minInterval
+            }
+            return interval
+        }
+    }
+    return this.interval
+}
+
+// The next returns how long to wait before the page is crawled again, and false if it is not revisited.
+func (this *Revisit) next(p *page.Page) (time.Duration, bool) {
+    url := p.GetRequest().GetUrl()
+    interval := this.baseInterval(p.GetRequest())
+    if interval <= 0 {
+        return 0, false
+    }
+    if this.minInterval <= 0 && this.maxInterval <= 0 {
+        return interval, true
+    }
+
+    hash := md5.Sum([]byte(p.GetBodyStr()))
+    key := util.NormalizeUrl(url)
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    s := this.states[key]
+    if s == nil {
+        this.states[key] = &revisitState{interval: interval, hash: hash}
+        return interval, true
+    }
+    if p.IsSucc() {
+        if p.IsNotModified() || hash == s.hash {
+            s.interval *= 2
+        } else {
+            s.interval /= 2
+            s.hash = hash
+        }
+        if this.maxInterval > 0 && s.interval > this.maxInterval {
+            s.interval = this.maxInterval
+        }
+        if s.interval < this.minInterval {
+            s.interval = this.minInterval
+        }
+    }
+    return s.interval, true
+}
+
+// The SetRevisit makes crawled requests requeued to Scheduler again after intervals of the Revisit.
+// Requests waiting to be revisited keep Run running until Stop is called, and they are pushed to Scheduler
+// when Run is stopped, so they are saved by SetPendingRequestFile or EnableCheckpoint and crawled when
+// Run starts again.
+func (this *Spider) SetRevisit(r *Revisit) *Spider {
+    this.revisit = r
+    return this
+}
+
+// The revisitLater requeues the request after its revisit interval.
+func (this *Spider) revisitLater(req *request.Request, p *page.Page) {
+    if this.revisit == nil || this.isStopped() {
+        return
+    }
+    if delay, ok := this.revisit.next(p); ok {
+        req.SetRetries(0)
+        this.requeueAfter(req, delay)
+    }
+}
@@ -0,0 +1,60 @@
+package spider
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "testing"
+    "time"
+)
+
+func TestRevisitAdaptive(t *testing.T) {
+    r := NewRevisit(0).AddPattern(`/list`, 4*time.Minute).SetAdaptive(time.Minute, 8*time.Minute)
+    crawl := func(url, body string) (time.Duration, bool) {
+        p := page.NewPage(request.NewRequest(url, "text"))
+        p.SetBodyStr(body)
+        return r.next(p)
+    }
+    if _, ok := crawl("http://a.com/item/1", "a"); ok {
+        t.Error("url without interval should not be revisited")
+    }
+    for i, c := range []struct {
+        body     string
+        interval time.Duration
+    }{
+        {"a", 4 * time.Minute},
+        {"a", 8 * time.Minute},
+        {"a", 8 * time.Minute},
+        {"b", 4 * time.Minute},
+        {"c", 2 * time.Minute},
+        {"d", time.Minute},
+        {"e", time.Minute},
+    } {
+        if d, ok := crawl("http://a.com/list", c.body); !ok || d != c.interval {
+            t.Errorf("interval %d should be %v: %v", i, c.interval, d)
+        }
+    }
+}
+
+func TestRevisitChangefreq(t *testing.T) {
+    r := NewRevisit(time.Minute).AddPattern(`/list`, 2*time.Minute).SetChangefreq(true)
+    for _, c := range []struct {
+        url        string
+        changefreq string
+        interval   time.Duration
+        ok         bool
+    }{
+        {"http://a.com/item/1", "", time.Minute, true},
+        {"http://a.com/item/2", "daily", 24 * time.Hour, true},
+        {"http://a.com/item/3", "Weekly", 7 * 24 * time.Hour, true},
+        {"http://a.com/item/4", "never", 0, false},
+        {"http://a.com/list", "daily", 2 * time.Minute, true},
+    } {
+        req := request.NewRequest(c.url, "text")
+        if c.changefreq != "" {
+            req.SetMeta("changefreq", c.changefreq)
+        }
+        if d, ok := r.next(page.NewPage(req)); ok != c.ok || d != c.interval {
+            t.Errorf("interval of %s should be %v: %v %v", c.url, c.interval, d, ok)
+        }
+    }
+}
@@ -0,0 +1,165 @@
+package spider
+
+import (
+    "encoding/json"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "hash/fnv"
+    "os"
+    "path/filepath"
+    "strconv"
+    "sync"
+)
+
+// The ShardSink receives requests of other shards of Spider.SetShard, like a file or a message queue read
+// by the spider instance of the shard. It is called by workers at once, so it should be safe for concurrent use.
+type ShardSink interface {
+    Forward(shard int, req *request.Request) error
+}
+
+// The ShardSinkFunc uses a function as ShardSink, like one publishing the request to a topic of the shard.
+type ShardSinkFunc func(shard int, req *request.Request) error
+
+func (this ShardSinkFunc) Forward(shard int, req *request.Request) error {
+    return this(shard, req)
+}
+
+// The crawlShard is shard of the spider instance set by SetShard.
+type crawlShard struct {
+    index int
+    count int
+    sink  ShardSink
+    key   func(*request.Request) string
+}
+
+// ShardOf returns shard of the key in count shards by its fnv hash, which is the same in every instance.
+func ShardOf(key string, count int) int {
+    h := fnv.New32a()
+    h.Write([]byte(key))
+    return int(h.Sum32() % uint32(count))
+}
+
+// ShardByHost returns host of the request as shard key of SetShardKey, so all the requests of a host are
+// crawled by one instance, which keeps its rate limits and robots.txt.
+func ShardByHost(req *request.Request) string {
+    return requestHost(req)
+}
+
+// The SetShard splits a crawl across count spider instances without shared state: the instance of shard
+// index, from 0 to count-1, only crawls requests whose key hashes to its shard by ShardOf, and passes the
+// other requests to the sink, which delivers them to instances of their shards, like ShardFileSink.
+// Every instance can be given all the seeds. The key is request fingerprint of scheduler.DefaultFingerprint
+// by default, see SetShardKey. Requests forwarded are counted as dropped of reason "shard" in Stats; a nil
+// sink drops them. The count 0 or 1 disables sharding.
+func (this *Spider) SetShard(index, count int, sink ShardSink) *Spider {
+    if count <= 1 {
+        this.shard = nil
+        return this
+    }
+    if index < 0 || index >= count {
+        logger.Error("shard index is out of range", mlog.F("index", index), mlog.F("count", count))
+        return this
+    }
+    key := scheduler.DefaultFingerprint
+    if this.shard != nil {
+        key = this.shard.key
+    }
+    this.shard = &crawlShard{index: index, count: count, sink: sink, key: key}
+    return this
+}
+
+// The SetShardKey sets the function returning shard key of a request, like ShardByHost. It should be called
+// after SetShard, and all the instances should use the same function.
+func (this *Spider) SetShardKey(key func(*request.Request) string) *Spider {
+    if this.shard != nil && key != nil {
+        this.shard.key = key
+    }
+    return this
+}
+
+// The GetShard returns shard index and count of SetShard, or 0 and 1 if the crawl is not sharded.
+func (this *Spider) GetShard() (int, int) {
+    if this.shard == nil {
+        return 0, 1
+    }
+    return this.shard.index, this.shard.count
+}
+
+// The forwardShard passes the request to the sink if it is of other shard, and returns whether it is.
+func (this *Spider) forwardShard(req *request.Request) bool {
+    if this.shard == nil {
+        return false
+    }
+    n := ShardOf(this.shard.key(req), this.shard.count)
+    if n == this.shard.index {
+        return false
+    }
+    if this.shard.sink != nil {
+        if err := this.shard.sink.Forward(n, req); err != nil {
+            logger.Error("request is not forwarded to its shard", mlog.F("url", req.GetUrl()), mlog.F("shard", n),
+                mlog.F("error", err))
+        }
+    }
+    return true
+}
+
+// The ShardFileSink appends requests of each shard to file "shard-<n>.jsonl" in a directory, one json line
+// for each request, which LoadShardFile reads for the instance of the shard, like seeds of its next run.
+type ShardFileSink struct {
+    locker sync.Mutex
+    dir    string
+    files  map[int]*os.File
+}
+
+// NewShardFileSink returns ShardFileSink of files in the directory, which is created if it does not exist.
+func NewShardFileSink(dir string) (*ShardFileSink, error) {
+    if err := os.MkdirAll(dir, 0755); err != nil {
+        return nil, err
+    }
+    return &ShardFileSink{dir: dir, files: make(map[int]*os.File)}, nil
+}
+
+// The shardFilePath returns path of file of the shard in the directory.
+func shardFilePath(dir string, shard int) string {
+    return filepath.Join(dir, "shard-"+strconv.Itoa(shard)+".jsonl")
+}
+
+// Forward appends the request to file of the shard.
+func (this *ShardFileSink) Forward(shard int, req *request.Request) error {
+    line, err := json.Marshal(req)
+    if err != nil {
+        return err
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    f, ok := this.files[shard]
+    if !ok {
+        if f, err = os.OpenFile(shardFilePath(this.dir, shard), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666); err != nil {
+            return err
+        }
+        this.files[shard] = f
+    }
+    _, err = f.Write(append(line, '\n'))
+    return err
+}
+
+// Close closes the files.
+func (this *ShardFileSink) Close() error {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    var err error
+    for shard, f := range this.files {
+        if cerr := f.Close(); cerr != nil && err == nil {
+            err = cerr
+        }
+        delete(this.files, shard)
+    }
+    return err
+}
+
+// LoadShardFile reads requests of the shard written by ShardFileSink in the directory. It returns no request
+// if the file does not exist. Broken lines are skipped.
+func LoadShardFile(dir string, shard int) ([]*request.Request, error) {
+    return loadPendingRequests(shardFilePath(dir, shard))
+}
@@ -0,0 +1,85 @@
+package spider
+
+import (
+    "context"
+    "os"
+    "os/signal"
+    "syscall"
+)
+
+// The Shutdown stops Spider like Stop, and waits until Run returns, after requests being crawled are done
+// and pipelines are flushed. If ctx is done first, Shutdown returns its error while Run is still draining.
+// It returns nil at once if Run is not running.
+func (this *Spider) Shutdown(ctx context.Context) error {
+    this.runLocker.Lock()
+    done := this.runDone
+    this.runLocker.Unlock()
+    if done == nil {
+        return nil
+    }
+    this.Stop()
+    select {
+    case <-done:
+        return nil
+    case <-ctx.Done():
+        return ctx.Err()
+    }
+}
+
+// The StopOnSignal stops Spider gracefully when one of the signals is received, so that buffered results of
+// pipelines are not lost. A second signal exits the process at once. Default signals are SIGINT and SIGTERM.
+// It returns function that stops handling the signals.
+func (this *Spider) StopOnSignal(signals ...os.Signal) func() {
+    if len(signals) == 0 {
+        signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+    }
+    c := make(chan os.Signal, 1)
+    signal.Notify(c, signals...)
+    quit := make(chan struct{})
+    go func() {
+        select {
+        case sig := <-c:
+            logger.Info("signal " + sig.String() + " received, spider is stopping")
+            this.Stop()
+        case <-quit:
+            return
+        }
+        select {
+        case sig := <-c:
+            logger.Warn("signal " + sig.String() + " received again, exit now")
+            os.Exit(1)
+        case <-quit:
+        }
+    }()
+    return func() {
+        signal.Stop(c)
+        close(quit)
+    }
+}
+
+// The PauseOnSignal pauses Spider when the pause signal is received, and resumes it when the resume signal
+// is received, like SIGUSR1 and SIGUSR2 sent by "kill -USR1 <pid>". It returns function that stops handling
+// the signals.
+func (this *Spider) PauseOnSignal(pause os.Signal, resume os.Signal) func() {
+    c := make(chan os.Signal, 1)
+    signal.Notify(c, pause, resume)
+    quit := make(chan struct{})
+    go func() {
+        for {
+            select {
+            case sig := <-c:
+                if sig == pause {
+                    this.Pause()
+                } else {
+                    this.Resume()
+                }
+            case <-quit:
+                return
+            }
+        }
+    }()
+    return func() {
+        signal.Stop(c)
+        close(quit)
+    }
+}
@@ -0,0 +1,143 @@
+package spider
+
+import (
+    "bufio"
+    "compress/gzip"
+    "encoding/xml"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/request"
+    "io"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+)
+
+// The SitemapMaxDepth limits how deep sitemap index files are followed.
+const SitemapMaxDepth = 3
+
+// The sitemapXml represents both urlset and sitemapindex documents.
+type sitemapXml struct {
+    Urls     []sitemapEntry `xml:"url"`
+    Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+    Loc        string `xml:"loc"`
+    Lastmod    string `xml:"lastmod"`
+    Changefreq string `xml:"changefreq"`
+    Priority   string `xml:"priority"`
+}
+
+// LoadSitemap downloads sitemap of the url and returns Requests of urls in it.
+// Sitemap index files are followed no deeper than SitemapMaxDepth, and gzipped sitemaps are supported.
+// The "lastmod", "changefreq" and "priority" of url are saved in request meta if they are set, and
+// the priority from 0.0 to 1.0 is set as Request priority from 0 to 10 for PriorityScheduler.
+// The respType is responce type of the returned Requests. Sitemaps are downloaded by http.DefaultClient.
+func LoadSitemap(url string, respType string) ([]*request.Request, error) {
+    return LoadSitemapWithClient(http.DefaultClient, url, respType)
+}
+
+// LoadSitemapWithClient is LoadSitemap that downloads sitemaps by the client, like client of
+// HttpDownloader.GetClient with its proxy, resolver and cookies.
+func LoadSitemapWithClient(client *http.Client, url string, respType string) ([]*request.Request, error) {
+    return loadSitemap(client, url, respType, 0)
+}
+
+func loadSitemap(client *http.Client, url string, respType string, depth int) ([]*request.Request, error) {
+    if depth > SitemapMaxDepth {
+        return nil, errors.New("sitemap index is too deep : " + url)
+    }
+
+    doc, err := fetchSitemap(client, url)
+    if err != nil {
+        return nil, err
+    }
+
+    var reqs []*request.Request
+    for _, entry := range doc.Urls {
+        loc := strings.TrimSpace(entry.Loc)
+        if loc == "" {
+            continue
+        }
+        req := request.NewRequest(loc, respType)
+        if entry.Lastmod != "" {
+            req.SetMeta("lastmod", strings.TrimSpace(entry.Lastmod))
+        }
+        if entry.Changefreq != "" {
+            req.SetMeta("changefreq", strings.TrimSpace(entry.Changefreq))
+        }
+        if entry.Priority != "" {
+            priority := strings.TrimSpace(entry.Priority)
+            req.SetMeta("priority", priority)
+            if f, err := strconv.ParseFloat(priority, 64); err == nil {
+                req.SetPriority(int(math.Floor(f*10 + 0.5)))
+            }
+        }
+        reqs = append(reqs, req)
+    }
+
+    // nested sitemaps of sitemap index; a broken one does not stop others
+    for _, entry := range doc.Sitemaps {
+        loc := strings.TrimSpace(entry.Loc)
+        if loc == "" {
+            continue
+        }
+        nested, err := loadSitemap(client, loc, respType, depth+1)
+        if err != nil {
+            logger.Error(err.Error())
+            continue
+        }
+        reqs = append(reqs, nested...)
+    }
+    return reqs, nil
+}
+
+// The AddSitemap adds requests of urls in sitemap of the url to Scheduler, which are seeds of the crawl.
+// See LoadSitemap for sitemap index, gzipped sitemap and meta of the requests. Sitemaps are downloaded by
+// http client of HttpDownloader of Spider, so they are sent by its proxy and resolver like pages.
+func (this *Spider) AddSitemap(url string, respType string) *Spider {
+    client := http.DefaultClient
+    if d, ok := this.findHttpDownloader(); ok {
+        c, err := d.GetClient()
+        if err != nil {
+            logger.Error(err.Error())
+            return this
+        }
+        client = c
+    }
+    reqs, err := LoadSitemapWithClient(client, url, respType)
+    if err != nil {
+        logger.Error(err.Error())
+    }
+    return this.AddRequests(reqs)
+}
+
+// The fetchSitemap downloads and parses one sitemap file.
+func fetchSitemap(client *http.Client, url string) (*sitemapXml, error) {
+    resp, err := client.Get(url)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, errors.New("sitemap download failed : " + url + " : status " + strconv.Itoa(resp.StatusCode))
+    }
+
+    // gzipped sitemap is detected by its magic number, whatever the url or Content-Type is
+    var body io.Reader = bufio.NewReader(resp.Body)
+    if magic, err := body.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+        gz, err := gzip.NewReader(body)
+        if err != nil {
+            return nil, err
+        }
+        defer gz.Close()
+        body = gz
+    }
+
+    doc := &sitemapXml{}
+    if err = xml.NewDecoder(body).Decode(doc); err != nil {
+        return nil, errors.New("sitemap parse failed : " + url + " : " + err.Error())
+    }
+    return doc, nil
+}
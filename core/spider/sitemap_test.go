@@ -0,0 +1,80 @@
+package spider_test
+
+import (
+    "bytes"
+    "compress/gzip"
+    "github.com/hu17889/go_spider/core/downloader"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "github.com/hu17889/go_spider/core/spider"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestLoadSitemap(t *testing.T) {
+    var ts *httptest.Server
+    ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/sitemap.xml":
+            w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>` + ts.URL + `/a.xml</loc></sitemap>
+  <sitemap><loc>` + ts.URL + `/b.xml.gz</loc></sitemap>
+</sitemapindex>`))
+        case "/a.xml":
+            w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/1</loc><lastmod>2014-09-23</lastmod></url>
+  <url><loc>http://example.com/2</loc></url>
+</urlset>`))
+        case "/b.xml.gz":
+            var buf bytes.Buffer
+            gz := gzip.NewWriter(&buf)
+            gz.Write([]byte(`<urlset><url><loc>http://example.com/3</loc><priority>0.8</priority></url></urlset>`))
+            gz.Close()
+            w.Write(buf.Bytes())
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+    defer ts.Close()
+
+    reqs, err := spider.LoadSitemap(ts.URL+"/sitemap.xml", "html")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(reqs) != 3 {
+        t.Fatalf("requests count error: %d", len(reqs))
+    }
+    if lastmod, ok := reqs[0].GetMeta("lastmod"); !ok || lastmod != "2014-09-23" {
+        t.Error("lastmod meta error")
+    }
+    if reqs[2].GetUrl() != "http://example.com/3" {
+        t.Error("gzipped sitemap error")
+    }
+    if priority, _ := reqs[2].GetMeta("priority"); priority != "0.8" || reqs[2].GetPriority() != 8 {
+        t.Error("priority meta error")
+    }
+
+    // urls of larger priority are crawled first
+    s := scheduler.NewPriorityScheduler(false)
+    spider.NewSpider(&testPageProcesser{}, "sitemap").CloseStrace().SetScheduler(s).AddSitemap(ts.URL+"/sitemap.xml", "html")
+    if s.Count() != 3 || s.Peek().GetUrl() != "http://example.com/3" {
+        t.Error("sitemap seeds error")
+    }
+
+    if _, err = spider.LoadSitemap(ts.URL+"/none.xml", "html"); err == nil {
+        t.Error("missing sitemap should fail")
+    }
+
+    // sitemaps of AddSitemap are downloaded by the downloader, whose resolver knows the host
+    _, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+    s = scheduler.NewPriorityScheduler(false)
+    spider.NewSpider(&testPageProcesser{}, "sitemap").CloseStrace().SetScheduler(s).
+        SetResolver(downloader.NewDNSCache(nil, time.Minute).AddHost("sitemap.example", "127.0.0.1")).
+        AddSitemap("http://sitemap.example:"+port+"/a.xml", "html")
+    if s.Count() != 2 {
+        t.Errorf("sitemap should be downloaded by the downloader: %d", s.Count())
+    }
+}
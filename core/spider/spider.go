@@ -2,20 +2,35 @@
 package spider
 
 import (
+    "context"
+    "errors"
     "github.com/hu17889/go_spider/core/common/mlog"
     "github.com/hu17889/go_spider/core/common/page"
     "github.com/hu17889/go_spider/core/common/page_items"
     "github.com/hu17889/go_spider/core/common/request"
     "github.com/hu17889/go_spider/core/common/resource_manage"
+    "github.com/hu17889/go_spider/core/common/trace"
+    "github.com/hu17889/go_spider/core/common/util"
     "github.com/hu17889/go_spider/core/downloader"
     "github.com/hu17889/go_spider/core/page_processer"
     "github.com/hu17889/go_spider/core/pipeline"
     "github.com/hu17889/go_spider/core/scheduler"
+    "io"
     "math/rand"
+    "net/http"
+    "strconv"
+    "sync"
+    "sync/atomic"
     "time"
     //"fmt"
 )
 
+// The logger writes logs of this package, whose level is set by mlog.SetLevel("spider", level).
+var logger = mlog.Component("spider")
+
+// The waitInterval is the longest time Run waits for new requests before polling Scheduler again.
+const waitInterval = 100 * time.Millisecond
+
 type Spider struct {
     taskname string
 
@@ -23,16 +38,213 @@ type Spider struct {
 
     pDownloader downloader.Downloader
 
+    // The pCache is checked before download if it is set. Requests not found in it are dropped if offline.
+    pCache  downloader.Cache
+    offline bool
+
     pScheduler scheduler.Scheduler
 
+    // The fingerprint is set to Scheduler for removing duplicate requests.
+    fingerprint func(*request.Request) string
+
     pPiplelines []pipeline.Pipeline
+    // The pipelineOptions are options of pPiplelines of the same index, nil for pipelines of AddPipeline.
+    pipelineOptions []*pipelineOption
+
+    // The requestMiddlewares and responseMiddlewares are called around each download in registration order.
+    requestMiddlewares  []func(*request.Request) *request.Request
+    responseMiddlewares []func(*page.Page) *page.Page
+    // The middlewareRequests maps requests returned by requestMiddlewares to the requests polled, which are
+    // requeued instead, so middlewares are applied to each attempt once. It is guarded by dispatchLocker.
+    middlewareRequests map[*request.Request]*request.Request
+
+    mc resource_manage.ResourceManage
+
+    // The notify wakes up Run when requests are added or workers are free.
+    notify chan struct{}
+
+    // The dashboardToken is the token requests to Dashboard need, or "" if they need none.
+    dashboardToken string
+
+    // The stopped is set to 1 by Stop, and Run returns without crawling requests left in Scheduler.
+    stopped int32
+
+    // The autoReferer is whether url of page is set as referer of its target requests.
+    autoReferer bool
+
+    // The inheritMeta is whether target requests of a page inherit meta of its request, only the
+    // inheritMetaKeys if they are set.
+    inheritMeta     bool
+    inheritMetaKeys []string
+
+    // The headers are set to requests that do not have them, like headers of Config.
+    headers map[string]string
+
+    // The runDone is closed when Run returns, for Shutdown waiting for it.
+    runLocker sync.Mutex
+    runDone   chan struct{}
+
+    // The rulesLocker guards crawl rules that can be changed while Run is running, like by Config.Reload:
+    // pPageProcesser, maxDepth, urlFilter, retryTimes, retryStatusCodes and headers.
+    rulesLocker sync.RWMutex
+
+    // The paused is set to 1 by Pause, and Run does not dispatch requests until Resume is called.
+    paused int32
 
-    mc  resource_manage.ResourceManage
+    // The Run is paused for autoPauseDuration after autoPauseThreshold consecutive 429 or 503 responces.
+    autoPauseThreshold int32
+    autoPauseDuration  time.Duration
+    blockedCount       int32
+
+    // The inflight saves requests polled from Scheduler and not done yet.
+    // The dispatchLocker makes polling and taking checkpoint exclusive, so no request is missed in checkpoint.
+    dispatchLocker sync.Mutex
+    inflight       map[*request.Request]bool
+
+    // The checkpointPath saves checkpoint every checkpointInterval while Run is running.
+    checkpointPath     string
+    checkpointInterval time.Duration
+
+    // The skipNotModified is whether pages of 304 Not Modified responce are not processed.
+    skipNotModified bool
+
+    // The maxDepth drops requests deeper than it; 0 means no limit.
+    maxDepth int
+
+    // The contentDeduplicator skips pages of content seen before.
+    contentDeduplicator ContentDeduplicator
+
+    // The urlFilter drops requests it does not allow before they are pushed to Scheduler.
+    urlFilter *scheduler.UrlFilter
+
+    // The authenticator logs in before crawl and again when the session is expired, and authGeneration
+    // counts its logins.
+    authenticator  Authenticator
+    authLocker     sync.Mutex
+    authGeneration int64
+
+    // The pendingRequestFile saves requests left in Scheduler when Spider is stopped.
+    pendingRequestFile string
 
     threadnum uint
 
+    // The synchronous is whether requests are crawled one by one in the goroutine of Run.
+    synchronous bool
+
     exitWhenComplete bool
 
+    // The retryBackoffBase and retryBackoffMax are backoff of failed requests requeued to Scheduler,
+    // and delayed saves requests waiting to be requeued with their timers.
+    retryBackoffBase time.Duration
+    retryBackoffMax  time.Duration
+    delayed          map[*request.Request]*time.Timer
+
+    // The revisit requeues crawled requests again after their intervals.
+    revisit *Revisit
+
+    // The hostSlots limits requests of each host crawled at once, and hostWaiting saves requests of busy hosts
+    // put aside by Run, hostWaitingCount of them.
+    hostSlots        *resource_manage.ResourceManageHost
+    hostWaiting      map[string][]*request.Request
+    hostWaitingCount int
+
+    // The hostPartition is whether requests are crawled by shard workers of their hosts, which are shards
+    // while Run is running. Requests of busy shards are put aside in hostWaiting by shard.
+    hostPartition bool
+    shards        *hostShards
+
+    // The stats collects Stats of the run, and lastStats is of the last run after Run returns.
+    // The statsReportPath is the file Stats are written to when Run returns.
+    stats           *statsCollector
+    lastStats       *statsCollector
+    statsReportPath string
+
+    // The hostBackoff backs off hosts of 429 and 503 responces.
+    hostBackoff *hostBackoff
+
+    // The circuitBreaker stops downloads of hosts failing again and again.
+    circuitBreaker *circuitBreaker
+
+    // The hostLiveness fails requests of hosts that do not answer its probe.
+    hostLiveness *hostLiveness
+    // The probeCtx is context of probes of hostLiveness while Run is running, canceled by cancelProbes when
+    // Spider is stopped.
+    probeLocker  sync.Mutex
+    probeCtx     context.Context
+    cancelProbes context.CancelFunc
+
+    // The languages are languages of pages processed, or nil if pages of all the languages are.
+    languages map[string]bool
+
+    // The feedWatcher adds requests of new entries of feeds while Run is running.
+    feedWatcher *FeedWatcher
+
+    // The budget limits pages crawled of each domain.
+    budget *Budget
+
+    // The shard keeps requests of this instance of a crawl split by SetShard, and forwards the others.
+    shard *crawlShard
+
+    // The pipelineWorkers run pipelines out of download workers, taking pages from a queue of
+    // pipelineQueueSize. The pipelineQueue is the queue while Run is running; it is set before download
+    // workers start and cleared after they are done, and runLocker guards it for Status.
+    // The pipelineQueueMemory limits bytes of pages in the queue.
+    pipelineWorkers     int
+    pipelineQueueSize   int
+    pipelineQueueMemory int64
+    pipelineQueue       *pipelineQueue
+
+    // The retryTimes is how many times a failed download is retried.
+    // If retryStatusCodes is set, only network errors and these status codes are retried.
+    retryTimes       uint
+    retryStatusCodes map[int]bool
+
+    // The responseValidator rejects downloaded pages like captcha or access denied pages of status 200.
+    responseValidator func(*page.Page) bool
+
+    // The itemValidator checks PageItems before pipelines, and invalid items are passed to invalidItemPipeline.
+    itemValidator       func(*page_items.PageItems) string
+    invalidItemPipeline pipeline.Pipeline
+
+    // The itemDeduplicator skips PageItems of identity emitted before.
+    itemDeduplicator *ItemDeduplicator
+
+    // The incremental skips pages not changed since the last crawl.
+    incremental *Incremental
+
+    // The crawlGraph records links between pages and their target requests.
+    crawlGraph *CrawlGraph
+
+    // The captchaDetect finds captcha pages, which are solved by captchaSolver and downloaded again.
+    captchaDetect func(*page.Page) bool
+    captchaSolver CaptchaSolver
+
+    // The failedRequestHandler is called with request that is still failed after retries.
+    failedRequestHandler func(*request.Request, error)
+
+    // The deadLetterPipeline is passed DeadLetter of failed requests and invalid items, with responce bodies
+    // truncated to deadLetterBodySize.
+    deadLetterPipeline pipeline.Pipeline
+    deadLetterBodySize int
+
+    // The pRandomDelay draws wait time before each download.
+    pRandomDelay *randomDelay
+
+    // The metrics counts downloads, errors and pipeline work.
+    metrics *Metrics
+
+    // The prefetch is whether host of the next request is prefetched when a request is polled.
+    prefetch bool
+
+    // The tracer records spans of requests crawled.
+    tracer *trace.Tracer
+
+    // The pRateLimit limits requests per second of all the downloads and of each host.
+    pRateLimit *rateLimit
+
+    // The autoThrottle adapts delay of each host to its latency and ban signals.
+    autoThrottle *AutoThrottle
+
     // Sleeptype can be fixed or rand.
     startSleeptime uint
     endSleeptime   uint
@@ -41,6 +253,8 @@ type Spider struct {
 
 // Spider is scheduler module for all the other modules, like downloader, pipeline, scheduler and etc.
 // The taskname could be empty string too, or it can be used in Pipeline for record the result crawled by which task;
+// The default HttpDownloader obeys robots.txt by downloader.NewRobots("go_spider"), so unlike spiders of
+// earlier versions, urls disallowed by robots.txt are not crawled; SetObeyRobots(false) crawls them again.
 func NewSpider(pageinst page_processer.PageProcesser, taskname string) *Spider {
     mlog.StraceInst().Open()
 
@@ -49,8 +263,17 @@ func NewSpider(pageinst page_processer.PageProcesser, taskname string) *Spider {
     // init filelog.
     ap.CloseFileLog()
     ap.exitWhenComplete = true
+    ap.retryTimes = 1
+    ap.notify = make(chan struct{}, 1)
     ap.sleeptype = "fixed"
     ap.startSleeptime = 0
+    ap.pRandomDelay = newRandomDelay(0, 0, time.Now().UnixNano())
+    ap.pRateLimit = newRateLimit()
+    ap.inflight = make(map[*request.Request]bool)
+    ap.delayed = make(map[*request.Request]*time.Timer)
+    ap.middlewareRequests = make(map[*request.Request]*request.Request)
+    ap.metrics = newMetrics(func() int { return ap.queueDepth() })
+    ap.stats = newStatsCollector(taskname)
 
     // init spider
     if ap.pScheduler == nil {
@@ -58,7 +281,8 @@ func NewSpider(pageinst page_processer.PageProcesser, taskname string) *Spider {
     }
 
     if ap.pDownloader == nil {
-        ap.SetDownloader(downloader.NewHttpDownloader())
+        d := downloader.NewHttpDownloader().SetRobots(downloader.NewRobots("go_spider")).SetCookieJar(downloader.NewCookieJar())
+        ap.SetDownloader(d)
     }
 
     mlog.StraceInst().Println("** start spider **")
@@ -71,6 +295,21 @@ func (this *Spider) Taskname() string {
     return this.taskname
 }
 
+// The SetPageProcesser replaces PageProcesser of Spider. It can be called while Run is running, like for new
+// extraction rules, and pages being processed are finished by the old one.
+func (this *Spider) SetPageProcesser(p page_processer.PageProcesser) *Spider {
+    this.rulesLocker.Lock()
+    this.pPageProcesser = p
+    this.rulesLocker.Unlock()
+    return this
+}
+
+func (this *Spider) GetPageProcesser() page_processer.PageProcesser {
+    this.rulesLocker.RLock()
+    defer this.rulesLocker.RUnlock()
+    return this.pPageProcesser
+}
+
 // Deal with one url and return the PageItems
 func (this *Spider) Get(url string, respType string) *page_items.PageItems {
     var urls []string
@@ -98,53 +337,416 @@ func (this *Spider) GetAll(urls []string, respType string) []*page_items.PageIte
     return pip.GetCollected()
 }
 
+// The Run dispatches requests in Scheduler to at most threadnum workers at once.
+// When the Scheduler is empty, Run waits for new requests instead of polling Scheduler all the time.
+// If exitWhenComplete is true, Run returns when the Scheduler is empty and all workers are idle.
 func (this *Spider) Run() {
-    if this.threadnum == 0 {
+    this.RunContext(context.Background())
+}
+
+// The RunContext is Run that is stopped when ctx is done. Downloads and pipelines being processed
+// are canceled with ctx, and canceled requests are neither retried nor passed to failed request handler.
+// Contexts of requests set by Request.SetContext are kept, and their values are visible by Page.Context.
+func (this *Spider) RunContext(ctx context.Context) {
+    stopRun := context.AfterFunc(ctx, this.Stop)
+    defer stopRun()
+
+    done := make(chan struct{})
+    this.runLocker.Lock()
+    if this.threadnum == 0 || this.synchronous {
         this.threadnum = 1
     }
-    this.mc = resource_manage.NewResourceManageChan(this.threadnum)
+    this.mc = resource_manage.NewResourceManageCond(this.threadnum)
+    if this.hostPartition && !this.synchronous {
+        this.shards = newHostShards(this.threadnum)
+    }
+    this.runDone = done
+    this.runLocker.Unlock()
+    defer func() {
+        this.runLocker.Lock()
+        this.runDone = nil
+        this.runLocker.Unlock()
+        close(done)
+    }()
+    this.stats.begin()
+    this.loadPendingRequests()
+    this.resumeCheckpoint()
+    if this.itemDeduplicator != nil {
+        this.itemDeduplicator.load()
+    }
+    stopCheckpoint := this.startCheckpoint()
+    stopProbes := this.startProbes(ctx)
+    defer stopProbes()
+    stopFeeds := this.startFeedWatcher(ctx)
+    stopPipelines := this.startPipelineQueue(ctx)
 
-    for {
-        req := this.pScheduler.Poll()
+    var workers sync.WaitGroup
 
-        // mc is not atomic
-        if this.mc.Has() == 0 && req == nil && this.exitWhenComplete {
-            mlog.StraceInst().Println("** end spider **")
-            break
-        } else if req == nil {
-            //mlog.StraceInst().Println("scheduler is empty")
+    if this.authenticator != nil {
+        if err := this.login(this.authGenerationNow()); err != nil {
+            logger.Error("login failed, spider is stopped : " + err.Error())
+            this.Stop()
+        }
+    }
+
+    for !this.isStopped() {
+        if this.IsPaused() {
+            this.waitRequest()
             continue
         }
-        this.mc.GetOne()
+        req, release := this.nextRequest()
+        if req == nil {
+            // Workers push new requests before they are free, so the Scheduler is polled again
+            // after all workers are found free.
+            if this.mc.Has() == 0 && this.exitWhenComplete && !this.hasDelayed() && this.feedWatcher == nil &&
+                !this.hasScheduled() {
+                if req, release = this.nextRequest(); req == nil {
+                    mlog.StraceInst().Println("** end spider **")
+                    break
+                }
+            } else {
+                this.waitRequest()
+                continue
+            }
+        }
 
-        // Asynchronous fetching
-        go func(*request.Request) {
-            defer this.mc.FreeOne()
-            //time.Sleep( time.Duration(rand.Intn(5)) * time.Second)
+        // blocked while all workers are busy
+        this.mc.GetOne()
+        for this.IsPaused() && !this.isStopped() {
+            this.waitRequest()
+        }
+        if this.isStopped() {
+            // the request is left in Scheduler
+            this.mc.FreeOne()
+            release()
+            this.unpoll(req)
+            break
+        }
+        workers.Add(1)
+        task := func() {
+            defer workers.Done()
             mlog.StraceInst().Println("start crawl : " + req.GetUrl())
-            this.pageProcess(req)
-        }(req)
+            this.pageProcess(ctx, req)
+            this.done(req)
+            release()
+            this.mc.FreeOne()
+            this.wakeup()
+        }
+        if this.synchronous {
+            task()
+        } else if this.shards != nil {
+            this.shards.run(req, task)
+        } else {
+            go task()
+        }
+    }
+    this.unpollWaiting()
+    stopFeeds()
+    workers.Wait()
+    if this.shards != nil {
+        this.shards.stop()
+        this.shards = nil
+    }
+    stopPipelines()
+    this.flushDelayed()
+    complete := !this.isStopped() && this.pScheduler.Count() == 0
+    stopCheckpoint(complete)
+    if this.isStopped() {
+        mlog.StraceInst().Println("** stop spider **")
+    }
+    this.savePendingRequests()
+    this.flushPipelines()
+    if err := this.tracer.Flush(); err != nil {
+        logger.Error("spans are not exported : " + err.Error())
+    }
+    if this.itemDeduplicator != nil {
+        this.itemDeduplicator.save()
     }
+    this.reportStats(complete)
     this.close()
 }
 
+// The Stop makes Run return after requests being crawled are done and pipelines are flushed.
+// Requests left in Scheduler are not crawled, and they are saved if SetPendingRequestFile is set.
+// Shutdown waits for Run to return.
+func (this *Spider) Stop() {
+    atomic.StoreInt32(&this.stopped, 1)
+    this.stopProbes()
+    this.wakeup()
+}
+
+// The requestContext returns context of the request that is also canceled when runCtx is done.
+func requestContext(runCtx context.Context, req *request.Request) (context.Context, func()) {
+    ctx, cancel := context.WithCancelCause(req.Context())
+    stop := context.AfterFunc(runCtx, func() {
+        cancel(context.Cause(runCtx))
+    })
+    return ctx, func() {
+        stop()
+        cancel(nil)
+    }
+}
+
+func (this *Spider) isStopped() bool {
+    return atomic.LoadInt32(&this.stopped) == 1
+}
+
+// The Pause makes Run stop dispatching requests to workers until Resume is called.
+// Requests being crawled are done normally, and requests in Scheduler are kept.
+func (this *Spider) Pause() {
+    if atomic.CompareAndSwapInt32(&this.paused, 0, 1) {
+        mlog.StraceInst().Println("** pause spider **")
+    }
+}
+
+// The Resume makes paused Run dispatch requests again.
+func (this *Spider) Resume() {
+    if atomic.CompareAndSwapInt32(&this.paused, 1, 0) {
+        mlog.StraceInst().Println("** resume spider **")
+    }
+    this.wakeup()
+}
+
+func (this *Spider) IsPaused() bool {
+    return atomic.LoadInt32(&this.paused) == 1
+}
+
+// The SetAutoPause pauses Spider after threshold consecutive 429 or 503 responces, and resumes it after
+// the duration. If duration is 0, Spider is paused until Resume is called. The threshold 0 disables it.
+func (this *Spider) SetAutoPause(threshold int, duration time.Duration) *Spider {
+    this.autoPauseThreshold = int32(threshold)
+    this.autoPauseDuration = duration
+    return this
+}
+
+// The checkAutoPause counts consecutive 429 and 503 responces and pauses Spider if threshold is reached.
+func (this *Spider) checkAutoPause(p *page.Page) {
+    if this.autoPauseThreshold <= 0 {
+        return
+    }
+    code := p.GetStatusCode()
+    if code != http.StatusTooManyRequests && code != http.StatusServiceUnavailable {
+        atomic.StoreInt32(&this.blockedCount, 0)
+        return
+    }
+    if atomic.AddInt32(&this.blockedCount, 1) < this.autoPauseThreshold {
+        return
+    }
+    atomic.StoreInt32(&this.blockedCount, 0)
+    logger.Warn("too many blocked responces, spider is paused", mlog.F("url", p.GetRequest().GetUrl()))
+    this.Pause()
+    if this.autoPauseDuration > 0 {
+        time.AfterFunc(this.autoPauseDuration, this.Resume)
+    }
+}
+
+// The SetPendingRequestFile sets file where requests left in Scheduler are saved when Run returns.
+// Requests in the file are added to Scheduler again when Run starts, so a stopped crawl can be continued.
+// The Scheduler needs to be a scheduler.InspectableScheduler, or requests left are not saved.
+func (this *Spider) SetPendingRequestFile(path string) *Spider {
+    this.pendingRequestFile = path
+    return this
+}
+
+// The loadPendingRequests adds requests saved in pendingRequestFile to Scheduler.
+func (this *Spider) loadPendingRequests() {
+    if this.pendingRequestFile == "" {
+        return
+    }
+    reqs, err := loadPendingRequests(this.pendingRequestFile)
+    if err != nil {
+        logger.Error(err.Error())
+        return
+    }
+    this.AddRequests(reqs)
+}
+
+// The savePendingRequests drains Scheduler and saves the requests in pendingRequestFile.
+func (this *Spider) savePendingRequests() {
+    if this.pendingRequestFile == "" {
+        return
+    }
+    s, ok := this.pScheduler.(scheduler.InspectableScheduler)
+    if !ok {
+        logger.Error("scheduler can not be drained, pending requests are not saved")
+        return
+    }
+    if err := savePendingRequests(this.pendingRequestFile, s.Drain()); err != nil {
+        logger.Error(err.Error())
+    }
+}
+
+// The poll polls a request from Scheduler and saves it as in-flight.
+func (this *Spider) poll() *request.Request {
+    this.dispatchLocker.Lock()
+    req := this.pScheduler.Poll()
+    if req != nil {
+        this.inflight[req] = true
+    }
+    this.dispatchLocker.Unlock()
+    if req != nil && this.prefetch {
+        this.prefetchNext()
+    }
+    return req
+}
+
+// The prefetchNext prefetches host of the next request in Scheduler.
+func (this *Spider) prefetchNext() {
+    s, ok := this.pScheduler.(scheduler.InspectableScheduler)
+    if !ok {
+        return
+    }
+    if d, ok := this.findHttpDownloader(); ok {
+        if next := s.Peek(); next != nil {
+            d.Prefetch(next)
+        }
+    }
+}
+
+// The unpoll pushes the request polled but not dispatched back to Scheduler.
+func (this *Spider) unpoll(req *request.Request) {
+    this.dispatchLocker.Lock()
+    defer this.dispatchLocker.Unlock()
+    delete(this.inflight, req)
+    this.requeue(req)
+}
+
+// The done is called after the request is crawled and its target requests are pushed.
+func (this *Spider) done(req *request.Request) {
+    if s, ok := this.pScheduler.(scheduler.DoneScheduler); ok {
+        s.Done(req)
+    }
+    this.dispatchLocker.Lock()
+    delete(this.inflight, req)
+    this.dispatchLocker.Unlock()
+}
+
+// The wakeup tells Run that there may be new requests or free workers.
+func (this *Spider) wakeup() {
+    select {
+    case this.notify <- struct{}{}:
+    default:
+    }
+}
+
+// The queueDepth returns number of requests in Scheduler.
+func (this *Spider) queueDepth() int {
+    this.runLocker.Lock()
+    s := this.pScheduler
+    this.runLocker.Unlock()
+    return s.Count()
+}
+
+// The hasScheduled returns whether requests wait for their fetch time in scheduler.TimedScheduler.
+func (this *Spider) hasScheduled() bool {
+    s, ok := this.pScheduler.(scheduler.TimedScheduler)
+    return ok && !s.NextTime().IsZero()
+}
+
+// The waitRequest blocks until wakeup is called, or waitInterval passes for Scheduler that
+// gets requests from outside of Spider.
+func (this *Spider) waitRequest() {
+    timer := time.NewTimer(waitInterval)
+    defer timer.Stop()
+    select {
+    case <-this.notify:
+    case <-timer.C:
+    }
+}
+
 func (this *Spider) close() {
+    // the Scheduler is swapped under runLocker, as it is read by Status and Metrics while Run is running
+    this.runLocker.Lock()
     this.SetScheduler(scheduler.NewQueueScheduler(false))
-    this.SetDownloader(downloader.NewHttpDownloader())
+    this.runLocker.Unlock()
     this.pPiplelines = make([]pipeline.Pipeline, 0)
+    this.pipelineOptions = nil
     this.exitWhenComplete = true
+    atomic.StoreInt32(&this.stopped, 0)
+}
+
+// The output passes the page to pipelines.
+func (this *Spider) output(ctx context.Context, p *page.Page) {
+    if !this.validItems(ctx, p) || this.duplicateItems(p) {
+        return
+    }
+    start := time.Now()
+    for i, pip := range this.pPiplelines {
+        if this.runPipeline(ctx, pip, this.pipelineOptions[i], p) {
+            this.stats.item(pipelineName(pip))
+        }
+    }
+    this.metrics.pipeline(time.Since(start))
+}
+
+// The flushPipelines writes results buffered by pipelines.
+func (this *Spider) flushPipelines() {
+    pips := append([]pipeline.Pipeline(nil), this.pPiplelines...)
+    for _, pip := range []pipeline.Pipeline{this.invalidItemPipeline, this.deadLetterPipeline} {
+        if pip != nil {
+            pips = append(pips, pip)
+        }
+    }
+    for _, pip := range pips {
+        if f, ok := pip.(pipeline.FlushPipeline); ok {
+            this.safePipeline(pip, "", f.Flush)
+        }
+    }
 }
 
+// The AddPipeline adds pipeline that is passed all the PageItems. Pipelines are called in order they are
+// added, and a pipeline that panics does not stop the others; see AddPipelineWith for filters and
+// concurrency of pipelines.
 func (this *Spider) AddPipeline(p pipeline.Pipeline) *Spider {
     this.pPiplelines = append(this.pPiplelines, p)
+    this.pipelineOptions = append(this.pipelineOptions, nil)
+    return this
+}
+
+// The AddRequestMiddleware adds a function called with each request before it is downloaded.
+// The middleware can modify the request or return a new one; returning nil drops the request.
+// Middlewares are called with a copy of the request polled from Scheduler, so a request retried or requeued
+// later is passed to them as it was polled, like without headers they added.
+func (this *Spider) AddRequestMiddleware(m func(*request.Request) *request.Request) *Spider {
+    this.requestMiddlewares = append(this.requestMiddlewares, m)
+    return this
+}
+
+// The AddResponseMiddleware adds a function called with each downloaded page before PageProcesser.
+// The middleware can modify the page or return a new one; returning nil drops the page.
+func (this *Spider) AddResponseMiddleware(m func(*page.Page) *page.Page) *Spider {
+    this.responseMiddlewares = append(this.responseMiddlewares, m)
     return this
 }
 
 func (this *Spider) SetScheduler(s scheduler.Scheduler) *Spider {
     this.pScheduler = s
+    this.setFingerprint()
+    return this
+}
+
+// The SetRequestFingerprint sets function that returns fingerprint of request, which is used by Scheduler
+// to remove duplicate requests instead of scheduler.DefaultFingerprint.
+// For example, it can ignore tracking params or keep the fragment of url.
+// The Scheduler needs to be a scheduler.FingerprintScheduler.
+func (this *Spider) SetRequestFingerprint(f func(*request.Request) string) *Spider {
+    this.fingerprint = f
+    this.setFingerprint()
     return this
 }
 
+// The setFingerprint sets the fingerprint function to Scheduler.
+func (this *Spider) setFingerprint() {
+    if this.fingerprint == nil {
+        return
+    }
+    if s, ok := this.pScheduler.(scheduler.FingerprintScheduler); ok {
+        s.SetFingerprint(this.fingerprint)
+    } else {
+        logger.Error("scheduler does not support request fingerprint")
+    }
+}
+
 func (this *Spider) GetScheduler() scheduler.Scheduler {
     return this.pScheduler
 }
@@ -158,15 +760,308 @@ func (this *Spider) GetDownloader() downloader.Downloader {
     return this.pDownloader
 }
 
+// The httpDownloader returns the downloader for settings of HttpDownloader.
+// It panics if the downloader has been replaced by other Downloader.
+func (this *Spider) httpDownloader() *downloader.HttpDownloader {
+    d, ok := this.findHttpDownloader()
+    if !ok {
+        panic("the setting needs HttpDownloader")
+    }
+    return d
+}
+
+// The findHttpDownloader returns the downloader if it is HttpDownloader, or HttpDownloader of BrowserDownloader,
+// which can be wrapped by MiddlewareDownloader.
+func (this *Spider) findHttpDownloader() (*downloader.HttpDownloader, bool) {
+    d := this.pDownloader
+    for {
+        switch v := d.(type) {
+        case *downloader.HttpDownloader:
+            return v, true
+        case *downloader.BrowserDownloader:
+            return v.GetHttpDownloader(), true
+        case *downloader.MiddlewareDownloader:
+            d = v.GetDownloader()
+        default:
+            return nil, false
+        }
+    }
+}
+
+// The SetMaxParseDepth limits nesting depth of html and json documents parsed by HttpDownloader.
+// Pages nested deeper are set failed instead of being parsed. The depth 0 means no limit.
+func (this *Spider) SetMaxParseDepth(depth int) *Spider {
+    this.httpDownloader().SetMaxParseDepth(depth)
+    return this
+}
+
+// The SetMaxBodySize limits bytes of responce bodies read by HttpDownloader, so a link to a huge file does not
+// exhaust memory. Body over the limit is truncated if truncate is true, or else the page is set failed without
+// reading the rest; Page.GetBodyOutcome tells which. The n 0 means no limit.
+func (this *Spider) SetMaxBodySize(n int64, truncate bool) *Spider {
+    this.httpDownloader().SetMaxBodySize(n).SetTruncateBody(truncate)
+    return this
+}
+
+// The SetContentTypes sets media types of pages downloaded by HttpDownloader, like "text/html" and
+// "application/json". Responces of other Content-Type are set failed before their bodies are read.
+func (this *Spider) SetContentTypes(types ...string) *Spider {
+    this.httpDownloader().SetContentTypes(types...)
+    return this
+}
+
+// The SetProxyPool sets ProxyPool of HttpDownloader that rotates proxies. Proxy of a page rejected by
+// responce validator is banned.
+func (this *Spider) SetProxyPool(pool *downloader.ProxyPool) *Spider {
+    this.httpDownloader().SetProxyPool(pool)
+    return this
+}
+
+// The SetGeoRouter sets GeoRouter of HttpDownloader that picks proxies and local addresses of the region of
+// hosts of requests. Proxy of a page rejected by responce validator is banned by the pool of its region.
+func (this *Spider) SetGeoRouter(r *downloader.GeoRouter) *Spider {
+    this.httpDownloader().SetGeoRouter(r)
+    return this
+}
+
+// The SetValidatorStore sets store of ETag and Last-Modified of pages, like downloader.ValidatorMap or
+// downloader.FileCache, so that re-crawls send conditional requests to the HttpDownloader.
+func (this *Spider) SetValidatorStore(s downloader.ValidatorStore) *Spider {
+    this.httpDownloader().SetValidatorStore(s)
+    return this
+}
+
+// The SetSkipNotModified sets whether pages of 304 Not Modified responce are skipped without being processed
+// and sent to pipelines, for re-crawls that only care about changed pages.
+func (this *Spider) SetSkipNotModified(skip bool) *Spider {
+    this.skipNotModified = skip
+    return this
+}
+
+// The SetUserAgentPool sets UserAgentPool of HttpDownloader that picks a random User-Agent for requests
+// without User-Agent header, like downloader.NewUserAgentPool(nil) of realistic browser User-Agents.
+func (this *Spider) SetUserAgentPool(pool *downloader.UserAgentPool) *Spider {
+    this.httpDownloader().SetUserAgentPool(pool)
+    return this
+}
+
+// The SetHeaderProfile sets HeaderProfile of HttpDownloader that sends browser headers like Accept,
+// Sec-Fetch-* and client hints matching User-Agent of each request, like downloader.NewHeaderProfile("auto").
+func (this *Spider) SetHeaderProfile(profile *downloader.HeaderProfile) *Spider {
+    this.httpDownloader().SetHeaderProfile(profile)
+    return this
+}
+
+// The SetResolver sets Resolver of hosts of HttpDownloader, like downloader.NewDNSCache(nil, time.Minute)
+// caching addresses of the system resolver.
+func (this *Spider) SetResolver(r downloader.Resolver) *Spider {
+    this.httpDownloader().SetResolver(r)
+    return this
+}
+
+// The SetIPFamily sets family of ip addresses HttpDownloader connects to, like downloader.IPv4Only on a
+// network whose IPv6 route is broken. Default downloader.IPAuto races both families by happy eyeballs.
+func (this *Spider) SetIPFamily(family downloader.IPFamily) *Spider {
+    this.httpDownloader().SetIPFamily(family)
+    return this
+}
+
+// The SetSocketOptions sets TOS, TTL and mark of sockets of connections of HttpDownloader, so crawl traffic
+// can be classified and shaped by network devices.
+func (this *Spider) SetSocketOptions(opts downloader.SocketOptions) *Spider {
+    this.httpDownloader().SetSocketOptions(opts)
+    return this
+}
+
+// The SetPrefetch sets whether host of the next request in Scheduler is resolved and connected by
+// HttpDownloader.Prefetch when a request is polled, so setup of connections to new hosts overlaps downloads
+// of requests before it, like in a broad crawl of many sites. Scheduler should be a
+// scheduler.InspectableScheduler, whose next request is found by Peek.
+func (this *Spider) SetPrefetch(enable bool) *Spider {
+    this.httpDownloader().SetPrefetch(enable)
+    this.prefetch = enable
+    return this
+}
+
+// The SetLocalAddrPool binds connections of HttpDownloader to local ip addresses of the pool, like
+// downloader.NewLocalAddrPool("192.0.2.10", "192.0.2.11") of a host with several addresses.
+func (this *Spider) SetLocalAddrPool(pool *downloader.LocalAddrPool) *Spider {
+    this.httpDownloader().SetLocalAddrPool(pool)
+    return this
+}
+
+// The SetFileRoot lets HttpDownloader read "file" urls under the directory, like a local mirror of a site.
+func (this *Spider) SetFileRoot(dir string) *Spider {
+    this.httpDownloader().SetFileRoot(dir)
+    return this
+}
+
+// The SetStreamLimits sets how long streams of "sse" and "websocket" pages of HttpDownloader are open, how
+// many messages they receive and how they are reconnected. Request.SetStreamLimits overrides them.
+func (this *Spider) SetStreamLimits(l request.StreamLimits) *Spider {
+    this.httpDownloader().SetStreamLimits(l)
+    return this
+}
+
+// The SetTimeouts sets connect, tls handshake, responce header and total timeouts of HttpDownloader.
+// Request.SetTimeouts and Request.SetTimeout override them for slow targets.
+func (this *Spider) SetTimeouts(t request.Timeouts) *Spider {
+    this.httpDownloader().SetTimeouts(t)
+    return this
+}
+
+// The SetMaxRedirects limits redirects followed by HttpDownloader. The n 0 does not follow redirects.
+func (this *Spider) SetMaxRedirects(n int) *Spider {
+    this.httpDownloader().SetMaxRedirects(n)
+    return this
+}
+
+// The SetSameDomainRedirects sets whether downloads of HttpDownloader redirected to other domain are failed.
+func (this *Spider) SetSameDomainRedirects(same bool) *Spider {
+    this.httpDownloader().SetSameDomainRedirects(same)
+    return this
+}
+
+// The SetKeepRawBody sets whether HttpDownloader keeps responce body before charset conversion in Page,
+// which is needed by PipelineWarc.
+func (this *Spider) SetKeepRawBody(keep bool) *Spider {
+    this.httpDownloader().SetKeepRawBody(keep)
+    return this
+}
+
+// The SetExtractDocuments sets whether HttpDownloader extracts text of documents like pdf as body of pages.
+// Default is false.
+func (this *Spider) SetExtractDocuments(extract bool) *Spider {
+    this.httpDownloader().SetExtractDocuments(extract)
+    return this
+}
+
+// The SetMaxIdleConns limits idle (keep-alive) connections of HttpDownloader across all hosts.
+func (this *Spider) SetMaxIdleConns(n int) *Spider {
+    this.httpDownloader().SetMaxIdleConns(n)
+    return this
+}
+
+// The SetMaxIdleConnsPerHost limits idle (keep-alive) connections of HttpDownloader of each host.
+// Default is 32, which should not be less than thread number for a crawl of one host.
+func (this *Spider) SetMaxIdleConnsPerHost(n int) *Spider {
+    this.httpDownloader().SetMaxIdleConnsPerHost(n)
+    return this
+}
+
+// The SetMaxConnsPerHost limits all the connections of HttpDownloader of each host. The n 0 means no limit.
+func (this *Spider) SetMaxConnsPerHost(n int) *Spider {
+    this.httpDownloader().SetMaxConnsPerHost(n)
+    return this
+}
+
+// The SetIdleConnTimeout sets how long an idle connection of HttpDownloader is kept.
+func (this *Spider) SetIdleConnTimeout(d time.Duration) *Spider {
+    this.httpDownloader().SetIdleConnTimeout(d)
+    return this
+}
+
+// The SetHTTP2 sets whether HttpDownloader uses HTTP/2 for https hosts supporting it. Default is true.
+func (this *Spider) SetHTTP2(enable bool) *Spider {
+    this.httpDownloader().SetHTTP2(enable)
+    return this
+}
+
+// The SetObeyRobots sets whether HttpDownloader skips urls disallowed by robots.txt, and waits for
+// Crawl-delay of robots.txt before each download. Default is true.
+func (this *Spider) SetObeyRobots(obey bool) *Spider {
+    d := this.httpDownloader()
+    if !obey {
+        d.SetRobots(nil)
+    } else if d.GetRobots() == nil {
+        d.SetRobots(downloader.NewRobots("go_spider"))
+    }
+    return this
+}
+
+// The SetCookieJar sets cookie jar of HttpDownloader, like the jar returned by Login.
+// Default is a downloader.CookieJar, so cookies set by responces are sent with later requests of the domain.
+// The nil means cookies are not kept.
+func (this *Spider) SetCookieJar(jar http.CookieJar) *Spider {
+    this.httpDownloader().SetCookieJar(jar)
+    return this
+}
+
+func (this *Spider) GetCookieJar() http.CookieJar {
+    return this.httpDownloader().GetCookieJar()
+}
+
+// The SetSession sets downloader.Session of the key for requests of Request.SetSession, like cookies logged
+// in, proxy and User-Agent of one account, so several accounts crawl the same site in one Spider.
+func (this *Spider) SetSession(key string, s *downloader.Session) *Spider {
+    this.httpDownloader().SetSession(key, s)
+    return this
+}
+
+// The SetAutoReferer sets whether url of page is set as referer of requests added by the page,
+// unless their referer is set already. The url is the final one after redirects.
+func (this *Spider) SetAutoReferer(auto bool) *Spider {
+    this.autoReferer = auto
+    return this
+}
+
+// The SetInheritMeta sets whether target requests added by a page inherit meta of the page request, so
+// context like "category" set on a listing page flows to its detail pages and further. Only the keys are
+// inherited if keys are given. Meta set on a target request by PageProcesser is kept. Default is false.
+func (this *Spider) SetInheritMeta(inherit bool, keys ...string) *Spider {
+    this.inheritMeta = inherit
+    this.inheritMetaKeys = keys
+    return this
+}
+
+// The SetCache sets the Cache for downloaded pages.
+// If a Request is found in the cache, the page is not downloaded again.
+// It is useful when developing PageProcesser that crawl the same pages again and again.
+func (this *Spider) SetCache(c downloader.Cache) *Spider {
+    this.pCache = c
+    return this
+}
+
+func (this *Spider) GetCache() downloader.Cache {
+    return this.pCache
+}
+
+// The SetOffline sets whether requests are only read from the Cache and never downloaded, for replaying a
+// crawl recorded by a Cache like FileCache without a network, like when changing selectors of PageProcesser.
+// Requests not found in the Cache are dropped and counted as dropped requests of reason "offline".
+func (this *Spider) SetOffline(offline bool) *Spider {
+    this.offline = offline
+    return this
+}
+
+// The SetThreadnum sets number of requests crawled at once. It can be changed while Run is running,
+// and workers over a lowered number finish their requests first.
 func (this *Spider) SetThreadnum(i uint) *Spider {
+    this.runLocker.Lock()
+    defer this.runLocker.Unlock()
     this.threadnum = i
+    if mc, ok := this.mc.(*resource_manage.ResourceManageCond); ok && i > 0 {
+        mc.SetCapnum(i)
+    }
     return this
 }
 
 func (this *Spider) GetThreadnum() uint {
+    this.runLocker.Lock()
+    defer this.runLocker.Unlock()
     return this.threadnum
 }
 
+// The SetSynchronous sets whether requests are crawled one by one in the goroutine of Run, in the order
+// they are polled from Scheduler, so a crawl is deterministic, like crawls of package spidertest replaying
+// fixtures in tests. Threadnum is 1 and SetHostPartition is ignored.
+func (this *Spider) SetSynchronous(synchronous bool) *Spider {
+    this.runLocker.Lock()
+    defer this.runLocker.Unlock()
+    this.synchronous = synchronous
+    return this
+}
+
 // If exit when each crawl task is done.
 // If you want to keep spider in memory all the time and add url from outside, you can set it true.
 func (this *Spider) SetExitWhenComplete(e bool) *Spider {
@@ -178,15 +1073,116 @@ func (this *Spider) GetExitWhenComplete() bool {
     return this.exitWhenComplete
 }
 
+// The SetMaxDepth limits crawl depth, so the crawl does not wander into endless links like calendars.
+// Requests added to Spider have depth 0 unless set by Request.SetDepth, and target requests of a page
+// are one deeper than the page. Requests deeper than n are dropped before they are pushed to Scheduler,
+// and counted in Metrics. The n 0 means no limit.
+func (this *Spider) SetMaxDepth(n int) *Spider {
+    this.rulesLocker.Lock()
+    this.maxDepth = n
+    this.rulesLocker.Unlock()
+    return this
+}
+
+func (this *Spider) GetMaxDepth() int {
+    this.rulesLocker.RLock()
+    defer this.rulesLocker.RUnlock()
+    return this.maxDepth
+}
+
+// The SetUrlFilter sets filter of requests pushed to Scheduler, by allow and deny regexps, allowed domains
+// or domains of start requests, and denied file extensions. Requests denied are dropped and counted in Metrics.
+func (this *Spider) SetUrlFilter(f *scheduler.UrlFilter) *Spider {
+    this.rulesLocker.Lock()
+    this.urlFilter = f
+    this.rulesLocker.Unlock()
+    return this
+}
+
+func (this *Spider) GetUrlFilter() *scheduler.UrlFilter {
+    this.rulesLocker.RLock()
+    defer this.rulesLocker.RUnlock()
+    return this.urlFilter
+}
+
+// The SetRetryTimes sets how many times a failed download is retried. Default is 1.
+func (this *Spider) SetRetryTimes(n uint) *Spider {
+    this.rulesLocker.Lock()
+    this.retryTimes = n
+    this.rulesLocker.Unlock()
+    return this
+}
+
+func (this *Spider) GetRetryTimes() uint {
+    this.rulesLocker.RLock()
+    defer this.rulesLocker.RUnlock()
+    return this.retryTimes
+}
+
+// The SetRetryStatusCodes sets http status codes that should be retried, like 429, 502, 503 and 504.
+// If it is set, only network errors and these status codes are retried, and pages of these status codes
+// are set failed after retries. Other status codes like 404 are not retried.
+// If 429 or 503 responce has Retry-After header, it is used as sleep time before retry.
+func (this *Spider) SetRetryStatusCodes(codes []int) *Spider {
+    retryStatusCodes := make(map[int]bool)
+    for _, code := range codes {
+        retryStatusCodes[code] = true
+    }
+    this.rulesLocker.Lock()
+    this.retryStatusCodes = retryStatusCodes
+    this.rulesLocker.Unlock()
+    return this
+}
+
+// The getRetryStatusCodes returns status codes set by SetRetryStatusCodes, which is not changed but replaced.
+func (this *Spider) getRetryStatusCodes() map[int]bool {
+    this.rulesLocker.RLock()
+    defer this.rulesLocker.RUnlock()
+    return this.retryStatusCodes
+}
+
+// The SetResponseValidator sets function called with each page downloaded successfully.
+// If it returns false, like for a captcha page of status 200, the page is set failed and retried no matter
+// what its status code is, and it is handled by failed request handler after retries.
+// The proxy of the page is banned by ProxyPool and its sticky User-Agent is dropped, so the retry looks
+// like another visitor. ResponseValidator.Validate declares the rules of good pages.
+func (this *Spider) SetResponseValidator(v func(*page.Page) bool) *Spider {
+    this.responseValidator = v
+    return this
+}
+
+// The SetFailedRequestHandler sets function called with request that is still failed after retries,
+// and the error of the last download. The page is still processed by PageProcesser.
+// Use FailedRequestFile.Handle to save failed requests in a file and LoadFailedRequests to crawl them again.
+func (this *Spider) SetFailedRequestHandler(h func(*request.Request, error)) *Spider {
+    this.failedRequestHandler = h
+    return this
+}
+
 // The OpenFileLog initialize the log path and open log.
 // If log is opened, error info or other useful info in spider will be logged in file of the filepath.
-// Log command is mlog.LogInst().LogError("info") or mlog.LogInst().LogInfo("info").
-// Spider's default log is closed.
+// Log command is mlog.Log().Error("info") or mlog.Log().Info("info").
+// Spider's default log is closed. The file log is not used if other Logger is set by SetLogger.
 func (this *Spider) OpenFileLog(filePath string) *Spider {
     mlog.InitFilelog(true, filePath)
     return this
 }
 
+// The SetLogger sets Logger that receives logs of spider, downloader, scheduler and other modules,
+// instead of the default file log. The Logger is shared by all the spiders in the process.
+func (this *Spider) SetLogger(l mlog.Logger) *Spider {
+    mlog.SetLogger(l)
+    return this
+}
+
+// The SetLogLevel sets the lowest level of logs of the component, like "spider", "downloader", "scheduler",
+// "pipeline", "page_processer" or "strace". The component "" sets level of all the components without their own level.
+// Like SetLogger, levels are shared by all the spiders in the process.
+func (this *Spider) SetLogLevel(component string, l mlog.Level) *Spider {
+    mlog.SetLevel(component, l)
+    return this
+}
+
 // OpenFileLogDefault open file log with default file path like "WD/log/log.2014-9-1".
 func (this *Spider) OpenFileLogDefault() *Spider {
     mlog.InitFilelog(true, "")
@@ -235,6 +1231,82 @@ func (this *Spider) sleep() {
     }
 }
 
+// The SetRandomDelay sets wait time before each download, which is drawn uniformly between min and max.
+// A random delay is less detectable than the fixed sleep time. It is safe for concurrent workers.
+func (this *Spider) SetRandomDelay(min, max time.Duration) *Spider {
+    if min > max {
+        panic("min delay must not be larger than max delay")
+    }
+    this.pRandomDelay.set(min, max)
+    return this
+}
+
+// The SetRandomDelaySeed seeds the random delay, so that delays are repeatable in tests.
+func (this *Spider) SetRandomDelaySeed(seed int64) *Spider {
+    this.pRandomDelay.seed(seed)
+    return this
+}
+
+// The SetGlobalRateLimit limits requests per second of all the downloads, retries included.
+// The rps 0 means no limit.
+func (this *Spider) SetGlobalRateLimit(rps float64) *Spider {
+    this.pRateLimit.setGlobal(rps)
+    return this
+}
+
+// The SetHostRateLimit limits requests per second of downloads of each host, retries included.
+// Both the global and the host limit are enforced. The rps 0 means no limit.
+func (this *Spider) SetHostRateLimit(rps float64) *Spider {
+    this.pRateLimit.setHost(rps)
+    return this
+}
+
+// The SetHostBurst sets how many requests of a host can be downloaded at once before the host rate limit
+// takes effect, like a browser loading several resources of a page. Default is 1.
+func (this *Spider) SetHostBurst(burst int) *Spider {
+    this.pRateLimit.setHostBurst(burst)
+    return this
+}
+
+// The SetHostDelay keeps requests of each host at least delay plus a random jitter in [0, jitter] apart,
+// even if they are downloaded by concurrent workers, retries included. Requests of other hosts are not delayed.
+func (this *Spider) SetHostDelay(delay, jitter time.Duration) *Spider {
+    this.pRateLimit.setHostDelay(delay, jitter)
+    return this
+}
+
+// The SetBandwidth limits bytes per second of responce bodies of all the downloads of HttpDownloader, and
+// SetHostBandwidth limits them of each host. Both are enforced; 0 means no limit.
+func (this *Spider) SetBandwidth(bps float64) *Spider {
+    this.httpDownloader().SetBandwidth(bps)
+    return this
+}
+
+func (this *Spider) SetHostBandwidth(bps float64) *Spider {
+    this.httpDownloader().SetHostBandwidth(bps)
+    return this
+}
+
+// The requestDelay returns wait time before the request is downloaded, which is the larger one of
+// the random delay and Crawl-delay of robots.txt. Crawl-delay is left to scheduler.PoliteScheduler,
+// which may be wrapped by scheduler.DelayScheduler.
+func (this *Spider) requestDelay(req *request.Request) time.Duration {
+    delay := this.pRandomDelay.next()
+    s := this.pScheduler
+    if d, ok := s.(*scheduler.DelayScheduler); ok {
+        s = d.GetScheduler()
+    }
+    if _, polite := s.(*scheduler.PoliteScheduler); polite {
+        return delay
+    }
+    if d, ok := this.findHttpDownloader(); ok && d.GetRobots() != nil {
+        if crawlDelay := d.GetRobots().CrawlDelay(req.GetUrl()); crawlDelay > delay {
+            delay = crawlDelay
+        }
+    }
+    return delay
+}
+
 func (this *Spider) AddUrl(url string, respType string) *Spider {
     req := request.NewRequest(url, respType)
     this.addRequest(req)
@@ -249,40 +1321,371 @@ func (this *Spider) AddUrls(urls []string, respType string) *Spider {
     return this
 }
 
+func (this *Spider) AddRequest(req *request.Request) *Spider {
+    this.addRequest(req)
+    return this
+}
+
+func (this *Spider) AddRequests(reqs []*request.Request) *Spider {
+    for _, req := range reqs {
+        this.addRequest(req)
+    }
+    return this
+}
+
+// The AddTemplateRequests adds urls generated from the template with every combination of vars.
+// For example, template "http://a.com/item?id={id}&s={s}" with vars {"id": ["1", "2"], "s": ["x", "y"]}
+// adds four urls. Duplicate urls are added once. Use util.ExpandTemplate to limit the number of urls.
+func (this *Spider) AddTemplateRequests(template string, vars map[string][]string, respType string) *Spider {
+    return this.AddUrls(util.ExpandTemplate(template, vars, 0), respType)
+}
+
 // add Request to Schedule
-func (this *Spider) addRequest(req *request.Request) {
+// It returns reason if the request is dropped, like "depth", or "" if it is pushed.
+func (this *Spider) addRequest(req *request.Request) string {
     if req == nil {
-        mlog.LogInst().LogError("request is nil")
-        return
+        logger.Error("request is nil")
+        return "invalid"
     } else if req.GetUrl() == "" {
-        mlog.LogInst().LogError("request is empty")
-        return
+        logger.Error("request is empty")
+        return "invalid"
+    }
+    this.rulesLocker.RLock()
+    maxDepth, urlFilter := this.maxDepth, this.urlFilter
+    this.rulesLocker.RUnlock()
+    reason := ""
+    switch {
+    case maxDepth > 0 && req.GetDepth() > maxDepth:
+        reason = "depth"
+    case urlFilter != nil && !urlFilter.Allowed(req):
+        reason = "filter"
+    case this.budget != nil && this.budget.exhausted(req):
+        reason = "budget"
+    }
+    if reason != "" {
+        this.dropRequest(reason)
+        return reason
     }
+    if this.forwardShard(req) {
+        this.dropRequest("shard")
+        return "shard"
+    }
+    req.SetQueuedAt(time.Now())
     this.pScheduler.Push(req)
+    this.wakeup()
+    return ""
+}
+
+// The dropRequest records a request dropped for the reason, like "depth".
+func (this *Spider) dropRequest(reason string) {
+    this.metrics.drop(reason)
+    this.runLocker.Lock()
+    stats := this.stats
+    this.runLocker.Unlock()
+    stats.drop(reason)
+}
+
+// The download downloads the request and retries if it is failed.
+// It returns nil if the request is requeued to be retried later.
+func (this *Spider) download(ctx context.Context, req *request.Request) *page.Page {
+    p, rejected := this.downloadOnce(ctx, req)
+    // requests requeued to be retried were downloaded before
+    attempts := req.GetRetries() + 1
+    if this.retryBackoffBase > 0 {
+        if ctx.Err() == nil && (rejected || this.needRetry(p)) && this.retryLater(req, p) {
+            p.CloseBody()
+            return nil
+        }
+    }
+    retryTimes := this.GetRetryTimes()
+    for i := uint(0); this.retryBackoffBase <= 0 && i < retryTimes && ctx.Err() == nil && (rejected || this.needRetry(p)) &&
+        this.retryable(req); i++ {
+        if delay, ok := this.retryDelay(p); ok {
+            time.Sleep(delay)
+        } else {
+            this.sleep()
+        }
+        p.CloseBody()
+        p, rejected = this.downloadOnce(ctx, req)
+        attempts++
+    }
+    if this.getRetryStatusCodes()[p.GetStatusCode()] || this.hostBackoff != nil && backoffStatus(p.GetStatusCode()) {
+        p.SetStatus(true, "http status "+strconv.Itoa(p.GetStatusCode()))
+    }
+    if !p.IsSucc() && ctx.Err() == nil {
+        if this.failedRequestHandler != nil {
+            this.failedRequestHandler(req, errors.New(p.Errormsg()))
+        }
+        this.deadLetter(ctx, &DeadLetter{Kind: DeadLetterRequest, Request: req, Attempts: attempts,
+            Error: p.Errormsg()}, p)
+    }
+    return p
+}
+
+// The downloadOnce downloads the request once, solves captcha and validates the page.
+// It returns true if the page is rejected by the responce validator or is a captcha page not solved,
+// and the page is set failed.
+func (this *Spider) downloadOnce(ctx context.Context, req *request.Request) (*page.Page, bool) {
+    p := this.fetch(ctx, req)
+    if p.GetStatusCode() != 0 && this.captchaDetect != nil && this.captchaDetect(p) {
+        var msg string
+        if p, msg = this.solveCaptcha(ctx, req, p); msg != "" {
+            return this.reject(req, p, msg), true
+        }
+    }
+    if p.IsSucc() && this.responseValidator != nil && !this.responseValidator(p) {
+        logger.Warn("responce is rejected by validator", mlog.F("url", req.GetUrl()), mlog.F("status", p.GetStatusCode()))
+        return this.reject(req, p, "responce is rejected by validator"), true
+    }
+    return p, false
+}
+
+// The reject sets the page failed with msg. The proxy of the page is banned by ProxyPool and its sticky
+// User-Agent is dropped, so the retry looks like another visitor.
+func (this *Spider) reject(req *request.Request, p *page.Page, msg string) *page.Page {
+    if p.IsSucc() && p.GetStatusCode() < 400 {
+        // recorded as ok by download
+        this.stats.reject()
+    }
+    p.SetStatus(true, msg)
+    this.metrics.reject()
+    if d, ok := this.findHttpDownloader(); ok {
+        if p.GetProxyHost() != "" {
+            d.BanProxy(p.GetProxyHost())
+        }
+        if d.GetUserAgentPool() != nil {
+            d.GetUserAgentPool().Forget(req.GetUrl(), p.GetProxyHost())
+        }
+    }
+    return p
+}
+
+// The fetch downloads the request once by rate limits, and records the download.
+func (this *Spider) fetch(ctx context.Context, req *request.Request) *page.Page {
+    if this.autoThrottle != nil {
+        this.autoThrottle.wait(req.GetUrl())
+    }
+    if flow, ok := this.GetPageProcesser().(*Flow); ok {
+        flow.wait(req)
+    }
+    this.pRateLimit.wait(req.GetUrl())
+    start := time.Now()
+    downloadCtx, span := trace.Start(ctx, "download")
+    p := this.authorizedDownload(downloadCtx, req)
+    if span != nil {
+        traceDownload(span, req, p)
+        // pipelines of the page are traced under the crawl span, not the download
+        p.SetContext(ctx)
+    }
+    this.metrics.download(p, time.Since(start))
+    this.stats.download(p)
+    if logger.Enabled(mlog.LevelDebug) {
+        logger.Debug("download", mlog.F("url", req.GetUrl()), mlog.F("host", urlHost(req.GetUrl())),
+            mlog.F("status", p.GetStatusCode()), mlog.F("duration", time.Since(start)), mlog.F("error", p.Errormsg()))
+    }
+    if this.autoThrottle != nil {
+        this.autoThrottle.observe(p, time.Since(start))
+    }
+    this.checkAutoPause(p)
+    this.observeBackoff(p)
+    this.observeCircuit(p)
+    return p
+}
+
+// The needRetry tests whether the page should be downloaded again.
+func (this *Spider) needRetry(p *page.Page) bool {
+    if p.Errormsg() == downloader.ErrRobotsDisallowed.Error() {
+        return false
+    }
+    if this.hostBackoff != nil && backoffStatus(p.GetStatusCode()) {
+        return true
+    }
+    codes := this.getRetryStatusCodes()
+    if len(codes) == 0 {
+        return !p.IsSucc()
+    }
+    if p.GetStatusCode() == 0 {
+        return !p.IsSucc()
+    }
+    return codes[p.GetStatusCode()]
+}
+
+// The retryAfter returns delay in Retry-After header of 429 or 503 responce.
+// The header value is seconds or a http date.
+func retryAfter(p *page.Page) (time.Duration, bool) {
+    if !backoffStatus(p.GetStatusCode()) {
+        return 0, false
+    }
+    value := http.Header(p.GetHeader()).Get("Retry-After")
+    if value == "" {
+        return 0, false
+    }
+    if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+        return time.Duration(seconds) * time.Second, true
+    }
+    if t, err := http.ParseTime(value); err == nil {
+        if delay := t.Sub(time.Now()); delay > 0 {
+            return delay, true
+        }
+        return 0, true
+    }
+    return 0, false
+}
+
+// The process parses page by callback of the request, or PageProcesser of Spider if callback is not set.
+// Body of "stream" page is passed to page_processer.StreamPageProcesser or callback func(*page.Page, io.Reader).
+func (this *Spider) process(p *page.Page) {
+    if body := p.GetBodyReader(); body != nil {
+        switch callback := p.GetRequest().GetCallback().(type) {
+        case func(*page.Page, io.Reader):
+            callback(p, body)
+            return
+        case page_processer.StreamPageProcesser:
+            callback.ProcessStream(p, body)
+            return
+        case nil:
+            if sp, ok := this.GetPageProcesser().(page_processer.StreamPageProcesser); ok {
+                sp.ProcessStream(p, body)
+                return
+            }
+        }
+    }
+    switch callback := p.GetRequest().GetCallback().(type) {
+    case nil:
+        this.GetPageProcesser().Process(p)
+    case func(*page.Page):
+        callback(p)
+    case page_processer.PageProcesser:
+        callback.Process(p)
+    default:
+        logger.Error("request callback is not func(*page.Page) or PageProcesser", mlog.F("url", p.GetRequest().GetUrl()))
+        this.GetPageProcesser().Process(p)
+    }
 }
 
 // core processer
-func (this *Spider) pageProcess(req *request.Request) {
+func (this *Spider) pageProcess(runCtx context.Context, req *request.Request) {
+    polled := req
+    if len(this.requestMiddlewares) > 0 {
+        req = polled.Clone()
+    }
+    this.applyHeaders(req)
+    for _, m := range this.requestMiddlewares {
+        if req = m(req); req == nil {
+            return
+        }
+    }
+    if modified := req; modified != polled {
+        this.dispatchLocker.Lock()
+        this.middlewareRequests[modified] = polled
+        this.dispatchLocker.Unlock()
+        defer func() {
+            this.dispatchLocker.Lock()
+            delete(this.middlewareRequests, modified)
+            this.dispatchLocker.Unlock()
+        }()
+    }
+    ctx, release := requestContext(runCtx, req)
+    defer release()
+    ctx, span := this.traceRequest(ctx, req)
+    defer span.Finish()
+
+    if this.throttlePaused(req) || this.backedOff(req) {
+        return
+    }
+    if this.budget != nil && req.GetRetries() == 0 && !this.budget.take(req) {
+        this.dropRequest("budget")
+        return
+    }
+
     var p *page.Page
-    p = this.pDownloader.Download(req)
-    if !p.IsSucc() {
-        // download retry
+    cached := false
+    if this.pCache != nil {
+        p, cached = this.pCache.Get(req)
+    }
+    if !cached && this.offline {
+        logger.Debug("request is not in cache", mlog.F("url", req.GetUrl()))
+        this.dropRequest("offline")
+        return
+    }
+    if !cached {
+        if this.circuitOpen(req) || this.hostDead(ctx, req) {
+            return
+        }
+        if delay := this.requestDelay(req); delay > 0 {
+            time.Sleep(delay)
+        }
+        if p = this.download(ctx, req); p == nil {
+            return
+        }
+        defer p.CloseBody()
+        if this.pCache != nil && p.IsSucc() && p.GetBodyReader() == nil && p.Messages() == nil {
+            this.pCache.Set(req, p)
+        }
+    }
+    this.revisitLater(polled, p)
+    if this.crawlGraph != nil {
+        this.crawlGraph.crawled(p)
+    }
+
+    for _, m := range this.responseMiddlewares {
+        if p = m(p); p == nil {
+            return
+        }
+    }
+    if this.skipNotModified && p.IsNotModified() {
+        this.sleep()
+        return
+    }
+    if this.duplicateContent(p) || this.otherLanguage(p) {
+        if !cached {
+            this.sleep()
+        }
+        return
+    }
+
+    _, processSpan := trace.Start(ctx, "process")
+    if p.Messages() != nil {
+        this.processMessages(ctx, p)
+        processSpan.Finish()
+        return
+    }
+    this.process(p)
+    processSpan.SetAttribute("crawl.target_requests", len(p.GetTargetRequests())).Finish()
+    this.emit(ctx, p)
+
+    // sleep is not needed when target is not visited
+    if !cached {
         this.sleep()
-        p = this.pDownloader.Download(req)
     }
+}
 
-    this.pPageProcesser.Process(p)
+// The emit puts target requests of the processed page into Scheduler, and outputs its items to Pipelines.
+func (this *Spider) emit(ctx context.Context, p *page.Page) {
     for _, req := range p.GetTargetRequests() {
         //fmt.Printf("%v\n",req)
-        this.addRequest(req)
+        if this.autoReferer && req.GetReferer() == "" {
+            req.SetReferer(p.GetFinalUrl())
+        }
+        if this.inheritMeta {
+            req.InheritMeta(p.GetRequest(), this.inheritMetaKeys...)
+        }
+        if req.GetSession() == "" {
+            req.SetSession(p.GetRequest().GetSession())
+        }
+        req.SetDepth(p.GetRequest().GetDepth() + 1)
+        dropped := this.addRequest(req)
+        if this.crawlGraph != nil {
+            this.crawlGraph.link(p, req, dropped)
+        }
     }
 
     // output
-    if !p.GetSkip() {
-        for _, pip := range this.pPiplelines {
-            pip.Process(p.GetPageItems(), this)
+    if this.pageChanged(p) && !p.GetSkip() && len(this.pPiplelines) > 0 {
+        if q := this.pipelineQueue; q != nil {
+            q.push(p)
+        } else {
+            this.output(ctx, p)
         }
     }
-
-    this.sleep()
 }
@@ -0,0 +1,3115 @@
+package spider_test
+
+import (
+    "context"
+    "crypto/md5"
+    "encoding/hex"
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/com_interfaces"
+    "github.com/hu17889/go_spider/core/common/mlog"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/trace"
+    "github.com/hu17889/go_spider/core/downloader"
+    "github.com/hu17889/go_spider/core/page_processer"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "github.com/hu17889/go_spider/core/scheduler"
+    "github.com/hu17889/go_spider/core/spider"
+    "golang.org/x/net/html"
+    "io"
+    "io/ioutil"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "os"
+    "path/filepath"
+    "reflect"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+)
+
+type testPageProcesser struct {
+    locker sync.Mutex
+    pages  []*page.Page
+}
+
+func (this *testPageProcesser) Process(p *page.Page) {
+    this.locker.Lock()
+    this.pages = append(this.pages, p)
+    this.locker.Unlock()
+}
+
+func (this *testPageProcesser) count() int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return len(this.pages)
+}
+
+func TestRetryStatusCodes(t *testing.T) {
+    var locker sync.Mutex
+    hits := make(map[string]int)
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        hits[r.URL.Path]++
+        n := hits[r.URL.Path]
+        locker.Unlock()
+        switch {
+        case r.URL.Path == "/busy" && n <= 2:
+            w.Header().Set("Retry-After", "0")
+            w.WriteHeader(http.StatusTooManyRequests)
+        case r.URL.Path == "/missing":
+            w.WriteHeader(http.StatusNotFound)
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "retry").CloseStrace()
+    sp.SetRetryTimes(3).SetRetryStatusCodes([]int{429, 503})
+    sp.AddUrl(ts.URL+"/busy", "text").AddUrl(ts.URL+"/missing", "text").Run()
+
+    if hits["/busy"] != 3 {
+        t.Errorf("retryable status should be retried: %d", hits["/busy"])
+    }
+    if hits["/missing"] != 1 {
+        t.Errorf("not retryable status should not be retried: %d", hits["/missing"])
+    }
+    for _, p := range pp.pages {
+        if !p.IsSucc() {
+            t.Errorf("page failed: %s %s", p.GetRequest().GetUrl(), p.Errormsg())
+        }
+    }
+
+    // retry budget is used up
+    hits = make(map[string]int)
+    pp = &testPageProcesser{}
+    sp = spider.NewSpider(pp, "retry").CloseStrace()
+    sp.SetRetryTimes(1).SetRetryStatusCodes([]int{429})
+    sp.AddUrl(ts.URL+"/busy", "text").Run()
+    if len(pp.pages) != 1 || pp.pages[0].IsSucc() || pp.pages[0].GetStatusCode() != 429 {
+        t.Error("page should be failed after retries")
+    }
+}
+
+func TestFailedRequestFile(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_failed")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "failed.jsonl")
+
+    ff := spider.NewFailedRequestFile(path)
+    sp := spider.NewSpider(&testPageProcesser{}, "failed").CloseStrace()
+    sp.SetRetryStatusCodes([]int{503}).SetFailedRequestHandler(ff.Handle)
+    sp.AddUrl(ts.URL+"/a", "html").AddUrl(ts.URL+"/b", "text").Run()
+    ff.Close()
+
+    reqs, err := spider.LoadFailedRequests(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(reqs) != 2 {
+        t.Fatalf("failed requests count error: %d", len(reqs))
+    }
+    for _, req := range reqs {
+        if req.GetUrl() != ts.URL+"/a" && req.GetUrl() != ts.URL+"/b" {
+            t.Errorf("failed request error: %s", req.GetUrl())
+        }
+        if req.GetUrl() == ts.URL+"/b" && req.GetResponceType() != "text" {
+            t.Error("responce type error")
+        }
+    }
+}
+
+func TestDeadLetterPipeline(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/down" {
+            w.Header().Set("Retry-After", "0")
+            w.WriteHeader(http.StatusServiceUnavailable)
+            w.Write([]byte("service is down for maintenance"))
+            return
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_dead_letter")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "dead.jsonl")
+
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        if !p.IsSucc() {
+            p.SetSkip(true)
+            return
+        }
+        p.AddField("name", "")
+    })
+    spider.NewSpider(pp, "dead").CloseStrace().SetObeyRobots(false).SetRetryTimes(1).
+        SetRetryStatusCodes([]int{503}).SetItemValidator(spider.NewItemValidator().Require("name").Check, nil).
+        SetDeadLetterPipeline(pipeline.NewPipelineJsonLines(path), 7).AddPipeline(pipeline.NewCollectPipelinePageItems()).
+        AddUrl(ts.URL+"/down", "text").AddUrl(ts.URL+"/item", "text").Run()
+
+    letters, err := spider.LoadDeadLetters(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if len(letters) != 2 {
+        t.Fatalf("dead letters count error: %d", len(letters))
+    }
+    for _, letter := range letters {
+        switch letter.Kind {
+        case spider.DeadLetterRequest:
+            if letter.Url != ts.URL+"/down" || letter.Request.GetUrl() != letter.Url || letter.Attempts != 2 ||
+                letter.StatusCode != 503 || letter.Error == "" {
+                t.Errorf("failed request dead letter error: %+v", letter)
+            }
+            if letter.Body != "service" || !letter.BodyTruncated || letter.Header.Get("Retry-After") != "0" {
+                t.Errorf("responce of dead letter error: %q %v", letter.Body, letter.Header)
+            }
+        case spider.DeadLetterItem:
+            if letter.Url != ts.URL+"/item" || letter.Request.GetResponceType() != "text" || letter.Error == "" ||
+                letter.Body != "ok" || letter.BodyTruncated {
+                t.Errorf("invalid item dead letter error: %+v", letter)
+            }
+            if name, ok := letter.Items["name"]; !ok || name != "" {
+                t.Errorf("items of dead letter error: %v", letter.Items)
+            }
+        default:
+            t.Errorf("dead letter kind error: %s", letter.Kind)
+        }
+    }
+}
+
+func TestHostLiveness(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+    l, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    deadAddr := l.Addr().String()
+    l.Close()
+
+    var locker sync.Mutex
+    probes := make(map[string]int)
+    probe := spider.TCPProbe(time.Second)
+    var failed []error
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "liveness").CloseStrace().SetObeyRobots(false).SetThreadnum(3).
+        SetHostLiveness(func(ctx context.Context, addr string) error {
+            locker.Lock()
+            probes[addr]++
+            locker.Unlock()
+            return probe(ctx, addr)
+        }, 0).SetFailedRequestHandler(func(req *request.Request, err error) {
+        locker.Lock()
+        failed = append(failed, err)
+        locker.Unlock()
+    })
+    for _, path := range []string{"/a", "/b", "/c"} {
+        sp.AddUrl(ts.URL+path, "text").AddUrl("http://"+deadAddr+path, "text")
+    }
+    sp.Run()
+
+    if len(pp.pages) != 3 {
+        t.Errorf("pages of live host should be downloaded: %d", len(pp.pages))
+    }
+    if len(failed) != 3 || failed[0] != spider.ErrHostDead {
+        t.Errorf("requests of dead host should fail: %v", failed)
+    }
+    if len(probes) != 2 || probes[deadAddr] != 1 || probes[ts.Listener.Addr().String()] != 1 {
+        t.Errorf("each host should be probed once: %v", probes)
+    }
+    if dead := sp.GetDeadHosts(); len(dead) != 1 || dead[deadAddr] == nil {
+        t.Errorf("dead hosts error: %v", dead)
+    }
+    if dead := sp.ProbeHosts(ts.URL+"/d", "http://"+deadAddr+"/d", "file:///tmp/x"); len(dead) != 1 ||
+        dead[deadAddr] == nil || probes[deadAddr] != 1 {
+        t.Errorf("probe hosts error: %v", dead)
+    }
+}
+
+func TestHostLivenessDownloader(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+    _, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+
+    // probes resolve hosts by the resolver of the downloader
+    sp := spider.NewSpider(&testPageProcesser{}, "liveness").CloseStrace().
+        SetResolver(downloader.NewDNSCache(nil, time.Minute).AddHost("live.example", "127.0.0.1")).
+        SetHostLiveness(spider.TCPProbe(time.Second), 0)
+    if dead := sp.ProbeHosts("http://live.example:" + port + "/"); len(dead) != 0 {
+        t.Errorf("host should be resolved by resolver of the downloader: %v", dead)
+    }
+
+    // and Stop cancels probes being run
+    started := make(chan struct{})
+    var once sync.Once
+    pp := &testPageProcesser{}
+    sp = spider.NewSpider(pp, "liveness").CloseStrace().SetObeyRobots(false).
+        SetHostLiveness(func(ctx context.Context, addr string) error {
+            once.Do(func() { close(started) })
+            <-ctx.Done()
+            return ctx.Err()
+        }, 0).AddUrl(ts.URL+"/a", "text")
+    done := make(chan struct{})
+    go func() {
+        sp.Run()
+        close(done)
+    }()
+    <-started
+    sp.Stop()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("Stop should cancel the probe")
+    }
+    if dead := sp.GetDeadHosts(); len(dead) != 0 {
+        t.Errorf("host of canceled probe should not be dead: %v", dead)
+    }
+}
+
+type stopPageProcesser struct {
+    testPageProcesser
+    sp *spider.Spider
+}
+
+func (this *stopPageProcesser) Process(p *page.Page) {
+    this.testPageProcesser.Process(p)
+    this.sp.Stop()
+}
+
+func TestPendingRequestFile(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_pending")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "pending.jsonl")
+
+    // stopped after the first page
+    pp := &stopPageProcesser{}
+    pp.sp = spider.NewSpider(pp, "pending").CloseStrace().SetPendingRequestFile(path)
+    pp.sp.AddUrls([]string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/c"}, "text").Run()
+    if len(pp.pages) != 1 {
+        t.Fatalf("spider should stop after one page: %d", len(pp.pages))
+    }
+
+    // continued by the pending requests
+    tp := &testPageProcesser{}
+    spider.NewSpider(tp, "pending").CloseStrace().SetPendingRequestFile(path).Run()
+    if len(tp.pages) != 2 {
+        t.Fatalf("pending requests count error: %d", len(tp.pages))
+    }
+    if _, err := os.Stat(path); !os.IsNotExist(err) {
+        t.Error("pending request file should be removed")
+    }
+}
+
+func TestLogin(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/login":
+            if r.FormValue("user") != "u" || r.FormValue("password") != "p" {
+                w.Write([]byte("wrong password"))
+                return
+            }
+            http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+            http.Redirect(w, r, "/home", http.StatusFound)
+        case "/home":
+            w.Write([]byte("welcome"))
+        case "/secret":
+            if c, err := r.Cookie("session"); err != nil || c.Value != "s1" {
+                w.WriteHeader(http.StatusForbidden)
+                return
+            }
+            w.Write([]byte("secret"))
+        }
+    }))
+    defer ts.Close()
+
+    welcome := func(body string) bool { return body == "welcome" }
+    if _, err := spider.Login(ts.URL+"/login", map[string]string{"user": "u", "password": "x"}, welcome); err == nil {
+        t.Error("login with wrong password should fail")
+    }
+    jar, err := spider.Login(ts.URL+"/login", map[string]string{"user": "u", "password": "p"}, welcome)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    pp := &testPageProcesser{}
+    spider.NewSpider(pp, "login").CloseStrace().SetCookieJar(jar).AddUrl(ts.URL+"/secret", "text").Run()
+    if len(pp.pages) != 1 || pp.pages[0].GetBodyStr() != "secret" {
+        t.Error("crawl with session cookies error")
+    }
+}
+
+type testLogger struct {
+    locker sync.Mutex
+    infos  []string
+    errors []string
+}
+
+func (this *testLogger) Debug(str string) {}
+func (this *testLogger) Info(str string) {
+    this.locker.Lock()
+    this.infos = append(this.infos, str)
+    this.locker.Unlock()
+}
+func (this *testLogger) Warn(str string) {}
+func (this *testLogger) Error(str string) {
+    this.locker.Lock()
+    this.errors = append(this.errors, str)
+    this.locker.Unlock()
+}
+
+func TestSetLogger(t *testing.T) {
+    l := &testLogger{}
+    sp := spider.NewSpider(&testPageProcesser{}, "logger").CloseStrace().SetLogger(l)
+    defer sp.SetLogger(nil)
+    sp.AddUrl("http://example.com/", "unknown").Run()
+    if len(l.errors) == 0 || l.errors[0] != "error request type:unknown" {
+        t.Errorf("logger error: %v", l.errors)
+    }
+}
+
+func TestStraceLogger(t *testing.T) {
+    l := &testLogger{}
+    sp := spider.NewSpider(&testPageProcesser{}, "strace").SetLogger(l)
+    defer sp.SetLogger(nil)
+    sp.AddUrl("http://example.com/", "unknown").Run()
+    if len(l.infos) == 0 || l.infos[len(l.infos)-1] != "** end spider **" {
+        t.Fatalf("strace should be logged by Logger: %v", l.infos)
+    }
+
+    l.infos = nil
+    sp.CloseStrace().AddUrl("http://example.com/", "unknown").Run()
+    if len(l.infos) != 0 {
+        t.Errorf("closed strace should not be logged: %v", l.infos)
+    }
+}
+
+type pausePageProcesser struct {
+    testPageProcesser
+    sp   *spider.Spider
+    once sync.Once
+}
+
+func (this *pausePageProcesser) Process(p *page.Page) {
+    this.testPageProcesser.Process(p)
+    this.once.Do(this.sp.Pause)
+}
+
+func TestPauseResume(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &pausePageProcesser{}
+    pp.sp = spider.NewSpider(pp, "pause").CloseStrace()
+    pp.sp.AddUrls([]string{ts.URL + "/a", ts.URL + "/b"}, "text")
+    done := make(chan struct{})
+    go func() {
+        pp.sp.Run()
+        close(done)
+    }()
+
+    time.Sleep(100 * time.Millisecond)
+    pp.locker.Lock()
+    n := len(pp.pages)
+    pp.locker.Unlock()
+    if n != 1 || !pp.sp.IsPaused() {
+        t.Fatalf("spider should be paused after one page: %d", n)
+    }
+    pp.sp.Resume()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("spider is not resumed")
+    }
+    if len(pp.pages) != 2 {
+        t.Error("requests should be kept while paused")
+    }
+}
+
+func TestAutoPause(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusServiceUnavailable)
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "autopause").CloseStrace().SetAutoPause(2, 50*time.Millisecond)
+    sp.AddUrls([]string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/c"}, "text")
+    start := time.Now()
+    sp.Run()
+    if time.Since(start) < 50*time.Millisecond {
+        t.Error("spider should be paused")
+    }
+    if len(pp.pages) != 3 || sp.IsPaused() {
+        t.Error("spider should be resumed after the duration")
+    }
+}
+
+func TestAutoReferer(t *testing.T) {
+    var locker sync.Mutex
+    var referer string
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/old":
+            http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+        case "/child":
+            locker.Lock()
+            referer = r.Referer()
+            locker.Unlock()
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "referer").CloseStrace().SetAutoReferer(true)
+    sp.AddRequest(request.NewRequest(ts.URL+"/old", "text").SetCallback(func(p *page.Page) {
+        p.AddTargetRequest(ts.URL+"/child", "text")
+    })).Run()
+    if referer != ts.URL+"/new" {
+        t.Errorf("referer should be the url after redirect: %s", referer)
+    }
+}
+
+func TestMiddleware(t *testing.T) {
+    var locker sync.Mutex
+    order := make(map[string]string)
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        order[r.URL.Path] = strings.Join(r.Header["X-Order"], ",")
+        locker.Unlock()
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    var responses []string
+    sp := spider.NewSpider(pp, "middleware").CloseStrace().SetObeyRobots(false).SetThreadnum(1).
+        AddRequestMiddleware(func(req *request.Request) *request.Request {
+            return req.AddHeader("X-Order", "1")
+        }).
+        AddRequestMiddleware(func(req *request.Request) *request.Request {
+            if strings.HasSuffix(req.GetUrl(), "/dropped") {
+                return nil
+            }
+            return req.AddHeader("X-Order", "2")
+        }).
+        AddResponseMiddleware(func(p *page.Page) *page.Page {
+            locker.Lock()
+            responses = append(responses, "1 "+p.GetRequest().GetUrl())
+            locker.Unlock()
+            return p
+        }).
+        AddResponseMiddleware(func(p *page.Page) *page.Page {
+            locker.Lock()
+            responses = append(responses, "2 "+p.GetRequest().GetUrl())
+            locker.Unlock()
+            if strings.HasSuffix(p.GetRequest().GetUrl(), "/hidden") {
+                return nil
+            }
+            return p
+        })
+    sp.AddUrls([]string{ts.URL + "/kept", ts.URL + "/dropped", ts.URL + "/hidden"}, "text").Run()
+
+    if _, ok := order["/dropped"]; ok || order["/kept"] != "1,2" || order["/hidden"] != "1,2" {
+        t.Errorf("request middlewares should be called in order and drop requests: %v", order)
+    }
+    // one thread downloads pages one by one, and each passes the first middleware before the second
+    var pages []string
+    for i := 0; i+1 < len(responses); i += 2 {
+        if responses[i][2:] != responses[i+1][2:] || responses[i][:2] != "1 " || responses[i+1][:2] != "2 " {
+            t.Errorf("response middlewares should be called in order: %v", responses)
+        }
+        pages = append(pages, responses[i][2:])
+    }
+    sort.Strings(pages)
+    if len(responses) != 4 || strings.Join(pages, " ") != ts.URL+"/hidden "+ts.URL+"/kept" {
+        t.Errorf("response middlewares should be called for downloaded pages: %v", responses)
+    }
+    if len(pp.pages) != 1 || pp.pages[0].GetRequest().GetUrl() != ts.URL+"/kept" {
+        t.Errorf("page dropped by response middleware should not be processed: %d", len(pp.pages))
+    }
+}
+
+func TestMiddlewareRequeue(t *testing.T) {
+    var locker sync.Mutex
+    var attempts []string
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        attempts = append(attempts, strings.Join(r.Header["X-Attempt"], ","))
+        n := len(attempts)
+        locker.Unlock()
+        if n == 1 {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "middleware").CloseStrace().SetObeyRobots(false).
+        SetRetryStatusCodes([]int{http.StatusServiceUnavailable}).SetRetryBackoff(time.Millisecond, time.Millisecond).
+        AddRequestMiddleware(func(req *request.Request) *request.Request {
+            return req.AddHeader("X-Attempt", strconv.Itoa(req.GetRetries()))
+        })
+    req := request.NewRequest(ts.URL+"/", "text")
+    sp.AddRequest(req).Run()
+
+    // the requeued request is passed to middlewares without the header of the first attempt
+    if pp.count() != 1 || strings.Join(attempts, " ") != "0 1" {
+        t.Errorf("middlewares should be applied once to each attempt: %q", attempts)
+    }
+    if len(req.GetHeader()["X-Attempt"]) != 0 {
+        t.Errorf("middlewares should not modify the polled request: %v", req.GetHeader())
+    }
+}
+
+// The countingScheduler counts Poll calls of a QueueScheduler.
+type countingScheduler struct {
+    *scheduler.QueueScheduler
+    polls int64
+}
+
+func (this *countingScheduler) Poll() *request.Request {
+    atomic.AddInt64(&this.polls, 1)
+    return this.QueueScheduler.Poll()
+}
+
+func TestRunWorkers(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        time.Sleep(20 * time.Millisecond)
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    // Run returns when the Scheduler is empty and all the workers are idle, after pages found by workers
+    pp := &testPageProcesser{}
+    s := &countingScheduler{QueueScheduler: scheduler.NewQueueScheduler(false)}
+    sp := spider.NewSpider(pp, "workers").CloseStrace().SetObeyRobots(false).SetThreadnum(3).SetScheduler(s)
+    for i := 0; i < 3; i++ {
+        sp.AddRequest(request.NewRequest(ts.URL+"/list/"+strconv.Itoa(i), "text").SetCallback(func(p *page.Page) {
+            pp.Process(p)
+            for j := 0; j < 3; j++ {
+                p.AddTargetRequest(p.GetRequest().GetUrl()+"/item/"+strconv.Itoa(j), "text")
+            }
+        }))
+    }
+    done := make(chan struct{})
+    go func() {
+        sp.Run()
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("Run should return when all the requests are crawled")
+    }
+    if len(pp.pages) != 12 || s.Count() != 0 {
+        t.Errorf("all the pages should be crawled before Run returns: %d %d", len(pp.pages), s.Count())
+    }
+
+    // an idle spider waits for requests instead of polling the Scheduler in a busy loop
+    s = &countingScheduler{QueueScheduler: scheduler.NewQueueScheduler(false)}
+    sp = spider.NewSpider(pp, "idle").CloseStrace().SetObeyRobots(false).SetScheduler(s).SetExitWhenComplete(false)
+    done = make(chan struct{})
+    go func() {
+        sp.Run()
+        close(done)
+    }()
+    time.Sleep(500 * time.Millisecond)
+    if polls := atomic.LoadInt64(&s.polls); polls > 20 {
+        t.Errorf("idle spider should not busy poll the Scheduler: %d polls in 500ms", polls)
+    }
+    sp.AddUrl(ts.URL+"/late", "text")
+    for i := 0; i < 100 && pp.count() < 13; i++ {
+        time.Sleep(10 * time.Millisecond)
+    }
+    sp.Stop()
+    <-done
+    if len(pp.pages) != 13 {
+        t.Errorf("request added to idle spider should be crawled: %d", len(pp.pages))
+    }
+}
+
+func TestCheckpoint(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_checkpoint")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "checkpoint.json")
+
+    pp := &stopPageProcesser{}
+    pp.sp = spider.NewSpider(pp, "checkpoint").CloseStrace().EnableCheckpoint(path, time.Millisecond)
+    pp.sp.AddUrls([]string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/c"}, "text").Run()
+
+    tp := &testPageProcesser{}
+    sp := spider.NewSpider(tp, "checkpoint").CloseStrace()
+    if err = sp.LoadCheckpoint(path); err != nil {
+        t.Fatal(err)
+    }
+    sp.Run()
+    if len(tp.pages) != 2 {
+        t.Fatalf("requests in checkpoint error: %d", len(tp.pages))
+    }
+
+    ioutil.WriteFile(path, []byte(`{"version": 99, "requests": []}`), 0644)
+    if err = sp.LoadCheckpoint(path); err == nil {
+        t.Error("newer checkpoint version should be refused")
+    }
+}
+
+// The linkPageProcesser adds links of all the pages, and stops the spider after the first page if sp is set.
+type linkPageProcesser struct {
+    testPageProcesser
+    links []string
+    sp    *spider.Spider
+}
+
+func (this *linkPageProcesser) Process(p *page.Page) {
+    this.testPageProcesser.Process(p)
+    p.AddTargetRequests(this.links, "text")
+    if this.sp != nil {
+        this.sp.Stop()
+    }
+}
+
+func TestCheckpointResume(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "go_spider_checkpoint")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "checkpoint.json")
+    links := []string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/c"}
+    newSpider := func(pp page_processer.PageProcesser) *spider.Spider {
+        s := scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+        return spider.NewSpider(pp, "resume").CloseStrace().SetScheduler(s).EnableCheckpoint(path, time.Hour)
+    }
+
+    pp := &linkPageProcesser{links: links}
+    pp.sp = newSpider(pp)
+    pp.sp.AddUrl(links[0], "text").Run()
+    if _, err = os.Stat(path + ".dedup"); err != nil {
+        t.Fatal("deduplicator should be saved")
+    }
+
+    // crawled page is not crawled again after resume
+    tp := &linkPageProcesser{links: links}
+    newSpider(tp).Run()
+    if len(tp.pages) != 2 {
+        t.Fatalf("crawl should be resumed: %d", len(tp.pages))
+    }
+    for _, p := range tp.pages {
+        if p.GetRequest().GetUrl() == links[0] {
+            t.Error("crawled page should be removed by deduplicator")
+        }
+    }
+    if _, err = os.Stat(path); !os.IsNotExist(err) {
+        t.Error("checkpoint should be removed when the crawl is complete")
+    }
+}
+
+func TestResponseValidator(t *testing.T) {
+    var locker sync.Mutex
+    hits := 0
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        hits++
+        n := hits
+        locker.Unlock()
+        if n <= 2 {
+            w.Write([]byte("please input captcha"))
+            return
+        }
+        w.Write([]byte("content"))
+    }))
+    defer ts.Close()
+
+    var failed []string
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "validator").CloseStrace().SetRetryTimes(2).SetRetryStatusCodes([]int{503})
+    sp.SetResponseValidator(func(p *page.Page) bool {
+        return !strings.Contains(p.GetBodyStr(), "captcha")
+    }).SetFailedRequestHandler(func(req *request.Request, err error) {
+        failed = append(failed, req.GetUrl())
+    })
+    sp.AddUrl(ts.URL, "text").Run()
+    if hits != 3 || len(pp.pages) != 1 || pp.pages[0].GetBodyStr() != "content" {
+        t.Errorf("rejected page should be retried: %d", hits)
+    }
+
+    // still rejected after retries
+    sp.SetRetryTimes(0).AddUrl(ts.URL+"/a", "text")
+    sp.SetResponseValidator(func(p *page.Page) bool { return false }).Run()
+    if len(failed) != 1 || pp.pages[1].IsSucc() {
+        t.Error("rejected page should be failed")
+    }
+}
+
+func TestObeyRobots(t *testing.T) {
+    var locker sync.Mutex
+    hits := make(map[string]int)
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        hits[r.URL.Path]++
+        locker.Unlock()
+        if r.URL.Path == "/robots.txt" {
+            w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+            return
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    spider.NewSpider(pp, "robots").CloseStrace().AddUrl(ts.URL+"/private", "text").AddUrl(ts.URL+"/public", "text").Run()
+    if hits["/private"] != 0 || hits["/public"] != 1 || hits["/robots.txt"] != 1 {
+        t.Errorf("disallowed url should be skipped: %v", hits)
+    }
+
+    hits = make(map[string]int)
+    spider.NewSpider(pp, "robots").CloseStrace().SetObeyRobots(false).AddUrl(ts.URL+"/private", "text").Run()
+    if hits["/private"] != 1 || hits["/robots.txt"] != 0 {
+        t.Errorf("robots.txt should not be obeyed: %v", hits)
+    }
+}
+
+func TestRetryBackoff(t *testing.T) {
+    var locker sync.Mutex
+    var times []time.Time
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            http.NotFound(w, r)
+            return
+        }
+        locker.Lock()
+        times = append(times, time.Now())
+        n := len(times)
+        locker.Unlock()
+        if n <= 3 {
+            w.WriteHeader(http.StatusServiceUnavailable)
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    s := scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+    sp := spider.NewSpider(pp, "backoff").CloseStrace().SetScheduler(s).SetRetryStatusCodes([]int{503})
+    sp.SetRetryTimes(3).SetRetryBackoff(20*time.Millisecond, 50*time.Millisecond)
+    sp.AddUrl(ts.URL, "text").Run()
+    if len(times) != 4 || len(pp.pages) != 1 || !pp.pages[0].IsSucc() {
+        t.Fatalf("request should be retried until success: %d", len(times))
+    }
+    if pp.pages[0].GetRequest().GetRetries() != 3 {
+        t.Error("retries should be saved in request")
+    }
+    // backoff is 20ms, 40ms and 50ms
+    for i, min := range []time.Duration{20, 40, 50} {
+        if d := times[i+1].Sub(times[i]); d < min*time.Millisecond {
+            t.Errorf("backoff %d should be at least %dms: %v", i, min, d)
+        }
+    }
+
+    // retry budget of the request
+    times = nil
+    pp = &testPageProcesser{}
+    sp = spider.NewSpider(pp, "backoff").CloseStrace().SetRetryStatusCodes([]int{503})
+    sp.SetRetryTimes(3).SetRetryBackoff(time.Millisecond, 0)
+    sp.AddRequest(request.NewRequest(ts.URL, "text").SetMaxRetries(1)).Run()
+    if len(times) != 2 || len(pp.pages) != 1 || pp.pages[0].IsSucc() {
+        t.Errorf("retries of request should be limited: %d", len(times))
+    }
+}
+
+func TestMetrics(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/robots.txt", "/missing":
+            http.NotFound(w, r)
+        default:
+            w.Write([]byte("hello"))
+        }
+    }))
+    defer ts.Close()
+
+    sp := spider.NewSpider(&testPageProcesser{}, "metrics").CloseStrace().SetRetryTimes(0)
+    sp.AddPipeline(pipeline.NewCollectPipelinePageItems())
+    sp.AddUrls([]string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/missing"}, "text").Run()
+
+    w := httptest.NewRecorder()
+    sp.GetMetrics().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+    body := w.Body.String()
+    host := strings.TrimPrefix(ts.URL, "http://")
+    for _, line := range []string{
+        `go_spider_pages_total{code="200"} 2`,
+        `go_spider_pages_total{code="404"} 1`,
+        `go_spider_bytes_total 29`,
+        `go_spider_errors_total{type="http_4xx"} 1`,
+        `go_spider_download_seconds_count{host="` + host + `"} 3`,
+        `go_spider_pipeline_seconds_count 3`,
+        `go_spider_queue_depth 0`,
+    } {
+        if !strings.Contains(body, line+"\n") {
+            t.Errorf("metrics should have %s:\n%s", line, body)
+        }
+    }
+}
+
+// The chainPageProcesser adds the next page of each page, so the links never end.
+type chainPageProcesser struct {
+    testPageProcesser
+}
+
+func (this *chainPageProcesser) Process(p *page.Page) {
+    this.testPageProcesser.Process(p)
+    p.AddTargetRequest(p.GetRequest().GetUrl()+"/next", "text")
+}
+
+func TestMaxDepth(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &chainPageProcesser{}
+    sp := spider.NewSpider(pp, "depth").CloseStrace().SetObeyRobots(false).SetMaxDepth(2)
+    sp.AddUrl(ts.URL, "text").Run()
+    if len(pp.pages) != 3 {
+        t.Fatalf("pages deeper than max depth should not be crawled: %d", len(pp.pages))
+    }
+    for _, p := range pp.pages {
+        if depth := strings.Count(p.GetRequest().GetUrl(), "/next"); p.GetRequest().GetDepth() != depth {
+            t.Errorf("depth of %s should be %d", p.GetRequest().GetUrl(), depth)
+        }
+    }
+
+    w := httptest.NewRecorder()
+    sp.GetMetrics().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+    if !strings.Contains(w.Body.String(), `go_spider_dropped_requests_total{reason="depth"} 1`+"\n") {
+        t.Errorf("dropped request should be counted:\n%s", w.Body.String())
+    }
+}
+
+func TestMaxParseDepth(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/deep.html":
+            w.Write([]byte("<html><body>" + strings.Repeat("<div>", 100) + "</body></html>"))
+        case "/deep.json":
+            w.Write([]byte(strings.Repeat("[", 100) + strings.Repeat("]", 100)))
+        case "/flat.json":
+            w.Write([]byte(`{"a":[1,2]}`))
+        default:
+            w.Write([]byte("<html><body><div>ok</div></body></html>"))
+        }
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    spider.NewSpider(pp, "parse_depth").CloseStrace().SetObeyRobots(false).SetMaxParseDepth(10).
+        AddUrl(ts.URL+"/flat.html", "html").AddUrl(ts.URL+"/deep.html", "html").
+        AddUrl(ts.URL+"/flat.json", "json").AddUrl(ts.URL+"/deep.json", "json").Run()
+    succ := make(map[string]bool)
+    for _, p := range pp.pages {
+        succ[strings.TrimPrefix(p.GetRequest().GetUrl(), ts.URL)] = p.IsSucc()
+    }
+    if len(succ) != 4 || !succ["/flat.html"] || succ["/deep.html"] || !succ["/flat.json"] || succ["/deep.json"] {
+        t.Errorf("pages nested deeper than max parse depth should be rejected: %v", succ)
+    }
+}
+
+func TestCrawlGraph(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    g := spider.NewCrawlGraph()
+    pp := &chainPageProcesser{}
+    spider.NewSpider(pp, "graph").CloseStrace().SetObeyRobots(false).SetMaxDepth(2).SetCrawlGraph(g).
+        AddUrl(ts.URL, "text").Run()
+    a, b, c := ts.URL+"/next", ts.URL+"/next/next", ts.URL+"/next/next/next"
+    want := []spider.CrawlEdge{{From: ts.URL, To: a}, {From: a, To: b}, {From: b, To: c, Dropped: "depth"}}
+    if edges := g.Edges(); !reflect.DeepEqual(edges, want) {
+        t.Fatalf("edges error: %v", edges)
+    }
+    if path := g.Path(b); !reflect.DeepEqual(path, []string{ts.URL, a, b}) {
+        t.Errorf("path error: %v", path)
+    }
+    if n, ok := g.Node(c); !ok || n.Crawled || n.Depth != 3 {
+        t.Errorf("dropped node error: %+v", n)
+    }
+    if n, _ := g.Node(b); !n.Crawled || n.StatusCode != 200 || n.Depth != 2 {
+        t.Errorf("crawled node error: %+v", n)
+    }
+    if depths := g.Depths(); !reflect.DeepEqual(depths, map[int]int{0: 1, 1: 1, 2: 1}) {
+        t.Errorf("depths error: %v", depths)
+    }
+
+    var buf strings.Builder
+    g.WriteEdgeList(&buf)
+    if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 3 || lines[2] != b+"\t"+c+"\tdepth" {
+        t.Errorf("edge list error:\n%s", buf.String())
+    }
+    buf.Reset()
+    g.WriteDot(&buf)
+    if !strings.HasPrefix(buf.String(), "digraph crawl {") || !strings.Contains(buf.String(), `"`+ts.URL+`" -> "`+a+`";`) {
+        t.Errorf("dot error:\n%s", buf.String())
+    }
+    buf.Reset()
+    g.WriteGraphML(&buf)
+    var doc struct {
+        Nodes []struct {
+            Id string `xml:"id,attr"`
+        } `xml:"graph>node"`
+        Edges []struct {
+            Source string `xml:"source,attr"`
+            Target string `xml:"target,attr"`
+        } `xml:"graph>edge"`
+    }
+    if err := xml.Unmarshal([]byte(buf.String()), &doc); err != nil || len(doc.Nodes) != 4 || len(doc.Edges) != 3 ||
+        doc.Edges[0].Source != doc.Nodes[0].Id {
+        t.Errorf("graphml error: %v\n%s", err, buf.String())
+    }
+}
+
+func TestUrlFilter(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    links := []string{ts.URL + "/a", ts.URL + "/b.jpg", ts.URL + "/private/c", "http://other.invalid/d"}
+    pp := &linkPageProcesser{links: links}
+    f := scheduler.NewUrlFilter().StayOnSeedDomains(true).Deny(`/private/`).DenyExtensions("jpg")
+    s := scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+    sp := spider.NewSpider(pp, "filter").CloseStrace().SetObeyRobots(false).SetScheduler(s).SetUrlFilter(f)
+    sp.AddUrl(ts.URL, "text").Run()
+    if len(pp.pages) != 2 {
+        t.Fatalf("only seed and allowed link should be crawled: %d", len(pp.pages))
+    }
+    for _, p := range pp.pages {
+        if u := p.GetRequest().GetUrl(); u != ts.URL && u != links[0] {
+            t.Errorf("%s should be filtered", u)
+        }
+    }
+}
+
+func TestSkipNotModified(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("If-None-Match") == `"v1"` {
+            w.WriteHeader(http.StatusNotModified)
+            return
+        }
+        w.Header().Set("ETag", `"v1"`)
+        w.Write([]byte("hello"))
+    }))
+    defer ts.Close()
+
+    store := downloader.NewValidatorMap()
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "not_modified").CloseStrace().SetObeyRobots(false).
+        SetValidatorStore(store).SetSkipNotModified(true)
+    sp.AddUrl(ts.URL, "text").Run()
+    sp.AddUrl(ts.URL, "text").Run()
+    if len(pp.pages) != 1 {
+        t.Errorf("not modified page should not be processed: %d", len(pp.pages))
+    }
+}
+
+// The countPageProcesser stops the spider after n pages.
+type countPageProcesser struct {
+    testPageProcesser
+    n  int
+    sp *spider.Spider
+}
+
+func (this *countPageProcesser) Process(p *page.Page) {
+    this.testPageProcesser.Process(p)
+    this.locker.Lock()
+    if len(this.pages) >= this.n {
+        this.sp.Stop()
+    }
+    this.locker.Unlock()
+}
+
+func TestRevisit(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &countPageProcesser{n: 3}
+    s := scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+    pp.sp = spider.NewSpider(pp, "revisit").CloseStrace().SetObeyRobots(false).SetScheduler(s).
+        SetRevisit(spider.NewRevisit(20 * time.Millisecond))
+    start := time.Now()
+    pp.sp.AddUrl(ts.URL, "text").Run()
+    if len(pp.pages) != 3 {
+        t.Fatalf("page should be revisited until stopped: %d", len(pp.pages))
+    }
+    if d := time.Since(start); d < 40*time.Millisecond {
+        t.Errorf("page should be revisited after interval: %v", d)
+    }
+}
+
+// The flushPipeline buffers results until Flush is called.
+type flushPipeline struct {
+    locker   sync.Mutex
+    buffered int
+    flushed  int
+}
+
+func (this *flushPipeline) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    this.locker.Lock()
+    this.buffered++
+    this.locker.Unlock()
+}
+
+func (this *flushPipeline) Flush() {
+    this.locker.Lock()
+    this.flushed += this.buffered
+    this.buffered = 0
+    this.locker.Unlock()
+}
+
+func TestShutdown(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        time.Sleep(100 * time.Millisecond)
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pip := &flushPipeline{}
+    sp := spider.NewSpider(&testPageProcesser{}, "shutdown").CloseStrace().AddPipeline(pip)
+    sp.AddUrls([]string{ts.URL + "/a", ts.URL + "/b", ts.URL + "/c"}, "text")
+    go sp.Run()
+
+    time.Sleep(50 * time.Millisecond)
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := sp.Shutdown(ctx); err != nil {
+        t.Fatal(err)
+    }
+    pip.locker.Lock()
+    defer pip.locker.Unlock()
+    if pip.flushed != 1 || pip.buffered != 0 {
+        t.Errorf("the page being crawled should be done and flushed: %d %d", pip.flushed, pip.buffered)
+    }
+    if err := sp.Shutdown(ctx); err != nil {
+        t.Errorf("shutdown of stopped spider should return at once: %v", err)
+    }
+}
+
+func TestFormLogin(t *testing.T) {
+    var locker sync.Mutex
+    logins := 0
+    session := ""
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        defer locker.Unlock()
+        switch {
+        case r.URL.Path == "/login" && r.Method == "GET":
+            w.Write([]byte(`<form method="post"><input type="hidden" name="csrf" value="t` + strconv.Itoa(logins) +
+                `"><input name="user"><input type="password" name="password"></form>`))
+        case r.URL.Path == "/login":
+            if r.FormValue("csrf") != "t"+strconv.Itoa(logins) || r.FormValue("password") != "p" {
+                w.Write([]byte("wrong password"))
+                return
+            }
+            logins++
+            session = "s" + strconv.Itoa(logins)
+            http.SetCookie(w, &http.Cookie{Name: "session", Value: session, Path: "/"})
+            w.Write([]byte("welcome"))
+        default:
+            if c, err := r.Cookie("session"); err != nil || c.Value != session {
+                http.Redirect(w, r, "/login", http.StatusFound)
+                return
+            }
+            // the session expires after each page
+            session = ""
+            w.Write([]byte("secret"))
+        }
+    }))
+    defer ts.Close()
+
+    welcome := func(body string) bool { return body == "welcome" }
+    pp := &testPageProcesser{}
+    auth := spider.NewFormLogin(ts.URL+"/login", map[string]string{"user": "u", "password": "p"}).SetCheck(welcome)
+    spider.NewSpider(pp, "formlogin").CloseStrace().SetAuthenticator(auth).
+        AddUrls([]string{ts.URL + "/a", ts.URL + "/b"}, "text").Run()
+    if len(pp.pages) != 2 || pp.pages[0].GetBodyStr() != "secret" || pp.pages[1].GetBodyStr() != "secret" || logins != 2 {
+        t.Errorf("spider should login again when the session is expired: %d logins", logins)
+    }
+
+    pp = &testPageProcesser{}
+    auth = spider.NewFormLogin(ts.URL+"/login", map[string]string{"user": "u", "password": "x"}).SetCheck(welcome)
+    spider.NewSpider(pp, "formlogin").CloseStrace().SetAuthenticator(auth).AddUrl(ts.URL+"/a", "text").Run()
+    if len(pp.pages) != 0 {
+        t.Error("spider should not crawl after login failed")
+    }
+}
+
+func TestDigestAuth(t *testing.T) {
+    h := func(s string) string {
+        sum := md5.Sum([]byte(s))
+        return hex.EncodeToString(sum[:])
+    }
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        auth := r.Header.Get("Authorization")
+        params := make(map[string]string)
+        for _, kv := range strings.Split(strings.TrimPrefix(auth, "Digest "), ", ") {
+            if i := strings.Index(kv, "="); i > 0 {
+                params[kv[:i]] = strings.Trim(kv[i+1:], `"`)
+            }
+        }
+        ha1 := h("u:test:p")
+        ha2 := h(r.Method + ":" + r.URL.RequestURI())
+        if params["response"] != h(ha1+":n1:"+params["nc"]+":"+params["cnonce"]+":auth:"+ha2) || params["opaque"] != "o" {
+            w.Header().Set("WWW-Authenticate", `Digest realm="test", nonce="n1", qop="auth", opaque="o"`)
+            w.WriteHeader(http.StatusUnauthorized)
+            return
+        }
+        w.Write([]byte("secret"))
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    spider.NewSpider(pp, "digest").CloseStrace().SetAuthenticator(spider.NewDigestAuth(ts.URL+"/", "u", "p")).
+        AddUrls([]string{ts.URL + "/a?x=1", ts.URL + "/b"}, "text").Run()
+    if len(pp.pages) != 2 || pp.pages[0].GetBodyStr() != "secret" || pp.pages[1].GetBodyStr() != "secret" {
+        t.Error("requests should be sent with digest authorization")
+    }
+}
+
+func TestLanguages(t *testing.T) {
+    pages := map[string]string{
+        "/en":  `<html><body><p>The spider follows the links of this page, which is written in english.</p><a href="/en2">en</a><a href="/zh">zh</a><a href="/fr">fr</a></body></html>`,
+        "/en2": `<html><body><p>This is another page of the site, and it is written in english too.</p></body></html>`,
+        "/zh":  `<html><body><p>网络爬虫按照一定的规则自动抓取万维网上的信息，并把数据保存到数据库中。</p><a href="/zh2">zh</a></body></html>`,
+        "/zh2": `<html><body><p>这个页面不应该被抓取。</p></body></html>`,
+        "/fr":  `<html lang="fr-FR"><body><p>Bonjour</p></body></html>`,
+    }
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(pages[r.URL.Path]))
+    }))
+    defer ts.Close()
+
+    var locker sync.Mutex
+    processed := make(map[string]string)
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        u, _ := url.Parse(p.GetRequest().GetUrl())
+        locker.Lock()
+        processed[u.Path] = p.GetLanguage()
+        locker.Unlock()
+        for _, link := range p.GetLinks() {
+            p.AddTargetRequest(link, "html")
+        }
+    })
+    spider.NewSpider(pp, "languages").CloseStrace().SetObeyRobots(false).SetLanguages("en", "fr-FR").
+        AddUrl(ts.URL+"/en", "html").Run()
+
+    expected := map[string]string{"/en": "en", "/en2": "en", "/fr": "fr"}
+    if !reflect.DeepEqual(processed, expected) {
+        t.Errorf("only pages of the languages should be processed: %v", processed)
+    }
+}
+
+func TestContentDeduplicator(t *testing.T) {
+    text := strings.Repeat("go spider is a concurrent crawler framework written in golang with pluggable modules ", 5)
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/a", "/mirror/a":
+            w.Write([]byte("<p>" + text + "</p>"))
+        case "/a/ad":
+            w.Write([]byte("<p>" + text + "</p><script>var ad = 1;</script><div>advertisement</div>"))
+        default:
+            w.Write([]byte("<p>the quick brown fox jumps over the lazy dog near the river bank in the morning</p>"))
+        }
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "contentdedup").CloseStrace().
+        SetContentDeduplicator(spider.NewContentHashDeduplicator(nil))
+    sp.AddUrls([]string{ts.URL + "/a", ts.URL + "/mirror/a", ts.URL + "/a/ad", ts.URL + "/b"}, "html").Run()
+    if len(pp.pages) != 3 {
+        t.Errorf("page of the same content should not be processed: %d", len(pp.pages))
+    }
+
+    pp = &testPageProcesser{}
+    sp = spider.NewSpider(pp, "simhash").CloseStrace().SetContentDeduplicator(spider.NewSimHashDeduplicator(3))
+    sp.AddUrls([]string{ts.URL + "/a", ts.URL + "/a/ad", ts.URL + "/b"}, "html").Run()
+    if len(pp.pages) != 2 {
+        t.Errorf("page of nearly the same content should not be processed: %d", len(pp.pages))
+    }
+}
+
+func TestBudget(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("<p>" + r.URL.String() + "</p>"))
+    }))
+    defer ts.Close()
+    local := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+
+    pp := &testPageProcesser{}
+    budget := spider.NewBudget(2).SetDomainLimit("localhost", 5).AddPattern(`/search\?`, 1)
+    sp := spider.NewSpider(pp, "budget").CloseStrace().SetThreadnum(1).SetBudget(budget)
+    sp.AddUrls([]string{ts.URL + "/1", ts.URL + "/2", ts.URL + "/3", ts.URL + "/4",
+        local + "/1", local + "/search?q=a", local + "/search?q=b"}, "html").Run()
+    if len(pp.pages) != 4 {
+        t.Errorf("pages over budget should not be crawled: %d", len(pp.pages))
+    }
+    counts := budget.Counts()
+    if counts["127.0.0.1"] != 2 || counts["localhost"] != 2 {
+        t.Errorf("wrong counts: %v", counts)
+    }
+
+    w := httptest.NewRecorder()
+    sp.GetMetrics().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+    for _, line := range []string{`go_spider_budget_pages_total{domain="127.0.0.1"} 2`,
+        `go_spider_dropped_requests_total{reason="budget"} 3`} {
+        if !strings.Contains(w.Body.String(), line) {
+            t.Errorf("metrics should have %s:\n%s", line, w.Body.String())
+        }
+    }
+}
+
+func TestAutoThrottle(t *testing.T) {
+    var locker sync.Mutex
+    hits := make(map[string]time.Time)
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        hits[r.URL.Path] = time.Now()
+        locker.Unlock()
+        if strings.HasPrefix(r.URL.Path, "/captcha") {
+            w.Write([]byte("<p>please solve the captcha</p>"))
+            return
+        }
+        w.Write([]byte("<p>ok</p>"))
+    }))
+    defer ts.Close()
+
+    throttle := spider.NewAutoThrottle(0, 100*time.Millisecond).AddBanSignature("(?i)captcha").
+        SetCoolOff(2, 300*time.Millisecond)
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "autothrottle").CloseStrace().SetThreadnum(1).SetAutoThrottle(throttle)
+    sp.AddUrls([]string{ts.URL + "/captcha1", ts.URL + "/captcha2", ts.URL + "/ok"}, "html").Run()
+    if len(pp.pages) != 3 {
+        t.Fatalf("requests of paused host should be crawled after cool-off: %d", len(pp.pages))
+    }
+    if d := hits["/ok"].Sub(hits["/captcha2"]); d < 300*time.Millisecond {
+        t.Errorf("host should be paused after ban signals: %v", d)
+    }
+    if d := throttle.Delay(ts.URL); d <= 0 || d > 100*time.Millisecond {
+        t.Errorf("delay should be raised by ban signals within max delay: %v", d)
+    }
+}
+
+func TestDashboard(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/missing" {
+            w.WriteHeader(http.StatusNotFound)
+        }
+        time.Sleep(20 * time.Millisecond)
+        w.Write([]byte("<p>ok</p>"))
+    }))
+    defer ts.Close()
+
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "dashboard").CloseStrace().SetThreadnum(1).SetExitWhenComplete(false)
+    sp.AddUrl(ts.URL+"/missing", "html")
+    for i := 0; i < 20; i++ {
+        sp.AddUrl(ts.URL+"/"+strconv.Itoa(i), "html")
+    }
+    done := make(chan struct{})
+    go func() {
+        sp.Run()
+        close(done)
+    }()
+    dashboard := httptest.NewServer(sp.Dashboard())
+    defer dashboard.Close()
+
+    resp, err := http.Get(dashboard.URL + "/pause")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusMethodNotAllowed {
+        t.Errorf("control should need POST: %d", resp.StatusCode)
+    }
+    // pages of other sites can not control the spider by simple requests
+    for _, contentType := range []string{"text/plain", "application/x-www-form-urlencoded", ""} {
+        req, _ := http.NewRequest("POST", dashboard.URL+"/stop", strings.NewReader(`{}`))
+        req.Header.Set("Content-Type", contentType)
+        req.Header.Set("Origin", "http://evil.example")
+        if resp, err = http.DefaultClient.Do(req); err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode == http.StatusOK {
+            t.Errorf("cross-origin %s request should be refused", contentType)
+        }
+        req, _ = http.NewRequest("POST", dashboard.URL+"/stop", strings.NewReader(`{}`))
+        req.Header.Set("Content-Type", contentType)
+        if resp, err = http.DefaultClient.Do(req); err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusUnsupportedMediaType {
+            t.Errorf("%s request should be refused: %d", contentType, resp.StatusCode)
+        }
+    }
+    req, _ := http.NewRequest("POST", dashboard.URL+"/stop", strings.NewReader(`{}`))
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Origin", "http://evil.example")
+    if resp, err = http.DefaultClient.Do(req); err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusForbidden || sp.Status().Stopped {
+        t.Errorf("cross-origin json request should be refused: %d", resp.StatusCode)
+    }
+
+    // with the token
+    sp.SetDashboardToken("s3cret")
+    resp, err = http.Post(dashboard.URL+"/threadnum?n=4", "application/json", nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusUnauthorized || sp.GetThreadnum() == 4 {
+        t.Errorf("request without token should be refused: %d", resp.StatusCode)
+    }
+    req, _ = http.NewRequest("POST", dashboard.URL+"/threadnum?n=4", nil)
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("Authorization", "Bearer s3cret")
+    req.Header.Set("Origin", dashboard.URL)
+    if resp, err = http.DefaultClient.Do(req); err != nil {
+        t.Fatal(err)
+    }
+    var status spider.Status
+    err = json.NewDecoder(resp.Body).Decode(&status)
+    resp.Body.Close()
+    if err != nil || status.Threadnum != 4 || !status.Running || status.Taskname != "dashboard" {
+        t.Errorf("wrong status: %+v %v", status, err)
+    }
+
+    time.Sleep(200 * time.Millisecond)
+    if resp, err = http.Post(dashboard.URL+"/stop?token=s3cret", "application/json", nil); err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    select {
+    case <-done:
+    case <-time.After(5 * time.Second):
+        t.Fatal("spider should be stopped by dashboard")
+    }
+    status = sp.Status()
+    if status.Running || status.Pages == 0 || status.Errors != 1 ||
+        len(status.RecentErrors) != 1 || status.RecentErrors[0].StatusCode != http.StatusNotFound ||
+        len(status.Hosts) != 1 {
+        t.Errorf("wrong status: %+v", status)
+    }
+}
+
+// The hrefPageProcesser adds links of all the pages.
+type hrefPageProcesser struct {
+    testPageProcesser
+}
+
+func (this *hrefPageProcesser) Process(p *page.Page) {
+    this.testPageProcesser.Process(p)
+    p.AddTargetRequests(p.GetLinks(), "html")
+}
+
+func TestNewFromConfig(t *testing.T) {
+    var locker sync.Mutex
+    var agents, tokens []string
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        agents = append(agents, r.UserAgent())
+        tokens = append(tokens, r.Header.Get("X-Token"))
+        locker.Unlock()
+        w.Write([]byte(`<a href="/a">a</a><a href="/private/b">b</a>`))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "config")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    configs := map[string]string{
+        "crawl.yaml": `
+taskname: yaml
+processor: config_test
+seeds: ["` + ts.URL + `/"]
+threadnum: 2
+max_depth: 1
+obey_robots: false
+timeouts:
+    total: 5s
+random_delay:
+    max: 1ms
+headers:
+    X-Token: secret
+user_agents: ["config agent"]
+filter:
+    deny: ["/private/"]
+pipelines:
+    - type: file
+      params:
+          path: ` + filepath.Join(dir, "yaml.txt") + `
+    - type: jsonl
+      params:
+          path: ` + filepath.Join(dir, "yaml.jsonl") + `
+          rotate_size: 1048576
+`,
+        "crawl.toml": `
+taskname = "toml"
+processor = "config_test"
+seeds = ["` + ts.URL + `/"]
+threadnum = 2
+max_depth = 1
+obey_robots = false
+user_agents = ["config agent"]
+
+[timeouts]
+total = "5s"
+
+[headers]
+X-Token = "secret"
+
+[filter]
+deny = ["/private/"]
+
+[[pipelines]]
+type = "file"
+[pipelines.params]
+path = "` + filepath.Join(dir, "toml.txt") + `"
+`,
+    }
+
+    pp := &hrefPageProcesser{}
+    spider.RegisterPageProcesser("config_test", pp)
+    for name, content := range configs {
+        path := filepath.Join(dir, name)
+        if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+        pp.pages = nil
+        agents, tokens = nil, nil
+        sp, err := spider.NewFromConfig(path)
+        if err != nil {
+            t.Fatal(name, err)
+        }
+        if sp.GetThreadnum() != 2 || sp.Taskname() != filepath.Ext(name)[1:] {
+            t.Errorf("%s: wrong settings", name)
+        }
+        sp.CloseStrace().Run()
+        if len(pp.pages) != 2 {
+            t.Errorf("%s: denied and deep urls should not be crawled: %d", name, len(pp.pages))
+        }
+        for i := range agents {
+            if agents[i] != "config agent" || tokens[i] != "secret" {
+                t.Errorf("%s: headers are not sent: %q %q", name, agents[i], tokens[i])
+            }
+        }
+        if _, err := os.Stat(filepath.Join(dir, sp.Taskname()+".txt")); err != nil {
+            t.Errorf("%s: pipeline is not added: %v", name, err)
+        }
+    }
+    if data, err := ioutil.ReadFile(filepath.Join(dir, "yaml.jsonl")); err != nil || strings.Count(string(data), "\n") != 2 {
+        t.Errorf("jsonl pipeline is not added: %v %q", err, data)
+    }
+
+    path := filepath.Join(dir, "bad.yaml")
+    ioutil.WriteFile(path, []byte("processor: missing\n"), 0644)
+    if _, err := spider.NewFromConfig(path); err == nil {
+        t.Error("config of unregistered processor should fail")
+    }
+}
+
+type contextKey string
+
+func TestRunContext(t *testing.T) {
+    var slow int32
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/slow" {
+            atomic.AddInt32(&slow, 1)
+            select {
+            case <-r.Context().Done():
+            case <-time.After(5 * time.Second):
+            }
+            return
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    var failed int32
+    proc := &testPageProcesser{}
+    sp := spider.NewSpider(proc, "context").CloseStrace().SetRetryTimes(3).
+        SetFailedRequestHandler(func(*request.Request, error) { atomic.AddInt32(&failed, 1) })
+    ctx, cancel := context.WithCancel(context.Background())
+    reqCtx := context.WithValue(context.Background(), contextKey("k"), "v")
+    sp.AddRequest(request.NewRequest(ts.URL+"/fast", "text").SetContext(reqCtx))
+    sp.AddRequest(request.NewRequest(ts.URL+"/slow", "text"))
+
+    time.AfterFunc(200*time.Millisecond, cancel)
+    start := time.Now()
+    sp.RunContext(ctx)
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Errorf("download should be canceled with the context: %v", elapsed)
+    }
+    if n := atomic.LoadInt32(&slow); n != 1 {
+        t.Errorf("canceled request should not be retried: %d", n)
+    }
+    if n := atomic.LoadInt32(&failed); n != 0 {
+        t.Errorf("canceled request should not be handled as failed: %d", n)
+    }
+    proc.locker.Lock()
+    defer proc.locker.Unlock()
+    found := false
+    for _, p := range proc.pages {
+        if p.GetRequest().GetUrl() == ts.URL+"/fast" {
+            found = p.Context().Value(contextKey("k")) == "v"
+        }
+    }
+    if !found {
+        t.Error("value of request context should be visible by page")
+    }
+}
+
+type fieldLogger struct {
+    testLogger
+    entries []map[string]interface{}
+}
+
+func (this *fieldLogger) LogFields(level mlog.Level, msg string, fields []mlog.Field) {
+    entry := map[string]interface{}{"level": level, "msg": msg}
+    for _, f := range fields {
+        entry[f.Key] = f.Value
+    }
+    this.locker.Lock()
+    this.entries = append(this.entries, entry)
+    this.locker.Unlock()
+}
+
+func TestSetLogLevel(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    l := &fieldLogger{}
+    sp := spider.NewSpider(&testPageProcesser{}, "loglevel").CloseStrace().SetLogger(l)
+    defer sp.SetLogger(nil)
+    sp.AddUrl(ts.URL+"/a", "text").Run()
+    if len(l.entries) != 1 || l.entries[0]["msg"] != "download" || l.entries[0]["component"] != "spider" ||
+        l.entries[0]["status"] != 200 || l.entries[0]["url"] != ts.URL+"/a" {
+        t.Fatalf("download should be logged with fields: %v", l.entries)
+    }
+
+    sp.SetLogLevel("spider", mlog.LevelInfo)
+    defer sp.SetLogLevel("spider", mlog.LevelDebug)
+    sp.AddUrl(ts.URL+"/b", "text").Run()
+    if len(l.entries) != 1 {
+        t.Errorf("debug logs of spider should be dropped: %v", l.entries)
+    }
+}
+
+func TestResponseValidatorRules(t *testing.T) {
+    var hits int32
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch atomic.AddInt32(&hits, 1) {
+        case 1:
+            w.Write([]byte(`<html><body>please input the Captcha to continue ...</body></html>`))
+        case 2:
+            w.Write([]byte(`<p>x</p>`))
+        case 3:
+            w.Write([]byte(`<html><body><div id="other">not the page you want</div></body></html>`))
+        case 4:
+            w.WriteHeader(http.StatusAccepted)
+            w.Write([]byte(`<html><body><div id="content">accepted but not ok</div></body></html>`))
+        default:
+            w.Write([]byte(`<html><body><div id="content">the real content</div></body></html>`))
+        }
+    }))
+    defer ts.Close()
+
+    v := spider.NewResponseValidator().SetStatusCodes(200).SetMinBodySize(60).RequireSelector("#content").
+        AddBanMarker("(?i)captcha")
+    var reasons []string
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "validator rules").CloseStrace().SetObeyRobots(false).SetRetryTimes(5)
+    sp.SetResponseValidator(func(p *page.Page) bool {
+        if reason := v.Check(p); reason != "" {
+            reasons = append(reasons, reason)
+            return false
+        }
+        return true
+    })
+    sp.AddUrl(ts.URL, "html").Run()
+    if len(reasons) != 4 || reasons[0] != "ban marker (?i)captcha" || !strings.HasPrefix(reasons[1], "body of ") ||
+        reasons[2] != "no element of #content" || reasons[3] != "status 202" {
+        t.Errorf("wrong reasons: %v", reasons)
+    }
+    if len(pp.pages) != 1 || !strings.Contains(pp.pages[0].GetBodyStr(), "real content") {
+        t.Error("only the good page should be processed")
+    }
+}
+
+func TestThreadnumPerHost(t *testing.T) {
+    var locker sync.Mutex
+    var total, maxTotal int
+    newServer := func(maxHost *int) *httptest.Server {
+        var current int
+        return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            locker.Lock()
+            current++
+            total++
+            if current > *maxHost {
+                *maxHost = current
+            }
+            if total > maxTotal {
+                maxTotal = total
+            }
+            locker.Unlock()
+            time.Sleep(20 * time.Millisecond)
+            locker.Lock()
+            current--
+            total--
+            locker.Unlock()
+            w.Write([]byte("ok"))
+        }))
+    }
+    var maxA, maxB int
+    a, b := newServer(&maxA), newServer(&maxB)
+    defer a.Close()
+    defer b.Close()
+
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "per_host").CloseStrace().SetObeyRobots(false).SetThreadnum(4).SetThreadnumPerHost(1)
+    for i := 0; i < 4; i++ {
+        sp.AddUrl(a.URL+"/"+strconv.Itoa(i), "text")
+    }
+    for i := 0; i < 4; i++ {
+        sp.AddUrl(b.URL+"/"+strconv.Itoa(i), "text")
+    }
+    sp.Run()
+    if len(pp.pages) != 8 {
+        t.Fatalf("all the pages should be crawled: %d", len(pp.pages))
+    }
+    if maxA != 1 || maxB != 1 {
+        t.Errorf("concurrent requests of each host should be 1: %d %d", maxA, maxB)
+    }
+    if maxTotal != 2 {
+        t.Errorf("hosts should be crawled at once: %d", maxTotal)
+    }
+}
+
+func TestHostPartition(t *testing.T) {
+    var locker sync.Mutex
+    current := make(map[string]int)
+    maxCurrent := make(map[string]int)
+    handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        current[r.Host]++
+        if current[r.Host] > maxCurrent[r.Host] {
+            maxCurrent[r.Host] = current[r.Host]
+        }
+        locker.Unlock()
+        time.Sleep(5 * time.Millisecond)
+        locker.Lock()
+        current[r.Host]--
+        locker.Unlock()
+        w.Write([]byte("ok"))
+    })
+
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "partition").CloseStrace().SetObeyRobots(false).SetThreadnum(3).SetHostPartition(true)
+    for i := 0; i < 3; i++ {
+        ts := httptest.NewServer(handler)
+        defer ts.Close()
+        // "localhost" and "127.0.0.1" are different hosts of the same server
+        for _, base := range []string{ts.URL, strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)} {
+            for j := 0; j < 4; j++ {
+                sp.AddUrl(base+"/"+strconv.Itoa(j), "text")
+            }
+        }
+    }
+    sp.Run()
+    if len(pp.pages) != 24 {
+        t.Fatalf("all the pages should be crawled: %d", len(pp.pages))
+    }
+    if len(maxCurrent) != 6 {
+        t.Fatalf("hosts error: %v", maxCurrent)
+    }
+    for host, n := range maxCurrent {
+        if n != 1 {
+            t.Errorf("host %s should be crawled by one worker: %d", host, n)
+        }
+    }
+}
+
+func TestStatsReport(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/missing" {
+            w.WriteHeader(http.StatusNotFound)
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+    dir, err := ioutil.TempDir("", "go_spider_stats")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    // statistics of previous runs saved in checkpoint are added
+    cp := filepath.Join(dir, "checkpoint.json")
+    ioutil.WriteFile(cp, []byte(`{"version":1,"requests":[],"stats":{"runs":1,"seconds":10,"pages":3,"pages_ok":3,"status_codes":{"200":3}}}`), 0644)
+    report := filepath.Join(dir, "report", "stats.json")
+    sp := spider.NewSpider(&testPageProcesser{}, "stats").CloseStrace().SetObeyRobots(false).SetRetryTimes(0).
+        AddPipeline(pipeline.NewCollectPipelinePageItems()).SetStatsReport(report)
+    if err = sp.LoadCheckpoint(cp); err != nil {
+        t.Fatal(err)
+    }
+    sp.AddUrls([]string{ts.URL + "/ok", ts.URL + "/missing"}, "text").Run()
+
+    content, err := ioutil.ReadFile(report)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var stats spider.Stats
+    if err = json.Unmarshal(content, &stats); err != nil {
+        t.Fatal(err)
+    }
+    if stats.Taskname != "stats" || !stats.Complete || stats.Runs != 2 || stats.Seconds < 10 {
+        t.Errorf("run stats error: %+v", stats)
+    }
+    if stats.Pages != 5 || stats.PagesOk != 4 || stats.PagesFailed != 1 || stats.StatusCodes[200] != 4 || stats.StatusCodes[404] != 1 {
+        t.Errorf("page stats error: %+v", stats)
+    }
+    if len(stats.TopErrors) != 1 || stats.TopErrors[0].Reason != "http_4xx: 404 Not Found" || stats.TopErrors[0].Count != 1 {
+        t.Errorf("top errors error: %+v", stats.TopErrors)
+    }
+    if stats.Items["*pipeline.CollectPipelinePageItems"] != 2 {
+        t.Errorf("items error: %v", stats.Items)
+    }
+    if last := sp.GetStats(); last.Pages != stats.Pages {
+        t.Errorf("stats of the last run error: %+v", last)
+    }
+}
+
+func TestHostBackoff(t *testing.T) {
+    var locker sync.Mutex
+    var hits []string
+    times := make(map[string]time.Time)
+    handler := func(name string) http.Handler {
+        return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+            locker.Lock()
+            key := name + r.URL.Path
+            _, seen := times[key]
+            hits = append(hits, key)
+            times[key] = time.Now()
+            locker.Unlock()
+            if key == "a/1" && !seen {
+                // larger than max of backoff
+                w.Header().Set("Retry-After", "3600")
+                w.WriteHeader(http.StatusTooManyRequests)
+            }
+            w.Write([]byte("ok"))
+        })
+    }
+    a, b := httptest.NewServer(handler("a")), httptest.NewServer(handler("b"))
+    defer a.Close()
+    defer b.Close()
+
+    pp := &testPageProcesser{}
+    start := time.Now()
+    spider.NewSpider(pp, "backoff").CloseStrace().SetObeyRobots(false).SetThreadnum(1).SetRetryTimes(1).
+        SetRetryBackoff(time.Millisecond, time.Millisecond).SetHostBackoff(0, 150*time.Millisecond).
+        AddUrls([]string{a.URL + "/1", a.URL + "/2", b.URL + "/1"}, "text").Run()
+    locker.Lock()
+    defer locker.Unlock()
+
+    if len(pp.pages) != 3 {
+        t.Fatalf("all the pages should be crawled: %d", len(pp.pages))
+    }
+    for _, p := range pp.pages {
+        if p.GetStatusCode() != http.StatusOK {
+            t.Errorf("429 should be retried: %s", p.GetRequest().GetUrl())
+        }
+    }
+    if len(hits) != 4 || hits[0] != "a/1" || hits[1] != "b/1" {
+        t.Fatalf("requests of other hosts should go on while the host is backed off: %v", hits)
+    }
+    if d := times["a/2"].Sub(start); d < 140*time.Millisecond || d > 2*time.Second {
+        t.Errorf("host should be backed off for the max delay: %v", d)
+    }
+}
+
+type metaPageProcesser struct {
+    testPageProcesser
+}
+
+func (this *metaPageProcesser) Process(p *page.Page) {
+    this.testPageProcesser.Process(p)
+    req := p.GetRequest()
+    switch {
+    case strings.HasSuffix(req.GetUrl(), "/list"):
+        p.AddTargetRequest(req.GetUrl()+"/detail", "text")
+        p.AddTargetRequestWithParams(request.NewRequest(req.GetUrl()+"/sale", "text").SetMeta("category", "sale"))
+    case strings.HasSuffix(req.GetUrl(), "/detail"):
+        p.AddTargetRequest(req.GetUrl()+"/review", "text")
+    }
+}
+
+func TestInheritMeta(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := &metaPageProcesser{}
+    sp := spider.NewSpider(pp, "inherit_meta").CloseStrace().SetObeyRobots(false).SetInheritMeta(true, "category")
+    sp.AddRequest(request.NewRequest(ts.URL+"/list", "text").SetMeta("category", "shoes").SetMeta("page", 1)).Run()
+    if len(pp.pages) != 4 {
+        t.Fatalf("all the pages should be crawled: %d", len(pp.pages))
+    }
+    expected := map[string]string{"/list": "shoes", "/list/detail": "shoes", "/list/detail/review": "shoes",
+        "/list/sale": "sale"}
+    for _, p := range pp.pages {
+        path := strings.TrimPrefix(p.GetRequest().GetUrl(), ts.URL)
+        if category, _ := p.GetRequest().GetMeta("category"); category != expected[path] {
+            t.Errorf("category of %s should be %s: %v", path, expected[path], category)
+        }
+        if _, ok := p.GetRequest().GetMeta("page"); ok && path != "/list" {
+            t.Errorf("meta not in the keys should not be inherited by %s", path)
+        }
+    }
+}
+
+func TestConfigReload(t *testing.T) {
+    var locker sync.Mutex
+    tokens := make(map[string]string)
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        tokens[r.URL.Path] = r.Header.Get("X-Token")
+        locker.Unlock()
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    old, pp := &testPageProcesser{}, &testPageProcesser{}
+    spider.RegisterPageProcesser("reload_test", pp)
+    sp := spider.NewSpider(old, "reload").CloseStrace().SetObeyRobots(false).SetExitWhenComplete(false)
+    done := make(chan struct{})
+    go func() {
+        sp.Run()
+        close(done)
+    }()
+    dashboard := httptest.NewServer(sp.Dashboard())
+    defer dashboard.Close()
+
+    resp, err := http.Post(dashboard.URL+"/config", "application/yaml", strings.NewReader("processor: missing\n"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusBadRequest || sp.GetPageProcesser() != old {
+        t.Errorf("config of unregistered processor should not be reloaded: %d", resp.StatusCode)
+    }
+    config := "processor: reload_test\nthreadnum: 3\nheaders:\n    X-Token: secret\nfilter:\n    deny: [\"/private/\"]\n"
+    if resp, err = http.Post(dashboard.URL+"/config", "application/yaml", strings.NewReader(config)); err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || sp.GetThreadnum() != 3 {
+        t.Fatalf("config is not reloaded: %d", resp.StatusCode)
+    }
+    sp.AddUrl(ts.URL+"/private/a", "text").AddUrl(ts.URL+"/public/a", "text")
+    for i := 0; i < 100 && sp.Status().Pages < 1; i++ {
+        time.Sleep(10 * time.Millisecond)
+    }
+    time.Sleep(50 * time.Millisecond)
+    locker.Lock()
+    if len(tokens) != 1 || tokens["/public/a"] != "secret" {
+        t.Errorf("new filter and headers should be used: %v", tokens)
+    }
+    locker.Unlock()
+    pp.locker.Lock()
+    if len(pp.pages) != 1 || len(old.pages) != 0 {
+        t.Errorf("new processer should be used: %d %d", len(pp.pages), len(old.pages))
+    }
+    pp.locker.Unlock()
+
+    dir, err := ioutil.TempDir("", "reload")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "crawl.yaml")
+    ioutil.WriteFile(path, []byte(config), 0644)
+    stop := sp.WatchConfig(path, 10*time.Millisecond)
+    defer stop()
+    ioutil.WriteFile(path, []byte("processor: reload_test\nmax_depth: 2\nfilter:\n    deny: [\"/public/\"]\n"), 0644)
+    for i := 0; i < 100 && sp.GetMaxDepth() != 2; i++ {
+        time.Sleep(10 * time.Millisecond)
+    }
+    if sp.GetMaxDepth() != 2 || sp.GetUrlFilter().Allowed(request.NewRequest(ts.URL+"/public/b", "text")) {
+        t.Error("config file should be reloaded")
+    }
+    sp.Stop()
+    <-done
+}
+
+func TestRouterPageProcesser(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    list, detail := &testPageProcesser{}, &testPageProcesser{}
+    var api []string
+    var locker sync.Mutex
+    router := page_processer.NewRouterPageProcesser().
+        HandleTag("detail", detail).
+        HandleFunc(`/api/`, func(p *page.Page) {
+            locker.Lock()
+            api = append(api, p.GetRequest().GetUrl())
+            locker.Unlock()
+        }).
+        SetDefault(page_processer.PageProcesserFunc(func(p *page.Page) {
+            list.Process(p)
+            p.AddTargetRequestsWithTag([]string{ts.URL + "/item/1", ts.URL + "/item/2"}, "text", "detail")
+            p.AddTargetRequest(ts.URL+"/api/items", "text")
+        }))
+    s := scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+    spider.NewSpider(router, "router").CloseStrace().SetObeyRobots(false).SetScheduler(s).
+        AddUrl(ts.URL+"/list", "text").Run()
+    if len(list.pages) != 1 || len(detail.pages) != 2 || len(api) != 1 || api[0] != ts.URL+"/api/items" {
+        t.Errorf("pages are not routed: %d %d %v", len(list.pages), len(detail.pages), api)
+    }
+    for _, p := range detail.pages {
+        if !strings.Contains(p.GetRequest().GetUrl(), "/item/") {
+            t.Errorf("%s should not be routed to detail", p.GetRequest().GetUrl())
+        }
+    }
+
+    data, err := json.Marshal(request.NewRequest(ts.URL, "text").SetTag("detail"))
+    var req request.Request
+    if err != nil || json.Unmarshal(data, &req) != nil || req.GetTag() != "detail" {
+        t.Errorf("tag should be serialized: %s %v", data, err)
+    }
+}
+
+func TestRulePageProcesser(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/":
+            w.Write([]byte(`<div class="items"><a href="/item/1">1</a><a href="/item/2">2</a><a href="/item/3">3</a>` +
+                `<a href="/about">about</a></div>`))
+        case "/item/1":
+            w.Write([]byte(`<h1>  Blue
+                Shirt </h1><span class="price">$1,299.00</span><meta itemprop="sku" content="SKU-42">` +
+                `<span class="tag">New</span><span class="tag">SALE</span><a class="more" href="/more/1">more</a>`))
+        case "/item/2":
+            w.Write([]byte(`<h1>Red Shirt</h1><span class="price">19,90 €</span>`))
+        case "/item/3":
+            w.Write([]byte(`<h1>Gift card</h1>`))
+        case "/api":
+            w.Header().Set("Content-Type", "application/json")
+            w.Write([]byte(`{"data": {"name": "Green Shirt", "tags": ["a", "b"], "stock": 3}}`))
+        default:
+            w.Write([]byte(`<h1>other</h1>`))
+        }
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "rules")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    rules := filepath.Join(dir, "rules.yaml")
+    ioutil.WriteFile(rules, []byte(`
+fields:
+    - name: title
+      selector: h1
+      transform: [collapse]
+    - name: price
+      selector: .price
+      transform: [currency]
+      required: true
+    - name: sku
+      xpath: //meta[@itemprop='sku']/@content
+      regex: 'SKU-(\d+)'
+      default: none
+    - name: tags
+      selector: .tag
+      transform: [lower]
+      list: true
+    - name: more
+      selector: a.more
+      attr: href
+      transform: [url]
+follow:
+    - selector: .items a
+      allow: ["/item/"]
+items: ["/item/"]
+`), 0644)
+    config := filepath.Join(dir, "crawl.yaml")
+    ioutil.WriteFile(config, []byte("rules: "+rules+"\nseeds: [\""+ts.URL+"/\"]\nthreadnum: 1\nobey_robots: false\n"), 0644)
+
+    sp, err := spider.NewFromConfig(config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    pip := pipeline.NewCollectPipelinePageItems()
+    sp.CloseStrace().AddPipeline(pip).Run()
+    items := make(map[string]map[string]interface{})
+    for _, item := range pip.GetCollected() {
+        items[item.GetRequest().GetUrl()] = item.GetValues()
+    }
+    want := map[string]map[string]interface{}{
+        ts.URL + "/item/1": {"title": "Blue Shirt", "price": "1299.00", "sku": "42", "tags": []string{"new", "sale"},
+            "more": ts.URL + "/more/1"},
+        ts.URL + "/item/2": {"title": "Red Shirt", "price": "19.90", "sku": "none"},
+    }
+    if !reflect.DeepEqual(items, want) {
+        t.Errorf("wrong items by rules: %v", items)
+    }
+
+    p, err := page_processer.NewRulePageProcesser(page_processer.ExtractRules{Fields: []page_processer.FieldRule{
+        {Name: "name", Jsonpath: "data.name", Transform: []string{"upper"}},
+        {Name: "tags", Jsonpath: "data.tags", List: true},
+        {Name: "stock", Jsonpath: "data.stock", Regex: `\d+`},
+    }})
+    if err != nil {
+        t.Fatal(err)
+    }
+    pip = pipeline.NewCollectPipelinePageItems()
+    spider.NewSpider(p, "rules").CloseStrace().SetObeyRobots(false).AddPipeline(pip).AddUrl(ts.URL+"/api", "json").Run()
+    if len(pip.GetCollected()) != 1 || !reflect.DeepEqual(pip.GetCollected()[0].GetValues(),
+        map[string]interface{}{"name": "GREEN SHIRT", "tags": []string{"a", "b"}, "stock": "3"}) {
+        t.Errorf("wrong items of json page: %v", pip.GetCollected())
+    }
+
+    for _, f := range []page_processer.FieldRule{
+        {Name: "title"},
+        {Name: "title", Selector: "h1", Xpath: "//h1"},
+        {Name: "title", Xpath: "//h1["},
+        {Name: "title", Selector: "h1", Regex: "("},
+        {Name: "title", Selector: "h1", Transform: []string{"reverse"}},
+    } {
+        if _, err := page_processer.NewRulePageProcesser(page_processer.ExtractRules{Fields: []page_processer.FieldRule{f}}); err == nil {
+            t.Errorf("rules of field %+v should fail", f)
+        }
+    }
+    ioutil.WriteFile(rules, []byte("fields: []\n"), 0644)
+    if _, err := spider.NewFromConfig(config); err == nil {
+        t.Error("config of rules without fields should fail")
+    }
+}
+
+func TestTransforms(t *testing.T) {
+    chain, err := page_processer.ParseTransforms([]string{"unescape", "collapse", `regex:on (.+)$`,
+        "date:Jan 2, 2006 3:04 PM|2006-01-02@America/New_York"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    for value, expected := range map[string]string{
+        "Posted on  Mar 5, 2024\n 9:30 PM": "2024-03-05T21:30:00-05:00",
+        "Posted&nbsp;on 2024-07-01":        "2024-07-01T00:00:00-04:00",
+    } {
+        if s, err := chain.Apply(value, ""); err != nil || s != expected {
+            t.Errorf("transforms of %q: %q, %v", value, s, err)
+        }
+    }
+    if _, err := chain.Apply("no date", ""); err == nil {
+        t.Error("value the regexp does not match should fail")
+    }
+    replace, _ := page_processer.ParseTransform(`replace:\s*/\s*:/`)
+    if s, _ := replace("a / b /c", ""); s != "a/b/c" {
+        t.Errorf("replace transform error: %s", s)
+    }
+    for _, spec := range []string{"reverse", "regex:(", "replace:x", "date:2006@Mars/Base"} {
+        if _, err := page_processer.ParseTransform(spec); err == nil {
+            t.Errorf("transform %s should fail", spec)
+        }
+    }
+
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(`<h1> Tom &amp; Jerry </h1><span class="price">USD 1,024.50</span>` +
+            `<span class="size">10 cm</span><span class="size">n/a</span><span class="size">25 cm</span>`))
+    }))
+    defer ts.Close()
+    pp := page_processer.NewCssPageProcesser().AddField("title", "h1", "html").AddField("price", ".price", "").
+        AddListField("sizes", ".size", "").Transform("title", page_processer.Unescape, page_processer.Trim).
+        Transform("price", page_processer.Currency).Transform("sizes", page_processer.RegexTransform(`(\d+) cm`))
+    pip := pipeline.NewCollectPipelinePageItems()
+    spider.NewSpider(pp, "transforms").CloseStrace().SetObeyRobots(false).AddPipeline(pip).AddUrl(ts.URL, "html").Run()
+    items := pip.GetCollected()
+    if len(items) != 1 || !reflect.DeepEqual(items[0].GetValues(), map[string]interface{}{"title": "Tom & Jerry", "price": "1024.50",
+        "sizes": []string{"10", "25"}}) {
+        t.Errorf("wrong items of transforms: %v", items)
+    }
+}
+
+func TestFlow(t *testing.T) {
+    var locker sync.Mutex
+    var reviews []time.Time
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasPrefix(r.URL.Path, "/reviews/") {
+            locker.Lock()
+            reviews = append(reviews, time.Now())
+            locker.Unlock()
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    flow := spider.NewFlow()
+    flow.StageFunc("list", func(p *page.Page) {
+        if p.GetRequest().GetUrl() == ts.URL+"/list/1" {
+            p.AddTargetRequests([]string{ts.URL + "/item/1", ts.URL + "/item/2"}, "text")
+            p.AddTargetRequestsWithTag([]string{ts.URL + "/list/2"}, "text", "list")
+        } else {
+            p.AddTargetRequest(ts.URL+"/item/3", "text")
+        }
+    }).Next("detail").SetSkip(true)
+    details := pipeline.NewCollectPipelinePageItems()
+    flow.StageFunc("detail", func(p *page.Page) {
+        id := strings.TrimPrefix(p.GetRequest().GetUrl(), ts.URL+"/item/")
+        p.AddField("item", id)
+        p.AddTargetRequest(ts.URL+"/reviews/"+id, "text")
+    }).Next("reviews").AddPipeline(details)
+    reviewItems := pipeline.NewCollectPipelinePageItems()
+    flow.StageFunc("reviews", func(p *page.Page) {
+        p.AddField("review", p.GetRequest().GetUrl())
+    }).SetRateLimit(20).AddPipeline(reviewItems)
+
+    all := pipeline.NewCollectPipelinePageItems()
+    spider.NewSpider(nil, "flow").CloseStrace().SetObeyRobots(false).SetFlow(flow).AddPipeline(all).
+        AddUrl(ts.URL+"/list/1", "text").Run()
+    if len(details.GetCollected()) != 3 || len(reviewItems.GetCollected()) != 3 || len(all.GetCollected()) != 6 {
+        t.Errorf("items should be passed to pipelines of their stages: %d %d %d", len(details.GetCollected()),
+            len(reviewItems.GetCollected()), len(all.GetCollected()))
+    }
+    for _, items := range reviewItems.GetCollected() {
+        if items.GetRequest().GetTag() != "reviews" {
+            t.Errorf("request should be in reviews stage: %s", items.GetRequest().GetTag())
+        }
+    }
+    sort.Slice(reviews, func(i, j int) bool { return reviews[i].Before(reviews[j]) })
+    for i := 1; i < len(reviews); i++ {
+        if d := reviews[i].Sub(reviews[i-1]); d < 40*time.Millisecond {
+            t.Errorf("reviews stage should be rate limited: %v", d)
+        }
+    }
+}
+
+func TestShard(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "shard")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    sink, err := spider.NewShardFileSink(dir)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var locker sync.Mutex
+    crawled := make(map[string]int)
+    crawl := func(index int, reqs []*request.Request) {
+        pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+            locker.Lock()
+            if i, ok := crawled[p.GetRequest().GetUrl()]; ok && i != index {
+                t.Errorf("%s is crawled by both shards", p.GetRequest().GetUrl())
+            }
+            crawled[p.GetRequest().GetUrl()] = index
+            locker.Unlock()
+            if p.GetRequest().GetUrl() == ts.URL+"/" {
+                for i := 0; i < 20; i++ {
+                    p.AddTargetRequest(ts.URL+"/"+strconv.Itoa(i), "text")
+                }
+            }
+        })
+        sp := spider.NewSpider(pp, "shard").CloseStrace().SetObeyRobots(false).SetShard(index, 2, sink).
+            AddUrl(ts.URL+"/", "text").AddRequests(reqs)
+        if i, n := sp.GetShard(); i != index || n != 2 {
+            t.Errorf("wrong shard: %d %d", i, n)
+        }
+        sp.Run()
+    }
+    // the first run of both instances, and then a run of requests forwarded to each of them
+    for pass := 0; pass < 2; pass++ {
+        for index := 0; index < 2; index++ {
+            var reqs []*request.Request
+            if pass > 0 {
+                if reqs, err = spider.LoadShardFile(dir, index); err != nil {
+                    t.Fatal(err)
+                }
+                os.Remove(filepath.Join(dir, "shard-"+strconv.Itoa(index)+".jsonl"))
+            }
+            crawl(index, reqs)
+        }
+    }
+    sink.Close()
+    if len(crawled) != 21 {
+        t.Errorf("all the urls should be crawled by their shards: %d", len(crawled))
+    }
+    for url, index := range crawled {
+        if spider.ShardOf(scheduler.DefaultFingerprint(request.NewRequest(url, "text")), 2) != index {
+            t.Errorf("%s is crawled by wrong shard %d", url, index)
+        }
+    }
+}
+
+// The slowPipeline takes delay for each page, and records the most pages processed but not output yet.
+type slowPipeline struct {
+    delay     time.Duration
+    processed *int32
+    output    int32
+    maxWait   int32
+}
+
+func (this *slowPipeline) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    if n := atomic.LoadInt32(this.processed) - atomic.LoadInt32(&this.output); n > atomic.LoadInt32(&this.maxWait) {
+        atomic.StoreInt32(&this.maxWait, n)
+    }
+    time.Sleep(this.delay)
+    atomic.AddInt32(&this.output, 1)
+}
+
+func TestAsyncPipelines(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    var processed int32
+    pip := &slowPipeline{delay: 20 * time.Millisecond, processed: &processed}
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        atomic.AddInt32(&processed, 1)
+    })
+    sp := spider.NewSpider(pp, "async").CloseStrace().SetObeyRobots(false).SetThreadnum(4).
+        SetAsyncPipelines(1, 2).AddPipeline(pip)
+    for i := 0; i < 20; i++ {
+        sp.AddUrl(ts.URL+"/"+strconv.Itoa(i), "text")
+    }
+    sp.Run()
+    if pip.output != 20 {
+        t.Errorf("all the pages should be output before Run returns: %d", pip.output)
+    }
+    // pages of 4 download workers, 2 in queue and 1 in pipeline worker
+    if pip.maxWait > 7 {
+        t.Errorf("downloads should wait for full pipeline queue: %d", pip.maxWait)
+    }
+}
+
+func TestPipelineQueueMemory(t *testing.T) {
+    body := strings.Repeat("x", 1000)
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte(body))
+    }))
+    defer ts.Close()
+
+    var processed int32
+    pip := &slowPipeline{delay: 20 * time.Millisecond, processed: &processed}
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        atomic.AddInt32(&processed, 1)
+    })
+    sp := spider.NewSpider(pp, "memory").CloseStrace().SetObeyRobots(false).SetThreadnum(4).
+        SetAsyncPipelines(1, 100).SetPipelineQueueMemory(2500).AddPipeline(pip)
+    for i := 0; i < 20; i++ {
+        sp.AddUrl(ts.URL+"/"+strconv.Itoa(i), "text")
+    }
+    sp.Run()
+    if pip.output != 20 {
+        t.Errorf("all the pages should be output: %d", pip.output)
+    }
+    // pages of 4 download workers, 2 pages within 2500 bytes in queue and 1 in pipeline worker
+    if pip.maxWait > 7 {
+        t.Errorf("downloads should wait for pipeline queue over memory: %d", pip.maxWait)
+    }
+}
+
+func TestTracer(t *testing.T) {
+    var locker sync.Mutex
+    var traceparent string
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        traceparent = r.Header.Get("Traceparent")
+        locker.Unlock()
+        w.Header().Set("Content-Type", "text/html")
+        w.Write([]byte(`<html><body><h1>title</h1></body></html>`))
+    }))
+    defer ts.Close()
+
+    var spans []*trace.Span
+    exporter := trace.ExporterFunc(func(service string, batch []*trace.Span) error {
+        locker.Lock()
+        spans = append(spans, batch...)
+        locker.Unlock()
+        return nil
+    })
+    tracer := trace.NewTracer("test", exporter).SetBatchSize(2).SetPropagation(true)
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        p.AddField("title", p.GetHtmlParser().Find("h1").Text())
+    })
+    spider.NewSpider(pp, "tracer").CloseStrace().SetObeyRobots(false).SetTracer(tracer).
+        AddPipeline(pipeline.NewCollectPipelinePageItems()).AddUrl(ts.URL, "html").Run()
+
+    byName := make(map[string]*trace.Span)
+    for _, span := range spans {
+        byName[span.Name] = span
+    }
+    crawl := byName["crawl"]
+    if crawl == nil || crawl.ParentID != "" || crawl.GetAttribute("url.full") != ts.URL {
+        t.Fatalf("crawl span should be root of the trace: %v", byName)
+    }
+    parents := map[string]string{"queue wait": "crawl", "download": "crawl", "process": "crawl",
+        "pipeline *pipeline.CollectPipelinePageItems": "crawl", "connect": "download", "ttfb": "download",
+        "read body": "download", "parse": "download"}
+    for name, parent := range parents {
+        span := byName[name]
+        if span == nil {
+            t.Errorf("span %s should be recorded", name)
+        } else if span.TraceID != crawl.TraceID || span.ParentID != byName[parent].SpanID {
+            t.Errorf("span %s should be child of %s", name, parent)
+        }
+    }
+    if byName["download"].GetAttribute("http.response.status_code") != 200 {
+        t.Errorf("download span should have status code: %v", byName["download"].Attributes)
+    }
+    if traceparent != "00-"+crawl.TraceID+"-"+byName["download"].SpanID+"-01" {
+        t.Errorf("traceparent should be of download span: %s", traceparent)
+    }
+}
+
+func TestPrefetch(t *testing.T) {
+    slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        time.Sleep(100 * time.Millisecond)
+        w.Write([]byte("slow"))
+    }))
+    defer slow.Close()
+    var conns int32
+    next := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("next"))
+    }))
+    next.Config.ConnState = func(c net.Conn, state http.ConnState) {
+        if state == http.StateNew {
+            atomic.AddInt32(&conns, 1)
+        }
+    }
+    next.Start()
+    defer next.Close()
+
+    sp := spider.NewSpider(page_processer.PageProcesserFunc(func(p *page.Page) {}), "prefetch").CloseStrace().
+        SetObeyRobots(false).SetPrefetch(true).AddUrl(slow.URL+"/1", "text").AddUrl(next.URL+"/1", "text")
+    sp.Run()
+    prefetched, used := sp.GetDownloader().(*downloader.HttpDownloader).GetPrefetchStats()
+    if prefetched != 1 || used != 1 {
+        t.Errorf("connection of the next host should be prefetched and used: %d, %d", prefetched, used)
+    }
+    if n := atomic.LoadInt32(&conns); n != 1 {
+        t.Errorf("next host should be connected once: %d", n)
+    }
+}
+
+func TestOfflineReplay(t *testing.T) {
+    var hits int32
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        atomic.AddInt32(&hits, 1)
+        w.Write([]byte(`<a href="/a">a</a><a href="/b">b</a>`))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "replay")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    pp := &hrefPageProcesser{}
+    cache, err := downloader.NewFileCache(dir, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    s := scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+    spider.NewSpider(pp, "record").CloseStrace().SetObeyRobots(false).SetScheduler(s).
+        SetCache(cache).AddUrl(ts.URL+"/", "html").Run()
+    if len(pp.pages) != 3 || hits != 3 {
+        t.Fatalf("pages should be recorded: %d %d", len(pp.pages), hits)
+    }
+
+    pp = &hrefPageProcesser{}
+    s = scheduler.NewQueueScheduler(false).SetDeduplicator(scheduler.NewMapDeduplicator())
+    sp := spider.NewSpider(pp, "replay").CloseStrace().SetObeyRobots(false).SetScheduler(s).
+        SetCache(cache).SetOffline(true)
+    sp.AddUrl(ts.URL+"/", "html").AddUrl(ts.URL+"/c", "html").Run()
+    if len(pp.pages) != 3 || hits != 3 {
+        t.Errorf("pages should be replayed without download: %d %d", len(pp.pages), hits)
+    }
+    if status := sp.Status(); status.Dropped["offline"] != 1 {
+        t.Errorf("request not in cache should be dropped: %v", status.Dropped)
+    }
+}
+
+func TestCaptchaHandler(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if c, err := r.Cookie("captcha"); (err == nil && c.Value == "ok") || r.URL.Query().Get("token") == "ok" {
+            w.Write([]byte("<p>content</p>"))
+            return
+        }
+        w.Write([]byte(`<div class="g-recaptcha" data-sitekey="key"></div>`))
+    }))
+    defer ts.Close()
+
+    var solves int32
+    solver := spider.CaptchaSolverFunc(func(ctx context.Context, p *page.Page) (*spider.CaptchaSolution, error) {
+        atomic.AddInt32(&solves, 1)
+        if key, _ := p.GetHtmlParser().Find(".g-recaptcha").Attr("data-sitekey"); key != "key" {
+            return nil, os.ErrInvalid
+        }
+        return &spider.CaptchaSolution{Cookies: []*http.Cookie{{Name: "captcha", Value: "ok"}}}, nil
+    })
+    detector := spider.NewCaptchaDetector().AddSelector(".g-recaptcha")
+    pp := &testPageProcesser{}
+    sp := spider.NewSpider(pp, "captcha").CloseStrace().SetObeyRobots(false).SetThreadnum(1).
+        SetCaptchaHandler(detector.Detect, solver)
+    sp.AddUrl(ts.URL+"/a", "html").AddUrl(ts.URL+"/b", "html").Run()
+    if solves != 1 || len(pp.pages) != 2 {
+        t.Fatalf("captcha should be solved once for the session: %d %d", solves, len(pp.pages))
+    }
+    for _, p := range pp.pages {
+        if !p.IsSucc() || !strings.Contains(p.GetBodyStr(), "content") {
+            t.Errorf("page should be downloaded again after captcha is solved: %s", p.GetBodyStr())
+        }
+    }
+    w := httptest.NewRecorder()
+    sp.GetMetrics().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+    if !strings.Contains(w.Body.String(), `go_spider_captchas_total{result="solved"} 1`+"\n") {
+        t.Errorf("solved captcha should be counted:\n%s", w.Body.String())
+    }
+
+    // the token of params is sent, and captcha without solver is retried then failed
+    pp = &testPageProcesser{}
+    solver = func(ctx context.Context, p *page.Page) (*spider.CaptchaSolution, error) {
+        return &spider.CaptchaSolution{Params: map[string]string{"token": "ok"}}, nil
+    }
+    spider.NewSpider(pp, "captcha").CloseStrace().SetObeyRobots(false).SetCaptchaHandler(detector.Detect, solver).
+        AddUrl(ts.URL+"/c?q=1", "html").Run()
+    if len(pp.pages) != 1 || !strings.Contains(pp.pages[0].GetBodyStr(), "content") {
+        t.Errorf("params of solution should be added to url")
+    }
+    var failed []string
+    pp = &testPageProcesser{}
+    spider.NewSpider(pp, "captcha").CloseStrace().SetObeyRobots(false).SetRetryTimes(1).
+        SetCaptchaHandler(detector.Detect, nil).SetFailedRequestHandler(func(req *request.Request, err error) {
+        failed = append(failed, err.Error())
+    }).AddUrl(ts.URL+"/d", "html").Run()
+    if len(failed) != 1 || failed[0] != "captcha is detected" || pp.pages[0].IsSucc() {
+        t.Errorf("captcha page should be failed: %v", failed)
+    }
+}
+
+func TestItemValidator(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    prices := map[string]string{"/a": "9.99", "/b": "", "/c": "free", "/d": "0"}
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        u, _ := url.Parse(p.GetRequest().GetUrl())
+        p.AddField("name", "item"+u.Path)
+        p.AddField("price", prices[u.Path])
+        p.AddField("url", p.GetRequest().GetUrl())
+    })
+    v := spider.NewItemValidator().Require("name", "price").SetType("price", "float").
+        SetRange("price", 0.01, 100000).SetType("url", "url").SetLength("name", 1, 20)
+    valid, invalid := pipeline.NewCollectPipelinePageItems(), pipeline.NewCollectPipelinePageItems()
+    sp := spider.NewSpider(pp, "items").CloseStrace().SetObeyRobots(false).AddPipeline(valid).
+        SetItemValidator(v.Check, invalid)
+    for path := range prices {
+        sp.AddUrl(ts.URL+path, "text")
+    }
+    sp.Run()
+
+    if items := valid.GetCollected(); len(items) != 1 {
+        t.Fatalf("only valid items should be passed to pipelines: %d", len(items))
+    } else if name, _ := items[0].GetItem("name"); name != "item/a" {
+        t.Errorf("valid item is wrong: %s", name)
+    }
+    reasons := make(map[string]string)
+    for _, items := range invalid.GetCollected() {
+        name, _ := items.GetItem("name")
+        reasons[name], _ = items.GetItem(spider.InvalidReasonKey)
+    }
+    expected := map[string]string{
+        "item/b": "price: is required",
+        "item/c": "price: is not float",
+        "item/d": "price: 0 is out of range [0.01, 100000]",
+    }
+    if !reflect.DeepEqual(reasons, expected) {
+        t.Errorf("invalid items should be passed to error pipeline with reason: %v", reasons)
+    }
+    if stats := sp.GetStats(); stats.InvalidItems != 3 {
+        t.Errorf("invalid items should be counted: %d", stats.InvalidItems)
+    }
+}
+
+func TestItemDeduplicator(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "items")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+    path := filepath.Join(dir, "items")
+
+    skus := map[string]string{"/a": "1", "/b": "2", "/c": "1", "/d": ""}
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        u, _ := url.Parse(p.GetRequest().GetUrl())
+        p.AddField("sku", skus[u.Path])
+    })
+    run := func(paths ...string) (*pipeline.CollectPipelinePageItems, spider.Stats) {
+        collected := pipeline.NewCollectPipelinePageItems()
+        d := spider.NewItemDeduplicator(scheduler.NewMapDeduplicator(), "sku").SetFile(path)
+        sp := spider.NewSpider(pp, "items").CloseStrace().SetObeyRobots(false).AddPipeline(collected).
+            SetItemDeduplicator(d)
+        for _, p := range paths {
+            sp.AddUrl(ts.URL+p, "text")
+        }
+        sp.Run()
+        return collected, sp.GetStats()
+    }
+
+    collected, stats := run("/a", "/b", "/c", "/d")
+    if len(collected.GetCollected()) != 3 || stats.DuplicateItems != 1 {
+        t.Errorf("items of the same sku should be emitted once: %d %d", len(collected.GetCollected()),
+            stats.DuplicateItems)
+    }
+    collected, stats = run("/c", "/d")
+    if len(collected.GetCollected()) != 1 || stats.DuplicateItems != 1 {
+        t.Errorf("items emitted by last run should be dropped: %d %d", len(collected.GetCollected()),
+            stats.DuplicateItems)
+    }
+}
+
+func TestPoliteScheduler(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/robots.txt" {
+            w.Write([]byte("User-agent: *\nCrawl-delay: 0.05\n"))
+            return
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    robots := downloader.NewRobots("go_spider")
+    s := scheduler.NewPoliteScheduler(false).SetCrawlDelay(robots.CrawlDelay)
+    var locker sync.Mutex
+    var times []time.Time
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        locker.Lock()
+        times = append(times, time.Now())
+        locker.Unlock()
+    })
+    d := downloader.NewHttpDownloader().SetRobots(robots)
+    sp := spider.NewSpider(pp, "polite").CloseStrace().SetDownloader(d).SetScheduler(s).SetThreadnum(4)
+    for i := 0; i < 4; i++ {
+        sp.AddUrl(ts.URL+"/"+strconv.Itoa(i), "text")
+    }
+    sp.Run()
+    if len(times) != 4 {
+        t.Fatalf("requests waiting for crawl delay should be crawled before Run returns: %d", len(times))
+    }
+    sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+    for i := 1; i < len(times); i++ {
+        if d := times[i].Sub(times[i-1]); d < 40*time.Millisecond {
+            t.Errorf("requests of the host should be spaced by crawl delay: %v", d)
+        }
+    }
+}
+
+func TestIncremental(t *testing.T) {
+    var locker sync.Mutex
+    bodies := map[string]string{"/a": "a", "/b": "b", "/c": "c"}
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        defer locker.Unlock()
+        w.Write([]byte(bodies[r.URL.Path]))
+    }))
+    defer ts.Close()
+
+    dir, err := ioutil.TempDir("", "incremental")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(dir)
+
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        p.AddField("body", p.GetBodyStr())
+    })
+    run := func(paths ...string) (int, map[string]string, spider.Stats) {
+        store, err := spider.OpenBoltChangeStore(filepath.Join(dir, "changes.db"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        defer store.Close()
+        changes := make(map[string]string)
+        inc := spider.NewIncremental(store).SetChangeHandler(func(e spider.ChangeEvent) {
+            locker.Lock()
+            changes[strings.TrimPrefix(e.Url, ts.URL)] = e.Change
+            locker.Unlock()
+        })
+        collected := pipeline.NewCollectPipelinePageItems()
+        sp := spider.NewSpider(pp, "incremental").CloseStrace().SetObeyRobots(false).AddPipeline(collected).
+            SetIncremental(inc)
+        for _, path := range paths {
+            sp.AddUrl(ts.URL+path, "text")
+        }
+        sp.Run()
+        return len(collected.GetCollected()), changes, sp.GetStats()
+    }
+
+    if n, _, stats := run("/a", "/b"); n != 2 || stats.Changes[spider.ChangeAdded] != 2 {
+        t.Fatalf("pages of the first crawl should be added: %d %v", n, stats.Changes)
+    }
+    locker.Lock()
+    bodies["/b"] = "b2"
+    locker.Unlock()
+    n, changes, _ := run("/a", "/b", "/c")
+    expected := map[string]string{"/a": spider.ChangeUnchanged, "/b": spider.ChangeModified, "/c": spider.ChangeAdded}
+    if n != 2 || !reflect.DeepEqual(changes, expected) {
+        t.Errorf("only changed pages should be passed to pipelines: %d %v", n, changes)
+    }
+}
+
+func TestDashboardSeeds(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/missing" {
+            w.WriteHeader(http.StatusNotFound)
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    var locker sync.Mutex
+    crawled := make(map[string]bool)
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        locker.Lock()
+        crawled[strings.TrimPrefix(p.GetRequest().GetUrl(), ts.URL)] = true
+        locker.Unlock()
+    })
+    sp := spider.NewSpider(pp, "seeds").CloseStrace().SetObeyRobots(false).SetExitWhenComplete(false).
+        SetScheduler(scheduler.NewPriorityScheduler(false))
+    sp.Pause()
+    done := make(chan struct{})
+    go func() {
+        sp.Run()
+        close(done)
+    }()
+    dashboard := httptest.NewServer(sp.Dashboard())
+    defer dashboard.Close()
+
+    post := func(path, contentType, body string) int {
+        resp, err := http.Post(dashboard.URL+path, contentType, strings.NewReader(body))
+        if err != nil {
+            t.Fatal(err)
+        }
+        resp.Body.Close()
+        return resp.StatusCode
+    }
+    if code := post("/seeds?type=text", "text/plain", ts.URL+"/a\n"); code != http.StatusUnsupportedMediaType {
+        t.Fatalf("seeds of text should be refused: %d", code)
+    }
+    if code := post("/seeds?type=text", "application/yaml", "- "+ts.URL+"/a\n"); code != http.StatusBadRequest {
+        t.Fatalf("seeds of yaml should be refused: %d", code)
+    }
+    if code := post("/seeds?type=text", "application/json", `["`+ts.URL+`/a","`+ts.URL+`/missing"]`); code != 200 {
+        t.Fatalf("seeds of urls should be added: %d", code)
+    }
+    // other fields of requests are dropped
+    seed := `{"url":"` + ts.URL + `/b","tag":"b","header":{"Cookie":["id=1"]},"proxy":"http://127.0.0.1:1","priority":5}`
+    if code := post("/seeds?type=text", "application/json", `[`+seed+`]`); code != 200 {
+        t.Fatalf("seeds of urls and tags should be added: %d", code)
+    }
+    if code := post("/seeds", "application/json", `["ftp://a.com/"]`); code != http.StatusBadRequest {
+        t.Errorf("seed that is not a http url should be refused: %d", code)
+    }
+
+    resp, err := http.Get(dashboard.URL + "/queue?n=2")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var q spider.QueueStatus
+    err = json.NewDecoder(resp.Body).Decode(&q)
+    resp.Body.Close()
+    if err != nil || q.Depth != 3 || len(q.Requests) != 2 || q.Requests[0].GetResponceType() != "text" {
+        t.Fatalf("queue should list the first requests: %+v %v", q, err)
+    }
+    resp, err = http.Get(dashboard.URL + "/queue")
+    if err != nil {
+        t.Fatal(err)
+    }
+    err = json.NewDecoder(resp.Body).Decode(&q)
+    resp.Body.Close()
+    for _, req := range q.Requests {
+        if req.GetUrl() == ts.URL+"/b" && (req.GetTag() != "b" || len(req.GetHeader()) != 0 ||
+            req.GetProxyHost() != "" || req.GetPriority() != 0) {
+            t.Errorf("seed should have only its url and tag: %+v", req)
+        }
+    }
+
+    sp.Resume()
+    deadline := time.Now().Add(5 * time.Second)
+    for sp.Status().Pages < 3 && time.Now().Before(deadline) {
+        time.Sleep(10 * time.Millisecond)
+    }
+    sp.Stop()
+    <-done
+    if !crawled["/a"] || !crawled["/b"] || !crawled["/missing"] {
+        t.Errorf("seeds should be crawled: %v", crawled)
+    }
+
+    resp, err = http.Get(dashboard.URL + "/errors")
+    if err != nil {
+        t.Fatal(err)
+    }
+    var errs []spider.ErrorRecord
+    err = json.NewDecoder(resp.Body).Decode(&errs)
+    resp.Body.Close()
+    if err != nil || len(errs) != 1 || errs[0].Url != ts.URL+"/missing" {
+        t.Errorf("recent errors should be served: %+v %v", errs, err)
+    }
+}
+
+type panicPipeline struct{}
+
+func (this *panicPipeline) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    panic("database is down")
+}
+
+type countPipeline struct {
+    running, maxRunning, count int32
+}
+
+func (this *countPipeline) Process(items *page_items.PageItems, t com_interfaces.Task) {
+    n := atomic.AddInt32(&this.running, 1)
+    if n > atomic.LoadInt32(&this.maxRunning) {
+        atomic.StoreInt32(&this.maxRunning, n)
+    }
+    time.Sleep(5 * time.Millisecond)
+    atomic.AddInt32(&this.count, 1)
+    atomic.AddInt32(&this.running, -1)
+}
+
+func TestPipelineIsolation(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        n, _ := strconv.Atoi(strings.TrimPrefix(p.GetRequest().GetUrl(), ts.URL+"/"))
+        p.AddField("type", []string{"product", "review"}[n%2])
+    })
+    all, products := &countPipeline{}, &countPipeline{}
+    isProduct := func(items *page_items.PageItems) bool {
+        t, _ := items.GetItem("type")
+        return t == "product"
+    }
+    sp := spider.NewSpider(pp, "isolation").CloseStrace().SetObeyRobots(false).SetThreadnum(4).
+        AddPipeline(&panicPipeline{}).AddPipelineWith(all, nil, 1).AddPipelineWith(products, isProduct, 0)
+    for i := 0; i < 20; i++ {
+        sp.AddUrl(ts.URL+"/"+strconv.Itoa(i), "text")
+    }
+    sp.Run()
+
+    if all.count != 20 || products.count != 10 {
+        t.Errorf("pipelines should get items after a pipeline panics: %d %d", all.count, products.count)
+    }
+    if all.maxRunning != 1 {
+        t.Errorf("pipeline of concurrency 1 should not be called at once: %d", all.maxRunning)
+    }
+    stats := sp.GetStats()
+    if stats.PipelineErrors["*spider_test.panicPipeline"] != 20 || stats.Items["*spider_test.panicPipeline"] != 0 {
+        t.Errorf("panics of pipeline should be counted: %v %v", stats.PipelineErrors, stats.Items)
+    }
+}
+
+func TestDelayScheduler(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer ts.Close()
+
+    var locker sync.Mutex
+    crawled := make(map[string]time.Time)
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        locker.Lock()
+        crawled[p.GetRequest().GetUrl()] = time.Now()
+        locker.Unlock()
+        if strings.HasSuffix(p.GetRequest().GetUrl(), "/start") {
+            p.AddTargetRequestWithParams(request.NewRequest(ts.URL+"/deferred", "text").SetDelay(100 * time.Millisecond))
+        }
+    })
+    start := time.Now()
+    sp := spider.NewSpider(pp, "delay").CloseStrace().
+        SetScheduler(scheduler.NewDelayScheduler(scheduler.NewQueueScheduler(false))).SetThreadnum(2)
+    sp.AddUrl(ts.URL+"/start", "text")
+    sp.Run()
+    at, ok := crawled[ts.URL+"/deferred"]
+    if !ok {
+        t.Fatal("deferred request should be crawled before Run returns")
+    }
+    if d := at.Sub(start); d < 90*time.Millisecond {
+        t.Errorf("deferred request should be crawled after its time: %v", d)
+    }
+}
+
+func TestStreamPageProcesser(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.URL.Path == "/page.html" {
+            w.Write([]byte(`<html><body><a href="/export.xml">export</a><p>skipped</p></body></html>`))
+            return
+        }
+        w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><products>`))
+        for i := 0; i < 1000; i++ {
+            fmt.Fprintf(w, `<product id="%d"><name>p%d</name></product>`, i, i)
+        }
+        w.Write([]byte(`</products>`))
+    }))
+    defer ts.Close()
+
+    type product struct {
+        Id   int    `xml:"id,attr"`
+        Name string `xml:"name"`
+    }
+    var locker sync.Mutex
+    var names []string
+    var links []string
+    sp := page_processer.StreamPageProcesserFunc(func(p *page.Page, body io.Reader) {
+        if strings.HasSuffix(p.GetRequest().GetUrl(), ".html") {
+            z := p.HtmlTokenizer()
+            for tt := z.Next(); tt != html.ErrorToken; tt = z.Next() {
+                if name, hasAttr := z.TagName(); tt == html.StartTagToken && string(name) == "a" && hasAttr {
+                    for {
+                        key, val, more := z.TagAttr()
+                        if string(key) == "href" {
+                            locker.Lock()
+                            links = append(links, string(val))
+                            locker.Unlock()
+                            p.AddTargetRequest(ts.URL+string(val), "stream")
+                        }
+                        if !more {
+                            break
+                        }
+                    }
+                }
+            }
+            return
+        }
+        decoder := p.XmlDecoder()
+        for {
+            token, err := decoder.Token()
+            if err != nil {
+                break
+            }
+            if start, ok := token.(xml.StartElement); ok && start.Name.Local == "product" {
+                var v product
+                if err := decoder.DecodeElement(&v, &start); err != nil {
+                    t.Error(err)
+                    return
+                }
+                locker.Lock()
+                names = append(names, v.Name)
+                locker.Unlock()
+            }
+        }
+    })
+    spider.NewSpider(sp, "stream").CloseStrace().AddUrl(ts.URL+"/page.html", "stream").Run()
+    if len(links) != 1 || links[0] != "/export.xml" {
+        t.Errorf("links should be found by html tokenizer: %v", links)
+    }
+    if len(names) != 1000 || names[999] != "p999" {
+        t.Errorf("elements should be decoded one by one: %d", len(names))
+    }
+}
+
+func TestMessagePageProcesser(t *testing.T) {
+    var locker sync.Mutex
+    var lastIds []string
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        locker.Lock()
+        lastIds = append(lastIds, r.Header.Get("Last-Event-ID"))
+        locker.Unlock()
+        w.Header().Set("Content-Type", "text/event-stream")
+        if r.Header.Get("Last-Event-ID") == "2" {
+            fmt.Fprint(w, "id: 3\ndata: {\"n\": 3}\n\n")
+            w.(http.Flusher).Flush()
+            // the stream is open until the client ends it
+            <-r.Context().Done()
+            return
+        }
+        fmt.Fprint(w, ": keep-alive\n\nid: 1\ndata: {\"n\": 1}\n\nevent: tick\nid: 2\ndata: {\"n\":\ndata: 2}\n\n")
+    }))
+    defer ts.Close()
+
+    pp := page_processer.PageProcesserFunc(func(p *page.Page) {
+        n, _ := p.GetJson().Get("n").Int()
+        p.AddField("n", strconv.Itoa(n))
+        p.AddField("event", p.GetMessage().Event)
+    })
+    collect := pipeline.NewCollectPipelinePageItems()
+    limits := request.StreamLimits{MaxMessages: 3, Reconnects: 1, ReconnectDelay: 10 * time.Millisecond}
+    spider.NewSpider(pp, "sse").CloseStrace().SetObeyRobots(false).AddPipeline(collect).
+        AddRequest(request.NewRequest(ts.URL, "sse").SetStreamLimits(limits)).Run()
+    var got []string
+    for _, items := range collect.GetCollected() {
+        got = append(got, items.GetAll()["n"]+" "+items.GetAll()["event"])
+    }
+    if !reflect.DeepEqual(got, []string{"1 message", "2 tick", "3 message"}) {
+        t.Errorf("each message should be processed and output: %v", got)
+    }
+    if !reflect.DeepEqual(lastIds, []string{"", "2"}) {
+        t.Errorf("broken stream should be reconnected from the last event: %v", lastIds)
+    }
+
+    // the processer receives messages until the stream is ended by the server
+    var ids []string
+    mp := page_processer.MessagePageProcesserFunc(func(p *page.Page, messages <-chan page.Message) {
+        for msg := range messages {
+            ids = append(ids, msg.Id)
+            p.AddField("id", msg.Id)
+            p.Emit()
+        }
+    })
+    collect = pipeline.NewCollectPipelinePageItems()
+    spider.NewSpider(mp, "sse").CloseStrace().SetObeyRobots(false).AddPipeline(collect).AddUrl(ts.URL, "sse").Run()
+    if !reflect.DeepEqual(ids, []string{"1", "2"}) || len(collect.GetCollected()) != 2 {
+        t.Errorf("items should be emitted for each message: %v %d", ids, len(collect.GetCollected()))
+    }
+}
+
+func TestCircuitBreaker(t *testing.T) {
+    var hits int32
+    a := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        // the host is down for its first 3 requests
+        if atomic.AddInt32(&hits, 1) <= 3 {
+            w.WriteHeader(http.StatusInternalServerError)
+        }
+        w.Write([]byte("ok"))
+    }))
+    defer a.Close()
+    b := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Write([]byte("ok"))
+    }))
+    defer b.Close()
+
+    pp := &testPageProcesser{}
+    var locker sync.Mutex
+    var failed []error
+    sp := spider.NewSpider(pp, "circuit").CloseStrace().SetObeyRobots(false).SetThreadnum(1).SetRetryTimes(5).
+        SetRetryStatusCodes([]int{500}).SetCircuitBreaker(2, 50*time.Millisecond).
+        SetFailedRequestHandler(func(req *request.Request, err error) {
+            locker.Lock()
+            failed = append(failed, err)
+            locker.Unlock()
+        })
+    for i := 0; i < 6; i++ {
+        sp.AddUrl(a.URL+"/"+strconv.Itoa(i), "text")
+    }
+    sp.AddUrl(b.URL+"/", "text")
+    sp.Run()
+
+    // two failures open the circuit, and the first probe fails
+    if n := atomic.LoadInt32(&hits); n > 8 {
+        t.Errorf("requests of open host should not be downloaded: %d", n)
+    }
+    ok := 0
+    for _, p := range pp.pages {
+        if p.GetStatusCode() == http.StatusOK {
+            ok++
+        }
+    }
+    if ok != 5 {
+        t.Errorf("requests should be crawled after a probe succeeds: %d", ok)
+    }
+    for _, err := range failed {
+        if err == spider.ErrCircuitOpen {
+            t.Error("requests put aside should not use up their retries")
+        }
+    }
+    if len(sp.GetOpenCircuits()) != 0 {
+        t.Errorf("circuit should be closed: %v", sp.GetOpenCircuits())
+    }
+    u, _ := url.Parse(a.URL)
+    if n := sp.GetStats().HostErrors[u.Host]["http_5xx"]; n != 3 {
+        t.Errorf("errors of host should be classified: %v", sp.GetStats().HostErrors)
+    }
+}
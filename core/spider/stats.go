@@ -0,0 +1,341 @@
+package spider
+
+import (
+    "encoding/json"
+    "fmt"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// The maxTopErrors is how many error reasons are in TopErrors of Stats.
+const maxTopErrors = 10
+
+// The Stats is statistics of a crawl, from Run starting to Run returning. When the crawl is resumed from
+// checkpoint, statistics saved in the checkpoint are added, so the Stats covers the whole crawl: StartTime is
+// start of the first run, Seconds is total time of runs and Runs counts them.
+type Stats struct {
+    Taskname   string    `json:"taskname"`
+    StartTime  time.Time `json:"start_time"`
+    FinishTime time.Time `json:"finish_time"`
+    Seconds    float64   `json:"seconds"`
+    Runs       int       `json:"runs"`
+    // The Complete is whether the crawl is complete, or it is stopped with requests left.
+    Complete bool `json:"complete"`
+
+    // The Pages counts downloads, including retries. The PagesFailed are network errors, http error status and
+    // pages rejected by responce validator.
+    Pages       uint64         `json:"pages"`
+    PagesOk     uint64         `json:"pages_ok"`
+    PagesFailed uint64         `json:"pages_failed"`
+    Bytes       uint64         `json:"bytes"`
+    StatusCodes map[int]uint64 `json:"status_codes"`
+
+    // The Errors counts failed pages by reason, like "http_4xx: 404 Not Found" or "connect: connection refused",
+    // and TopErrors are the most frequent of them.
+    Errors    map[string]uint64 `json:"errors"`
+    TopErrors []ErrorCount      `json:"top_errors"`
+
+    // The HostErrors counts failed pages of each host by type, like "dns", "connect", "tls", "timeout",
+    // "http_4xx" or "http_5xx".
+    HostErrors map[string]map[string]uint64 `json:"host_errors"`
+
+    // The Items counts PageItems processed by each pipeline, by its type like "*pipeline.PipelineFile".
+    Items map[string]uint64 `json:"items"`
+
+    // The PipelineErrors counts panics of each pipeline, by its type like Items.
+    PipelineErrors map[string]uint64 `json:"pipeline_errors"`
+
+    // The InvalidItems counts PageItems rejected by item validator, which are not passed to pipelines.
+    InvalidItems uint64 `json:"invalid_items"`
+
+    // The DuplicateItems counts PageItems of identity emitted before, which are not passed to pipelines.
+    DuplicateItems uint64 `json:"duplicate_items"`
+
+    // The Changes counts pages by change found by Incremental, "added", "modified" or "unchanged".
+    Changes map[string]uint64 `json:"changes"`
+
+    // The Dropped counts requests dropped before they are pushed to Scheduler by reason, and the Duplicates
+    // counts pages of duplicate content.
+    Dropped    map[string]uint64 `json:"dropped"`
+    Duplicates uint64            `json:"duplicates"`
+}
+
+// The ErrorCount is count of an error reason.
+type ErrorCount struct {
+    Reason string `json:"reason"`
+    Count  uint64 `json:"count"`
+}
+
+// The statsCollector aggregates Stats of a run.
+type statsCollector struct {
+    locker sync.Mutex
+    stats  Stats
+    // The start is start of this run, and previous is total seconds of runs resumed from checkpoint.
+    start    time.Time
+    previous float64
+}
+
+func newStatsCollector(taskname string) *statsCollector {
+    return &statsCollector{stats: Stats{
+        Taskname:    taskname,
+        StatusCodes: make(map[int]uint64),
+        Errors:      make(map[string]uint64),
+        Items:       make(map[string]uint64),
+        Dropped:     make(map[string]uint64),
+        Changes:     make(map[string]uint64),
+
+        HostErrors:     make(map[string]map[string]uint64),
+        PipelineErrors: make(map[string]uint64),
+    }}
+}
+
+// The begin is called when Run starts.
+func (this *statsCollector) begin() {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.start = time.Now()
+    if this.stats.StartTime.IsZero() {
+        this.stats.StartTime = this.start
+    }
+    this.stats.Runs++
+}
+
+// The finish is called when Run returns, and returns the final Stats.
+func (this *statsCollector) finish(complete bool) Stats {
+    this.locker.Lock()
+    this.stats.FinishTime = time.Now()
+    this.stats.Complete = complete
+    this.locker.Unlock()
+    return this.snapshot()
+}
+
+// The snapshot returns copy of Stats so far.
+func (this *statsCollector) snapshot() Stats {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    s := this.stats
+    s.Seconds = this.previous
+    if !this.start.IsZero() {
+        end := time.Now()
+        if !s.FinishTime.IsZero() {
+            end = s.FinishTime
+        }
+        s.Seconds += end.Sub(this.start).Seconds()
+    }
+    s.StatusCodes = make(map[int]uint64, len(this.stats.StatusCodes))
+    for code, n := range this.stats.StatusCodes {
+        s.StatusCodes[code] = n
+    }
+    s.Errors = copyCounts(this.stats.Errors)
+    s.Items = copyCounts(this.stats.Items)
+    s.Dropped = copyCounts(this.stats.Dropped)
+    s.Changes = copyCounts(this.stats.Changes)
+    s.PipelineErrors = copyCounts(this.stats.PipelineErrors)
+    s.HostErrors = make(map[string]map[string]uint64, len(this.stats.HostErrors))
+    for host, counts := range this.stats.HostErrors {
+        s.HostErrors[host] = copyCounts(counts)
+    }
+    s.TopErrors = nil
+    for reason, n := range s.Errors {
+        s.TopErrors = append(s.TopErrors, ErrorCount{Reason: reason, Count: n})
+    }
+    sort.Slice(s.TopErrors, func(i, j int) bool {
+        if s.TopErrors[i].Count != s.TopErrors[j].Count {
+            return s.TopErrors[i].Count > s.TopErrors[j].Count
+        }
+        return s.TopErrors[i].Reason < s.TopErrors[j].Reason
+    })
+    if len(s.TopErrors) > maxTopErrors {
+        s.TopErrors = s.TopErrors[:maxTopErrors]
+    }
+    return s
+}
+
+// The resume adds Stats saved in checkpoint by previous runs.
+func (this *statsCollector) resume(saved *Stats) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    if !saved.StartTime.IsZero() && (this.stats.StartTime.IsZero() || saved.StartTime.Before(this.stats.StartTime)) {
+        this.stats.StartTime = saved.StartTime
+    }
+    this.previous += saved.Seconds
+    this.stats.Runs += saved.Runs
+    this.stats.Pages += saved.Pages
+    this.stats.PagesOk += saved.PagesOk
+    this.stats.PagesFailed += saved.PagesFailed
+    this.stats.Bytes += saved.Bytes
+    this.stats.Duplicates += saved.Duplicates
+    this.stats.InvalidItems += saved.InvalidItems
+    this.stats.DuplicateItems += saved.DuplicateItems
+    for code, n := range saved.StatusCodes {
+        this.stats.StatusCodes[code] += n
+    }
+    addCounts(this.stats.Errors, saved.Errors)
+    addCounts(this.stats.Items, saved.Items)
+    addCounts(this.stats.Dropped, saved.Dropped)
+    addCounts(this.stats.Changes, saved.Changes)
+    addCounts(this.stats.PipelineErrors, saved.PipelineErrors)
+    for host, counts := range saved.HostErrors {
+        this.hostErrors(host)
+        addCounts(this.stats.HostErrors[host], counts)
+    }
+}
+
+// The hostErrors returns error counts of the host, adding them if they are new. It is called with locker held.
+func (this *statsCollector) hostErrors(host string) map[string]uint64 {
+    counts, ok := this.stats.HostErrors[host]
+    if !ok {
+        counts = make(map[string]uint64)
+        this.stats.HostErrors[host] = counts
+    }
+    return counts
+}
+
+// The download records the page downloaded.
+func (this *statsCollector) download(p *page.Page) {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.stats.Pages++
+    this.stats.Bytes += uint64(len(p.GetBodyStr())) + uint64(p.GetFileSize())
+    this.stats.StatusCodes[p.GetStatusCode()]++
+    if !p.IsSucc() || p.GetStatusCode() >= 400 {
+        this.stats.PagesFailed++
+        this.stats.Errors[errorReason(p)]++
+        this.hostErrors(requestHost(p.GetRequest()))[errorType(p)]++
+    } else {
+        this.stats.PagesOk++
+    }
+}
+
+// The reject records a page rejected by responce validator, which was recorded as ok by download.
+func (this *statsCollector) reject() {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.stats.PagesOk--
+    this.stats.PagesFailed++
+    this.stats.Errors["rejected"]++
+}
+
+func (this *statsCollector) drop(reason string) {
+    this.locker.Lock()
+    this.stats.Dropped[reason]++
+    this.locker.Unlock()
+}
+
+func (this *statsCollector) duplicate() {
+    this.locker.Lock()
+    this.stats.Duplicates++
+    this.locker.Unlock()
+}
+
+func (this *statsCollector) invalidItem() {
+    this.locker.Lock()
+    this.stats.InvalidItems++
+    this.locker.Unlock()
+}
+
+func (this *statsCollector) change(change string) {
+    this.locker.Lock()
+    this.stats.Changes[change]++
+    this.locker.Unlock()
+}
+
+func (this *statsCollector) duplicateItem() {
+    this.locker.Lock()
+    this.stats.DuplicateItems++
+    this.locker.Unlock()
+}
+
+// The item records PageItems processed by the pipeline of the name.
+func (this *statsCollector) item(name string) {
+    this.locker.Lock()
+    this.stats.Items[name]++
+    this.locker.Unlock()
+}
+
+// The pipelineError records a panic of the pipeline of the name.
+func (this *statsCollector) pipelineError(name string) {
+    this.locker.Lock()
+    this.stats.PipelineErrors[name]++
+    this.locker.Unlock()
+}
+
+// The pipelineName returns name of the pipeline in Stats, which is its type.
+func pipelineName(pip pipeline.Pipeline) string {
+    return fmt.Sprintf("%T", pip)
+}
+
+// The errorReason returns type of the failed page with its status text or the last part of its error message,
+// which has no url, so failed pages of the same reason are counted together.
+func errorReason(p *page.Page) string {
+    t := errorType(p)
+    if code := p.GetStatusCode(); code >= 400 {
+        return t + ": " + strconv.Itoa(code) + " " + http.StatusText(code)
+    }
+    msg := p.Errormsg()
+    if i := strings.LastIndex(msg, ": "); i >= 0 {
+        msg = msg[i+2:]
+    }
+    if msg == "" {
+        return t
+    }
+    return t + ": " + msg
+}
+
+func copyCounts(m map[string]uint64) map[string]uint64 {
+    c := make(map[string]uint64, len(m))
+    addCounts(c, m)
+    return c
+}
+
+func addCounts(dst, src map[string]uint64) {
+    for key, n := range src {
+        dst[key] += n
+    }
+}
+
+// The GetStats returns Stats of the crawl so far, or of the last crawl after Run returns.
+func (this *Spider) GetStats() Stats {
+    this.runLocker.Lock()
+    stats := this.stats
+    if this.runDone == nil && this.lastStats != nil {
+        stats = this.lastStats
+    }
+    this.runLocker.Unlock()
+    return stats.snapshot()
+}
+
+// The SetStatsReport writes Stats of the crawl to the file as json when Run returns, for auditing crawls.
+func (this *Spider) SetStatsReport(path string) *Spider {
+    this.statsReportPath = path
+    return this
+}
+
+// The reportStats writes the final Stats to the report file, and starts Stats of the next run.
+func (this *Spider) reportStats(complete bool) {
+    stats := this.stats.finish(complete)
+    this.runLocker.Lock()
+    this.lastStats, this.stats = this.stats, newStatsCollector(this.taskname)
+    this.runLocker.Unlock()
+    if this.statsReportPath == "" {
+        return
+    }
+    content, err := json.MarshalIndent(stats, "", "  ")
+    if err == nil {
+        if err = os.MkdirAll(filepath.Dir(this.statsReportPath), 0755); err == nil {
+            err = ioutil.WriteFile(this.statsReportPath, content, 0644)
+        }
+    }
+    if err != nil {
+        logger.Error("stats report is not written : " + err.Error())
+    }
+}
@@ -0,0 +1,64 @@
+package spider
+
+import (
+    "context"
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/common/trace"
+    "github.com/hu17889/go_spider/core/downloader"
+    "time"
+)
+
+// The SetTracer records a trace of each request crawled by the Tracer, which is exported to a backend like
+// Jaeger by trace.OTLPExporter. The root span "crawl" lasts from the request pushed to Scheduler to its
+// targets and items handled, with children "queue wait", "download" of each attempt, "process" and
+// "pipeline <type>" of each pipeline; "download" has children "dns", "connect", "tls handshake", "ttfb",
+// "read body" and "parse" of HttpDownloader. Spans are passed by contexts of requests, so pipelines of
+// pipeline.ContextPipeline and downloaders can add their own by trace.Start. Spans left are exported when
+// Run returns. Nil stops tracing.
+func (this *Spider) SetTracer(t *trace.Tracer) *Spider {
+    this.tracer = t
+    return this
+}
+
+func (this *Spider) GetTracer() *trace.Tracer {
+    return this.tracer
+}
+
+// The traceRequest starts span "crawl" of the request since it is queued, with its child "queue wait".
+func (this *Spider) traceRequest(ctx context.Context, req *request.Request) (context.Context, *trace.Span) {
+    now := time.Now()
+    start := req.GetQueuedAt()
+    if start.IsZero() || start.After(now) {
+        start = now
+    }
+    ctx, span := this.tracer.StartAt(ctx, "crawl", start)
+    if span == nil {
+        return ctx, nil
+    }
+    span.SetAttribute("url.full", req.GetUrl()).SetAttribute("http.request.method", req.GetMethod()).
+        SetAttribute("crawl.depth", req.GetDepth())
+    if req.GetTag() != "" {
+        span.SetAttribute("crawl.tag", req.GetTag())
+    }
+    if start.Before(now) {
+        span.StartChildAt("queue wait", start).Finish()
+    }
+    return ctx, span
+}
+
+// The traceDownload sets outcome of the page to the download span and ends it.
+func traceDownload(span *trace.Span, req *request.Request, p *page.Page) {
+    if span == nil {
+        return
+    }
+    span.SetAttribute("http.response.status_code", p.GetStatusCode()).
+        SetAttribute("http.response.body.size", len(p.GetBodyStr())).SetAttribute("crawl.retries", req.GetRetries())
+    if p.GetProxyHost() != "" {
+        span.SetAttribute("crawl.proxy", downloader.RedactProxy(p.GetProxyHost()))
+    }
+    if !p.IsSucc() {
+        span.SetError(p.Errormsg())
+    }
+    span.Finish()
+}
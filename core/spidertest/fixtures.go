@@ -0,0 +1,261 @@
+// Package spidertest tests PageProcessers by crawls replaying recorded responces, without hitting live sites.
+// Fixtures of responces are recorded by Recorder from a real crawl or added by hand, and replayed by
+// NewFakeDownloader. Harness crawls them synchronously by Spider and asserts items and requests of the crawl.
+package spidertest
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "github.com/hu17889/go_spider/core/common/util"
+    "github.com/hu17889/go_spider/core/downloader"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "sort"
+    "strconv"
+    "sync"
+    "unicode/utf8"
+)
+
+// Fixture is a recorded responce of a request.
+type Fixture struct {
+    Method string `json:"method"`
+    Url    string `json:"url"`
+
+    // The RequestBody is matched with body of the request if it is not empty, like postdata of a search form.
+    RequestBody string `json:"request_body,omitempty"`
+
+    Status int         `json:"status"`
+    Header http.Header `json:"header,omitempty"`
+
+    // The Body is the responce body, encoded by base64 if Encoding is "base64", like gzip or image bodies.
+    Body     string `json:"body"`
+    Encoding string `json:"encoding,omitempty"`
+}
+
+// The body returns the decoded responce body.
+func (this *Fixture) body() ([]byte, error) {
+    if this.Encoding == "base64" {
+        return base64.StdEncoding.DecodeString(this.Body)
+    }
+    return []byte(this.Body), nil
+}
+
+// The setBody sets the responce body, encoded by base64 unless it is utf-8 text.
+func (this *Fixture) setBody(body []byte) {
+    if utf8.Valid(body) {
+        this.Body, this.Encoding = string(body), ""
+    } else {
+        this.Body, this.Encoding = base64.StdEncoding.EncodeToString(body), "base64"
+    }
+}
+
+// Fixtures is an http.RoundTripper replaying responces of fixtures of requests by method and normalized url,
+// see util.NormalizeUrl. Requests without fixture fail, and Missing returns them.
+type Fixtures struct {
+    locker   sync.Mutex
+    fixtures []*Fixture
+    missing  []string
+}
+
+func NewFixtures() *Fixtures {
+    return &Fixtures{}
+}
+
+// Add adds the fixture. Fixtures added later of the same request replace those before.
+func (this *Fixtures) Add(f Fixture) *Fixtures {
+    if f.Method == "" {
+        f.Method = "GET"
+    }
+    if f.Status == 0 {
+        f.Status = http.StatusOK
+    }
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    this.fixtures = append(this.fixtures, &f)
+    return this
+}
+
+// AddBody adds fixture of GET request of the url, whose responce is the body of status 200 and the
+// Content-Type, like "text/html; charset=utf-8".
+func (this *Fixtures) AddBody(url string, contentType string, body string) *Fixtures {
+    return this.Add(Fixture{Url: url, Header: http.Header{"Content-Type": {contentType}}, Body: body})
+}
+
+// AddFile adds fixture of GET request of the url, whose responce is content of the file, like html saved
+// from a browser in testdata directory.
+func (this *Fixtures) AddFile(url string, contentType string, path string) error {
+    content, err := ioutil.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    f := Fixture{Url: url, Header: http.Header{"Content-Type": {contentType}}}
+    f.setBody(content)
+    this.Add(f)
+    return nil
+}
+
+// Len returns number of the fixtures.
+func (this *Fixtures) Len() int {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return len(this.fixtures)
+}
+
+// Missing returns requests without fixture, like "GET http://example.com/next", in order.
+func (this *Fixtures) Missing() []string {
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    return append([]string(nil), this.missing...)
+}
+
+// The find returns the last fixture of the request.
+func (this *Fixtures) find(method string, url string, body string) *Fixture {
+    url = util.NormalizeUrl(url)
+    this.locker.Lock()
+    defer this.locker.Unlock()
+    for i := len(this.fixtures) - 1; i >= 0; i-- {
+        f := this.fixtures[i]
+        if f.Method == method && util.NormalizeUrl(f.Url) == url && (f.RequestBody == "" || f.RequestBody == body) {
+            return f
+        }
+    }
+    this.missing = append(this.missing, method+" "+url)
+    return nil
+}
+
+// RoundTrip returns responce of fixture of the request, or error if the request has no fixture.
+func (this *Fixtures) RoundTrip(httpreq *http.Request) (*http.Response, error) {
+    var reqBody []byte
+    if httpreq.Body != nil {
+        var err error
+        reqBody, err = ioutil.ReadAll(httpreq.Body)
+        httpreq.Body.Close()
+        if err != nil {
+            return nil, err
+        }
+    }
+    f := this.find(httpreq.Method, httpreq.URL.String(), string(reqBody))
+    if f == nil {
+        return nil, errors.New("fixture is not found : " + httpreq.Method + " " + httpreq.URL.String())
+    }
+    body, err := f.body()
+    if err != nil {
+        return nil, err
+    }
+    header := make(http.Header, len(f.Header))
+    for k, v := range f.Header {
+        header[k] = append([]string(nil), v...)
+    }
+    return &http.Response{
+        Status:        strconv.Itoa(f.Status) + " " + http.StatusText(f.Status),
+        StatusCode:    f.Status,
+        Proto:         "HTTP/1.1",
+        ProtoMajor:    1,
+        ProtoMinor:    1,
+        Header:        header,
+        Body:          ioutil.NopCloser(bytes.NewReader(body)),
+        ContentLength: int64(len(body)),
+        Request:       httpreq,
+    }, nil
+}
+
+// Save writes the fixtures to the file, one json line for each fixture, sorted by url so recorded files
+// are stable in version control.
+func (this *Fixtures) Save(path string) error {
+    this.locker.Lock()
+    fixtures := append([]*Fixture(nil), this.fixtures...)
+    this.locker.Unlock()
+    sort.SliceStable(fixtures, func(i, j int) bool {
+        return fixtures[i].Url < fixtures[j].Url
+    })
+    var b bytes.Buffer
+    for _, f := range fixtures {
+        line, err := json.Marshal(f)
+        if err != nil {
+            return err
+        }
+        b.Write(line)
+        b.WriteByte('\n')
+    }
+    return ioutil.WriteFile(path, b.Bytes(), 0644)
+}
+
+// LoadFixtures reads fixtures saved by Fixtures.Save.
+func LoadFixtures(path string) (*Fixtures, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+    fixtures := NewFixtures()
+    r := bufio.NewReader(file)
+    for n := 1; ; n++ {
+        line, err := r.ReadBytes('\n')
+        if len(bytes.TrimSpace(line)) > 0 {
+            var f Fixture
+            if jerr := json.Unmarshal(line, &f); jerr != nil {
+                return nil, errors.New("fixture of line " + strconv.Itoa(n) + " is broken : " + jerr.Error())
+            }
+            fixtures.Add(f)
+        }
+        if err == io.EOF {
+            return fixtures, nil
+        } else if err != nil {
+            return nil, err
+        }
+    }
+}
+
+// Recorder is an http.RoundTripper recording responces of requests sent by another RoundTripper as
+// Fixtures, so a crawl of live sites is replayed later. Use it by HttpDownloader.SetRoundTripper, and save
+// Fixtures after the crawl.
+type Recorder struct {
+    rt       http.RoundTripper
+    fixtures *Fixtures
+}
+
+// NewRecorder returns Recorder sending requests by rt, or by a transport of downloader.NewTransport if rt
+// is nil.
+func NewRecorder(rt http.RoundTripper) *Recorder {
+    if rt == nil {
+        rt = downloader.NewTransport()
+    }
+    return &Recorder{rt: rt, fixtures: NewFixtures()}
+}
+
+// Fixtures returns the recorded fixtures.
+func (this *Recorder) Fixtures() *Fixtures {
+    return this.fixtures
+}
+
+// RoundTrip sends the request and records its responce, which is read to the end.
+func (this *Recorder) RoundTrip(httpreq *http.Request) (*http.Response, error) {
+    f := Fixture{Method: httpreq.Method, Url: httpreq.URL.String()}
+    if httpreq.Body != nil && httpreq.GetBody != nil {
+        if body, err := httpreq.GetBody(); err == nil {
+            reqBody, _ := ioutil.ReadAll(body)
+            body.Close()
+            f.RequestBody = string(reqBody)
+        }
+    }
+    resp, err := this.rt.RoundTrip(httpreq)
+    if err != nil {
+        return nil, err
+    }
+    body, err := ioutil.ReadAll(resp.Body)
+    resp.Body.Close()
+    if err != nil {
+        return nil, err
+    }
+    f.Status = resp.StatusCode
+    f.Header = resp.Header.Clone()
+    f.setBody(body)
+    this.fixtures.Add(f)
+    resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+    return resp, nil
+}
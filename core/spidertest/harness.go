@@ -0,0 +1,169 @@
+package spidertest
+
+import (
+    "github.com/hu17889/go_spider/core/common/page_items"
+    "github.com/hu17889/go_spider/core/common/request"
+    "github.com/hu17889/go_spider/core/downloader"
+    "github.com/hu17889/go_spider/core/page_processer"
+    "github.com/hu17889/go_spider/core/pipeline"
+    "github.com/hu17889/go_spider/core/spider"
+    "strings"
+    "testing"
+)
+
+// NewFakeDownloader returns HttpDownloader replaying the fixtures instead of sending requests, so pages are
+// parsed like downloads of live sites: charset, json and redirects of the fixtures work as usual.
+func NewFakeDownloader(fixtures *Fixtures) *downloader.HttpDownloader {
+    return downloader.NewHttpDownloader().SetCookieJar(downloader.NewCookieJar()).SetRoundTripper(fixtures)
+}
+
+// Harness crawls by Spider of the PageProcesser with the fake downloader of Fixtures, synchronously in
+// the goroutine of Crawl, and records items output and requests added by the pages, for assertions.
+type Harness struct {
+    t        testing.TB
+    spider   *spider.Spider
+    fixtures *Fixtures
+    items    *pipeline.CollectPipelinePageItems
+    graph    *spider.CrawlGraph
+}
+
+// NewHarness returns Harness whose assertions fail the test t.
+func NewHarness(t testing.TB, processer page_processer.PageProcesser, fixtures *Fixtures) *Harness {
+    items := pipeline.NewCollectPipelinePageItems()
+    graph := spider.NewCrawlGraph()
+    s := spider.NewSpider(processer, "spidertest").
+        SetDownloader(NewFakeDownloader(fixtures)).
+        SetSynchronous(true).
+        SetCrawlGraph(graph).
+        AddPipeline(items)
+    return &Harness{t: t, spider: s, fixtures: fixtures, items: items, graph: graph}
+}
+
+// Spider returns Spider of the crawl for other settings, like SetMaxDepth.
+func (this *Harness) Spider() *spider.Spider {
+    return this.spider
+}
+
+// Crawl crawls the requests and the requests added by their pages until they are all done.
+func (this *Harness) Crawl(reqs ...*request.Request) *Harness {
+    for _, req := range reqs {
+        this.spider.AddRequest(req)
+    }
+    this.spider.Run()
+    return this
+}
+
+// CrawlUrls crawls requests of the urls of the responce type, like "html".
+func (this *Harness) CrawlUrls(respType string, urls ...string) *Harness {
+    reqs := make([]*request.Request, 0, len(urls))
+    for _, u := range urls {
+        reqs = append(reqs, request.NewRequest(u, respType))
+    }
+    return this.Crawl(reqs...)
+}
+
+// Items returns items output by the pages in order.
+func (this *Harness) Items() []*page_items.PageItems {
+    return this.items.GetCollected()
+}
+
+// Requests returns urls of requests added by the pages in order, including those dropped like by
+// Spider.SetMaxDepth.
+func (this *Harness) Requests() []string {
+    var urls []string
+    for _, e := range this.graph.Edges() {
+        urls = append(urls, e.To)
+    }
+    return urls
+}
+
+// Graph returns CrawlGraph of the crawl, which tells which page added which request and whether it was
+// dropped.
+func (this *Harness) Graph() *spider.CrawlGraph {
+    return this.graph
+}
+
+// AssertItemCount asserts that n items are output.
+func (this *Harness) AssertItemCount(n int) *Harness {
+    this.t.Helper()
+    if got := len(this.Items()); got != n {
+        this.t.Errorf("spidertest: %d items are output, want %d", got, n)
+    }
+    return this
+}
+
+// AssertItem asserts that an item has all the fields of want, like {"title": "Go", "price": "12.5"}.
+func (this *Harness) AssertItem(want map[string]string) *Harness {
+    this.t.Helper()
+    for _, items := range this.Items() {
+        if hasFields(items, want) {
+            return this
+        }
+    }
+    this.t.Errorf("spidertest: no item has fields %v in %d items", want, len(this.Items()))
+    return this
+}
+
+// AssertNoItem asserts that no item has all the fields of want.
+func (this *Harness) AssertNoItem(want map[string]string) *Harness {
+    this.t.Helper()
+    for _, items := range this.Items() {
+        if hasFields(items, want) {
+            this.t.Errorf("spidertest: item %v has fields %v", items.GetAll(), want)
+            return this
+        }
+    }
+    return this
+}
+
+// The hasFields returns whether the items has all the fields.
+func hasFields(items *page_items.PageItems, fields map[string]string) bool {
+    for k, v := range fields {
+        if got, ok := items.GetItem(k); !ok || got != v {
+            return false
+        }
+    }
+    return true
+}
+
+// AssertRequestCount asserts that n requests are added by the pages.
+func (this *Harness) AssertRequestCount(n int) *Harness {
+    this.t.Helper()
+    if got := len(this.Requests()); got != n {
+        this.t.Errorf("spidertest: %d requests are added, want %d : %s", got, n, strings.Join(this.Requests(), " "))
+    }
+    return this
+}
+
+// AssertRequested asserts that request of the url is added by a page.
+func (this *Harness) AssertRequested(url string) *Harness {
+    this.t.Helper()
+    for _, u := range this.Requests() {
+        if u == url {
+            return this
+        }
+    }
+    this.t.Errorf("spidertest: %s is not requested", url)
+    return this
+}
+
+// AssertNotRequested asserts that request of the url is not added by any page.
+func (this *Harness) AssertNotRequested(url string) *Harness {
+    this.t.Helper()
+    for _, u := range this.Requests() {
+        if u == url {
+            this.t.Errorf("spidertest: %s is requested", url)
+            return this
+        }
+    }
+    return this
+}
+
+// AssertNoMissing asserts that every request downloaded has its fixture.
+func (this *Harness) AssertNoMissing() *Harness {
+    this.t.Helper()
+    if missing := this.fixtures.Missing(); len(missing) > 0 {
+        this.t.Errorf("spidertest: fixtures are not found : %s", strings.Join(missing, ", "))
+    }
+    return this
+}
@@ -0,0 +1,101 @@
+package spidertest_test
+
+import (
+    "github.com/hu17889/go_spider/core/common/page"
+    "github.com/hu17889/go_spider/core/downloader"
+    "github.com/hu17889/go_spider/core/page_processer"
+    "github.com/hu17889/go_spider/core/spidertest"
+    "net/http"
+    "net/http/httptest"
+    "path/filepath"
+    "testing"
+)
+
+// The listProcesser follows links of list pages and outputs titles of the other pages.
+var listProcesser = page_processer.PageProcesserFunc(func(p *page.Page) {
+    if !p.IsSucc() {
+        p.SetSkip(true)
+        return
+    }
+    if links := p.GetLinks(); len(links) > 0 {
+        for _, link := range links {
+            p.AddTargetRequest(link, "html")
+        }
+        p.SetSkip(true)
+        return
+    }
+    p.AddField("url", p.GetRequest().GetUrl())
+    p.AddField("title", p.GetHtmlParser().Find("title").Text())
+})
+
+func TestHarness(t *testing.T) {
+    fixtures := spidertest.NewFixtures().
+        AddBody("http://example.com/list", "text/html", `<a href="http://example.com/a">a</a><a href="http://example.com/b">b</a>`).
+        AddBody("http://example.com/a", "text/html", `<title>A</title>`).
+        Add(spidertest.Fixture{Url: "http://example.com/b", Status: http.StatusMovedPermanently,
+            Header: http.Header{"Location": {"/c"}}}).
+        AddBody("http://example.com/c", "text/html; charset=gbk", "<title>\xd6\xd0\xce\xc4</title>")
+
+    h := spidertest.NewHarness(t, listProcesser, fixtures).CrawlUrls("html", "http://example.com/list")
+    h.AssertItemCount(2).
+        AssertItem(map[string]string{"url": "http://example.com/a", "title": "A"}).
+        AssertItem(map[string]string{"url": "http://example.com/b", "title": "中文"}).
+        AssertNoItem(map[string]string{"title": "list"}).
+        AssertRequestCount(2).
+        AssertRequested("http://example.com/a").
+        AssertNotRequested("http://example.com/c").
+        AssertNoMissing()
+    // synchronous crawls are in order
+    if items := h.Items(); len(items) == 2 {
+        if u, _ := items[0].GetItem("url"); u != "http://example.com/a" {
+            t.Errorf("first item is of %s", u)
+        }
+    }
+
+    // a request without fixture fails
+    fixtures = spidertest.NewFixtures().AddBody("http://example.com/list", "text/html", `<a href="/x">x</a>`)
+    h = spidertest.NewHarness(t, listProcesser, fixtures)
+    h.Spider().SetMaxDepth(1)
+    h.CrawlUrls("html", "http://example.com/list").AssertItemCount(0).AssertRequested("http://example.com/x")
+    if missing := fixtures.Missing(); len(missing) == 0 || missing[0] != "GET http://example.com/x" {
+        t.Errorf("missing fixtures are %v", missing)
+    }
+}
+
+func TestRecorder(t *testing.T) {
+    ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Path {
+        case "/":
+            w.Write([]byte(`<a href="/page">page</a>`))
+        case "/page":
+            w.Header().Set("Content-Type", "text/html; charset=utf-8")
+            w.Write([]byte(`<title>Page</title>`))
+        default:
+            http.NotFound(w, r)
+        }
+    }))
+
+    // record a crawl of the live site
+    recorder := spidertest.NewRecorder(nil)
+    h := spidertest.NewHarness(t, listProcesser, spidertest.NewFixtures())
+    h.Spider().SetDownloader(downloader.NewHttpDownloader().SetRoundTripper(recorder))
+    h.CrawlUrls("html", ts.URL+"/").AssertItem(map[string]string{"title": "Page"})
+    ts.Close()
+    path := filepath.Join(t.TempDir(), "fixtures.jsonl")
+    if err := recorder.Fixtures().Save(path); err != nil {
+        t.Fatal(err)
+    }
+
+    // replay it without the site
+    fixtures, err := spidertest.LoadFixtures(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if fixtures.Len() != 2 {
+        t.Errorf("%d fixtures are recorded, want 2", fixtures.Len())
+    }
+    spidertest.NewHarness(t, listProcesser, fixtures).CrawlUrls("html", ts.URL+"/").
+        AssertItemCount(1).
+        AssertItem(map[string]string{"url": ts.URL + "/page", "title": "Page"}).
+        AssertNoMissing()
+}